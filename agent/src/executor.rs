@@ -4,12 +4,16 @@
 //! output, and managing their lifecycle.
 
 use anyhow::{Context, Result};
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
 use std::process::Stdio;
+use std::sync::{Arc, Mutex};
 use tokio::io::{AsyncBufReadExt, BufReader};
-use tokio::process::{Child, Command};
+use std::os::unix::process::ExitStatusExt;
+use std::process::ExitStatus;
+use tokio::process::Command;
 use tokio::sync::mpsc;
-use tracing::{debug, error, info, warn};
+use tracing::{debug, error, info};
 
 /// Output event from a running process.
 #[derive(Debug, Clone)]
@@ -18,12 +22,111 @@ pub enum ProcessOutput {
     Stdout(String),
     /// A line from stderr  
     Stderr(String),
-    /// Process exited with the given code
-    Exit(i32),
+    /// Process exited
+    Exit(ExitInfo),
     /// Error occurred during execution
     Error(String),
 }
 
+/// How a process ended.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ExitInfo {
+    /// Exit code; 128 + the signal number if a signal killed the process,
+    /// as shells report it
+    pub code: i32,
+    /// The signal that killed the process
+    pub signal: Option<i32>,
+    /// The kernel OOM killer killed the process
+    pub oom_killed: bool,
+    /// exec.cancel killed the process
+    pub canceled: bool,
+}
+
+impl ExitInfo {
+    /// Describes status. oom_kills_before is the OOM kill count of the
+    /// sandbox's cgroup when the process started: a SIGKILL that comes with
+    /// a new OOM kill is taken to be the OOM killer's.
+    fn from_status(status: ExitStatus, oom_kills_before: Option<u64>) -> Self {
+        match status.signal() {
+            Some(sig) => Self {
+                code: 128 + sig,
+                signal: Some(sig),
+                oom_killed: sig == SIGKILL && oom_kills().zip(oom_kills_before).is_some_and(|(now, before)| now > before),
+                canceled: false,
+            },
+            None => Self {
+                code: status.code().unwrap_or(-1),
+                signal: None,
+                oom_killed: false,
+                canceled: false,
+            },
+        }
+    }
+
+    /// The reason the Control Plane reports for an abnormal exit.
+    pub fn reason(&self) -> Option<&'static str> {
+        if self.oom_killed {
+            Some("oom_killed")
+        } else if self.canceled {
+            Some("canceled")
+        } else if self.signal.is_some() {
+            Some("signaled")
+        } else {
+            None
+        }
+    }
+}
+
+/// Errors of exec caused by the request rather than the agent.
+#[derive(Debug, thiserror::Error)]
+pub enum ExecError {
+    #[error("working directory {0} does not exist")]
+    CwdNotFound(String),
+    #[error("unknown user {0}")]
+    UserNotFound(String),
+}
+
+/// Classifies an error of exec for the error event: "command_not_found"
+/// or "permission_denied" when the program cannot be spawned,
+/// "cwd_not_found" or "user_not_found" for a bad request, "internal"
+/// otherwise.
+pub fn error_kind(err: &anyhow::Error) -> &'static str {
+    match err.downcast_ref::<ExecError>() {
+        Some(ExecError::CwdNotFound(_)) => return "cwd_not_found",
+        Some(ExecError::UserNotFound(_)) => return "user_not_found",
+        None => {}
+    }
+    for cause in err.chain() {
+        match cause.downcast_ref::<std::io::Error>().map(|e| e.kind()) {
+            Some(std::io::ErrorKind::NotFound) => return "command_not_found",
+            Some(std::io::ErrorKind::PermissionDenied) => return "permission_denied",
+            _ => {}
+        }
+    }
+    "internal"
+}
+
+/// The signal the OOM killer sends.
+const SIGKILL: i32 = 9;
+
+/// Returns how many processes the OOM killer has killed in the agent's
+/// cgroup, which is the sandbox's, or None if the cgroup cannot be read.
+fn oom_kills() -> Option<u64> {
+    // cgroup v2, then v1
+    ["/sys/fs/cgroup/memory.events", "/sys/fs/cgroup/memory/memory.oom_control"]
+        .iter()
+        .find_map(|path| parse_oom_kills(&std::fs::read_to_string(path).ok()?))
+}
+
+/// Reads the oom_kill counter of a memory.events or memory.oom_control
+/// file.
+fn parse_oom_kills(contents: &str) -> Option<u64> {
+    contents.lines().find_map(|line| match line.split_once(' ') {
+        Some(("oom_kill", n)) => n.trim().parse().ok(),
+        _ => None,
+    })
+}
+
 /// Configuration for process execution.
 #[derive(Debug, Clone)]
 pub struct ExecConfig {
@@ -35,6 +138,8 @@ pub struct ExecConfig {
     pub env: HashMap<String, String>,
     /// Working directory
     pub cwd: String,
+    /// User name or "uid[:gid]" to run as; None runs as the default user
+    pub user: Option<String>,
 }
 
 impl Default for ExecConfig {
@@ -44,32 +149,130 @@ impl Default for ExecConfig {
             args: Vec::new(),
             env: HashMap::new(),
             cwd: "/workspace".to_string(),
+            user: None,
+        }
+    }
+}
+
+/// Environment variable naming the user code runs as when an exec names
+/// none. The Docker driver sets it when a sandbox has a user.
+pub const DEFAULT_USER_VAR: &str = "BOXED_DEFAULT_USER";
+
+/// Returns the working directory for an exec: cwd if absolute, otherwise
+/// cwd under /workspace.
+pub fn work_dir(cwd: Option<&str>) -> String {
+    let base = Path::new("/workspace");
+    match cwd {
+        Some(cwd) if !cwd.is_empty() => base.join(cwd).to_string_lossy().into_owned(),
+        _ => base.to_string_lossy().into_owned(),
+    }
+}
+
+/// A user to run a process as.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct User {
+    /// Login name; empty for a uid without a passwd entry
+    pub name: String,
+    pub uid: u32,
+    pub gid: u32,
+    pub home: String,
+}
+
+impl User {
+    /// Resolves a user name or "uid[:gid]" with /etc/passwd.
+    pub fn lookup(spec: &str) -> Result<Self> {
+        let passwd = std::fs::read_to_string("/etc/passwd").unwrap_or_default();
+        Self::lookup_in(spec, &passwd)
+    }
+
+    /// Resolves spec with the passwd file contents given. A uid needs no
+    /// entry; its gid then defaults to the uid. An explicit gid overrides
+    /// the entry's.
+    fn lookup_in(spec: &str, passwd: &str) -> Result<Self> {
+        let (uid_part, gid_part) = match spec.split_once(':') {
+            Some((u, g)) => (u, Some(g)),
+            None => (spec, None),
+        };
+        let uid: Option<u32> = uid_part.parse().ok();
+        let gid = match gid_part {
+            Some(g) => Some(g.parse::<u32>().with_context(|| format!("invalid gid in user {}", spec))?),
+            None => None,
+        };
+
+        for line in passwd.lines() {
+            let fields: Vec<&str> = line.split(':').collect();
+            if fields.len() < 6 {
+                continue;
+            }
+            let (Ok(u), Ok(g)) = (fields[2].parse::<u32>(), fields[3].parse::<u32>()) else {
+                continue;
+            };
+            if fields[0] == spec || uid == Some(u) {
+                return Ok(Self {
+                    name: fields[0].to_string(),
+                    uid: u,
+                    gid: gid.unwrap_or(g),
+                    home: fields[5].to_string(),
+                });
+            }
+        }
+        match uid {
+            Some(uid) => Ok(Self {
+                name: String::new(),
+                uid,
+                gid: gid.unwrap_or(uid),
+                home: "/".to_string(),
+            }),
+            None => anyhow::bail!(ExecError::UserNotFound(spec.to_string())),
         }
     }
 }
 
 /// Process executor that manages child processes.
+///
+/// Processes belong to a session: REPLs started under a name run side by
+/// side, while execs and the unnamed REPL share the empty session, whose
+/// last process started is the one input and signals go to.
 pub struct Executor {
-    /// Currently running process, if any
-    current: Option<Child>,
-    /// Handle to child's stdin
-    stdin: Option<tokio::process::ChildStdin>,
+    /// Handles to the stdin of the processes started with a piped stdin, by
+    /// session
+    stdin: HashMap<String, tokio::process::ChildStdin>,
+    /// Pid of the last process started in each session, until it exits. It
+    /// leads its own process group, which signals are sent to.
+    running: Arc<Mutex<HashMap<String, u32>>>,
+    /// Pids of the processes cancel killed, until they are reaped
+    canceled: Arc<Mutex<HashSet<u32>>>,
 }
 
 impl Executor {
     /// Create a new Executor.
     pub fn new() -> Self {
-        Self { 
-            current: None,
-            stdin: None,
+        Self {
+            stdin: HashMap::new(),
+            running: Arc::new(Mutex::new(HashMap::new())),
+            canceled: Arc::new(Mutex::new(HashSet::new())),
         }
     }
 
-    /// Execute a command and stream its output.
+    /// Execute a command in session and stream its output. A named session
+    /// runs one process at a time.
     ///
-    /// Returns a channel that receives output events until the process completes.
-    pub async fn exec(&mut self, config: ExecConfig, pipe_stdin: bool) -> Result<mpsc::Receiver<ProcessOutput>> {
-        info!(cmd = %config.cmd, args = ?config.args, "Spawning process");
+    /// Returns a channel that receives output events until the process
+    /// completes, ending with its Exit once all output has been sent.
+    pub async fn exec(&mut self, config: ExecConfig, session: &str, pipe_stdin: bool) -> Result<mpsc::Receiver<ProcessOutput>> {
+        info!(cmd = %config.cmd, args = ?config.args, session, "Spawning process");
+
+        if !session.is_empty() && self.running.lock().unwrap().contains_key(session) {
+            anyhow::bail!("a REPL is already running for session {}", session);
+        }
+
+        let user = match config.user.clone().or_else(|| std::env::var(DEFAULT_USER_VAR).ok()) {
+            Some(spec) if !spec.is_empty() => Some(User::lookup(&spec)?),
+            _ => None,
+        };
+        if !Path::new(&config.cwd).is_dir() {
+            anyhow::bail!(ExecError::CwdNotFound(config.cwd.clone()));
+        }
 
         let (tx, rx) = mpsc::channel(100);
 
@@ -80,7 +283,16 @@ impl Executor {
             .stdin(if pipe_stdin { Stdio::piped() } else { Stdio::null() })
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
-            .kill_on_drop(true);
+            .kill_on_drop(true)
+            .process_group(0)
+            .env_remove(DEFAULT_USER_VAR);
+
+        if let Some(user) = &user {
+            cmd.uid(user.uid).gid(user.gid).env("HOME", &user.home);
+            if !user.name.is_empty() {
+                cmd.env("USER", &user.name).env("LOGNAME", &user.name);
+            }
+        }
 
         // Set environment variables
         for (key, value) in &config.env {
@@ -88,7 +300,14 @@ impl Executor {
         }
 
         // Spawn the process
+        let oom_kills_before = oom_kills();
         let mut child = cmd.spawn().context("Failed to spawn process")?;
+        let pid = child.id();
+        let session = session.to_string();
+        match pid {
+            Some(pid) => self.running.lock().unwrap().insert(session.clone(), pid),
+            None => self.running.lock().unwrap().remove(&session),
+        };
 
         let stdout = child.stdout.take().expect("stdout piped");
         let stderr = child.stderr.take().expect("stderr piped");
@@ -96,14 +315,12 @@ impl Executor {
         // If stdin is piped, take it and store it
         if pipe_stdin {
              let stdin = child.stdin.take().expect("stdin piped");
-             self.stdin = Some(stdin);
+             self.stdin.insert(session.clone(), stdin);
         }
 
-        self.current = Some(child);
-
         // Spawn tasks to read stdout and stderr
         let tx_stdout = tx.clone();
-        tokio::spawn(async move {
+        let stdout_task = tokio::spawn(async move {
             let reader = BufReader::new(stdout);
             let mut lines = reader.lines();
             while let Ok(Some(line)) = lines.next_line().await {
@@ -114,7 +331,7 @@ impl Executor {
         });
 
         let tx_stderr = tx.clone();
-        tokio::spawn(async move {
+        let stderr_task = tokio::spawn(async move {
             let reader = BufReader::new(stderr);
             let mut lines = reader.lines();
             while let Ok(Some(line)) = lines.next_line().await {
@@ -124,13 +341,40 @@ impl Executor {
             }
         });
 
+        // Report the exit after the last of the output
+        let running = self.running.clone();
+        let canceled = self.canceled.clone();
+        tokio::spawn(async move {
+            let _ = tokio::join!(stdout_task, stderr_task);
+            let status = child.wait().await;
+            {
+                let mut running = running.lock().unwrap();
+                if pid.is_some() && running.get(&session) == pid.as_ref() {
+                    running.remove(&session);
+                }
+            }
+            let output = match status {
+                Ok(status) => {
+                    let mut exit = ExitInfo::from_status(status, oom_kills_before);
+                    exit.canceled = pid.is_some_and(|pid| canceled.lock().unwrap().remove(&pid));
+                    debug!(exit_code = exit.code, signal = ?exit.signal, oom_killed = exit.oom_killed, "Process completed");
+                    ProcessOutput::Exit(exit)
+                }
+                Err(e) => {
+                    error!(error = %e, "Failed to wait for process");
+                    ProcessOutput::Error(e.to_string())
+                }
+            };
+            let _ = tx.send(output).await;
+        });
+
         Ok(rx)
     }
 
-    /// Write to the stdin of the current process.
-    pub async fn write_stdin(&mut self, data: &str) -> Result<()> {
+    /// Write to the stdin of the current process of session.
+    pub async fn write_stdin(&mut self, session: &str, data: &str) -> Result<()> {
         use tokio::io::AsyncWriteExt;
-        if let Some(stdin) = self.stdin.as_mut() {
+        if let Some(stdin) = self.stdin.get_mut(session) {
             stdin.write_all(data.as_bytes()).await.context("Failed to write to stdin")?;
             stdin.flush().await.context("Failed to flush stdin")?;
             Ok(())
@@ -139,26 +383,56 @@ impl Executor {
         }
     }
 
-    /// Wait for the current process to complete.
-    pub async fn wait_for_completion(&mut self) -> Option<ProcessOutput> {
-        self.stdin = None; // Close stdin to allow process to exit if waiting for it
-        if let Some(mut child) = self.current.take() {
-            match child.wait().await {
-                Ok(status) => {
-                    let code = status.code().unwrap_or(-1);
-                    debug!(exit_code = code, "Process completed");
-                    Some(ProcessOutput::Exit(code))
-                }
-                Err(e) => {
-                    error!(error = %e, "Failed to wait for process");
-                    Some(ProcessOutput::Error(e.to_string()))
-                }
+    /// Close the stdin of the current process of session, which then reads
+    /// end of file.
+    pub fn close_stdin(&mut self, session: &str) -> Result<()> {
+        match self.stdin.remove(session) {
+            Some(_) => Ok(()),
+            None => anyhow::bail!("Process has no persistent stdin"),
+        }
+    }
+
+    /// Send signal sig to the running process of session and the
+    /// processes it started.
+    pub fn signal(&self, session: &str, sig: i32) -> Result<()> {
+        let Some(pid) = self.running.lock().unwrap().get(session).copied() else {
+            if session.is_empty() {
+                anyhow::bail!("no process is running");
             }
-        } else {
-            None
+            anyhow::bail!("no process is running for session {}", session);
+        };
+        info!(pid, signal = sig, session, "Signaling process");
+        kill_group(pid, sig)
+    }
+
+    /// Kill the running exec and the processes it started, for a client
+    /// that no longer waits for it. Its exit is reported as canceled.
+    pub fn cancel(&self) -> Result<()> {
+        let Some(pid) = self.running.lock().unwrap().get("").copied() else {
+            anyhow::bail!("no exec is running");
+        };
+        info!(pid, "Canceling exec");
+        self.canceled.lock().unwrap().insert(pid);
+        kill_group(pid, SIGKILL)
+    }
+
+    /// Kill every running process and the processes they started, e.g.
+    /// when the connection to the Control Plane closes.
+    pub fn kill_all(&self) {
+        for (session, pid) in self.running.lock().unwrap().iter() {
+            info!(pid, session = %session, "Killing abandoned process");
+            let _ = kill_group(*pid, SIGKILL);
         }
     }
+}
 
+/// Send sig to the process group led by pid.
+fn kill_group(pid: u32, sig: i32) -> Result<()> {
+    // A negative pid signals the process group
+    if unsafe { libc::kill(-(pid as i32), sig) } != 0 {
+        return Err(std::io::Error::last_os_error()).context("Failed to signal process");
+    }
+    Ok(())
 }
 
 impl Default for Executor {
@@ -180,11 +454,195 @@ mod tests {
             ..Default::default()
         };
 
-        let mut rx = executor.exec(config).await.unwrap();
+        let mut rx = executor.exec(config, "", false).await.unwrap();
         
         // Should receive stdout
         if let Some(ProcessOutput::Stdout(line)) = rx.recv().await {
             assert_eq!(line, "hello");
         }
     }
+
+    #[tokio::test]
+    async fn test_exec_exit() {
+        let mut executor = Executor::new();
+        let config = ExecConfig {
+            cmd: "sh".to_string(),
+            args: vec!["-c".to_string(), "echo out; exit 3".to_string()],
+            cwd: "/".to_string(),
+            ..Default::default()
+        };
+
+        let mut rx = executor.exec(config, "", false).await.unwrap();
+        let mut events = Vec::new();
+        while let Some(output) = rx.recv().await {
+            events.push(output);
+        }
+        assert!(matches!(&events[0], ProcessOutput::Stdout(line) if line == "out"));
+        match events.last() {
+            Some(ProcessOutput::Exit(exit)) => assert_eq!(exit, &ExitInfo { code: 3, signal: None, oom_killed: false, canceled: false }),
+            other => panic!("expected an exit, got {:?}", other),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_exec_signaled() {
+        let mut executor = Executor::new();
+        let config = ExecConfig {
+            cmd: "sh".to_string(),
+            args: vec!["-c".to_string(), "kill -TERM $$".to_string()],
+            cwd: "/".to_string(),
+            ..Default::default()
+        };
+
+        let mut rx = executor.exec(config, "", false).await.unwrap();
+        let mut last = None;
+        while let Some(output) = rx.recv().await {
+            last = Some(output);
+        }
+        match last {
+            Some(ProcessOutput::Exit(exit)) => {
+                assert_eq!((exit.code, exit.signal), (143, Some(15)));
+                assert_eq!(exit.reason(), Some("signaled"));
+            }
+            other => panic!("expected an exit, got {:?}", other),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_signal() {
+        let mut executor = Executor::new();
+        assert!(executor.signal("", 2).is_err());
+
+        let config = ExecConfig {
+            cmd: "sh".to_string(),
+            args: vec!["-c".to_string(), "echo started; sleep 30".to_string()],
+            cwd: "/".to_string(),
+            ..Default::default()
+        };
+        let mut rx = executor.exec(config, "", false).await.unwrap();
+        assert!(matches!(rx.recv().await, Some(ProcessOutput::Stdout(line)) if line == "started"));
+
+        // The whole group gets it: the shell waits for sleep otherwise
+        executor.signal("", 2).unwrap();
+        let mut last = None;
+        while let Some(output) = rx.recv().await {
+            last = Some(output);
+        }
+        match last {
+            Some(ProcessOutput::Exit(exit)) => assert_eq!((exit.code, exit.signal), (130, Some(2))),
+            other => panic!("expected an exit, got {:?}", other),
+        }
+        assert!(executor.signal("", 2).is_err());
+    }
+
+    #[tokio::test]
+    async fn test_cancel() {
+        let mut executor = Executor::new();
+        assert!(executor.cancel().is_err());
+
+        let config = ExecConfig {
+            cmd: "sh".to_string(),
+            args: vec!["-c".to_string(), "echo started; sleep 30".to_string()],
+            cwd: "/".to_string(),
+            ..Default::default()
+        };
+        let mut rx = executor.exec(config, "", false).await.unwrap();
+        assert!(matches!(rx.recv().await, Some(ProcessOutput::Stdout(line)) if line == "started"));
+
+        executor.cancel().unwrap();
+        let mut last = None;
+        while let Some(output) = rx.recv().await {
+            last = Some(output);
+        }
+        match last {
+            Some(ProcessOutput::Exit(exit)) => {
+                assert_eq!((exit.code, exit.signal), (137, Some(9)));
+                assert_eq!(exit.reason(), Some("canceled"));
+            }
+            other => panic!("expected an exit, got {:?}", other),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_sessions() {
+        let mut executor = Executor::new();
+        let repl = || ExecConfig {
+            cmd: "sh".to_string(),
+            args: vec!["-c".to_string(), "echo started; sleep 30".to_string()],
+            cwd: "/".to_string(),
+            ..Default::default()
+        };
+        let mut py = executor.exec(repl(), "py", true).await.unwrap();
+        let mut sh = executor.exec(repl(), "sh", true).await.unwrap();
+        assert!(matches!(py.recv().await, Some(ProcessOutput::Stdout(line)) if line == "started"));
+        assert!(matches!(sh.recv().await, Some(ProcessOutput::Stdout(line)) if line == "started"));
+
+        // A session runs one REPL; the others are left alone
+        assert!(executor.exec(repl(), "py", true).await.is_err());
+        assert!(executor.signal("", 2).is_err());
+        executor.signal("py", 2).unwrap();
+        let mut last = None;
+        while let Some(output) = py.recv().await {
+            last = Some(output);
+        }
+        assert!(matches!(last, Some(ProcessOutput::Exit(exit)) if exit.signal == Some(2)));
+        assert!(executor.signal("py", 2).is_err());
+        executor.signal("sh", 9).unwrap();
+    }
+
+    #[test]
+    fn test_parse_oom_kills() {
+        let v2 = "low 0\nhigh 0\nmax 12\noom 2\noom_kill 2\noom_group_kill 0\n";
+        assert_eq!(parse_oom_kills(v2), Some(2));
+        let v1 = "oom_kill_disable 0\nunder_oom 0\noom_kill 5\n";
+        assert_eq!(parse_oom_kills(v1), Some(5));
+        assert_eq!(parse_oom_kills("oom_kill_disable 0\n"), None);
+    }
+
+    #[test]
+    fn test_work_dir() {
+        assert_eq!(work_dir(None), "/workspace");
+        assert_eq!(work_dir(Some("src")), "/workspace/src");
+        assert_eq!(work_dir(Some("/tmp")), "/tmp");
+    }
+
+    #[test]
+    fn test_user_lookup() {
+        let passwd = "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1001::/home/alice:/bin/sh\n";
+
+        let alice = User::lookup_in("alice", passwd).unwrap();
+        assert_eq!((alice.uid, alice.gid, alice.home.as_str()), (1000, 1001, "/home/alice"));
+        assert_eq!(User::lookup_in("1000", passwd).unwrap(), alice);
+        assert_eq!(User::lookup_in("1000:5", passwd).unwrap().gid, 5);
+
+        let anon = User::lookup_in("2000", passwd).unwrap();
+        assert_eq!((anon.name.as_str(), anon.uid, anon.gid), ("", 2000, 2000));
+
+        let err = User::lookup_in("bob", passwd).unwrap_err();
+        assert_eq!(err.to_string(), "unknown user bob");
+        assert_eq!(error_kind(&err), "user_not_found");
+        assert!(User::lookup_in("1000:x", passwd).is_err());
+    }
+
+    #[tokio::test]
+    async fn test_error_kind() {
+        let mut executor = Executor::new();
+        let config = ExecConfig {
+            cmd: "no-such-command".to_string(),
+            ..Default::default()
+        };
+        let err = executor.exec(config, "", false).await.unwrap_err();
+        assert_eq!(error_kind(&err), "command_not_found");
+
+        let config = ExecConfig {
+            cmd: "true".to_string(),
+            cwd: "/no/such/dir".to_string(),
+            ..Default::default()
+        };
+        let err = executor.exec(config, "", false).await.unwrap_err();
+        assert_eq!(err.to_string(), "working directory /no/such/dir does not exist");
+        assert_eq!(error_kind(&err), "cwd_not_found");
+
+        assert_eq!(error_kind(&anyhow::anyhow!("no process is running")), "internal");
+    }
 }
@@ -1,12 +1,15 @@
 //! Filesystem watcher for artifact detection.
 //!
-//! This module monitors the /output directory for new files and streams them
-//! back to the Control Plane as base64-encoded artifacts.
+//! This module monitors the /output directory (or the directories an exec asks
+//! for) for new files and streams them back to the Control Plane, either as
+//! base64-encoded contents or as references the Control Plane can serve.
 
-use anyhow::{Context, Result};
+use crate::rpc::{ArtifactOptions, Delivery};
+use anyhow::{bail, Context, Result};
 use base64::Engine;
 use notify::{Config, Event, EventKind, RecommendedWatcher, RecursiveMode, Watcher};
 use std::path::{Path, PathBuf};
+use std::sync::{Arc, RwLock};
 use tokio::fs;
 use tokio::sync::mpsc;
 use tracing::{debug, error, info, warn};
@@ -14,21 +17,86 @@ use tracing::{debug, error, info, warn};
 /// An artifact detected in the watched directory.
 #[derive(Debug, Clone)]
 pub struct Artifact {
-    /// Path relative to the watched directory
+    /// Path relative to the default watch directory, or absolute for files
+    /// in other watched directories
     pub path: String,
     /// MIME type of the file
     pub mime: String,
-    /// Base64-encoded file contents
-    pub data_base64: String,
+    /// File size in bytes
+    pub size: u64,
+    /// Base64-encoded file contents (inline delivery only)
+    pub data_base64: Option<String>,
 }
 
 /// Maximum file size to stream inline (files larger than this should use upload)
 const MAX_INLINE_SIZE: u64 = 10 * 1024 * 1024; // 10 MB
 
+/// Capture rules applied to file events. Replaced on every exec.
+#[derive(Debug, Clone)]
+struct Policy {
+    roots: Vec<PathBuf>,
+    max_size: u64,
+    mime_types: Vec<String>,
+    delivery: Delivery,
+}
+
+impl Policy {
+    fn new(opts: Option<&ArtifactOptions>, watch_dir: &Path) -> Self {
+        let opts = opts.cloned().unwrap_or_default();
+
+        let roots = if opts.watch_paths.is_empty() {
+            vec![watch_dir.to_path_buf()]
+        } else {
+            opts.watch_paths.iter().map(PathBuf::from).collect()
+        };
+
+        // Inline artifacts travel in a single JSON line, so they stay capped
+        let max_size = match (opts.delivery, opts.max_size) {
+            (Delivery::Inline, Some(n)) => n.min(MAX_INLINE_SIZE),
+            (Delivery::Inline, None) => MAX_INLINE_SIZE,
+            (Delivery::Url, n) => n.unwrap_or(u64::MAX),
+        };
+
+        Self {
+            roots,
+            max_size,
+            mime_types: opts.mime_types,
+            delivery: opts.delivery,
+        }
+    }
+
+    fn covers(&self, path: &Path) -> bool {
+        self.roots.iter().any(|root| path.starts_with(root))
+    }
+
+    fn allows_mime(&self, mime: &str) -> bool {
+        self.mime_types.is_empty() || self.mime_types.iter().any(|p| mime_matches(p, mime))
+    }
+}
+
+/// Match a MIME type against an exact type or a "type/*" wildcard.
+fn mime_matches(pattern: &str, mime: &str) -> bool {
+    if pattern == "*" || pattern == "*/*" {
+        return true;
+    }
+    match pattern.strip_suffix("/*") {
+        Some(kind) => mime
+            .split('/')
+            .next()
+            .map(|t| t.eq_ignore_ascii_case(kind))
+            .unwrap_or(false),
+        None => pattern.eq_ignore_ascii_case(mime),
+    }
+}
+
 /// Filesystem watcher for artifact detection.
 pub struct FsWatcher {
-    /// The directory being watched
+    /// The directory watched when an exec does not ask for others
     watch_dir: PathBuf,
+    /// Directories currently registered with the underlying watcher
+    watched: Vec<PathBuf>,
+    /// Rules shared with the event processing task
+    policy: Arc<RwLock<Policy>>,
     /// The underlying file watcher
     _watcher: RecommendedWatcher,
 }
@@ -47,6 +115,7 @@ impl FsWatcher {
 
         let (artifact_tx, artifact_rx) = mpsc::channel(100);
         let (event_tx, mut event_rx) = mpsc::channel(100);
+        let policy = Arc::new(RwLock::new(Policy::new(None, &watch_dir)));
 
         // Create the file watcher
         let tx = event_tx.clone();
@@ -62,10 +131,11 @@ impl FsWatcher {
         // Process file events in a background task
         let artifact_tx_clone = artifact_tx.clone();
         let watch_dir_clone = watch_dir.clone();
+        let policy_clone = policy.clone();
         tokio::spawn(async move {
             while let Some(event) = event_rx.recv().await {
                 if let Err(e) =
-                    process_event(event, &watch_dir_clone, &artifact_tx_clone).await
+                    process_event(event, &watch_dir_clone, &policy_clone, &artifact_tx_clone).await
                 {
                     error!(error = %e, "Failed to process file event");
                 }
@@ -74,6 +144,8 @@ impl FsWatcher {
 
         let mut fs_watcher = Self {
             watch_dir,
+            watched: Vec::new(),
+            policy,
             _watcher: watcher,
         };
 
@@ -90,6 +162,47 @@ impl FsWatcher {
         self._watcher
             .watch(&self.watch_dir, RecursiveMode::Recursive)
             .context("Failed to watch directory")?;
+        self.watched.push(self.watch_dir.clone());
+        Ok(())
+    }
+
+    /// Apply the artifact options of an exec, replacing those of the previous
+    /// one. `None` restores the defaults.
+    pub async fn configure(&mut self, opts: Option<&ArtifactOptions>) -> Result<()> {
+        let policy = Policy::new(opts, &self.watch_dir);
+
+        if let Some(root) = policy.roots.iter().find(|r| !r.is_absolute()) {
+            bail!("watch path must be absolute: {}", root.display());
+        }
+
+        for root in &policy.roots {
+            if self.watched.contains(root) {
+                continue;
+            }
+            fs::create_dir_all(root)
+                .await
+                .with_context(|| format!("Failed to create {}", root.display()))?;
+            self._watcher
+                .watch(root, RecursiveMode::Recursive)
+                .with_context(|| format!("Failed to watch {}", root.display()))?;
+            self.watched.push(root.clone());
+            debug!(dir = %root.display(), "Watching directory");
+        }
+
+        let stale: Vec<PathBuf> = self
+            .watched
+            .iter()
+            .filter(|w| !policy.roots.contains(w))
+            .cloned()
+            .collect();
+        for root in stale {
+            if let Err(e) = self._watcher.unwatch(&root) {
+                warn!(dir = %root.display(), error = %e, "Failed to unwatch directory");
+            }
+            self.watched.retain(|w| w != &root);
+        }
+
+        *self.policy.write().unwrap_or_else(|e| e.into_inner()) = policy;
         Ok(())
     }
 }
@@ -98,6 +211,7 @@ impl FsWatcher {
 async fn process_event(
     event: Event,
     watch_dir: &Path,
+    policy: &RwLock<Policy>,
     artifact_tx: &mpsc::Sender<Artifact>,
 ) -> Result<()> {
     // We only care about file creation and modification
@@ -106,12 +220,19 @@ async fn process_event(
         _ => return Ok(()),
     }
 
+    let policy = policy.read().unwrap_or_else(|e| e.into_inner()).clone();
+
     for path in event.paths {
         // Skip directories
         if path.is_dir() {
             continue;
         }
 
+        // Skip events from directories that are no longer watched
+        if !policy.covers(&path) {
+            continue;
+        }
+
         // Skip hidden files
         if path
             .file_name()
@@ -124,12 +245,12 @@ async fn process_event(
         debug!(path = %path.display(), "File event detected");
 
         // Read and encode the file
-        match read_artifact(&path, watch_dir).await {
+        match read_artifact(&path, watch_dir, &policy).await {
             Ok(Some(artifact)) => {
                 info!(
                     path = %artifact.path,
                     mime = %artifact.mime,
-                    size = artifact.data_base64.len(),
+                    size = artifact.size,
                     "Artifact detected"
                 );
                 if artifact_tx.send(artifact).await.is_err() {
@@ -137,7 +258,7 @@ async fn process_event(
                 }
             }
             Ok(None) => {
-                // File too large or unreadable
+                // Filtered out, too large or unreadable
             }
             Err(e) => {
                 warn!(path = %path.display(), error = %e, "Failed to read artifact");
@@ -149,41 +270,52 @@ async fn process_event(
 }
 
 /// Read a file and convert it to an artifact.
-async fn read_artifact(path: &Path, watch_dir: &Path) -> Result<Option<Artifact>> {
+async fn read_artifact(path: &Path, watch_dir: &Path, policy: &Policy) -> Result<Option<Artifact>> {
     // Get file metadata
     let metadata = fs::metadata(path).await?;
 
-    // Skip files that are too large for inline streaming
-    if metadata.len() > MAX_INLINE_SIZE {
+    // Skip files above the size limit
+    if metadata.len() > policy.max_size {
         warn!(
             path = %path.display(),
             size = metadata.len(),
-            "File too large for inline streaming"
+            limit = policy.max_size,
+            "File too large for artifact capture"
         );
         return Ok(None);
     }
 
-    // Read file contents
-    let data = fs::read(path).await?;
-
     // Detect MIME type
     let mime = mime_guess::from_path(path)
         .first_or_octet_stream()
         .to_string();
 
-    // Create relative path
+    if !policy.allows_mime(&mime) {
+        debug!(path = %path.display(), mime = %mime, "MIME type not requested");
+        return Ok(None);
+    }
+
+    // Paths under the default directory stay relative to it; others are
+    // reported as absolute sandbox paths
     let relative_path = path
         .strip_prefix(watch_dir)
         .unwrap_or(path)
         .to_string_lossy()
         .to_string();
 
-    // Base64 encode
-    let data_base64 = base64::engine::general_purpose::STANDARD.encode(&data);
+    // Read and base64 encode for inline delivery
+    let data_base64 = match policy.delivery {
+        Delivery::Inline => {
+            let data = fs::read(path).await?;
+            Some(base64::engine::general_purpose::STANDARD.encode(&data))
+        }
+        Delivery::Url => None,
+    };
 
     Ok(Some(Artifact {
         path: relative_path,
         mime,
+        size: metadata.len(),
         data_base64,
     }))
 }
@@ -208,4 +340,33 @@ mod tests {
         let result = FsWatcher::new(dir.path()).await;
         assert!(result.is_ok());
     }
+
+    #[test]
+    fn test_mime_matches() {
+        assert!(mime_matches("image/png", "image/png"));
+        assert!(mime_matches("image/*", "image/svg+xml"));
+        assert!(mime_matches("*/*", "text/csv"));
+        assert!(!mime_matches("image/*", "text/plain"));
+        assert!(!mime_matches("image/png", "image/jpeg"));
+    }
+
+    #[test]
+    fn test_policy_defaults() {
+        let policy = Policy::new(None, Path::new("/output"));
+        assert_eq!(policy.roots, vec![PathBuf::from("/output")]);
+        assert_eq!(policy.max_size, MAX_INLINE_SIZE);
+        assert!(policy.allows_mime("application/octet-stream"));
+
+        let opts = ArtifactOptions {
+            watch_paths: vec!["/workspace".to_string()],
+            max_size: Some(u64::MAX),
+            mime_types: vec!["image/*".to_string()],
+            delivery: Delivery::Inline,
+        };
+        let policy = Policy::new(Some(&opts), Path::new("/output"));
+        assert!(policy.covers(Path::new("/workspace/plot.png")));
+        assert!(!policy.covers(Path::new("/output/plot.png")));
+        assert_eq!(policy.max_size, MAX_INLINE_SIZE);
+        assert!(!policy.allows_mime("text/plain"));
+    }
 }
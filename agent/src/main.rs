@@ -3,7 +3,8 @@
 //! The agent is responsible for:
 //! - Executing commands from the Control Plane via JSON-RPC 2.0
 //! - Streaming stdout/stderr in real-time
-//! - Watching for artifacts (files in /output) and streaming them back
+//! - Watching for artifacts (files in /output, or the directories an exec
+//!   asks for) and streaming them back
 //!
 //! # Architecture
 //!
@@ -50,7 +51,7 @@ async fn run_agent() -> Result<()> {
     let mut executor = executor::Executor::new();
 
     // Initialize FS watcher
-    let (_watcher, mut artifact_rx) = fs_watcher::FsWatcher::new("/output").await?;
+    let (mut watcher, mut artifact_rx) = fs_watcher::FsWatcher::new("/output").await?;
     
     // Channel for events (Stdout, Stderr, Exit, Artifact, Error)
     let (event_tx, mut event_rx) = tokio::sync::mpsc::channel::<rpc::StreamEvent>(100);
@@ -64,7 +65,9 @@ async fn run_agent() -> Result<()> {
                 let request = match request_res {
                     Ok(Some(req)) => req,
                     Ok(None) => {
+                        // Nobody reads the output of what runs any more
                         info!("EOF received, shutting down");
+                        executor.kill_all();
                         break;
                     }
                     Err(e) => {
@@ -74,13 +77,30 @@ async fn run_agent() -> Result<()> {
                 };
 
                 match request.method.as_str() {
+                    "ping" => {
+                        // The control plane checks that the agent is alive
+                        if let Some(id) = request.id {
+                            rpc.send_response(rpc::Response::success(id, serde_json::Value::Null)).await?;
+                        }
+                    }
                     "exec" => {
                         let params: rpc::ExecParams = serde_json::from_value(request.params.clone())?;
+                        // Ties the agent's log lines to the Control Plane's trace
+                        if let Some(tp) = &params.traceparent {
+                            info!(traceparent = %tp, cmd = %params.cmd, "Exec requested");
+                        }
+
+                        // Each exec brings its own capture rules (or the defaults)
+                        if let Err(e) = watcher.configure(params.artifacts.as_ref()).await {
+                            let _ = event_tx.send(rpc::StreamEvent::Error { message: e.to_string(), kind: None }).await;
+                        }
+
                         let config = executor::ExecConfig {
                             cmd: params.cmd,
                             args: params.args,
                             env: params.env,
-                            cwd: "/workspace".to_string(),
+                            cwd: executor::work_dir(params.cwd.as_deref()),
+                            user: params.user,
                         };
                         
                         if let Some(id) = request.id {
@@ -88,32 +108,14 @@ async fn run_agent() -> Result<()> {
                         }
 
                         // Start execution and spawn monitoring task
-                        match executor.exec(config, false).await {
-                            Ok(mut output_rx) => {
-                                let tx = event_tx.clone();
-                                tokio::spawn(async move {
-                                    while let Some(output) = output_rx.recv().await {
-                                        match output {
-                                            executor::ProcessOutput::Stdout(line) => {
-                                                let _ = tx.send(rpc::StreamEvent::Stdout { chunk: line + "\n" }).await;
-                                            }
-                                            executor::ProcessOutput::Stderr(line) => {
-                                                let _ = tx.send(rpc::StreamEvent::Stderr { chunk: line + "\n" }).await;
-                                            }
-                                            executor::ProcessOutput::Error(e) => {
-                                                let _ = tx.send(rpc::StreamEvent::Error { message: e }).await;
-                                            }
-                                            _ => {}
-                                        }
-                                    }
-                                    // Note: In this simple implementation, we don't handle wait_for_completion 
-                                    // inside the monitoring task because it needs &mut self.
-                                    // We will improve this in the next iteration.
-                                    let _ = tx.send(rpc::StreamEvent::Exit { code: 0 }).await;
-                                });
+                        match executor.exec(config, "", false).await {
+                            Ok(output_rx) => {
+                                tokio::spawn(forward(output_rx, event_tx.clone(), String::new()));
                             }
                             Err(e) => {
-                                let _ = event_tx.send(rpc::StreamEvent::Error { message: e.to_string() }).await;
+                                // E.g. a missing cwd or user: nothing will exit
+                                let _ = event_tx.send(error_event(&e)).await;
+                                let _ = event_tx.send(rpc::StreamEvent::Exit { code: -1, signal: None, reason: None }).await;
                             }
                         }
                     }
@@ -124,41 +126,29 @@ async fn run_agent() -> Result<()> {
                             args: params.args,
                             env: params.env,
                             cwd: "/workspace".to_string(),
+                            user: None,
                         };
 
                         if let Some(id) = request.id {
                             rpc.send_response(rpc::Response::success(id, serde_json::Value::Null)).await?;
                         }
 
-                        match executor.exec(config, true).await {
-                            Ok(mut output_rx) => {
-                                let tx = event_tx.clone();
-                                tokio::spawn(async move {
-                                    while let Some(output) = output_rx.recv().await {
-                                        match output {
-                                            executor::ProcessOutput::Stdout(line) => {
-                                                let _ = tx.send(rpc::StreamEvent::Stdout { chunk: line + "\n" }).await;
-                                            }
-                                            executor::ProcessOutput::Stderr(line) => {
-                                                let _ = tx.send(rpc::StreamEvent::Stderr { chunk: line + "\n" }).await;
-                                            }
-                                            executor::ProcessOutput::Error(e) => {
-                                                let _ = tx.send(rpc::StreamEvent::Error { message: e }).await;
-                                            }
-                                            _ => {}
-                                        }
-                                    }
-                                    let _ = tx.send(rpc::StreamEvent::Exit { code: 0 }).await;
-                                });
+                        match executor.exec(config, &params.session, true).await {
+                            Ok(output_rx) => {
+                                tokio::spawn(forward(output_rx, event_tx.clone(), params.session.clone()));
                             }
                             Err(e) => {
-                                let _ = event_tx.send(rpc::StreamEvent::Error { message: e.to_string() }).await;
+                                let _ = event_tx.send(error_event(&e).for_session(&params.session)).await;
                             }
                         }
                     }
                     "repl.input" => {
                         let params: rpc::ReplInputParams = serde_json::from_value(request.params.clone())?;
-                        match executor.write_stdin(&params.data).await {
+                        let mut result = executor.write_stdin(&params.session, &params.data).await;
+                        if result.is_ok() && params.eof {
+                            result = executor.close_stdin(&params.session);
+                        }
+                        match result {
                             Ok(_) => {
                                 if let Some(id) = request.id {
                                     rpc.send_response(rpc::Response::success(id, serde_json::Value::Null)).await?;
@@ -171,6 +161,36 @@ async fn run_agent() -> Result<()> {
                             }
                         }
                     }
+                    "proc.signal" => {
+                        // Usually a notification: the control plane learns
+                        // the outcome from the process's exit
+                        let result = serde_json::from_value::<rpc::SignalParams>(request.params.clone())
+                            .map_err(anyhow::Error::from)
+                            .and_then(|params| executor.signal(&params.session, params.signal));
+                        match (request.id, result) {
+                            (Some(id), Ok(())) => {
+                                rpc.send_response(rpc::Response::success(id, serde_json::Value::Null)).await?;
+                            }
+                            (Some(id), Err(e)) => {
+                                rpc.send_response(rpc::Response::error(id, rpc::INVALID_PARAMS, &format!("{:#}", e))).await?;
+                            }
+                            (None, Err(e)) => info!(error = %e, "Signal not sent"),
+                            (None, Ok(())) => {}
+                        }
+                    }
+                    "exec.cancel" => {
+                        // The exec's exit follows, with reason "canceled"
+                        match (request.id, executor.cancel()) {
+                            (Some(id), Ok(())) => {
+                                rpc.send_response(rpc::Response::success(id, serde_json::Value::Null)).await?;
+                            }
+                            (Some(id), Err(e)) => {
+                                rpc.send_response(rpc::Response::error(id, rpc::INVALID_PARAMS, &format!("{:#}", e))).await?;
+                            }
+                            (None, Err(e)) => info!(error = %e, "Exec not canceled"),
+                            (None, Ok(())) => {}
+                        }
+                    }
                     _ => {
                         if let Some(id) = request.id {
                             rpc.send_response(rpc::Response::error(id, rpc::METHOD_NOT_FOUND, "Method not found")).await?;
@@ -190,6 +210,7 @@ async fn run_agent() -> Result<()> {
                     rpc.send_event(rpc::StreamEvent::Artifact {
                         path: a.path,
                         mime: a.mime,
+                        size: a.size,
                         data_base64: a.data_base64
                     }).await?;
                 }
@@ -199,3 +220,43 @@ async fn run_agent() -> Result<()> {
     
     Ok(())
 }
+
+/// The error event of a process that could not be started.
+fn error_event(err: &anyhow::Error) -> rpc::StreamEvent {
+    rpc::StreamEvent::Error {
+        message: format!("{:#}", err),
+        kind: Some(executor::error_kind(err).to_string()),
+    }
+}
+
+/// Forwards the output of a process as events, tagged with its REPL
+/// session if it has a name.
+async fn forward(
+    mut output_rx: tokio::sync::mpsc::Receiver<executor::ProcessOutput>,
+    tx: tokio::sync::mpsc::Sender<rpc::StreamEvent>,
+    session: String,
+) {
+    while let Some(output) = output_rx.recv().await {
+        let event = match output {
+            executor::ProcessOutput::Stdout(line) => rpc::StreamEvent::Stdout { chunk: line + "\n" },
+            executor::ProcessOutput::Stderr(line) => rpc::StreamEvent::Stderr { chunk: line + "\n" },
+            executor::ProcessOutput::Error(e) => rpc::StreamEvent::Error { message: e, kind: Some("internal".to_string()) },
+            executor::ProcessOutput::Exit(exit) => {
+                let _ = tx.send(exit_event(&exit).for_session(&session)).await;
+                return;
+            }
+        };
+        let _ = tx.send(event.for_session(&session)).await;
+    }
+    // The process could not be waited for
+    let _ = tx.send(rpc::StreamEvent::Exit { code: -1, signal: None, reason: None }.for_session(&session)).await;
+}
+
+/// Turns how a process ended into the exit notification.
+fn exit_event(exit: &executor::ExitInfo) -> rpc::StreamEvent {
+    rpc::StreamEvent::Exit {
+        code: exit.code,
+        signal: exit.signal,
+        reason: exit.reason().map(str::to_string),
+    }
+}
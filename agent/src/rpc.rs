@@ -96,19 +96,53 @@ pub enum StreamEvent {
     
     /// Process exited
     #[serde(rename = "exit")]
-    Exit { code: i32 },
+    Exit {
+        code: i32,
+        /// The signal that killed the process
+        #[serde(skip_serializing_if = "Option::is_none")]
+        signal: Option<i32>,
+        /// Why the process ended abnormally: "signaled" or "oom_killed"
+        #[serde(skip_serializing_if = "Option::is_none")]
+        reason: Option<String>,
+    },
     
     /// Artifact detected
     #[serde(rename = "artifact")]
     Artifact {
         path: String,
         mime: String,
-        data_base64: String,
+        size: u64,
+        /// Inline contents; absent when the artifact is delivered by URL
+        #[serde(skip_serializing_if = "Option::is_none")]
+        data_base64: Option<String>,
     },
     
     /// Error occurred
     #[serde(rename = "error")]
-    Error { message: String },
+    Error {
+        message: String,
+        /// What failed, for the Control Plane to tell apart without parsing
+        /// the message: see executor::error_kind
+        #[serde(skip_serializing_if = "Option::is_none")]
+        kind: Option<String>,
+    },
+
+    /// An event of the REPL of a session, sent with the session's name
+    #[serde(skip)]
+    Session { session: String, event: Box<StreamEvent> },
+}
+
+impl StreamEvent {
+    /// Tags the event with the REPL session it comes from, if it has one.
+    pub fn for_session(self, session: &str) -> Self {
+        if session.is_empty() {
+            return self;
+        }
+        StreamEvent::Session {
+            session: session.to_string(),
+            event: Box::new(self),
+        }
+    }
 }
 
 /// Parameters for the "exec" method.
@@ -119,6 +153,46 @@ pub struct ExecParams {
     pub args: Vec<String>,
     #[serde(default)]
     pub env: HashMap<String, String>,
+    /// Working directory, absolute or relative to /workspace
+    #[serde(default)]
+    pub cwd: Option<String>,
+    /// User name or "uid[:gid]" to run as instead of the default user
+    #[serde(default)]
+    pub user: Option<String>,
+    #[serde(default)]
+    pub artifacts: Option<ArtifactOptions>,
+    /// W3C trace context of the Control Plane's span for this exec
+    #[serde(default)]
+    pub traceparent: Option<String>,
+}
+
+/// How detected artifacts are returned to the Control Plane.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Delivery {
+    /// File contents are base64-encoded into the artifact event
+    #[default]
+    Inline,
+    /// Only path, MIME type and size are sent; the Control Plane serves the
+    /// file from the sandbox on request
+    Url,
+}
+
+/// Per-exec artifact capture options. Missing fields keep the defaults:
+/// watch /output, inline delivery, 10 MB limit, any MIME type.
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct ArtifactOptions {
+    /// Absolute directories to watch instead of /output
+    #[serde(default)]
+    pub watch_paths: Vec<String>,
+    /// Largest file reported, in bytes
+    #[serde(default)]
+    pub max_size: Option<u64>,
+    /// Allowed MIME types, either exact ("image/png") or wildcards ("image/*")
+    #[serde(default)]
+    pub mime_types: Vec<String>,
+    #[serde(default)]
+    pub delivery: Delivery,
 }
 
 /// Parameters for the "repl.start" method.
@@ -129,12 +203,33 @@ pub struct ReplStartParams {
     pub args: Vec<String>,
     #[serde(default)]
     pub env: HashMap<String, String>,
+    /// Names the REPL so that several can run side by side; its events
+    /// carry the name. Empty is the REPL of a connection running only one.
+    #[serde(default)]
+    pub session: String,
 }
 
 /// Parameters for the "repl.input" method.
 #[derive(Debug, Clone, Deserialize)]
 pub struct ReplInputParams {
+    #[serde(default)]
     pub data: String,
+    /// The REPL the input is for
+    #[serde(default)]
+    pub session: String,
+    /// Close the REPL's stdin after data
+    #[serde(default)]
+    pub eof: bool,
+}
+
+/// Parameters for the "proc.signal" method.
+#[derive(Debug, Clone, Deserialize)]
+pub struct SignalParams {
+    /// Signal number, e.g. 2 for SIGINT
+    pub signal: i32,
+    /// Signals the REPL of this session instead of the last process started
+    #[serde(default)]
+    pub session: String,
 }
 
 /// RPC handler that processes incoming requests.
@@ -186,34 +281,7 @@ where
 
     /// Send a streaming event (notification) to the stream.
     pub async fn send_event(&mut self, event: StreamEvent) -> Result<()> {
-        let notification = match &event {
-            StreamEvent::Stdout { chunk } => {
-                Request::notification("stdout", serde_json::json!({ "chunk": chunk }))
-            }
-            StreamEvent::Stderr { chunk } => {
-                Request::notification("stderr", serde_json::json!({ "chunk": chunk }))
-            }
-            StreamEvent::Exit { code } => {
-                Request::notification("exit", serde_json::json!({ "code": code }))
-            }
-            StreamEvent::Artifact {
-                path,
-                mime,
-                data_base64,
-            } => Request::notification(
-                "artifact",
-                serde_json::json!({
-                    "path": path,
-                    "mime": mime,
-                    "data_base64": data_base64
-                }),
-            ),
-            StreamEvent::Error { message } => {
-                Request::notification("error", serde_json::json!({ "message": message }))
-            }
-        };
-
-        let json = serde_json::to_string(&notification)?;
+        let json = serde_json::to_string(&notification(&event))?;
         self.writer.write_all(json.as_bytes()).await?;
         self.writer.write_all(b"\n").await?;
         self.writer.flush().await?;
@@ -221,6 +289,56 @@ where
     }
 }
 
+/// Builds the notification of a streaming event.
+fn notification(event: &StreamEvent) -> Request {
+    match event {
+        StreamEvent::Stdout { chunk } => {
+            Request::notification("stdout", serde_json::json!({ "chunk": chunk }))
+        }
+        StreamEvent::Stderr { chunk } => {
+            Request::notification("stderr", serde_json::json!({ "chunk": chunk }))
+        }
+        StreamEvent::Exit { code, signal, reason } => {
+            let mut params = serde_json::json!({ "code": code });
+            if let Some(signal) = signal {
+                params["signal"] = serde_json::json!(signal);
+            }
+            if let Some(reason) = reason {
+                params["reason"] = serde_json::json!(reason);
+            }
+            Request::notification("exit", params)
+        }
+        StreamEvent::Artifact {
+            path,
+            mime,
+            size,
+            data_base64,
+        } => {
+            let mut params = serde_json::json!({
+                "path": path,
+                "mime": mime,
+                "size": size
+            });
+            if let Some(data) = data_base64 {
+                params["data_base64"] = serde_json::json!(data);
+            }
+            Request::notification("artifact", params)
+        }
+        StreamEvent::Error { message, kind } => {
+            let mut params = serde_json::json!({ "message": message });
+            if let Some(kind) = kind {
+                params["kind"] = serde_json::json!(kind);
+            }
+            Request::notification("error", params)
+        }
+        StreamEvent::Session { session, event } => {
+            let mut notification = notification(event);
+            notification.params["session"] = serde_json::json!(session);
+            notification
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -242,6 +360,59 @@ mod tests {
         assert!(json.contains("\"method\":\"exec\""));
     }
 
+    #[test]
+    fn test_exec_params_artifacts() {
+        let params: ExecParams = serde_json::from_value(serde_json::json!({
+            "cmd": "python3",
+            "artifacts": {
+                "watch_paths": ["/workspace"],
+                "max_size": 1024,
+                "mime_types": ["image/*"],
+                "delivery": "url"
+            }
+        }))
+        .unwrap();
+
+        let artifacts = params.artifacts.unwrap();
+        assert_eq!(artifacts.watch_paths, vec!["/workspace"]);
+        assert_eq!(artifacts.max_size, Some(1024));
+        assert_eq!(artifacts.delivery, Delivery::Url);
+
+        let params: ExecParams =
+            serde_json::from_value(serde_json::json!({ "cmd": "python3" })).unwrap();
+        assert!(params.artifacts.is_none());
+        assert!(params.cwd.is_none());
+        assert!(params.user.is_none());
+    }
+
+    #[test]
+    fn test_session_event() {
+        let event = StreamEvent::Stdout { chunk: "hi\n".to_string() };
+        let json = serde_json::to_value(notification(&event.clone().for_session("py"))).unwrap();
+        assert_eq!(json["method"], "stdout");
+        assert_eq!(json["params"]["chunk"], "hi\n");
+        assert_eq!(json["params"]["session"], "py");
+
+        let json = serde_json::to_value(notification(&event.for_session(""))).unwrap();
+        assert!(json["params"].get("session").is_none());
+
+        let params: ReplInputParams = serde_json::from_value(serde_json::json!({ "data": "x" })).unwrap();
+        assert_eq!(params.session, "");
+    }
+
+    #[test]
+    fn test_error_event() {
+        let event = StreamEvent::Error { message: "boom".to_string(), kind: Some("internal".to_string()) };
+        let json = serde_json::to_value(notification(&event)).unwrap();
+        assert_eq!(json["method"], "error");
+        assert_eq!(json["params"]["message"], "boom");
+        assert_eq!(json["params"]["kind"], "internal");
+
+        let event = StreamEvent::Error { message: "boom".to_string(), kind: None };
+        let json = serde_json::to_value(notification(&event)).unwrap();
+        assert!(json["params"].get("kind").is_none());
+    }
+
     #[test]
     fn test_response_success() {
         let response = Response::success(
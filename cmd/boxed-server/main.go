@@ -9,28 +9,46 @@
 //
 // Flags:
 //
-//	-c, --config string   Path to config file (default: boxed.yaml)
+//	-c, --config string   Path to config file (default: boxed.yaml if it exists, or $BOXED_CONFIG)
 //	-p, --port int        HTTP server port (default: 8080)
-//	-d, --driver string   Backend driver: docker, firecracker (default: docker)
+//	-d, --driver string   Backend drivers, comma-separated: docker, wasm or plugins (default: docker)
 //	-v, --verbose         Enable debug logging
+//
+// The flags override the config file and the BOXED_* environment
+// variables, which override the file; see package config.
 package main
 
 import (
 	"context"
+	"flag"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/config"
 	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"github.com/akshayaggarwal99/boxed/internal/driver/plugin"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
 
-	// Register docker driver
+	// Register drivers
 	_ "github.com/akshayaggarwal99/boxed/internal/driver/docker"
+	_ "github.com/akshayaggarwal99/boxed/internal/driver/wasm"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // Version information (set via ldflags at build time)
@@ -41,8 +59,29 @@ var (
 )
 
 func main() {
+	flags := config.Default()
+	var configFile, driverNames string
+	var verbose bool
+	for _, name := range []string{"c", "config"} {
+		flag.StringVar(&configFile, name, os.Getenv("BOXED_CONFIG"), "Path to config file (default: "+config.DefaultFile+" if it exists)")
+	}
+	for _, name := range []string{"p", "port"} {
+		flag.StringVar(&flags.Port, name, flags.Port, "HTTP server port")
+	}
+	for _, name := range []string{"d", "driver"} {
+		flag.StringVar(&driverNames, name, "docker", "Backend drivers, comma-separated: docker, wasm or plugins")
+	}
+	for _, name := range []string{"v", "verbose"} {
+		flag.BoolVar(&verbose, name, false, "Enable debug logging")
+	}
+	flag.Parse()
+	flags.Driver.Names = strings.Split(driverNames, ",")
+
 	// Configure structured JSON logging
 	zerolog.TimeFieldFormat = time.RFC3339Nano
+	if verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
 
 	// Use pretty console output for development
 	if os.Getenv("BOXED_ENV") != "production" {
@@ -72,13 +111,97 @@ func main() {
 		cancel()
 	}()
 
-	// Initialize configuration
-	// For MVP, we stick to defaults or env vars handled by driver New()
+	// Spans are exported when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(ctx, "boxed-server", Version)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to flush traces")
+		}
+	}()
+	if tracing.Enabled() {
+		log.Info().Msg("Exporting traces over OTLP")
+	}
+
+	// Initialize configuration: the config file, then the environment,
+	// then the flags
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "p", "port":
+			given["port"] = true
+		case "d", "driver":
+			given["driver"] = true
+		}
+	})
+	cfg.Override(flags, func(name string) bool { return given[name] })
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	if cfg.Path != "" {
+		log.Info().Str("file", cfg.Path).Msg("Loaded configuration")
+	}
+
+	// Driver plugins are executables named boxed-driver-<name> in
+	// the plugin directory (default: ./plugins)
+	plugins, err := plugin.Load(cfg.Driver.PluginDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load driver plugins")
+	}
+	if len(plugins) > 0 {
+		log.Info().Strs("plugins", plugins).Msg("Loaded driver plugins")
+	}
 
-	// Create Docker driver
-	d, err := driver.NewDriver("docker", nil)
+	// Create driver (docker, wasm or a plugin, or several with routes
+	// choosing between them), configured with the config file's driver
+	// options and the variables below
+	routes, err := multi.ParseRoutes(strings.Join(cfg.Driver.Routes, ","))
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize docker driver")
+		log.Fatal().Err(err).Msg("Invalid driver routes")
+	}
+	driverCfg := cfg.Driver.Settings()
+	if v, err := time.ParseDuration(os.Getenv("BOXED_RECONCILE_INTERVAL")); err == nil {
+		driverCfg["reconcile_interval"] = v
+	}
+	// BOXED_NODE_URL makes the server a node of a cluster sharing the
+	// state in BOXED_STATE_DIR; other nodes reach its API at that URL
+	nodeURL := os.Getenv("BOXED_NODE_URL")
+	instanceID := os.Getenv("BOXED_INSTANCE_ID")
+	if nodeURL != "" && (instanceID == "" || cfg.Storage.StateDir == "") {
+		log.Fatal().Msg("BOXED_NODE_URL requires BOXED_INSTANCE_ID and BOXED_STATE_DIR")
+	}
+	// BOXED_ORPHAN_POLICY: delete (default), adopt or keep the sandboxes an
+	// earlier process of this BOXED_INSTANCE_ID left running. Cluster nodes
+	// keep them by default: another node may have taken them over.
+	if v := os.Getenv("BOXED_ORPHAN_POLICY"); v != "" {
+		driverCfg["orphan_policy"] = v
+	} else if _, ok := driverCfg["orphan_policy"]; !ok && nodeURL != "" {
+		driverCfg["orphan_policy"] = "keep"
+	}
+	if instanceID != "" {
+		driverCfg["instance_id"] = instanceID
+	}
+	// BOXED_GPUS lists the GPUs sandboxes can be given, "id[:type],...";
+	// by default nvidia-smi finds them
+	if v := os.Getenv("BOXED_GPUS"); v != "" {
+		driverCfg["gpus"] = v
+	}
+	// BOXED_DISCARD_AGENT_STDERR drops the stderr of Docker agents, kept
+	// with the agent logs of each sandbox by default
+	if v, err := strconv.ParseBool(os.Getenv("BOXED_DISCARD_AGENT_STDERR")); err == nil {
+		driverCfg["discard_agent_stderr"] = v
+	}
+	d, err := multi.Open(cfg.Driver.Names, driverCfg, routes)
+	if err != nil {
+		log.Fatal().Err(err).Strs("drivers", cfg.Driver.Names).Msg("Failed to initialize driver")
 	}
 	defer d.Close()
 
@@ -98,26 +221,245 @@ func main() {
 	// e.Use(middleware.Logger())
 	// e.Use(middleware.Recover())
 
-	apiKey := os.Getenv("BOXED_API_KEY")
-	h := api.NewHandler(d, apiKey)
+	opts := []api.Option{
+		api.WithMaxOutput(cfg.Limits.MaxOutput),
+		api.WithMaxTTL(cfg.Limits.MaxTTL),
+		api.WithMaxContextFileSize(cfg.Limits.MaxContextFileSize),
+		api.WithMaxContextSize(cfg.Limits.MaxContextSize),
+		api.WithInputLimits(cfg.Limits.Inputs()),
+		api.WithReadyPoolMin(cfg.Pool.ReadyMin),
+		api.WithMaxSandboxes(cfg.Pool.MaxSandboxes),
+		api.WithMaxSandboxAge(cfg.Limits.MaxSandboxAge),
+		api.WithDownloadStallTimeout(cfg.Limits.DownloadStallTimeout),
+	}
+	if v, err := strconv.Atoi(os.Getenv("BOXED_EXEC_CACHE_SIZE")); err == nil {
+		opts = append(opts, api.WithExecCacheSize(v))
+	}
+	if v, err := time.ParseDuration(os.Getenv("BOXED_EXPIRY_WARNING")); err == nil {
+		opts = append(opts, api.WithExpiryWarning(v))
+	}
+	if v, err := time.ParseDuration(os.Getenv("BOXED_USAGE_INTERVAL")); err == nil {
+		opts = append(opts, api.WithUsageInterval(v))
+	}
+	// BOXED_SECURITY=hardened applies driver.HardenedSecurity to sandboxes
+	// created without their own settings, except for images matching
+	// BOXED_TRUSTED_IMAGES (comma-separated patterns such as "boxed-*:*")
+	switch v := os.Getenv("BOXED_SECURITY"); v {
+	case "", "default":
+	case "hardened":
+		var trusted []string
+		for _, pattern := range strings.Split(os.Getenv("BOXED_TRUSTED_IMAGES"), ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				trusted = append(trusted, pattern)
+			}
+		}
+		opts = append(opts, api.WithSecurity(driver.HardenedSecurity(), trusted...))
+	default:
+		log.Fatal().Str("security", v).Msg("Invalid BOXED_SECURITY: want default or hardened")
+	}
+	if dir := os.Getenv("BOXED_SECCOMP_DIR"); dir != "" {
+		opts = append(opts, api.WithSeccompDir(dir))
+	}
+	// The ranges let through the egress proxy may be cloned from and sent
+	// job callbacks too
+	egressAllow, err := cfg.Egress.Allowed()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid egress allowlist")
+	}
+	opts = append(opts, api.WithGitAllowCIDRs(egressAllow), api.WithCallbackAllowCIDRs(egressAllow))
+	if dir := cfg.Storage.ArtifactDir; dir != "" {
+		store, err := artifacts.Open(dir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open artifact store")
+		}
+		opts = append(opts, api.WithArtifactStore(store))
+	}
+	if dir := cfg.Storage.StateDir; dir != "" {
+		store, err := state.OpenFileStore(dir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open state store")
+		}
+		opts = append(opts, api.WithStore(store))
+	}
+	if nodeURL != "" {
+		clusterCfg := api.ClusterConfig{NodeID: instanceID, URL: nodeURL}
+		if v, err := time.ParseDuration(os.Getenv("BOXED_HEARTBEAT_INTERVAL")); err == nil {
+			clusterCfg.HeartbeatInterval = v
+		}
+		if v, err := time.ParseDuration(os.Getenv("BOXED_NODE_TIMEOUT")); err == nil {
+			clusterCfg.NodeTimeout = v
+		}
+		opts = append(opts, api.WithCluster(clusterCfg))
+	}
+	if cfg.Templates != "" {
+		catalog, err := templates.Load(cfg.Templates)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load templates")
+		}
+		go func() {
+			if err := catalog.Watch(ctx); err != nil {
+				log.Error().Err(err).Msg("Cannot watch templates for changes")
+			}
+		}()
+		opts = append(opts, api.WithTemplates(catalog))
+	}
+	keys := cfg.Auth.Keys
+	if path := cfg.Auth.KeysFile; path != "" {
+		fileKeys, err := api.LoadAPIKeys(path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load API keys")
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) > 0 {
+		if err := api.CheckAPIKeys(keys); err != nil {
+			log.Fatal().Err(err).Msg("Invalid API keys")
+		}
+		if err := api.CheckExecScheduling(cfg.Scheduling.Exec(), keys); err != nil {
+			log.Fatal().Err(err).Msg("Invalid exec scheduling")
+		}
+		opts = append(opts, api.WithAPIKeys(keys))
+	}
+	if hooks := cfg.Admission.Webhooks; len(hooks) > 0 {
+		opts = append(opts, api.WithAdmissionWebhooks(hooks))
+	}
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		opts = append(opts, api.WithCORS(cfg.CORS.Policy()))
+	}
+	if cfg.Dashboard {
+		opts = append(opts, api.WithDashboard())
+	}
+	if cfg.Scheduling.Slots > 0 || cfg.Scheduling.SandboxSlots > 0 {
+		opts = append(opts, api.WithExecScheduling(cfg.Scheduling.Exec()))
+	}
+	if len(cfg.Metrics.SlowDriverOps) > 0 {
+		opts = append(opts, api.WithSlowDriverOps(cfg.Metrics.SlowDriverOps))
+	}
+	if oidc := cfg.Auth.OIDC; oidc.Issuer != "" {
+		verifier, err := auth.NewOIDCVerifier(ctx, auth.Config{
+			Issuer:   oidc.Issuer,
+			Audience: oidc.Audience,
+			OrgClaim: oidc.OrgClaim,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up OIDC authentication")
+		}
+		opts = append(opts, api.WithTokenVerifier(verifier))
+	}
+	h := api.NewHandler(d, cfg.Auth.APIKey, opts...)
 	h.RegisterRoutes(e)
 
+	// Warm the images of the pool's prepull list
+	h.Prepull(cfg.Pool.Prepull)
+
+	// BOXED_GRPC=true serves the gRPC API on the HTTP port too;
+	// BOXED_GRPC_PORT on a port of its own
+	var gs *grpc.Server
+	grpcEnabled, _ := strconv.ParseBool(os.Getenv("BOXED_GRPC"))
+	grpcPort := os.Getenv("BOXED_GRPC_PORT")
+	if grpcEnabled || grpcPort != "" {
+		var grpcOpts []grpc.ServerOption
+		if cfg.TLS.Enabled() && grpcPort != "" {
+			creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to load TLS certificate")
+			}
+			grpcOpts = append(grpcOpts, grpc.Creds(creds))
+		}
+		gs = h.NewGRPCServer(grpcOpts...)
+	}
+	if gs != nil && grpcPort == "" {
+		api.MountGRPC(e, gs)
+	}
+
+	// BOXED_SSH_PORT serves an SSH/SFTP gateway to sandboxes, with the
+	// host key in BOXED_SSH_HOST_KEY
+	var ss *api.SSHServer
+	sshPort := os.Getenv("BOXED_SSH_PORT")
+	if sshPort != "" {
+		hostKey, err := api.LoadSSHHostKey(os.Getenv("BOXED_SSH_HOST_KEY"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load SSH host key")
+		}
+		if os.Getenv("BOXED_SSH_HOST_KEY") == "" {
+			log.Warn().Msg("SSH host key is generated anew each start; set BOXED_SSH_HOST_KEY to keep it")
+		}
+		ss = h.NewSSHServer(hostKey)
+	}
+
+	// egress.proxy_port serves the proxy sandboxes asking for internet
+	// access reach it through
+	var ep *api.EgressProxy
+	if cfg.Egress.ProxyPort != 0 {
+		ep = h.NewEgressProxy(cfg.Egress.ProxyPort, egressAllow)
+	}
+
 	// Start server
-	serverErr := make(chan error, 1)
+	serverErr := make(chan error, 4)
 	go func() {
-		port := "8080"
-		if p := os.Getenv("PORT"); p != "" {
-			port = p
+		log.Info().Str("port", cfg.Port).Bool("tls", cfg.TLS.Enabled()).Msg("🚀 Server listening")
+		if cfg.TLS.Enabled() {
+			serverErr <- e.StartTLS(":"+cfg.Port, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			return
 		}
-		log.Info().Str("port", port).Msg("🚀 Server listening")
-		serverErr <- e.Start(":" + port)
+		serverErr <- e.Start(":" + cfg.Port)
 	}()
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for gRPC")
+		}
+		go func() {
+			log.Info().Str("port", grpcPort).Msg("🚀 gRPC listening")
+			serverErr <- gs.Serve(lis)
+		}()
+	}
+	if ss != nil {
+		lis, err := net.Listen("tcp", ":"+sshPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for SSH")
+		}
+		go func() {
+			log.Info().Str("port", sshPort).Msg("🚀 SSH gateway listening")
+			serverErr <- ss.Serve(lis)
+		}()
+	}
+	if ep != nil {
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.Egress.ProxyPort))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for the egress proxy")
+		}
+		go func() {
+			log.Info().Int("port", cfg.Egress.ProxyPort).Msg("🚀 Egress proxy listening")
+			serverErr <- ep.Serve(lis)
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
+		// Drain first: in-flight execs and sessions finish (up to
+		// BOXED_DRAIN_TIMEOUT) while new creates are refused
+		drainTimeout := 30 * time.Second
+		if v, err := time.ParseDuration(os.Getenv("BOXED_DRAIN_TIMEOUT")); err == nil {
+			drainTimeout = v
+		}
+		stopOnExit, _ := strconv.ParseBool(os.Getenv("BOXED_STOP_ON_EXIT"))
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer drainCancel()
+		h.Drain(drainCtx, stopOnExit)
+
 		// Graceful shutdown
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer shutdownCancel()
+		if gs != nil {
+			api.StopGRPC(shutdownCtx, gs)
+		}
+		if ss != nil {
+			ss.Shutdown(shutdownCtx)
+		}
+		if ep != nil {
+			ep.Shutdown(shutdownCtx)
+		}
 		if err := e.Shutdown(shutdownCtx); err != nil {
 			log.Error().Err(err).Msg("Server forced to shutdown")
 		}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// Operations admission webhooks review.
+const (
+	AdmissionCreate = "create"
+	AdmissionExec   = "exec"
+)
+
+// DefaultAdmissionTimeout bounds a call to an admission webhook that sets
+// no timeout of its own.
+const DefaultAdmissionTimeout = 5 * time.Second
+
+// AdmissionSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of an
+// admission review keyed with the webhook's secret; only set if it has one.
+const AdmissionSignatureHeader = "X-Boxed-Signature"
+
+// admissionResponseBytes caps the body read from a webhook.
+const admissionResponseBytes = 1 << 20
+
+// AdmissionWebhook is an HTTP endpoint that reviews requests before they
+// run, and may deny or change them; see WithAdmissionWebhooks.
+type AdmissionWebhook struct {
+	// Name identifies the webhook in errors and logs
+	Name string `yaml:"name"`
+
+	// URL is POSTed an AdmissionReview for each request it reviews
+	URL string `yaml:"url"`
+
+	// Operations are AdmissionCreate and AdmissionExec; empty reviews both
+	Operations []string `yaml:"operations"`
+
+	// Timeout bounds each call; zero means DefaultAdmissionTimeout
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Secret signs the reviews sent, in AdmissionSignatureHeader
+	Secret string `yaml:"secret"`
+
+	// FailOpen admits requests when the webhook cannot be reached or
+	// answers badly; by default they fail with 503
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// reviews reports whether the webhook reviews operation.
+func (w AdmissionWebhook) reviews(operation string) bool {
+	return len(w.Operations) == 0 || slices.Contains(w.Operations, operation)
+}
+
+// AdmissionReview is what a webhook is sent: the request and who made it.
+type AdmissionReview struct {
+	Operation string `json:"operation"`
+
+	// Caller is the identity of the caller; nil without authentication
+	Caller *auth.Claims `json:"caller,omitempty"`
+
+	// SandboxID is the sandbox of an exec
+	SandboxID string `json:"sandbox_id,omitempty"`
+
+	// Request is the CreateSandboxRequest or ExecRequest, as the API took
+	// it or as earlier webhooks changed it
+	Request any `json:"request"`
+}
+
+// AdmissionResponse is a webhook's answer. A request the webhook sets
+// replaces the one reviewed in whole.
+type AdmissionResponse struct {
+	Allowed bool            `json:"allowed"`
+	Reason  string          `json:"reason,omitempty"`
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// WithAdmissionWebhooks has hooks review creates and execs, in order,
+// before they run. Each sees the request as the webhooks before it left it.
+// Execs include jobs and SSH commands. Interactive sessions, whose code is
+// typed as they go, are refused while a webhook reviews execs; file
+// transfers are not reviewed.
+func WithAdmissionWebhooks(hooks []AdmissionWebhook) Option {
+	return func(h *Handler) {
+		h.admission = hooks
+	}
+}
+
+// CheckAdmissionWebhooks reports webhooks without a name or an http(s)
+// URL, names used twice and operations that are not reviewed.
+func CheckAdmissionWebhooks(hooks []AdmissionWebhook) error {
+	names := make(map[string]bool)
+	for i, w := range hooks {
+		if w.Name == "" {
+			return fmt.Errorf("webhook %d has no name", i+1)
+		}
+		if names[w.Name] {
+			return fmt.Errorf("webhook name %s is used twice", w.Name)
+		}
+		names[w.Name] = true
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %s needs an http or https URL", w.Name)
+		}
+		for _, op := range w.Operations {
+			if op != AdmissionCreate && op != AdmissionExec {
+				return fmt.Errorf("webhook %s: unknown operation %q: want create or exec", w.Name, op)
+			}
+		}
+		if w.Timeout < 0 {
+			return fmt.Errorf("webhook %s: timeout cannot be negative", w.Name)
+		}
+	}
+	return nil
+}
+
+// admitCreate has the webhooks review a create, returning the request as
+// they leave it.
+func (h *Handler) admitCreate(ctx context.Context, req CreateSandboxRequest) (CreateSandboxRequest, error) {
+	return admit(ctx, h, AdmissionCreate, "", req)
+}
+
+// admitExec has the webhooks review an exec in sandbox id, returning the
+// request as they leave it.
+func (h *Handler) admitExec(ctx context.Context, id string, req ExecRequest) (ExecRequest, error) {
+	if len(h.admission) == 0 {
+		return req, nil
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return req, err
+	}
+	return admit(ctx, h, AdmissionExec, id, req)
+}
+
+// errInteractiveReview refuses interactive sessions while webhooks review
+// execs: what they run is typed as they go, so no webhook could see it.
+var errInteractiveReview = newAPIError(http.StatusForbidden, CodeForbidden,
+	"interactive sessions are not allowed while admission webhooks review execs")
+
+// admitInteractive refuses an interactive session in sandbox id, such as a
+// REPL or an SSH shell, if a webhook reviews execs.
+func (h *Handler) admitInteractive(ctx context.Context, id string) error {
+	if !slices.ContainsFunc(h.admission, func(w AdmissionWebhook) bool { return w.reviews(AdmissionExec) }) {
+		return nil
+	}
+	audit(ctx, id, "Denied interactive session: admission webhooks review execs")
+	return errInteractiveReview
+}
+
+// admit sends req, the request of operation, to the webhooks of h that
+// review it, and returns it with their changes.
+func admit[T any](ctx context.Context, h *Handler, operation, id string, req T) (T, error) {
+	for _, w := range h.admission {
+		if !w.reviews(operation) {
+			continue
+		}
+		review := AdmissionReview{Operation: operation, Caller: auth.FromContext(ctx), SandboxID: id, Request: req}
+		resp, err := callAdmissionWebhook(ctx, w, review)
+		if err != nil {
+			if w.FailOpen {
+				log.Warn().Err(err).Str("webhook", w.Name).Str("operation", operation).Msg("Admission webhook failed; admitting")
+				continue
+			}
+			return req, wrapAPIError(http.StatusServiceUnavailable, CodeUnavailable,
+				fmt.Sprintf("admission webhook %s failed", w.Name), err)
+		}
+		if !resp.Allowed {
+			audit(ctx, id, fmt.Sprintf("Denied %s by admission webhook %s: %s", operation, w.Name, resp.Reason))
+			msg := fmt.Sprintf("denied by admission webhook %s", w.Name)
+			if resp.Reason != "" {
+				msg += ": " + resp.Reason
+			}
+			return req, newAPIError(http.StatusForbidden, CodeForbidden, msg)
+		}
+		if len(resp.Request) > 0 && string(resp.Request) != "null" {
+			// Decode into a zero request, so that fields the webhook left
+			// out are unset
+			var changed T
+			if err := json.Unmarshal(resp.Request, &changed); err != nil {
+				return req, wrapAPIError(http.StatusServiceUnavailable, CodeUnavailable,
+					fmt.Sprintf("admission webhook %s returned an invalid request", w.Name), err)
+			}
+			req = changed
+			audit(ctx, id, fmt.Sprintf("Admission webhook %s changed the %s request", w.Name, operation))
+		}
+	}
+	return req, nil
+}
+
+// callAdmissionWebhook POSTs review to w and returns its answer.
+func callAdmissionWebhook(ctx context.Context, w AdmissionWebhook, review AdmissionReview) (*AdmissionResponse, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = DefaultAdmissionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set(AdmissionSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New(resp.Status)
+	}
+	var answer AdmissionResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, admissionResponseBytes)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &answer, nil
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	artifactBlobs = metrics.Default.Gauge("boxed_artifact_blobs",
+		"Distinct artifact blobs in the artifact store.")
+	artifactBytes = metrics.Default.Gauge("boxed_artifact_stored_bytes",
+		"Bytes stored in the artifact store.")
+	artifactDedupBytes = metrics.Default.Counter("boxed_artifact_dedup_bytes_total",
+		"Artifact bytes not stored because identical content already was.")
+)
+
+// outputDir is the default artifact directory; agents report paths under it
+// relative to it.
+const outputDir = "/output"
+
+// validateArtifactOptions rejects options the agent could not honour.
+func validateArtifactOptions(o *proto.ArtifactOptions) error {
+	if o == nil {
+		return nil
+	}
+	switch o.Delivery {
+	case "", proto.DeliveryInline, proto.DeliveryURL, proto.DeliveryManifest:
+	default:
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("artifacts.delivery must be %q, %q or %q", proto.DeliveryInline, proto.DeliveryURL, proto.DeliveryManifest))
+	}
+	if o.MaxSize < 0 {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "artifacts.max_size must not be negative")
+	}
+	for _, p := range o.WatchPaths {
+		if !path.IsAbs(p) {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "artifacts.watch_paths must be absolute: "+p)
+		}
+	}
+	for _, m := range o.MIMETypes {
+		if m != "*" && !strings.Contains(m, "/") {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "artifacts.mime_types entry is not a MIME type: "+m)
+		}
+	}
+	return nil
+}
+
+// artifactFromParams builds the artifact reported by an agent notification.
+// URL-delivered artifacts carry no data; they get a download link instead.
+// A manifest lists them without either.
+func artifactFromParams(id string, params map[string]any, delivery string) proto.ArtifactEvent {
+	a := proto.ArtifactEvent{}
+	a.Path, _ = params["path"].(string)
+	a.MIME, _ = params["mime"].(string)
+	a.DataBase64, _ = params["data_base64"].(string)
+	if size, ok := params["size"].(float64); ok {
+		a.Size = int64(size)
+	}
+	if delivery == proto.DeliveryURL && a.DataBase64 == "" {
+		p := a.Path
+		if !path.IsAbs(p) {
+			p = path.Join(outputDir, p)
+		}
+		a.URL = fileURL(id, p)
+	}
+	return a
+}
+
+// fileURL is the API path that downloads path from a sandbox.
+func fileURL(id, path string) string {
+	return fmt.Sprintf("/v1/sandbox/%s/files/content?path=%s", id, url.QueryEscape(path))
+}
+
+// storeArtifacts puts inline artifacts into the artifact store, if one is
+// configured, and points their URL at the stored blob.
+func (h *Handler) storeArtifacts(id string, list []proto.ArtifactEvent) {
+	if h.artifacts == nil {
+		return
+	}
+	for i := range list {
+		a := &list[i]
+		if a.DataBase64 == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(a.DataBase64)
+		if err != nil {
+			continue
+		}
+		digest, size, dup, err := h.artifacts.Put(id, bytes.NewReader(data))
+		if err != nil {
+			log.Warn().Err(err).Str("id", id).Str("path", a.Path).Msg("Failed to store artifact")
+			continue
+		}
+		if dup {
+			artifactDedupBytes.Add(float64(size))
+		}
+		a.SHA256 = digest
+		a.Deduplicated = dup
+		if a.URL == "" {
+			a.URL = "/v1/artifacts/" + digest
+		}
+	}
+	h.updateArtifactGauges()
+}
+
+// releaseArtifacts drops the artifact store references of a sandbox that
+// is gone.
+func (h *Handler) releaseArtifacts(id string) {
+	if h.artifacts == nil {
+		return
+	}
+	if freed := h.artifacts.Release(id); freed > 0 {
+		log.Debug().Str("id", id).Int64("bytes", freed).Msg("Released artifacts")
+	}
+	h.updateArtifactGauges()
+}
+
+func (h *Handler) updateArtifactGauges() {
+	st := h.artifacts.Stats()
+	artifactBlobs.Set(float64(st.Blobs))
+	artifactBytes.Set(float64(st.Bytes))
+}
+
+func (h *Handler) artifactStats(c echo.Context) error {
+	if h.artifacts == nil {
+		return newAPIError(http.StatusNotImplemented, CodeNotImplemented, "artifact store is not enabled")
+	}
+	return c.JSON(http.StatusOK, h.artifacts.Stats())
+}
+
+func (h *Handler) getArtifact(c echo.Context) error {
+	if h.artifacts == nil {
+		return newAPIError(http.StatusNotImplemented, CodeNotImplemented, "artifact store is not enabled")
+	}
+	digest := c.Param("digest")
+	r, size, err := h.artifacts.Open(digest)
+	if errors.Is(err, artifacts.ErrNotFound) {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "artifact not found")
+	}
+	if err != nil {
+		return wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to read artifact", err)
+	}
+	defer r.Close()
+
+	// Blobs never change: clients may cache them forever
+	header := c.Response().Header()
+	header.Set("ETag", `"`+digest+`"`)
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	return c.Stream(http.StatusOK, "application/octet-stream", r)
+}
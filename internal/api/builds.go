@@ -0,0 +1,387 @@
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxDockerfileSize bounds a Dockerfile sent apart from the context
+	maxDockerfileSize = 1 << 20
+
+	// buildLogBytes caps the build output kept for the response
+	buildLogBytes = 64 * 1024
+
+	// buildDockerfile is where a Dockerfile sent apart from the context
+	// is added to it
+	buildDockerfile = ".boxed.Dockerfile"
+)
+
+var imageBuildsTotal = metrics.Default.Counter("boxed_image_builds_total",
+	"Image builds through POST /images/build, by result: built, failed or error.", "result")
+
+// BuildImageRequest builds an image from a Dockerfile and saves it as the
+// template "<name>:<tag>", as POST /images/build does.
+type BuildImageRequest struct {
+	// Name, Tag, Init and InitTimeout are those of PublishTemplateRequest
+	Name        string
+	Tag         string
+	Init        string
+	InitTimeout int
+
+	// MemoryMB and CPUCores are the template's resources; the catalog's
+	// defaults if 0
+	MemoryMB int64
+	CPUCores float64
+
+	// Dockerfile is the content of the Dockerfile; if empty, the one at
+	// the root of Context is built
+	Dockerfile string
+
+	// Context is the build context, a tar archive, optionally gzipped;
+	// nil if the Dockerfile copies nothing in
+	Context io.Reader
+
+	// BuildArgs are the values of the Dockerfile's ARGs
+	BuildArgs map[string]string
+}
+
+// BuildImageResponse is the answer to POST /images/build.
+type BuildImageResponse struct {
+	Template TemplateInfo `json:"template"`
+
+	// Log is the output of the build, its last 64 KiB if longer
+	Log string `json:"log"`
+}
+
+// Types of BuildEvent.
+const (
+	BuildEventLog   = "log"
+	BuildEventDone  = "done"
+	BuildEventError = "error"
+)
+
+// BuildEvent is one line of the stream of POST /images/build with "Accept:
+// application/x-ndjson": a "log" event per line of build output, then
+// "done" with the template, or "error".
+type BuildEvent struct {
+	Type     string        `json:"type"`
+	Line     string        `json:"line,omitempty"`
+	Template *TemplateInfo `json:"template,omitempty"`
+	Error    *APIError     `json:"error,omitempty"`
+}
+
+// buildImage serves POST /images/build, a multipart form with the fields of
+// BuildImageRequest: name, tag, init, init_timeout, memory_mb, cpu_cores,
+// dockerfile (a field or a file), context (a file) and build_arg, repeated
+// as KEY=VALUE.
+func (h *Handler) buildImage(c echo.Context) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "request must be a multipart form")
+	}
+	req := BuildImageRequest{
+		Name:       c.FormValue("name"),
+		Tag:        c.FormValue("tag"),
+		Init:       c.FormValue("init"),
+		Dockerfile: c.FormValue("dockerfile"),
+	}
+	if v := c.FormValue("init_timeout"); v != "" {
+		if req.InitTimeout, err = strconv.Atoi(v); err != nil {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "init_timeout must be a number of seconds")
+		}
+	}
+	if v := c.FormValue("memory_mb"); v != "" {
+		if req.MemoryMB, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "memory_mb must be a number")
+		}
+	}
+	if v := c.FormValue("cpu_cores"); v != "" {
+		if req.CPUCores, err = strconv.ParseFloat(v, 64); err != nil {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "cpu_cores must be a number")
+		}
+	}
+	for _, arg := range form.Value["build_arg"] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || k == "" {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("build_arg %q is not KEY=VALUE", arg))
+		}
+		if req.BuildArgs == nil {
+			req.BuildArgs = make(map[string]string)
+		}
+		req.BuildArgs[k] = v
+	}
+	if parts := form.File["dockerfile"]; len(parts) > 0 {
+		if parts[0].Size > maxDockerfileSize {
+			return tooLarge("dockerfile is %d bytes, over the limit of %d", parts[0].Size, maxDockerfileSize)
+		}
+		f, err := parts[0].Open()
+		if err != nil {
+			return fmt.Errorf("failed to read dockerfile: %w", err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read dockerfile: %w", err)
+		}
+		req.Dockerfile = string(data)
+	} else if len(req.Dockerfile) > maxDockerfileSize {
+		return tooLarge("dockerfile is %d bytes, over the limit of %d", len(req.Dockerfile), maxDockerfileSize)
+	}
+	if parts := form.File["context"]; len(parts) > 0 {
+		f, err := parts[0].Open()
+		if err != nil {
+			return fmt.Errorf("failed to read context: %w", err)
+		}
+		defer f.Close()
+		req.Context = f
+	}
+
+	ctx := c.Request().Context()
+	if !acceptsNDJSON(c.Request()) {
+		var out logTail
+		t, err := h.BuildImage(ctx, req, out.add)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, BuildImageResponse{Template: *t, Log: out.String()})
+	}
+
+	res := c.Response()
+	enc := json.NewEncoder(res)
+	write := func(ev BuildEvent) {
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+			res.Header().Set(echo.HeaderCacheControl, "no-cache")
+			res.WriteHeader(http.StatusOK)
+		}
+		if enc.Encode(ev) == nil {
+			res.Flush()
+		}
+	}
+	t, err := h.BuildImage(ctx, req, func(line string) {
+		write(BuildEvent{Type: BuildEventLog, Line: line})
+	})
+	if err != nil {
+		var apiErr *APIError
+		if !res.Committed || !errors.As(err, &apiErr) {
+			return err
+		}
+		write(BuildEvent{Type: BuildEventError, Error: apiErr})
+		return nil
+	}
+	write(BuildEvent{Type: BuildEventDone, Template: t})
+	return nil
+}
+
+// BuildImage builds an image from a Dockerfile and adds a template of it
+// to the catalog, like PublishTemplate does with a sandbox, so that teams
+// get their own environments without access to the container runtime.
+// log, if non-nil, is called with each line of build output. A failing
+// step fails with 422 setup_failed. It is the transport independent core
+// of POST /images/build; errors are *APIError.
+func (h *Handler) BuildImage(ctx context.Context, req BuildImageRequest, log func(line string)) (*TemplateInfo, error) {
+	if req.Tag == "" {
+		req.Tag = "latest"
+	}
+	if err := checkTemplateName(req.Name, req.Tag, req.Init, req.InitTimeout); err != nil {
+		return nil, err
+	}
+	switch {
+	case req.MemoryMB < 0 || req.CPUCores < 0:
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "memory_mb and cpu_cores must not be negative")
+	case req.Dockerfile == "" && req.Context == nil:
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "a dockerfile or a context holding one is required")
+	}
+	ib, ok := h.ids.Backend().(driver.ImageBuilder)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not build images")
+	}
+
+	end := h.activity.begin("build")
+	defer end()
+
+	// The name is held while building, which can take minutes, rather
+	// than publishMu
+	name := req.Name + ":" + req.Tag
+	h.publishMu.Lock()
+	owner, err := h.templateOwner(ctx, name)
+	if err != nil {
+		h.publishMu.Unlock()
+		return nil, err
+	}
+	h.building[name] = true
+	h.publishMu.Unlock()
+	defer func() {
+		h.publishMu.Lock()
+		delete(h.building, name)
+		h.publishMu.Unlock()
+	}()
+
+	t := templates.Template{
+		Name:        name,
+		Image:       templateImageRepo + name,
+		MemoryMB:    req.MemoryMB,
+		CPUCores:    req.CPUCores,
+		Init:        req.Init,
+		InitTimeout: time.Duration(req.InitTimeout) * time.Second,
+		Owner:       owner,
+	}
+	buildCtx, dockerfile, contextErr := buildContext(req.Context, req.Dockerfile)
+	var out logTail
+	err = ib.BuildImage(ctx, t.Image, driver.ImageBuild{
+		Context:    buildCtx,
+		Dockerfile: dockerfile,
+		BuildArgs:  req.BuildArgs,
+		Labels:     map[string]string{TemplateLabel: name},
+	}, func(line string) {
+		out.add(line)
+		if log != nil {
+			log(line)
+		}
+	})
+	if cerr := contextErr(); cerr != nil {
+		imageBuildsTotal.Inc("error")
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, cerr.Error())
+	}
+	if errors.Is(err, driver.ErrBuildFailed) {
+		imageBuildsTotal.Inc("failed")
+		// The reason follows the output of the failed step
+		return nil, wrapAPIError(http.StatusUnprocessableEntity, CodeSetupFailed,
+			fmt.Sprintf("building %s failed: %s", name, outputTail(out.String()+err.Error(), "")), err)
+	}
+	if err != nil {
+		imageBuildsTotal.Inc("error")
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to build %s: %v", t.Image, err)
+		return nil, apiErr
+	}
+	imageBuildsTotal.Inc("built")
+
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+	t, err = h.templates.Publish(t)
+	if errors.Is(err, templates.ErrDefined) {
+		return nil, wrapAPIError(http.StatusConflict, CodeConflict, err.Error(), err)
+	}
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, err.Error(), err)
+	}
+	audit(ctx, "", "Built template "+name)
+	resp := templateInfo(t, h.templates.Default().Name)
+	return &resp, nil
+}
+
+// errInvalidContext is the error of a build context that is not a tar
+// archive.
+var errInvalidContext = errors.New("context is not a tar archive, optionally gzipped")
+
+// buildContext returns the context to build, with dockerfile added to src
+// if given, and the path of the Dockerfile in it ("" for the default). The
+// function returned reports whether src was not a tar archive, once the
+// build is over.
+func buildContext(src io.Reader, dockerfile string) (io.Reader, string, func() error) {
+	if dockerfile == "" {
+		return src, "", func() error { return nil }
+	}
+	pr, pw := io.Pipe()
+	var contextErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tw := tar.NewWriter(pw)
+		if src != nil {
+			if err := copyContext(tw, src); err != nil {
+				if errors.Is(err, errInvalidContext) {
+					contextErr = err
+				}
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		err := tw.WriteHeader(&tar.Header{Name: buildDockerfile, Mode: 0o644, Size: int64(len(dockerfile)), ModTime: time.Now()})
+		if err == nil {
+			_, err = io.WriteString(tw, dockerfile)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, buildDockerfile, func() error {
+		// The build may have stopped reading early
+		pr.Close()
+		<-done
+		return contextErr
+	}
+}
+
+// copyContext copies the entries of the tar archive r, which may be
+// gzipped, to tw, but for any at buildDockerfile.
+func copyContext(tw *tar.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidContext, err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidContext, err)
+		}
+		if strings.TrimPrefix(hdr.Name, "./") == buildDockerfile {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// logTail keeps the last buildLogBytes of build output.
+type logTail struct {
+	buf []byte
+}
+
+func (t *logTail) add(line string) {
+	t.buf = append(append(t.buf, line...), '\n')
+	if len(t.buf) > 2*buildLogBytes {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-buildLogBytes:]...)
+	}
+}
+
+func (t *logTail) String() string {
+	if len(t.buf) > buildLogBytes {
+		return string(t.buf[len(t.buf)-buildLogBytes:])
+	}
+	return string(t.buf)
+}
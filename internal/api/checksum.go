@@ -0,0 +1,42 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ContentSHA256Header carries the hex SHA-256 digest of a file: the one an
+// upload of a single file must match, or that of a downloaded file, as a
+// header or, when the file is streamed, a trailer.
+const ContentSHA256Header = "X-Boxed-Content-SHA256"
+
+// sha256Hex returns the hex SHA-256 digest of what r reads.
+func sha256Hex(r io.Reader) (string, error) {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// parseSHA256 checks that s is a hex SHA-256 digest, and returns it in
+// lower case.
+func parseSHA256(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("sha256 %q is not a hex SHA-256 digest", s))
+	}
+	return s, nil
+}
+
+// checksumMismatch is the error of content that does not match the digest
+// it was sent with.
+func checksumMismatch(path, want, got string) *APIError {
+	return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+		fmt.Sprintf("sha256 of %s is %s, not %s", path, got, want))
+}
@@ -0,0 +1,374 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// ForwardedHeader marks requests a node proxied to the node serving their
+// sandbox, with the forwarding node's ID. They are never forwarded again.
+const ForwardedHeader = "X-Boxed-Forwarded-By"
+
+// Cluster defaults.
+const (
+	// DefaultHeartbeatInterval is how often nodes refresh their entry
+	DefaultHeartbeatInterval = 5 * time.Second
+
+	// DefaultNodeTimeout is how long after its last heartbeat a node is
+	// considered dead and its sandboxes taken over
+	DefaultNodeTimeout = 30 * time.Second
+)
+
+// ClusterConfig makes a Handler one node of several sharing a state store
+// that implements state.NodeRegistry.
+type ClusterConfig struct {
+	// NodeID identifies the node; it must be the instance ID of its driver,
+	// which labels its sandboxes, so that other nodes can adopt them
+	NodeID string
+
+	// URL is where the other nodes reach this node's API
+	URL string
+
+	HeartbeatInterval time.Duration
+	NodeTimeout       time.Duration
+}
+
+// WithStore keeps the control plane's records in s instead of memory.
+func WithStore(s state.Store) Option {
+	return func(h *Handler) {
+		h.store = s
+	}
+}
+
+// WithCluster runs the handler as a node of a cluster. Requests for a
+// sandbox another node serves are proxied to it, and the sandboxes of nodes
+// that stop heartbeating are taken over. It needs a store shared by the
+// nodes (see WithStore) that implements state.NodeRegistry.
+func WithCluster(cfg ClusterConfig) Option {
+	return func(h *Handler) {
+		if cfg.HeartbeatInterval <= 0 {
+			cfg.HeartbeatInterval = DefaultHeartbeatInterval
+		}
+		if cfg.NodeTimeout <= 0 {
+			cfg.NodeTimeout = DefaultNodeTimeout
+		}
+		h.cluster = &cluster{ClusterConfig: cfg, startedAt: time.Now(), done: make(chan struct{})}
+	}
+}
+
+// cluster is the node's view of the other nodes.
+type cluster struct {
+	ClusterConfig
+	registry  state.NodeRegistry
+	startedAt time.Time
+
+	// done stops the heartbeat when the node drains
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NodeInfo describes a node of the cluster.
+type NodeInfo struct {
+	state.Node
+	// Alive is false once the node missed heartbeats for the node timeout
+	Alive bool `json:"alive"`
+	// Self is true for the node answering
+	Self bool `json:"self"`
+	// Sandboxes is the number of live sandboxes the node serves
+	Sandboxes int `json:"sandboxes"`
+}
+
+// startCluster registers the node and starts heartbeating.
+func (h *Handler) startCluster() {
+	registry, ok := h.store.(state.NodeRegistry)
+	if !ok {
+		log.Error().Msg("The state store cannot be shared: running as a single node")
+		h.cluster = nil
+		return
+	}
+	h.cluster.registry = registry
+	h.heartbeat()
+	go func() {
+		ticker := time.NewTicker(h.cluster.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.cluster.done:
+				return
+			case <-ticker.C:
+				h.heartbeat()
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops the node heartbeating, so that the others take its
+// sandboxes over once it is gone.
+func (h *Handler) stopHeartbeat() {
+	if h.cluster != nil {
+		h.cluster.stopOnce.Do(func() { close(h.cluster.done) })
+	}
+}
+
+// heartbeat refreshes the node's entry, then takes over the nodes that
+// stopped heartbeating.
+func (h *Handler) heartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cluster.HeartbeatInterval)
+	defer cancel()
+	now := time.Now()
+	err := h.cluster.registry.Heartbeat(ctx, state.Node{
+		ID:          h.cluster.NodeID,
+		URL:         h.cluster.URL,
+		StartedAt:   h.cluster.startedAt,
+		HeartbeatAt: now,
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to send heartbeat")
+		return
+	}
+	nodes, err := h.cluster.registry.ListNodes(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list nodes")
+		return
+	}
+	for _, n := range nodes {
+		if n.ID != h.cluster.NodeID && !h.alive(n, now) {
+			h.takeOver(n.ID, now.Add(-h.cluster.NodeTimeout))
+		}
+	}
+}
+
+func (h *Handler) alive(n state.Node, now time.Time) bool {
+	return now.Sub(n.HeartbeatAt) < h.cluster.NodeTimeout
+}
+
+// takeOver moves the sandboxes of a dead node to this one. The driver
+// adopts those it can reach, e.g. containers on a Docker host both nodes
+// use; the others are recorded as failed. Sessions, jobs and uploads in
+// progress on the dead node are lost.
+func (h *Handler) takeOver(dead string, staleBefore time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if removed, err := h.cluster.registry.RemoveNode(ctx, dead, staleBefore); err != nil || !removed {
+		// Another node is taking over, or it came back
+		return
+	}
+	log.Warn().Str("node", dead).Msg("Node stopped heartbeating; taking over its sandboxes")
+
+	if a, ok := h.driver.(driver.Adopter); ok {
+		if _, err := a.Adopt(ctx, dead); err != nil && !errors.Is(err, driver.ErrNotImplemented) {
+			log.Warn().Err(err).Str("node", dead).Msg("Failed to adopt sandboxes")
+		}
+	}
+	records, err := h.store.ListSandboxes(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sandboxes to take over")
+		return
+	}
+	taken, lost := 0, 0
+	for _, rec := range records {
+		if rec.Node != dead || (rec.State != state.SandboxReady && rec.State != state.SandboxCreating) {
+			continue
+		}
+		at := time.Now()
+		if _, err := h.driver.Info(ctx, rec.ID); err == nil && rec.State == state.SandboxReady {
+			rec.Node = h.cluster.NodeID
+			h.store.PutSandbox(ctx, rec)
+			h.recordEvent(rec.ID, state.EventTakenOver, at, "from node "+dead, nil)
+			taken++
+			continue
+		}
+		rec.State = state.SandboxFailed
+		rec.Error = fmt.Sprintf("node %s stopped and the sandbox could not be taken over", dead)
+		h.recordEvent(rec.ID, state.EventFailed, at, "", fmt.Errorf("%s", rec.Error))
+		h.store.PutSandbox(ctx, rec)
+		h.publish(LifecycleEvent{Type: LifecycleErrored, SandboxID: rec.ID, Labels: rec.Labels, Error: rec.Error})
+		h.store.DeleteSandbox(ctx, rec.ID)
+		lost++
+	}
+	log.Info().Str("node", dead).Int("taken_over", taken).Int("lost", lost).Msg("Took over node")
+}
+
+// servedHere reports whether this node serves the sandbox of rec. Records
+// of stopped and failed sandboxes are served by any node.
+func (h *Handler) servedHere(rec state.SandboxRecord) bool {
+	return h.cluster == nil || rec.Node == "" || rec.Node == h.cluster.NodeID ||
+		(rec.State != state.SandboxReady && rec.State != state.SandboxCreating)
+}
+
+// routeSandbox proxies requests for a sandbox another node serves to that
+// node. It runs after resolveSandbox, which consults the shared store, so
+// abbreviated IDs work on every node.
+func (h *Handler) routeSandbox(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Param("id")
+		if h.cluster == nil || id == "" || c.Request().Header.Get(ForwardedHeader) != "" {
+			return next(c)
+		}
+		ctx := c.Request().Context()
+		rec, err := h.store.GetSandbox(ctx, id)
+		if err != nil || h.servedHere(rec) {
+			return next(c)
+		}
+		node, err := h.node(ctx, rec.Node)
+		if err != nil {
+			return err
+		}
+		return h.forward(c, node)
+	}
+}
+
+// node returns a live node by ID.
+func (h *Handler) node(ctx context.Context, id string) (*state.Node, error) {
+	nodes, err := h.cluster.registry.ListNodes(ctx)
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list nodes", err)
+	}
+	for _, n := range nodes {
+		if n.ID == id && h.alive(n, time.Now()) {
+			return &n, nil
+		}
+	}
+	return nil, newAPIError(http.StatusServiceUnavailable, CodeUnavailable,
+		fmt.Sprintf("node %s serving the sandbox is unavailable; another node takes it over shortly", id))
+}
+
+// forward proxies the request to node, streams and WebSockets included.
+func (h *Handler) forward(c echo.Context, node *state.Node) error {
+	target, err := url.Parse(node.URL)
+	if err != nil {
+		return wrapAPIError(http.StatusInternalServerError, CodeInternal, "invalid node URL", err)
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Header.Set(ForwardedHeader, h.cluster.NodeID)
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Warn().Err(err).Str("node", node.ID).Msg("Failed to forward request")
+			w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(newAPIError(http.StatusBadGateway, CodeUnavailable, "node "+node.ID+" is unreachable"))
+		},
+	}
+	proxy.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// fanOut sends the request to every other live node and decodes their
+// answers; nodes that fail are logged and skipped.
+func fanOut[T any](h *Handler, c echo.Context) []T {
+	if h.cluster == nil || c.Request().Header.Get(ForwardedHeader) != "" {
+		return nil
+	}
+	ctx := c.Request().Context()
+	nodes, err := h.cluster.registry.ListNodes(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list nodes")
+		return nil
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []T
+	)
+	now := time.Now()
+	for _, n := range nodes {
+		if n.ID == h.cluster.NodeID || !h.alive(n, now) {
+			continue
+		}
+		wg.Add(1)
+		go func(n state.Node) {
+			defer wg.Done()
+			var v T
+			if err := h.ask(ctx, n, c.Request(), &v); err != nil {
+				log.Warn().Err(err).Str("node", n.ID).Msg("Node did not answer")
+				return
+			}
+			mu.Lock()
+			results = append(results, v)
+			mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	return results
+}
+
+// ask replays a bodiless request on another node.
+func (h *Handler) ask(ctx context.Context, n state.Node, in *http.Request, out any) error {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return err
+	}
+	u.Path, u.RawQuery = in.URL.Path, in.URL.RawQuery
+	req, err := http.NewRequestWithContext(ctx, in.Method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = in.Header.Clone()
+	req.Header.Set(ForwardedHeader, h.cluster.NodeID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Nodes lists the nodes of the cluster, or only this one when it runs
+// alone. It is the transport independent core of GET /cluster/nodes.
+func (h *Handler) Nodes(ctx context.Context) ([]NodeInfo, error) {
+	if h.cluster == nil {
+		return []NodeInfo{}, nil
+	}
+	nodes, err := h.cluster.registry.ListNodes(ctx)
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list nodes", err)
+	}
+	records, err := h.store.ListSandboxes(ctx)
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list sandboxes", err)
+	}
+	counts := make(map[string]int)
+	for _, rec := range records {
+		if rec.State == state.SandboxReady || rec.State == state.SandboxCreating {
+			counts[rec.Node]++
+		}
+	}
+	now := time.Now()
+	infos := make([]NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		infos = append(infos, NodeInfo{
+			Node:      n,
+			Alive:     h.alive(n, now),
+			Self:      n.ID == h.cluster.NodeID,
+			Sandboxes: counts[n.ID],
+		})
+	}
+	return infos, nil
+}
+
+func (h *Handler) listNodes(c echo.Context) error {
+	nodes, err := h.Nodes(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"nodes": nodes})
+}
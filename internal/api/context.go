@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// DefaultMaxContextFileSize and DefaultMaxContextSize bound the decoded size
+// of each context file of a create, and of all of them, when no limits are
+// configured.
+const (
+	DefaultMaxContextFileSize = 64 << 20
+	DefaultMaxContextSize     = 256 << 20
+)
+
+// ContextReport describes the context files injected into a sandbox.
+type ContextReport struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+
+	// DurationMs is how long the driver took to create the sandbox,
+	// injection included
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// checkContext validates the context files of a create against the
+// server's limits before anything is created, so that a bad or oversized
+// file fails the request instead of stalling it. It returns the files'
+// total decoded size.
+func (h *Handler) checkContext(files []driver.FileInjection) (int64, error) {
+	var total int64
+	for _, file := range files {
+		size, err := file.Size()
+		if err != nil {
+			return 0, driverError(err)
+		}
+		if size > int64(h.maxContextFileSize) {
+			return 0, newAPIError(http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+				fmt.Sprintf("context file %s is %d bytes, over the limit of %d", file.Path, size, h.maxContextFileSize))
+		}
+		total += size
+	}
+	if total > int64(h.maxContextSize) {
+		return 0, newAPIError(http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+			fmt.Sprintf("context files total %d bytes, over the limit of %d", total, h.maxContextSize))
+	}
+	return total, nil
+}
+
+// contextReport describes files once created in took.
+func contextReport(files []driver.FileInjection, bytes int64, took time.Duration) *ContextReport {
+	if len(files) == 0 {
+		return nil
+	}
+	return &ContextReport{Files: len(files), Bytes: bytes, DurationMs: took.Milliseconds()}
+}
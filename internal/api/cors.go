@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// DefaultCORSHeaders are the request headers browsers may send when a
+// CORSPolicy sets none.
+var DefaultCORSHeaders = []string{
+	echo.HeaderAuthorization, echo.HeaderContentType, echo.HeaderAccept,
+	"Range", "X-Boxed-API-Key", UploadOffsetHeader,
+}
+
+// corsExposedHeaders are the response headers of the API browser scripts
+// may read.
+var corsExposedHeaders = []string{
+	SessionHeader, JobHeader, UploadOffsetHeader,
+	echo.HeaderContentDisposition, "Content-Range", "Accept-Ranges",
+}
+
+// CORSPolicy lets web consoles served from other origins call the API and
+// open its WebSockets; see WithCORS.
+type CORSPolicy struct {
+	// AllowedOrigins are origins such as https://console.example.com;
+	// https://*.example.com allows the subdomains of example.com and *
+	// every origin
+	AllowedOrigins []string
+
+	// AllowedHeaders are the request headers browsers may send; empty
+	// means DefaultCORSHeaders
+	AllowedHeaders []string
+
+	// AllowCredentials lets browsers send cookies and HTTP authentication;
+	// it cannot be combined with the origin *
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight; zero leaves it to
+	// them
+	MaxAge time.Duration
+}
+
+// WithCORS answers the cross-origin requests of p's origins, and accepts
+// their WebSockets. Without it the API sends no CORS headers, and only
+// same-origin and localhost pages may open WebSockets.
+func WithCORS(p CORSPolicy) Option {
+	return func(h *Handler) {
+		h.cors = p
+	}
+}
+
+// CheckCORS reports origins that are not a scheme and host, or *, and
+// credentials allowed to every origin.
+func CheckCORS(p CORSPolicy) error {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("credentials cannot be allowed to every origin")
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(o, "*.", "", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("origin %q is not an http or https scheme and host", o)
+		}
+		if strings.Contains(strings.Replace(o, "://*.", "://", 1), "*") {
+			return fmt.Errorf("origin %q: only a leading *. of the host can be a wildcard", o)
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	return nil
+}
+
+// allows reports whether pages of origin may call the API.
+func (p CORSPolicy) allows(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(o, "://*.")
+		if !ok {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err == nil && strings.EqualFold(u.Scheme, scheme) && strings.HasSuffix(strings.ToLower(u.Host), "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware answers preflights and sets the CORS headers of the
+// requests of allowed origins. It runs before routing, so that preflights,
+// which carry no credentials, are answered without authentication.
+func (h *Handler) corsMiddleware() echo.MiddlewareFunc {
+	headers := h.cors.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSHeaders
+	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			return h.cors.allows(origin), nil
+		},
+		AllowMethods: []string{
+			http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodPatch, http.MethodDelete,
+		},
+		AllowHeaders:     headers,
+		AllowCredentials: h.cors.AllowCredentials,
+		ExposeHeaders:    corsExposedHeaders,
+		MaxAge:           int(h.cors.MaxAge / time.Second),
+	})
+}
+
+// upgrader upgrades the WebSockets of the API. Browsers always send an
+// Origin, so pages of other origins are refused unless the CORS policy
+// allows them; clients that are not browsers send none.
+func (h *Handler) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get(echo.HeaderOrigin)
+			if origin == "" {
+				return true
+			}
+			if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+				return true
+			}
+			if len(h.cors.AllowedOrigins) == 0 {
+				return strings.HasPrefix(origin, "http://localhost") || strings.HasPrefix(origin, "https://localhost")
+			}
+			return h.cors.allows(origin)
+		},
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// DescriptorPath is where every sandbox finds its own descriptor.
+const DescriptorPath = "/run/boxed/descriptor.json"
+
+// execLanguages lists the exec languages each interpreter serves.
+var execLanguages = map[string][]string{
+	"python3": {"python", LanguagePythonSession},
+	"node":    {"javascript", "node"},
+	"bash":    {"bash", "sh", LanguageBashSession},
+}
+
+// Descriptor tells an agent what its sandbox offers, so it can be prompted
+// with facts rather than guess.
+type Descriptor struct {
+	SandboxID string `json:"sandbox_id"`
+	Driver    string `json:"driver"`
+	Image     string `json:"image"`
+	OS        string `json:"os,omitempty"`
+
+	Interpreters []DescriptorInterpreter `json:"interpreters"`
+	Packages     []driver.Package        `json:"packages"`
+
+	Limits  DescriptorLimits     `json:"limits"`
+	Network driver.NetworkPolicy `json:"network"`
+
+	// WorkDir is where code runs; WritablePaths are meant for writing,
+	// files written to /output are returned as artifacts
+	WorkDir       string   `json:"work_dir"`
+	WritablePaths []string `json:"writable_paths"`
+
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// DescriptorInterpreter is an interpreter usable through exec.
+type DescriptorInterpreter struct {
+	// Languages are the exec "language" values that run it
+	Languages []string `json:"languages"`
+	Command   string   `json:"command"`
+	Version   string   `json:"version,omitempty"`
+}
+
+type DescriptorLimits struct {
+	CPUCores float64 `json:"cpu_cores"`
+	MemoryMB int64   `json:"memory_mb"`
+
+	// MaxOutputBytes is the stdout and stderr kept per exec
+	MaxOutputBytes int `json:"max_output_bytes"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (h *Handler) getDescriptor(c echo.Context) error {
+	desc, err := h.Describe(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, desc)
+}
+
+// Describe probes a sandbox and builds its descriptor. It is the transport
+// independent core of GET /sandbox/:id/descriptor; errors are *APIError.
+func (h *Handler) Describe(ctx context.Context, id string) (*Descriptor, error) {
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.GetSandbox(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if info.State != driver.StateReady {
+		return nil, driverError(driver.ErrSandboxNotRunning)
+	}
+
+	cfg := info.Config
+	desc := &Descriptor{
+		SandboxID:    id,
+		Driver:       info.DriverType,
+		Image:        cfg.Image,
+		Interpreters: []DescriptorInterpreter{},
+		Packages:     []driver.Package{},
+		Limits: DescriptorLimits{
+			CPUCores:       cfg.CPUCores,
+			MemoryMB:       cfg.MemoryMB,
+			MaxOutputBytes: h.maxOutput,
+			ExpiresAt:      info.ExpiresAt,
+		},
+		Network:       cfg.NetworkPolicy,
+		WorkDir:       cfg.WorkDir,
+		WritablePaths: []string{cfg.WorkDir, outputDir, "/tmp"},
+		GeneratedAt:   time.Now().UTC(),
+	}
+
+	// Without a probe the descriptor still carries the configuration
+	if d, ok := h.driver.(driver.Describer); ok {
+		env, err := d.Describe(ctx, id)
+		if err != nil {
+			return nil, driverError(err)
+		}
+		desc.OS = env.OS
+		desc.Packages = env.Packages
+		for _, in := range env.Interpreters {
+			if langs, ok := execLanguages[in.Command]; ok {
+				desc.Interpreters = append(desc.Interpreters, DescriptorInterpreter{
+					Languages: langs,
+					Command:   in.Command,
+					Version:   in.Version,
+				})
+			}
+		}
+	}
+	return desc, nil
+}
+
+// writeDescriptor puts the descriptor of a new sandbox at DescriptorPath.
+// Failures are logged: the sandbox is usable without it.
+func (h *Handler) writeDescriptor(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	desc, err := h.Describe(ctx, id)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(desc, "", "  ")
+		if err == nil {
+			err = h.driver.PutFile(ctx, id, DescriptorPath, bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to write sandbox descriptor")
+	}
+}
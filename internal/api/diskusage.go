@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"path"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// maxUsagePaths bounds the directories a single usage request measures.
+const maxUsagePaths = 16
+
+// defaultUsagePaths are the directories measured when none are asked for:
+// where agents work and where they leave their output.
+var defaultUsagePaths = []string{"/workspace", "/output"}
+
+// FilesUsageResponse is the space the files of a sandbox take.
+type FilesUsageResponse struct {
+	SandboxID string `json:"sandbox_id"`
+
+	// UsedBytes is the sum of the directories' usage
+	UsedBytes int64             `json:"used_bytes"`
+	Paths     []driver.DirUsage `json:"paths"`
+}
+
+// getFilesUsage serves GET /sandbox/:id/files/usage, measuring each
+// repeated "path" query parameter, or /workspace and /output without one.
+func (h *Handler) getFilesUsage(c echo.Context) error {
+	resp, err := h.FilesUsage(c.Request().Context(), c.Param("id"), c.QueryParams()["path"])
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// FilesUsage returns the bytes and files under dirs, absolute paths in the
+// sandbox, so that agents can clean up before writes start failing. Where
+// a directory is on a filesystem of its own, such as the /output tmpfs,
+// its size and what is left of it come too. It is the transport
+// independent core of GET /sandbox/:id/files/usage; errors are *APIError.
+func (h *Handler) FilesUsage(ctx context.Context, id string, dirs []string) (*FilesUsageResponse, error) {
+	ur, ok := h.driver.(driver.DiskUsageReader)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not report disk usage")
+	}
+	if len(dirs) == 0 {
+		dirs = defaultUsagePaths
+	}
+	if len(dirs) > maxUsagePaths {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "too many paths")
+	}
+	cleaned := make([]string, len(dirs))
+	for i, dir := range dirs {
+		if !path.IsAbs(dir) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "path must be absolute")
+		}
+		cleaned[i] = path.Clean(dir)
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := ur.DiskUsage(ctx, id, cleaned)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	resp := &FilesUsageResponse{SandboxID: id, Paths: usage}
+	for _, u := range usage {
+		resp.UsedBytes += u.UsedBytes
+	}
+	return resp, nil
+}
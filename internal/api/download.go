@@ -0,0 +1,352 @@
+package api
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultDownloadStallTimeout is how long a file download may make no
+// progress, neither reading the file nor sending to the client, before it
+// is given up.
+const DefaultDownloadStallTimeout = time.Minute
+
+// Results of downloads in boxed_file_downloads_total.
+const (
+	downloadComplete = "complete"
+	// downloadPartial ended early: the client went away or the file could
+	// not be read to its end
+	downloadPartial = "partial"
+	// downloadStalled was given up after DefaultDownloadStallTimeout
+	downloadStalled = "stalled"
+)
+
+var (
+	downloadsTotal = metrics.Default.Counter("boxed_file_downloads_total",
+		"File downloads by result: complete, partial or stalled.", "result")
+	downloadBytes = metrics.Default.Counter("boxed_file_download_bytes_total",
+		"Bytes of files sent to clients, by the result of their download.", "result")
+)
+
+// WithDownloadStallTimeout gives up file downloads that make no progress
+// for d: the file is closed, releasing the driver's copy, and the write the
+// client does not take fails. Zero keeps DefaultDownloadStallTimeout;
+// negative values never give up.
+func WithDownloadStallTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		if d != 0 {
+			h.downloadStall = max(d, 0)
+		}
+	}
+}
+
+// downloadFile serves GET and HEAD /sandbox/:id/files/content. Drivers whose
+// reader knows the file's size (see driver.Driver.GetFile) get Content-Length
+// and Range support; others are streamed whole.
+//
+// With checksum=true the SHA-256 digest of the whole file is sent in
+// ContentSHA256Header. Files that can be read twice are hashed first and
+// keep Range support; others are streamed whole, the digest following in a
+// trailer.
+func (h *Handler) downloadFile(c echo.Context) error {
+	id := c.Param("id")
+	p := c.QueryParam("path")
+	if p == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "path required")
+	}
+	checksum, _ := strconv.ParseBool(c.QueryParam("checksum"))
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	started := time.Now()
+	content, err := h.driver.GetFile(ctx, id, p)
+	h.recordEvent(id, state.EventFileDownload, started, p, err)
+	if err != nil {
+		return driverError(err)
+	}
+	defer content.Close()
+
+	header := c.Response().Header()
+	info, sized := statFile(content)
+	seeker, seekable := content.(io.ReadSeeker)
+	if checksum && sized && seekable {
+		sum, err := sha256Hex(seeker)
+		if err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to checksum %s: %w", p, err)
+		}
+		header.Set(ContentSHA256Header, sum)
+	}
+
+	guard := h.guardDownload(ctx, cancel, c, content)
+	defer guard.finish(id, p)
+
+	name := path.Base(p)
+	br := bufio.NewReader(guard)
+	header.Set(echo.HeaderContentType, contentType(name, br))
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+
+	if !sized || (checksum && !seekable) {
+		header.Set("Accept-Ranges", "none")
+		if c.Request().Method == http.MethodHead {
+			return c.NoContent(http.StatusOK)
+		}
+		if !checksum {
+			return c.Stream(http.StatusOK, header.Get(echo.HeaderContentType), br)
+		}
+		header.Set("Trailer", ContentSHA256Header)
+		sum := sha256.New()
+		if err := c.Stream(http.StatusOK, header.Get(echo.HeaderContentType), io.TeeReader(br, sum)); err != nil {
+			// Without the trailer the client cannot mistake what it got
+			// for the whole file
+			return err
+		}
+		header.Set(ContentSHA256Header, hex.EncodeToString(sum.Sum(nil)))
+		return nil
+	}
+
+	var body io.ReadSeeker
+	if seekable {
+		// Nothing was consumed by the sniffing above: it peeks
+		body = seekBuffered{seeker, guard, br}
+	} else {
+		body = &forwardSeeker{r: br, size: info.Size()}
+		// Ranges in a multi-range request may go backwards
+		if strings.Contains(c.Request().Header.Get("Range"), ",") {
+			c.Request().Header.Del("Range")
+		}
+	}
+	http.ServeContent(c.Response(), c.Request(), name, info.ModTime(), body)
+	return nil
+}
+
+// downloadGuard reads the file of a download and watches the response
+// sending it. When neither makes progress for the handler's downloadStall,
+// it cancels the download's context, which the driver reads the file with,
+// closes the file and times out the write the client is stuck on, so that
+// a stalled client does not hold the driver's copy open. It also counts
+// the bytes sent for boxed_file_download_bytes_total.
+type downloadGuard struct {
+	ctx     context.Context
+	c       echo.Context
+	content io.ReadCloser
+	cancel  context.CancelFunc
+	rc      *http.ResponseController
+	w       http.ResponseWriter
+	stall   time.Duration
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	sent    int64
+	err     error
+	stalled bool
+}
+
+func (h *Handler) guardDownload(ctx context.Context, cancel context.CancelFunc, c echo.Context, content io.ReadCloser) *downloadGuard {
+	res := c.Response()
+	g := &downloadGuard{
+		ctx:     ctx,
+		c:       c,
+		content: content,
+		cancel:  cancel,
+		rc:      http.NewResponseController(res.Writer),
+		w:       res.Writer,
+		stall:   h.downloadStall,
+	}
+	res.Writer = guardedWriter{g}
+	if g.stall > 0 {
+		g.timer = time.AfterFunc(g.stall, g.giveUp)
+	}
+	return g
+}
+
+// giveUp ends a stalled download.
+func (g *downloadGuard) giveUp() {
+	g.mu.Lock()
+	g.stalled = true
+	g.mu.Unlock()
+	g.cancel()
+	g.content.Close()
+	g.rc.SetWriteDeadline(time.Now())
+}
+
+// progress restarts the stall timer, and records err unless it is io.EOF.
+func (g *downloadGuard) progress(n int, err error) {
+	if g.timer != nil && n > 0 {
+		g.timer.Reset(g.stall)
+	}
+	if err != nil && err != io.EOF {
+		g.mu.Lock()
+		g.err = cmp.Or(g.err, err)
+		g.mu.Unlock()
+	}
+}
+
+// Read reads the file, failing once the download was given up.
+func (g *downloadGuard) Read(p []byte) (int, error) {
+	if err := g.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := g.content.Read(p)
+	g.progress(n, err)
+	return n, err
+}
+
+// finish stops the stall timer, clears the write deadline it may have set
+// for the next request on the connection, and counts the download.
+func (g *downloadGuard) finish(id, p string) {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.mu.Lock()
+	sent, err, stalled := g.sent, g.err, g.stalled
+	g.mu.Unlock()
+	if stalled {
+		// The connection is unusable after the failed write
+		g.rc.SetWriteDeadline(time.Now())
+	} else {
+		g.rc.SetWriteDeadline(time.Time{})
+	}
+	if g.c.Request().Method == http.MethodHead {
+		return
+	}
+
+	result := downloadComplete
+	if want, perr := strconv.ParseInt(g.w.Header().Get(echo.HeaderContentLength), 10, 64); perr == nil && sent < want {
+		result = downloadPartial
+	}
+	switch {
+	case stalled:
+		result = downloadStalled
+		log.Warn().Str("id", id).Str("path", p).Int64("sent", sent).Dur("stall", g.stall).
+			Msg("File download stalled; giving up")
+	case err != nil:
+		result = downloadPartial
+	}
+	downloadsTotal.Inc(result)
+	downloadBytes.Add(float64(sent), result)
+}
+
+// guardedWriter is the response writer of a guarded download.
+type guardedWriter struct {
+	g *downloadGuard
+}
+
+func (w guardedWriter) Header() http.Header {
+	return w.g.w.Header()
+}
+
+func (w guardedWriter) WriteHeader(code int) {
+	w.g.w.WriteHeader(code)
+}
+
+func (w guardedWriter) Write(p []byte) (int, error) {
+	n, err := w.g.w.Write(p)
+	w.g.mu.Lock()
+	w.g.sent += int64(n)
+	w.g.mu.Unlock()
+	w.g.progress(n, err)
+	return n, err
+}
+
+func (w guardedWriter) Unwrap() http.ResponseWriter {
+	return w.g.w
+}
+
+// contentType guesses the MIME type from the file name, falling back to
+// sniffing the first bytes of br.
+func contentType(name string, br *bufio.Reader) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	head, _ := br.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// statFile returns the file info of content if it is a regular file of
+// known size.
+func statFile(content io.Reader) (fs.FileInfo, bool) {
+	f, ok := content.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		return nil, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
+
+// seekBuffered reads through a bufio.Reader wrapping r, which reads the
+// seekable file f; a seek goes to the file and drops what was buffered.
+type seekBuffered struct {
+	f  io.Seeker
+	r  io.Reader
+	br *bufio.Reader
+}
+
+func (s seekBuffered) Read(p []byte) (int, error) {
+	return s.br.Read(p)
+}
+
+func (s seekBuffered) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset -= int64(s.br.Buffered())
+	}
+	n, err := s.f.Seek(offset, whence)
+	s.br.Reset(s.r)
+	return n, err
+}
+
+// forwardSeeker lets http.ServeContent serve one range of a stream: seeking
+// forward skips bytes, seeking to the end only reports the size.
+type forwardSeeker struct {
+	r    io.Reader
+	size int64
+	pos  int64
+}
+
+var errSeekBackwards = errors.New("cannot seek backwards in a stream")
+
+func (s *forwardSeeker) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *forwardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		// http.ServeContent asks for the size and then seeks back to
+		// where it was; report the size without moving
+		return s.size + offset, nil
+	}
+	if offset < s.pos {
+		return s.pos, errSeekBackwards
+	}
+	n, err := io.CopyN(io.Discard, s.r, offset-s.pos)
+	s.pos += n
+	return s.pos, err
+}
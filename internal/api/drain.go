@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// activity counts in-flight creates, execs, jobs and interactive sessions so
+// a drain can wait for them.
+type activity struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight map[string]int
+	// idle is closed when the last operation ends during a drain
+	idle chan struct{}
+}
+
+func newActivity() *activity {
+	return &activity{inFlight: make(map[string]int), idle: make(chan struct{})}
+}
+
+// begin records an operation of the given kind; the returned func ends it.
+func (a *activity) begin(kind string) (end func()) {
+	a.mu.Lock()
+	a.inFlight[kind]++
+	a.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.inFlight[kind]--
+			if a.draining && a.total() == 0 {
+				close(a.idle)
+			}
+		})
+	}
+}
+
+// total must be called with a.mu held.
+func (a *activity) total() int {
+	n := 0
+	for _, c := range a.inFlight {
+		n += c
+	}
+	return n
+}
+
+// drain stops new creates and returns a channel closed once nothing is in
+// flight.
+func (a *activity) drain() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.draining {
+		a.draining = true
+		if a.total() == 0 {
+			close(a.idle)
+		}
+	}
+	return a.idle
+}
+
+func (a *activity) isDraining() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.draining
+}
+
+func (a *activity) snapshot() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counts := make(map[string]int, len(a.inFlight))
+	for k, v := range a.inFlight {
+		counts[k] = v
+	}
+	return counts
+}
+
+var errDraining = newAPIError(http.StatusServiceUnavailable, CodeUnavailable, "server is shutting down")
+
+// Drain prepares the server to exit. New sandboxes are refused from now on;
+// Drain then waits for in-flight creates, execs and interactive sessions
+// until ctx is done. With stopSandboxes set, the sandboxes still running
+// are stopped afterwards instead of being left for the next startup's
+// orphan cleanup. It returns ctx.Err() if work was still in flight.
+//
+// Call Drain before shutting down the HTTP server, so that requests made
+// meanwhile are answered. A cluster node stops heartbeating at the end, so
+// that the other nodes take over the sandboxes it leaves running.
+func (h *Handler) Drain(ctx context.Context, stopSandboxes bool) error {
+	log.Info().Bool("stop_sandboxes", stopSandboxes).Msg("Draining")
+
+	var err error
+	select {
+	case <-h.activity.drain():
+	case <-ctx.Done():
+		err = ctx.Err()
+		counts := h.activity.snapshot()
+		log.Warn().Int("creates", counts["create"]).Int("execs", counts["exec"]).
+			Int("sessions", counts["session"]).Int("jobs", counts["job"]).Msg("Drain timed out; dropping in-flight work")
+	}
+
+	if stopSandboxes {
+		h.stopAll()
+	}
+	h.stopHeartbeat()
+	h.stopUsage()
+	h.events.close()
+	return err
+}
+
+// stopAll stops every sandbox the control plane created that is still
+// running, leaving those other nodes serve alone.
+func (h *Handler) stopAll() {
+	records, _ := h.store.ListSandboxes(context.Background())
+
+	var wg sync.WaitGroup
+	for _, rec := range records {
+		if (rec.State != state.SandboxReady && rec.State != state.SandboxCreating) || !h.servedHere(rec) {
+			continue
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := h.stop(ctx, id, "server shutdown"); err != nil {
+				log.Warn().Err(err).Str("id", id).Msg("Failed to stop sandbox on shutdown")
+			}
+		}(rec.ID)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,763 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrEgressProxyClosed is returned by EgressProxy.Serve after Shutdown.
+var ErrEgressProxyClosed = errors.New("egress: proxy closed")
+
+const (
+	// egressUser is the user name sandboxes log in to the proxy with; the
+	// password tells them apart
+	egressUser = "boxed"
+
+	// egressHandshakeTimeout bounds how long a connection may take to say
+	// where it is going
+	egressHandshakeTimeout = 30 * time.Second
+
+	// egressDialTimeout bounds connecting to a destination
+	egressDialTimeout = 30 * time.Second
+)
+
+// Results of egress connections, the label of boxed_egress_connections_total.
+const (
+	egressAllowed      = "allowed"
+	egressBlocked      = "blocked"
+	egressUnauthorized = "unauthorized"
+	egressFailed       = "failed"
+)
+
+var egressConnections = metrics.Default.Counter("boxed_egress_connections_total",
+	"Connections sandboxes opened through the egress proxy, by result.", "result")
+
+// reservedRanges are ranges outside the internet that netip.Addr does not
+// classify: "this network", shared address space of carrier-grade NATs,
+// where some clouds run metadata services, IETF protocol assignments,
+// benchmarking and the reserved class E.
+var reservedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// nat64Range embeds IPv4 addresses in IPv6 ones, which are checked as the
+// IPv4 address.
+var nat64Range = netip.MustParsePrefix("64:ff9b::/96")
+
+// EgressProxy is the way out of sandboxes created with
+// network_policy.enable_internet. Their network reaches nothing but the
+// proxy, which connects them on to the internet and refuses what they must
+// not reach, whatever name resolves to it: the host itself, loopback,
+// link-local addresses such as the cloud metadata service at
+// 169.254.169.254, private networks and other reserved ranges. The
+// network_policy's allow_domains, if any, are the only hosts it connects
+// to. It speaks HTTP, both CONNECT tunnels and plain requests, and SOCKS5
+// on one port; each sandbox logs in with a password of its own, so that
+// every connection is logged with the sandbox that opened it.
+type EgressProxy struct {
+	addrPolicy
+	port   int
+	dialer net.Dialer
+
+	// transport forwards plain HTTP requests, never reusing a connection
+	// for another request, which may come from another sandbox
+	transport *http.Transport
+
+	mu sync.Mutex
+	// grants are the sandboxes' logins, by password, and passwords the
+	// password of each sandbox
+	grants    map[string]*egressGrant
+	passwords map[string]string
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	active    sync.WaitGroup
+}
+
+// egressGrant is what a sandbox may reach through the proxy.
+type egressGrant struct {
+	// sandbox is set once the sandbox is created; connections made while
+	// it is created are logged without it
+	sandbox string
+	domains []string
+}
+
+// egressError is why the proxy did not connect a sandbox.
+type egressError struct {
+	result string
+	msg    string
+}
+
+func (e *egressError) Error() string { return e.msg }
+
+// NewEgressProxy returns the egress proxy of the sandboxes of h, served on
+// port of the host, and gives it to h: from then on, creates asking for
+// internet access are connected through it. Addresses in allow are let
+// through even though they are private, such as those of a package mirror.
+func (h *Handler) NewEgressProxy(port int, allow []netip.Prefix) *EgressProxy {
+	p := &EgressProxy{
+		addrPolicy: newAddrPolicy(allow),
+		port:       port,
+		dialer:     net.Dialer{Timeout: egressDialTimeout},
+		grants:     make(map[string]*egressGrant),
+		passwords:  make(map[string]string),
+		listeners:  make(map[net.Listener]bool),
+		conns:      make(map[net.Conn]bool),
+	}
+	p.transport = &http.Transport{
+		DialContext:         p.dialHTTP,
+		DisableKeepAlives:   true,
+		TLSHandshakeTimeout: egressHandshakeTimeout,
+	}
+	h.egress = p
+	return p
+}
+
+// grant returns the login to the proxy of a sandbox about to be created,
+// which may connect to domains, or anywhere if there are none.
+func (p *EgressProxy) grant(domains []string) *driver.EgressProxy {
+	b := make([]byte, 16)
+	rand.Read(b)
+	password := hex.EncodeToString(b)
+	p.mu.Lock()
+	p.grants[password] = &egressGrant{domains: domains}
+	p.mu.Unlock()
+	return &driver.EgressProxy{Port: p.port, User: egressUser, Password: password}
+}
+
+// bind ties the login of login to the sandbox created with it.
+func (p *EgressProxy) bind(id string, login *driver.EgressProxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if g := p.grants[login.Password]; g != nil {
+		g.sandbox = id
+		p.passwords[id] = login.Password
+	}
+}
+
+// revoke ends a login whose sandbox was not created.
+func (p *EgressProxy) revoke(login *driver.EgressProxy) {
+	p.mu.Lock()
+	delete(p.grants, login.Password)
+	p.mu.Unlock()
+}
+
+// closeSandbox ends the login of a sandbox that is gone. Open connections
+// are left to end on their own.
+func (p *EgressProxy) closeSandbox(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if password, ok := p.passwords[id]; ok {
+		delete(p.grants, password)
+		delete(p.passwords, id)
+	}
+}
+
+// login returns the grant of the sandbox logging in as user with password.
+func (p *EgressProxy) login(user, password string) *egressGrant {
+	if user != egressUser {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.grants[password]
+}
+
+// Serve accepts connections on l until Shutdown is called.
+func (p *EgressProxy) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrEgressProxyClosed
+	}
+	p.listeners[l] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrEgressProxyClosed
+			}
+			return err
+		}
+		go p.serveConn(nc)
+	}
+}
+
+// Shutdown stops accepting connections and waits for open ones to end
+// until ctx is done, then closes them.
+func (p *EgressProxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for c := range p.conns {
+			c.Close()
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (p *EgressProxy) serveConn(nc net.Conn) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		nc.Close()
+		return
+	}
+	p.conns[nc] = true
+	p.active.Add(1)
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, nc)
+		p.mu.Unlock()
+		p.active.Done()
+	}()
+	defer nc.Close()
+
+	nc.SetDeadline(time.Now().Add(egressHandshakeTimeout))
+	br := bufio.NewReader(nc)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	// SOCKS5 greetings start with the version; HTTP requests with a method
+	if first[0] == 5 {
+		p.serveSOCKS(nc, br)
+		return
+	}
+	p.serveHTTP(nc, br)
+}
+
+// serveHTTP serves the requests of an HTTP proxy connection: a CONNECT
+// request turns it into a tunnel, and plain requests are forwarded one
+// after the other.
+func (p *EgressProxy) serveHTTP(nc net.Conn, br *bufio.Reader) {
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		nc.SetDeadline(time.Time{})
+
+		user, password, _ := parseProxyAuth(req.Header.Get("Proxy-Authorization"))
+		g := p.login(user, password)
+		if g == nil {
+			egressConnections.Inc(egressUnauthorized)
+			log.Warn().Str("remote", nc.RemoteAddr().String()).Msg("Egress proxy login failed")
+			resp := httpError(req, http.StatusProxyAuthRequired, "proxy login required")
+			resp.Header.Set("Proxy-Authenticate", `Basic realm="boxed"`)
+			resp.Write(nc)
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			p.tunnelHTTP(nc, br, req, g)
+			return
+		}
+		if !p.forward(nc, req, g) {
+			return
+		}
+		nc.SetDeadline(time.Now().Add(egressHandshakeTimeout))
+	}
+}
+
+// tunnelHTTP connects a CONNECT request to its destination and relays the
+// connection both ways.
+func (p *EgressProxy) tunnelHTTP(nc net.Conn, br *bufio.Reader, req *http.Request, g *egressGrant) {
+	up, err := p.dial(req.Context(), g, req.Host, "connect")
+	if err != nil {
+		httpError(req, egressStatus(err), err.Error()).Write(nc)
+		return
+	}
+	defer up.Close()
+	if _, err := io.WriteString(nc, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	relay(nc, br, up)
+}
+
+// forward sends a plain HTTP request on to its destination and the
+// response back, reporting whether the connection can carry another
+// request.
+func (p *EgressProxy) forward(nc net.Conn, req *http.Request, g *egressGrant) bool {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		httpError(req, http.StatusBadRequest, "only http:// URLs are forwarded; use CONNECT for https").Write(nc)
+		return false
+	}
+	out := req.Clone(context.WithValue(req.Context(), egressGrantKey{}, g))
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		var eerr *egressError
+		if !errors.As(err, &eerr) {
+			err = &egressError{result: egressFailed, msg: err.Error()}
+		}
+		httpError(req, egressStatus(err), err.Error()).Write(nc)
+		return false
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	if err := resp.Write(nc); err != nil {
+		return false
+	}
+	return !req.Close && !resp.Close
+}
+
+// egressGrantKey keys the grant of a forwarded request in its context, for
+// dialHTTP.
+type egressGrantKey struct{}
+
+// dialHTTP dials the destination of a forwarded request.
+func (p *EgressProxy) dialHTTP(ctx context.Context, network, addr string) (net.Conn, error) {
+	g, _ := ctx.Value(egressGrantKey{}).(*egressGrant)
+	if g == nil {
+		return nil, errors.New("egress: request without a grant")
+	}
+	return p.dial(ctx, g, addr, "http")
+}
+
+// SOCKS5 protocol values; see RFC 1928 and RFC 1929.
+const (
+	socksVersion      = 5
+	socksAuthVersion  = 1
+	socksAuthPassword = 2
+	socksNoMethod     = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+
+	socksSucceeded     = 0
+	socksNotAllowed    = 2
+	socksRefused       = 5
+	socksNoCommand     = 7
+	socksNoAddressType = 8
+	socksAuthSucceeded = 0
+	socksAuthFailed    = 1
+)
+
+// serveSOCKS serves a SOCKS5 connection: a password login, then a CONNECT
+// request, whose connection is relayed both ways.
+func (p *EgressProxy) serveSOCKS(nc net.Conn, br *bufio.Reader) {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return
+	}
+	if !slices.Contains(methods, socksAuthPassword) {
+		nc.Write([]byte{socksVersion, socksNoMethod})
+		return
+	}
+	nc.Write([]byte{socksVersion, socksAuthPassword})
+
+	user, password, err := readSOCKSLogin(br)
+	if err != nil {
+		return
+	}
+	g := p.login(user, password)
+	if g == nil {
+		egressConnections.Inc(egressUnauthorized)
+		log.Warn().Str("remote", nc.RemoteAddr().String()).Msg("Egress proxy login failed")
+		nc.Write([]byte{socksAuthVersion, socksAuthFailed})
+		return
+	}
+	nc.Write([]byte{socksAuthVersion, socksAuthSucceeded})
+
+	var req [4]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil {
+		return
+	}
+	host, err := readSOCKSAddr(br, req[3])
+	if err != nil {
+		socksReply(nc, socksNoAddressType)
+		return
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return
+	}
+	if req[1] != socksConnect {
+		socksReply(nc, socksNoCommand)
+		return
+	}
+	nc.SetDeadline(time.Time{})
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	up, err := p.dial(context.Background(), g, addr, "socks")
+	if err != nil {
+		var eerr *egressError
+		if errors.As(err, &eerr) && eerr.result == egressBlocked {
+			socksReply(nc, socksNotAllowed)
+		} else {
+			socksReply(nc, socksRefused)
+		}
+		return
+	}
+	defer up.Close()
+	if err := socksReply(nc, socksSucceeded); err != nil {
+		return
+	}
+	relay(nc, br, up)
+}
+
+// readSOCKSLogin reads a user name and password login.
+func readSOCKSLogin(r io.Reader) (user, password string, err error) {
+	var field [255]byte
+	var n [1]byte
+	read := func() (string, error) {
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(r, field[:n[0]]); err != nil {
+			return "", err
+		}
+		return string(field[:n[0]]), nil
+	}
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", "", err
+	}
+	if n[0] != socksAuthVersion {
+		return "", "", fmt.Errorf("socks: unknown login version %d", n[0])
+	}
+	if user, err = read(); err != nil {
+		return "", "", err
+	}
+	password, err = read()
+	return user, password, err
+}
+
+// readSOCKSAddr reads the destination address of a request, of type typ.
+func readSOCKSAddr(r io.Reader, typ byte) (string, error) {
+	switch typ {
+	case socksIPv4, socksIPv6:
+		b := make([]byte, 4)
+		if typ == socksIPv6 {
+			b = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		addr, _ := netip.AddrFromSlice(b)
+		return addr.String(), nil
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return "", fmt.Errorf("socks: unknown address type %d", typ)
+}
+
+// socksReply answers a request with code; the bound address is not told.
+func socksReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// dial connects the sandbox of g to addr, "host:port", if its grant allows
+// the host and the host resolves to an address it may reach, and logs the
+// connection.
+func (p *EgressProxy) dial(ctx context.Context, g *egressGrant, addr, via string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, &egressError{result: egressFailed, msg: fmt.Sprintf("invalid destination %q", addr)}
+	}
+	p.mu.Lock()
+	id := g.sandbox
+	p.mu.Unlock()
+	logger := log.With().Str("id", id).Str("dest", addr).Str("via", via).Logger()
+
+	conn, err := p.connect(ctx, g, host, port)
+	var eerr *egressError
+	if err != nil && !errors.As(err, &eerr) {
+		eerr = &egressError{result: egressFailed, msg: fmt.Sprintf("failed to connect to %s: %v", addr, err)}
+	}
+	if eerr != nil {
+		egressConnections.Inc(eerr.result)
+		if eerr.result == egressBlocked {
+			logger.Warn().Str("reason", eerr.msg).Msg("Egress blocked")
+		} else {
+			logger.Info().Str("reason", eerr.msg).Msg("Egress failed")
+		}
+		return nil, eerr
+	}
+	egressConnections.Inc(egressAllowed)
+	logger.Info().Str("addr", conn.RemoteAddr().String()).Msg("Egress connection")
+	return conn, nil
+}
+
+// connect dials the first address of host the proxy lets g reach.
+func (p *EgressProxy) connect(ctx context.Context, g *egressGrant, host, port string) (net.Conn, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if len(g.domains) > 0 && !slices.ContainsFunc(g.domains, func(d string) bool { return domainMatches(d, host) }) {
+		return nil, &egressError{result: egressBlocked, msg: fmt.Sprintf("%s is not in the sandbox's allow_domains", host)}
+	}
+
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		if p.blocked(addr) {
+			continue
+		}
+		conn, err := p.dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, &egressError{result: egressBlocked, msg: fmt.Sprintf("%s resolves to no address sandboxes may reach", host)}
+}
+
+// addrPolicy tells the addresses of the internet, which the server may
+// connect to for sandboxes, from the rest.
+type addrPolicy struct {
+	allow []netip.Prefix
+	local []netip.Addr
+}
+
+// newAddrPolicy returns the policy refusing what is not on the internet,
+// except addresses in allow.
+func newAddrPolicy(allow []netip.Prefix) addrPolicy {
+	p := addrPolicy{allow: allow}
+	// Names resolving to the host's own addresses reach the server's
+	// ports, such as the API's
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				if addr, ok := netip.AddrFromSlice(n.IP); ok {
+					p.local = append(p.local, addr.Unmap())
+				}
+			}
+		}
+	}
+	return p
+}
+
+// blocked reports whether sandboxes must not reach addr: it is not a
+// public unicast address of the internet, or it is the host's.
+func (p addrPolicy) blocked(addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, r := range p.allow {
+		if r.Contains(addr) {
+			return false
+		}
+	}
+	if nat64Range.Contains(addr) {
+		b := addr.As16()
+		return p.blocked(netip.AddrFrom4([4]byte(b[12:])))
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || slices.Contains(p.local, addr) {
+		return true
+	}
+	for _, r := range reservedRanges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// errNotInternet is returned by addrPolicy.dial for hosts with no address
+// the policy lets through.
+var errNotInternet = errors.New("host is not on the internet")
+
+// dial connects to the first address of host the policy lets through. The
+// name is resolved once and that address dialed, so it cannot resolve to
+// another between the check and the connection.
+func (p addrPolicy) dial(ctx context.Context, network, hostport string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	var lastErr error
+	for _, addr := range addrs {
+		if p.blocked(addr) {
+			continue
+		}
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("%s: %w", host, errNotInternet)
+}
+
+// domainMatches reports whether host matches an allow_domains pattern: the
+// domain itself, or with a "*." prefix, any name below it.
+func domainMatches(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// validateAllowDomains checks the allow_domains of a network policy: host
+// names, or IP addresses, optionally with a "*." prefix.
+func validateAllowDomains(domains []string) error {
+	for _, d := range domains {
+		name := strings.TrimPrefix(strings.TrimSuffix(d, "."), "*.")
+		if _, err := netip.ParseAddr(name); err == nil && name == d {
+			continue
+		}
+		if !driver.IsHostName(name) {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("invalid allow_domains entry %q: want a host name such as pypi.org or *.example.com", d))
+		}
+	}
+	return nil
+}
+
+// egressStatus is the HTTP status of a failed proxy request.
+func egressStatus(err error) int {
+	var eerr *egressError
+	if errors.As(err, &eerr) && eerr.result == egressBlocked {
+		return http.StatusForbidden
+	}
+	return http.StatusBadGateway
+}
+
+// httpError is a plain text response of the proxy itself to req.
+func httpError(req *http.Request, code int, msg string) *http.Response {
+	body := msg + "\n"
+	return &http.Response{
+		StatusCode:    code,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+}
+
+// parseProxyAuth parses a Proxy-Authorization header with basic
+// credentials.
+func parseProxyAuth(header string) (user, password string, ok bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(b), ":")
+}
+
+// hopHeaders are the headers of one connection, not passed on by proxies;
+// see RFC 9110, section 7.6.1.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// removeHopHeaders removes the hop-by-hop headers from h, including those
+// its Connection header names.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// relay copies between a client connection, whose first bytes may still be
+// in br, and its destination. Once the destination is done the client is
+// too: it is not waited on to close.
+func relay(nc net.Conn, br *bufio.Reader, up net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(up, br)
+		if c, ok := up.(*net.TCPConn); ok {
+			c.CloseWrite()
+		}
+		close(done)
+	}()
+	io.Copy(nc, up)
+	nc.SetReadDeadline(time.Now())
+	<-done
+}
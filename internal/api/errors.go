@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Machine readable error codes returned in the "code" field of error bodies.
+// Clients should branch on these rather than on messages.
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeSandboxNotFound   = "sandbox_not_found"
+	CodeSandboxNotRunning = "sandbox_not_running"
+	CodeConflict          = "conflict"
+	CodeSandboxBusy       = "sandbox_busy"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeTimedOut          = "timed_out"
+	CodeCanceled          = "canceled"
+	CodeNotImplemented    = "not_implemented"
+	CodeUnavailable       = "unavailable"
+	CodeSetupFailed       = "setup_failed"
+	CodeInternal          = "internal"
+)
+
+// APIError is an error with a stable code, rendered as
+// {"error": "<message>", "code": "<code>"}.
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"error"`
+
+	// SandboxID is set when the error concerns a sandbox that was created
+	// and then torn down (e.g. a failed start), so its timeline can be fetched
+	SandboxID string `json:"sandbox_id,omitempty"`
+
+	// QueuePosition and RetryAfter, in seconds, are set when an exec found
+	// no slot, of the server or of its sandbox: where it was among the
+	// execs waiting, and about when one would be free
+	QueuePosition int `json:"queue_position,omitempty"`
+	RetryAfter    int `json:"retry_after,omitempty"`
+
+	// Err is the underlying cause, if any (often a driver sentinel)
+	Err error `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func wrapAPIError(status int, code, message string, err error) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Err: err}
+}
+
+// StatusClientClosedRequest is the status of a request whose client went
+// away before it finished, as nginx logs it. The client never sees it.
+const StatusClientClosedRequest = 499
+
+// contextError is the error of an operation cut short by the end of ctx:
+// timed out at its deadline, canceled otherwise, e.g. when the client
+// disconnected.
+func contextError(ctx context.Context) *APIError {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return wrapAPIError(http.StatusRequestTimeout, CodeTimedOut, "timed out", driver.ErrTimeout)
+	}
+	return wrapAPIError(StatusClientClosedRequest, CodeCanceled, "canceled", context.Cause(ctx))
+}
+
+var errSandboxNotFound = wrapAPIError(http.StatusNotFound, CodeSandboxNotFound, "sandbox not found", driver.ErrSandboxNotFound)
+
+// driverError maps driver sentinel errors onto API errors. The returned value
+// is freshly allocated, so callers may reword its message.
+func driverError(err error) *APIError {
+	switch {
+	case errors.Is(err, driver.ErrSandboxNotFound):
+		return wrapAPIError(http.StatusNotFound, CodeSandboxNotFound, err.Error(), err)
+	case errors.Is(err, driver.ErrSandboxNotRunning):
+		return wrapAPIError(http.StatusConflict, CodeSandboxNotRunning, err.Error(), err)
+	case errors.Is(err, driver.ErrSandboxAlreadyRunning):
+		return wrapAPIError(http.StatusConflict, CodeConflict, err.Error(), err)
+	case errors.Is(err, driver.ErrResourceExhausted):
+		return wrapAPIError(http.StatusTooManyRequests, CodeQuotaExceeded, err.Error(), err)
+	case errors.Is(err, driver.ErrTimeout):
+		return wrapAPIError(http.StatusRequestTimeout, CodeTimedOut, err.Error(), err)
+	case errors.Is(err, driver.ErrWorkspaceNotFound):
+		return wrapAPIError(http.StatusNotFound, CodeNotFound, err.Error(), err)
+	case errors.Is(err, driver.ErrWorkspaceExists), errors.Is(err, driver.ErrWorkspaceInUse):
+		return wrapAPIError(http.StatusConflict, CodeConflict, err.Error(), err)
+	case errors.Is(err, driver.ErrVolumeNotFound):
+		return wrapAPIError(http.StatusNotFound, CodeNotFound, err.Error(), err)
+	case errors.Is(err, driver.ErrVolumeExists), errors.Is(err, driver.ErrVolumeInUse):
+		return wrapAPIError(http.StatusConflict, CodeConflict, err.Error(), err)
+	case errors.Is(err, driver.ErrNotImplemented):
+		return wrapAPIError(http.StatusNotImplemented, CodeNotImplemented, err.Error(), err)
+	case errors.Is(err, driver.ErrInvalidConfig):
+		return wrapAPIError(http.StatusBadRequest, CodeInvalidRequest, err.Error(), err)
+	}
+	return wrapAPIError(http.StatusInternalServerError, CodeInternal, err.Error(), err)
+}
+
+// codeForStatus picks a code for errors raised without one (echo.HTTPError).
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeUnauthorized
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return CodeNotFound
+	case http.StatusTooManyRequests:
+		return CodeQuotaExceeded
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return CodeTimedOut
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// ErrorHandler renders every error returned by a handler as an APIError body.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+		if apiErr.Status >= http.StatusInternalServerError && apiErr.Err != nil {
+			log.Error().Err(apiErr.Err).Str("path", c.Path()).Msg(apiErr.Message)
+		}
+	case errors.As(err, &httpErr):
+		apiErr = newAPIError(httpErr.Code, codeForStatus(httpErr.Code), fmt.Sprint(httpErr.Message))
+		if httpErr.Internal != nil {
+			log.Debug().Err(httpErr.Internal).Str("path", c.Path()).Msg(apiErr.Message)
+		}
+	default:
+		log.Error().Err(err).Str("path", c.Path()).Msg("Unhandled error")
+		apiErr = newAPIError(http.StatusInternalServerError, CodeInternal, http.StatusText(http.StatusInternalServerError))
+	}
+
+	if apiErr.RetryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, apiErr)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write error response")
+	}
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Types of the lifecycle events streamed by GET /events.
+const (
+	LifecycleCreated    = "created"
+	LifecycleReady      = "ready"
+	LifecycleTTLWarning = "ttl_warning"
+	LifecycleStopped    = "stopped"
+	LifecycleErrored    = "errored"
+	LifecycleHibernated = "hibernated"
+)
+
+var lifecycleTypes = []string{
+	LifecycleCreated, LifecycleReady, LifecycleTTLWarning, LifecycleStopped, LifecycleErrored, LifecycleHibernated,
+}
+
+// DefaultExpiryWarning is how long before a sandbox expires its
+// ttl_warning event is sent.
+const DefaultExpiryWarning = 30 * time.Second
+
+const (
+	// eventBuffer is the number of events a subscriber may fall behind by
+	// before it is disconnected
+	eventBuffer = 256
+
+	// expiryCheckInterval is how often sandboxes are checked for expiry
+	// warnings and errors while there are subscribers
+	expiryCheckInterval = time.Second
+
+	// eventKeepalive is how often an idle stream is pinged, so proxies do
+	// not close it
+	eventKeepalive = 30 * time.Second
+)
+
+// LifecycleEvent is a sandbox state transition broadcast to subscribers.
+type LifecycleEvent struct {
+	Type      string    `json:"type"`
+	SandboxID string    `json:"sandbox_id"`
+	At        time.Time `json:"at"`
+
+	// Labels are those of the sandbox, which subscribers filter on
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// ExpiresAt is when the TTL reaps the sandbox; set on ready and
+	// ttl_warning events
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Reason is why a sandbox stopped: "api", "ttl_expired", "server
+	// shutdown"...
+	Reason string `json:"reason,omitempty"`
+
+	// Error describes what went wrong for errored events
+	Error string `json:"error,omitempty"`
+}
+
+// EventFilter selects lifecycle events. Empty fields match everything.
+type EventFilter struct {
+	// Types of the events
+	Types []string
+
+	// Labels the sandbox must all have, as "key=value" or just "key"
+	Labels []string
+}
+
+func (f EventFilter) match(ev LifecycleEvent) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, ev.Type) {
+		return false
+	}
+	return hasLabels(ev.Labels, f.Labels)
+}
+
+func (f EventFilter) validate() error {
+	for _, typ := range f.Types {
+		if !slices.Contains(lifecycleTypes, typ) {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("unknown event type %q: want %s", typ, strings.Join(lifecycleTypes, ", ")))
+		}
+	}
+	return nil
+}
+
+// WithExpiryWarning sends ttl_warning events d before sandboxes expire.
+// Values <= 0 keep DefaultExpiryWarning.
+func WithExpiryWarning(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.expiryWarning = d
+		}
+	}
+}
+
+// eventBus broadcasts lifecycle events to subscribers. While there are
+// any, watch runs to produce the events no request causes.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[*eventSub]struct{}
+	closed bool
+
+	watch     func(stop <-chan struct{})
+	stopWatch chan struct{}
+}
+
+type eventSub struct {
+	filter EventFilter
+	ch     chan LifecycleEvent
+
+	// lagged is set before ch is closed when the subscriber fell behind
+	lagged bool
+}
+
+func newEventBus(watch func(stop <-chan struct{})) *eventBus {
+	return &eventBus{subs: map[*eventSub]struct{}{}, watch: watch}
+}
+
+// subscribe returns a channel of the events matching filter and a function
+// to stop receiving them. The channel is closed when the subscriber falls
+// behind or the bus is closed.
+func (b *eventBus) subscribe(filter EventFilter) (*eventSub, func()) {
+	sub := &eventSub{filter: filter, ch: make(chan LifecycleEvent, eventBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub, func() {}
+	}
+	b.subs[sub] = struct{}{}
+	if len(b.subs) == 1 && b.watch != nil {
+		b.stopWatch = make(chan struct{})
+		go b.watch(b.stopWatch)
+	}
+	return sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(sub)
+	}
+}
+
+// remove drops sub and closes its channel. b.mu must be held.
+func (b *eventBus) remove(sub *eventSub) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.ch)
+	if len(b.subs) == 0 && b.stopWatch != nil {
+		close(b.stopWatch)
+		b.stopWatch = nil
+	}
+}
+
+// active reports whether anyone is subscribed, so that events nobody
+// receives are not built.
+func (b *eventBus) active() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+func (b *eventBus) publish(ev LifecycleEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if !sub.filter.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// Blocking here would stall every other subscriber
+			sub.lagged = true
+			b.remove(sub)
+		}
+	}
+}
+
+// close ends every subscription; later ones end at once.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// publish broadcasts a lifecycle event. The time and the sandbox labels
+// are filled in when unset.
+func (h *Handler) publish(ev LifecycleEvent) {
+	if !h.events.active() {
+		return
+	}
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	if ev.Labels == nil {
+		if rec, err := h.store.GetSandbox(context.Background(), ev.SandboxID); err == nil {
+			ev.Labels = rec.Labels
+		}
+	}
+	h.events.publish(ev)
+}
+
+// SubscribeEvents returns a channel of the lifecycle events matching
+// filter and a function to stop receiving them, which must be called. It
+// is the transport independent core of GET /events. Events are sent for
+// the sandboxes this node serves. The channel is closed when the server
+// drains, or when the subscriber falls behind by more than a few hundred
+// events; errors are *APIError.
+func (h *Handler) SubscribeEvents(filter EventFilter) (<-chan LifecycleEvent, func(), error) {
+	if err := filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	sub, cancel := h.events.subscribe(filter)
+	return sub.ch, cancel, nil
+}
+
+// errEventsLagged ends the stream of a subscriber that fell behind.
+var errEventsLagged = newAPIError(http.StatusServiceUnavailable, CodeUnavailable,
+	"event stream fell behind and was closed; subscribe again")
+
+// streamEvents serves GET /events?type=<type>&label=<selector>: lifecycle
+// events as server-sent events named after their type, or as JSON messages
+// when the request is a WebSocket upgrade. A subscriber that falls behind
+// gets an "error" event holding the APIError before the stream ends.
+func (h *Handler) streamEvents(c echo.Context) error {
+	var filter EventFilter
+	for _, t := range c.QueryParams()["type"] {
+		filter.Types = append(filter.Types, strings.Split(t, ",")...)
+	}
+	filter.Labels = ownerFilter(c.Request().Context(), c.QueryParams()["label"])
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	sub, cancel := h.events.subscribe(filter)
+	defer cancel()
+
+	if websocket.IsWebSocketUpgrade(c.Request()) {
+		return h.streamEventsWS(c, sub)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				if sub.lagged {
+					writeEvent(res, "error", errEventsLagged)
+				}
+				return nil
+			}
+			if writeEvent(res, ev.Type, ev) != nil {
+				return nil
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(res, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// streamEventsWS sends the events of sub over a WebSocket, one JSON
+// message each. Messages from the client are ignored.
+func (h *Handler) streamEventsWS(c echo.Context, sub *eventSub) error {
+	ws, err := h.upgrader().Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	// Reading is what notices the client going away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok {
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				if sub.lagged {
+					msg = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, errEventsLagged.Message)
+				}
+				ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				return nil
+			}
+			if err := ws.WriteJSON(ev); err != nil {
+				return nil
+			}
+		case <-keepalive.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return nil
+			}
+		case <-gone:
+			return nil
+		}
+	}
+}
+
+// watchSandboxes sends the events no request causes until stop is closed:
+// ttl_warning for sandboxes about to expire, errored for those the driver
+// reports broken.
+func (h *Handler) watchSandboxes(stop <-chan struct{}) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	// The expiry each sandbox was warned about; extending the TTL re-arms
+	// the warning
+	warned := map[string]time.Time{}
+	errored := map[string]bool{}
+	for {
+		h.checkSandboxes(warned, errored)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (h *Handler) checkSandboxes(warned map[string]time.Time, errored map[string]bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), expiryCheckInterval)
+	defer cancel()
+
+	records, err := h.store.ListSandboxes(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list sandboxes for expiry warnings")
+		return
+	}
+	live := make(map[string]bool, len(records))
+	for _, rec := range records {
+		if rec.State != state.SandboxReady || !h.servedHere(rec) {
+			continue
+		}
+		live[rec.ID] = true
+		if rec.ExpiresAt.IsZero() || time.Until(rec.ExpiresAt) > h.expiryWarning || warned[rec.ID].Equal(rec.ExpiresAt) {
+			continue
+		}
+		warned[rec.ID] = rec.ExpiresAt
+		expiresAt := rec.ExpiresAt
+		h.publish(LifecycleEvent{Type: LifecycleTTLWarning, SandboxID: rec.ID, Labels: rec.Labels, ExpiresAt: &expiresAt})
+	}
+	for id := range warned {
+		if !live[id] {
+			delete(warned, id)
+		}
+	}
+
+	broken, err := h.driver.List(ctx, []driver.SandboxState{driver.StateError})
+	if err != nil {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			log.Warn().Err(err).Msg("Failed to list broken sandboxes")
+		}
+		return
+	}
+	seen := make(map[string]bool, len(broken))
+	for _, info := range broken {
+		seen[info.ID] = true
+		if errored[info.ID] || !live[info.ID] {
+			continue
+		}
+		errored[info.ID] = true
+		h.publish(LifecycleEvent{Type: LifecycleErrored, SandboxID: info.ID, Error: info.Error})
+	}
+	for id := range errored {
+		if !seen[id] {
+			delete(errored, id)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+)
+
+const (
+	// DefaultExecCacheSize is the number of exec results kept for requests
+	// that set "cache"
+	DefaultExecCacheSize = 1024
+
+	// execCacheMaxAge bounds how long a result is served: images behind a
+	// tag change, and so may their results.
+	execCacheMaxAge = time.Hour
+)
+
+var (
+	execCacheHits = metrics.Default.Counter("boxed_exec_cache_hits_total",
+		"Execs answered from the exec cache.")
+	execCacheMisses = metrics.Default.Counter("boxed_exec_cache_misses_total",
+		"Cacheable execs that had to run.")
+)
+
+// execCache is a bounded LRU of exec results keyed by execCacheKey.
+type execCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type execCacheEntry struct {
+	key      string
+	result   ExecResponse
+	storedAt time.Time
+}
+
+func newExecCache(size int) *execCache {
+	return &execCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns a copy of the result stored under key, or nil.
+func (c *execCache) get(key string) *ExecResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*execCacheEntry)
+	if time.Since(e.storedAt) > execCacheMaxAge {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return copyExecResponse(e.result)
+}
+
+func (c *execCache) put(key string, result ExecResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &execCacheEntry{key: key, result: *copyExecResponse(result), storedAt: time.Now()}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*execCacheEntry).key)
+	}
+}
+
+func copyExecResponse(r ExecResponse) *ExecResponse {
+	r.Artifacts = append([]proto.ArtifactEvent{}, r.Artifacts...)
+	return &r
+}
+
+// contextDigest identifies the files a sandbox was created with. Sandboxes
+// of one image and digest start out identical.
+func contextDigest(files []driver.FileInjection) string {
+	sorted := append([]driver.FileInjection{}, files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	h := sha256.New()
+	for _, f := range sorted {
+		json.NewEncoder(h).Encode(f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// workspaceDigest folds the base workspace of a sandbox into its context
+// digest. A workspace is identified by name and creation time, since
+// replacing it recreates it.
+func workspaceDigest(ws *driver.WorkspaceInfo, digest string) string {
+	sum := sha256.Sum256([]byte(ws.Name + "\x00" + ws.CreatedAt.UTC().Format(time.RFC3339Nano) + "\x00" + digest))
+	return hex.EncodeToString(sum[:])
+}
+
+// execCacheKey hashes everything that decides the result of an exec in a
+// freshly created sandbox.
+func execCacheKey(rec state.SandboxRecord, req ExecRequest) string {
+	data, _ := json.Marshal(struct {
+		Image         string                 `json:"image"`
+		ContextDigest string                 `json:"context_digest"`
+		Language      string                 `json:"language"`
+		Code          string                 `json:"code"`
+		Cwd           string                 `json:"cwd"`
+		User          string                 `json:"user"`
+		Env           map[string]string      `json:"env"`
+		SpillOutput   bool                   `json:"spill_output"`
+		Artifacts     *proto.ArtifactOptions `json:"artifacts"`
+	}{rec.Image, rec.ContextDigest, req.Language, req.Code, req.Cwd, req.User, req.Env, req.SpillOutput, req.Artifacts})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheable reports whether a result can be served to another sandbox:
+// the exec succeeded and every artifact carries its data, since links to
+// files in the original sandbox die with it.
+func cacheable(r *ExecResponse) bool {
+	if r.ExitCode == nil || *r.ExitCode != 0 {
+		return false
+	}
+	for _, a := range r.Artifacts {
+		if a.DataBase64 == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// cachedExec returns a copy of the result cached under key for sandbox id,
+// or nil.
+func (h *Handler) cachedExec(id, key string) *ExecResponse {
+	res := h.execCache.get(key)
+	if res == nil {
+		execCacheMisses.Inc()
+		return nil
+	}
+	execCacheHits.Inc()
+
+	// The sandbox holds its own references to the stored artifacts
+	h.storeArtifacts(id, res.Artifacts)
+	res.Cached = true
+	return res
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+)
+
+// What an exec does when its sandbox already runs as many execs as
+// ExecScheduling.SandboxSlots allows; see ExecRequest.IfBusy.
+const (
+	// ExecIfBusyQueue waits for one of them to finish, the default
+	ExecIfBusyQueue = "queue"
+	// ExecIfBusyReject fails at once with 409 sandbox_busy
+	ExecIfBusyReject = "reject"
+)
+
+var (
+	execSandboxQueueDepth = metrics.Default.Gauge("boxed_exec_sandbox_queue_depth",
+		"Execs waiting for another exec of their sandbox to finish.")
+	execSandboxBusyTotal = metrics.Default.Counter("boxed_exec_sandbox_busy_total",
+		"Execs refused because their sandbox was busy: rejected at once, or timed_out waiting.", "reason")
+)
+
+// execWait is how an exec waited for its sandbox before running.
+type execWait struct {
+	position int
+	took     time.Duration
+}
+
+// sandboxMaxWait is how long the exec of ctx waits for its sandbox: jobs,
+// which waited in the sandbox's job queue already, as long as it takes.
+func (h *Handler) sandboxMaxWait(ctx context.Context) time.Duration {
+	if queuedExec(ctx) {
+		return 0
+	}
+	return h.scheduling.withDefaults().MaxWait
+}
+
+// acquireExec takes a slot of sandbox id and then one of the server for
+// the exec of ctx, waiting for them as scheduling allows; with reject it
+// fails at once if the sandbox has no free slot. queued is told the place
+// it took if it waits for the sandbox, as is the caller once it got both.
+func (h *Handler) acquireExec(ctx context.Context, id string, reject bool, queued func(position int)) (release func(), position int, err error) {
+	var releases []func()
+	release = func() {
+		for _, r := range slices.Backward(releases) {
+			r()
+		}
+	}
+	// Wait for the sandbox first, so as not to hold a server slot while
+	// doing so
+	if h.sandboxGate != nil {
+		r, p, err := h.sandboxGate.acquire(ctx, id, reject && !queuedExec(ctx), h.sandboxMaxWait(ctx), queued)
+		if err != nil {
+			return nil, 0, err
+		}
+		releases = append(releases, r)
+		position = p
+	}
+	if h.scheduler != nil {
+		r, err := h.scheduler.acquire(ctx, queuedExec(ctx))
+		if err != nil {
+			release()
+			return nil, 0, err
+		}
+		releases = append(releases, r)
+	}
+	return release, position, nil
+}
+
+// sandboxGate bounds the execs running at once in each sandbox, the others
+// waiting their turn in the order they came.
+type sandboxGate struct {
+	slots int
+
+	mu        sync.Mutex
+	sandboxes map[string]*sandboxQueue
+	// avgRun is a moving average of how long execs hold a slot, for
+	// estimating how long a queued one will wait
+	avgRun time.Duration
+}
+
+// sandboxQueue holds the execs of one sandbox; it is dropped once none run
+// or wait.
+type sandboxQueue struct {
+	running int
+	waiting []chan struct{}
+}
+
+func newSandboxGate(slots int) *sandboxGate {
+	return &sandboxGate{slots: slots, sandboxes: make(map[string]*sandboxQueue), avgRun: time.Second}
+}
+
+// acquire waits for a slot of sandbox id and returns the function that
+// gives it back, and the place the exec took in the sandbox's queue, from
+// 1, or 0 if it did not wait; queued, if set, is called with it before
+// waiting. With reject set, it fails with 409 instead of waiting; unless
+// maxWait is 0, it fails so once it has waited that long.
+func (g *sandboxGate) acquire(ctx context.Context, id string, reject bool, maxWait time.Duration, queued func(position int)) (release func(), position int, err error) {
+	g.mu.Lock()
+	q, ok := g.sandboxes[id]
+	if !ok {
+		q = &sandboxQueue{}
+		g.sandboxes[id] = q
+	}
+	if q.running < g.slots && len(q.waiting) == 0 {
+		q.running++
+		g.mu.Unlock()
+		return g.releaser(id, q), 0, nil
+	}
+	position = len(q.waiting) + 1
+	if reject {
+		wait := g.estimate(position)
+		msg := fmt.Sprintf("sandbox is running %d execs, as many as it may", q.running)
+		g.mu.Unlock()
+		return nil, 0, g.busyError("rejected", position, wait, msg)
+	}
+	ready := make(chan struct{})
+	q.waiting = append(q.waiting, ready)
+	execSandboxQueueDepth.Add(1)
+	g.mu.Unlock()
+	if queued != nil {
+		queued(position)
+	}
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ready:
+		return g.releaser(id, q), position, nil
+	case <-ctx.Done():
+	case <-timeout:
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-ready:
+		// Given a slot as it gave up
+		if ctx.Err() == nil {
+			return g.releaser(id, q), position, nil
+		}
+		g.done(id, q)
+		return nil, 0, contextError(ctx)
+	default:
+	}
+	position = slices.Index(q.waiting, ready) + 1
+	q.waiting = slices.Delete(q.waiting, position-1, position)
+	execSandboxQueueDepth.Add(-1)
+	if ctx.Err() != nil {
+		return nil, 0, contextError(ctx)
+	}
+	return nil, 0, g.busyError("timed_out", position, g.estimate(position),
+		fmt.Sprintf("sandbox still busy after %s; %d execs were ahead", maxWait, position-1))
+}
+
+// releaser returns the function giving back a slot of q taken now.
+func (g *sandboxGate) releaser(id string, q *sandboxQueue) func() {
+	started := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.avgRun = (g.avgRun*7 + time.Since(started)) / 8
+			g.done(id, q)
+		})
+	}
+}
+
+// done frees a slot of q, handing it to the exec waiting longest, and
+// forgets q if nothing is left. Callers must hold g.mu.
+func (g *sandboxGate) done(id string, q *sandboxQueue) {
+	q.running--
+	if len(q.waiting) > 0 {
+		close(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		q.running++
+		execSandboxQueueDepth.Add(-1)
+	}
+	if q.running == 0 && len(q.waiting) == 0 {
+		delete(g.sandboxes, id)
+	}
+}
+
+// estimate guesses how long the exec at position will wait for a slot.
+// Callers must hold g.mu.
+func (g *sandboxGate) estimate(position int) time.Duration {
+	wait := g.avgRun * time.Duration(position) / time.Duration(g.slots)
+	return max(wait, time.Second)
+}
+
+// busyError is the error of an exec that found its sandbox busy.
+func (g *sandboxGate) busyError(reason string, position int, retryAfter time.Duration, msg string) *APIError {
+	execSandboxBusyTotal.Inc(reason)
+	err := newAPIError(http.StatusConflict, CodeSandboxBusy, msg)
+	err.QueuePosition = position
+	err.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	return err
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/labstack/echo/v4"
+)
+
+// mimeNDJSON is the type of execs streamed as JSON lines.
+const mimeNDJSON = "application/x-ndjson"
+
+// Types of ExecEvent.
+const (
+	ExecEventStdout   = "stdout"
+	ExecEventStderr   = "stderr"
+	ExecEventArtifact = "artifact"
+	ExecEventQueued   = "queued"
+	ExecEventExit     = "exit"
+	ExecEventError    = "error"
+)
+
+// ExecEvent is a step of a streamed exec. Output and artifacts are sent as
+// the sandbox produces them; the last event is an exit or an error.
+type ExecEvent struct {
+	Type string `json:"type"`
+
+	// Chunk is the output of stdout and stderr events, with secrets masked
+	Chunk string `json:"chunk,omitempty"`
+
+	// Artifact is set on artifact events
+	Artifact *proto.ArtifactEvent `json:"artifact,omitempty"`
+
+	// QueuePosition is set on the queued event of an exec waiting for its
+	// sandbox, sent before it runs, and on the exit event
+	QueuePosition int `json:"queue_position,omitempty"`
+
+	// The fields of ExecResponse other than the output, on the exit event
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	ExitReason  string `json:"exit_reason,omitempty"`
+	Signal      int    `json:"signal,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	StdoutBytes int64  `json:"stdout_bytes,omitempty"`
+	StderrBytes int64  `json:"stderr_bytes,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+	QueuedMS    int64  `json:"queued_ms,omitempty"`
+
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	// Error is why an exec that had started streaming failed
+	Error *APIError `json:"error,omitempty"`
+}
+
+// exitEvent is the exit event of a finished exec.
+func exitEvent(res *ExecResponse) ExecEvent {
+	return ExecEvent{
+		Type:        ExecEventExit,
+		ExitCode:    res.ExitCode,
+		ExitReason:  res.ExitReason,
+		Signal:      res.Signal,
+		Truncated:   res.Truncated,
+		StdoutBytes: res.StdoutBytes,
+		StderrBytes: res.StderrBytes,
+		Cached:      res.Cached,
+
+		QueuePosition: res.QueuePosition,
+		QueuedMS:      res.QueuedMS,
+
+		ErrorKind:    res.ErrorKind,
+		ErrorMessage: res.ErrorMessage,
+	}
+}
+
+// execEmitter passes the events of an exec to a callback until the exec
+// returns. The agent stream is read on its own goroutine, which may outlive
+// a timed-out exec for a moment. A nil emitter drops events.
+type execEmitter struct {
+	mu   sync.Mutex
+	fn   func(ExecEvent)
+	done bool
+}
+
+func newExecEmitter(fn func(ExecEvent)) *execEmitter {
+	if fn == nil {
+		return nil
+	}
+	return &execEmitter{fn: fn}
+}
+
+func (e *execEmitter) emit(ev ExecEvent) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.done {
+		e.fn(ev)
+	}
+}
+
+func (e *execEmitter) artifacts(artifacts []proto.ArtifactEvent) {
+	for i := range artifacts {
+		e.emit(ExecEvent{Type: ExecEventArtifact, Artifact: &artifacts[i]})
+	}
+}
+
+// replay emits the output and artifacts of a result that was not streamed,
+// such as a cached one.
+func (e *execEmitter) replay(res *ExecResponse) {
+	if res.Stdout != "" {
+		e.emit(ExecEvent{Type: ExecEventStdout, Chunk: res.Stdout})
+	}
+	if res.Stderr != "" {
+		e.emit(ExecEvent{Type: ExecEventStderr, Chunk: res.Stderr})
+	}
+	e.artifacts(res.Artifacts)
+}
+
+func (e *execEmitter) close() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done = true
+}
+
+// tee emits what is written to stdout and stderr, masking the secrets of
+// sandbox id.
+func (h *Handler) tee(e *execEmitter, id string, stdout, stderr *cappedOutput) {
+	if e == nil {
+		return
+	}
+	stdout.onWrite = func(s string) {
+		e.emit(ExecEvent{Type: ExecEventStdout, Chunk: h.secrets.redact(id, s)})
+	}
+	stderr.onWrite = func(s string) {
+		e.emit(ExecEvent{Type: ExecEventStderr, Chunk: h.secrets.redact(id, s)})
+	}
+}
+
+// ExecStream runs code like Exec and also calls emit with its output and
+// artifacts as the sandbox produces them, never concurrently and not after
+// it returns. The returned result holds the whole run, as from Exec.
+func (h *Handler) ExecStream(ctx context.Context, id string, req ExecRequest, emit func(ExecEvent)) (*ExecResponse, error) {
+	req, err := h.admitExec(ctx, id, req)
+	if err != nil {
+		return nil, err
+	}
+	events := newExecEmitter(emit)
+	defer events.close()
+	return h.exec(ctx, id, req, events)
+}
+
+// acceptsNDJSON reports whether the client asked for an exec streamed as
+// JSON lines.
+func acceptsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(echo.HeaderAccept), mimeNDJSON)
+}
+
+// streamExec serves POST /sandbox/:id/exec with "Accept:
+// application/x-ndjson": each ExecEvent is written and flushed as a JSON
+// line, ending with the exit event. Failures before the first event get
+// the usual error status; later ones end the stream with an error event.
+func (h *Handler) streamExec(c echo.Context, id string, req ExecRequest) error {
+	res := c.Response()
+	enc := json.NewEncoder(res)
+	write := func(ev ExecEvent) {
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+			res.Header().Set(echo.HeaderCacheControl, "no-cache")
+			res.WriteHeader(http.StatusOK)
+		}
+		if enc.Encode(ev) == nil {
+			res.Flush()
+		}
+	}
+
+	result, err := h.ExecStream(c.Request().Context(), id, req, write)
+	if err != nil {
+		var apiErr *APIError
+		if !res.Committed || !errors.As(err, &apiErr) {
+			return err
+		}
+		write(ExecEvent{Type: ExecEventError, Error: apiErr})
+		return nil
+	}
+	write(exitEvent(result))
+	return nil
+}
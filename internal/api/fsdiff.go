@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// FSDiffResponse lists the changes a sandbox made to its filesystem.
+type FSDiffResponse struct {
+	SandboxID string            `json:"sandbox_id"`
+	Changes   []driver.FSChange `json:"changes"`
+}
+
+func (h *Handler) getFSDiff(c echo.Context) error {
+	resp, err := h.FSDiff(c.Request().Context(), c.Param("id"), c.QueryParam("path"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// FSDiff returns the files and directories added, changed and removed
+// since the sandbox was created, so that agents can find what their code
+// wrote anywhere rather than only in /output. The descriptor the server
+// writes is left out; a non-empty dir keeps the changes at or under it. It is the transport independent core of GET
+// /sandbox/:id/fsdiff; errors are *APIError.
+func (h *Handler) FSDiff(ctx context.Context, id, dir string) (*FSDiffResponse, error) {
+	fd, ok := h.driver.(driver.FSDiffer)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not track filesystem changes")
+	}
+	if dir != "" {
+		if !path.IsAbs(dir) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "path must be absolute")
+		}
+		dir = path.Clean(dir)
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := fd.FSDiff(ctx, id)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	kept := []driver.FSChange{}
+	for _, c := range changes {
+		if under(c.Path, path.Dir(DescriptorPath)) || (dir != "" && !under(c.Path, dir)) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return &FSDiffResponse{SandboxID: id, Changes: kept}, nil
+}
+
+// under reports whether p is dir or a path under it.
+func under(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
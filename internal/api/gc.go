@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Limits on what the GC report keeps in memory.
+const (
+	maxGCRuns   = 50
+	maxGCReaped = 500
+)
+
+// GC run triggers.
+const (
+	GCTriggerAPI = "api"
+)
+
+var (
+	gcRunsTotal = metrics.Default.Counter("boxed_gc_runs_total",
+		"Garbage collection runs.", "trigger", "dry_run")
+	gcRemovedTotal = metrics.Default.Counter("boxed_gc_removed_total",
+		"Resources removed by garbage collection, including TTL expiries.", "kind", "reason")
+	gcFailedTotal = metrics.Default.Counter("boxed_gc_failed_total",
+		"Resources garbage collection failed to remove.", "kind", "reason")
+	gcReclaimedBytes = metrics.Default.Counter("boxed_gc_reclaimed_bytes_total",
+		"Disk space reclaimed by garbage collection.")
+	gcLastRun = metrics.Default.Gauge("boxed_gc_last_run_timestamp_seconds",
+		"Unix time of the last garbage collection run.")
+)
+
+// GCRun is one on-demand garbage collection pass.
+type GCRun struct {
+	ID         string          `json:"id"`
+	Trigger    string          `json:"trigger"`
+	DryRun     bool            `json:"dry_run"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Items      []driver.GCItem `json:"items"`
+	Error      string          `json:"error,omitempty"`
+
+	// Removed and ReclaimedBytes only count successful removals; on a dry
+	// run they are what a real run would achieve.
+	Removed        int   `json:"removed"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// GCTotals aggregates removals since the server started. Dry runs are not
+// counted.
+type GCTotals struct {
+	Runs           int   `json:"runs"`
+	Removed        int   `json:"removed"`
+	Failed         int   `json:"failed"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// GCReport is the response of GET /admin/gc/report.
+type GCReport struct {
+	// Runs are on-demand runs, newest first
+	Runs []GCRun `json:"runs"`
+
+	// Reaped are resources the driver removed in the background (startup
+	// orphan sweep, TTL expiries), newest first
+	Reaped []driver.GCItem `json:"reaped"`
+
+	Totals GCTotals `json:"totals"`
+}
+
+type GCRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// gcLog keeps recent garbage collection activity for the report.
+type gcLog struct {
+	mu     sync.Mutex
+	runs   []GCRun
+	reaped []driver.GCItem
+	totals GCTotals
+}
+
+func newGCLog() *gcLog {
+	return &gcLog{}
+}
+
+// count updates the metrics and totals for a removal. Callers hold l.mu.
+func (l *gcLog) count(item driver.GCItem) {
+	if item.Error != "" {
+		gcFailedTotal.Inc(item.Kind, item.Reason)
+		l.totals.Failed++
+		return
+	}
+	gcRemovedTotal.Inc(item.Kind, item.Reason)
+	gcReclaimedBytes.Add(float64(item.Bytes))
+	l.totals.Removed++
+	l.totals.ReclaimedBytes += item.Bytes
+}
+
+func (l *gcLog) addRun(run GCRun) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.runs = append([]GCRun{run}, l.runs...)
+	if len(l.runs) > maxGCRuns {
+		l.runs = l.runs[:maxGCRuns]
+	}
+	if run.DryRun {
+		return
+	}
+	l.totals.Runs++
+	for _, item := range run.Items {
+		l.count(item)
+	}
+}
+
+func (l *gcLog) addReaped(item driver.GCItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reaped = append([]driver.GCItem{item}, l.reaped...)
+	if len(l.reaped) > maxGCReaped {
+		l.reaped = l.reaped[:maxGCReaped]
+	}
+	l.count(item)
+}
+
+func (l *gcLog) report() GCReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return GCReport{
+		Runs:   append([]GCRun{}, l.runs...),
+		Reaped: append([]driver.GCItem{}, l.reaped...),
+		Totals: l.totals,
+	}
+}
+
+// reaped records a resource the driver removed on its own. Expired
+// sandboxes are also closed out in the store, like an API stop.
+func (h *Handler) reaped(item driver.GCItem) {
+	// The report entry is added last so it is seen after the cleanup
+	defer h.gc.addReaped(item)
+	log.Info().Str("kind", item.Kind).Str("id", item.ID).Str("reason", item.Reason).
+		Int64("bytes", item.Bytes).Str("error", item.Error).Msg("Garbage collected")
+
+	if item.Kind != "sandbox" || item.Error != "" {
+		return
+	}
+	ctx := context.Background()
+	h.recordEvent(item.ID, state.EventStopped, item.At, "reason: "+item.Reason, nil)
+	h.usageStopped(item.ID, item.At)
+	if rec, err := h.store.GetSandbox(ctx, item.ID); err == nil {
+		rec.State = state.SandboxStopped
+		h.store.PutSandbox(ctx, rec)
+	}
+	h.publish(LifecycleEvent{Type: LifecycleStopped, SandboxID: item.ID, Reason: item.Reason})
+	h.store.DeleteSandbox(ctx, item.ID)
+	h.releaseArtifacts(item.ID)
+	h.sessions.closeSandbox(item.ID)
+	h.uploads.closeSandbox(item.ID)
+	h.kernels.closeSandbox(item.ID)
+	if h.egress != nil {
+		h.egress.closeSandbox(item.ID)
+	}
+}
+
+// RunGC runs garbage collection now and records it in the report. With
+// dryRun set nothing is removed. As it removes the resources of every
+// owner, it needs the admin scope. Errors are *APIError.
+func (h *Handler) RunGC(ctx context.Context, dryRun bool) (*GCRun, error) {
+	if !isAdmin(ctx) {
+		return nil, errGCAdmin
+	}
+	gc, ok := h.driver.(driver.GarbageCollector)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support garbage collection")
+	}
+
+	run := GCRun{
+		ID:        newJobID("gc_"),
+		Trigger:   GCTriggerAPI,
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+	}
+	items, err := gc.CollectGarbage(ctx, dryRun)
+	run.FinishedAt = time.Now()
+	if items == nil {
+		items = []driver.GCItem{}
+	}
+	run.Items = items
+	for _, item := range items {
+		if item.Error == "" {
+			run.Removed++
+			run.ReclaimedBytes += item.Bytes
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	gcRunsTotal.Inc(run.Trigger, boolLabel(dryRun))
+	gcLastRun.Set(float64(run.FinishedAt.Unix()))
+	h.gc.addRun(run)
+	log.Info().Str("run", run.ID).Bool("dry_run", dryRun).Int("removed", run.Removed).
+		Int64("bytes", run.ReclaimedBytes).Err(err).Msg("Garbage collection finished")
+
+	if err != nil {
+		return nil, driverError(err)
+	}
+	return &run, nil
+}
+
+// errGCAdmin refuses garbage collection to callers without the admin scope.
+var errGCAdmin = newAPIError(http.StatusForbidden, CodeForbidden, "garbage collection needs the admin scope")
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+func (h *Handler) runGC(c echo.Context) error {
+	// The body is optional: an empty POST runs a real collection
+	var req GCRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	run, err := h.RunGC(c.Request().Context(), req.DryRun)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, run)
+}
+
+func (h *Handler) gcReport(c echo.Context) error {
+	if !isAdmin(c.Request().Context()) {
+		return errGCAdmin
+	}
+	return c.JSON(http.StatusOK, h.gc.report())
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+const (
+	// maxGitBytes caps the files of a cloned repository, .git included
+	maxGitBytes = 64 << 20
+
+	// gitTimeout bounds a clone
+	gitTimeout = 2 * time.Minute
+)
+
+// WithGitAllowCIDRs lets creates clone repositories from the private
+// ranges allow, such as that of an internal git server. Others outside the
+// internet are refused, as by the egress proxy.
+func WithGitAllowCIDRs(allow []netip.Prefix) Option {
+	return func(h *Handler) {
+		h.gitAddrs.allow = allow
+	}
+}
+
+// commitPattern matches refs that are commit hashes, which cannot be cloned
+// by name and are fetched instead.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// GitSource is a repository cloned into the working directory of a new
+// sandbox. The control plane clones it, so the sandbox needs neither git
+// nor network access.
+type GitSource struct {
+	// URL is the http(s) URL of the repository
+	URL string `json:"url"`
+
+	// Ref is the branch, tag or commit to check out; by default the
+	// repository's default branch
+	Ref string `json:"ref,omitempty"`
+
+	// Token authenticates to private repositories. It is sent as the
+	// password of HTTP basic auth and is not stored in the sandbox.
+	Token string `json:"token,omitempty"`
+}
+
+// validate checks the source before anything is run with it.
+func (g *GitSource) validate() error {
+	u, err := url.Parse(g.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "git url must be an http or https URL")
+	}
+	if strings.HasPrefix(g.Ref, "-") || strings.ContainsAny(g.Ref, " \t\n") {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid git ref: "+g.Ref)
+	}
+	return nil
+}
+
+// resolve looks up the host of the source and returns the addresses of it
+// git may connect to, as a curl resolve entry pinning them, so that the
+// name cannot resolve elsewhere while git runs; addresses need no entry. A
+// host with none of them, e.g. one on loopback or serving the cloud
+// metadata service, is refused.
+func (g *GitSource) resolve(ctx context.Context, policy addrPolicy) (string, error) {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest, "git url must be an http or https URL")
+	}
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	notInternet := newAPIError(http.StatusBadRequest, CodeInvalidRequest, "git host "+host+" is not on the internet")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if policy.blocked(addr) {
+			return "", notInternet
+		}
+		return "", nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", wrapAPIError(http.StatusBadRequest, CodeInvalidRequest, "cannot resolve git host "+host, err)
+	}
+	var allowed []string
+	for _, addr := range addrs {
+		if policy.blocked(addr) {
+			continue
+		}
+		addr = addr.Unmap().WithZone("")
+		if addr.Is6() {
+			// As curl wants them
+			allowed = append(allowed, "["+addr.String()+"]")
+		} else {
+			allowed = append(allowed, addr.String())
+		}
+	}
+	if len(allowed) == 0 {
+		return "", notInternet
+	}
+	return host + ":" + port + ":" + strings.Join(allowed, ","), nil
+}
+
+// displayURL is the URL without credentials, for messages and for the
+// origin remote in the sandbox.
+func (g *GitSource) displayURL() string {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return g.URL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// gitEnv is the environment git runs in: no prompts, only http(s), the
+// host's addresses pinned to those of resolve, no redirects, which could
+// lead anywhere, and the token passed as configuration rather than on the
+// command line.
+func (g *GitSource) gitEnv(resolve string) []string {
+	config := [][2]string{
+		{"protocol.allow", "never"},
+		{"protocol.http.allow", "always"},
+		{"protocol.https.allow", "always"},
+		{"http.followRedirects", "false"},
+		{"advice.detachedHead", "false"},
+	}
+	if resolve != "" {
+		config = append(config, [2]string{"http.curloptResolve", resolve})
+	}
+	if g.Token != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + g.Token))
+		config = append(config, [2]string{"http.extraHeader", "Authorization: Basic " + auth})
+	}
+
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_CONFIG_COUNT="+strconv.Itoa(len(config)))
+	for i, kv := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+	}
+	return env
+}
+
+// cloneGit fetches the commit src names, without history, and returns the
+// repository's files, .git included, as context files for the working
+// directory. It also returns the commit checked out. Hosts policy blocks are
+// refused.
+func cloneGit(ctx context.Context, src GitSource, policy addrPolicy) ([]driver.FileInjection, string, error) {
+	if err := src.validate(); err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	resolve, err := src.resolve(ctx, policy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tmp, err := os.MkdirTemp("", "boxed-git-")
+	if err != nil {
+		return nil, "", wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to clone repository", err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "repo")
+
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = src.gitEnv(resolve)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return "", wrapAPIError(http.StatusRequestTimeout, CodeTimedOut, "timed out cloning "+src.displayURL(), driver.ErrTimeout)
+			}
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = err.Error()
+			}
+			return "", wrapAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("failed to clone %s: %s", src.displayURL(), msg), err)
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	steps := [][]string{{"clone", "-q", "--depth", "1", "--no-tags", "--", src.URL, dir}}
+	switch {
+	case commitPattern.MatchString(src.Ref):
+		steps = [][]string{
+			{"init", "-q", dir},
+			{"-C", dir, "remote", "add", "origin", src.URL},
+			{"-C", dir, "fetch", "-q", "--depth", "1", "--no-tags", "origin", src.Ref},
+			{"-C", dir, "checkout", "-q", "FETCH_HEAD"},
+		}
+	case src.Ref != "":
+		steps[0] = []string{"clone", "-q", "--depth", "1", "--no-tags", "--branch", src.Ref, "--", src.URL, dir}
+	}
+	steps = append(steps,
+		[]string{"-C", dir, "remote", "set-url", "origin", src.displayURL()},
+		// File modes are not kept by context injection
+		[]string{"-C", dir, "config", "core.fileMode", "false"},
+	)
+	for _, args := range steps {
+		if _, err := git(args...); err != nil {
+			return nil, "", err
+		}
+	}
+	if _, err := git("-C", dir, "symbolic-ref", "-q", "HEAD"); err != nil {
+		// A detached HEAD leaves .git/refs without files, and empty
+		// directories are not injected; git needs the directory
+		if _, err := git("-C", dir, "update-ref", "refs/remotes/origin/HEAD", "HEAD"); err != nil {
+			return nil, "", err
+		}
+	}
+	commit, err := git("-C", dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+	// Sample hooks are only noise in the sandbox
+	os.RemoveAll(filepath.Join(dir, ".git", "hooks"))
+
+	files, err := readTree(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return files, commit, nil
+}
+
+// readTree returns the regular files under dir as context files with paths
+// relative to it. Symbolic links cannot be injected and are skipped.
+func readTree(dir string) ([]driver.FileInjection, error) {
+	var files []driver.FileInjection
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if total += int64(len(data)); total > maxGitBytes {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("repository exceeds %d MiB", maxGitBytes>>20))
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files = append(files, driver.FileInjection{
+			Path:          filepath.ToSlash(rel),
+			ContentBase64: base64.StdEncoding.EncodeToString(data),
+		})
+		return nil
+	})
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return nil, err
+		}
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to read repository", err)
+	}
+	return files, nil
+}
+
+// gitDigest folds the commit a sandbox was cloned at into its context
+// digest; the clone's .git differs between clones of one commit.
+func gitDigest(commit, digest string) string {
+	sum := sha256.Sum256([]byte("git\x00" + commit + "\x00" + digest))
+	return hex.EncodeToString(sum[:])
+}
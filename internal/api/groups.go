@@ -0,0 +1,410 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// GroupLabel is the metadata key naming the session group of a sandbox.
+// Callers cannot set it: CreateSandboxRequest.Session does.
+const GroupLabel = "boxed.session"
+
+// groupIDPrefix starts the IDs the server gives session groups.
+const groupIDPrefix = "grp_"
+
+// Statuses of a session group, from the states of its sandboxes.
+const (
+	// GroupEmpty has no sandboxes
+	GroupEmpty = "empty"
+	// GroupCreating has sandboxes still being created, and none failed
+	GroupCreating = "creating"
+	// GroupReady has every sandbox ready
+	GroupReady = "ready"
+	// GroupDegraded has sandboxes that failed or stopped beside others
+	GroupDegraded = "degraded"
+	// GroupStopped has every sandbox stopped
+	GroupStopped = "stopped"
+)
+
+// GroupRequest is the body of POST /sessions.
+type GroupRequest struct {
+	// ID names the group, e.g. after the conversation it serves; by
+	// default the server picks one
+	ID string `json:"id,omitempty"`
+
+	// TTL is the lifetime of the group and its sandboxes in seconds; by
+	// default 5 minutes
+	TTL int `json:"ttl,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// GroupInfo describes a session group: the sandboxes an agent uses for one
+// conversation, which share a lifetime and are stopped together.
+type GroupInfo struct {
+	ID        string            `json:"id"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+
+	// Status sums up the states of the sandboxes; see GroupReady
+	Status string `json:"status"`
+
+	// States counts the sandboxes in each state
+	States map[driver.SandboxState]int `json:"states"`
+
+	// Sandboxes are the group's sandboxes; only GET /sessions/:session
+	// lists them
+	Sandboxes []*driver.SandboxInfo `json:"sandboxes,omitempty"`
+}
+
+// GroupTTLResponse reports the new expiry of a group, and the sandboxes
+// whose lifetime could not follow it.
+type GroupTTLResponse struct {
+	ID        string        `json:"id"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	Failed    []StopFailure `json:"failed"`
+}
+
+type group struct {
+	id        string
+	metadata  map[string]string
+	createdAt time.Time
+	expiresAt time.Time
+	// owner holds the owner labels of the creator, which its sandboxes
+	// carry too
+	owner map[string]string
+	timer *time.Timer
+}
+
+// groupRegistry keeps the session groups, in memory only.
+type groupRegistry struct {
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+func newGroupRegistry() *groupRegistry {
+	return &groupRegistry{groups: make(map[string]*group)}
+}
+
+// get returns group id if the caller may use it.
+func (r *groupRegistry) get(ctx context.Context, id string) (*group, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.groups[id]
+	if !ok || !owns(ctx, g.owner) {
+		return nil, newAPIError(http.StatusNotFound, CodeNotFound, "session not found: "+id)
+	}
+	return g, nil
+}
+
+// remove forgets group id.
+func (r *groupRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if g, ok := r.groups[id]; ok {
+		g.timer.Stop()
+		delete(r.groups, id)
+	}
+}
+
+// status sums up the states of a group's sandboxes.
+func groupStatus(states map[driver.SandboxState]int) string {
+	total := 0
+	for _, n := range states {
+		total += n
+	}
+	switch {
+	case total == 0:
+		return GroupEmpty
+	case states[driver.StateReady] == total:
+		return GroupReady
+	case states[driver.StateStopped] == total:
+		return GroupStopped
+	case states[driver.StateError] > 0:
+		return GroupDegraded
+	case states[driver.StateCreating] > 0:
+		return GroupCreating
+	}
+	return GroupDegraded
+}
+
+func (g *group) info(sandboxes []*driver.SandboxInfo) *GroupInfo {
+	info := &GroupInfo{
+		ID:        g.id,
+		Metadata:  g.metadata,
+		CreatedAt: g.createdAt,
+		ExpiresAt: g.expiresAt,
+		States:    make(map[driver.SandboxState]int),
+	}
+	for _, sb := range sandboxes {
+		info.States[sb.State]++
+	}
+	info.Status = groupStatus(info.States)
+	return info
+}
+
+func newGroupID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return groupIDPrefix + hex.EncodeToString(b)
+}
+
+func (h *Handler) createGroup(c echo.Context) error {
+	var req GroupRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	info, err := h.CreateGroup(c.Request().Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, info)
+}
+
+// CreateGroup starts a session group, which sandboxes join when created
+// with its ID in CreateSandboxRequest.Session. It is the transport
+// independent core of POST /sessions; errors are *APIError.
+func (h *Handler) CreateGroup(ctx context.Context, req GroupRequest) (*GroupInfo, error) {
+	if req.ID != "" && (!sessionName.MatchString(req.ID) || strings.HasPrefix(req.ID, groupIDPrefix)) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			"session id must be letters, digits, '.', '_' and '-', up to 63 characters, not starting with "+groupIDPrefix)
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl == 0 {
+		ttl = min(5*time.Minute, h.maxTTL)
+	}
+	if ttl < 0 || ttl > h.maxTTL || (h.maxAge > 0 && ttl > h.maxAge) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("ttl must be between 1s and %s", min(h.maxTTL, cmp.Or(h.maxAge, h.maxTTL))))
+	}
+	owner, err := ownerLabels(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	g := &group{
+		id:        cmp.Or(req.ID, newGroupID()),
+		metadata:  req.Metadata,
+		createdAt: now,
+		expiresAt: now.Add(ttl),
+		owner:     owner,
+	}
+	h.groups.mu.Lock()
+	defer h.groups.mu.Unlock()
+	if _, ok := h.groups.groups[g.id]; ok {
+		return nil, newAPIError(http.StatusConflict, CodeConflict, "session already exists: "+g.id)
+	}
+	h.groups.groups[g.id] = g
+	g.timer = time.AfterFunc(ttl, func() { h.expireGroup(g) })
+	log.Info().Str("session", g.id).Str("owner", owner[OwnerLabel]).Msg("Session group created")
+	return g.info(nil), nil
+}
+
+func (h *Handler) listGroups(c echo.Context) error {
+	groups, err := h.ListGroups(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"sessions": groups})
+}
+
+// ListGroups returns the caller's session groups, oldest first, without
+// their sandboxes. It is the transport independent core of GET /sessions;
+// errors are *APIError.
+func (h *Handler) ListGroups(ctx context.Context) ([]*GroupInfo, error) {
+	sandboxes, err := h.ListSandboxes(ctx, ListFilter{Labels: []string{GroupLabel}})
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]*driver.SandboxInfo)
+	for _, sb := range sandboxes {
+		id := sb.Config.Labels[GroupLabel]
+		members[id] = append(members[id], sb)
+	}
+
+	h.groups.mu.Lock()
+	defer h.groups.mu.Unlock()
+	infos := []*GroupInfo{}
+	for _, g := range h.groups.groups {
+		if owns(ctx, g.owner) {
+			infos = append(infos, g.info(members[g.id]))
+		}
+	}
+	slices.SortFunc(infos, func(a, b *GroupInfo) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return infos, nil
+}
+
+func (h *Handler) getGroup(c echo.Context) error {
+	info, err := h.GetGroup(c.Request().Context(), c.Param("session"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// GetGroup describes a session group with its sandboxes. It is the
+// transport independent core of GET /sessions/:session; errors are
+// *APIError.
+func (h *Handler) GetGroup(ctx context.Context, id string) (*GroupInfo, error) {
+	g, err := h.groups.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sandboxes, err := h.ListSandboxes(ctx, ListFilter{Labels: []string{GroupLabel + "=" + id}})
+	if err != nil {
+		return nil, err
+	}
+	h.groups.mu.Lock()
+	defer h.groups.mu.Unlock()
+	info := g.info(sandboxes)
+	info.Sandboxes = sandboxes
+	return info, nil
+}
+
+func (h *Handler) setGroupTTL(c echo.Context) error {
+	var req TTLRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.SetGroupTTL(c.Request().Context(), c.Param("session"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SetGroupTTL moves the expiry of a session group and of its running
+// sandboxes. Sandboxes that cannot follow, such as those past the maximum
+// age, are reported and keep their expiry. It is the transport independent
+// core of POST /sessions/:session/ttl; errors are *APIError.
+func (h *Handler) SetGroupTTL(ctx context.Context, id string, req TTLRequest) (*GroupTTLResponse, error) {
+	if (req.TTL == 0) == (req.ExtendBy == 0) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "exactly one of ttl and extend_by is required")
+	}
+	g, err := h.groups.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	h.groups.mu.Lock()
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(req.TTL) * time.Second)
+	if req.ExtendBy != 0 {
+		expiresAt = g.expiresAt.Add(time.Duration(req.ExtendBy) * time.Second)
+	}
+	remaining := expiresAt.Sub(now)
+	if remaining <= 0 || remaining > h.maxTTL {
+		h.groups.mu.Unlock()
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("the remaining lifetime must be between 1s and %s", h.maxTTL))
+	}
+	g.expiresAt = expiresAt.UTC()
+	g.timer.Reset(remaining)
+	h.groups.mu.Unlock()
+
+	sandboxes, err := h.ListSandboxes(ctx, ListFilter{
+		States: []driver.SandboxState{driver.StateReady},
+		Labels: []string{GroupLabel + "=" + id},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &GroupTTLResponse{ID: id, ExpiresAt: g.expiresAt, Failed: []StopFailure{}}
+	for _, sb := range sandboxes {
+		if _, err := h.SetTTL(ctx, sb.ID, TTLRequest{TTL: max(1, int(remaining/time.Second))}); err != nil {
+			resp.Failed = append(resp.Failed, StopFailure{ID: sb.ID, Error: err.Error()})
+		}
+	}
+	return resp, nil
+}
+
+func (h *Handler) deleteGroup(c echo.Context) error {
+	res, err := h.DeleteGroup(c.Request().Context(), c.Param("session"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// DeleteGroup ends a session group and stops its sandboxes. Sandboxes that
+// fail to stop are reported, not returned as an error. It is the transport
+// independent core of DELETE /sessions/:session; errors are *APIError.
+func (h *Handler) DeleteGroup(ctx context.Context, id string) (*StopResult, error) {
+	if _, err := h.groups.get(ctx, id); err != nil {
+		return nil, err
+	}
+	// Removed first, so that no sandbox joins while the others stop
+	h.groups.remove(id)
+	log.Info().Str("session", id).Msg("Session group deleted")
+	return h.StopSandboxes(ctx, ListFilter{Labels: []string{GroupLabel + "=" + id}}, false)
+}
+
+// expireGroup stops the sandboxes of g once it expires. Their own TTL ends
+// at the same time; this catches those that missed an extension.
+func (h *Handler) expireGroup(g *group) {
+	h.groups.mu.Lock()
+	if h.groups.groups[g.id] != g || time.Now().Before(g.expiresAt) {
+		h.groups.mu.Unlock()
+		return
+	}
+	delete(h.groups.groups, g.id)
+	h.groups.mu.Unlock()
+
+	log.Info().Str("session", g.id).Msg("Session group expired")
+	ctx := context.Background()
+	sandboxes, err := h.driver.List(ctx, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("session", g.id).Msg("Failed to list the sandboxes of an expired session")
+		return
+	}
+	for _, sb := range sandboxes {
+		if sb.Config.Labels[GroupLabel] == g.id && sb.State != driver.StateStopped {
+			if err := h.stop(ctx, sb.ID, "session expired"); err != nil {
+				log.Warn().Err(err).Str("id", sb.ID).Str("session", g.id).Msg("Failed to stop sandbox of an expired session")
+			}
+		}
+	}
+}
+
+// joinGroup returns the labels and timeout, in seconds, of a sandbox
+// created in session group id: labels with GroupLabel added, and the
+// group's remaining lifetime.
+func (h *Handler) joinGroup(ctx context.Context, id string, labels map[string]string, timeout int) (map[string]string, int, error) {
+	if timeout != 0 {
+		return nil, 0, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "sandboxes of a session share its ttl; set it on the session")
+	}
+	g, err := h.groups.get(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	h.groups.mu.Lock()
+	remaining := time.Until(g.expiresAt)
+	h.groups.mu.Unlock()
+	if remaining < time.Second {
+		return nil, 0, newAPIError(http.StatusConflict, CodeConflict, "session is expiring: "+id)
+	}
+	joined := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		joined[k] = v
+	}
+	joined[GroupLabel] = id
+	return joined, int(remaining / time.Second), nil
+}
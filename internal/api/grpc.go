@@ -0,0 +1,520 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCService is the gRPC service mirroring the v1 REST API. Its messages
+// are the JSON bodies of the REST API, sent with the "json" codec (content
+// type "application/grpc+json"), so that neither side needs generated code.
+const GRPCService = "boxed.v1.Boxed"
+
+// Metadata of gRPC calls: the API key and bearer token of a call, and the
+// error code and sandbox of a failed one, in its trailer.
+const (
+	GRPCAPIKeyHeader    = "x-boxed-api-key"
+	GRPCErrorCodeHeader = "x-boxed-error-code"
+	GRPCSandboxHeader   = "x-boxed-sandbox-id"
+
+	// GRPCQueuePositionHeader and GRPCRetryAfterHeader carry the
+	// QueuePosition and RetryAfter of an exec that found no slot
+	GRPCQueuePositionHeader = "x-boxed-queue-position"
+	GRPCRetryAfterHeader    = "x-boxed-retry-after"
+)
+
+func init() {
+	encoding.RegisterCodec(grpcCodec{})
+}
+
+// grpcCodec encodes messages as JSON, as driver plugins do.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (grpcCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (grpcCodec) Name() string                       { return "json" }
+
+// Messages of GRPCService that the REST API carries in the path or query.
+type (
+	// GRPCSandboxRequest names the sandbox of GetSandbox and StopSandbox;
+	// the other sandbox calls embed it
+	GRPCSandboxRequest struct {
+		SandboxID string `json:"sandbox_id"`
+	}
+	GRPCListRequest struct {
+		States []driver.SandboxState `json:"states,omitempty"`
+		Labels []string              `json:"labels,omitempty"`
+	}
+	GRPCListResponse struct {
+		Sandboxes []*driver.SandboxInfo `json:"sandboxes"`
+	}
+	GRPCExecRequest struct {
+		GRPCSandboxRequest
+		ExecRequest
+	}
+	GRPCSignalRequest struct {
+		GRPCSandboxRequest
+		SignalRequest
+	}
+	GRPCTTLRequest struct {
+		GRPCSandboxRequest
+		TTLRequest
+	}
+	GRPCResourcesRequest struct {
+		GRPCSandboxRequest
+		ResourcesRequest
+	}
+	GRPCWakeRequest struct {
+		GRPCSandboxRequest
+		WakeRequest
+	}
+	GRPCPublishTemplateRequest struct {
+		GRPCSandboxRequest
+		PublishTemplateRequest
+	}
+
+	// GRPCInteractMessage is a message of Interact from the client. The
+	// first names the sandbox and the REPL's language ("python", bash by
+	// default); later ones type Data into the REPL or send it Signal.
+	GRPCInteractMessage struct {
+		SandboxID string `json:"sandbox_id,omitempty"`
+		Language  string `json:"language,omitempty"`
+		Data      string `json:"data,omitempty"`
+		Signal    string `json:"signal,omitempty"`
+	}
+
+	grpcEmpty struct{}
+)
+
+// The streaming methods, indexed in grpcServiceDesc.Streams.
+const (
+	grpcStreamExec = iota
+	grpcStreamInteract
+)
+
+// grpcServiceDesc describes GRPCService; Handler implements it.
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		grpcUnary("CreateSandbox", (*Handler).grpcCreate),
+		grpcUnary("GetSandbox", (*Handler).grpcGet),
+		grpcUnary("ListSandboxes", (*Handler).grpcList),
+		grpcUnary("StopSandbox", (*Handler).grpcStop),
+		grpcUnary("Exec", (*Handler).grpcExec),
+		grpcUnary("Signal", (*Handler).grpcSignal),
+		grpcUnary("SetTTL", (*Handler).grpcSetTTL),
+		grpcUnary("UpdateResources", (*Handler).grpcUpdateResources),
+		grpcUnary("Hibernate", (*Handler).grpcHibernate),
+		grpcUnary("Wake", (*Handler).grpcWake),
+		grpcUnary("ListTemplates", (*Handler).grpcTemplates),
+		grpcUnary("PublishTemplate", (*Handler).grpcPublishTemplate),
+	},
+	Streams: []grpc.StreamDesc{
+		grpcStreamExec:     {StreamName: "ExecStream", Handler: grpcStream((*Handler).grpcExecStream), ServerStreams: true},
+		grpcStreamInteract: {StreamName: "Interact", Handler: grpcStream((*Handler).grpcInteract), ServerStreams: true, ClientStreams: true},
+	},
+}
+
+// grpcUnary describes a unary method served by call.
+func grpcUnary[Req, Resp any](name string, call func(*Handler, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				h := srv.(*Handler)
+				ctx, err := h.grpcAuth(ctx)
+				if err != nil {
+					return nil, grpcStatus(ctx, err)
+				}
+				resp, err := call(h, ctx, req.(*Req))
+				return resp, grpcStatus(ctx, err)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + GRPCService + "/" + name}, handler)
+		},
+	}
+}
+
+// grpcStream adapts a streaming method of Handler.
+func grpcStream(call func(*Handler, grpc.ServerStream) error) grpc.StreamHandler {
+	return func(srv any, stream grpc.ServerStream) error {
+		h := srv.(*Handler)
+		ctx, err := h.grpcAuth(stream.Context())
+		if err == nil {
+			err = call(h, &authedStream{stream, ctx})
+		}
+		return grpcStatus(stream.Context(), err)
+	}
+}
+
+// authedStream is a stream whose context carries the caller's claims.
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
+
+// NewGRPCServer returns a gRPC server serving GRPCService with h, for
+// programs that want typed streaming calls multiplexed on one connection.
+// Serve it on a listener of its own, or next to the REST API with
+// MountGRPC.
+func (h *Handler) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{grpc.MaxRecvMsgSize(h.inputs.BodySize)}, opts...)...)
+	s.RegisterService(&grpcServiceDesc, h)
+	return s
+}
+
+// MountGRPC serves the gRPC requests that reach e with s, so that the port
+// of the REST API serves both. It enables HTTP/2 without TLS on e's server,
+// which gRPC clients connect with.
+func MountGRPC(e *echo.Echo, s *grpc.Server) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	e.Server.Protocols = protocols
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get(echo.HeaderContentType), "application/grpc") {
+				return next(c)
+			}
+			s.ServeHTTP(c.Response().Writer, r)
+			return nil
+		}
+	})
+}
+
+// grpcAuth checks the API key or bearer token of a call, as the REST API
+// does its headers, and returns ctx with the token's claims.
+func (h *Handler) grpcAuth(ctx context.Context) (context.Context, error) {
+	if !h.authenticates() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 && h.tokens != nil {
+		scheme, token, ok := strings.Cut(values[0], " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+			claims, err := h.tokens.Verify(ctx, token)
+			if err != nil {
+				log.Debug().Err(err).Msg("Rejected bearer token")
+				return ctx, newAPIError(http.StatusUnauthorized, CodeUnauthorized, "invalid bearer token")
+			}
+			return auth.WithClaims(ctx, claims), nil
+		}
+	}
+	if keys := md.Get(GRPCAPIKeyHeader); len(keys) > 0 && keys[0] != "" {
+		if h.apiKey != "" && keys[0] == h.apiKey {
+			return ctx, nil
+		}
+		if k, ok := h.keys[keys[0]]; ok {
+			return auth.WithClaims(ctx, k.claims()), nil
+		}
+	}
+	return ctx, newAPIError(http.StatusUnauthorized, CodeUnauthorized, "invalid or missing API key")
+}
+
+// grpcCodes maps the error codes of the REST API to gRPC codes.
+var grpcCodes = map[string]codes.Code{
+	CodeInvalidRequest:    codes.InvalidArgument,
+	CodeUnauthorized:      codes.Unauthenticated,
+	CodeForbidden:         codes.PermissionDenied,
+	CodeNotFound:          codes.NotFound,
+	CodeSandboxNotFound:   codes.NotFound,
+	CodeSandboxNotRunning: codes.FailedPrecondition,
+	CodeConflict:          codes.Aborted,
+	CodeQuotaExceeded:     codes.ResourceExhausted,
+	CodeTimedOut:          codes.DeadlineExceeded,
+	CodeCanceled:          codes.Canceled,
+	CodeNotImplemented:    codes.Unimplemented,
+	CodeUnavailable:       codes.Unavailable,
+	CodeSetupFailed:       codes.FailedPrecondition,
+	CodeInternal:          codes.Internal,
+}
+
+// grpcStatus turns an error into a gRPC status, with the error code of the
+// REST API, the sandbox a failed create left behind and the queue position
+// of an exec that found no slot in the trailer.
+func grpcStatus(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = driverError(err)
+	}
+	if apiErr.Status >= http.StatusInternalServerError && apiErr.Err != nil {
+		log.Error().Err(apiErr.Err).Str("code", apiErr.Code).Msg(apiErr.Message)
+	}
+	trailer := metadata.Pairs(GRPCErrorCodeHeader, apiErr.Code)
+	if apiErr.SandboxID != "" {
+		trailer.Set(GRPCSandboxHeader, apiErr.SandboxID)
+	}
+	if apiErr.QueuePosition > 0 {
+		trailer.Set(GRPCQueuePositionHeader, strconv.Itoa(apiErr.QueuePosition))
+		trailer.Set(GRPCRetryAfterHeader, strconv.Itoa(apiErr.RetryAfter))
+	}
+	grpc.SetTrailer(ctx, trailer)
+	code, ok := grpcCodes[apiErr.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	return status.Error(code, apiErr.Message)
+}
+
+// grpcSandbox resolves the sandbox of a call. Unlike the REST API, gRPC
+// calls are not proxied to the cluster node serving the sandbox.
+func (h *Handler) grpcSandbox(ctx context.Context, ref string) (string, error) {
+	id, err := h.resolveID(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if rec, err := h.store.GetSandbox(ctx, id); err == nil && !h.servedHere(rec) {
+		return "", newAPIError(http.StatusServiceUnavailable, CodeUnavailable,
+			fmt.Sprintf("sandbox %s is served by node %s; call it there", id, rec.Node))
+	}
+	return id, nil
+}
+
+func (h *Handler) grpcCreate(ctx context.Context, req *CreateSandboxRequest) (*CreateSandboxResponse, error) {
+	return h.CreateSandbox(ctx, *req)
+}
+
+func (h *Handler) grpcGet(ctx context.Context, req *GRPCSandboxRequest) (*driver.SandboxInfo, error) {
+	return h.GetSandbox(ctx, req.SandboxID)
+}
+
+func (h *Handler) grpcList(ctx context.Context, req *GRPCListRequest) (*GRPCListResponse, error) {
+	list, err := h.ListSandboxes(ctx, ListFilter{States: req.States, Labels: req.Labels})
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCListResponse{Sandboxes: list}, nil
+}
+
+func (h *Handler) grpcStop(ctx context.Context, req *GRPCSandboxRequest) (*grpcEmpty, error) {
+	if err := h.StopSandbox(ctx, req.SandboxID); err != nil {
+		return nil, err
+	}
+	return &grpcEmpty{}, nil
+}
+
+func (h *Handler) grpcExec(ctx context.Context, req *GRPCExecRequest) (*ExecResponse, error) {
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return h.Exec(ctx, id, req.ExecRequest)
+}
+
+func (h *Handler) grpcSignal(ctx context.Context, req *GRPCSignalRequest) (*SignalResponse, error) {
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return h.Signal(ctx, id, req.SignalRequest)
+}
+
+func (h *Handler) grpcSetTTL(ctx context.Context, req *GRPCTTLRequest) (*TTLResponse, error) {
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return h.SetTTL(ctx, id, req.TTLRequest)
+}
+
+func (h *Handler) grpcUpdateResources(ctx context.Context, req *GRPCResourcesRequest) (*ResourcesResponse, error) {
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return h.UpdateResources(ctx, id, req.ResourcesRequest)
+}
+
+func (h *Handler) grpcHibernate(ctx context.Context, req *GRPCSandboxRequest) (*driver.SandboxInfo, error) {
+	return h.HibernateSandbox(ctx, req.SandboxID)
+}
+
+func (h *Handler) grpcWake(ctx context.Context, req *GRPCWakeRequest) (*driver.SandboxInfo, error) {
+	return h.WakeSandbox(ctx, req.SandboxID, req.WakeRequest)
+}
+
+func (h *Handler) grpcTemplates(ctx context.Context, _ *grpcEmpty) (*TemplateList, error) {
+	return h.Templates(ctx), nil
+}
+
+func (h *Handler) grpcPublishTemplate(ctx context.Context, req *GRPCPublishTemplateRequest) (*TemplateInfo, error) {
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return nil, err
+	}
+	return h.PublishTemplate(ctx, id, req.PublishTemplateRequest)
+}
+
+// grpcExecStream serves ExecStream: it sends the ExecEvents of an exec as
+// the sandbox produces them, ending with the exit event. Failures before
+// the first event end the call with their status; later ones are sent as
+// an error event, as over REST.
+func (h *Handler) grpcExecStream(stream grpc.ServerStream) error {
+	var req GRPCExecRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	id, err := h.grpcSandbox(ctx, req.SandboxID)
+	if err != nil {
+		return err
+	}
+	sent := false
+	result, err := h.ExecStream(ctx, id, req.ExecRequest, func(ev ExecEvent) {
+		sent = true
+		stream.SendMsg(&ev)
+	})
+	if err != nil {
+		var apiErr *APIError
+		if !sent || !errors.As(err, &apiErr) {
+			return err
+		}
+		return stream.SendMsg(&ExecEvent{Type: ExecEventError, Error: apiErr})
+	}
+	ev := exitEvent(result)
+	return stream.SendMsg(&ev)
+}
+
+// grpcInteract serves Interact: it starts a REPL in the sandbox the first
+// message names, types the data of later messages into it, and sends the
+// agent's messages, the JSON-RPC notifications of GET
+// /sandbox/:id/interact. The call ends when the REPL exits or the client
+// closes its side, which ends the REPL; unlike WebSocket sessions it
+// cannot be resumed.
+func (h *Handler) grpcInteract(stream grpc.ServerStream) error {
+	var start GRPCInteractMessage
+	if err := stream.RecvMsg(&start); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	id, err := h.grpcSandbox(ctx, start.SandboxID)
+	if err != nil {
+		return err
+	}
+	if err := h.admitInteractive(ctx, id); err != nil {
+		return err
+	}
+	cmd := "bash"
+	if start.Language == "python" {
+		cmd = "python3"
+	}
+
+	end := h.activity.begin("session")
+	defer end()
+	conn, err := h.driver.Connect(ctx, id)
+	if err != nil {
+		return driverError(err)
+	}
+	defer conn.Close()
+	var connMu sync.Mutex
+	write := func(method string, params map[string]any, reqID any) error {
+		msg, _ := json.Marshal(proto.NewRequest(method, params, reqID))
+		connMu.Lock()
+		defer connMu.Unlock()
+		_, err := conn.Write(append(msg, '\n'))
+		return err
+	}
+	if err := write("repl.start", map[string]any{"cmd": cmd}, 1); err != nil {
+		return driverError(err)
+	}
+
+	// Client messages go to the REPL until the client closes its side
+	inputDone := make(chan error, 1)
+	go func() {
+		for {
+			var msg GRPCInteractMessage
+			if err := stream.RecvMsg(&msg); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				inputDone <- err
+				conn.Close()
+				return
+			}
+			if msg.Signal != "" {
+				if _, sig, ok := parseSignal(msg.Signal); ok {
+					sendSignal(&connMu, conn, "", sig)
+				}
+			}
+			if msg.Data != "" {
+				write("repl.input", map[string]any{"data": msg.Data}, nil)
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := []byte(h.secrets.redact(id, scanner.Text()))
+		for _, msg := range splitMessage(line) {
+			if err := stream.SendMsg(json.RawMessage(msg)); err != nil {
+				return err
+			}
+		}
+		var n struct {
+			Method string `json:"method"`
+		}
+		if json.Unmarshal(line, &n) == nil && n.Method == "exit" {
+			return nil
+		}
+	}
+	select {
+	case err := <-inputDone:
+		return err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx)
+	}
+	return newAPIError(http.StatusServiceUnavailable, CodeUnavailable, "connection to the sandbox ended")
+}
+
+// StopGRPC stops s, letting running calls finish until ctx is done.
+func StopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
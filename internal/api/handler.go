@@ -2,177 +2,1459 @@ package api
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
 	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/instrument"
+	"github.com/akshayaggarwal99/boxed/internal/driver/shortid"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
 	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/akshayaggarwal99/boxed/internal/ui"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			return true // CLI/SDK directly connecting
-		}
-		// In production, this should be configurable.
-		// For now, allow localhost and any origin if no host is set (CLI/tests)
-		return strings.HasPrefix(origin, "http://localhost") || strings.HasPrefix(origin, "https://localhost")
-	},
-}
-
 type Handler struct {
 	driver driver.Driver
 	apiKey string
+	store  state.Store
+	pulls  *pullJobs
+	gc     *gcLog
+
+	// ids gives sandboxes short IDs; driver is ids, wrapping the backend,
+	// with its calls timed
+	ids *shortid.Driver
+
+	// slowDriverOps override the thresholds above which driver calls are
+	// logged as slow; see instrument.Wrap
+	slowDriverOps map[string]time.Duration
+
+	// activity tracks in-flight work for Drain
+	activity *activity
+
+	// sessions are the interactive sessions clients can reattach to
+	sessions *sessionRegistry
+
+	// uploads are the chunked uploads in progress
+	uploads *uploadRegistry
+
+	// jobs are the execs queued to run in the background
+	jobs *jobRegistry
+
+	// packages are the package installations in progress
+	packages *packageBuilds
+
+	// kernels are the kernels of the execs of session languages, such as
+	// python-session
+	kernels *kernelRegistry
+
+	// egress connects sandboxes asking for internet access to it; without
+	// it they get none. See NewEgressProxy.
+	egress *EgressProxy
+
+	// gitAddrs are the addresses creates may clone repositories from
+	gitAddrs addrPolicy
+
+	// callbackAddrs are the addresses job callbacks may be sent to
+	callbackAddrs addrPolicy
+
+	// procs are the running execs signals can be sent to
+	procs *procRegistry
+
+	// secrets are the secrets sandboxes and execs can be given
+	secrets *secretRegistry
+
+	// groups are the session groups sandboxes share a lifetime in
+	groups *groupRegistry
+
+	// artifacts keeps exec artifacts by content hash; nil if disabled
+	artifacts *artifacts.Store
+
+	// maxOutput caps the bytes of stdout and of stderr kept per exec
+	maxOutput int
+
+	// downloadStall is how long a file download may make no progress
+	// before it is given up; 0 never gives up
+	downloadStall time.Duration
+
+	// maxContextFileSize and maxContextSize cap the decoded bytes of each
+	// context file of a create and of all of them
+	maxContextFileSize int
+	maxContextSize     int
+
+	// inputs bound the code, command lines, environments and bodies of
+	// requests
+	inputs InputLimits
+
+	// maxTTL caps the remaining lifetime of a sandbox
+	maxTTL time.Duration
+	ttlMu  sync.Mutex
+
+	// maxAge caps the total lifetime of a sandbox; 0 means no cap
+	maxAge time.Duration
+
+	// limit caps the number of live sandboxes
+	limit sandboxLimit
+
+	// tokens verifies bearer tokens; nil if only the API key is accepted
+	tokens TokenVerifier
+
+	// keys are further API keys, by key, whose callers own their sandboxes
+	keys map[string]APIKey
+
+	// execCache keeps results for execs that ask for caching; nil if disabled
+	execCache     *execCache
+	execCacheSize int
+
+	// security hardens sandboxes created without their own settings,
+	// except those of trustedImages; seccompDir holds the seccomp
+	// profiles creates can name
+	security      driver.Security
+	trustedImages []string
+	seccompDir    string
+
+	// cluster is set when the handler is one of several nodes sharing
+	// the store; nil otherwise
+	cluster *cluster
+
+	// events broadcasts lifecycle events; expiryWarning is how long before
+	// expiry ttl_warning is sent
+	events        *eventBus
+	expiryWarning time.Duration
+
+	// usage meters what sandboxes consume, every usageInterval; nil if
+	// the store does not keep usage
+	usage         *usageMeter
+	usageInterval time.Duration
+
+	// templates resolves the templates sandboxes are created from
+	templates *templates.Catalog
+	// publishMu serializes PublishTemplate, so that a name's owner check
+	// and the snapshot replacing its image happen together
+	publishMu sync.Mutex
+	// building are the templates whose image BuildImage is building,
+	// which nothing else may replace meanwhile; guarded by publishMu
+	building map[string]bool
+
+	// admission are the webhooks reviewing creates and execs, in order
+	admission []AdmissionWebhook
+
+	// cors are the origins whose pages may call the API
+	cors CORSPolicy
+
+	// dashboard serves the web dashboard at /ui
+	dashboard bool
+
+	// scheduler shares the exec slots between callers; nil if execs run
+	// as they come
+	scheduler  *execScheduler
+	scheduling ExecScheduling
+
+	// sandboxGate bounds the execs running at once in each sandbox; nil
+	// if there is no bound
+	sandboxGate *sandboxGate
+
+	// readyPoolMin is the warm sandboxes a pooled driver needs for /readyz
+	readyPoolMin int
+	startedAt    time.Time
+}
+
+// Option configures optional Handler behaviour.
+type Option func(*Handler)
+
+// WithDashboard serves the web dashboard at /ui. The page itself needs no
+// credentials; the API calls it makes do.
+func WithDashboard() Option {
+	return func(h *Handler) {
+		h.dashboard = true
+	}
+}
+
+// WithMaxOutput caps the stdout and stderr captured per exec to n bytes each.
+// Values <= 0 keep DefaultMaxOutput.
+func WithMaxOutput(n int) Option {
+	return func(h *Handler) {
+		if n > 0 {
+			h.maxOutput = n
+		}
+	}
 }
 
-func NewHandler(d driver.Driver, apiKey string) *Handler {
-	return &Handler{
-		driver: d,
-		apiKey: apiKey,
+// WithMaxContextFileSize caps the decoded size of each context file of a
+// create to n bytes. Values <= 0 keep DefaultMaxContextFileSize.
+func WithMaxContextFileSize(n int) Option {
+	return func(h *Handler) {
+		if n > 0 {
+			h.maxContextFileSize = n
+		}
+	}
+}
+
+// WithMaxContextSize caps the decoded size of all the context files of a
+// create to n bytes. Values <= 0 keep DefaultMaxContextSize.
+func WithMaxContextSize(n int) Option {
+	return func(h *Handler) {
+		if n > 0 {
+			h.maxContextSize = n
+		}
+	}
+}
+
+// WithMaxTTL caps the remaining lifetime a sandbox can be created with or
+// extended to. Values <= 0 keep DefaultMaxTTL.
+func WithMaxTTL(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.maxTTL = d
+		}
+	}
+}
+
+// WithMaxSandboxAge caps how long after its creation a sandbox may live,
+// however its TTL is extended. Values <= 0 leave only WithMaxTTL.
+func WithMaxSandboxAge(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.maxAge = d
+		}
+	}
+}
+
+// WithMaxSandboxes refuses creates with 429 while n sandboxes are live.
+// Values <= 0 mean no limit.
+func WithMaxSandboxes(n int) Option {
+	return func(h *Handler) {
+		h.limit.max = n
+	}
+}
+
+// WithExecCacheSize keeps up to n exec results for requests that set
+// "cache". Negative values disable the cache, 0 keeps DefaultExecCacheSize.
+func WithExecCacheSize(n int) Option {
+	return func(h *Handler) {
+		if n != 0 {
+			h.execCacheSize = n
+		}
+	}
+}
+
+// WithTokenVerifier accepts "Authorization: Bearer" tokens checked by v, in
+// addition to the API key. The token's subject and organisation become the
+// owner of the sandboxes it creates.
+func WithTokenVerifier(v TokenVerifier) Option {
+	return func(h *Handler) {
+		h.tokens = v
+	}
+}
+
+// WithAPIKeys accepts keys in addition to the API key of NewHandler. Unlike
+// it, each is a caller of its own: it owns the sandboxes it creates and
+// can only see and use those, unless it is an admin key.
+func WithAPIKeys(keys []APIKey) Option {
+	return func(h *Handler) {
+		h.keys = make(map[string]APIKey, len(keys))
+		for _, k := range keys {
+			h.keys[k.Key] = k
+		}
+	}
+}
+
+// WithArtifactStore keeps inline exec artifacts in s, deduplicated by
+// content, and serves them from GET /artifacts/:digest.
+func WithArtifactStore(s *artifacts.Store) Option {
+	return func(h *Handler) {
+		h.artifacts = s
+	}
+}
+
+// WithSlowDriverOps logs driver calls slower than the threshold of their
+// operation, such as "create", in place of instrument.DefaultThresholds; 0
+// turns the warning off for an operation.
+func WithSlowDriverOps(thresholds map[string]time.Duration) Option {
+	return func(h *Handler) {
+		h.slowDriverOps = thresholds
+	}
+}
+
+func NewHandler(d driver.Driver, apiKey string, opts ...Option) *Handler {
+	ids := shortid.Wrap(d)
+	h := &Handler{
+		driver:    ids,
+		apiKey:    apiKey,
+		ids:       ids,
+		store:     state.NewMemoryStore(),
+		pulls:     newPullJobs(),
+		gc:        newGCLog(),
+		activity:  newActivity(),
+		sessions:  newSessionRegistry(),
+		uploads:   newUploadRegistry(),
+		jobs:      newJobRegistry(),
+		packages:  newPackageBuilds(),
+		maxOutput: DefaultMaxOutput,
+		maxTTL:    DefaultMaxTTL,
+
+		maxContextFileSize: DefaultMaxContextFileSize,
+		maxContextSize:     DefaultMaxContextSize,
+		inputs:             InputLimits{}.withDefaults(),
+		downloadStall:      DefaultDownloadStallTimeout,
+
+		kernels: newKernelRegistry(),
+		procs:   newProcRegistry(),
+		secrets: newSecretRegistry(),
+		groups:  newGroupRegistry(),
+
+		gitAddrs:      newAddrPolicy(nil),
+		callbackAddrs: newAddrPolicy(nil),
+
+		execCacheSize: DefaultExecCacheSize,
+		expiryWarning: DefaultExpiryWarning,
+		usageInterval: DefaultUsageInterval,
+		templates:     templates.Builtin(),
+		building:      make(map[string]bool),
+		startedAt:     time.Now(),
+	}
+	h.events = newEventBus(h.watchSandboxes)
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.driver = instrument.Wrap(ids, h.slowDriverOps)
+	if h.execCacheSize > 0 {
+		h.execCache = newExecCache(h.execCacheSize)
+	}
+	if h.scheduling.Slots > 0 {
+		h.scheduler = newExecScheduler(h.scheduling, h.keys)
+	}
+	if h.scheduling.SandboxSlots > 0 {
+		h.sandboxGate = newSandboxGate(h.scheduling.SandboxSlots)
+	}
+	if _, ok := d.(driver.GarbageCollector); ok {
+		ids.SetReapHook(h.reaped)
+	}
+	if h.cluster != nil {
+		h.startCluster()
 	}
+	h.startUsage()
+	return h
 }
 
 func (h *Handler) RegisterRoutes(e *echo.Echo) {
+	// Errors carry a stable "code" clients can branch on
+	e.HTTPErrorHandler = ErrorHandler
+	e.Use(traceRequests)
+	e.Use(h.limitBody())
+	if len(h.cors.AllowedOrigins) > 0 {
+		e.Pre(h.corsMiddleware())
+	}
+
 	v1 := e.Group("/v1")
 
 	// Apply Auth Middleware if API Key is configured
-	if h.apiKey != "" {
+	var auth []echo.MiddlewareFunc
+	if h.authenticates() {
+		auth = append(auth, h.authMiddleware)
 		v1.Use(h.authMiddleware)
 	}
+	// Sandbox IDs may be abbreviated
+	v1.Use(h.resolveSandbox)
+	// Sandboxes served by other nodes are proxied to them
+	v1.Use(h.routeSandbox)
+
+	// Probes for load balancers and orchestrators; unauthenticated
+	e.GET("/healthz", h.healthz)
+	e.GET("/readyz", h.readyz)
+
+	// Web dashboard; its page calls the API with the caller's credentials
+	if h.dashboard {
+		files := echo.WrapHandler(http.StripPrefix("/ui", ui.Handler()))
+		e.GET("/ui", func(c echo.Context) error {
+			return c.Redirect(http.StatusMovedPermanently, "/ui/")
+		})
+		e.GET("/ui/*", files)
+	}
+
+	// Prometheus scrape endpoint
+	e.GET("/metrics", echo.WrapHandler(metrics.Default.Handler()), auth...)
 
 	v1.POST("/sandbox", h.createSandbox)
 	v1.POST("/sandbox/:id/exec", h.execSandbox)
+	v1.GET("/sandbox/:id", h.getSandbox)
 	v1.DELETE("/sandbox/:id", h.stopSandbox)
 	v1.GET("/sandbox", h.listSandboxes)
+	v1.DELETE("/sandbox", h.stopSandboxes)
+	v1.POST("/sessions", h.createGroup)
+	v1.GET("/sessions", h.listGroups)
+	v1.GET("/sessions/:session", h.getGroup)
+	v1.POST("/sessions/:session/ttl", h.setGroupTTL)
+	v1.DELETE("/sessions/:session", h.deleteGroup)
+	v1.GET("/sandbox/:id/execs", h.listExecs)
+	v1.POST("/sandbox/:id/jobs", h.createJob)
+	v1.GET("/sandbox/:id/jobs", h.listJobs)
+	v1.GET("/sandbox/:id/timeline", h.getTimeline)
+	v1.GET("/sandbox/:id/init", h.getInit)
+	v1.GET("/sandbox/:id/logs", h.getLogs)
+	v1.GET("/sandbox/:id/stats", h.getStats)
+	v1.GET("/sandbox/:id/stats/stream", h.streamStats)
+	v1.POST("/sandbox/:id/ttl", h.setTTL)
+	v1.PATCH("/sandbox/:id/resources", h.updateResources)
+	v1.POST("/sandbox/:id/hibernate", h.hibernateSandbox)
+	v1.POST("/sandbox/:id/wake", h.wakeSandbox)
+	v1.GET("/sandbox/:id/fsdiff", h.getFSDiff)
+	v1.POST("/sandbox/:id/signal", h.signalSandbox)
+	v1.POST("/sandbox/:id/publish-template", h.publishTemplate)
+	v1.GET("/sandbox/:id/descriptor", h.getDescriptor)
+
+	// Filesystem API
+	v1.GET("/sandbox/:id/files", h.listFiles)
+	v1.POST("/sandbox/:id/files", h.uploadFile)
+	v1.GET("/sandbox/:id/files/content", h.downloadFile)
+	v1.HEAD("/sandbox/:id/files/content", h.downloadFile)
+	v1.GET("/sandbox/:id/files/usage", h.getFilesUsage)
+	v1.GET("/sandbox/:id/interact", h.interactSandbox)
+
+	// Chunked uploads
+	v1.POST("/sandbox/:id/uploads", h.createUpload)
+	v1.GET("/sandbox/:id/uploads/:upload", h.getUpload)
+	v1.PATCH("/sandbox/:id/uploads/:upload", h.appendUpload)
+	v1.POST("/sandbox/:id/uploads/:upload/commit", h.commitUpload)
+	v1.DELETE("/sandbox/:id/uploads/:upload", h.abortUpload)
+
+	// Exec jobs
+	v1.GET("/jobs/:job", h.getJob)
+	v1.DELETE("/jobs/:job", h.cancelJob)
+
+	// Image cache
+	v1.GET("/images", h.listImages)
+	v1.POST("/images/pull", h.pullImage)
+	v1.GET("/images/pull/:job", h.getPullJob)
+	v1.POST("/images/build", h.buildImage)
+
+	// Templates sandboxes are created from
+	v1.GET("/templates", h.listTemplates)
+
+	// Artifact store
+	v1.GET("/artifacts", h.artifactStats)
+	v1.GET("/artifacts/:digest", h.getArtifact)
+
+	// Base workspaces
+	v1.POST("/workspaces", h.createWorkspace)
+	v1.GET("/workspaces", h.listWorkspaces)
+	v1.GET("/workspaces/:name", h.getWorkspace)
+	v1.PUT("/workspaces/:name", h.replaceWorkspace)
+	v1.DELETE("/workspaces/:name", h.deleteWorkspace)
+	v1.POST("/volumes", h.createVolume)
+	v1.GET("/volumes", h.listVolumes)
+	v1.GET("/volumes/:name", h.getVolume)
+	v1.DELETE("/volumes/:name", h.deleteVolume)
+
+	// Secrets injected into sandboxes
+	v1.POST("/secrets", h.createSecret)
+	v1.GET("/secrets", h.listSecrets)
+	v1.GET("/secrets/:name", h.getSecret)
+	v1.PUT("/secrets/:name", h.putSecret)
+	v1.DELETE("/secrets/:name", h.deleteSecret)
+
+	// Registry credentials
+	v1.GET("/registries", h.listRegistries)
+	v1.PUT("/registries/:registry", h.putRegistry)
+	v1.DELETE("/registries/:registry", h.deleteRegistry)
+
+	// Garbage collection
+	v1.POST("/admin/gc", h.runGC)
+	v1.GET("/admin/gc/report", h.gcReport)
+
+	// Control-plane nodes
+	v1.GET("/cluster/nodes", h.listNodes)
+
+	// Resource usage for charging back
+	v1.GET("/usage", h.getUsage)
+
+	// Lifecycle events
+	v1.GET("/events", h.streamEvents)
+}
+
+func (h *Handler) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// A bearer token identifies the caller; it is checked even when an
+		// API key is configured too
+		if token, ok := bearerToken(c.Request()); ok && h.tokens != nil {
+			claims, err := h.tokens.Verify(c.Request().Context(), token)
+			if err != nil {
+				log.Debug().Err(err).Str("path", c.Path()).Msg("Rejected bearer token")
+				return newAPIError(http.StatusUnauthorized, CodeUnauthorized, "invalid bearer token")
+			}
+			c.SetRequest(c.Request().WithContext(auth.WithClaims(c.Request().Context(), claims)))
+			return next(c)
+		}
+
+		key := c.Request().Header.Get("X-Boxed-API-Key")
+		if key == "" {
+			// Also support Query param for easier debugging/CLI
+			key = c.QueryParam("api_key")
+		}
+
+		if h.apiKey != "" && key == h.apiKey {
+			return next(c)
+		}
+		if k, ok := h.keys[key]; ok && key != "" {
+			c.SetRequest(c.Request().WithContext(auth.WithClaims(c.Request().Context(), k.claims())))
+			return next(c)
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing API key")
+	}
+}
+
+// authenticates reports whether requests must carry an API key or token.
+func (h *Handler) authenticates() bool {
+	return h.apiKey != "" || len(h.keys) > 0 || h.tokens != nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or of
+// the access_token query parameter for browser WebSockets, which cannot set
+// headers. Other requests may not pass it in the query, which ends up in
+// access logs and browser history.
+func bearerToken(r *http.Request) (string, bool) {
+	if token := r.URL.Query().Get("access_token"); token != "" && websocket.IsWebSocketUpgrade(r) {
+		return token, true
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// ListFilter selects sandboxes. Empty fields match everything.
+type ListFilter struct {
+	// States the sandbox may be in
+	States []driver.SandboxState
+
+	// Labels the sandbox must all have, as "key=value" or just "key"
+	Labels []string
+}
+
+// listFilter reads the state and label query parameters.
+func listFilter(c echo.Context) ListFilter {
+	var filter ListFilter
+	for _, s := range c.QueryParams()["state"] {
+		for _, st := range strings.Split(s, ",") {
+			filter.States = append(filter.States, driver.SandboxState(st))
+		}
+	}
+	filter.Labels = c.QueryParams()["label"]
+	return filter
+}
+
+func (h *Handler) listSandboxes(c echo.Context) error {
+	sandboxes, err := h.ListSandboxes(c.Request().Context(), listFilter(c))
+	if err != nil {
+		return err
+	}
+	// The other nodes of a cluster list their own sandboxes
+	seen := make(map[string]bool, len(sandboxes))
+	for _, info := range sandboxes {
+		seen[info.ID] = true
+	}
+	for _, res := range fanOut[struct {
+		Sandboxes []*driver.SandboxInfo `json:"sandboxes"`
+	}](h, c) {
+		for _, info := range res.Sandboxes {
+			if !seen[info.ID] {
+				seen[info.ID] = true
+				sandboxes = append(sandboxes, info)
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"sandboxes": sandboxes})
+}
+
+// ListSandboxes returns the sandboxes matching filter. It is the transport
+// independent core of GET /sandbox; errors are *APIError.
+func (h *Handler) ListSandboxes(ctx context.Context, filter ListFilter) ([]*driver.SandboxInfo, error) {
+	for _, st := range filter.States {
+		switch st {
+		case driver.StateCreating, driver.StateReady, driver.StateStopping, driver.StateStopped, driver.StateHibernated, driver.StateError, driver.StateFailed:
+		default:
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "unknown state: "+string(st))
+		}
+	}
+
+	// Failed creates are gone from the driver; only the store knows them
+	states := slices.DeleteFunc(slices.Clone(filter.States), func(st driver.SandboxState) bool { return st == driver.StateFailed })
+	failed := len(states) < len(filter.States)
+	var all []*driver.SandboxInfo
+	if !failed || len(states) > 0 {
+		var err error
+		if all, err = h.driver.List(ctx, states); err != nil {
+			return nil, driverError(err)
+		}
+	}
+	filter.Labels = ownerFilter(ctx, filter.Labels)
+	sandboxes := []*driver.SandboxInfo{}
+	if failed {
+		records, err := h.store.ListSandboxes(ctx)
+		if err != nil {
+			return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list sandboxes", err)
+		}
+		for _, rec := range records {
+			if rec.State == state.SandboxFailed && hasLabels(rec.Labels, filter.Labels) {
+				sandboxes = append(sandboxes, h.failedInfo(rec))
+			}
+		}
+	}
+	for _, info := range all {
+		if !hasLabels(info.Config.Labels, filter.Labels) {
+			continue
+		}
+		if rec, err := h.store.GetSandbox(ctx, info.ID); err == nil {
+			setPhases(info, rec)
+			if expires(rec) {
+				info.ExpiresAt = &rec.ExpiresAt
+			}
+		}
+		h.secrets.scrub(info)
+		sandboxes = append(sandboxes, info)
+	}
+	return sandboxes, nil
+}
+
+// hasLabels reports whether labels satisfies every "key=value" or "key"
+// selector.
+func hasLabels(labels map[string]string, selectors []string) bool {
+	for _, sel := range selectors {
+		key, value, withValue := strings.Cut(sel, "=")
+		v, ok := labels[key]
+		if !ok || (withValue && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) getSandbox(c echo.Context) error {
+	info, err := h.GetSandbox(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// GetSandbox returns runtime information about a sandbox, including failed
+// creates the driver no longer knows about. It is the transport independent
+// core of GET /sandbox/:id; errors are *APIError.
+func (h *Handler) GetSandbox(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.driver.Info(ctx, id)
+	rec, rerr := h.store.GetSandbox(ctx, id)
+	if errors.Is(err, driver.ErrSandboxNotFound) && rerr == nil && rec.State == state.SandboxFailed {
+		// Failed creates are gone from the driver but remain queryable
+		return h.failedInfo(rec), nil
+	}
+	if err != nil {
+		return nil, driverError(err)
+	}
+	if rerr == nil {
+		setPhases(info, rec)
+		if expires(rec) {
+			info.ExpiresAt = &rec.ExpiresAt
+		}
+	}
+	h.secrets.scrub(info)
+	return info, nil
+}
+
+// failedInfo describes the failed create of rec, which the driver no
+// longer knows about.
+func (h *Handler) failedInfo(rec state.SandboxRecord) *driver.SandboxInfo {
+	info := &driver.SandboxInfo{
+		ID:         rec.ID,
+		BackendID:  rec.BackendID,
+		State:      driver.StateFailed,
+		CreatedAt:  rec.CreatedAt,
+		Config:     driver.SandboxConfig{Image: rec.Image, Labels: rec.Labels},
+		DriverType: h.driver.DriverName(),
+		Error:      rec.Error,
+	}
+	setPhases(info, rec)
+	return info
+}
+
+type CreateSandboxRequest struct {
+	// Template names a template of the server's catalog or an image; by
+	// default the catalog's default template is used
+	Template      string                 `json:"template"`
+	Timeout       int                    `json:"timeout"`
+	Metadata      map[string]string      `json:"metadata"`
+	NetworkPolicy driver.NetworkPolicy   `json:"network_policy"`
+	Context       []driver.FileInjection `json:"context"`
+	Sidecars      []driver.Sidecar       `json:"sidecars"`
+
+	// Workspace names a base workspace to mount copy-on-write at the
+	// working directory; Context files are written on top of it
+	Workspace string `json:"workspace,omitempty"`
+
+	// Volumes are persistent volumes to mount; see /volumes
+	Volumes []driver.VolumeMount `json:"volumes,omitempty"`
+
+	// Driver picks the backend on servers running several; by default the
+	// server's routing policy decides
+	Driver string `json:"driver,omitempty"`
+
+	// Git is a repository cloned into the working directory, on top of
+	// Workspace and below Context files
+	Git *GitSource `json:"git,omitempty"`
+
+	// User runs code as this user instead of root, by default; see
+	// driver.SandboxConfig.User
+	User string `json:"user,omitempty"`
+
+	// Secrets are registered secrets to inject as environment variables
+	// or files. Their values are masked in exec output and never shown in
+	// the sandbox's info.
+	Secrets []SecretRef `json:"secrets,omitempty"`
+
+	// Packages are installed before the sandbox is ready. The result is
+	// saved as an image, which later creates with the same template and
+	// packages start from.
+	Packages *Packages `json:"packages,omitempty"`
+
+	// Security hardens the sandbox, replacing the server's default (see
+	// WithSecurity); an empty object keeps the driver's defaults.
+	// SeccompProfile names a profile of the server's seccomp directory or
+	// a built-in one, and replaces the template's.
+	Security *driver.Security `json:"security,omitempty"`
+
+	// Platform is the image platform to run, e.g. "linux/amd64"; see
+	// driver.SandboxConfig.Platform
+	Platform string `json:"platform,omitempty"`
+
+	// GPU passes GPUs of the host through to the sandbox; see
+	// driver.SandboxConfig.GPU
+	GPU *driver.GPURequest `json:"gpu,omitempty"`
+
+	// NetworkGroup puts the sandbox on a network shared with the other
+	// sandboxes of the group, which reach it at NetworkAlias; see
+	// driver.SandboxConfig.NetworkGroup
+	NetworkGroup string `json:"network_group,omitempty"`
+	NetworkAlias string `json:"network_alias,omitempty"`
+
+	// DNS, DNSSearch and DNSOptions replace the name servers, search
+	// domains and options of the sandbox's resolv.conf, and ExtraHosts
+	// are added to its /etc/hosts; see driver.SandboxConfig.DNS
+	DNS        []string          `json:"dns,omitempty"`
+	DNSSearch  []string          `json:"dns_search,omitempty"`
+	DNSOptions []string          `json:"dns_options,omitempty"`
+	ExtraHosts map[string]string `json:"extra_hosts,omitempty"`
+
+	// Session puts the sandbox in a session group (see /sessions), whose
+	// lifetime it shares; Timeout must then be unset
+	Session string `json:"session,omitempty"`
+}
+
+type CreateSandboxResponse struct {
+	SandboxID string `json:"sandbox_id"`
+	Status    string `json:"status"`
+
+	// GitCommit is the commit checked out from CreateSandboxRequest.Git
+	GitCommit string `json:"git_commit,omitempty"`
+
+	// Setup reports the installation of CreateSandboxRequest.Packages
+	Setup *SetupResult `json:"setup,omitempty"`
+
+	// Platform is that of the sandbox's image, if the driver runs images,
+	// and Emulated is set when the host runs it under emulation
+	Platform string `json:"platform,omitempty"`
+	Emulated bool   `json:"emulated,omitempty"`
+
+	// GPUs are the GPUs the sandbox was given for CreateSandboxRequest.GPU
+	GPUs []driver.GPU `json:"gpus,omitempty"`
+
+	// Context reports the injection of CreateSandboxRequest.Context
+	Context *ContextReport `json:"context,omitempty"`
+
+	// Init is the run of the template's init script, if it has one
+	Init *state.ExecRecord `json:"init,omitempty"`
+
+	// Warnings are things about the sandbox the caller may not expect,
+	// such as it running under emulation
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (h *Handler) createSandbox(c echo.Context) error {
+	var req CreateSandboxRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.CreateSandbox(c.Request().Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// CreateSandbox creates and starts a sandbox. It is the transport independent
+// core of POST /sandbox; errors are *APIError.
+func (h *Handler) CreateSandbox(ctx context.Context, req CreateSandboxRequest) (*CreateSandboxResponse, error) {
+	end := h.activity.begin("create")
+	defer end()
+	if h.activity.isDraining() {
+		return nil, errDraining
+	}
+	req, err := h.admitCreate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	// Phases the create goes through before the sandbox has a record
+	var phaseMu sync.Mutex
+	phases := []state.PhaseChange{{Phase: string(driver.PhaseCreating), At: time.Now()}}
+	labels, err := ownerLabels(ctx, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if req.Session != "" {
+		if labels, req.Timeout, err = h.joinGroup(ctx, req.Session, labels, req.Timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpl, err := h.templates.Resolve(req.Template)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	}
+	image := tmpl.Image
+
+	cfg := driver.SandboxConfig{
+		Image:         image,
+		MemoryMB:      tmpl.MemoryMB,
+		CPUCores:      tmpl.CPUCores,
+		CPUBurst:      tmpl.CPUBurst,
+		Labels:        labels,
+		Timeout:       time.Duration(req.Timeout) * time.Second,
+		NetworkPolicy: req.NetworkPolicy,
+		Context:       req.Context,
+		Sidecars:      req.Sidecars,
+		Workspace:     req.Workspace,
+		Volumes:       req.Volumes,
+		Driver:        cmp.Or(req.Driver, tmpl.Driver),
+		User:          req.User,
+		Platform:      req.Platform,
+		GPU:           req.GPU,
+		NetworkGroup:  req.NetworkGroup,
+		NetworkAlias:  req.NetworkAlias,
+		DNS:           req.DNS,
+		DNSSearch:     req.DNSSearch,
+		DNSOptions:    req.DNSOptions,
+		ExtraHosts:    req.ExtraHosts,
+	}
+	if cfg.Platform != "" {
+		if err := driver.ValidatePlatform(cfg.Platform); err != nil {
+			return nil, driverError(err)
+		}
+	}
+	if cfg.Security, err = h.sandboxSecurity(req.Security, tmpl); err != nil {
+		return nil, err
+	}
+	if req.NetworkPolicy.EnableInternet && h.egress != nil {
+		if err := validateAllowDomains(req.NetworkPolicy.AllowDomains); err != nil {
+			return nil, err
+		}
+	}
+
+	maxTTL := h.maxTTL
+	if h.maxAge > 0 && h.maxAge < maxTTL {
+		maxTTL = h.maxAge
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = min(cmp.Or(tmpl.Timeout, 5*time.Minute), maxTTL)
+	}
+	if cfg.Timeout < 0 || cfg.Timeout > maxTTL {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("timeout must be between 1s and %s", maxTTL))
+	}
+
+	if cfg.Driver != "" && !slices.Contains(backends(h.ids.Backend()), cfg.Driver) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("unknown driver %q; this server runs %s", cfg.Driver, strings.Join(backends(h.ids.Backend()), ", ")))
+	}
+
+	if !req.Packages.empty() {
+		if err := req.Packages.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.checkSidecarInput(req.Sidecars); err != nil {
+		return nil, err
+	}
+	contextBytes, err := h.checkContext(req.Context)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := h.secrets.resolve(secretOwner(ctx), req.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	if env := secretEnv(secrets); len(env) > 0 {
+		cfg.Env = env
+	}
+
+	digest := secretsDigest(secrets, contextDigest(cfg.Context))
+	if cfg.Workspace != "" {
+		wm, ok := h.ids.Backend().(driver.WorkspaceManager)
+		if !ok {
+			return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support workspaces")
+		}
+		ws, err := ownedWorkspace(ctx, wm, cfg.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		// A replaced workspace must not hit results cached on the old one
+		digest = workspaceDigest(ws, digest)
+	}
+	if len(cfg.Volumes) > 0 {
+		vm, err := h.volumeManager()
+		if err != nil {
+			return nil, err
+		}
+		// Other owners' volumes are as missing ones, which are left for
+		// the driver to report after checking the mounts
+		for _, v := range cfg.Volumes {
+			if info, err := vm.GetVolume(ctx, v.Name); err == nil && !owns(ctx, info.Labels) {
+				return nil, driverError(fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, v.Name))
+			}
+		}
+	}
+
+	detail := "image " + image
+	var commit string
+	if req.Git != nil {
+		var files []driver.FileInjection
+		gitCtx, span := tracing.Start(ctx, "git.clone", attribute.String("boxed.git.url", req.Git.displayURL()))
+		files, commit, err = cloneGit(gitCtx, *req.Git, h.gitAddrs)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Context = append(files, cfg.Context...)
+		digest = gitDigest(commit, digest)
+		detail += fmt.Sprintf(", git %s at %s", req.Git.displayURL(), commit)
+	}
+	cfg.Context = append(cfg.Context, secretFiles(secrets)...)
+
+	var setup *SetupResult
+	if !req.Packages.empty() {
+		setup, err = h.preparePackages(ctx, cfg, req.Packages)
+		if err != nil {
+			return nil, err
+		}
+		image, cfg.Image = setup.Image, setup.Image
+		if len(req.Packages.Npm) > 0 {
+			if cfg.Env == nil {
+				cfg.Env = map[string]string{}
+			}
+			cfg.Env["NODE_PATH"] = npmPath
+		}
+		detail += ", packages " + setup.Image
+	}
+
+	release, err := h.limit.reserve(ctx, h.driver)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if req.NetworkPolicy.EnableInternet && h.egress != nil {
+		cfg.EnableNetworking = true
+		cfg.EgressProxy = h.egress.grant(req.NetworkPolicy.AllowDomains)
+	}
+
+	createdAt := time.Now()
+	createCtx, span := tracing.Start(ctx, "driver.create", attribute.String("boxed.image", image))
+	createCtx = driver.WatchPhases(createCtx, func(p driver.SandboxPhase) {
+		phaseMu.Lock()
+		phases = append(phases, state.PhaseChange{Phase: string(p), At: time.Now()})
+		phaseMu.Unlock()
+	})
+	id, err := h.driver.Create(createCtx, cfg)
+	tracing.End(span, err)
+	createTook := time.Since(createdAt)
+	if err != nil {
+		if cfg.EgressProxy != nil {
+			h.egress.revoke(cfg.EgressProxy)
+		}
+		// The driver releases anything it provisioned before failing.
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to create sandbox: %v", err)
+		return nil, apiErr
+	}
+	h.recordEvent(id, state.EventCreated, createdAt, detail, nil)
+	trace.SpanFromContext(ctx).SetAttributes(tracing.SandboxID(id))
+	h.secrets.bind(id, secrets)
+	if cfg.EgressProxy != nil {
+		h.egress.bind(id, cfg.EgressProxy)
+	}
+
+	rec := state.SandboxRecord{
+		ID:        id,
+		Image:     image,
+		State:     state.SandboxCreating,
+		CreatedAt: createdAt,
+		BackendID: h.ids.BackendID(id),
+		ExpiresAt: time.Now().Add(cfg.Timeout),
+		Labels:    labels,
+
+		ContextDigest: digest,
+	}
+	phaseMu.Lock()
+	rec.Phases = phases
+	phaseMu.Unlock()
+	for _, v := range cfg.Volumes {
+		rec.Volumes = append(rec.Volumes, v.Name)
+	}
+	rec.NetworkGroup = cfg.NetworkGroup
+	if h.cluster != nil {
+		rec.Node = h.cluster.NodeID
+	}
+	h.store.PutSandbox(context.Background(), rec)
+	h.publish(LifecycleEvent{Type: LifecycleCreated, SandboxID: id, At: createdAt, Labels: labels})
+
+	// From here on the sandbox exists: any failure tears it down and leaves
+	// a terminal "failed" record behind for GET /sandbox/:id and the timeline.
+	committed := false
+	defer func() {
+		if !committed {
+			h.failCreate(rec, err)
+		}
+	}()
+
+	// Start immediately for this API model
+	h.enterPhase(&rec, driver.PhaseStarting)
+	startedAt := time.Now()
+	startCtx, span := tracing.Start(ctx, "driver.start", tracing.SandboxID(id))
+	err = h.driver.Start(startCtx, id)
+	tracing.End(span, err)
+	if err != nil {
+		h.recordEvent(id, state.EventStarted, startedAt, "", err)
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to start sandbox: %v", err)
+		apiErr.SandboxID = id
+		return nil, apiErr
+	}
+	h.recordEvent(id, state.EventStarted, startedAt, "", nil)
+
+	if tmpl.Init != "" {
+		initAt := time.Now()
+		var initErr *APIError
+		h.enterPhase(&rec, driver.PhaseAgentWaiting)
+		initCtx := onAgentConnected(ctx, func() { h.enterPhase(&rec, driver.PhaseInitializing) })
+		rec.Init, initErr = h.runInit(initCtx, id, tmpl, cfg)
+		if initErr != nil {
+			err = initErr
+			h.recordEvent(id, state.EventInit, initAt, "template "+tmpl.Name, err)
+			initErr.SandboxID = id
+			return nil, initErr
+		}
+		h.recordEvent(id, state.EventInit, initAt, "template "+tmpl.Name, nil)
+	}
+
+	rec.State = state.SandboxReady
+	h.enterPhase(&rec, driver.PhaseReady)
+	committed = true
+	h.usageStarted(rec, cfg)
+	h.publish(LifecycleEvent{Type: LifecycleReady, SandboxID: id, Labels: labels, ExpiresAt: &rec.ExpiresAt})
+
+	h.writeDescriptor(ctx, id)
+	audit(ctx, id, "Sandbox created")
+
+	resp := &CreateSandboxResponse{
+		SandboxID: id,
+		Status:    "ready",
+		GitCommit: commit,
+		Setup:     setup,
+		Context:   contextReport(req.Context, contextBytes, createTook),
+		Init:      rec.Init,
+	}
+	if info, err := h.driver.Info(ctx, id); err == nil {
+		resp.Platform, resp.Emulated, resp.GPUs = info.Platform, info.Emulated, info.GPUs
+		if info.Emulated {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf(
+				"image %s is not built for this host and runs as %s under emulation, which is slower and fails without emulation support on the host", image, info.Platform))
+		}
+	}
+	return resp, nil
+}
+
+// backends returns the drivers a create can ask for.
+func backends(d driver.Driver) []string {
+	if r, ok := d.(driver.Router); ok {
+		return r.Backends()
+	}
+	return []string{d.DriverName()}
+}
+
+// failCreate releases a sandbox whose creation did not complete and records
+// the failure. It runs on a fresh context: the request may have been
+// cancelled, which is often why creation failed in the first place.
+func (h *Handler) failCreate(rec state.SandboxRecord, cause error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stoppedAt := time.Now()
+	if err := h.driver.Stop(ctx, rec.ID); err != nil && !errors.Is(err, driver.ErrSandboxNotFound) {
+		log.Error().Err(err).Str("id", rec.ID).Msg("Failed to clean up sandbox after failed create")
+	}
+	h.recordEvent(rec.ID, state.EventStopped, stoppedAt, "reason: create failed", nil)
+
+	rec.State = state.SandboxFailed
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	h.recordEvent(rec.ID, state.EventFailed, time.Now(), "", cause)
+	h.store.PutSandbox(ctx, rec)
+	h.publish(LifecycleEvent{Type: LifecycleErrored, SandboxID: rec.ID, Labels: rec.Labels, Error: rec.Error})
+	h.store.DeleteSandbox(ctx, rec.ID)
+	h.releaseArtifacts(rec.ID)
+	h.secrets.closeSandbox(rec.ID)
+	if h.egress != nil {
+		h.egress.closeSandbox(rec.ID)
+	}
+}
+
+type ExecRequest struct {
+	Code     string `json:"code"`
+	Language string `json:"language"`
+
+	// File runs a script already in the sandbox instead of Code, with the
+	// language's interpreter; the language defaults to the one of its
+	// extension. A relative path is relative to Cwd.
+	File string `json:"file,omitempty"`
+
+	// Args are passed to the script File runs
+	Args []string `json:"args,omitempty"`
+
+	// Cwd is the directory the code runs in, absolute or relative to the
+	// sandbox's working directory (the default)
+	Cwd string `json:"cwd,omitempty"`
+
+	// User runs the code as this user instead of the sandbox's default:
+	// a user name or "uid[:gid]"
+	User string `json:"user,omitempty"`
+
+	// Env adds environment variables for this exec only
+	Env map[string]string `json:"env,omitempty"`
+
+	// Secrets are registered secrets to inject for this exec: variables
+	// for this exec only, files written before it runs. Their values are
+	// masked in the sandbox's output from then on.
+	Secrets []SecretRef `json:"secrets,omitempty"`
+
+	// SpillOutput writes the full stdout/stderr into the sandbox (under
+	// /output/.boxed) when they exceed the capture limit, and lists the
+	// files as artifacts.
+	SpillOutput bool `json:"spill_output,omitempty"`
+
+	// Artifacts controls which files are captured as artifacts (watched
+	// directories, size and MIME filters, inline, URL or manifest
+	// delivery). A delivery mode alone, "artifacts": "manifest", is short
+	// for its object.
+	Artifacts *proto.ArtifactOptions `json:"artifacts,omitempty"`
+
+	// Cache answers the exec with the stored result of identical code run
+	// in an identically created sandbox (same image and context files), if
+	// there is one. The code is then not run: only use it for code whose
+	// result is all that matters, not its effect on the sandbox.
+	Cache bool `json:"cache,omitempty"`
+
+	// IfBusy is what the exec does when its sandbox already runs as many
+	// execs as the server allows: ExecIfBusyQueue (the default) waits for
+	// one to finish, ExecIfBusyReject fails with 409 sandbox_busy. Jobs
+	// always wait.
+	IfBusy string `json:"if_busy,omitempty"`
+}
+
+type ExecResponse struct {
+	Stdout    string                `json:"stdout"`
+	Stderr    string                `json:"stderr"`
+	Artifacts []proto.ArtifactEvent `json:"artifacts"`
+	ExitCode  *int                  `json:"exit_code"`
+
+	// Truncated is true if stdout or stderr exceeded the capture limit
+	Truncated bool `json:"truncated"`
+
+	// StdoutBytes and StderrBytes are the full output sizes
+	StdoutBytes int64 `json:"stdout_bytes"`
+	StderrBytes int64 `json:"stderr_bytes"`
+
+	// SpillTruncated is true if a spilled stream exceeded MaxSpillBytes, so
+	// its file holds only the start of it
+	SpillTruncated bool `json:"spill_truncated,omitempty"`
+
+	// Cached is true if the result came from the exec cache
+	Cached bool `json:"cached,omitempty"`
+
+	// QueuePosition is the place the exec took among those waiting for
+	// their sandbox, from 1, and QueuedMS how long it waited; both are
+	// unset if it ran at once
+	QueuePosition int   `json:"queue_position,omitempty"`
+	QueuedMS      int64 `json:"queued_ms,omitempty"`
+
+	// ExitReason says why the process ended abnormally: driver.ExitSignaled,
+	// driver.ExitOOMKilled, driver.ExitSandboxDied or
+	// driver.ExitAgentCrashed. With the latter two there is no exit code.
+	ExitReason string `json:"exit_reason,omitempty"`
+
+	// Signal is the signal that killed the process, if one did
+	Signal int `json:"signal,omitempty"`
+
+	// ErrorKind says why the exec failed, when it failed for a reason
+	// other than the code's own: one of the proto.Error kinds, or the
+	// ExitReason driver.ExitOOMKilled, driver.ExitSandboxDied or
+	// driver.ExitAgentCrashed. Shell execs exiting 127 or 126 report
+	// command_not_found or permission_denied, as the shell could not run
+	// a command.
+	ErrorKind string `json:"error_kind,omitempty"`
 
-	// Filesystem API
-	v1.GET("/sandbox/:id/files", h.listFiles)
-	v1.POST("/sandbox/:id/files", h.uploadFile)
-	v1.GET("/sandbox/:id/files/content", h.downloadFile)
-	v1.GET("/sandbox/:id/interact", h.interactSandbox)
+	// ErrorMessage is the agent's description of the failure, if it gave
+	// one
+	ErrorMessage string `json:"error_message,omitempty"`
 }
 
-func (h *Handler) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		key := c.Request().Header.Get("X-Boxed-API-Key")
-		if key == "" {
-			// Also support Query param for easier debugging/CLI
-			key = c.QueryParam("api_key")
-		}
-
-		if h.apiKey != "" && key != h.apiKey {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing API key")
-		}
-		return next(c)
-	}
+// execExit is how the process of an exec ended.
+type execExit struct {
+	// code is nil if the process never reported an exit
+	code   *int
+	signal int
+	// reason is one of the driver.Exit constants if it ended abnormally
+	reason string
+	// errKind and errMsg are those of the error the agent reported, if it
+	// did
+	errKind string
+	errMsg  string
 }
 
-func (h *Handler) listSandboxes(c echo.Context) error {
-	sandboxes, err := h.driver.List(c.Request().Context(), nil)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+// failed records an error the agent reported. The first kind reported
+// is kept, the messages are joined.
+func (e *execExit) failed(kind, msg string) {
+	if e.errKind == "" {
+		e.errKind = kind
 	}
-	if sandboxes == nil {
-		sandboxes = []*driver.SandboxInfo{}
+	if e.errMsg != "" {
+		msg = e.errMsg + "\n" + msg
 	}
-	return c.JSON(http.StatusOK, map[string]any{"sandboxes": sandboxes})
-}
-
-type CreateSandboxRequest struct {
-	Template      string                 `json:"template"`
-	Timeout       int                    `json:"timeout"`
-	Metadata      map[string]string      `json:"metadata"`
-	NetworkPolicy driver.NetworkPolicy   `json:"network_policy"`
-	Context       []driver.FileInjection `json:"context"`
+	e.errMsg = msg
 }
 
-type CreateSandboxResponse struct {
-	SandboxID string `json:"sandbox_id"`
-	Status    string `json:"status"`
+// errorKind classifies the failure of an exec of language; see
+// ExecResponse.ErrorKind.
+func (e execExit) errorKind(language string) string {
+	switch {
+	case e.errKind != "":
+		return e.errKind
+	case e.reason == driver.ExitOOMKilled, e.reason == driver.ExitSandboxDied, e.reason == driver.ExitAgentCrashed:
+		return e.reason
+	case e.errMsg != "" && (e.code == nil || *e.code == -1):
+		// An agent that does not classify its errors
+		return proto.ErrorInternal
+	case e.code == nil || e.signal != 0 || (language != "bash" && language != "sh"):
+		return ""
+	case *e.code == 127:
+		return proto.ErrorCommandNotFound
+	case *e.code == 126:
+		return proto.ErrorPermissionDenied
+	}
+	return ""
 }
 
-func (h *Handler) createSandbox(c echo.Context) error {
-	var req CreateSandboxRequest
+func (h *Handler) execSandbox(c echo.Context) error {
+	id := c.Param("id")
+	var req ExecRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
 	}
+	if acceptsNDJSON(c.Request()) {
+		return h.streamExec(c, id, req)
+	}
 
-	// Map template to image
-	image := "python:3.10-slim" // Default fallback
-	if req.Template == "python-data-science" {
-		image = "boxed-python:3.9" // Assumes this image exists or will be pulled
-	} else if req.Template != "" {
-		// Allow direct image usage for now or map other templates
-		// For MVP, just use python default if unknown, or maybe fail?
-		// Let's assume template == image for advanced users if no map matches
-		if strings.Contains(req.Template, ":") {
-			image = req.Template
-		}
+	result, err := h.Exec(c.Request().Context(), id, req)
+	if err != nil {
+		return err
 	}
+	return c.JSON(http.StatusOK, result)
+}
 
-	cfg := driver.SandboxConfig{
-		Image:         image,
-		MemoryMB:      512,
-		CPUCores:      1.0,
-		Labels:        req.Metadata,
-		Timeout:       time.Duration(req.Timeout) * time.Second,
-		NetworkPolicy: req.NetworkPolicy,
-		Context:       req.Context,
+// Exec runs code in a sandbox and waits for it to exit. It is the transport
+// independent core of POST /sandbox/:id/exec; errors are *APIError.
+func (h *Handler) Exec(ctx context.Context, id string, req ExecRequest) (*ExecResponse, error) {
+	req, err := h.admitExec(ctx, id, req)
+	if err != nil {
+		return nil, err
 	}
-	if cfg.Timeout == 0 {
-		cfg.Timeout = 5 * time.Minute
+	return h.exec(ctx, id, req, nil)
+}
+
+// exec runs an exec, passing its events to events if set.
+func (h *Handler) exec(ctx context.Context, id string, req ExecRequest, events *execEmitter) (*ExecResponse, error) {
+	end := h.activity.begin("exec")
+	defer end()
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	id, err := h.driver.Create(c.Request().Context(), cfg)
+	started := time.Now()
+	cmd, args, err := h.execCommand(req)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("failed to create sandbox: %v", err))
+		return nil, err
 	}
+	req.Language = execLanguage(req)
 
-	// Start immediately for this API model
-	if err := h.driver.Start(c.Request().Context(), id); err != nil {
-		// Try to verify clean up if start fails
-		_ = h.driver.Stop(context.Background(), id)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to start sandbox").SetInternal(err)
+	var secrets []resolvedSecret
+	if len(req.Secrets) > 0 {
+		if isSessionLanguage(req.Language) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "secrets cannot be given to "+req.Language+" execs; give them to the sandbox")
+		}
+		if secrets, err = h.secrets.resolve(secretOwner(ctx), req.Secrets); err != nil {
+			return nil, err
+		}
 	}
 
-	return c.JSON(http.StatusCreated, CreateSandboxResponse{
-		SandboxID: id,
-		Status:    "ready",
+	var cacheKey string
+	// A session's result depends on the execs before it; one with secrets
+	// on their values; one with volumes or a network group on other
+	// sandboxes; one of a file on what was written to it
+	if req.Cache && h.execCache != nil && !isSessionLanguage(req.Language) && req.File == "" && len(secrets) == 0 {
+		if rec, err := h.store.GetSandbox(ctx, id); err == nil && rec.State == state.SandboxReady && len(rec.Volumes) == 0 && rec.NetworkGroup == "" {
+			cacheKey = execCacheKey(rec, req)
+			if res := h.cachedExec(id, cacheKey); res != nil {
+				h.recordExec(id, req, started, res, "")
+				events.replay(res)
+				return res, nil
+			}
+		}
+	}
+
+	var wait execWait
+	release, position, err := h.acquireExec(ctx, id, req.IfBusy == ExecIfBusyReject, func(position int) {
+		events.emit(ExecEvent{Type: ExecEventQueued, QueuePosition: position})
 	})
-}
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if position > 0 {
+		wait = execWait{position: position, took: time.Since(started)}
+	}
 
-type ExecRequest struct {
-	Code     string `json:"code"`
-	Language string `json:"language"`
-}
+	stdout := newCappedOutput(h.maxOutput, req.SpillOutput)
+	stderr := newCappedOutput(h.maxOutput, req.SpillOutput)
+	defer stdout.close()
+	defer stderr.close()
+	h.tee(events, id, stdout, stderr)
 
-type ExecResponse struct {
-	Stdout    string                `json:"stdout"`
-	Stderr    string                `json:"stderr"`
-	Artifacts []proto.ArtifactEvent `json:"artifacts"`
-	ExitCode  *int                  `json:"exit_code"`
-}
+	if isSessionLanguage(req.Language) {
+		artifacts, exit, err := h.execSession(ctx, id, req, stdout, stderr)
+		if err != nil {
+			h.recordExec(id, req, started, nil, err.Error())
+			return nil, err
+		}
+		events.artifacts(artifacts)
+		return h.execResult(ctx, id, req, started, wait, stdout, stderr, artifacts, exit, events), nil
+	}
 
-func (h *Handler) execSandbox(c echo.Context) error {
-	id := c.Param("id")
-	var req ExecRequest
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	// Mask the secrets before they can reach the output
+	h.secrets.bind(id, secrets)
+	for _, sec := range secrets {
+		if sec.Path == "" {
+			continue
+		}
+		if err := h.driver.PutFile(ctx, id, sec.Path, strings.NewReader(sec.value)); err != nil {
+			apiErr := driverError(err)
+			if apiErr.Code == CodeInternal {
+				apiErr.Message = fmt.Sprintf("failed to write secret %s: %v", sec.Name, err)
+			}
+			return nil, apiErr
+		}
+	}
+
+	params := map[string]any{
+		"cmd":  cmd,
+		"args": args,
+	}
+	if req.Cwd != "" {
+		params["cwd"] = req.Cwd
+	}
+	if req.User != "" {
+		params["user"] = req.User
+	}
+	if len(req.Env) > 0 || len(secrets) > 0 {
+		env := maps.Clone(req.Env)
+		if env == nil {
+			env = map[string]string{}
+		}
+		maps.Copy(env, secretEnv(secrets))
+		params["env"] = env
+	}
+	var delivery string
+	if req.Artifacts != nil {
+		opts := *req.Artifacts
+		delivery = opts.Delivery
+		if delivery == proto.DeliveryManifest {
+			opts.Delivery = proto.DeliveryURL
+		}
+		params["artifacts"] = &opts
+	}
+	artifacts, exit, apiErr := h.agentExec(ctx, id, params, delivery, events, stdout, stderr, attribute.String("boxed.language", req.Language))
+	if apiErr != nil {
+		h.recordExec(id, req, started, nil, apiErr.Message)
+		return nil, apiErr
+	}
+	result := h.execResult(ctx, id, req, started, wait, stdout, stderr, artifacts, exit, events)
+	if cacheKey != "" && cacheable(result) {
+		h.execCache.put(cacheKey, *result)
 	}
 
-	// Determine command
-	var cmd string
-	var args []string
+	return result, nil
+}
 
+// execCommand validates req and returns the command that runs its code;
+// none for session languages, whose code the sandbox's kernel runs.
+func (h *Handler) execCommand(req ExecRequest) (cmd string, args []string, err error) {
+	if err := h.checkExecInput(req); err != nil {
+		return "", nil, err
+	}
+	if req.File != "" {
+		return h.fileCommand(req)
+	}
+	if len(req.Args) > 0 {
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "args can only be given with file")
+	}
 	switch req.Language {
 	case "python":
 		cmd = "python3"
@@ -183,30 +1465,119 @@ func (h *Handler) execSandbox(c echo.Context) error {
 	case "bash", "sh":
 		cmd = "bash"
 		args = []string{"-c", req.Code}
+	case LanguagePythonSession, LanguageBashSession:
+	default:
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "unsupported language: "+req.Language)
+	}
+	if err := checkExecOptions(req); err != nil {
+		return "", nil, err
+	}
+	return cmd, args, nil
+}
+
+// fileLanguages maps script extensions to the language running them.
+var fileLanguages = map[string]string{
+	".py":  "python",
+	".js":  "javascript",
+	".mjs": "javascript",
+	".cjs": "javascript",
+	".sh":  "bash",
+}
+
+// fileCommand returns the command that runs the script req.File with the
+// interpreter of req.Language, by default that of its extension.
+func (h *Handler) fileCommand(req ExecRequest) (cmd string, args []string, err error) {
+	if req.Code != "" {
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "give either code or file, not both")
+	}
+	if strings.HasPrefix(req.File, "-") {
+		// It would be taken for an option of the interpreter
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "file cannot start with -")
+	}
+	switch execLanguage(req) {
+	case "python":
+		cmd = "python3"
+	case "javascript", "node":
+		cmd = "node"
+	case "bash", "sh":
+		cmd = "bash"
+	case "":
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("cannot tell the language of %s from its extension; set language", req.File))
 	default:
-		return echo.NewHTTPError(http.StatusBadRequest, "unsupported language: "+req.Language)
+		return "", nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "cannot run a file with language "+req.Language)
+	}
+	if err := checkExecOptions(req); err != nil {
+		return "", nil, err
+	}
+	return cmd, append([]string{req.File}, req.Args...), nil
+}
+
+// execLanguage is the language of req: the one it gives, or for a file the
+// one of its extension.
+func execLanguage(req ExecRequest) string {
+	if req.Language == "" && req.File != "" {
+		return fileLanguages[path.Ext(req.File)]
+	}
+	return req.Language
+}
+
+// checkExecOptions validates the options of req that do not depend on its
+// language.
+func checkExecOptions(req ExecRequest) error {
+	if err := validateArtifactOptions(req.Artifacts); err != nil {
+		return err
+	}
+	if req.User != "" {
+		if err := driver.ValidateUser(req.User); err != nil {
+			return driverError(err)
+		}
+	}
+	if req.IfBusy != "" && req.IfBusy != ExecIfBusyQueue && req.IfBusy != ExecIfBusyReject {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "if_busy must be queue or reject")
 	}
+	return nil
+}
 
+// agentExec runs the command of an agent "exec" request with params in
+// sandbox id, writing its output to stdout and stderr, and returns the
+// artifacts it reported, with their URLs per delivery, and how it ended.
+// Artifacts are also passed to events as they come. attrs are added to its
+// span. Errors are *APIError.
+func (h *Handler) agentExec(ctx context.Context, id string, params map[string]any, delivery string, events *execEmitter, stdout, stderr *cappedOutput, attrs ...attribute.KeyValue) ([]proto.ArtifactEvent, execExit, *APIError) {
 	// Connect to sandbox
-	conn, err := h.driver.Connect(c.Request().Context(), id)
+	connectCtx, span := tracing.Start(ctx, "agent.connect", tracing.SandboxID(id))
+	conn, err := h.driver.Connect(connectCtx, id)
+	tracing.End(span, err)
 	if err != nil {
-		if err == driver.ErrSandboxNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "sandbox not found")
+		apiErr := driverError(err)
+		if apiErr.Code == CodeInternal {
+			apiErr.Message = fmt.Sprintf("failed to connect to sandbox: %v", err)
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to connect to sandbox").SetInternal(err)
+		return nil, execExit{}, apiErr
 	}
 	defer conn.Close()
+	h.recordAgentReady(id)
+	agentConnected(ctx)
+
+	// The span covers the round trip to the agent, which continues the
+	// trace from the traceparent param
+	rpcCtx, span := tracing.Start(ctx, "agent.exec", append(attrs, tracing.SandboxID(id))...)
+	defer span.End()
 
 	// Send execution request
-	rpcReq := proto.NewRequest("exec", map[string]any{
-		"cmd":  cmd,
-		"args": args,
-	}, 1)
+	if tp := tracing.Traceparent(rpcCtx); tp != "" {
+		params["traceparent"] = tp
+	}
+	rpcReq := proto.NewRequest("exec", params, 1)
 
 	reqBytes, _ := json.Marshal(rpcReq)
 	if _, err := conn.Write(append(reqBytes, '\n')); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to send request").SetInternal(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, execExit{}, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to send request", err)
 	}
+	proc := &agentProc{conn: conn}
+	defer h.procs.add(id, proc)()
 
 	// Stream response
 	// We need to read line by line until we see an "exit" event or an error response
@@ -216,13 +1587,12 @@ func (h *Handler) execSandbox(c echo.Context) error {
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, 1024*1024) // 1MB max line
 
-	var stdout, stderr strings.Builder
 	var artifacts []proto.ArtifactEvent
-	var exitCode *int
+	var exit execExit
 
 	// Set a hard timeout for the RPC loop to prevent hanging forever
 	// This respects the context deadline if set by HTTP server
-	done := make(chan error)
+	done := make(chan error, 1)
 	go func() {
 		for scanner.Scan() {
 			line := scanner.Bytes()
@@ -253,7 +1623,7 @@ func (h *Handler) execSandbox(c echo.Context) error {
 				}
 				if resp.Error != nil {
 					// RPC level error
-					stderr.WriteString(fmt.Sprintf("\nRPC Error: %s\n", resp.Error.Message))
+					exit.failed(proto.ErrorInternal, resp.Error.Message)
 					// Should we stop? The exec failed to start?
 					// If exec failed to start, we probably won't get events.
 					break
@@ -280,26 +1650,25 @@ func (h *Handler) execSandbox(c echo.Context) error {
 					stderr.WriteString(s)
 				}
 			case "artifact":
-				// Need strict struct
-				path, _ := params["path"].(string)
-				mime, _ := params["mime"].(string)
-				data, _ := params["data_base64"].(string)
-				artifacts = append(artifacts, proto.ArtifactEvent{
-					Path:       path,
-					MIME:       mime,
-					DataBase64: data,
-				})
+				a := artifactFromParams(id, params, delivery)
+				artifacts = append(artifacts, a)
+				events.emit(ExecEvent{Type: ExecEventArtifact, Artifact: &a})
 			case "exit":
 				if c, ok := params["code"].(float64); ok { // JSON numbers are floats
 					code := int(c)
-					exitCode = &code
+					exit.code = &code
+					if sig, ok := params["signal"].(float64); ok {
+						exit.signal = int(sig)
+					}
+					exit.reason, _ = params["reason"].(string)
 					// We are done
 					done <- nil
 					return
 				}
 			case "error":
 				if msg, ok := params["message"].(string); ok {
-					stderr.WriteString(fmt.Sprintf("\nRuntime Error: %s\n", msg))
+					kind, _ := params["kind"].(string)
+					exit.failed(kind, msg)
 				}
 			}
 		}
@@ -312,41 +1681,305 @@ func (h *Handler) execSandbox(c echo.Context) error {
 	}()
 
 	select {
-	case <-c.Request().Context().Done():
-		return echo.NewHTTPError(http.StatusRequestTimeout, "timed out")
+	case <-ctx.Done():
+		// Nobody waits for the process any more, e.g. the client
+		// disconnected: end it rather than leave it running. Closing the
+		// connection ends it too, should the agent miss the cancel.
+		if err := proc.cancel(); err != nil {
+			log.Debug().Err(err).Str("id", id).Msg("Failed to cancel exec")
+		}
+		// The reader writes to stdout, stderr and events, which the caller
+		// owns again once this returns: stop it first
+		conn.Close()
+		<-done
+		apiErr := contextError(ctx)
+		span.SetStatus(codes.Error, apiErr.Message)
+		return nil, execExit{}, apiErr
 	case err := <-done:
 		if err != nil && err != io.EOF {
-			return echo.NewHTTPError(http.StatusInternalServerError, "stream error").SetInternal(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, execExit{}, wrapAPIError(http.StatusInternalServerError, CodeInternal, "stream error: "+err.Error(), err)
 		}
 	}
 
+	if exit.code != nil {
+		span.SetAttributes(attribute.Int("boxed.exit_code", *exit.code))
+	} else {
+		// The stream ended without an exit: find out whether the sandbox
+		// went down with the process
+		exit.reason = h.deathReason(id)
+	}
+	if exit.reason != "" {
+		span.SetAttributes(attribute.String("boxed.exit_reason", exit.reason))
+	}
+	return artifacts, exit, nil
+}
+
+// execResult builds the response of a finished exec, spilling output that
+// went over the cap, and records it in the history. Spill files are passed
+// to events as artifacts.
+func (h *Handler) execResult(ctx context.Context, id string, req ExecRequest, started time.Time, wait execWait, stdout, stderr *cappedOutput, artifacts []proto.ArtifactEvent, exit execExit, events *execEmitter) *ExecResponse {
 	if artifacts == nil {
 		artifacts = []proto.ArtifactEvent{}
 	}
+	h.storeArtifacts(id, artifacts)
 
+	// Spill before building the response so the files are listed with the
+	// artifacts; the file names share a prefix per exec.
+	spillBase := fmt.Sprintf("%s/exec-%d", spillDir, started.UnixNano())
+	if a := h.spillTo(ctx, id, stdout, spillBase+".stdout"); a != nil {
+		artifacts = append(artifacts, *a)
+		events.emit(ExecEvent{Type: ExecEventArtifact, Artifact: a})
+	}
+	if a := h.spillTo(ctx, id, stderr, spillBase+".stderr"); a != nil {
+		artifacts = append(artifacts, *a)
+		events.emit(ExecEvent{Type: ExecEventArtifact, Artifact: a})
+	}
+
+	redact := func(s string) string { return h.secrets.redact(id, s) }
 	result := ExecResponse{
-		Stdout:    stdout.String(),
-		Stderr:    stderr.String(),
-		Artifacts: artifacts,
-		ExitCode:  exitCode,
+		Stdout:         redact(stdout.String()),
+		Stderr:         redact(stderr.String()),
+		Artifacts:      artifacts,
+		ExitCode:       exit.code,
+		Truncated:      stdout.truncated || stderr.truncated,
+		StdoutBytes:    stdout.total,
+		StderrBytes:    stderr.total,
+		SpillTruncated: stdout.spillCut || stderr.spillCut,
+		ExitReason:     exit.reason,
+		Signal:         exit.signal,
+		ErrorKind:      exit.errorKind(req.Language),
+		ErrorMessage:   redact(exit.errMsg),
+
+		QueuePosition: wait.position,
+		QueuedMS:      wait.took.Milliseconds(),
 	}
+	h.recordExec(id, req, started, &result, "")
+	return &result
+}
 
-	return c.JSON(http.StatusOK, result)
+// deathReason tells why the agent stream of sandbox id ended without an
+// exit: driver.ExitOOMKilled or driver.ExitSandboxDied if the sandbox is
+// gone or failed, driver.ExitAgentCrashed if it is still running.
+func (h *Handler) deathReason(id string) string {
+	// The request context may be done already
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info, err := h.driver.Info(ctx, id)
+	switch {
+	case errors.Is(err, driver.ErrSandboxNotFound):
+		return driver.ExitSandboxDied
+	case err != nil:
+		return ""
+	case info.State == driver.StateReady:
+		return driver.ExitAgentCrashed
+	case info.ExitReason != "":
+		return info.ExitReason
+	}
+	return driver.ExitSandboxDied
 }
 
-func (h *Handler) stopSandbox(c echo.Context) error {
+// recordExec appends an entry to the sandbox exec history.
+// result is nil when the exec did not complete.
+func (h *Handler) recordExec(id string, req ExecRequest, started time.Time, result *ExecResponse, errMsg string) {
+	rec := state.ExecRecord{
+		Language:   req.Language,
+		StartedAt:  started,
+		DurationMS: time.Since(started).Milliseconds(),
+		Error:      errMsg,
+	}
+
+	// Code may spell out a secret as well as print it
+	redact := func(s string) string { return h.secrets.redact(id, s) }
+	var truncCode, truncOut, truncErr bool
+	rec.Code, truncCode = state.Truncate(redact(req.Code), state.MaxRecordedOutput)
+	rec.File = req.File
+	for _, arg := range req.Args {
+		rec.Args = append(rec.Args, redact(arg))
+	}
+	if result != nil {
+		rec.ExitCode = result.ExitCode
+		rec.Stdout, truncOut = state.Truncate(redact(result.Stdout), state.MaxRecordedOutput)
+		rec.Stderr, truncErr = state.Truncate(redact(result.Stderr), state.MaxRecordedOutput)
+		rec.Cached = result.Cached
+		rec.QueuedMS = result.QueuedMS
+		rec.ExitReason = result.ExitReason
+		rec.ErrorKind = result.ErrorKind
+		if rec.Error == "" {
+			rec.Error = result.ErrorMessage
+		}
+	}
+	rec.Truncated = truncCode || truncOut || truncErr || (result != nil && result.Truncated)
+
+	// Use a fresh context: the request context may already be cancelled.
+	if err := h.store.AppendExec(context.Background(), id, rec); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to record exec")
+	}
+
+	detail := req.Language
+	if rec.ExitCode != nil {
+		detail = fmt.Sprintf("%s exit=%d", req.Language, *rec.ExitCode)
+	}
+	if rec.ExitReason != "" {
+		detail += " " + rec.ExitReason
+	}
+	if rec.ErrorKind != "" && rec.ErrorKind != rec.ExitReason {
+		detail += " " + rec.ErrorKind
+	}
+	if rec.Cached {
+		detail += " cached"
+	}
+	var execErr error
+	if errMsg != "" {
+		execErr = errors.New(errMsg)
+	}
+	h.recordEvent(id, state.EventExec, started, detail, execErr)
+}
+
+func (h *Handler) listExecs(c echo.Context) error {
 	id := c.Param("id")
+	if _, err := h.driver.Info(c.Request().Context(), id); err != nil {
+		return driverError(err)
+	}
+
+	execs, err := h.store.ListExecs(c.Request().Context(), id)
+	if err != nil {
+		return driverError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"execs": execs})
+}
+
+func (h *Handler) stopSandbox(c echo.Context) error {
+	if err := h.StopSandbox(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// StopSandbox stops and removes a sandbox. It is the transport independent
+// core of DELETE /sandbox/:id; errors are *APIError.
+func (h *Handler) StopSandbox(ctx context.Context, id string) error {
 	if id == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "id is required"})
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "id is required")
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := h.stop(ctx, id, "api"); err != nil {
+		return err
 	}
-	err := h.driver.Stop(c.Request().Context(), id)
+	audit(ctx, id, "Sandbox stopped")
+	return nil
+}
+
+// stopConcurrency bounds how many sandboxes StopSandboxes stops at once.
+const stopConcurrency = 8
+
+// StopResult reports which sandboxes a bulk delete stopped.
+type StopResult struct {
+	Stopped []string      `json:"stopped"`
+	Failed  []StopFailure `json:"failed"`
+}
+
+// StopFailure is a sandbox a bulk delete could not stop.
+type StopFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+func (h *Handler) stopSandboxes(c echo.Context) error {
+	all, _ := strconv.ParseBool(c.QueryParam("all"))
+	res, err := h.StopSandboxes(c.Request().Context(), listFilter(c), all)
+	if err != nil {
+		return err
+	}
+	// The other nodes of a cluster stop their own sandboxes
+	for _, other := range fanOut[StopResult](h, c) {
+		res.Stopped = append(res.Stopped, other.Stopped...)
+		res.Failed = append(res.Failed, other.Failed...)
+	}
+	slices.Sort(res.Stopped)
+	res.Stopped = slices.Compact(res.Stopped)
+	slices.SortFunc(res.Failed, func(a, b StopFailure) int { return strings.Compare(a.ID, b.ID) })
+	return c.JSON(http.StatusOK, res)
+}
+
+// StopSandboxes stops and removes every sandbox matching filter. Unless all
+// is set the filter must have labels, so that a bare request cannot wipe
+// the server. Sandboxes that fail to stop are reported, not returned as an
+// error. It is the transport independent core of DELETE /sandbox.
+func (h *Handler) StopSandboxes(ctx context.Context, filter ListFilter, all bool) (*StopResult, error) {
+	if len(filter.Labels) == 0 && !all {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "label or all=true is required")
+	}
+	sandboxes, err := h.ListSandboxes(ctx, filter)
 	if err != nil {
-		if errors.Is(err, driver.ErrSandboxNotFound) {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "sandbox not found"})
+		return nil, err
+	}
+
+	res := &StopResult{Stopped: []string{}, Failed: []StopFailure{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, stopConcurrency)
+	for _, info := range sandboxes {
+		if info.State == driver.StateFailed {
+			// Already removed
+			continue
 		}
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer func() { <-sem; wg.Done() }()
+			err := h.stop(ctx, id, "api")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.Failed = append(res.Failed, StopFailure{ID: id, Error: err.Error()})
+				return
+			}
+			res.Stopped = append(res.Stopped, id)
+			audit(ctx, id, "Sandbox stopped")
+		}(info.ID)
 	}
-	return c.NoContent(http.StatusNoContent)
+	wg.Wait()
+	slices.Sort(res.Stopped)
+	slices.SortFunc(res.Failed, func(a, b StopFailure) int { return strings.Compare(a.ID, b.ID) })
+	return res, nil
+}
+
+// stop removes a sandbox and records why in its timeline.
+func (h *Handler) stop(ctx context.Context, id, reason string) error {
+	// The CPU time consumed is gone with the sandbox
+	h.sampleUsage(ctx, id)
+	if rec, err := h.store.GetSandbox(ctx, id); err == nil {
+		h.enterPhase(&rec, driver.PhaseStopping)
+	}
+	stoppedAt := time.Now()
+	err := h.driver.Stop(ctx, id)
+	if err != nil {
+		return driverError(err)
+	}
+	h.recordEvent(id, state.EventStopped, stoppedAt, "reason: "+reason, nil)
+	h.usageStopped(id, stoppedAt)
+	if rec, err := h.store.GetSandbox(ctx, id); err == nil {
+		rec.State = state.SandboxStopped
+		h.store.PutSandbox(context.Background(), rec)
+	}
+	h.publish(LifecycleEvent{Type: LifecycleStopped, SandboxID: id, Reason: reason})
+	h.store.DeleteSandbox(context.Background(), id)
+	h.releaseArtifacts(id)
+	h.sessions.closeSandbox(id)
+	h.uploads.closeSandbox(id)
+	for _, j := range h.jobs.closeSandbox(id) {
+		h.jobDone(j)
+	}
+	h.kernels.closeSandbox(id)
+	h.secrets.closeSandbox(id)
+	if h.egress != nil {
+		h.egress.closeSandbox(id)
+	}
+	return nil
 }
 
 func (h *Handler) listFiles(c echo.Context) error {
@@ -358,7 +1991,7 @@ func (h *Handler) listFiles(c echo.Context) error {
 
 	files, err := h.driver.ListFiles(c.Request().Context(), id, path)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return driverError(err)
 	}
 	if files == nil {
 		files = []*driver.FileEntry{}
@@ -366,125 +1999,165 @@ func (h *Handler) listFiles(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]any{"files": files})
 }
 
+// uploadFile serves POST /sandbox/:id/files. Each "file" part is written
+// into the directory in "path" under its file name, or to the path the
+// optional "paths" field gives it: a JSON array of paths in the order of
+// the parts, or an object from file names to paths. Relative paths are
+// taken from the directory. Parts with a digest in the "sha256" fields, or
+// in ContentSHA256Header for a single part, must match it; nothing is
+// written otherwise.
 func (h *Handler) uploadFile(c echo.Context) error {
 	id := c.Param("id")
-	path := c.FormValue("path")
-	if path == "" {
-		path = "/uploads"
+	dir := c.FormValue("path")
+	if dir == "" {
+		dir = "/uploads"
 	}
-	// "file" is the form field
-	file, err := c.FormFile("file")
-	if err != nil {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
 		return echo.NewHTTPError(http.StatusBadRequest, "file required")
 	}
-	src, err := file.Open()
+	parts := form.File["file"]
+	targets, err := uploadTargets(dir, parts, c.FormValue("paths"))
 	if err != nil {
-		return err
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, err.Error())
 	}
-	defer src.Close()
-
-	// Append filename to path if path is a directory
-	// Simplified: Assume path is destination directory?
-	// Or path is full path?
-	// Spec says default "/uploads".
-	// Let's assume path is DIRECTORY.
-	fullPath := fmt.Sprintf("%s/%s", strings.TrimSuffix(path, "/"), file.Filename)
 
-	if err := h.driver.PutFile(c.Request().Context(), id, fullPath, src); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	want := form.Value["sha256"]
+	if header := c.Request().Header.Get(ContentSHA256Header); header != "" {
+		want = append(want, header)
 	}
-	return c.JSON(http.StatusOK, map[string]string{"status": "uploaded", "path": fullPath})
-}
-
-func (h *Handler) downloadFile(c echo.Context) error {
-	id := c.Param("id")
-	path := c.QueryParam("path")
-	if path == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "path required")
+	if len(want) > len(parts) {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("%d sha256 digests for %d files", len(want), len(parts)))
 	}
-
-	content, err := h.driver.GetFile(c.Request().Context(), id, path)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	checksums := make(map[string]string, len(parts))
+	files := make([]driver.FileUpload, len(parts))
+	for i, part := range parts {
+		sum, err := partSHA256(part)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", part.Filename, err)
+		}
+		if i < len(want) && want[i] != "" {
+			expected, err := parseSHA256(want[i])
+			if err != nil {
+				return err
+			}
+			if sum != expected {
+				return checksumMismatch(targets[i], expected, sum)
+			}
+		}
+		checksums[targets[i]] = sum
+		files[i] = driver.FileUpload{
+			Path: targets[i],
+			Size: part.Size,
+			Open: func() (io.ReadCloser, error) { return part.Open() },
+		}
 	}
-	// Content is ReadCloser
-	defer content.Close()
-
-	return c.Stream(http.StatusOK, "application/octet-stream", content)
+	if err := h.putFiles(c.Request().Context(), id, files); err != nil {
+		return driverError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"status": "uploaded", "path": targets[0], "paths": targets, "checksums": checksums})
 }
-func (h *Handler) interactSandbox(c echo.Context) error {
-	id := c.Param("id")
 
-	// Upgrade to WebSocket
-	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+// partSHA256 returns the hex SHA-256 digest of an uploaded part, which the
+// form already holds in memory or on disk.
+func partSHA256(part *multipart.FileHeader) (string, error) {
+	f, err := part.Open()
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer ws.Close()
+	defer f.Close()
+	return sha256Hex(f)
+}
 
-	// Connect to sandbox agent
-	conn, err := h.driver.Connect(c.Request().Context(), id)
-	if err != nil {
-		return err
+// uploadTargets returns where the file parts of an upload into dir go,
+// given the "paths" field.
+func uploadTargets(dir string, parts []*multipart.FileHeader, paths string) ([]string, error) {
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = part.Filename
+	}
+	if paths != "" {
+		var byName map[string]string
+		if err := json.Unmarshal([]byte(paths), &names); err == nil {
+			if len(names) != len(parts) {
+				return nil, fmt.Errorf("paths has %d entries for %d files", len(names), len(parts))
+			}
+		} else if err := json.Unmarshal([]byte(paths), &byName); err == nil {
+			for name := range byName {
+				if !slices.Contains(names, name) {
+					return nil, fmt.Errorf("paths names %s, which no file has", name)
+				}
+			}
+			for i, name := range names {
+				if p, ok := byName[name]; ok {
+					names[i] = p
+				}
+			}
+		} else {
+			return nil, errors.New("paths must be a JSON array or object of paths")
+		}
 	}
-	defer conn.Close()
 
-	// Initial Handshake: Start REPL
-	// Default to bash if not specified? Or python?
-	// For now, let's allow setting it via query param
-	lang := c.QueryParam("lang")
-	cmd := "bash"
-	if lang == "python" {
-		cmd = "python3"
+	targets := make([]string, len(names))
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("file %d has no name or path", i+1)
+		}
+		target := name
+		if !path.IsAbs(target) {
+			target = path.Join(dir, target)
+		}
+		target = path.Clean(target)
+		if seen[target] {
+			return nil, fmt.Errorf("two files are written to %s", target)
+		}
+		seen[target] = true
+		targets[i] = target
 	}
+	return targets, nil
+}
 
-	startReq := proto.NewRequest("repl.start", map[string]any{
-		"cmd": cmd,
-	}, 1)
-	startBytes, _ := json.Marshal(startReq)
-	conn.Write(append(startBytes, '\n'))
+// uploadConcurrency is how many files putFiles writes at a time when the
+// driver cannot write them in one transfer.
+const uploadConcurrency = 4
 
-	// Two-way Pipe
-	// goroutine 1: Agent -> WebSocket
-	errChan := make(chan error, 2)
-	go func() {
-		scanner := bufio.NewScanner(conn)
-		for scanner.Scan() {
-			if err := ws.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
-				errChan <- err
-				return
+// putFiles writes files into sandbox id, in one transfer if the driver
+// can, recording each on the timeline.
+func (h *Handler) putFiles(ctx context.Context, id string, files []driver.FileUpload) error {
+	started := time.Now()
+	if fp, ok := h.driver.(driver.FilesPutter); ok && len(files) > 1 {
+		err := fp.PutFiles(ctx, id, files)
+		if !errors.Is(err, driver.ErrNotImplemented) {
+			for _, file := range files {
+				h.recordEvent(id, state.EventFileUpload, started, file.Path, err)
 			}
+			return err
 		}
-		errChan <- scanner.Err()
-	}()
+	}
 
-	// goroutine 2: WebSocket -> Agent
-	go func() {
-		for {
-			_, message, err := ws.ReadMessage()
-			if err != nil {
-				errChan <- err
-				return
+	errs := make([]error, len(files))
+	sem := make(chan struct{}, uploadConcurrency)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			started := time.Now()
+			content, err := file.Open()
+			if err == nil {
+				err = h.driver.PutFile(ctx, id, file.Path, content)
+				content.Close()
 			}
-
-			// If it's a raw string, we wrap it in repl.input JSON-RPC
-			// This makes it easy for simple clients, but we should also allow structured JSON-RPC
-			var generic map[string]any
-			if err := json.Unmarshal(message, &generic); err == nil && generic["method"] != nil {
-				// Already structured JSON-RPC, pass through
-				conn.Write(append(message, '\n'))
-			} else {
-				// Raw string, wrap it
-				inputReq := proto.NewRequest("repl.input", map[string]any{
-					"data": string(message),
-				}, nil)
-				inputBytes, _ := json.Marshal(inputReq)
-				conn.Write(append(inputBytes, '\n'))
+			h.recordEvent(id, state.EventFileUpload, started, file.Path, err)
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to upload %s: %w", file.Path, err)
 			}
-		}
-	}()
-
-	// Wait for any side to close
-	err = <-errChan
-	return err
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
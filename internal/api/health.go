@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+)
+
+// readyTimeout bounds each readiness check, so that a hung backend fails
+// the probe instead of stalling it.
+const readyTimeout = 5 * time.Second
+
+// Component statuses reported by /readyz.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// WithReadyPoolMin makes /readyz fail while a pooled driver has fewer than n
+// warm sandboxes available. Drivers without a pool are not affected.
+func WithReadyPoolMin(n int) Option {
+	return func(h *Handler) {
+		h.readyPoolMin = n
+	}
+}
+
+// ComponentStatus is the result of one readiness check.
+type ComponentStatus struct {
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Readiness is the body of /readyz.
+type Readiness struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// healthz serves GET /healthz: the process is up and serving requests.
+// It checks nothing else, so that a failing backend does not get the
+// server restarted.
+func (h *Handler) healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"status":         StatusOK,
+		"uptime_seconds": int64(time.Since(h.startedAt).Seconds()),
+	})
+}
+
+// readyz serves GET /readyz: 200 if new sandboxes can be served, 503 with
+// the failing components otherwise.
+func (h *Handler) readyz(c echo.Context) error {
+	r := h.Ready(c.Request().Context())
+	status := http.StatusOK
+	if r.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	return c.JSON(status, r)
+}
+
+// Ready checks the driver backend, the state store, the warm pool and
+// whether the server is draining.
+func (h *Handler) Ready(ctx context.Context) *Readiness {
+	r := &Readiness{Status: StatusOK, Components: make(map[string]ComponentStatus)}
+	check := func(name string, fn func(ctx context.Context) (string, error)) {
+		ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+		defer cancel()
+		start := time.Now()
+		detail, err := fn(ctx)
+		cs := ComponentStatus{Status: StatusOK, Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			cs.Status = StatusUnavailable
+			cs.Error = err.Error()
+			r.Status = StatusUnavailable
+		}
+		r.Components[name] = cs
+	}
+
+	check("driver", func(ctx context.Context) (string, error) {
+		return h.driver.DriverName(), h.driver.Healthy(ctx)
+	})
+	check("store", func(ctx context.Context) (string, error) {
+		if p, ok := h.store.(state.Pinger); ok {
+			return "", p.Ping(ctx)
+		}
+		return "in-memory", nil
+	})
+	if pd, ok := h.ids.Backend().(driver.PooledDriver); ok {
+		check("pool", func(ctx context.Context) (string, error) {
+			stats, err := pd.PoolStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+			detail := fmt.Sprintf("%d of %d warm sandboxes available", stats.Available, stats.Target)
+			if stats.Available < h.readyPoolMin {
+				return detail, fmt.Errorf("fewer than %d warm sandboxes available", h.readyPoolMin)
+			}
+			return detail, nil
+		})
+	}
+	check("drain", func(ctx context.Context) (string, error) {
+		if h.activity.isDraining() {
+			return "", errors.New("server is shutting down")
+		}
+		return "", nil
+	})
+	return r
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+)
+
+// WakeRequest starts a hibernated sandbox again.
+type WakeRequest struct {
+	// TTL sets the remaining lifetime, in seconds from now; by default the
+	// sandbox gets what was left of it when it hibernated
+	TTL int `json:"ttl"`
+}
+
+func (h *Handler) hibernateSandbox(c echo.Context) error {
+	info, err := h.HibernateSandbox(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *Handler) wakeSandbox(c echo.Context) error {
+	var req WakeRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+		}
+	}
+
+	info, err := h.WakeSandbox(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// HibernateSandbox stops the processes of a running sandbox but keeps its
+// filesystem, until WakeSandbox starts it again with the same ID and files
+// or it is stopped. Its TTL does not run meanwhile, and it counts no memory
+// in usage. Sessions, jobs and REPLs of the sandbox end as they do when it
+// stops. It is the transport independent core of POST
+// /sandbox/:id/hibernate; errors are *APIError.
+func (h *Handler) HibernateSandbox(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	hb, ok := h.driver.(driver.Hibernator)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support hibernation")
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serialize with TTL changes, which would rearm the TTL
+	h.ttlMu.Lock()
+	defer h.ttlMu.Unlock()
+
+	rec, err := h.store.GetSandbox(ctx, id)
+	if err != nil {
+		return nil, errSandboxNotFound
+	}
+	if rec.State != state.SandboxReady {
+		return nil, driverError(fmt.Errorf("%w: sandbox is %s", driver.ErrSandboxNotRunning, rec.State))
+	}
+
+	// The CPU time consumed is gone with the processes
+	h.sampleUsage(ctx, id)
+	hibernatedAt := time.Now()
+	if err := hb.Hibernate(ctx, id); err != nil {
+		h.recordEvent(id, state.EventHibernated, hibernatedAt, "", err)
+		return nil, driverError(err)
+	}
+	rec.State = state.SandboxHibernated
+	rec.HibernatedAt = hibernatedAt
+	h.store.PutSandbox(context.Background(), rec)
+	left := rec.ExpiresAt.Sub(hibernatedAt).Round(time.Second)
+	h.recordEvent(id, state.EventHibernated, hibernatedAt, fmt.Sprintf("%s of its TTL left", left), nil)
+	// A hibernated sandbox holds no memory or CPU
+	h.usageResized(id, hibernatedAt, driver.SandboxConfig{})
+	h.publish(LifecycleEvent{Type: LifecycleHibernated, SandboxID: id})
+	h.sessions.closeSandbox(id)
+	for _, j := range h.jobs.closeSandbox(id) {
+		h.jobDone(j)
+	}
+	h.kernels.closeSandbox(id)
+	audit(ctx, id, "Sandbox hibernated")
+
+	return h.GetSandbox(ctx, id)
+}
+
+// WakeSandbox starts a hibernated sandbox again, with the files it had, and
+// rearms its TTL. It is the transport independent core of POST
+// /sandbox/:id/wake; errors are *APIError.
+func (h *Handler) WakeSandbox(ctx context.Context, id string, req WakeRequest) (*driver.SandboxInfo, error) {
+	hb, ok := h.driver.(driver.Hibernator)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support hibernation")
+	}
+	if req.TTL < 0 {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "ttl cannot be negative")
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	h.ttlMu.Lock()
+	defer h.ttlMu.Unlock()
+
+	rec, err := h.store.GetSandbox(ctx, id)
+	if err != nil {
+		return nil, errSandboxNotFound
+	}
+	if rec.State != state.SandboxHibernated {
+		return nil, newAPIError(http.StatusConflict, CodeConflict, fmt.Sprintf("sandbox is %s, not hibernated", rec.State))
+	}
+
+	now := time.Now()
+	ttl := rec.ExpiresAt.Sub(rec.HibernatedAt)
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > h.maxTTL {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("the remaining lifetime may not exceed %s", h.maxTTL))
+	}
+	expiresAt := now.Add(ttl)
+	if h.maxAge > 0 {
+		// The time spent hibernating counts against the maximum age
+		deadline := rec.CreatedAt.Add(h.maxAge)
+		if !deadline.After(now) {
+			return nil, newAPIError(http.StatusConflict, CodeConflict,
+				fmt.Sprintf("sandboxes may not live longer than %s; this one can only be stopped", h.maxAge))
+		}
+		if expiresAt.After(deadline) {
+			expiresAt = deadline
+		}
+	}
+
+	if err := hb.Wake(ctx, id, expiresAt.Sub(now)); err != nil {
+		h.recordEvent(id, state.EventWoken, now, "", err)
+		return nil, driverError(err)
+	}
+	info, err := h.driver.Info(ctx, id)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	slept := now.Sub(rec.HibernatedAt).Round(time.Second)
+	rec.State = state.SandboxReady
+	rec.HibernatedAt = time.Time{}
+	rec.ExpiresAt = expiresAt
+	h.store.PutSandbox(context.Background(), rec)
+	h.recordEvent(id, state.EventWoken, now,
+		fmt.Sprintf("after %s, expires in %s", slept, expiresAt.Sub(now).Round(time.Second)), nil)
+	h.usageResized(id, now, info.Config)
+	h.publish(LifecycleEvent{Type: LifecycleReady, SandboxID: id, ExpiresAt: &rec.ExpiresAt})
+	audit(ctx, id, "Sandbox woken")
+
+	return h.GetSandbox(ctx, id)
+}
+
+// expires reports whether the expiry of rec is to be shown: hibernated
+// sandboxes get a new one when they wake.
+func expires(rec state.SandboxRecord) bool {
+	return !rec.ExpiresAt.IsZero() && rec.State != state.SandboxHibernated
+}
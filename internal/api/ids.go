@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver/shortid"
+	"github.com/labstack/echo/v4"
+)
+
+// resolveID returns the ID of the sandbox ref names. Like with Docker's CLI,
+// ref may be any unambiguous prefix of the ID, with or without "sbx_", or of
+// the backend's ID. A ref that names no sandbox is returned as is, for the
+// caller to report as not found; one naming a sandbox of another owner
+// fails as not found.
+func (h *Handler) resolveID(ctx context.Context, ref string) (string, error) {
+	id, err := h.lookupID(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return id, h.checkOwner(ctx, id)
+}
+
+// lookupID resolves ref for resolveID. Prefixes only match sandboxes the
+// caller owns.
+func (h *Handler) lookupID(ctx context.Context, ref string) (string, error) {
+	if _, err := h.store.GetSandbox(ctx, ref); err == nil {
+		return ref, nil
+	}
+	if id, ok := h.ids.Lookup(ref); ok {
+		return id, nil
+	}
+
+	matches := make(map[string]bool)
+	match := func(id, backendID string, labels map[string]string) {
+		if !owns(ctx, labels) {
+			return
+		}
+		if strings.HasPrefix(id, ref) || strings.HasPrefix(strings.TrimPrefix(id, shortid.Prefix), ref) ||
+			(backendID != "" && strings.HasPrefix(backendID, ref)) {
+			matches[id] = true
+		}
+	}
+	records, err := h.store.ListSandboxes(ctx)
+	if err != nil {
+		return "", wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list sandboxes", err)
+	}
+	for _, rec := range records {
+		match(rec.ID, rec.BackendID, rec.Labels)
+	}
+	infos, err := h.driver.List(ctx, nil)
+	if err != nil {
+		return "", driverError(err)
+	}
+	for _, info := range infos {
+		match(info.ID, info.BackendID, info.Config.Labels)
+	}
+
+	switch len(matches) {
+	case 0:
+		return ref, nil
+	case 1:
+		for id := range matches {
+			return id, nil
+		}
+	}
+	ids := make([]string, 0, len(matches))
+	for id := range matches {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+		fmt.Sprintf("sandbox ID %q is ambiguous: it matches %s", ref, strings.Join(ids, ", ")))
+}
+
+// resolveSandbox replaces the :id path parameter with the ID of the sandbox
+// it names, so that handlers see full IDs only.
+func (h *Handler) resolveSandbox(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ref := c.Param("id")
+		if ref == "" {
+			return next(c)
+		}
+		id, err := h.resolveID(c.Request().Context(), ref)
+		if err != nil {
+			return err
+		}
+		values := c.ParamValues()
+		for i, name := range c.ParamNames() {
+			if name == "id" {
+				values[i] = id
+			}
+		}
+		c.SetParamValues(values...)
+		return next(c)
+	}
+}
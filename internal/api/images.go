@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// pullJobRetention is how long finished pull jobs remain queryable.
+const pullJobRetention = time.Hour
+
+// Pull job states.
+const (
+	PullPending = "pending"
+	PullRunning = "pulling"
+	PullDone    = "done"
+	PullFailed  = "failed"
+)
+
+// PullJob tracks an asynchronous image pull.
+type PullJob struct {
+	ID         string              `json:"id"`
+	Image      string              `json:"image"`
+	Status     string              `json:"status"`
+	Progress   driver.PullProgress `json:"progress"`
+	Error      string              `json:"error,omitempty"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+}
+
+// pullJobs is the registry of image pulls started through the API or at boot.
+type pullJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*PullJob
+}
+
+func newPullJobs() *pullJobs {
+	return &pullJobs{jobs: make(map[string]*PullJob)}
+}
+
+// get returns a copy of the job so callers can read it without the lock.
+func (p *pullJobs) get(id string) (PullJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	if !ok {
+		return PullJob{}, false
+	}
+	return *job, true
+}
+
+func newJobID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// startPull starts pulling image in the background and returns the job.
+// If a pull for the same image is already in flight, that job is returned.
+func (h *Handler) startPull(im driver.ImageManager, image string) PullJob {
+	h.pulls.mu.Lock()
+	defer h.pulls.mu.Unlock()
+
+	now := time.Now()
+	for id, job := range h.pulls.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > pullJobRetention {
+			delete(h.pulls.jobs, id)
+			continue
+		}
+		if job.Image == image && job.FinishedAt == nil {
+			return *job
+		}
+	}
+
+	job := &PullJob{
+		ID:        newJobID("pull_"),
+		Image:     image,
+		Status:    PullPending,
+		StartedAt: now,
+	}
+	h.pulls.jobs[job.ID] = job
+
+	go func() {
+		err := im.PullImage(context.Background(), image, func(p driver.PullProgress) {
+			h.pulls.mu.Lock()
+			job.Status = PullRunning
+			job.Progress = p
+			h.pulls.mu.Unlock()
+		})
+
+		h.pulls.mu.Lock()
+		defer h.pulls.mu.Unlock()
+		finished := time.Now()
+		job.FinishedAt = &finished
+		if err != nil {
+			job.Status = PullFailed
+			job.Error = err.Error()
+			log.Warn().Err(err).Str("image", image).Msg("Image pull failed")
+			return
+		}
+		job.Status = PullDone
+		log.Info().Str("image", image).Dur("took", finished.Sub(job.StartedAt)).Msg("Image pulled")
+	}()
+
+	return *job
+}
+
+// Prepull starts background pulls for images so that the first create of a
+// template does not block on the download. It is a no-op for drivers without
+// an image cache.
+func (h *Handler) Prepull(images []string) {
+	im, ok := h.ids.Backend().(driver.ImageManager)
+	if !ok {
+		return
+	}
+	for _, image := range images {
+		job := h.startPull(im, image)
+		log.Info().Str("image", image).Str("job", job.ID).Msg("Prepulling image")
+	}
+}
+
+type PullImageRequest struct {
+	Image string `json:"image"`
+}
+
+func (h *Handler) pullImage(c echo.Context) error {
+	im, ok := h.ids.Backend().(driver.ImageManager)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "driver does not manage images")
+	}
+
+	var req PullImageRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	if req.Image == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "image is required")
+	}
+
+	return c.JSON(http.StatusAccepted, h.startPull(im, req.Image))
+}
+
+func (h *Handler) getPullJob(c echo.Context) error {
+	job, ok := h.pulls.get(c.Param("job"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "pull job not found")
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) listImages(c echo.Context) error {
+	im, ok := h.ids.Backend().(driver.ImageManager)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "driver does not manage images")
+	}
+
+	images, err := im.ListImages(c.Request().Context())
+	if err != nil {
+		return driverError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"images": images})
+}
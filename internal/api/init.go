@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// initOutputBytes caps the stdout and stderr kept of an init script.
+const initOutputBytes = 64 * 1024
+
+// runInit runs the init script of tmpl in sandbox id and returns the record
+// of the run. It runs as the image's user, and as root in sandboxes that
+// run code as another user. A script that does not exit 0 within the
+// template's InitTimeout fails with CodeSetupFailed.
+func (h *Handler) runInit(ctx context.Context, id string, tmpl templates.Template, cfg driver.SandboxConfig) (*state.ExecRecord, *APIError) {
+	initCtx, cancel := context.WithTimeout(ctx, tmpl.InitTimeout)
+	defer cancel()
+
+	started := time.Now()
+	stdout := newCappedOutput(initOutputBytes, false)
+	stderr := newCappedOutput(initOutputBytes, false)
+	params := map[string]any{"cmd": "bash", "args": []string{"-c", tmpl.Init}}
+	if cfg.User != "" {
+		params["user"] = "0"
+	}
+	_, exit, apiErr := h.agentExec(initCtx, id, params, "", nil, stdout, stderr, attribute.String("boxed.init", tmpl.Name))
+
+	rec := &state.ExecRecord{
+		Language:   "bash",
+		Code:       tmpl.Init,
+		ExitCode:   exit.code,
+		StartedAt:  started,
+		DurationMS: time.Since(started).Milliseconds(),
+		Stdout:     h.secrets.redact(id, stdout.String()),
+		Stderr:     h.secrets.redact(id, stderr.String()),
+		Truncated:  stdout.truncated || stderr.truncated,
+		ExitReason: exit.reason,
+	}
+	switch {
+	case apiErr != nil && apiErr.Code == CodeTimedOut && ctx.Err() == nil:
+		apiErr = newAPIError(http.StatusUnprocessableEntity, CodeSetupFailed,
+			fmt.Sprintf("init script of template %s did not finish within %s: %s", tmpl.Name, tmpl.InitTimeout, outputTail(rec.Stdout, rec.Stderr)))
+	case apiErr == nil && (exit.code == nil || *exit.code != 0):
+		apiErr = newAPIError(http.StatusUnprocessableEntity, CodeSetupFailed,
+			fmt.Sprintf("init script of template %s failed (%s): %s", tmpl.Name, exitSummary(exit.code), outputTail(rec.Stdout, rec.Stderr)))
+	}
+	if apiErr != nil {
+		rec.Error = apiErr.Message
+	}
+	return rec, apiErr
+}
+
+func (h *Handler) getInit(c echo.Context) error {
+	rec, err := h.Init(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rec)
+}
+
+// Init returns the run of the init script of a sandbox's template, also of
+// one whose script failed its create. It is the transport independent core
+// of GET /sandbox/:id/init; errors are *APIError.
+func (h *Handler) Init(ctx context.Context, id string) (*state.ExecRecord, error) {
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := h.store.GetSandbox(ctx, id)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, errSandboxNotFound
+	}
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to read sandbox", err)
+	}
+	if rec.Init == nil {
+		return nil, newAPIError(http.StatusNotFound, CodeNotFound, "sandbox ran no init script")
+	}
+	return rec.Init, nil
+}
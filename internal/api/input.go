@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Defaults of InputLimits.
+const (
+	// DefaultMaxCodeSize is about the most Linux passes in the single
+	// argument code runs as
+	DefaultMaxCodeSize = 128 << 10
+	DefaultMaxArgs     = 1024
+	DefaultMaxEnvVars  = 256
+	DefaultMaxEnvSize  = 256 << 10
+
+	// DefaultMaxBodySize leaves room for context files of the default
+	// DefaultMaxContextSize, base64 encoded
+	DefaultMaxBodySize = 512 << 20
+)
+
+// InputLimits bound what a request can carry, so that a hostile or buggy
+// client cannot push huge code, command lines or environments into a
+// sandbox. Zero fields keep their defaults.
+type InputLimits struct {
+	// CodeSize caps the bytes of the code of an exec
+	CodeSize int
+
+	// Args caps the arguments of a command line, such as a sidecar's
+	Args int
+
+	// EnvVars and EnvSize cap the variables of an environment and the
+	// bytes of their names and values
+	EnvVars int
+	EnvSize int
+
+	// BodySize caps the bytes of a JSON request body
+	BodySize int
+}
+
+// withDefaults fills the zero fields of l.
+func (l InputLimits) withDefaults() InputLimits {
+	if l.CodeSize <= 0 {
+		l.CodeSize = DefaultMaxCodeSize
+	}
+	if l.Args <= 0 {
+		l.Args = DefaultMaxArgs
+	}
+	if l.EnvVars <= 0 {
+		l.EnvVars = DefaultMaxEnvVars
+	}
+	if l.EnvSize <= 0 {
+		l.EnvSize = DefaultMaxEnvSize
+	}
+	if l.BodySize <= 0 {
+		l.BodySize = DefaultMaxBodySize
+	}
+	return l
+}
+
+// WithInputLimits bounds the code, command lines, environments and JSON
+// bodies of requests; see InputLimits.
+func WithInputLimits(l InputLimits) Option {
+	return func(h *Handler) {
+		h.inputs = l.withDefaults()
+	}
+}
+
+// tooLarge is the error of an input over its limit.
+func tooLarge(format string, args ...any) *APIError {
+	return newAPIError(http.StatusRequestEntityTooLarge, CodeInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+// checkExecInput checks the code and environment of an exec against the
+// limits.
+func (h *Handler) checkExecInput(req ExecRequest) error {
+	if len(req.Code) > h.inputs.CodeSize {
+		return tooLarge("code is %d bytes, over the limit of %d", len(req.Code), h.inputs.CodeSize)
+	}
+	if err := h.checkArgs("args", req.Args); err != nil {
+		return err
+	}
+	return h.checkEnv("env", req.Env)
+}
+
+// checkSidecarInput checks the command lines and environments of sidecars
+// against the limits.
+func (h *Handler) checkSidecarInput(sidecars []driver.Sidecar) error {
+	for _, sc := range sidecars {
+		if err := h.checkArgs("sidecar "+sc.Name, sc.Cmd); err != nil {
+			return err
+		}
+		if sc.HealthCheck != nil {
+			if err := h.checkArgs("health check of sidecar "+sc.Name, sc.HealthCheck.Cmd); err != nil {
+				return err
+			}
+		}
+		if err := h.checkEnv("env of sidecar "+sc.Name, sc.Env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) checkArgs(what string, args []string) error {
+	if len(args) > h.inputs.Args {
+		return tooLarge("%s has %d arguments, over the limit of %d", what, len(args), h.inputs.Args)
+	}
+	return nil
+}
+
+func (h *Handler) checkEnv(what string, env map[string]string) error {
+	if len(env) > h.inputs.EnvVars {
+		return tooLarge("%s has %d variables, over the limit of %d", what, len(env), h.inputs.EnvVars)
+	}
+	size := 0
+	for k, v := range env {
+		size += len(k) + len(v)
+	}
+	if size > h.inputs.EnvSize {
+		return tooLarge("%s is %d bytes, over the limit of %d", what, size, h.inputs.EnvSize)
+	}
+	return nil
+}
+
+// limitBody caps JSON request bodies at the limit. Uploads, which are
+// streamed rather than held in memory, are not JSON and are not capped.
+func (h *Handler) limitBody() echo.MiddlewareFunc {
+	limit := middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Limit: strconv.Itoa(h.inputs.BodySize),
+		Skipper: func(c echo.Context) bool {
+			return !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
+		},
+	})
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		next = limit(readUnsized(next))
+		return func(c echo.Context) error {
+			err := next(c)
+			// Handlers report a body cut short as one they cannot parse
+			if errors.Is(err, echo.ErrStatusRequestEntityTooLarge) {
+				return tooLarge("request body is over the limit of %d bytes", h.inputs.BodySize)
+			}
+			return err
+		}
+	}
+}
+
+// readUnsized reads a body of unknown length, as sent chunked, through the
+// limit before the handler sees it. Decoding JSON stops at the end of the
+// value and can miss the error of a limit hit in the same read.
+func readUnsized(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.ContentLength >= 0 {
+			return next(c)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return next(c)
+	}
+}
@@ -0,0 +1,509 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Job states.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// Headers of job completion callbacks.
+const (
+	// JobHeader carries the job ID
+	JobHeader = "X-Boxed-Job"
+	// JobSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// body keyed with the server's API key; only set if there is one
+	JobSignatureHeader = "X-Boxed-Signature"
+)
+
+// Limits on exec jobs.
+const (
+	// jobRetention is how long finished jobs remain queryable
+	jobRetention = time.Hour
+
+	// jobDefaultTimeout bounds a job that sets no timeout
+	jobDefaultTimeout = 10 * time.Minute
+
+	// jobMaxTimeout caps the timeout a job may ask for
+	jobMaxTimeout = time.Hour
+
+	// jobCallbackAttempts is how often a callback is tried, a second
+	// apart and then twice as long each time, before giving up
+	jobCallbackAttempts = 3
+
+	// jobCallbackTimeout bounds one callback attempt
+	jobCallbackTimeout = 10 * time.Second
+)
+
+// WithCallbackAllowCIDRs lets job callbacks be sent to the private ranges
+// allow, such as that of an internal receiver. Others outside the internet
+// are refused, as by the egress proxy.
+func WithCallbackAllowCIDRs(allow []netip.Prefix) Option {
+	return func(h *Handler) {
+		h.callbackAddrs.allow = allow
+	}
+}
+
+var (
+	errJobCanceled   = errors.New("job canceled")
+	errSandboxClosed = errors.New("sandbox stopped")
+)
+
+// JobRequest is an exec to run in the background.
+type JobRequest struct {
+	ExecRequest
+
+	// Timeout bounds the run, not counting the time queued, in seconds;
+	// 0 means 10 minutes
+	Timeout int `json:"timeout,omitempty"`
+
+	// CallbackURL is sent a POST of the finished Job
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Job is an exec queued with POST /sandbox/:id/jobs. Jobs of a sandbox run
+// one at a time, in the order they were queued.
+type Job struct {
+	ID        string `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	Status    string `json:"status"`
+	Language  string `json:"language"`
+
+	// Result is set once the exec has run, whatever its exit code
+	Result *ExecResponse `json:"result,omitempty"`
+
+	// Error and ErrorCode say why a failed or canceled job has no result
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+
+	CallbackURL string       `json:"callback_url,omitempty"`
+	Callback    *JobCallback `json:"callback,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobCallback reports the delivery of a job's completion callback.
+type JobCallback struct {
+	Attempts int `json:"attempts"`
+	// StatusCode is the response to the last attempt, if there was one
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// execJob is a Job with what it needs to run.
+type execJob struct {
+	Job
+	req JobRequest
+	// ctx is cancelled, with errJobCanceled or errSandboxClosed as its
+	// cause, to cancel the job
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// end ends the job's activity once it and its callback are done
+	end func()
+}
+
+// jobRegistry holds the jobs of all sandboxes.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*execJob
+	// queued holds the jobs waiting per sandbox, oldest first; a sandbox
+	// is in it while it has a worker
+	queued map[string][]*execJob
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[string]*execJob), queued: make(map[string][]*execJob)}
+}
+
+// add queues j and reports whether its sandbox needs a worker.
+func (r *jobRegistry) add(j *execJob) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, old := range r.jobs {
+		if old.FinishedAt != nil && now.Sub(*old.FinishedAt) > jobRetention {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.ID] = j
+	queue, working := r.queued[j.SandboxID]
+	r.queued[j.SandboxID] = append(queue, j)
+	return !working
+}
+
+// next takes the oldest job of a sandbox off its queue and marks it
+// running. It returns nil, and forgets the sandbox's worker, when there
+// are none left.
+func (r *jobRegistry) next(sandboxID string) *execJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.queued[sandboxID]
+	if len(queue) == 0 {
+		delete(r.queued, sandboxID)
+		return nil
+	}
+	j := queue[0]
+	r.queued[sandboxID] = queue[1:]
+	now := time.Now()
+	j.Status = JobRunning
+	j.StartedAt = &now
+	return j
+}
+
+// get returns a copy of the job so callers can read it without the lock.
+func (r *jobRegistry) get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// list returns the jobs of a sandbox, oldest first.
+func (r *jobRegistry) list(sandboxID string) []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := []Job{}
+	for _, j := range r.jobs {
+		if j.SandboxID == sandboxID {
+			jobs = append(jobs, j.Job)
+		}
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.Before(jobs[b].CreatedAt) })
+	return jobs
+}
+
+// snapshot returns a copy of j so callers can read it without the lock.
+func (r *jobRegistry) snapshot(j *execJob) Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return j.Job
+}
+
+// finish records the outcome of a job that ran.
+func (r *jobRegistry) finish(j *execJob, res *ExecResponse, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	j.FinishedAt = &now
+	switch cause := context.Cause(j.ctx); {
+	case err == nil:
+		j.Status = JobDone
+		j.Result = res
+	case errors.Is(cause, errJobCanceled), errors.Is(cause, errSandboxClosed):
+		j.Status = JobCanceled
+		j.Error = cause.Error()
+	default:
+		j.Status = JobFailed
+		j.Error = err.Error()
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			j.Error = apiErr.Message
+			j.ErrorCode = apiErr.Code
+		}
+	}
+}
+
+// cancelLocked cancels j: a queued job is finished at once, a running one
+// when its exec returns. It reports whether j was still queued.
+func (r *jobRegistry) cancelLocked(j *execJob, cause error) bool {
+	j.cancel(cause)
+	if j.Status != JobQueued {
+		return false
+	}
+	now := time.Now()
+	j.Status = JobCanceled
+	j.Error = cause.Error()
+	j.FinishedAt = &now
+	queue := r.queued[j.SandboxID]
+	for i, q := range queue {
+		if q == j {
+			r.queued[j.SandboxID] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// cancel cancels a job that has not finished and reports whether it was
+// still queued.
+func (r *jobRegistry) cancel(id string) (j *execJob, queued bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, false, newAPIError(http.StatusNotFound, CodeNotFound, "job not found")
+	}
+	if j.FinishedAt != nil {
+		return nil, false, newAPIError(http.StatusConflict, CodeConflict, "job has finished")
+	}
+	return j, r.cancelLocked(j, errJobCanceled), nil
+}
+
+// closeSandbox cancels the unfinished jobs of a stopped sandbox and
+// returns those that were still queued.
+func (r *jobRegistry) closeSandbox(sandboxID string) []*execJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dequeued []*execJob
+	for _, j := range r.jobs {
+		if j.SandboxID == sandboxID && j.FinishedAt == nil && r.cancelLocked(j, errSandboxClosed) {
+			dequeued = append(dequeued, j)
+		}
+	}
+	return dequeued
+}
+
+func (h *Handler) createJob(c echo.Context) error {
+	var req JobRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	job, err := h.CreateJob(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// CreateJob queues an exec in a sandbox and returns at once; the exec runs
+// after the sandbox's earlier jobs. It is the transport independent core
+// of POST /sandbox/:id/jobs; errors are *APIError.
+func (h *Handler) CreateJob(ctx context.Context, id string, req JobRequest) (*Job, error) {
+	if h.activity.isDraining() {
+		return nil, errDraining
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Jobs are reviewed as they are queued, when the caller is known
+	if req.ExecRequest, err = h.admitExec(ctx, id, req.ExecRequest); err != nil {
+		return nil, err
+	}
+	if _, _, err := h.execCommand(req.ExecRequest); err != nil {
+		return nil, err
+	}
+	if req.Timeout < 0 || time.Duration(req.Timeout)*time.Second > jobMaxTimeout {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("timeout must be between 1s and %s", jobMaxTimeout))
+	}
+	if req.CallbackURL != "" {
+		u, err := url.Parse(req.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "callback_url must be an http or https URL")
+		}
+		// Names are checked as they are dialed
+		if addr, err := netip.ParseAddr(u.Hostname()); err == nil && h.callbackAddrs.blocked(addr) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "callback_url host "+u.Hostname()+" is not on the internet")
+		}
+	}
+	if _, err := h.driver.Info(ctx, id); err != nil {
+		return nil, driverError(err)
+	}
+
+	// Jobs outlive the request, not the sandbox; they run as its caller
+	jobCtx, cancel := context.WithCancelCause(context.Background())
+	if claims := auth.FromContext(ctx); claims != nil {
+		jobCtx = auth.WithClaims(jobCtx, claims)
+	}
+	j := &execJob{
+		Job: Job{
+			ID:          newJobID("job_"),
+			SandboxID:   id,
+			Status:      JobQueued,
+			Language:    req.Language,
+			CallbackURL: req.CallbackURL,
+			CreatedAt:   time.Now(),
+		},
+		req:    req,
+		ctx:    jobCtx,
+		cancel: cancel,
+		end:    h.activity.begin("job"),
+	}
+	job := j.Job
+	if h.jobs.add(j) {
+		go h.runJobs(id)
+	}
+	return &job, nil
+}
+
+// runJobs runs the queued jobs of a sandbox until there are none left.
+func (h *Handler) runJobs(sandboxID string) {
+	for j := h.jobs.next(sandboxID); j != nil; j = h.jobs.next(sandboxID) {
+		timeout := jobDefaultTimeout
+		if j.req.Timeout > 0 {
+			timeout = time.Duration(j.req.Timeout) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(withQueuedExec(j.ctx), timeout)
+		res, err := h.exec(ctx, sandboxID, j.req.ExecRequest, nil)
+		cancel()
+		j.cancel(nil)
+		h.jobs.finish(j, res, err)
+		h.jobDone(j)
+	}
+}
+
+// jobDone delivers the callback of a finished job, if it has one, and ends
+// its activity.
+func (h *Handler) jobDone(j *execJob) {
+	job := h.jobs.snapshot(j)
+	log.Info().Str("job", job.ID).Str("id", job.SandboxID).Str("status", job.Status).Msg("Job finished")
+	if job.CallbackURL == "" {
+		j.end()
+		return
+	}
+	go func() {
+		defer j.end()
+		h.deliverCallback(j, job)
+	}()
+}
+
+// deliverCallback POSTs job to its callback URL, retrying failed attempts,
+// and records the outcome.
+func (h *Handler) deliverCallback(j *execJob, job Job) {
+	body, _ := json.Marshal(job)
+	var signature string
+	if h.apiKey != "" {
+		mac := hmac.New(sha256.New, []byte(h.apiKey))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	// Callbacks only go to the internet, and are not redirected elsewhere
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: h.callbackAddrs.dial, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	cb := JobCallback{}
+	backoff := time.Second
+	for cb.Attempts < jobCallbackAttempts {
+		if cb.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		cb.Attempts++
+		cb.StatusCode, cb.Error = 0, ""
+
+		ctx, cancel := context.WithTimeout(context.Background(), jobCallbackTimeout)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(JobHeader, job.ID)
+		if signature != "" {
+			req.Header.Set(JobSignatureHeader, signature)
+		}
+		resp, err := client.Do(req)
+		cancel()
+		if err != nil {
+			cb.Error = err.Error()
+			if errors.Is(err, errNotInternet) {
+				break
+			}
+			continue
+		}
+		resp.Body.Close()
+		cb.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			now := time.Now()
+			cb.DeliveredAt = &now
+			break
+		}
+		cb.Error = resp.Status
+	}
+	if cb.DeliveredAt == nil {
+		log.Warn().Str("job", job.ID).Str("error", cb.Error).Int("attempts", cb.Attempts).Msg("Job callback failed")
+	}
+
+	h.jobs.mu.Lock()
+	j.Callback = &cb
+	h.jobs.mu.Unlock()
+}
+
+func (h *Handler) getJob(c echo.Context) error {
+	job, err := h.GetJob(c.Param("job"))
+	if err != nil {
+		return err
+	}
+	if err := h.checkJobOwner(c.Request().Context(), job.SandboxID); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// checkJobOwner fails as if the job did not exist unless the caller may use
+// its sandbox.
+func (h *Handler) checkJobOwner(ctx context.Context, sandboxID string) error {
+	if h.checkOwner(ctx, sandboxID) != nil {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "job not found")
+	}
+	return nil
+}
+
+// GetJob returns a job queued in the last hour or still unfinished. It is
+// the transport independent core of GET /jobs/:job; errors are *APIError.
+func (h *Handler) GetJob(jobID string) (*Job, error) {
+	job, ok := h.jobs.get(jobID)
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, CodeNotFound, "job not found")
+	}
+	return &job, nil
+}
+
+// cancelJob serves DELETE /jobs/:job. Canceling a running job cancels its
+// exec, which kills the process in the sandbox.
+func (h *Handler) cancelJob(c echo.Context) error {
+	job, err := h.GetJob(c.Param("job"))
+	if err != nil {
+		return err
+	}
+	if err := h.checkJobOwner(c.Request().Context(), job.SandboxID); err != nil {
+		return err
+	}
+	j, queued, err := h.jobs.cancel(job.ID)
+	if err != nil {
+		return err
+	}
+	if queued {
+		h.jobDone(j)
+	}
+	return c.JSON(http.StatusOK, h.jobs.snapshot(j))
+}
+
+func (h *Handler) listJobs(c echo.Context) error {
+	id := c.Param("id")
+	if _, err := h.driver.Info(c.Request().Context(), id); err != nil {
+		return driverError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"jobs": h.jobs.list(id)})
+}
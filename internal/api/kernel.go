@@ -0,0 +1,420 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/rs/zerolog/log"
+)
+
+// kernelMarker starts the lines a kernel writes to report output and
+// results; other lines come from the code it runs.
+const kernelMarker = "\x1eboxed "
+
+// kernel is an interpreter kept per sandbox, which runs the code of the
+// execs of a session language in turn so that they share its state.
+type kernel struct {
+	// name is how errors and logs refer to it, e.g. "python session"
+	name string
+
+	// noun is what the next exec starts after the kernel ended
+	noun string
+
+	cmd  string
+	args []string
+
+	// input frames the code of an exec as a line of input of the kernel
+	input func(code string) string
+
+	// stderrFrames is set for kernels that end each exec with an exit
+	// frame on stderr as well as on stdout, so that the exec gets all of
+	// its stderr before it returns
+	stderrFrames bool
+
+	// exitIsResult is set for kernels that end when the code exits, such
+	// as a shell running exit: the exec running then reports the kernel's
+	// exit code rather than -1
+	exitIsResult bool
+}
+
+// kernels are the kernels of the session languages.
+var kernels = map[string]*kernel{
+	LanguagePythonSession: pythonKernel,
+	LanguageBashSession:   bashKernel,
+}
+
+// isSessionLanguage reports whether execs of language run in a kernel.
+func isSessionLanguage(language string) bool {
+	_, ok := kernels[language]
+	return ok
+}
+
+// kernelSession is an agent connection running a kernel of a sandbox.
+type kernelSession struct {
+	sandboxID string
+	kernel    *kernel
+	conn      io.ReadWriteCloser
+
+	// busy is held by the exec running in the kernel
+	busy chan struct{}
+
+	// connMu serialises input and signals
+	connMu sync.Mutex
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// run receives the output; output while no exec runs is dropped
+	run *kernelRun
+	// partial and partialErr are stdout and stderr received after their
+	// last newline
+	partial, partialErr string
+	closed              bool
+	// failure is the error the agent reported for the kernel, kept for
+	// the exec it ends
+	failure execExit
+	// exitCode is the kernel's own exit code, once it exited
+	exitCode *int
+}
+
+// kernelRun collects the output of one exec.
+type kernelRun struct {
+	stdout, stderr *cappedOutput
+	artifacts      []proto.ArtifactEvent
+
+	// exit receives the exit code, or -1 if the kernel died
+	exit chan int
+	code int
+	// frames counts the exit frames received
+	frames int
+	// failure is why the kernel died, if the agent said
+	failure execExit
+}
+
+// kernelKey identifies the kernel of a language in a sandbox.
+type kernelKey struct {
+	sandboxID, language string
+}
+
+// kernelRegistry holds the kernels of the sandboxes that have one.
+type kernelRegistry struct {
+	mu       sync.Mutex
+	sessions map[kernelKey]*kernelSession
+}
+
+func newKernelRegistry() *kernelRegistry {
+	return &kernelRegistry{sessions: make(map[kernelKey]*kernelSession)}
+}
+
+// get returns the kernel of language in the sandbox, starting one if there
+// is none.
+func (r *kernelRegistry) get(ctx context.Context, d driver.Driver, id, language string) (*kernelSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := kernelKey{id, language}
+	if s := r.sessions[key]; s != nil {
+		return s, nil
+	}
+
+	k := kernels[language]
+	// The kernel outlives the request starting it
+	conn, err := d.Connect(context.WithoutCancel(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	start, _ := json.Marshal(proto.NewRequest("repl.start", map[string]any{
+		"cmd":  k.cmd,
+		"args": k.args,
+	}, 1))
+	if _, err := conn.Write(append(start, '\n')); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", k.name, err)
+	}
+
+	s := &kernelSession{sandboxID: id, kernel: k, conn: conn, busy: make(chan struct{}, 1)}
+	r.sessions[key] = s
+	go s.pump(r)
+	log.Info().Str("id", id).Str("language", language).Msg("Started kernel")
+	return s, nil
+}
+
+func (r *kernelRegistry) remove(s *kernelSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, cur := range r.sessions {
+		if cur == s {
+			delete(r.sessions, key)
+		}
+	}
+}
+
+// closeSandbox ends the kernels of a sandbox, e.g. when it is stopped.
+func (r *kernelRegistry) closeSandbox(id string) {
+	r.mu.Lock()
+	var ended []*kernelSession
+	for key, s := range r.sessions {
+		if key.sandboxID == id {
+			ended = append(ended, s)
+			delete(r.sessions, key)
+		}
+	}
+	r.mu.Unlock()
+	for _, s := range ended {
+		s.close()
+	}
+}
+
+// exec runs code in the kernel, writing its output to stdout and stderr.
+// It waits for an exec already running in the kernel to finish first.
+func (s *kernelSession) exec(ctx context.Context, code string, stdout, stderr *cappedOutput) (*kernelRun, error) {
+	select {
+	case s.busy <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.busy }()
+
+	run := &kernelRun{stdout: stdout, stderr: stderr, exit: make(chan int, 1)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, io.EOF
+	}
+	s.run = run
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.run = nil
+		s.mu.Unlock()
+	}()
+
+	// With an ID a failed input is answered rather than dropped
+	input, _ := json.Marshal(proto.NewRequest("repl.input", map[string]any{
+		"data": s.kernel.input(code),
+	}, 2))
+	s.connMu.Lock()
+	_, err := s.conn.Write(append(input, '\n'))
+	s.connMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		// The code may run on for any time; a new kernel is quicker
+		s.close()
+		return nil, ctx.Err()
+	case run.code = <-run.exit:
+		return run, nil
+	}
+}
+
+// pump reads the agent's messages until the kernel ends, which ends the
+// session: the next exec starts a new one.
+func (s *kernelSession) pump(r *kernelRegistry) {
+	defer r.remove(s)
+	defer s.close()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			Method string          `json:"method"`
+			Params map[string]any  `json:"params"`
+			Error  *proto.RPCError `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Error != nil {
+			s.failed(proto.ErrorInternal, msg.Error.Message)
+			return
+		}
+		switch msg.Method {
+		case "stdout":
+			chunk, _ := msg.Params["chunk"].(string)
+			s.stdout(chunk)
+		case "stderr":
+			chunk, _ := msg.Params["chunk"].(string)
+			s.stderr(chunk)
+		case "artifact":
+			s.mu.Lock()
+			if s.run != nil {
+				s.run.artifacts = append(s.run.artifacts, artifactFromParams(s.sandboxID, msg.Params, ""))
+			}
+			s.mu.Unlock()
+		case "error":
+			// The interpreter failed to start or crashed
+			message, _ := msg.Params["message"].(string)
+			kind, _ := msg.Params["kind"].(string)
+			s.failed(kind, message)
+			return
+		case "exit":
+			// The interpreter itself ended
+			if code, ok := msg.Params["code"].(float64); ok {
+				c := int(code)
+				s.mu.Lock()
+				s.exitCode = &c
+				s.mu.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// failed records an error the agent reported for the kernel.
+func (s *kernelSession) failed(kind, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure.failed(kind, msg)
+}
+
+// stdout splits the kernel's output into marker lines and plain output.
+func (s *kernelSession) stdout(chunk string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = s.lines(s.partial+chunk, func(r *kernelRun) *cappedOutput { return r.stdout })
+}
+
+// stderr passes on the kernel's errors, split into marker lines and plain
+// output for kernels that write frames there.
+func (s *kernelSession) stderr(chunk string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kernel.stderrFrames {
+		s.partialErr = s.lines(s.partialErr+chunk, func(r *kernelRun) *cappedOutput { return r.stderr })
+	} else if s.run != nil {
+		s.run.stderr.WriteString(chunk)
+	}
+}
+
+// lines writes the complete lines of data to the output out picks of the
+// running exec, handles their frames, and returns the rest. Callers hold
+// s.mu.
+func (s *kernelSession) lines(data string, out func(*kernelRun) *cappedOutput) string {
+	for {
+		line, rest, ok := strings.Cut(data, "\n")
+		if !ok {
+			return data
+		}
+		data = rest
+
+		text, frame, isFrame := strings.Cut(line, kernelMarker)
+		if !isFrame {
+			text += "\n"
+		}
+		if s.run != nil {
+			out(s.run).WriteString(text)
+		}
+		if isFrame {
+			s.frame(frame)
+		}
+	}
+}
+
+// frame handles a marker line. Callers hold s.mu.
+func (s *kernelSession) frame(data string) {
+	var f struct {
+		Stdout string `json:"stdout"`
+		Stderr string `json:"stderr"`
+		Exit   *int   `json:"exit"`
+	}
+	if err := json.Unmarshal([]byte(data), &f); err != nil || s.run == nil {
+		return
+	}
+	s.run.stdout.WriteString(f.Stdout)
+	s.run.stderr.WriteString(f.Stderr)
+	if f.Exit == nil {
+		return
+	}
+	s.run.frames++
+	if s.run.frames == 1 {
+		s.run.code = *f.Exit
+	}
+	if !s.kernel.stderrFrames || s.run.frames == 2 {
+		s.run.exit <- s.run.code
+		s.run = nil
+	}
+}
+
+// signal sends sig to the kernel while it runs an exec: SIGINT interrupts
+// the code, ending the exec and keeping the session; signals that kill the
+// kernel end it.
+func (s *kernelSession) signal(sig int) bool {
+	s.mu.Lock()
+	running := s.run != nil && !s.closed
+	s.mu.Unlock()
+	return running && sendSignal(&s.connMu, s.conn, "", sig) == nil
+}
+
+func (s *kernelSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.conn.Close()
+	if s.run != nil {
+		code := -1
+		if s.kernel.exitIsResult && s.exitCode != nil {
+			code = *s.exitCode
+		}
+		s.run.stdout.WriteString(s.partial)
+		s.run.stderr.WriteString(s.partialErr)
+		s.run.stderr.WriteString(fmt.Sprintf("\n%s ended; the next exec starts a new %s\n", s.kernel.name, s.kernel.noun))
+		s.run.failure = s.failure
+		s.run.exit <- code
+		s.run = nil
+	}
+}
+
+// execSession runs req in the sandbox's kernel of its language. It mirrors
+// the agent exec in Exec, whose result handling it shares.
+func (h *Handler) execSession(ctx context.Context, id string, req ExecRequest, stdout, stderr *cappedOutput) ([]proto.ArtifactEvent, execExit, error) {
+	if req.Artifacts != nil {
+		return nil, execExit{}, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "artifact options are not supported with "+req.Language)
+	}
+	if req.Cwd != "" || req.User != "" || len(req.Env) > 0 {
+		// The kernel is shared by the session's execs
+		return nil, execExit{}, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "cwd, user and env are not supported with "+req.Language)
+	}
+	s, err := h.kernels.get(ctx, h.driver, id, req.Language)
+	if err != nil {
+		apiErr := driverError(err)
+		if apiErr.Code == CodeInternal {
+			apiErr.Message = fmt.Sprintf("failed to connect to sandbox: %v", err)
+		}
+		return nil, execExit{}, apiErr
+	}
+	h.recordAgentReady(id)
+
+	unregister := h.procs.add(id, s)
+	run, err := s.exec(ctx, req.Code, stdout, stderr)
+	unregister()
+	if err == io.EOF {
+		// The kernel ended while this exec waited for it; try a new one
+		if s, err = h.kernels.get(ctx, h.driver, id, req.Language); err != nil {
+			return nil, execExit{}, driverError(err)
+		}
+		unregister = h.procs.add(id, s)
+		run, err = s.exec(ctx, req.Code, stdout, stderr)
+		unregister()
+	}
+	if ctx.Err() != nil {
+		return nil, execExit{}, contextError(ctx)
+	}
+	if err != nil {
+		return nil, execExit{}, wrapAPIError(http.StatusInternalServerError, CodeInternal, s.kernel.name+" error", err)
+	}
+	exit := run.failure
+	exit.code = &run.code
+	return run.artifacts, exit, nil
+}
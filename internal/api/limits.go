@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// sandboxLimit caps the live sandboxes of a server. Creates in flight count
+// too, so concurrent requests cannot overshoot.
+type sandboxLimit struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+// reserve takes a slot for a create, or fails with 429 if the server is
+// full. release must be called once the create is done either way.
+func (l *sandboxLimit) reserve(ctx context.Context, d driver.Driver) (release func(), err error) {
+	if l.max <= 0 {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	live, err := d.List(ctx, []driver.SandboxState{driver.StateCreating, driver.StateReady})
+	if err != nil {
+		return nil, driverError(err)
+	}
+	if len(live)+l.inFlight >= l.max {
+		return nil, newAPIError(http.StatusTooManyRequests, CodeQuotaExceeded,
+			fmt.Sprintf("the server is at its limit of %d sandboxes", l.max))
+	}
+	l.inFlight++
+	return func() {
+		l.mu.Lock()
+		l.inFlight--
+		l.mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// getLogs serves GET /sandbox/:id/logs?source=agent|process&follow=true.
+// Lines are sent as newline-delimited JSON, one driver.LogLine each; with
+// follow set the response stays open until the sandbox stops or the client
+// goes away. exec=ID keeps the lines of one agent process.
+func (h *Handler) getLogs(c echo.Context) error {
+	lr, ok := h.driver.(driver.LogReader)
+	if !ok {
+		return newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not keep sandbox logs")
+	}
+	source := c.QueryParam("source")
+	if source == "" {
+		source = driver.LogSourceAgent
+	}
+	exec := c.QueryParam("exec")
+	follow := false
+	if v := c.QueryParam("follow"); v != "" {
+		var err error
+		if follow, err = strconv.ParseBool(v); err != nil {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "follow must be true or false")
+		}
+	}
+
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	lines, err := lr.Logs(ctx, id, source, follow)
+	if err != nil {
+		return driverError(err)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	enc := json.NewEncoder(res)
+	for line := range lines {
+		if exec != "" && line.Exec != exec {
+			continue
+		}
+		line.Text = h.secrets.redact(id, line.Text)
+		if err := enc.Encode(line); err != nil {
+			return nil
+		}
+		// Flush once the backlog is written, then per followed line
+		if len(lines) == 0 {
+			res.Flush()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/gorilla/websocket"
+)
+
+// What an interactive session does when its client reads output slower
+// than the process writes it; chosen with ?overflow= on the interact
+// endpoint.
+const (
+	// OverflowBlock stops reading from the process until the client
+	// catches up, which in turn blocks the process's writes
+	OverflowBlock = "block"
+	// OverflowDrop discards output the client has no room for
+	OverflowDrop = "drop"
+)
+
+// Flow states of the "flow" notifications sent to interact clients.
+const (
+	flowPaused  = "paused"
+	flowResumed = "resumed"
+	flowDropped = "dropped"
+)
+
+// Limits on the data between an interactive session and its client.
+const (
+	// outboxBytes bounds the output queued for a client
+	outboxBytes = 1 << 20
+
+	// sessionMaxMessage bounds a message to the client; longer output
+	// chunks are split
+	sessionMaxMessage = 32 * 1024
+
+	// sessionMaxInput bounds a message from the client
+	sessionMaxInput = 1 << 20
+
+	// sessionWriteTimeout is how long a write to the client may take
+	// before the client is taken for gone
+	sessionWriteTimeout = 10 * time.Second
+)
+
+// ArtifactsBinary, given as ?artifacts= on the interact endpoint, has
+// artifacts too large for one message streamed as binary frames.
+const ArtifactsBinary = "binary"
+
+// artifactFrameHeader is the size of the header of a binary artifact
+// frame: the stream ID, the index of the frame in the stream and the
+// CRC-32 (IEEE) of its payload, each a big-endian uint32.
+const artifactFrameHeader = 12
+
+// validOverflow reports whether policy is an overflow policy.
+func validOverflow(policy string) bool {
+	return policy == OverflowBlock || policy == OverflowDrop
+}
+
+// outbox queues agent messages for one WebSocket client and writes them
+// from its own goroutine, so that a slow client holds up neither the
+// session nor, unless its policy blocks, the process. Output beyond
+// outboxBytes waits or is dropped according to the policy; the client is
+// told with "flow" notifications.
+type outbox struct {
+	ws     *websocket.Conn
+	policy string
+	// binary is set if the client takes artifacts as binary frames
+	binary bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	bytes  int
+	closed bool
+	// paused is set once the client has been told output waits
+	paused bool
+	// dropped counts the messages and bytes dropped since the client was
+	// last told
+	dropped      int
+	droppedBytes int
+	// frames are the binary frames of artifacts, written when no other
+	// message waits, so that they hold up neither output nor exits
+	frames     [][]byte
+	frameBytes int
+	// streams numbers the artifacts streamed
+	streams uint32
+}
+
+func newOutbox(ws *websocket.Conn, policy string, binary bool) *outbox {
+	o := &outbox{ws: ws, policy: policy, binary: binary}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// push queues msg, an agent message; output waits for room or is dropped
+// per the policy, other messages are always queued.
+func (o *outbox) push(msg []byte, output bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if output && o.bytes+len(msg) > outboxBytes {
+		if o.policy == OverflowDrop {
+			o.dropped++
+			o.droppedBytes += len(msg)
+			return
+		}
+		if !o.paused {
+			o.paused = true
+			o.enqueueLocked(flowNotice(flowPaused, 0, 0))
+		}
+		for !o.closed && o.bytes > 0 && o.bytes+len(msg) > outboxBytes {
+			o.cond.Wait()
+		}
+	}
+	if !output && o.dropped > 0 {
+		// Say what was lost before e.g. the exit it preceded
+		o.enqueueLocked(flowNotice(flowDropped, o.dropped, o.droppedBytes))
+		o.dropped, o.droppedBytes = 0, 0
+	}
+	o.enqueueLocked(msg)
+}
+
+// drop counts a message that was not queued at all, e.g. for its size.
+func (o *outbox) drop(size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dropped++
+	o.droppedBytes += size
+	if o.bytes == 0 {
+		o.noticeLocked()
+	}
+}
+
+func (o *outbox) enqueueLocked(msg []byte) {
+	if o.closed {
+		return
+	}
+	o.queue = append(o.queue, msg)
+	o.bytes += len(msg)
+	o.cond.Broadcast()
+}
+
+// noticeLocked tells the client about the drops and the pause once the
+// queue has drained to half.
+func (o *outbox) noticeLocked() {
+	if o.bytes > outboxBytes/2 {
+		return
+	}
+	if o.dropped > 0 {
+		o.enqueueLocked(flowNotice(flowDropped, o.dropped, o.droppedBytes))
+		o.dropped, o.droppedBytes = 0, 0
+	}
+	if o.paused {
+		o.paused = false
+		o.enqueueLocked(flowNotice(flowResumed, 0, 0))
+	}
+}
+
+// run writes queued messages until the outbox is closed or a write fails,
+// and then calls gone unless the outbox was closed.
+func (o *outbox) run(gone func()) {
+	for {
+		o.mu.Lock()
+		for !o.closed && len(o.queue) == 0 && len(o.frames) == 0 {
+			o.cond.Wait()
+		}
+		if o.closed {
+			o.mu.Unlock()
+			return
+		}
+		var msg []byte
+		kind := websocket.TextMessage
+		if len(o.queue) > 0 {
+			msg = o.queue[0]
+			o.queue[0] = nil
+			o.queue = o.queue[1:]
+			o.bytes -= len(msg)
+			o.noticeLocked()
+		} else {
+			msg, kind = o.frames[0], websocket.BinaryMessage
+			o.frames[0] = nil
+			o.frames = o.frames[1:]
+			o.frameBytes -= len(msg)
+		}
+		// Wake blocked pushes
+		o.cond.Broadcast()
+		o.mu.Unlock()
+
+		o.ws.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+		if err := o.ws.WriteMessage(kind, msg); err != nil {
+			o.close()
+			gone()
+			return
+		}
+	}
+}
+
+// close stops the outbox, releasing blocked pushes; queued messages are
+// discarded.
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	o.queue = nil
+	o.bytes = 0
+	o.frames = nil
+	o.frameBytes = 0
+	o.cond.Broadcast()
+}
+
+// pushArtifact streams msg, an artifact event too large for one message,
+// as binary frames if the client takes them: an "artifact" notification
+// without the data but with its SHA-256, the stream ID and the number of
+// frames, then the frames. It waits while the frames of earlier artifacts
+// fill the outbox, and reports false if msg is not streamed.
+func (o *outbox) pushArtifact(msg []byte) bool {
+	if !o.binary {
+		return false
+	}
+	var n struct {
+		Method string         `json:"method"`
+		Params map[string]any `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &n); err != nil || n.Method != "artifact" {
+		return false
+	}
+	encoded, _ := n.Params["data_base64"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || encoded == "" {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for !o.closed && o.frameBytes > 0 && o.frameBytes+len(data) > outboxBytes {
+		o.cond.Wait()
+	}
+	if o.closed {
+		return true
+	}
+	o.streams++
+	stream := o.streams
+	payload := sessionMaxMessage - artifactFrameHeader
+	count := (len(data) + payload - 1) / payload
+
+	sum := sha256.Sum256(data)
+	delete(n.Params, "data_base64")
+	n.Params["size"] = len(data)
+	n.Params["sha256"] = hex.EncodeToString(sum[:])
+	n.Params["stream"] = stream
+	n.Params["frames"] = count
+	header, _ := json.Marshal(proto.NewNotification("artifact", n.Params))
+	o.enqueueLocked(header)
+
+	for i := range count {
+		chunk := data[i*payload : min((i+1)*payload, len(data))]
+		frame := make([]byte, artifactFrameHeader, artifactFrameHeader+len(chunk))
+		binary.BigEndian.PutUint32(frame[0:], stream)
+		binary.BigEndian.PutUint32(frame[4:], uint32(i))
+		binary.BigEndian.PutUint32(frame[8:], crc32.ChecksumIEEE(chunk))
+		frame = append(frame, chunk...)
+		o.frames = append(o.frames, frame)
+		o.frameBytes += len(frame)
+	}
+	o.cond.Broadcast()
+	return true
+}
+
+// flowNotice is a "flow" notification: output waits (paused), flows again
+// (resumed) or was dropped, with the number of messages and bytes lost.
+func flowNotice(state string, messages, size int) []byte {
+	params := map[string]any{"state": state}
+	if state == flowDropped {
+		params["messages"] = messages
+		params["bytes"] = size
+	}
+	msg, _ := json.Marshal(proto.NewNotification("flow", params))
+	return msg
+}
+
+// outputMessage reports whether msg is process output, the only kind of
+// agent message that may be dropped.
+func outputMessage(msg []byte) bool {
+	var n struct {
+		Method string `json:"method"`
+	}
+	json.Unmarshal(msg, &n)
+	return n.Method == "stdout" || n.Method == "stderr"
+}
+
+// splitMessage splits an agent message longer than sessionMaxMessage:
+// output into several notifications of the same stream, each under the
+// limit. It returns nil for other messages that are too long.
+func splitMessage(msg []byte) [][]byte {
+	if len(msg) <= sessionMaxMessage {
+		return [][]byte{msg}
+	}
+	var n struct {
+		Method string `json:"method"`
+		Params struct {
+			Chunk string `json:"chunk"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &n); err != nil || (n.Method != "stdout" && n.Method != "stderr") {
+		return nil
+	}
+	var parts [][]byte
+	chunk := n.Params.Chunk
+	for chunk != "" {
+		// Escaping can make the message much longer than its chunk
+		end := min(sessionMaxMessage-64, len(chunk))
+		for {
+			for end < len(chunk) && end > 1 && !utf8.RuneStart(chunk[end]) {
+				end--
+			}
+			part, _ := json.Marshal(proto.NewNotification(n.Method, map[string]any{"chunk": chunk[:end]}))
+			if len(part) <= sessionMaxMessage || end <= 1 {
+				parts = append(parts, part)
+				break
+			}
+			end /= 2
+		}
+		chunk = chunk[end:]
+	}
+	return parts
+}
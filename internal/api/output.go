@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultMaxOutput is the number of bytes of stdout and of stderr captured
+// per exec when no limit is configured.
+const DefaultMaxOutput = 1024 * 1024
+
+// MaxSpillBytes is the most of each stream spilled to the host per exec;
+// past it the spill file is cut too, so one noisy exec cannot fill the disk.
+const MaxSpillBytes = 256 * 1024 * 1024
+
+// spillDir is where full outputs are written inside the sandbox.
+const spillDir = outputDir + "/.boxed"
+
+// cappedOutput accumulates a stream up to max bytes and counts the rest.
+// If spilling is enabled the full stream is also copied to a temp file on
+// the host, so memory stays bounded while nothing is lost.
+type cappedOutput struct {
+	buf       strings.Builder
+	max       int
+	total     int64
+	truncated bool
+
+	spill    *os.File
+	spillErr error
+
+	// spilled counts the bytes spilled so far, and spillCut is set once
+	// the stream outgrew MaxSpillBytes
+	spilled  int64
+	spillCut bool
+
+	// onWrite is called with every write, e.g. to stream it
+	onWrite func(string)
+}
+
+func newCappedOutput(max int, spill bool) *cappedOutput {
+	o := &cappedOutput{max: max}
+	if spill {
+		o.spill, o.spillErr = os.CreateTemp("", "boxed-spill-")
+	}
+	return o
+}
+
+func (o *cappedOutput) WriteString(s string) {
+	o.total += int64(len(s))
+	switch room := o.max - o.buf.Len(); {
+	case o.truncated:
+		// Nothing more is kept once cut, or the capture could resume
+		// after a cut moved back to a rune start
+	case room < len(s):
+		o.truncated = true
+		o.buf.WriteString(s[:state.RuneCut(s, room)])
+	default:
+		o.buf.WriteString(s)
+	}
+	if o.spill != nil && o.spillErr == nil && !o.spillCut {
+		chunk := s
+		if room := MaxSpillBytes - o.spilled; room < int64(len(chunk)) {
+			o.spillCut = true
+			chunk = chunk[:state.RuneCut(chunk, int(room))]
+		}
+		var n int
+		n, o.spillErr = o.spill.WriteString(chunk)
+		o.spilled += int64(n)
+	}
+	if o.onWrite != nil {
+		o.onWrite(s)
+	}
+}
+
+func (o *cappedOutput) String() string {
+	return o.buf.String()
+}
+
+// close removes the spill file.
+func (o *cappedOutput) close() {
+	if o.spill != nil {
+		o.spill.Close()
+		os.Remove(o.spill.Name())
+	}
+}
+
+// spillTo copies the full output into the sandbox at path and returns the
+// artifact describing it. It returns nil if the output was not truncated.
+func (h *Handler) spillTo(ctx context.Context, id string, o *cappedOutput, path string) *proto.ArtifactEvent {
+	if !o.truncated || o.spill == nil {
+		return nil
+	}
+	if o.spillErr == nil {
+		_, o.spillErr = o.spill.Seek(0, 0)
+	}
+	if o.spillErr == nil {
+		o.spillErr = h.driver.PutFile(ctx, id, path, o.spill)
+	}
+	if o.spillCut {
+		log.Warn().Str("id", id).Str("path", path).Int64("bytes", o.total).Msg("Exec output exceeded the spill limit")
+	}
+	if o.spillErr != nil {
+		log.Warn().Err(o.spillErr).Str("id", id).Str("path", path).Msg("Failed to spill exec output")
+		return nil
+	}
+	return &proto.ArtifactEvent{
+		Path: path,
+		MIME: "text/plain",
+		URL:  fileURL(id, path),
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver/shortid"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata keys recording who created a sandbox. Callers cannot set them:
+// they come from the bearer token.
+const (
+	OwnerLabel = "boxed.owner"
+	OrgLabel   = "boxed.org"
+)
+
+// APIKey is one of several keys a server accepts; see WithAPIKeys.
+type APIKey struct {
+	// Name identifies the holder of the key; the sandboxes it creates are
+	// owned by "key:<name>"
+	Name string `yaml:"name"`
+
+	Key string `yaml:"key"`
+
+	// Admin grants the key auth.ScopeAdmin: it sees and manages every
+	// sandbox, not only those it created
+	Admin bool `yaml:"admin"`
+
+	// Priority is the class of ExecScheduling the execs of the key are
+	// scheduled in; empty means the default class
+	Priority string `yaml:"priority"`
+}
+
+// claims returns the identity of callers with the key.
+func (k APIKey) claims() *auth.Claims {
+	c := &auth.Claims{Subject: "key:" + k.Name}
+	if k.Admin {
+		c.Scopes = []string{auth.ScopeAdmin}
+	}
+	return c
+}
+
+// LoadAPIKeys reads the API keys of a YAML file:
+//
+//	keys:
+//	  - name: ci
+//	    key: 3f9c...
+//	  - name: ops
+//	    key: 8a1d...
+//	    admin: true
+//	  - name: nightly
+//	    key: 5b07...
+//	    priority: batch
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Keys []APIKey `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := CheckAPIKeys(file.Keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Keys, nil
+}
+
+// CheckAPIKeys reports keys without a name or value, and names or values
+// used twice.
+func CheckAPIKeys(keys []APIKey) error {
+	names := make(map[string]bool)
+	values := make(map[string]bool)
+	for i, k := range keys {
+		switch {
+		case k.Name == "":
+			return fmt.Errorf("key %d has no name", i+1)
+		case k.Key == "":
+			return fmt.Errorf("key %s is empty", k.Name)
+		case names[k.Name]:
+			return fmt.Errorf("key name %s is used twice", k.Name)
+		case values[k.Key]:
+			return fmt.Errorf("key %s is the same as another key", k.Name)
+		}
+		names[k.Name], values[k.Key] = true, true
+	}
+	return nil
+}
+
+// TokenVerifier checks bearer tokens; *auth.OIDCVerifier implements it.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.Claims, error)
+}
+
+// ownerLabels adds the caller's identity to the metadata of a new sandbox.
+func ownerLabels(ctx context.Context, metadata map[string]string) (map[string]string, error) {
+	for k := range metadata {
+		if k == OwnerLabel || k == OrgLabel || k == GroupLabel || k == shortid.Label {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "metadata key is reserved: "+k)
+		}
+	}
+	claims := auth.FromContext(ctx)
+	if claims == nil {
+		return metadata, nil
+	}
+
+	labels := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		labels[k] = v
+	}
+	labels[OwnerLabel] = claims.Subject
+	if claims.Org != "" {
+		labels[OrgLabel] = claims.Org
+	}
+	return labels, nil
+}
+
+// audit logs msg about sandbox id with the identity of the caller.
+func audit(ctx context.Context, id, msg string) {
+	ev := log.Info().Str("id", id)
+	if claims := auth.FromContext(ctx); claims != nil {
+		ev = ev.Str("owner", claims.Subject).Str("org", claims.Org)
+	}
+	ev.Msg(msg)
+}
+
+// isAdmin reports whether the caller may use every sandbox: it has the
+// admin scope, or the API key of NewHandler, or the server has no
+// authentication.
+func isAdmin(ctx context.Context) bool {
+	claims := auth.FromContext(ctx)
+	return claims == nil || claims.HasScope(auth.ScopeAdmin)
+}
+
+// owns reports whether the caller may use a sandbox with labels.
+func owns(ctx context.Context, labels map[string]string) bool {
+	return isAdmin(ctx) || labels[OwnerLabel] == auth.FromContext(ctx).Subject
+}
+
+// ownerFilter narrows the selectors of a list or subscription to the
+// sandboxes of the caller.
+func ownerFilter(ctx context.Context, labels []string) []string {
+	if isAdmin(ctx) {
+		return labels
+	}
+	return append(labels[:len(labels):len(labels)], OwnerLabel+"="+auth.FromContext(ctx).Subject)
+}
+
+// checkOwner fails with sandbox_not_found, so as not to reveal that the
+// sandbox exists, unless the caller may use sandbox id. Sandboxes that do
+// not exist are left for the caller to report.
+func (h *Handler) checkOwner(ctx context.Context, id string) error {
+	if isAdmin(ctx) {
+		return nil
+	}
+	var labels map[string]string
+	if rec, err := h.store.GetSandbox(ctx, id); err == nil {
+		labels = rec.Labels
+	} else if info, err := h.driver.Info(ctx, id); err == nil {
+		labels = info.Config.Labels
+	} else {
+		return nil
+	}
+	if !owns(ctx, labels) {
+		return errSandboxNotFound
+	}
+	return nil
+}
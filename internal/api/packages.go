@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PackagesLabel marks images holding installed packages; its value is the
+// digest of the base image and package set.
+const PackagesLabel = "boxed.packages"
+
+// Limits on package installation.
+const (
+	// packageSetupTimeout bounds the installation of a package set
+	packageSetupTimeout = 10 * time.Minute
+
+	// maxPackages bounds the packages of one create, all managers together
+	maxPackages = 200
+
+	// setupOutputBytes caps the stdout and stderr kept per setup step
+	setupOutputBytes = 64 * 1024
+)
+
+// npmPath is set as NODE_PATH when npm packages are installed: they are
+// installed globally, where require() does not look by default. It covers
+// the global roots of the official node images and of Debian's nodejs.
+const npmPath = "/usr/local/lib/node_modules:/usr/lib/node_modules"
+
+// packageSpec accepts pip requirement and npm package specifiers, and
+// Debian package names, but no whitespace, quotes or shell syntax. A
+// leading '-' would be taken for an option.
+var packageSpec = regexp.MustCompile(`^[A-Za-z0-9@._~^=<>!+/:\[\],*][A-Za-z0-9@._~^=<>!+/:\[\],*-]{0,199}$`)
+
+// Packages are installed into a sandbox before it is marked ready.
+type Packages struct {
+	Pip []string `json:"pip,omitempty"`
+	Npm []string `json:"npm,omitempty"`
+	Apt []string `json:"apt,omitempty"`
+}
+
+func (p *Packages) empty() bool {
+	return p == nil || len(p.Pip)+len(p.Npm)+len(p.Apt) == 0
+}
+
+func (p *Packages) validate() error {
+	if len(p.Pip)+len(p.Npm)+len(p.Apt) > maxPackages {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("at most %d packages can be installed", maxPackages))
+	}
+	for _, list := range [][]string{p.Pip, p.Npm, p.Apt} {
+		for _, spec := range list {
+			if !packageSpec.MatchString(spec) {
+				return newAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid package %q", spec))
+			}
+		}
+	}
+	return nil
+}
+
+// digest identifies the image resulting from installing p on image for
+// platform. The order of the packages of a manager does not matter.
+func (p *Packages) digest(image, platform string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "image %s\n", image)
+	if platform != "" {
+		fmt.Fprintf(h, "platform %s\n", platform)
+	}
+	for _, m := range []struct {
+		name string
+		list []string
+	}{{"apt", p.Apt}, {"pip", p.Pip}, {"npm", p.Npm}} {
+		list := slices.Clone(m.list)
+		slices.Sort(list)
+		for _, spec := range slices.Compact(list) {
+			fmt.Fprintf(h, "%s %s\n", m.name, spec)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// steps returns the commands installing p: apt first, since pip and npm
+// packages may build against system libraries.
+func (p *Packages) steps() []SetupStep {
+	var steps []SetupStep
+	if len(p.Apt) > 0 {
+		steps = append(steps, SetupStep{Manager: "apt", Command: "apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq --no-install-recommends " +
+			shellJoin(p.Apt) + " && rm -rf /var/lib/apt/lists/*"})
+	}
+	if len(p.Pip) > 0 {
+		steps = append(steps, SetupStep{Manager: "pip", Command: "python3 -m pip install --no-cache-dir --disable-pip-version-check -q " + shellJoin(p.Pip)})
+	}
+	if len(p.Npm) > 0 {
+		steps = append(steps, SetupStep{Manager: "npm", Command: "npm install --global --no-fund --no-audit --silent " + shellJoin(p.Npm)})
+	}
+	return steps
+}
+
+// shellJoin quotes words for bash.
+func shellJoin(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = "'" + strings.ReplaceAll(w, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// packagesImage names the image of image with packages of the given digest
+// installed. It is tagged in the repository of image, so that driver routes
+// matching the base image match it too.
+func packagesImage(image, digest string) string {
+	repo, tag := image, "latest"
+	if at := strings.Index(repo, "@"); at >= 0 {
+		repo, tag = repo[:at], "digest"
+	}
+	if colon := strings.LastIndex(repo, ":"); colon > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:colon], repo[colon+1:]
+	}
+	return fmt.Sprintf("%s:%s-pkgs-%s", repo, tag, digest[:16])
+}
+
+// SetupStep is a command run while creating a sandbox, with its output.
+type SetupStep struct {
+	// Manager is the package manager: apt, pip or npm
+	Manager string `json:"manager"`
+	// Command is the shell command that was run
+	Command  string `json:"command"`
+	ExitCode *int   `json:"exit_code"`
+	// Stdout and Stderr are capped at 64 KiB each
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// SetupResult reports the package installation of a create.
+type SetupResult struct {
+	// Image is the image the packages are installed in, which sandboxes
+	// with the same base image and packages start from
+	Image string `json:"image"`
+	// Cached is true if the image existed, so nothing was installed
+	Cached bool `json:"cached"`
+	// Steps are the installation commands run, if any
+	Steps []SetupStep `json:"steps,omitempty"`
+}
+
+// packageBuild is an installation in progress; creates asking for the same
+// image wait for it rather than installing the packages again.
+type packageBuild struct {
+	done   chan struct{}
+	result *SetupResult
+	err    error
+}
+
+// packageBuilds deduplicates concurrent installations by image.
+type packageBuilds struct {
+	mu     sync.Mutex
+	builds map[string]*packageBuild
+}
+
+func newPackageBuilds() *packageBuilds {
+	return &packageBuilds{builds: make(map[string]*packageBuild)}
+}
+
+// setupError is returned when a package installation step fails; the
+// message carries the end of its output.
+func setupError(step SetupStep) *APIError {
+	return newAPIError(http.StatusUnprocessableEntity, CodeSetupFailed,
+		fmt.Sprintf("installing %s packages failed (%s): %s", step.Manager, exitSummary(step.ExitCode), outputTail(step.Stdout, step.Stderr)))
+}
+
+// exitSummary describes the exit code of a setup command.
+func exitSummary(code *int) string {
+	if code == nil {
+		return "no exit code"
+	}
+	return fmt.Sprintf("exit code %d", *code)
+}
+
+// outputTail is the end of what a setup command printed, stderr if it
+// printed any.
+func outputTail(stdout, stderr string) string {
+	out := strings.TrimSpace(stderr)
+	if out == "" {
+		out = strings.TrimSpace(stdout)
+	}
+	if len(out) > 2048 {
+		out = "..." + out[len(out)-2048:]
+	}
+	return out
+}
+
+// preparePackages returns the image with pkgs installed on cfg.Image,
+// installing them in a throwaway sandbox and saving the result unless the
+// image exists already. The sandbox has internet access, whatever the
+// network policy of the sandbox being created.
+func (h *Handler) preparePackages(ctx context.Context, cfg driver.SandboxConfig, pkgs *Packages) (*SetupResult, error) {
+	sn, ok := h.ids.Backend().(driver.Snapshotter)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support packages")
+	}
+	digest := pkgs.digest(cfg.Image, cfg.Platform)
+	ref := packagesImage(cfg.Image, digest)
+
+	h.packages.mu.Lock()
+	if b, ok := h.packages.builds[ref]; ok {
+		h.packages.mu.Unlock()
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return nil, wrapAPIError(http.StatusRequestTimeout, CodeTimedOut, "timed out waiting for packages", driver.ErrTimeout)
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+		return &SetupResult{Image: ref, Cached: true}, nil
+	}
+	b := &packageBuild{done: make(chan struct{})}
+	h.packages.builds[ref] = b
+	h.packages.mu.Unlock()
+	defer func() {
+		h.packages.mu.Lock()
+		delete(h.packages.builds, ref)
+		h.packages.mu.Unlock()
+		close(b.done)
+	}()
+
+	exists, err := sn.HasImage(ctx, ref)
+	if err != nil {
+		b.err = driverError(err)
+		return nil, b.err
+	}
+	if exists {
+		b.result = &SetupResult{Image: ref, Cached: true}
+		return b.result, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "packages.install", attribute.String("boxed.image", ref))
+	b.result, b.err = h.installPackages(ctx, sn, cfg, pkgs, ref, digest)
+	tracing.End(span, b.err)
+	return b.result, b.err
+}
+
+// installPackages installs pkgs in a sandbox created for the purpose and
+// saves its filesystem as ref.
+func (h *Handler) installPackages(ctx context.Context, sn driver.Snapshotter, cfg driver.SandboxConfig, pkgs *Packages, ref, digest string) (*SetupResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, packageSetupTimeout)
+	defer cancel()
+
+	id, err := h.driver.Create(ctx, driver.SandboxConfig{
+		Image:            cfg.Image,
+		MemoryMB:         1024,
+		CPUCores:         cfg.CPUCores,
+		Timeout:          packageSetupTimeout + time.Minute,
+		EnableNetworking: true,
+		NetworkPolicy:    driver.NetworkPolicy{EnableInternet: true},
+		Driver:           cfg.Driver,
+		Platform:         cfg.Platform,
+		Labels:           map[string]string{PackagesLabel: digest},
+	})
+	if err != nil {
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to create sandbox for packages: %v", err)
+		return nil, apiErr
+	}
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := h.driver.Stop(stopCtx, id); err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("Failed to remove package sandbox")
+		}
+	}()
+	if err := h.driver.Start(ctx, id); err != nil {
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to start sandbox for packages: %v", err)
+		return nil, apiErr
+	}
+
+	result := &SetupResult{Image: ref}
+	for _, step := range pkgs.steps() {
+		started := time.Now()
+		stdout := newCappedOutput(setupOutputBytes, false)
+		stderr := newCappedOutput(setupOutputBytes, false)
+		params := map[string]any{"cmd": "bash", "args": []string{"-c", step.Command}, "user": "0"}
+		_, exit, apiErr := h.agentExec(ctx, id, params, "", nil, stdout, stderr, attribute.String("boxed.setup", step.Manager))
+		if apiErr != nil {
+			return result, apiErr
+		}
+		step.ExitCode = exit.code
+		step.Stdout = stdout.String()
+		step.Stderr = stderr.String()
+		step.DurationMS = time.Since(started).Milliseconds()
+		result.Steps = append(result.Steps, step)
+		if exit.code == nil || *exit.code != 0 {
+			return result, setupError(step)
+		}
+	}
+
+	if err := sn.Snapshot(ctx, id, ref, map[string]string{PackagesLabel: digest}); err != nil {
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to save packages: %v", err)
+		return result, apiErr
+	}
+	log.Info().Str("image", ref).Msg("Packages installed")
+	return result, nil
+}
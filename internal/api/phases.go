@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+)
+
+// enterPhase records that the sandbox of rec entered phase, saving rec.
+func (h *Handler) enterPhase(rec *state.SandboxRecord, phase driver.SandboxPhase) {
+	// Clipped: the store may share the slice with other copies of rec
+	rec.Phases = append(slices.Clip(rec.Phases), state.PhaseChange{Phase: string(phase), At: time.Now()})
+	h.store.PutSandbox(context.Background(), *rec)
+}
+
+// setPhases fills in the lifecycle phases of info from rec.
+func setPhases(info *driver.SandboxInfo, rec state.SandboxRecord) {
+	if len(rec.Phases) == 0 {
+		return
+	}
+	info.Phases = make([]driver.PhaseTransition, len(rec.Phases))
+	for i, p := range rec.Phases {
+		info.Phases[i] = driver.PhaseTransition{Phase: driver.SandboxPhase(p.Phase), At: p.At}
+	}
+	info.Phase = info.Phases[len(info.Phases)-1].Phase
+}
+
+// agentConnectedKey holds the function agentExec calls once it reached the
+// agent, which tells waiting for the agent apart from running the exec.
+type agentConnectedKey struct{}
+
+// onAgentConnected returns ctx making agentExec call fn once connected.
+func onAgentConnected(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, agentConnectedKey{}, fn)
+}
+
+// agentConnected calls the function onAgentConnected put in ctx, if any.
+func agentConnected(ctx context.Context) {
+	if fn, ok := ctx.Value(agentConnectedKey{}).(func()); ok {
+		fn()
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import "encoding/json"
+
+// LanguagePythonSession runs Python in an interpreter kept per sandbox, so
+// successive execs share variables and imports like notebook cells do.
+const LanguagePythonSession = "python-session"
+
+// pythonKernelScript is run with python3 -u -c. It reads one JSON request
+// per line, {"code": ...}, runs the code in a namespace kept across
+// requests, and writes the output and exit code as marker lines. The value of a
+// trailing expression is printed, like in a notebook.
+const pythonKernelScript = `
+import ast, io, json, linecache, sys, traceback
+
+MARKER = "\x1eboxed "
+_stdin, _stdout = sys.stdin, sys.stdout
+
+def _send(**frame):
+    _stdout.write(MARKER + json.dumps(frame) + "\n")
+    _stdout.flush()
+
+class _Stream(io.TextIOBase):
+    def __init__(self, name):
+        self.name = name
+    def writable(self):
+        return True
+    def write(self, s):
+        if s:
+            _send(**{self.name: s})
+        return len(s)
+
+def _run(ns, code, filename):
+    linecache.cache[filename] = (len(code), None, code.splitlines(True), filename)
+    tree = ast.parse(code, filename, "exec")
+    last = None
+    if tree.body and isinstance(tree.body[-1], ast.Expr):
+        last = ast.Expression(tree.body.pop().value)
+    exec(compile(tree, filename, "exec"), ns)
+    if last is not None:
+        value = eval(compile(last, filename, "eval"), ns)
+        if value is not None:
+            print(repr(value))
+
+def _main():
+    ns = {"__name__": "__main__", "__builtins__": __builtins__}
+    for n, line in enumerate(_stdin, 1):
+        try:
+            code = json.loads(line)["code"]
+        except Exception as e:
+            _send(stderr="invalid request: %s\n" % e, exit=1)
+            continue
+        filename = "<exec %d>" % n
+        sys.stdin = io.StringIO()
+        sys.stdout, sys.stderr = _Stream("stdout"), _Stream("stderr")
+        status = 0
+        try:
+            _run(ns, code, filename)
+        except SystemExit as e:
+            if isinstance(e.code, int):
+                status = e.code
+            elif e.code is not None:
+                print(e.code, file=sys.stderr)
+                status = 1
+        except BaseException as e:
+            tb = e.__traceback__
+            while tb is not None and tb.tb_frame.f_code.co_filename != filename:
+                tb = tb.tb_next
+            traceback.print_exception(type(e), e, tb)
+            status = 1
+        finally:
+            sys.stdout.flush()
+            sys.stdin, sys.stdout, sys.stderr = _stdin, _stdout, sys.__stderr__
+        _send(exit=status)
+
+_main()
+`
+
+// pythonKernel runs the execs of LanguagePythonSession.
+var pythonKernel = &kernel{
+	name:  "python session",
+	noun:  "interpreter",
+	cmd:   "python3",
+	args:  []string{"-u", "-c", pythonKernelScript},
+	input: pythonKernelInput,
+}
+
+// pythonKernelInput is the request line of code.
+func pythonKernelInput(code string) string {
+	data, _ := json.Marshal(map[string]string{"code": code})
+	return string(data) + "\n"
+}
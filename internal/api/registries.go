@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// RegistryRequest is the body of PUT /registries/:registry: a username and
+// password (or token), or the credential helper to ask for them.
+type RegistryRequest struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Helper   string `json:"helper,omitempty"`
+}
+
+// registryAuthenticator returns the driver's registry credentials, which
+// only admins may see and change: they are the server's.
+func (h *Handler) registryAuthenticator(ctx context.Context) (driver.RegistryAuthenticator, error) {
+	if !isAdmin(ctx) {
+		return nil, newAPIError(http.StatusForbidden, CodeUnauthorized, "registry credentials need the admin scope")
+	}
+	ra, ok := h.ids.Backend().(driver.RegistryAuthenticator)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not pull images")
+	}
+	return ra, nil
+}
+
+func (h *Handler) listRegistries(c echo.Context) error {
+	ra, err := h.registryAuthenticator(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"registries": ra.RegistryCredentials()})
+}
+
+// putRegistry serves PUT /registries/:registry.
+func (h *Handler) putRegistry(c echo.Context) error {
+	var req RegistryRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	cred := driver.RegistryCredential{Registry: c.Param("registry"), Username: req.Username, Password: req.Password, Helper: req.Helper}
+	if err := h.SetRegistryCredential(c.Request().Context(), cred); err != nil {
+		return err
+	}
+	cred.Registry = driver.NormalizeRegistry(cred.Registry)
+	cred.Password = ""
+	return c.JSON(http.StatusOK, cred)
+}
+
+// SetRegistryCredential adds or replaces the credential images are pulled
+// from a registry with. It is the transport independent core of
+// PUT /registries/:registry; errors are *APIError.
+func (h *Handler) SetRegistryCredential(ctx context.Context, cred driver.RegistryCredential) error {
+	ra, err := h.registryAuthenticator(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ra.SetRegistryCredential(cred); err != nil {
+		return driverError(err)
+	}
+	audit(ctx, driver.NormalizeRegistry(cred.Registry), "Registry credential set")
+	return nil
+}
+
+func (h *Handler) deleteRegistry(c echo.Context) error {
+	if err := h.DeleteRegistryCredential(c.Request().Context(), c.Param("registry")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteRegistryCredential forgets the credential of a registry; images
+// already pulled with it stay. It is the transport independent core of
+// DELETE /registries/:registry; errors are *APIError.
+func (h *Handler) DeleteRegistryCredential(ctx context.Context, registry string) error {
+	ra, err := h.registryAuthenticator(ctx)
+	if err != nil {
+		return err
+	}
+	if !ra.DeleteRegistryCredential(registry) {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "no credential for registry: "+registry)
+	}
+	audit(ctx, driver.NormalizeRegistry(registry), "Registry credential deleted")
+	return nil
+}
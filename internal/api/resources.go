@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+)
+
+// ResourcesRequest changes the limits of a running sandbox. Fields left
+// zero keep their limit.
+type ResourcesRequest struct {
+	// MemoryMB is the new memory limit in megabytes
+	MemoryMB int64 `json:"memory_mb"`
+
+	// CPUCores is the new CPU limit in fractional cores
+	CPUCores float64 `json:"cpu_cores"`
+}
+
+// ResourcesResponse reports the limits a sandbox has after a change.
+type ResourcesResponse struct {
+	SandboxID string  `json:"sandbox_id"`
+	MemoryMB  int64   `json:"memory_mb"`
+	CPUCores  float64 `json:"cpu_cores"`
+}
+
+func (h *Handler) updateResources(c echo.Context) error {
+	var req ResourcesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.UpdateResources(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// UpdateResources raises or lowers the memory and CPU limits of a running
+// sandbox, e.g. for a heavy step of an agent, without recreating it. It is
+// the transport independent core of PATCH /sandbox/:id/resources; errors
+// are *APIError.
+func (h *Handler) UpdateResources(ctx context.Context, id string, req ResourcesRequest) (*ResourcesResponse, error) {
+	ru, ok := h.driver.(driver.ResourceUpdater)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support changing limits")
+	}
+	update := driver.ResourceUpdate{MemoryMB: req.MemoryMB, CPUCores: req.CPUCores}
+	if err := update.Validate(); err != nil {
+		return nil, driverError(err)
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	err = ru.UpdateResources(ctx, id, update)
+	if err != nil {
+		h.recordEvent(id, state.EventResized, started, "", err)
+		return nil, driverError(err)
+	}
+	info, err := h.driver.Info(ctx, id)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	detail := fmt.Sprintf("memory %d MB, %g CPUs", info.Config.MemoryMB, info.Config.CPUCores)
+	h.recordEvent(id, state.EventResized, started, detail, nil)
+	h.usageResized(id, started, info.Config)
+	audit(ctx, id, "Resources changed to "+detail)
+
+	return &ResourcesResponse{SandboxID: id, MemoryMB: info.Config.MemoryMB, CPUCores: info.Config.CPUCores}, nil
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+)
+
+// Priority classes of the default exec scheduling.
+const (
+	PriorityInteractive = "interactive"
+	PriorityStandard    = "standard"
+	PriorityBatch       = "batch"
+)
+
+const (
+	// DefaultExecMaxQueue bounds the execs of one caller waiting for a slot
+	DefaultExecMaxQueue = 100
+
+	// DefaultExecMaxWait is how long an exec waits for a slot
+	DefaultExecMaxWait = 30 * time.Second
+)
+
+// DefaultPriorityClasses are the classes of ExecScheduling that sets none:
+// callers of interactive keys get 8 slots for every one of batch keys when
+// both wait.
+var DefaultPriorityClasses = map[string]int{
+	PriorityInteractive: 8,
+	PriorityStandard:    4,
+	PriorityBatch:       1,
+}
+
+var (
+	execQueueDepth = metrics.Default.Gauge("boxed_exec_queue_depth",
+		"Execs waiting for an exec slot.", "class")
+	execRejectedTotal = metrics.Default.Counter("boxed_exec_rejected_total",
+		"Execs refused because their caller's queue was full or they waited too long.", "class")
+)
+
+// ExecScheduling shares the exec slots of a server between callers, so that
+// the execs of one cannot starve those of others; see WithExecScheduling.
+type ExecScheduling struct {
+	// Slots is how many execs run at once across the server; 0 runs every
+	// exec as it comes
+	Slots int
+
+	// SandboxSlots is how many execs run at once in one sandbox, later
+	// ones waiting for them in order or failing with 409 as they ask; 0
+	// means no limit. Execs wait for their sandbox before a server slot.
+	SandboxSlots int
+
+	// MaxQueue bounds the execs of one caller waiting for a slot; zero
+	// means DefaultExecMaxQueue
+	MaxQueue int
+
+	// MaxWait is how long an exec waits for a slot before it fails with
+	// 429, or for its sandbox before it fails with 409; zero means
+	// DefaultExecMaxWait. Jobs wait as long as they need.
+	MaxWait time.Duration
+
+	// Classes weigh the share of the slots each caller of a class gets
+	// while others wait, by class name; empty means
+	// DefaultPriorityClasses. APIKey.Priority names the class of a key;
+	// other callers are in DefaultClass.
+	Classes map[string]int
+
+	// DefaultClass is the class of callers whose key names none, and of
+	// callers without keys; empty means PriorityStandard
+	DefaultClass string
+}
+
+// withDefaults fills in the settings left unset.
+func (s ExecScheduling) withDefaults() ExecScheduling {
+	if s.MaxQueue == 0 {
+		s.MaxQueue = DefaultExecMaxQueue
+	}
+	if s.MaxWait == 0 {
+		s.MaxWait = DefaultExecMaxWait
+	}
+	if len(s.Classes) == 0 {
+		s.Classes = DefaultPriorityClasses
+	}
+	if s.DefaultClass == "" {
+		s.DefaultClass = PriorityStandard
+	}
+	return s
+}
+
+// WithExecScheduling runs at most s.Slots execs at once, across sandboxes,
+// and hands out the slots of execs that have to wait fairly between
+// callers, weighted by their priority class.
+func WithExecScheduling(s ExecScheduling) Option {
+	return func(h *Handler) {
+		h.scheduling = s
+	}
+}
+
+// CheckExecScheduling reports negative settings, classes without a
+// positive weight, and keys or a default naming a class that is not
+// defined.
+func CheckExecScheduling(s ExecScheduling, keys []APIKey) error {
+	if s.Slots < 0 || s.SandboxSlots < 0 || s.MaxQueue < 0 || s.MaxWait < 0 {
+		return fmt.Errorf("slots, sandbox_slots, max_queue and max_wait cannot be negative")
+	}
+	s = s.withDefaults()
+	for name, weight := range s.Classes {
+		if weight <= 0 {
+			return fmt.Errorf("class %s needs a positive weight", name)
+		}
+	}
+	if _, ok := s.Classes[s.DefaultClass]; !ok {
+		return fmt.Errorf("default class %s is not defined", s.DefaultClass)
+	}
+	for _, k := range keys {
+		if _, ok := s.Classes[k.Priority]; k.Priority != "" && !ok {
+			return fmt.Errorf("key %s: priority class %s is not defined", k.Name, k.Priority)
+		}
+	}
+	return nil
+}
+
+// queueError is the error of an exec that found no slot: its caller's queue
+// was full, or it waited MaxWait.
+func queueError(class string, position int, retryAfter time.Duration, msg string) *APIError {
+	execRejectedTotal.Inc(class)
+	err := newAPIError(http.StatusTooManyRequests, CodeQuotaExceeded, msg)
+	err.QueuePosition = position
+	err.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	return err
+}
+
+// execScheduler hands out exec slots by start-time fair queuing: each
+// caller waiting is a flow whose execs are served in order, and the next
+// slot goes to the flow whose next exec would finish first in virtual
+// time, each exec counting for 1/weight of its class.
+type execScheduler struct {
+	cfg ExecScheduling
+
+	// classes are the classes of the callers of keys, by subject
+	classes map[string]string
+
+	mu      sync.Mutex
+	running int
+	// vtime is the start tag of the exec last given a slot
+	vtime float64
+	flows map[string]*execFlow
+	seq   uint64
+	// avgRun is a moving average of how long execs hold a slot, for
+	// estimating how long a queued one will wait
+	avgRun time.Duration
+}
+
+// execFlow holds the waiting execs of one caller.
+type execFlow struct {
+	class  string
+	weight float64
+	// finish is the virtual finish tag of the flow's last exec given a slot
+	finish  float64
+	waiting []*execTicket
+}
+
+// execTicket is an exec waiting for a slot; ready is closed once it has one.
+type execTicket struct {
+	seq   uint64
+	ready chan struct{}
+}
+
+func newExecScheduler(cfg ExecScheduling, keys map[string]APIKey) *execScheduler {
+	cfg = cfg.withDefaults()
+	s := &execScheduler{
+		cfg:     cfg,
+		classes: make(map[string]string),
+		flows:   make(map[string]*execFlow),
+		avgRun:  time.Second,
+	}
+	for _, k := range keys {
+		if k.Priority != "" {
+			s.classes[k.claims().Subject] = k.Priority
+		}
+	}
+	return s
+}
+
+// acquire waits for a slot for an exec of the caller of ctx, and returns
+// the function that gives it back. Unless queued is set, it fails with 429
+// when the caller already has MaxQueue execs waiting or after MaxWait.
+func (s *execScheduler) acquire(ctx context.Context, queued bool) (release func(), err error) {
+	caller := ""
+	if claims := auth.FromContext(ctx); claims != nil {
+		caller = claims.Subject
+	}
+
+	s.mu.Lock()
+	f := s.flow(caller)
+	if len(f.waiting) >= s.cfg.MaxQueue && !queued {
+		position := s.position(nil) + 1
+		wait := s.estimate(position)
+		s.mu.Unlock()
+		return nil, queueError(f.class, position, wait,
+			fmt.Sprintf("%d execs of the caller are already waiting for a slot", len(f.waiting)))
+	}
+	s.seq++
+	t := &execTicket{seq: s.seq, ready: make(chan struct{})}
+	f.waiting = append(f.waiting, t)
+	execQueueDepth.Add(1, f.class)
+	s.dispatch()
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !queued {
+		timer := time.NewTimer(s.cfg.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-t.ready:
+		return s.releaser(), nil
+	case <-ctx.Done():
+	case <-timeout:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-t.ready:
+		// Given a slot as it gave up
+		if ctx.Err() == nil {
+			return s.releaser(), nil
+		}
+		s.running--
+		s.dispatch()
+		return nil, contextError(ctx)
+	default:
+	}
+	position := s.position(t)
+	f.waiting = slices.DeleteFunc(f.waiting, func(w *execTicket) bool { return w == t })
+	execQueueDepth.Add(-1, f.class)
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
+	return nil, queueError(f.class, position, s.estimate(position),
+		fmt.Sprintf("no exec slot within %s; %d execs were ahead", s.cfg.MaxWait, position-1))
+}
+
+// releaser returns the function giving back a slot taken now.
+func (s *execScheduler) releaser() func() {
+	started := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.avgRun = (s.avgRun*7 + time.Since(started)) / 8
+			s.running--
+			s.dispatch()
+		})
+	}
+}
+
+// flow returns the flow of caller, adding it if it has none. Callers must
+// hold s.mu.
+func (s *execScheduler) flow(caller string) *execFlow {
+	if f, ok := s.flows[caller]; ok {
+		return f
+	}
+	class, ok := s.classes[caller]
+	if !ok {
+		class = s.cfg.DefaultClass
+	}
+	f := &execFlow{class: class, weight: float64(s.cfg.Classes[class]), finish: s.vtime}
+	s.flows[caller] = f
+	return f
+}
+
+// dispatch hands the free slots to waiting execs, then forgets the flows
+// with nothing waiting whose finish tag has passed: an idle caller saves
+// no credit. Callers must hold s.mu.
+func (s *execScheduler) dispatch() {
+	for s.running < s.cfg.Slots {
+		f, start := nextFlow(s.flows, s.vtime)
+		if f == nil {
+			break
+		}
+		t := f.waiting[0]
+		f.waiting = f.waiting[1:]
+		f.finish = start + 1/f.weight
+		s.vtime = start
+		s.running++
+		execQueueDepth.Add(-1, f.class)
+		close(t.ready)
+	}
+	for caller, f := range s.flows {
+		if len(f.waiting) == 0 && f.finish <= s.vtime {
+			delete(s.flows, caller)
+		}
+	}
+}
+
+// nextFlow picks, among flows, the one whose next exec finishes first in
+// virtual time, earliest arrival breaking ties, and returns the start tag
+// of that exec.
+func nextFlow(flows map[string]*execFlow, vtime float64) (*execFlow, float64) {
+	var best *execFlow
+	var bestStart, bestFinish float64
+	for _, f := range flows {
+		if len(f.waiting) == 0 {
+			continue
+		}
+		start := max(f.finish, vtime)
+		finish := start + 1/f.weight
+		if best == nil || finish < bestFinish || (finish == bestFinish && f.waiting[0].seq < best.waiting[0].seq) {
+			best, bestStart, bestFinish = f, start, finish
+		}
+	}
+	return best, bestStart
+}
+
+// position returns the place of t among the waiting execs, from 1, by
+// replaying the order dispatch would give them slots in; with t nil, the
+// number of execs waiting. Callers must hold s.mu.
+func (s *execScheduler) position(t *execTicket) int {
+	flows := make(map[string]*execFlow, len(s.flows))
+	total := 0
+	for caller, f := range s.flows {
+		c := *f
+		flows[caller] = &c
+		total += len(f.waiting)
+	}
+	if t == nil {
+		return total
+	}
+	vtime := s.vtime
+	for n := 1; ; n++ {
+		f, start := nextFlow(flows, vtime)
+		if f == nil {
+			return total
+		}
+		if f.waiting[0] == t {
+			return n
+		}
+		f.waiting = f.waiting[1:]
+		f.finish = start + 1/f.weight
+		vtime = start
+	}
+}
+
+// estimate guesses how long the exec at position will wait for a slot.
+func (s *execScheduler) estimate(position int) time.Duration {
+	wait := s.avgRun * time.Duration(position) / time.Duration(s.cfg.Slots)
+	return max(wait, time.Second)
+}
+
+// queuedKey marks the context of a job's exec, which already waited in the
+// sandbox's queue and waits for a slot as long as it takes.
+type queuedKey struct{}
+
+// withQueuedExec marks ctx as that of a job's exec.
+func withQueuedExec(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queuedKey{}, true)
+}
+
+// queuedExec reports whether ctx is that of a job's exec.
+func queuedExec(ctx context.Context) bool {
+	queued, _ := ctx.Value(queuedKey{}).(bool)
+	return queued
+}
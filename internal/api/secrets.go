@@ -0,0 +1,406 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// secretMask replaces secret values in captured output.
+const secretMask = "***"
+
+// secretNamePattern is what secret names, and the variables they are
+// injected as, look like: environment variable names.
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// SecretRequest is the body of POST /secrets and PUT /secrets/:name.
+type SecretRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SecretInfo describes a secret. Its value is never returned.
+type SecretInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SecretRef injects a secret into a sandbox or an exec: as the environment
+// variable Env, which defaults to the secret's name unless Path is set, as
+// the file at Path, or both.
+type SecretRef struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// resolvedSecret is a SecretRef with the value it injects.
+type resolvedSecret struct {
+	SecretRef
+	value string
+}
+
+// secretKey names a secret of an owner: callers each have secrets of their
+// own, which others cannot see or inject, whatever their names.
+type secretKey struct {
+	owner string
+	name  string
+}
+
+// secretOwner returns whom the secrets of the caller of ctx belong to: the
+// subject of its key or token, or no one without authentication.
+func secretOwner(ctx context.Context) string {
+	if claims := auth.FromContext(ctx); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+type storedSecret struct {
+	value     string
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+// boundSecrets is what was injected into a sandbox: the values to mask in
+// its output, and the variables and files to leave out of its config.
+type boundSecrets struct {
+	values []string
+	env    map[string]bool
+	paths  map[string]bool
+}
+
+// secretRegistry keeps the secrets registered with the server, in memory
+// only, and the secrets each sandbox was given.
+type secretRegistry struct {
+	mu       sync.Mutex
+	secrets  map[secretKey]*storedSecret
+	bindings map[string]*boundSecrets
+}
+
+func newSecretRegistry() *secretRegistry {
+	return &secretRegistry{
+		secrets:  make(map[secretKey]*storedSecret),
+		bindings: make(map[string]*boundSecrets),
+	}
+}
+
+// put stores a secret of owner. Unless replace is set, the name must be
+// new.
+func (r *secretRegistry) put(owner string, req SecretRequest, replace bool) (*SecretInfo, error) {
+	if !secretNamePattern.MatchString(req.Name) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			"secret name must be letters, digits and underscores, not starting with a digit")
+	}
+	if req.Value == "" {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "secret value is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	key := secretKey{owner, req.Name}
+	s, ok := r.secrets[key]
+	if ok && !replace {
+		return nil, newAPIError(http.StatusConflict, CodeConflict, "secret already exists: "+req.Name)
+	}
+	if !ok {
+		s = &storedSecret{createdAt: now}
+		r.secrets[key] = s
+	}
+	s.value = req.Value
+	s.updatedAt = now
+	return s.info(req.Name), nil
+}
+
+func (s *storedSecret) info(name string) *SecretInfo {
+	return &SecretInfo{Name: name, CreatedAt: s.createdAt, UpdatedAt: s.updatedAt}
+}
+
+func (r *secretRegistry) get(owner, name string) (*SecretInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.secrets[secretKey{owner, name}]
+	if !ok {
+		return nil, newAPIError(http.StatusNotFound, CodeNotFound, "secret not found: "+name)
+	}
+	return s.info(name), nil
+}
+
+func (r *secretRegistry) list(owner string) []*SecretInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	infos := []*SecretInfo{}
+	for key, s := range r.secrets {
+		if key.owner == owner {
+			infos = append(infos, s.info(key.name))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (r *secretRegistry) delete(owner, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := secretKey{owner, name}
+	if _, ok := r.secrets[key]; !ok {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "secret not found: "+name)
+	}
+	delete(r.secrets, key)
+	return nil
+}
+
+// resolve checks refs and looks up their values among the secrets of owner.
+func (r *secretRegistry) resolve(owner string, refs []SecretRef) ([]resolvedSecret, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	resolved := make([]resolvedSecret, 0, len(refs))
+	for _, ref := range refs {
+		s, ok := r.secrets[secretKey{owner, ref.Name}]
+		if !ok {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "unknown secret: "+ref.Name)
+		}
+		if ref.Env == "" && ref.Path == "" {
+			ref.Env = ref.Name
+		}
+		if ref.Env != "" && !secretNamePattern.MatchString(ref.Env) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid environment variable name: "+ref.Env)
+		}
+		if ref.Path != "" && !path.IsAbs(ref.Path) {
+			return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "secret path must be absolute: "+ref.Path)
+		}
+		resolved = append(resolved, resolvedSecret{SecretRef: ref, value: s.value})
+	}
+	return resolved, nil
+}
+
+// bind records that secrets were injected into a sandbox. Later bindings
+// add to earlier ones: a file written by an exec outlives it.
+func (r *secretRegistry) bind(sandboxID string, secrets []resolvedSecret) {
+	if len(secrets) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bindings[sandboxID]
+	if b == nil {
+		b = &boundSecrets{env: make(map[string]bool), paths: make(map[string]bool)}
+		r.bindings[sandboxID] = b
+	}
+	for _, s := range secrets {
+		if !slices.Contains(b.values, s.value) {
+			b.values = append(b.values, s.value)
+		}
+		if s.Env != "" {
+			b.env[s.Env] = true
+		}
+		if s.Path != "" {
+			b.paths[s.Path] = true
+		}
+	}
+}
+
+// closeSandbox forgets the secrets of a stopped sandbox.
+func (r *secretRegistry) closeSandbox(sandboxID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.bindings, sandboxID)
+}
+
+// redactor returns a function masking the secrets of a sandbox, in plain
+// text and in JSON-encoded form, or nil if it has none.
+func (r *secretRegistry) redactor(sandboxID string) func(string) string {
+	r.mu.Lock()
+	b := r.bindings[sandboxID]
+	var values []string
+	if b != nil {
+		values = slices.Clone(b.values)
+	}
+	r.mu.Unlock()
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Longest first, so that a secret containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v, secretMask)
+		if escaped := jsonEscape(v); escaped != v {
+			pairs = append(pairs, escaped, secretMask)
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace
+}
+
+// jsonEscape returns s as it appears inside a JSON string.
+func jsonEscape(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Drop the quotes and the newline
+	return string(buf.Bytes()[1 : buf.Len()-2])
+}
+
+// redact masks the secrets of a sandbox in s.
+func (r *secretRegistry) redact(sandboxID, s string) string {
+	if mask := r.redactor(sandboxID); mask != nil {
+		return mask(s)
+	}
+	return s
+}
+
+// scrub removes the secrets of a sandbox from its info, which echoes the
+// config it was created with, and its login to the egress proxy.
+func (r *secretRegistry) scrub(info *driver.SandboxInfo) {
+	info.Config.EgressProxy = nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bindings[info.ID]
+	if b == nil {
+		return
+	}
+	if len(info.Config.Env) > 0 {
+		env := make(map[string]string, len(info.Config.Env))
+		for k, v := range info.Config.Env {
+			if !b.env[k] {
+				env[k] = v
+			}
+		}
+		info.Config.Env = env
+	}
+	var files []driver.FileInjection
+	for _, f := range info.Config.Context {
+		if !b.paths[f.Path] {
+			files = append(files, f)
+		}
+	}
+	info.Config.Context = files
+}
+
+// secretEnv returns the variables secrets are injected as.
+func secretEnv(secrets []resolvedSecret) map[string]string {
+	env := make(map[string]string)
+	for _, s := range secrets {
+		if s.Env != "" {
+			env[s.Env] = s.value
+		}
+	}
+	return env
+}
+
+// secretFiles returns the files secrets are injected as.
+func secretFiles(secrets []resolvedSecret) []driver.FileInjection {
+	var files []driver.FileInjection
+	for _, s := range secrets {
+		if s.Path != "" {
+			files = append(files, driver.FileInjection{
+				Path:          s.Path,
+				ContentBase64: base64.StdEncoding.EncodeToString([]byte(s.value)),
+			})
+		}
+	}
+	return files
+}
+
+// secretsDigest folds the secrets of a sandbox into its context digest, so
+// that sandboxes given other values do not share cached results.
+func secretsDigest(secrets []resolvedSecret, digest string) string {
+	if len(secrets) == 0 {
+		return digest
+	}
+	h := sha256.New()
+	for _, s := range secrets {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", s.Name, s.Env, s.Path, s.value)
+	}
+	h.Write([]byte(digest))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (h *Handler) createSecret(c echo.Context) error {
+	var req SecretRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	info, err := h.secrets.put(secretOwner(c.Request().Context()), req, false)
+	if err != nil {
+		return err
+	}
+	audit(c.Request().Context(), req.Name, "Secret created")
+	return c.JSON(http.StatusCreated, info)
+}
+
+// putSecret serves PUT /secrets/:name.
+func (h *Handler) putSecret(c echo.Context) error {
+	var req SecretRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	req.Name = c.Param("name")
+	info, err := h.SetSecret(c.Request().Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// SetSecret creates a secret or replaces its value; sandboxes keep the
+// value they were given. It is the transport independent core of
+// PUT /secrets/:name; errors are *APIError.
+func (h *Handler) SetSecret(ctx context.Context, req SecretRequest) (*SecretInfo, error) {
+	info, err := h.secrets.put(secretOwner(ctx), req, true)
+	if err != nil {
+		return nil, err
+	}
+	audit(ctx, req.Name, "Secret updated")
+	return info, nil
+}
+
+func (h *Handler) listSecrets(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{"secrets": h.secrets.list(secretOwner(c.Request().Context()))})
+}
+
+func (h *Handler) getSecret(c echo.Context) error {
+	info, err := h.secrets.get(secretOwner(c.Request().Context()), c.Param("name"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *Handler) deleteSecret(c echo.Context) error {
+	if err := h.DeleteSecret(c.Request().Context(), c.Param("name")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteSecret removes a secret. Sandboxes it was injected into keep it,
+// and keep masking it. It is the transport independent core of
+// DELETE /secrets/:name; errors are *APIError.
+func (h *Handler) DeleteSecret(ctx context.Context, name string) error {
+	if err := h.secrets.delete(secretOwner(ctx), name); err != nil {
+		return err
+	}
+	audit(ctx, name, "Secret deleted")
+	return nil
+}
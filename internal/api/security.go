@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+)
+
+// seccompName is the name of a profile in the seccomp directory, without
+// its .json extension.
+var seccompName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// WithSecurity hardens the sandboxes created without "security" with sec,
+// unless their image matches one of the trusted patterns (path.Match
+// syntax, e.g. "boxed-python:*"). See driver.HardenedSecurity.
+func WithSecurity(sec driver.Security, trusted ...string) Option {
+	return func(h *Handler) {
+		h.security = sec
+		h.trustedImages = trusted
+	}
+}
+
+// WithSeccompDir lets creates and templates pick a seccomp profile by
+// name: "network-off" loads <dir>/network-off.json. Files there take
+// precedence over the built-in profiles of the same name.
+func WithSeccompDir(dir string) Option {
+	return func(h *Handler) {
+		h.seccompDir = dir
+	}
+}
+
+// sandboxSecurity returns the hardening of a sandbox of tmpl: req if the
+// create set it, else the server's default unless the image is trusted.
+// The template's seccomp profile applies unless req names one. Profile
+// names are resolved with seccompProfile; paths on the server are not
+// accepted from clients.
+func (h *Handler) sandboxSecurity(req *driver.Security, tmpl templates.Template) (driver.Security, error) {
+	var sec driver.Security
+	switch {
+	case req != nil:
+		sec = *req
+		if sec.SeccompProfile != "" {
+			p, err := h.seccompProfile(sec.SeccompProfile, false)
+			if err != nil {
+				return sec, err
+			}
+			sec.SeccompProfile = p
+		}
+	case h.trustedImage(tmpl.Image):
+		// Trusted images keep the driver's defaults
+	default:
+		sec = h.security
+	}
+	if sec.SeccompProfile == "" && tmpl.Seccomp != "" {
+		p, err := h.seccompProfile(tmpl.Seccomp, true)
+		if err != nil {
+			return sec, err
+		}
+		sec.SeccompProfile = p
+	}
+	if err := sec.Validate(); err != nil {
+		return sec, driverError(err)
+	}
+	return sec, nil
+}
+
+func (h *Handler) trustedImage(image string) bool {
+	for _, pattern := range h.trustedImages {
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// seccompProfile resolves the seccomp profile name of a create to the
+// profile in the seccomp directory, or else the built-in profile. Only
+// templates, which the operator writes, may name absolute paths or turn
+// filtering off with "permissive".
+func (h *Handler) seccompProfile(name string, fromTemplate bool) (string, error) {
+	if fromTemplate && filepath.IsAbs(name) {
+		return name, nil
+	}
+	if !seccompName.MatchString(name) {
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid seccomp profile %q", name))
+	}
+	builtin := driver.IsSeccompBuiltin(name)
+	if h.seccompDir != "" {
+		file := filepath.Join(h.seccompDir, name+".json")
+		if _, err := os.Stat(file); err == nil || !builtin {
+			return file, nil
+		}
+	}
+	switch {
+	case !builtin:
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("this server has no seccomp profiles but the built-in %s, %s and %s",
+				driver.SeccompDefault, driver.SeccompStrict, driver.SeccompPermissive))
+	case name == driver.SeccompPermissive && !fromTemplate:
+		return "", newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("seccomp profile %q can only be set by templates", name))
+	}
+	return name, nil
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// SessionHeader carries the interactive session ID in the WebSocket
+// handshake response.
+const SessionHeader = "X-Boxed-Session"
+
+// Limits on interactive sessions kept across WebSocket reconnects.
+const (
+	// sessionDetachTimeout is how long a session outlives its WebSocket
+	sessionDetachTimeout = 10 * time.Minute
+
+	// sessionBufferBytes bounds the recent output replayed on reattach
+	sessionBufferBytes = 64 * 1024
+)
+
+// sessionName is the form of a session named by the client: it starts with
+// a letter or digit and never with sessionPrefix.
+var sessionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// sessionPrefix starts the IDs of the sessions the server names.
+const sessionPrefix = "sess_"
+
+// replSession is an agent connection running a REPL. It lives on when its
+// WebSocket drops so that a client can reattach to it.
+type replSession struct {
+	id        string
+	sandboxID string
+	// repl is the agent session of the REPL: the session's ID if the client
+	// named it, so that its events carry the name, "" otherwise
+	repl string
+	conn io.ReadWriteCloser
+	// redact masks secrets in agent messages
+	redact func(sandboxID, msg string) string
+
+	// connMu serialises input from the current and a replaced WebSocket
+	connMu sync.Mutex
+
+	// mu guards the fields below
+	mu sync.Mutex
+	// ws is the attached client, written to through out; nil while
+	// detached
+	ws  *websocket.Conn
+	out *outbox
+	// buf holds recent agent messages, oldest first
+	buf      [][]byte
+	bufBytes int
+	// idle ends the session once it has been detached for too long
+	idle   *time.Timer
+	closed bool
+}
+
+// sessionRegistry holds the live interactive sessions of all sandboxes,
+// by sandbox and session ID: sandboxes may have sessions of the same name.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[[2]string]*replSession
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[[2]string]*replSession)}
+}
+
+// add registers s, reporting false if its sandbox already has a session of
+// that ID.
+func (r *sessionRegistry) add(s *replSession) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{s.sandboxID, s.id}
+	if r.sessions[key] != nil {
+		return false
+	}
+	r.sessions[key] = s
+	return true
+}
+
+// get returns session id of the sandbox, or nil.
+func (r *sessionRegistry) get(sandboxID, id string) *replSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[[2]string{sandboxID, id}]
+}
+
+func (r *sessionRegistry) remove(s *replSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key := [2]string{s.sandboxID, s.id}; r.sessions[key] == s {
+		delete(r.sessions, key)
+	}
+}
+
+// closeSandbox ends every session of a sandbox, e.g. when it is stopped.
+func (r *sessionRegistry) closeSandbox(sandboxID string) {
+	r.mu.Lock()
+	var ended []*replSession
+	for _, s := range r.sessions {
+		if s.sandboxID == sandboxID {
+			ended = append(ended, s)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, s := range ended {
+		s.close()
+	}
+}
+
+// pump relays agent messages to the attached client and the replay buffer
+// until the agent connection ends, which ends the session.
+func (s *replSession) pump(r *sessionRegistry) {
+	defer r.remove(s)
+	defer s.close()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		// A secret split across two chunks is not masked
+		line := []byte(s.redact(s.sandboxID, scanner.Text()))
+		parts := splitMessage(line)
+		if parts == nil {
+			// Streamed artifacts are not replayed either
+			s.mu.Lock()
+			out := s.out
+			s.mu.Unlock()
+			if out != nil && !out.pushArtifact(line) {
+				out.drop(len(line))
+			}
+			continue
+		}
+		output := outputMessage(line)
+		for _, msg := range parts {
+			s.mu.Lock()
+			s.buf = append(s.buf, msg)
+			s.bufBytes += len(msg)
+			for len(s.buf) > 1 && s.bufBytes > sessionBufferBytes {
+				s.bufBytes -= len(s.buf[0])
+				s.buf = s.buf[1:]
+			}
+			out := s.out
+			s.mu.Unlock()
+			// Outside the lock: with OverflowBlock this waits for the client
+			if out != nil {
+				out.push(msg, output)
+			}
+		}
+	}
+}
+
+// attach makes ws the session's client, replacing any other, and replays
+// the buffered output: the recent history when resuming, whatever the REPL
+// printed before the handshake finished otherwise. overflow is the
+// client's overflow policy; binary is set if it takes artifacts as binary
+// frames.
+func (s *replSession) attach(ws *websocket.Conn, resumed bool, overflow string, binary bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.ws != nil {
+		// The previous connection is likely dead but not noticed yet
+		s.out.close()
+		s.ws.Close()
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	s.ws = ws
+	s.out = newOutbox(ws, overflow, binary)
+	go s.out.run(func() { s.detach(ws) })
+
+	params := map[string]any{"id": s.id, "resumed": resumed}
+	if binary {
+		params["artifacts"] = ArtifactsBinary
+	}
+	hello, _ := json.Marshal(proto.NewNotification("session", params))
+	s.out.push(hello, false)
+	// The replay buffer is smaller than the outbox
+	for _, msg := range s.buf {
+		s.out.push(msg, false)
+	}
+	return true
+}
+
+// detach lets go of ws if it is still the session's client and starts the
+// countdown to ending the session.
+func (s *replSession) detach(ws *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detachLocked(ws)
+}
+
+func (s *replSession) detachLocked(ws *websocket.Conn) {
+	if s.closed || s.ws != ws {
+		return
+	}
+	s.out.close()
+	ws.Close()
+	s.ws = nil
+	s.out = nil
+	s.idle = time.AfterFunc(sessionDetachTimeout, func() {
+		log.Info().Str("session", s.id).Str("id", s.sandboxID).Msg("Detached session timed out")
+		s.close()
+	})
+}
+
+// input forwards a client message to the agent. Structured JSON-RPC passes
+// through, so that a client can start and drive more REPLs of its own
+// sessions on the connection; anything else is typed into the REPL.
+func (s *replSession) input(message []byte) {
+	var generic map[string]any
+	if err := json.Unmarshal(message, &generic); err != nil || generic["method"] == nil {
+		params := map[string]any{"data": string(message)}
+		if s.repl != "" {
+			params["session"] = s.repl
+		}
+		message, _ = json.Marshal(proto.NewRequest("repl.input", params, nil))
+	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	s.conn.Write(append(message, '\n'))
+}
+
+// signal sends sig to the REPL.
+func (s *replSession) signal(sig int) bool {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	return !closed && sendSignal(&s.connMu, s.conn, s.repl, sig) == nil
+}
+
+// end closes the session if ws is still its client; a replaced connection
+// closing does not end it.
+func (s *replSession) end(ws *websocket.Conn) {
+	s.mu.Lock()
+	current := s.ws == ws
+	s.mu.Unlock()
+	if current {
+		s.close()
+	}
+}
+
+func (s *replSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	if s.ws != nil {
+		s.out.close()
+		s.ws.Close()
+		s.ws = nil
+		s.out = nil
+	}
+	s.conn.Close()
+}
+
+// interactSandbox serves GET /sandbox/:id/interact. Without ?session it
+// starts a REPL (?lang=python for Python, bash otherwise); with it, it
+// reattaches to a session whose WebSocket dropped. A ?session the client
+// names is started on first use, so that a sandbox can run several REPLs
+// and clients find each by name. ?overflow picks what happens to output
+// the client is too slow for: OverflowBlock (default) or OverflowDrop.
+// ?artifacts=binary streams large artifacts as binary frames.
+func (h *Handler) interactSandbox(c echo.Context) error {
+	id := c.Param("id")
+	sessionID := c.QueryParam("session")
+	overflow := c.QueryParam("overflow")
+	if overflow == "" {
+		overflow = OverflowBlock
+	}
+	if !validOverflow(overflow) {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "overflow must be block or drop")
+	}
+	artifacts := c.QueryParam("artifacts")
+	if artifacts != "" && artifacts != ArtifactsBinary {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "artifacts must be binary")
+	}
+	if err := h.admitInteractive(c.Request().Context(), id); err != nil {
+		return err
+	}
+	var s *replSession
+	if sessionID != "" {
+		s = h.sessions.get(id, sessionID)
+		if s == nil && strings.HasPrefix(sessionID, sessionPrefix) {
+			return newAPIError(http.StatusNotFound, CodeNotFound, "session not found")
+		}
+		if s == nil && !sessionName.MatchString(sessionID) {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("invalid session %q: use letters, digits, '.', '_' and '-'", sessionID))
+		}
+	}
+	resumed := s != nil
+
+	end := h.activity.begin("session")
+	defer end()
+
+	if s == nil {
+		// The agent connection outlives this request
+		conn, err := h.driver.Connect(context.WithoutCancel(c.Request().Context()), id)
+		if err != nil {
+			return driverError(err)
+		}
+
+		cmd := "bash"
+		if c.QueryParam("lang") == "python" {
+			cmd = "python3"
+		}
+		s = &replSession{id: sessionID, sandboxID: id, repl: sessionID, conn: conn, redact: h.secrets.redact}
+		if s.id == "" {
+			s.id = newJobID(sessionPrefix)
+		}
+		params := map[string]any{"cmd": cmd}
+		if s.repl != "" {
+			params["session"] = s.repl
+		}
+		startBytes, _ := json.Marshal(proto.NewRequest("repl.start", params, 1))
+		conn.Write(append(startBytes, '\n'))
+
+		if !h.sessions.add(s) {
+			// Another client started the session first
+			conn.Close()
+			return newAPIError(http.StatusConflict, CodeConflict, fmt.Sprintf("session %s is starting", sessionID))
+		}
+		go s.pump(h.sessions)
+	}
+
+	ws, err := h.upgrader().Upgrade(c.Response(), c.Request(), http.Header{SessionHeader: {s.id}})
+	if err != nil {
+		if !resumed {
+			s.close()
+		}
+		return err
+	}
+	ws.SetReadLimit(sessionMaxInput)
+	if !s.attach(ws, resumed, overflow, artifacts == ArtifactsBinary) {
+		ws.Close()
+		return nil
+	}
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			// Only a close frame from the client ends the session; a
+			// dropped connection leaves it running for a reattach
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure {
+				s.end(ws)
+			} else {
+				s.detach(ws)
+			}
+			return nil
+		}
+		s.input(message)
+	}
+}
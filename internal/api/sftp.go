@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/pkg/sftp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpOutputBytes caps the output kept of a command run for SFTP, which
+// only its error message uses.
+const sftpOutputBytes = 4 << 10
+
+// sftpFiles serves SFTP from the files API of a sandbox. Transfers are
+// spooled through temporary files, which give the random access SFTP
+// needs, and operations the driver has no call for, such as mkdir and
+// rename, run as commands in the sandbox.
+type sftpFiles struct {
+	h   *Handler
+	ctx context.Context
+	id  string
+}
+
+// serveSFTP serves the "sftp" subsystem of a session on ch, starting in
+// the sandbox's working directory.
+func (s *SSHServer) serveSFTP(ctx context.Context, id string, ch ssh.Channel) {
+	end := s.h.activity.begin("session")
+	defer end()
+	start := "/"
+	if info, err := s.h.driver.Info(ctx, id); err == nil && info.Config.WorkDir != "" {
+		start = info.Config.WorkDir
+	}
+	audit(ctx, id, "Opened SFTP session")
+
+	f := &sftpFiles{h: s.h, ctx: ctx, id: id}
+	srv := sftp.NewRequestServer(ch, sftp.Handlers{FileGet: f, FilePut: f, FileCmd: f, FileList: f},
+		sftp.WithStartDirectory(start))
+	err := srv.Serve()
+	if err == nil || errors.Is(err, io.EOF) {
+		// scp fails without an exit status, like that of OpenSSH's
+		// sftp-server
+		sendExitStatus(ch, 0)
+	} else {
+		log.Debug().Err(err).Str("id", id).Msg("SFTP session failed")
+	}
+	srv.Close()
+}
+
+// Fileread implements sftp.FileReader.
+func (f *sftpFiles) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	started := time.Now()
+	content, err := f.h.driver.GetFile(f.ctx, f.id, r.Filepath)
+	f.h.recordEvent(f.id, state.EventFileDownload, started, r.Filepath, err)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+	if ra, ok := content.(io.ReaderAt); ok {
+		return ra, nil
+	}
+	defer content.Close()
+	spool, err := spoolFile()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(spool, content); err != nil {
+		spool.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", r.Filepath, err)
+	}
+	return spool, nil
+}
+
+// Filewrite implements sftp.FileWriter. The file is written to the
+// sandbox when the client closes it.
+func (f *sftpFiles) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	spool, err := spoolFile()
+	if err != nil {
+		return nil, err
+	}
+	if flags := r.Pflags(); !flags.Trunc {
+		// Writes update the file rather than replace it
+		content, err := f.h.driver.GetFile(f.ctx, f.id, r.Filepath)
+		if err == nil {
+			_, err = io.Copy(spool, content)
+			content.Close()
+			if err != nil {
+				spool.Close()
+				return nil, fmt.Errorf("failed to read %s: %w", r.Filepath, err)
+			}
+		}
+	}
+	return &sftpUpload{File: spool, f: f, path: r.Filepath}, nil
+}
+
+// sftpUpload is a file being written over SFTP.
+type sftpUpload struct {
+	*os.File
+	f    *sftpFiles
+	path string
+}
+
+// Close writes the file to the sandbox.
+func (u *sftpUpload) Close() error {
+	defer u.File.Close()
+	if _, err := u.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	started := time.Now()
+	err := u.f.h.driver.PutFile(u.f.ctx, u.f.id, u.path, u.File)
+	u.f.h.recordEvent(u.f.id, state.EventFileUpload, started, u.path, err)
+	return err
+}
+
+// Filecmd implements sftp.FileCmder. Attributes cannot be set: Setstat,
+// which clients preserving times send after uploads, changes nothing.
+func (f *sftpFiles) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Mkdir":
+		return f.run("mkdir", "--", r.Filepath)
+	case "Rmdir":
+		return f.run("rmdir", "--", r.Filepath)
+	case "Remove":
+		return f.run("rm", "--", r.Filepath)
+	case "Rename", "PosixRename":
+		return f.run("mv", "-f", "--", r.Filepath, r.Target)
+	case "Symlink":
+		return f.run("ln", "-s", "--", r.Filepath, r.Target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist implements sftp.FileLister.
+func (f *sftpFiles) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		entries, err := f.h.driver.ListFiles(f.ctx, f.id, r.Filepath)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		if len(entries) == 0 || !entries[0].IsDir {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+		// The listing is recursive and starts with the directory itself;
+		// paths of directories may end in a slash
+		dir := strings.TrimSuffix(entries[0].Path, "/") + "/"
+		var list sftpListing
+		for _, e := range entries[1:] {
+			name, ok := strings.CutPrefix(strings.TrimSuffix(e.Path, "/"), dir)
+			if ok && name != "" && !strings.Contains(name, "/") {
+				list = append(list, sftpFileInfo{e})
+			}
+		}
+		return list, nil
+	case "Stat", "Lstat":
+		if r.Filepath == "/" {
+			return sftpListing{sftpFileInfo{&driver.FileEntry{Name: "/", Mode: 0755, IsDir: true}}}, nil
+		}
+		entries, err := f.h.driver.ListFiles(f.ctx, f.id, r.Filepath)
+		if err != nil {
+			return nil, sftpError(err)
+		}
+		if len(entries) == 0 {
+			return nil, sftp.ErrSSHFxNoSuchFile
+		}
+		return sftpListing{sftpFileInfo{entries[0]}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// run runs a command in the sandbox for an SFTP request, failing with its
+// error output if it does.
+func (f *sftpFiles) run(cmd string, args ...string) error {
+	stdout := newCappedOutput(sftpOutputBytes, false)
+	stderr := newCappedOutput(sftpOutputBytes, false)
+	params := map[string]any{"cmd": cmd, "args": args}
+	_, exit, apiErr := f.h.agentExec(f.ctx, f.id, params, "", nil, stdout, stderr)
+	if apiErr != nil {
+		return apiErr
+	}
+	if exit.code == nil || *exit.code != 0 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("%s failed", cmd)
+	}
+	return nil
+}
+
+// sftpError maps a driver error to the SFTP status clients expect.
+func sftpError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return sftp.ErrSSHFxNoSuchFile
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
+
+// spoolFile returns an empty temporary file that is gone once closed.
+func spoolFile() (*os.File, error) {
+	file, err := os.CreateTemp("", "boxed-sftp-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool file: %w", err)
+	}
+	os.Remove(file.Name())
+	return file, nil
+}
+
+// sftpListing implements sftp.ListerAt.
+type sftpListing []os.FileInfo
+
+func (l sftpListing) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpFileInfo is a driver.FileEntry as an os.FileInfo.
+type sftpFileInfo struct {
+	e *driver.FileEntry
+}
+
+func (i sftpFileInfo) Name() string       { return path.Base(i.e.Name) }
+func (i sftpFileInfo) Size() int64        { return i.e.Size }
+func (i sftpFileInfo) ModTime() time.Time { return i.e.LastModified }
+func (i sftpFileInfo) IsDir() bool        { return i.e.IsDir }
+func (i sftpFileInfo) Sys() any           { return nil }
+
+func (i sftpFileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.e.Mode) & fs.ModePerm
+	if i.e.IsDir {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
@@ -0,0 +1,43 @@
+package api
+
+import "strconv"
+
+// LanguageBashSession runs bash in a shell kept per sandbox, so successive
+// execs share its working directory, variables and functions like commands
+// typed into a terminal do.
+const LanguageBashSession = "bash-session"
+
+// bashKernelScript is run with bash -c. It reads requests of a line with
+// the length in bytes of the code and the code itself, runs the code with
+// eval in the shell, and writes the exit code as a marker line to stdout
+// and to stderr. The code cannot read the requests: its stdin is
+// /dev/null, and the descriptors the kernel keeps for itself are closed
+// while it runs. SIGINT interrupts the running command, not the shell.
+const bashKernelScript = `
+exec 97<&0 98>&1 99>&2 0</dev/null
+trap : INT
+while IFS= read -r -u 97 __boxed_len; do
+	LC_ALL=C IFS= read -r -N "$__boxed_len" -u 97 __boxed_code
+	eval "$__boxed_code" 97<&- 98>&- 99>&-
+	__boxed_status=$?
+	printf '\036boxed {"exit":%d}\n' "$__boxed_status" >&98
+	printf '\036boxed {"exit":%d}\n' "$__boxed_status" >&99
+done
+`
+
+// bashKernel runs the execs of LanguageBashSession. exit ends the shell,
+// like it ends a terminal, and the exec running it exits with its code.
+var bashKernel = &kernel{
+	name:         "bash session",
+	noun:         "shell",
+	cmd:          "bash",
+	args:         []string{"--noprofile", "--norc", "-c", bashKernelScript},
+	input:        bashKernelInput,
+	stderrFrames: true,
+	exitIsResult: true,
+}
+
+// bashKernelInput is the request of code.
+func bashKernelInput(code string) string {
+	return strconv.Itoa(len(code)) + "\n" + code
+}
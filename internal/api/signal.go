@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+)
+
+// signalNumbers are the signals that can be sent to sandbox processes, with
+// their numbers on Linux, which sandboxes run whatever the server's OS.
+var signalNumbers = map[string]int{
+	"SIGHUP":  1,
+	"SIGINT":  2,
+	"SIGQUIT": 3,
+	"SIGKILL": 9,
+	"SIGUSR1": 10,
+	"SIGUSR2": 12,
+	"SIGTERM": 15,
+}
+
+// SignalRequest sends a signal to what runs in a sandbox.
+type SignalRequest struct {
+	// Signal is a signal name, such as "SIGINT" or "INT"
+	Signal string `json:"signal"`
+
+	// Session is an interactive session whose REPL gets the signal; by
+	// default the sandbox's running execs get it
+	Session string `json:"session,omitempty"`
+}
+
+type SignalResponse struct {
+	SandboxID string `json:"sandbox_id"`
+	Signal    string `json:"signal"`
+
+	// Processes is how many processes were signaled
+	Processes int `json:"processes"`
+}
+
+// parseSignal returns the name and number of signal s.
+func parseSignal(s string) (string, int, bool) {
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signalNumbers[name]
+	return name, sig, ok
+}
+
+// signaler is a process started through an agent connection.
+type signaler interface {
+	// signal sends sig to the process, reporting whether it was running
+	signal(sig int) bool
+}
+
+// procRegistry holds the signalable execs running in each sandbox, counting
+// the execs of a python session's kernel.
+type procRegistry struct {
+	mu    sync.Mutex
+	procs map[string]map[signaler]int
+}
+
+func newProcRegistry() *procRegistry {
+	return &procRegistry{procs: make(map[string]map[signaler]int)}
+}
+
+// add registers p as running in sandbox id until the returned func is
+// called.
+func (r *procRegistry) add(id string, p signaler) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.procs[id] == nil {
+		r.procs[id] = make(map[signaler]int)
+	}
+	r.procs[id][p]++
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.procs[id][p]--; r.procs[id][p] == 0 {
+			delete(r.procs[id], p)
+		}
+		if len(r.procs[id]) == 0 {
+			delete(r.procs, id)
+		}
+	}
+}
+
+func (r *procRegistry) running(id string) []signaler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var procs []signaler
+	for p := range r.procs[id] {
+		procs = append(procs, p)
+	}
+	return procs
+}
+
+// agentProc is the process of an exec, alone on its agent connection.
+type agentProc struct {
+	mu   sync.Mutex
+	conn io.Writer
+}
+
+func (p *agentProc) signal(sig int) bool {
+	return sendSignal(&p.mu, p.conn, "", sig) == nil
+}
+
+// cancel asks the agent to kill the process, for an exec nobody waits for
+// any more.
+func (p *agentProc) cancel() error {
+	msg, _ := json.Marshal(proto.NewNotification(proto.MethodExecCancel, nil))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.conn.Write(append(msg, '\n'))
+	return err
+}
+
+// sendSignal asks the agent on conn to signal its process, or the REPL of
+// session if it is named, holding mu to write.
+func sendSignal(mu *sync.Mutex, conn io.Writer, session string, sig int) error {
+	params := map[string]any{"signal": sig}
+	if session != "" {
+		params["session"] = session
+	}
+	msg, _ := json.Marshal(proto.NewNotification("proc.signal", params))
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := conn.Write(append(msg, '\n'))
+	return err
+}
+
+// signalSandbox serves POST /sandbox/:id/signal.
+func (h *Handler) signalSandbox(c echo.Context) error {
+	var req SignalRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.Signal(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Signal sends a signal to the running execs of a sandbox, or to the REPL
+// of one of its interactive sessions, and their child processes. The
+// execs then end as the processes do, with exit_reason "signaled" if the
+// signal killed them. It is the transport independent core of POST
+// /sandbox/:id/signal; errors are *APIError.
+func (h *Handler) Signal(ctx context.Context, id string, req SignalRequest) (*SignalResponse, error) {
+	name, sig, ok := parseSignal(req.Signal)
+	if !ok {
+		names := make([]string, 0, len(signalNumbers))
+		for n := range signalNumbers {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("unsupported signal %q: want one of %s", req.Signal, strings.Join(names, ", ")))
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.store.GetSandbox(ctx, id); err != nil {
+		return nil, errSandboxNotFound
+	}
+
+	targets := h.procs.running(id)
+	what := "exec"
+	if req.Session != "" {
+		s := h.sessions.get(id, req.Session)
+		if s == nil {
+			return nil, newAPIError(http.StatusNotFound, CodeNotFound, "session not found")
+		}
+		targets = []signaler{s}
+		what = "session " + req.Session
+	}
+
+	started := time.Now()
+	signaled := 0
+	for _, p := range targets {
+		if p.signal(sig) {
+			signaled++
+		}
+	}
+	if signaled == 0 {
+		return nil, newAPIError(http.StatusConflict, CodeConflict, "no exec is running in the sandbox")
+	}
+	if signaled > 1 {
+		what = fmt.Sprintf("%d execs", signaled)
+	}
+	h.recordEvent(id, state.EventSignaled, started, name+" to "+what, nil)
+
+	return &SignalResponse{SandboxID: id, Signal: name, Processes: signaled}, nil
+}
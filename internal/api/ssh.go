@@ -0,0 +1,508 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrSSHServerClosed is returned by SSHServer.Serve after Shutdown.
+var ErrSSHServerClosed = errors.New("ssh: server closed")
+
+// sshHandshakeTimeout bounds how long a connection may take to log in.
+const sshHandshakeTimeout = 30 * time.Second
+
+// SSHServer is a gateway to sandboxes for tools that speak SSH rather than
+// the REST API: scp, sftp, sshfs and the remote plugins of IDEs. The user
+// name is the ID of a sandbox, or an abbreviation of it, and the password
+// an API key or bearer token. A session runs its command, or an
+// interactive bash, in the sandbox without a terminal, and the "sftp"
+// subsystem serves the sandbox's files.
+type SSHServer struct {
+	h      *Handler
+	config *ssh.ServerConfig
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[ssh.Conn]bool
+	active    sync.WaitGroup
+}
+
+// sshLogin is what logging in established about a connection.
+type sshLogin struct {
+	sandbox string
+	claims  *auth.Claims
+}
+
+// sshLoginKey keys the sshLogin in the ssh.Permissions of a connection.
+type sshLoginKey struct{}
+
+// NewSSHServer returns an SSH gateway to the sandboxes of h, which
+// identifies itself with hostKey. Logins are checked like REST requests:
+// without an API key or token verifier, any password is accepted.
+func (h *Handler) NewSSHServer(hostKey ssh.Signer) *SSHServer {
+	s := &SSHServer{
+		h:         h,
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[ssh.Conn]bool),
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return s.login(meta, string(password))
+		},
+		ServerVersion: "SSH-2.0-boxed",
+	}
+	if !h.authenticates() {
+		s.config.NoClientAuth = true
+		s.config.NoClientAuthCallback = func(meta ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return s.login(meta, "")
+		}
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// LoadSSHHostKey reads the private key of an SSH server from path,
+// generating it there first if the file does not exist, so that clients
+// see the same host key across restarts. With no path the key is
+// generated for this process only.
+func LoadSSHHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "boxed")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to save SSH host key: %w", err)
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save SSH host key: %w", err)
+		}
+		log.Info().Str("path", path).Msg("Generated SSH host key")
+		return ssh.NewSignerFromKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH host key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH host key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// login authenticates secret like the API key or bearer token of a REST
+// request, then resolves the sandbox named by the user name as its caller.
+func (s *SSHServer) login(meta ssh.ConnMetadata, secret string) (*ssh.Permissions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sshHandshakeTimeout)
+	defer cancel()
+	login := &sshLogin{}
+	if s.h.authenticates() {
+		switch k, ok := s.h.keys[secret]; {
+		case secret == "":
+			return nil, errors.New("missing API key or token")
+		case s.h.apiKey != "" && secret == s.h.apiKey:
+		case ok:
+			login.claims = k.claims()
+		case s.h.tokens != nil:
+			claims, err := s.h.tokens.Verify(ctx, secret)
+			if err != nil {
+				log.Debug().Err(err).Str("remote", meta.RemoteAddr().String()).Msg("Rejected SSH token")
+				return nil, errors.New("invalid API key or token")
+			}
+			login.claims = claims
+		default:
+			return nil, errors.New("invalid API key or token")
+		}
+		if login.claims != nil {
+			ctx = auth.WithClaims(ctx, login.claims)
+		}
+	}
+
+	id, err := s.h.grpcSandbox(ctx, meta.User())
+	if err == nil {
+		_, err = s.h.driver.Info(ctx, id)
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("user", meta.User()).Str("remote", meta.RemoteAddr().String()).Msg("Rejected SSH login")
+		return nil, fmt.Errorf("sandbox %s: %w", meta.User(), err)
+	}
+	login.sandbox = id
+	return &ssh.Permissions{ExtraData: map[any]any{sshLoginKey{}: login}}, nil
+}
+
+// Serve accepts connections on l until Shutdown is called.
+func (s *SSHServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSSHServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrSSHServerClosed
+			}
+			return err
+		}
+		go s.serveConn(nc)
+	}
+}
+
+// Shutdown stops accepting connections and waits for open ones to end
+// until ctx is done, then closes them.
+func (s *SSHServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *SSHServer) serveConn(nc net.Conn) {
+	nc.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		log.Debug().Err(err).Str("remote", nc.RemoteAddr().String()).Msg("SSH handshake failed")
+		nc.Close()
+		return
+	}
+	nc.SetDeadline(time.Time{})
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.active.Add(1)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.active.Done()
+	}()
+	defer conn.Close()
+
+	login := conn.Permissions.ExtraData[sshLoginKey{}].(*sshLogin)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if login.claims != nil {
+		ctx = auth.WithClaims(ctx, login.claims)
+	}
+	audit(ctx, login.sandbox, "Opened SSH connection from "+conn.RemoteAddr().String())
+
+	// Global requests are keepalives and port forwarding, which is not
+	// supported
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.Prohibited, "only session channels are supported")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.session(ctx, login.sandbox, ch, chReqs)
+		}()
+	}
+	// The connection is gone: end what its sessions run
+	cancel()
+	wg.Wait()
+}
+
+// session serves a session channel, collecting environment variables until
+// a request says what to run.
+func (s *SSHServer) session(ctx context.Context, id string, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	env := make(map[string]string)
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ok := ssh.Unmarshal(req.Payload, &kv) == nil
+			if ok {
+				env[kv.Name] = kv.Value
+			}
+			req.Reply(ok, nil)
+		case "shell", "exec":
+			// Commands are reviewed and held to the limits of execs;
+			// shells, whose commands are typed later, only if no webhook
+			// reviews execs
+			exec := ExecRequest{Language: "bash", Env: env}
+			var args []string
+			var err error
+			if req.Type == "exec" {
+				var cmd struct{ Command string }
+				if ssh.Unmarshal(req.Payload, &cmd) != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				exec.Code = cmd.Command
+				if exec, err = s.h.admitExec(ctx, id, exec); err == nil {
+					args = []string{"-c", exec.Code}
+				}
+			} else {
+				err = s.h.admitInteractive(ctx, id)
+			}
+			if err == nil {
+				err = s.h.checkExecInput(exec)
+			}
+			if err != nil {
+				req.Reply(false, nil)
+				fmt.Fprintf(ch.Stderr(), "boxed: %v\n", err)
+				return
+			}
+			req.Reply(true, nil)
+			s.run(ctx, id, ch, reqs, args, exec.Env)
+			return
+		case "subsystem":
+			var sub struct{ Name string }
+			if ssh.Unmarshal(req.Payload, &sub) != nil || sub.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(reqs)
+			s.serveSFTP(ctx, id, ch)
+			return
+		default:
+			// Such as pty-req: commands run without a terminal, and
+			// clients fall back to passing lines
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// run runs bash with args in sandbox id, connecting it to ch: input goes
+// to its stdin, its output comes back as the channel's data and extended
+// (stderr) data, and its exit status ends the channel.
+func (s *SSHServer) run(ctx context.Context, id string, ch ssh.Channel, reqs <-chan *ssh.Request, args []string, env map[string]string) {
+	end := s.h.activity.begin("session")
+	defer end()
+	// Like an exec, it takes a slot of the sandbox and of the server for
+	// as long as it runs, waiting for them if need be
+	release, _, err := s.h.acquireExec(ctx, id, false, nil)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "boxed: %v\n", err)
+		sendExitStatus(ch, 255)
+		return
+	}
+	defer release()
+	conn, err := s.h.driver.Connect(ctx, id)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "boxed: %v\n", driverError(err).Message)
+		sendExitStatus(ch, 255)
+		return
+	}
+	defer conn.Close()
+	var connMu sync.Mutex
+	write := func(method string, params map[string]any, reqID any) error {
+		msg, _ := json.Marshal(proto.NewRequest(method, params, reqID))
+		connMu.Lock()
+		defer connMu.Unlock()
+		_, err := conn.Write(append(msg, '\n'))
+		return err
+	}
+	params := map[string]any{"cmd": "bash"}
+	if len(args) > 0 {
+		params["args"] = args
+		audit(ctx, id, "Running command over SSH")
+	} else {
+		audit(ctx, id, "Opened shell over SSH")
+	}
+	if len(env) > 0 {
+		params["env"] = env
+	}
+	if err := write("repl.start", params, 1); err != nil {
+		fmt.Fprintf(ch.Stderr(), "boxed: %v\n", err)
+		sendExitStatus(ch, 255)
+		return
+	}
+
+	// Signals from the client go to the process
+	go func() {
+		for req := range reqs {
+			if req.Type == "signal" {
+				var sig struct{ Name string }
+				if ssh.Unmarshal(req.Payload, &sig) == nil {
+					if _, n, ok := parseSignal(sig.Name); ok {
+						sendSignal(&connMu, conn, "", n)
+					}
+				}
+			}
+			if req.WantReply {
+				req.Reply(req.Type == "signal", nil)
+			}
+		}
+	}()
+
+	// Input goes to the process's stdin, which is closed when the client
+	// sends EOF. It is passed as text: a character split across reads is
+	// held back until the rest of it arrives.
+	go func() {
+		buf := make([]byte, 32*1024)
+		var pending []byte
+		for {
+			n, err := ch.Read(buf)
+			pending = append(pending, buf[:n]...)
+			if cut := completeUTF8(pending); cut > 0 {
+				write("repl.input", map[string]any{"data": string(pending[:cut])}, nil)
+				pending = append(pending[:0], pending[cut:]...)
+			}
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					write("repl.input", map[string]any{"data": string(pending), "eof": true}, nil)
+				}
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			Method string `json:"method"`
+			Params struct {
+				Chunk   string `json:"chunk"`
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+				Signal  int    `json:"signal"`
+			} `json:"params"`
+			Error *proto.RPCError `json:"error"`
+		}
+		if json.Unmarshal([]byte(s.h.secrets.redact(id, scanner.Text())), &msg) != nil {
+			continue
+		}
+		switch {
+		case msg.Error != nil:
+			// The process did not start
+			fmt.Fprintf(ch.Stderr(), "boxed: %s\n", msg.Error.Message)
+			sendExitStatus(ch, 255)
+			return
+		case msg.Method == "stdout":
+			io.WriteString(ch, msg.Params.Chunk)
+		case msg.Method == "stderr":
+			io.WriteString(ch.Stderr(), msg.Params.Chunk)
+		case msg.Method == "error":
+			fmt.Fprintf(ch.Stderr(), "boxed: %s\n", msg.Params.Message)
+		case msg.Method == "exit":
+			ch.CloseWrite()
+			if msg.Params.Signal != 0 {
+				sendExitSignal(ch, msg.Params.Signal)
+			} else {
+				sendExitStatus(ch, msg.Params.Code)
+			}
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		fmt.Fprintln(ch.Stderr(), "boxed: connection to the sandbox ended")
+		sendExitStatus(ch, 255)
+	}
+}
+
+// completeUTF8 returns the length of b without a character cut short at
+// its end.
+func completeUTF8(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+func sendExitStatus(ch ssh.Channel, code int) {
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(code)}))
+}
+
+// sendExitSignal reports that the process was killed by signal sig, or
+// its exit status 128+sig if the client does not know the signal.
+func sendExitSignal(ch ssh.Channel, sig int) {
+	for name, n := range signalNumbers {
+		if n == sig {
+			ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Message    string
+				Lang       string
+			}{Signal: name[len("SIG"):]}))
+			return
+		}
+	}
+	sendExitStatus(ch, 128+sig)
+}
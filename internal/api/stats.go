@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// Bounds of the interval between streamed stats samples.
+const (
+	defaultStatsInterval = time.Second
+	minStatsInterval     = 250 * time.Millisecond
+	maxStatsInterval     = time.Minute
+)
+
+func (h *Handler) getStats(c echo.Context) error {
+	stats, err := h.Stats(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// Stats returns a sample of the resources a sandbox uses. It is the
+// transport independent core of GET /sandbox/:id/stats; errors are
+// *APIError. A single sample has no CPU percentage, which needs two.
+func (h *Handler) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	sr, ok := h.driver.(driver.StatsReader)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not report resource usage")
+	}
+	stats, err := sr.Stats(ctx, id)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	return stats, nil
+}
+
+// streamStats serves GET /sandbox/:id/stats/stream?interval=<seconds> as
+// server-sent events: a "stats" event with a driver.ResourceStats every
+// interval until the sandbox stops or the client goes away. Other failures
+// to sample are sent as an "error" event holding the APIError before the
+// stream ends.
+func (h *Handler) streamStats(c echo.Context) error {
+	interval := defaultStatsInterval
+	if v := c.QueryParam("interval"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		interval = time.Duration(secs * float64(time.Second))
+		if err != nil || interval < minStatsInterval || interval > maxStatsInterval {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("interval must be between %v and %v seconds", minStatsInterval.Seconds(), maxStatsInterval.Seconds()))
+		}
+	}
+
+	ctx := c.Request().Context()
+	id := c.Param("id")
+	// The first sample is taken before answering, so that unknown sandboxes
+	// get an error status rather than an empty stream
+	stats, err := h.Stats(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var prev *driver.ResourceStats
+	for {
+		setCPUPercent(stats, prev)
+		if writeEvent(res, "stats", stats) != nil {
+			return nil
+		}
+		prev = stats
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		if stats, err = h.Stats(ctx, id); err != nil {
+			// A stopped sandbox ends the stream
+			if ctx.Err() != nil || errors.Is(err, driver.ErrSandboxNotFound) || errors.Is(err, driver.ErrSandboxNotRunning) {
+				return nil
+			}
+			writeEvent(res, "error", err)
+			return nil
+		}
+	}
+}
+
+// setCPUPercent fills in stats.CPUPercent from the CPU time used since
+// prev.
+func setCPUPercent(stats, prev *driver.ResourceStats) {
+	if prev == nil || stats.CPUNanos == nil || prev.CPUNanos == nil || *stats.CPUNanos < *prev.CPUNanos {
+		return
+	}
+	elapsed := stats.Time.Sub(prev.Time)
+	if elapsed <= 0 {
+		return
+	}
+	percent := float64(*stats.CPUNanos-*prev.CPUNanos) / float64(elapsed.Nanoseconds()) * 100
+	stats.CPUPercent = &percent
+}
+
+// writeEvent sends a server-sent event with v as JSON data.
+func writeEvent(res *echo.Response, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
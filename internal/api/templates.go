@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/labstack/echo/v4"
+)
+
+// WithTemplates resolves create templates with c instead of the built-in
+// catalog.
+func WithTemplates(c *templates.Catalog) Option {
+	return func(h *Handler) {
+		if c != nil {
+			h.templates = c
+		}
+	}
+}
+
+// TemplateInfo describes a template of the server's catalog.
+type TemplateInfo struct {
+	Name     string  `json:"name"`
+	Image    string  `json:"image"`
+	MemoryMB int64   `json:"memory_mb"`
+	CPUCores float64 `json:"cpu_cores"`
+
+	// CPUBurst is the template's CPU burst, if it has one
+	CPUBurst *driver.CPUBurst `json:"cpu_burst,omitempty"`
+
+	// Timeout is the lifetime in seconds of sandboxes created without
+	// one, if the template sets it
+	Timeout int `json:"timeout,omitempty"`
+
+	// Init is the script run in each sandbox before it is ready, and
+	// InitTimeout its limit in seconds, if the template has one
+	Init        string `json:"init,omitempty"`
+	InitTimeout int    `json:"init_timeout,omitempty"`
+
+	// Driver is the backend the template's sandboxes run on, if it names
+	// one
+	Driver string `json:"driver,omitempty"`
+
+	// Seccomp is the seccomp profile of the template's sandboxes, if it
+	// sets one
+	Seccomp string `json:"seccomp,omitempty"`
+
+	// Default marks the template of creates that name none
+	Default bool `json:"default,omitempty"`
+
+	// Published marks templates saved from a sandbox with
+	// POST /sandbox/:id/publish-template
+	Published bool `json:"published,omitempty"`
+}
+
+// TemplateList is the server's template catalog.
+type TemplateList struct {
+	Templates []TemplateInfo `json:"templates"`
+
+	// Images are the patterns images used directly as templates must
+	// match; null if every image is allowed
+	Images []string `json:"images"`
+}
+
+func (h *Handler) listTemplates(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.Templates(c.Request().Context()))
+}
+
+// Templates returns the catalog create templates are resolved with. It is
+// the transport independent core of GET /templates.
+func (h *Handler) Templates(ctx context.Context) *TemplateList {
+	list, def, images := h.templates.List()
+	resp := &TemplateList{Templates: make([]TemplateInfo, len(list)), Images: images}
+	for i, t := range list {
+		resp.Templates[i] = templateInfo(t, def)
+	}
+	return resp
+}
+
+func templateInfo(t templates.Template, def string) TemplateInfo {
+	return TemplateInfo{
+		Name:      t.Name,
+		Image:     t.Image,
+		MemoryMB:  t.MemoryMB,
+		CPUCores:  t.CPUCores,
+		CPUBurst:  t.CPUBurst,
+		Timeout:   int(t.Timeout.Seconds()),
+		Driver:    t.Driver,
+		Seccomp:   t.Seccomp,
+		Default:   t.Name == def,
+		Published: t.Published,
+
+		Init:        t.Init,
+		InitTimeout: int(t.InitTimeout.Seconds()),
+	}
+}
+
+// TemplateLabel is set on the images of published templates to the
+// template's name.
+const TemplateLabel = "boxed.template"
+
+// templateImageRepo is the repository of the images of published
+// templates, which keeps them apart from the images they started from.
+const templateImageRepo = "boxed-templates/"
+
+var (
+	templateNameRE = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+	templateTagRE  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// PublishTemplateRequest saves a sandbox as the template "<name>:<tag>".
+type PublishTemplateRequest struct {
+	// Name is lowercase letters and digits, separated by ".", "_" or "-"
+	Name string `json:"name"`
+
+	// Tag is letters, digits, ".", "_" and "-"; "latest" if empty
+	Tag string `json:"tag,omitempty"`
+
+	// Init is a script run in each sandbox of the template before it is
+	// ready, such as to start the services of the saved environment:
+	// processes are not saved. InitTimeout bounds it in seconds.
+	Init        string `json:"init,omitempty"`
+	InitTimeout int    `json:"init_timeout,omitempty"`
+}
+
+func (h *Handler) publishTemplate(c echo.Context) error {
+	var req PublishTemplateRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.PublishTemplate(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// PublishTemplate saves the filesystem of a running sandbox as an image
+// and adds a template of it to the catalog, with the sandbox's resources,
+// so that later creates start from it. Publishing a name again replaces
+// its image; only the caller who published it, or an admin, may. It is
+// the transport independent core of POST /sandbox/:id/publish-template;
+// errors are *APIError.
+func (h *Handler) PublishTemplate(ctx context.Context, id string, req PublishTemplateRequest) (*TemplateInfo, error) {
+	if req.Tag == "" {
+		req.Tag = "latest"
+	}
+	if err := checkTemplateName(req.Name, req.Tag, req.Init, req.InitTimeout); err != nil {
+		return nil, err
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := h.driver.Info(ctx, id)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	if info.State != driver.StateReady {
+		return nil, wrapAPIError(http.StatusConflict, CodeSandboxNotRunning, "sandbox is not running", driver.ErrSandboxNotRunning)
+	}
+
+	end := h.activity.begin("publish")
+	defer end()
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+
+	name := req.Name + ":" + req.Tag
+	owner, err := h.templateOwner(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	t := templates.Template{
+		Name:        name,
+		Image:       templateImageRepo + name,
+		MemoryMB:    info.Config.MemoryMB,
+		CPUCores:    info.Config.CPUCores,
+		CPUBurst:    info.Config.CPUBurst,
+		Init:        req.Init,
+		InitTimeout: time.Duration(req.InitTimeout) * time.Second,
+		Owner:       owner,
+	}
+	// On servers running several drivers the image only exists on the
+	// sandbox's
+	if len(backends(h.ids.Backend())) > 1 {
+		t.Driver, _, _ = strings.Cut(h.ids.BackendID(id), ":")
+	}
+
+	started := time.Now()
+	err = h.ids.Snapshot(ctx, id, t.Image, map[string]string{TemplateLabel: name})
+	h.recordEvent(id, state.EventPublished, started, "template "+name, err)
+	if err != nil {
+		apiErr := driverError(err)
+		apiErr.Message = fmt.Sprintf("failed to save sandbox as %s: %v", t.Image, err)
+		return nil, apiErr
+	}
+	t, err = h.templates.Publish(t)
+	if errors.Is(err, templates.ErrDefined) {
+		return nil, wrapAPIError(http.StatusConflict, CodeConflict, err.Error(), err)
+	}
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, err.Error(), err)
+	}
+	audit(ctx, id, "Published template "+name)
+	resp := templateInfo(t, h.templates.Default().Name)
+	return &resp, nil
+}
+
+// checkTemplateName checks the name and tag of a template to publish, and
+// its init script.
+func checkTemplateName(name, tag, init string, initTimeout int) *APIError {
+	switch {
+	case len(name) > 128 || !templateNameRE.MatchString(name):
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			"name must be lowercase letters and digits, separated by '.', '_' or '-'")
+	case !templateTagRE.MatchString(tag):
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			"tag must be up to 128 letters, digits, '.', '_' or '-'")
+	case initTimeout < 0 || (initTimeout > 0 && init == ""):
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "init_timeout needs an init script and must not be negative")
+	}
+	return nil
+}
+
+// templateOwner returns the caller publishing the template name, who must
+// own it if it was published already. Callers must hold h.publishMu.
+func (h *Handler) templateOwner(ctx context.Context, name string) (string, error) {
+	var owner string
+	if claims := auth.FromContext(ctx); claims != nil {
+		owner = claims.Subject
+	}
+	if old, ok := h.templates.Lookup(name); ok && !old.Published {
+		return "", newAPIError(http.StatusConflict, CodeConflict, fmt.Sprintf("template %s is defined by the server's catalog", name))
+	} else if ok && !isAdmin(ctx) && old.Owner != owner {
+		return "", newAPIError(http.StatusConflict, CodeConflict, fmt.Sprintf("template %s was published by someone else", name))
+	}
+	if h.building[name] {
+		return "", newAPIError(http.StatusConflict, CodeConflict, fmt.Sprintf("template %s is being built", name))
+	}
+	return owner, nil
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// TimelineEvent is a state.Event positioned relative to the first event of
+// the sandbox, which is what a Gantt-style view needs.
+type TimelineEvent struct {
+	state.Event
+	OffsetMS int64 `json:"offset_ms"`
+}
+
+type TimelineResponse struct {
+	SandboxID string          `json:"sandbox_id"`
+	Events    []TimelineEvent `json:"events"`
+	// TotalMS spans from the first event start to the last event end
+	TotalMS int64 `json:"total_ms"`
+}
+
+// recordEvent appends an operation to the sandbox timeline. started is when
+// the operation began; the duration is measured up to now.
+func (h *Handler) recordEvent(id, typ string, started time.Time, detail string, opErr error) {
+	if errors.Is(opErr, driver.ErrSandboxNotFound) {
+		return // don't grow timelines for unknown IDs
+	}
+	ev := state.Event{
+		Type:       typ,
+		At:         started,
+		DurationMS: time.Since(started).Milliseconds(),
+		Detail:     detail,
+	}
+	if opErr != nil {
+		ev.Error = opErr.Error()
+	}
+	// Use a fresh context: the request context may already be cancelled.
+	if err := h.store.AppendEvent(context.Background(), id, ev); err != nil {
+		log.Warn().Err(err).Str("id", id).Str("event", typ).Msg("Failed to record event")
+	}
+}
+
+// recordAgentReady records the first successful agent connection, measured
+// from the moment the sandbox was started.
+func (h *Handler) recordAgentReady(id string) {
+	events, err := h.store.ListEvents(context.Background(), id)
+	if err != nil {
+		return
+	}
+	var startedAt time.Time
+	for _, ev := range events {
+		switch ev.Type {
+		case state.EventAgentReady:
+			return
+		case state.EventStarted:
+			startedAt = ev.At.Add(time.Duration(ev.DurationMS) * time.Millisecond)
+		}
+	}
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+	h.recordEvent(id, state.EventAgentReady, startedAt, "", nil)
+}
+
+func (h *Handler) getTimeline(c echo.Context) error {
+	id := c.Param("id")
+
+	events, err := h.store.ListEvents(c.Request().Context(), id)
+	if err != nil {
+		return driverError(err)
+	}
+	if len(events) == 0 {
+		// Sandboxes created before the server started have no timeline yet.
+		if _, err := h.driver.Info(c.Request().Context(), id); errors.Is(err, driver.ErrSandboxNotFound) {
+			return errSandboxNotFound
+		}
+	}
+
+	resp := TimelineResponse{SandboxID: id, Events: make([]TimelineEvent, 0, len(events))}
+	if len(events) > 0 {
+		origin := events[0].At
+		var end time.Time
+		for _, ev := range events {
+			resp.Events = append(resp.Events, TimelineEvent{Event: ev, OffsetMS: ev.At.Sub(origin).Milliseconds()})
+			if e := ev.At.Add(time.Duration(ev.DurationMS) * time.Millisecond); e.After(end) {
+				end = e
+			}
+		}
+		resp.TotalMS = end.Sub(origin).Milliseconds()
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceRequests starts a server span per request, continuing the trace of
+// the caller's traceparent header. Probes and scrapes are not traced.
+func traceRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Path() {
+		case "/healthz", "/readyz", "/metrics":
+			return next(c)
+		}
+		req := c.Request()
+		ctx := tracing.Propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracing.Start(ctx, req.Method+" "+c.Path(),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("http.route", c.Path()))
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+		if id := c.Param("id"); id != "" {
+			span.SetAttributes(tracing.SandboxID(id))
+		}
+		status := c.Response().Status
+		if err != nil {
+			status = errorStatus(err)
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return err
+	}
+}
+
+// errorStatus is the status ErrorHandler answers err with.
+func errorStatus(err error) int {
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Status
+	case errors.As(err, &httpErr):
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
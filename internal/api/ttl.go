@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultMaxTTL bounds the remaining lifetime a sandbox can be given, at
+// creation or later.
+const DefaultMaxTTL = 30 * time.Minute
+
+// TTLRequest changes the remaining lifetime of a sandbox. Exactly one field
+// must be set.
+type TTLRequest struct {
+	// TTL sets the remaining lifetime, in seconds from now
+	TTL int `json:"ttl"`
+
+	// ExtendBy moves the expiry by this many seconds; negative shortens it
+	ExtendBy int `json:"extend_by"`
+}
+
+type TTLResponse struct {
+	SandboxID string    `json:"sandbox_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (h *Handler) setTTL(c echo.Context) error {
+	var req TTLRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	resp, err := h.SetTTL(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// SetTTL reschedules the expiry of a running sandbox. It is the transport
+// independent core of POST /sandbox/:id/ttl; errors are *APIError.
+func (h *Handler) SetTTL(ctx context.Context, id string, req TTLRequest) (*TTLResponse, error) {
+	ec, ok := h.driver.(driver.ExpiryController)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support changing the TTL")
+	}
+	if (req.TTL == 0) == (req.ExtendBy == 0) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "exactly one of ttl and extend_by is required")
+	}
+	id, err := h.resolveID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serialize changes so concurrent extensions add up
+	h.ttlMu.Lock()
+	defer h.ttlMu.Unlock()
+
+	rec, err := h.store.GetSandbox(ctx, id)
+	if err == nil && rec.State == state.SandboxHibernated {
+		return nil, newAPIError(http.StatusConflict, CodeSandboxNotRunning, "a hibernated sandbox gets its TTL when it wakes")
+	}
+	if err != nil || rec.State != state.SandboxReady {
+		return nil, errSandboxNotFound
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(req.TTL) * time.Second)
+	if req.ExtendBy != 0 {
+		expiresAt = rec.ExpiresAt.Add(time.Duration(req.ExtendBy) * time.Second)
+	}
+	remaining := expiresAt.Sub(now)
+	if remaining <= 0 {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "the new expiry is in the past")
+	}
+	if remaining > h.maxTTL {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("the remaining lifetime may not exceed %s", h.maxTTL))
+	}
+	if h.maxAge > 0 && expiresAt.After(rec.CreatedAt.Add(h.maxAge)) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("sandboxes may not live longer than %s", h.maxAge))
+	}
+
+	if err := ec.SetExpiry(ctx, id, expiresAt); err != nil {
+		return nil, driverError(err)
+	}
+	rec.ExpiresAt = expiresAt
+	h.store.PutSandbox(context.Background(), rec)
+	h.recordEvent(id, state.EventTTLChanged, now, "expires in "+remaining.Round(time.Second).String(), nil)
+
+	return &TTLResponse{SandboxID: id, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// UploadOffsetHeader carries the byte offset a chunk starts at. A chunk is
+// only accepted at the upload's current offset, so a client that lost a
+// response can ask for the offset and resume from there.
+const UploadOffsetHeader = "Upload-Offset"
+
+// uploadIdleTimeout is how long an upload no chunk was sent to is kept.
+const uploadIdleTimeout = time.Hour
+
+// Upload is a chunked upload in progress. Chunks are staged in a temporary
+// file on the server and handed to the driver as one stream on commit.
+type Upload struct {
+	ID        string `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	Path      string `json:"path"`
+
+	// Size is the declared total size, -1 if not given
+	Size int64 `json:"size"`
+
+	// Offset is the number of bytes received so far
+	Offset int64 `json:"offset"`
+}
+
+// upload is the server side of an Upload.
+type upload struct {
+	// mu is held while a chunk is written or the upload committed
+	mu      sync.Mutex
+	info    Upload
+	file    *os.File
+	touched time.Time
+}
+
+// uploadRegistry holds the chunked uploads of all sandboxes.
+type uploadRegistry struct {
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+func newUploadRegistry() *uploadRegistry {
+	return &uploadRegistry{uploads: make(map[string]*upload)}
+}
+
+// add registers u, dropping uploads that have been idle for too long.
+func (r *uploadRegistry) add(u *upload) {
+	r.mu.Lock()
+	var stale []*upload
+	for id, other := range r.uploads {
+		if time.Since(other.touched) > uploadIdleTimeout && other.mu.TryLock() {
+			delete(r.uploads, id)
+			stale = append(stale, other)
+		}
+	}
+	r.uploads[u.info.ID] = u
+	r.mu.Unlock()
+
+	for _, other := range stale {
+		log.Info().Str("upload", other.info.ID).Str("id", other.info.SandboxID).Msg("Dropping idle upload")
+		other.discard()
+		other.mu.Unlock()
+	}
+}
+
+func (r *uploadRegistry) get(sandboxID, id string) *upload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u := r.uploads[id]; u != nil && u.info.SandboxID == sandboxID {
+		return u
+	}
+	return nil
+}
+
+func (r *uploadRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.uploads, id)
+}
+
+// closeSandbox discards the uploads of a stopped sandbox.
+func (r *uploadRegistry) closeSandbox(sandboxID string) {
+	r.mu.Lock()
+	var dropped []*upload
+	for id, u := range r.uploads {
+		if u.info.SandboxID == sandboxID {
+			delete(r.uploads, id)
+			dropped = append(dropped, u)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, u := range dropped {
+		u.mu.Lock()
+		u.discard()
+		u.mu.Unlock()
+	}
+}
+
+// discard must be called with u.mu held.
+func (u *upload) discard() {
+	if u.file != nil {
+		u.file.Close()
+		os.Remove(u.file.Name())
+		u.file = nil
+	}
+}
+
+// lock takes u for a chunk or commit; a concurrent one is a conflict.
+func (u *upload) lock() error {
+	if !u.mu.TryLock() {
+		return newAPIError(http.StatusConflict, CodeConflict, "upload is busy with another request")
+	}
+	if u.file == nil {
+		u.mu.Unlock()
+		return newAPIError(http.StatusNotFound, CodeNotFound, "upload not found")
+	}
+	return nil
+}
+
+type CreateUploadRequest struct {
+	// Path is the destination file in the sandbox
+	Path string `json:"path"`
+
+	// Size is the total size, if known; commit then checks it
+	Size *int64 `json:"size,omitempty"`
+}
+
+// createUpload serves POST /sandbox/:id/uploads.
+func (h *Handler) createUpload(c echo.Context) error {
+	id := c.Param("id")
+	var req CreateUploadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	if req.Path == "" {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "path required")
+	}
+	size := int64(-1)
+	if req.Size != nil {
+		if *req.Size < 0 {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "size must not be negative")
+		}
+		size = *req.Size
+	}
+	if _, err := h.driver.Info(c.Request().Context(), id); err != nil {
+		return driverError(err)
+	}
+
+	f, err := os.CreateTemp("", "boxed-upload-")
+	if err != nil {
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+	u := &upload{
+		info:    Upload{ID: newJobID("upl_"), SandboxID: id, Path: req.Path, Size: size},
+		file:    f,
+		touched: time.Now(),
+	}
+	h.uploads.add(u)
+	return c.JSON(http.StatusCreated, u.info)
+}
+
+// getUpload serves GET /sandbox/:id/uploads/:upload, which tells a client
+// where to resume.
+func (h *Handler) getUpload(c echo.Context) error {
+	u := h.uploads.get(c.Param("id"), c.Param("upload"))
+	if u == nil {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "upload not found")
+	}
+	if err := u.lock(); err != nil {
+		return err
+	}
+	info := u.info
+	u.mu.Unlock()
+	return c.JSON(http.StatusOK, info)
+}
+
+// appendUpload serves PATCH /sandbox/:id/uploads/:upload. The body is
+// streamed to the staging file; if the connection drops midway, what
+// arrived is kept and the offset says how much.
+func (h *Handler) appendUpload(c echo.Context) error {
+	u := h.uploads.get(c.Param("id"), c.Param("upload"))
+	if u == nil {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "upload not found")
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest, UploadOffsetHeader+" header required")
+	}
+	if err := u.lock(); err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+	if offset != u.info.Offset {
+		return newAPIError(http.StatusConflict, CodeConflict,
+			fmt.Sprintf("chunk starts at %d but the upload is at %d", offset, u.info.Offset))
+	}
+
+	var body io.Reader = c.Request().Body
+	if u.info.Size >= 0 {
+		// One byte more than fits, to tell an oversized chunk apart
+		body = io.LimitReader(body, u.info.Size-u.info.Offset+1)
+	}
+	n, err := io.Copy(u.file, body)
+	u.info.Offset += n
+	u.touched = time.Now()
+	if u.info.Size >= 0 && u.info.Offset > u.info.Size {
+		// The overflow is not kept; sending the chunk again with the right
+		// length continues from the declared size
+		u.info.Offset = u.info.Size
+		u.file.Truncate(u.info.Size)
+		u.file.Seek(u.info.Size, io.SeekStart)
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("upload is larger than its declared size of %d bytes", u.info.Size))
+	}
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("chunk interrupted at offset %d: %v", u.info.Offset, err))
+	}
+	return c.JSON(http.StatusOK, u.info)
+}
+
+type CommitUploadRequest struct {
+	// SHA256 is the hex digest of the whole file; commit fails on mismatch
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// commitUpload serves POST /sandbox/:id/uploads/:upload/commit: the staged
+// file is written to the sandbox in one stream. If that fails the upload is
+// kept, so the commit can be retried.
+func (h *Handler) commitUpload(c echo.Context) error {
+	id := c.Param("id")
+	u := h.uploads.get(id, c.Param("upload"))
+	if u == nil {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "upload not found")
+	}
+	var req CommitUploadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	if err := u.lock(); err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+
+	if u.info.Size >= 0 && u.info.Offset != u.info.Size {
+		return newAPIError(http.StatusConflict, CodeConflict,
+			fmt.Sprintf("upload has %d of %d bytes", u.info.Offset, u.info.Size))
+	}
+	sum, err := sha256Hex(io.NewSectionReader(u.file, 0, u.info.Offset))
+	if err != nil {
+		return err
+	}
+	if req.SHA256 != "" {
+		want, err := parseSHA256(req.SHA256)
+		if err != nil {
+			return err
+		}
+		if want != sum {
+			return checksumMismatch(u.info.Path, want, sum)
+		}
+	}
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	err = h.driver.PutFile(c.Request().Context(), id, u.info.Path, u.file)
+	h.recordEvent(id, state.EventFileUpload, started, u.info.Path, err)
+	if err != nil {
+		return driverError(err)
+	}
+	h.uploads.remove(u.info.ID)
+	u.discard()
+	return c.JSON(http.StatusOK, map[string]any{"status": "uploaded", "path": u.info.Path, "size": u.info.Offset, "sha256": sum})
+}
+
+// abortUpload serves DELETE /sandbox/:id/uploads/:upload.
+func (h *Handler) abortUpload(c echo.Context) error {
+	u := h.uploads.get(c.Param("id"), c.Param("upload"))
+	if u == nil {
+		return newAPIError(http.StatusNotFound, CodeNotFound, "upload not found")
+	}
+	if err := u.lock(); err != nil {
+		return err
+	}
+	defer u.mu.Unlock()
+	h.uploads.remove(u.info.ID)
+	u.discard()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,411 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultUsageInterval is how often the usage of running sandboxes is
+// sampled.
+const DefaultUsageInterval = time.Minute
+
+// DefaultUsageKey is the key sandboxes created with the API key, or without
+// authentication, are accounted to.
+const DefaultUsageKey = "default"
+
+// Usage groupings; "label:<name>" groups by the value of a label.
+const (
+	UsageByKey     = "key"
+	UsageByImage   = "image"
+	UsageBySandbox = "sandbox"
+
+	usageByLabel = "label:"
+)
+
+// WithUsageInterval samples the usage of running sandboxes every d instead
+// of DefaultUsageInterval. Zero keeps the default.
+func WithUsageInterval(d time.Duration) Option {
+	return func(h *Handler) {
+		if d > 0 {
+			h.usageInterval = d
+		}
+	}
+}
+
+// usageMeter samples the sandboxes served here into the store's usage
+// records.
+type usageMeter struct {
+	store state.UsageStore
+
+	// mu serializes the updates of records, which read them first
+	mu sync.Mutex
+
+	// done stops the sampling when the node drains
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// startUsage starts metering usage if the store keeps it.
+func (h *Handler) startUsage() {
+	us, ok := h.store.(state.UsageStore)
+	if !ok {
+		return
+	}
+	h.usage = &usageMeter{store: us, done: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(h.usageInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.usage.done:
+				return
+			case <-ticker.C:
+				h.sampleAllUsage()
+			}
+		}
+	}()
+}
+
+// stopUsage stops sampling; usage is still recorded as sandboxes stop.
+func (h *Handler) stopUsage() {
+	if h.usage != nil {
+		h.usage.stopOnce.Do(func() { close(h.usage.done) })
+	}
+}
+
+// usageStarted opens the usage record of a sandbox that became ready.
+func (h *Handler) usageStarted(rec state.SandboxRecord, cfg driver.SandboxConfig) {
+	if h.usage == nil {
+		return
+	}
+	key := rec.Labels[OwnerLabel]
+	if key == "" {
+		key = DefaultUsageKey
+	}
+	err := h.usage.store.PutUsage(context.Background(), state.UsageRecord{
+		SandboxID: rec.ID,
+		Image:     rec.Image,
+		Key:       key,
+		Labels:    rec.Labels,
+		MemoryMB:  cfg.MemoryMB,
+		CPUCores:  cfg.CPUCores,
+		StartedAt: rec.CreatedAt,
+		SeenAt:    time.Now(),
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("id", rec.ID).Msg("Failed to record usage")
+	}
+}
+
+// sampleUsage brings the usage record of a running sandbox up to date.
+func (h *Handler) sampleUsage(ctx context.Context, id string) {
+	if h.usage == nil {
+		return
+	}
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+	u, err := h.usage.store.GetUsage(ctx, id)
+	if err != nil || u.StoppedAt != nil {
+		return
+	}
+	if sr, ok := h.driver.(driver.StatsReader); ok {
+		stats, err := sr.Stats(ctx, id)
+		if errors.Is(err, driver.ErrSandboxNotFound) || errors.Is(err, driver.ErrSandboxNotRunning) {
+			// Gone without its stop being recorded: its usage ends when it
+			// was last seen
+			return
+		}
+		if err == nil && stats.CPUNanos != nil {
+			// A restarted container counts from zero again
+			u.CPUSeconds = max(u.CPUSeconds, float64(*stats.CPUNanos)/1e9)
+		}
+	}
+	u.SeenAt = time.Now()
+	if err := h.usage.store.PutUsage(ctx, u); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to record usage")
+	}
+}
+
+// usageStopped closes the usage record of a sandbox that stopped at at.
+func (h *Handler) usageStopped(id string, at time.Time) {
+	if h.usage == nil {
+		return
+	}
+	ctx := context.Background()
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+	u, err := h.usage.store.GetUsage(ctx, id)
+	if err != nil || u.StoppedAt != nil {
+		return
+	}
+	u.StoppedAt = &at
+	if err := h.usage.store.PutUsage(ctx, u); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to record usage")
+	}
+}
+
+// usageResized records that a running sandbox was given new limits at at.
+func (h *Handler) usageResized(id string, at time.Time, cfg driver.SandboxConfig) {
+	if h.usage == nil {
+		return
+	}
+	ctx := context.Background()
+	h.usage.mu.Lock()
+	defer h.usage.mu.Unlock()
+	u, err := h.usage.store.GetUsage(ctx, id)
+	if err != nil || u.StoppedAt != nil {
+		return
+	}
+	u.Changes = append(u.Changes, state.UsageChange{At: at, MemoryMB: cfg.MemoryMB, CPUCores: cfg.CPUCores})
+	u.SeenAt = at
+	if err := h.usage.store.PutUsage(ctx, u); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("Failed to record usage")
+	}
+}
+
+// sampleAllUsage samples the running sandboxes this node serves.
+func (h *Handler) sampleAllUsage() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.usageInterval)
+	defer cancel()
+	records, err := h.store.ListSandboxes(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list sandboxes for usage")
+		return
+	}
+	for _, rec := range records {
+		if rec.State == state.SandboxReady && h.servedHere(rec) {
+			h.sampleUsage(ctx, rec.ID)
+		}
+	}
+}
+
+// UsageQuery selects the usage to report.
+type UsageQuery struct {
+	// From and To bound the period; usage of sandboxes running across
+	// them is prorated. Zero values mean the start of the current month
+	// (UTC) and now.
+	From time.Time
+	To   time.Time
+
+	// GroupBy is UsageByKey (the default), UsageByImage, UsageBySandbox
+	// or "label:<name>"
+	GroupBy string
+}
+
+// UsageTotals is what sandboxes consumed within a period.
+type UsageTotals struct {
+	Sandboxes int `json:"sandboxes"`
+
+	// CPUSeconds is the CPU time consumed, where the driver reports it
+	CPUSeconds float64 `json:"cpu_seconds"`
+
+	// MemoryMBHours is the memory allocated times how long it was
+	MemoryMBHours float64 `json:"memory_mb_hours"`
+
+	// WallSeconds is the time the sandboxes existed
+	WallSeconds float64 `json:"wall_seconds"`
+}
+
+func (t *UsageTotals) add(o UsageTotals) {
+	t.Sandboxes += o.Sandboxes
+	t.CPUSeconds += o.CPUSeconds
+	t.MemoryMBHours += o.MemoryMBHours
+	t.WallSeconds += o.WallSeconds
+}
+
+// UsageGroup is the usage of the sandboxes sharing a key, image, label
+// value or ID.
+type UsageGroup struct {
+	Group string `json:"group"`
+	UsageTotals
+}
+
+// UsageReport is the usage of a period, by group.
+type UsageReport struct {
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	GroupBy string       `json:"group_by"`
+	Groups  []UsageGroup `json:"groups"`
+	Total   UsageTotals  `json:"total"`
+}
+
+// getUsage serves GET /usage?from=&to=&group_by=, as JSON or, with
+// format=csv or an Accept header asking for text/csv, as a CSV file.
+func (h *Handler) getUsage(c echo.Context) error {
+	var q UsageQuery
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		v := c.QueryParam(name)
+		if v == "" {
+			continue
+		}
+		var err error
+		if *t, err = parseUsageTime(v); err != nil {
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name))
+		}
+	}
+	q.GroupBy = c.QueryParam("group_by")
+
+	report, err := h.Usage(c.Request().Context(), q)
+	if err != nil {
+		return err
+	}
+	if c.QueryParam("format") != "csv" && !strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv") {
+		return c.JSON(http.StatusOK, report)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`,
+		report.From.Format("20060102"), report.To.Format("20060102")))
+	res.WriteHeader(http.StatusOK)
+	w := csv.NewWriter(res)
+	w.Write([]string{report.GroupBy, "sandboxes", "cpu_seconds", "memory_mb_hours", "wall_seconds"})
+	for _, g := range report.Groups {
+		w.Write([]string{
+			g.Group,
+			strconv.Itoa(g.Sandboxes),
+			strconv.FormatFloat(g.CPUSeconds, 'f', 3, 64),
+			strconv.FormatFloat(g.MemoryMBHours, 'f', 3, 64),
+			strconv.FormatFloat(g.WallSeconds, 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseUsageTime parses an RFC 3339 time or a date, which is midnight UTC.
+func parseUsageTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
+
+// Usage reports the resources sandboxes consumed within a period, grouped
+// by the key that created them, image, label value or sandbox. Running
+// sandboxes are counted up to their last sample. Callers without the admin
+// scope see their own sandboxes only. It is the transport independent core
+// of GET /usage; errors are *APIError.
+func (h *Handler) Usage(ctx context.Context, q UsageQuery) (*UsageReport, error) {
+	if h.usage == nil {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "the state store does not keep usage")
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		y, m, _ := q.To.UTC().Date()
+		q.From = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if !q.From.Before(q.To) {
+		return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "from must be before to")
+	}
+	if q.GroupBy == "" {
+		q.GroupBy = UsageByKey
+	}
+	group, err := usageGrouping(q.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := h.usage.store.ListUsage(ctx, q.From, q.To)
+	if err != nil {
+		return nil, wrapAPIError(http.StatusInternalServerError, CodeInternal, "failed to list usage", err)
+	}
+	groups := map[string]*UsageGroup{}
+	report := &UsageReport{From: q.From, To: q.To, GroupBy: q.GroupBy, Groups: []UsageGroup{}}
+	for _, u := range records {
+		if !owns(ctx, u.Labels) {
+			continue
+		}
+		t, ok := usageWithin(u, q.From, q.To)
+		if !ok {
+			continue
+		}
+		name := group(u)
+		g := groups[name]
+		if g == nil {
+			g = &UsageGroup{Group: name}
+			groups[name] = g
+		}
+		g.add(t)
+		report.Total.add(t)
+	}
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	slices.SortFunc(report.Groups, func(a, b UsageGroup) int { return strings.Compare(a.Group, b.Group) })
+	return report, nil
+}
+
+// usageGrouping returns what groups records for groupBy.
+func usageGrouping(groupBy string) (func(state.UsageRecord) string, error) {
+	switch {
+	case groupBy == UsageByKey:
+		return func(u state.UsageRecord) string { return u.Key }, nil
+	case groupBy == UsageByImage:
+		return func(u state.UsageRecord) string { return u.Image }, nil
+	case groupBy == UsageBySandbox:
+		return func(u state.UsageRecord) string { return u.SandboxID }, nil
+	case strings.HasPrefix(groupBy, usageByLabel) && len(groupBy) > len(usageByLabel):
+		label := strings.TrimPrefix(groupBy, usageByLabel)
+		return func(u state.UsageRecord) string { return u.Labels[label] }, nil
+	}
+	return nil, newAPIError(http.StatusBadRequest, CodeInvalidRequest,
+		fmt.Sprintf("unsupported group_by %q: want key, image, sandbox or label:<name>", groupBy))
+}
+
+// usageWithin returns the part of u's usage that falls between from and
+// to. CPU time is spread evenly over the sandbox's lifetime.
+func usageWithin(u state.UsageRecord, from, to time.Time) (UsageTotals, bool) {
+	start, end := u.StartedAt, u.End()
+	lifetime := end.Sub(start)
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	overlap := end.Sub(start)
+	if overlap <= 0 {
+		return UsageTotals{}, false
+	}
+	share := overlap.Seconds() / lifetime.Seconds()
+
+	// Memory is counted at the allocation of each part of the overlap
+	var mbHours float64
+	memory, since := u.MemoryMB, u.StartedAt
+	for _, c := range append(u.Changes[:len(u.Changes):len(u.Changes)], state.UsageChange{At: end}) {
+		from, to := since, c.At
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if part := to.Sub(from); part > 0 {
+			mbHours += float64(memory) * part.Hours()
+		}
+		memory, since = c.MemoryMB, c.At
+	}
+	return UsageTotals{
+		Sandboxes:     1,
+		CPUSeconds:    u.CPUSeconds * share,
+		MemoryMBHours: mbHours,
+		WallSeconds:   overlap.Seconds(),
+	}, true
+}
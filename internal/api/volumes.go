@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// VolumeRequest is the body of POST /volumes.
+type VolumeRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *Handler) volumeManager() (driver.VolumeManager, error) {
+	vm, ok := h.ids.Backend().(driver.VolumeManager)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support volumes")
+	}
+	return vm, nil
+}
+
+// ownedVolume returns volume name if the caller may use it: it created it,
+// or is an admin. Others get the error of a volume that does not exist.
+func ownedVolume(ctx context.Context, vm driver.VolumeManager, name string) (*driver.VolumeInfo, error) {
+	info, err := vm.GetVolume(ctx, name)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	if !owns(ctx, info.Labels) {
+		return nil, driverError(fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, name))
+	}
+	return info, nil
+}
+
+func (h *Handler) createVolume(c echo.Context) error {
+	vm, err := h.volumeManager()
+	if err != nil {
+		return err
+	}
+	var req VolumeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+
+	labels, err := ownerLabels(c.Request().Context(), nil)
+	if err != nil {
+		return err
+	}
+	info, err := vm.CreateVolume(c.Request().Context(), req.Name, labels)
+	if err != nil {
+		return driverError(err)
+	}
+	audit(c.Request().Context(), req.Name, "Volume created")
+	return c.JSON(http.StatusCreated, info)
+}
+
+func (h *Handler) listVolumes(c echo.Context) error {
+	vm, err := h.volumeManager()
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	all, err := vm.ListVolumes(ctx)
+	if err != nil {
+		return driverError(err)
+	}
+	list := []*driver.VolumeInfo{}
+	for _, info := range all {
+		if owns(ctx, info.Labels) {
+			list = append(list, info)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"volumes": list})
+}
+
+func (h *Handler) getVolume(c echo.Context) error {
+	vm, err := h.volumeManager()
+	if err != nil {
+		return err
+	}
+	info, err := ownedVolume(c.Request().Context(), vm, c.Param("name"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *Handler) deleteVolume(c echo.Context) error {
+	vm, err := h.volumeManager()
+	if err != nil {
+		return err
+	}
+	if _, err := ownedVolume(c.Request().Context(), vm, c.Param("name")); err != nil {
+		return err
+	}
+	if err := vm.DeleteVolume(c.Request().Context(), c.Param("name")); err != nil {
+		return driverError(err)
+	}
+	audit(c.Request().Context(), c.Param("name"), "Volume deleted")
+	return c.NoContent(http.StatusNoContent)
+}
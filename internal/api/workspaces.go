@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// WorkspaceRequest is the body of POST /workspaces and PUT /workspaces/:name.
+type WorkspaceRequest struct {
+	Name  string                 `json:"name"`
+	Files []driver.FileInjection `json:"files"`
+
+	// Image fills the workspace on drivers that need a container to do so;
+	// it defaults to the default sandbox image
+	Image string `json:"image"`
+}
+
+func (h *Handler) workspaceManager() (driver.WorkspaceManager, error) {
+	wm, ok := h.ids.Backend().(driver.WorkspaceManager)
+	if !ok {
+		return nil, newAPIError(http.StatusNotImplemented, CodeNotImplemented, "driver does not support workspaces")
+	}
+	return wm, nil
+}
+
+// ownedWorkspace returns workspace name if the caller may use it: it
+// created it, or is an admin. Others get the error of a workspace that does
+// not exist.
+func ownedWorkspace(ctx context.Context, wm driver.WorkspaceManager, name string) (*driver.WorkspaceInfo, error) {
+	info, err := wm.GetWorkspace(ctx, name)
+	if err != nil {
+		return nil, driverError(err)
+	}
+	if !owns(ctx, info.Labels) {
+		return nil, driverError(driver.ErrWorkspaceNotFound)
+	}
+	return info, nil
+}
+
+func (h *Handler) createWorkspace(c echo.Context) error {
+	wm, err := h.workspaceManager()
+	if err != nil {
+		return err
+	}
+	var req WorkspaceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	if req.Image == "" {
+		req.Image = h.templates.Default().Image
+	}
+	labels, err := ownerLabels(c.Request().Context(), nil)
+	if err != nil {
+		return err
+	}
+
+	info, err := wm.CreateWorkspace(c.Request().Context(), driver.WorkspaceSpec{Name: req.Name, Files: req.Files, Image: req.Image, Labels: labels})
+	if err != nil {
+		return driverError(err)
+	}
+	audit(c.Request().Context(), req.Name, "Workspace created")
+	return c.JSON(http.StatusCreated, info)
+}
+
+// replaceWorkspace serves PUT /workspaces/:name. The workspace is deleted
+// and created again, so it must not be in use.
+func (h *Handler) replaceWorkspace(c echo.Context) error {
+	wm, err := h.workspaceManager()
+	if err != nil {
+		return err
+	}
+	var req WorkspaceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request").SetInternal(err)
+	}
+	req.Name = c.Param("name")
+	if req.Image == "" {
+		req.Image = h.templates.Default().Image
+	}
+	if err := driver.ValidateWorkspaceName(req.Name); err != nil {
+		return driverError(err)
+	}
+
+	// A replaced workspace keeps its owner; a new one is the caller's
+	ctx := c.Request().Context()
+	labels, err := ownerLabels(ctx, nil)
+	if err != nil {
+		return err
+	}
+	switch old, err := wm.GetWorkspace(ctx, req.Name); {
+	case err == nil && !owns(ctx, old.Labels):
+		return driverError(driver.ErrWorkspaceNotFound)
+	case err == nil:
+		labels = old.Labels
+	case !errors.Is(err, driver.ErrWorkspaceNotFound):
+		return driverError(err)
+	}
+	if err := wm.DeleteWorkspace(ctx, req.Name); err != nil && !errors.Is(err, driver.ErrWorkspaceNotFound) {
+		return driverError(err)
+	}
+	info, err := wm.CreateWorkspace(ctx, driver.WorkspaceSpec{Name: req.Name, Files: req.Files, Image: req.Image, Labels: labels})
+	if err != nil {
+		return driverError(err)
+	}
+	audit(ctx, req.Name, "Workspace replaced")
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *Handler) listWorkspaces(c echo.Context) error {
+	wm, err := h.workspaceManager()
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	all, err := wm.ListWorkspaces(ctx)
+	if err != nil {
+		return driverError(err)
+	}
+	list := []*driver.WorkspaceInfo{}
+	for _, info := range all {
+		if owns(ctx, info.Labels) {
+			list = append(list, info)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"workspaces": list})
+}
+
+func (h *Handler) getWorkspace(c echo.Context) error {
+	wm, err := h.workspaceManager()
+	if err != nil {
+		return err
+	}
+	info, err := ownedWorkspace(c.Request().Context(), wm, c.Param("name"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+func (h *Handler) deleteWorkspace(c echo.Context) error {
+	wm, err := h.workspaceManager()
+	if err != nil {
+		return err
+	}
+	if _, err := ownedWorkspace(c.Request().Context(), wm, c.Param("name")); err != nil {
+		return err
+	}
+	if err := wm.DeleteWorkspace(c.Request().Context(), c.Param("name")); err != nil {
+		return driverError(err)
+	}
+	audit(c.Request().Context(), c.Param("name"), "Workspace deleted")
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,177 @@
+// Package artifacts implements a content-addressable store for the files
+// execs return as artifacts.
+//
+// Blobs are named by the SHA-256 of their contents, so a file that many
+// execs or sandboxes generate identically is kept once. Every stored
+// artifact is a reference held by the sandbox that produced it; Release
+// drops a sandbox's references and a blob is deleted with its last one.
+package artifacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNotFound is returned for digests the store does not hold.
+var ErrNotFound = errors.New("artifact not found")
+
+// Store keeps blobs under dir/sha256/<first two hex digits>/<digest>.
+// Reference counts live in memory.
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	blobs map[string]*blob
+	// owners counts the references each sandbox holds per digest
+	owners map[string]map[string]int
+}
+
+type blob struct {
+	size int64
+	refs int
+}
+
+// Stats describes the store contents.
+type Stats struct {
+	// Blobs and Bytes are what is stored on disk
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+
+	// Refs and LogicalBytes are what storing every artifact separately
+	// would take
+	Refs         int   `json:"refs"`
+	LogicalBytes int64 `json:"logical_bytes"`
+}
+
+// Open prepares a store in dir. References are not persisted, so blobs left
+// by a previous process are unreferenced and removed.
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"sha256", "tmp"} {
+		if err := os.RemoveAll(filepath.Join(dir, sub)); err != nil {
+			return nil, fmt.Errorf("failed to clear artifact store: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create artifact store: %w", err)
+		}
+	}
+	return &Store{
+		dir:    dir,
+		blobs:  make(map[string]*blob),
+		owners: make(map[string]map[string]int),
+	}, nil
+}
+
+func (s *Store) path(digest string) string {
+	return filepath.Join(s.dir, "sha256", digest[:2], digest)
+}
+
+// Put stores content as a reference held by sandboxID and returns its hex
+// digest and size. dup is true if identical content was already stored.
+func (s *Store) Put(sandboxID string, content io.Reader) (digest string, size int64, dup bool, err error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "blob-")
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	h := sha256.New()
+	size, err = io.Copy(io.MultiWriter(tmp, h), content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to write artifact: %w", err)
+	}
+	digest = hex.EncodeToString(h.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, dup := s.blobs[digest]
+	if !dup {
+		if err := os.MkdirAll(filepath.Dir(s.path(digest)), 0755); err != nil {
+			return "", 0, false, err
+		}
+		if err := os.Rename(tmp.Name(), s.path(digest)); err != nil {
+			return "", 0, false, fmt.Errorf("failed to store artifact: %w", err)
+		}
+		b = &blob{size: size}
+		s.blobs[digest] = b
+	}
+	b.refs++
+
+	if s.owners[sandboxID] == nil {
+		s.owners[sandboxID] = make(map[string]int)
+	}
+	s.owners[sandboxID][digest]++
+	return digest, size, dup, nil
+}
+
+// Open returns the contents of a blob and its size.
+func (s *Store) Open(digest string) (io.ReadCloser, int64, error) {
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != sha256.Size*2 {
+		return nil, 0, ErrNotFound
+	}
+
+	s.mu.Lock()
+	b, ok := s.blobs[digest]
+	s.mu.Unlock()
+	if !ok {
+		return nil, 0, ErrNotFound
+	}
+
+	// An open file stays readable even if Release removes it meanwhile
+	f, err := os.Open(s.path(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, b.size, nil
+}
+
+// Release drops every reference held by sandboxID and deletes the blobs no
+// longer referenced. It returns the bytes freed on disk.
+func (s *Store) Release(sandboxID string) (freed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for digest, n := range s.owners[sandboxID] {
+		b := s.blobs[digest]
+		if b == nil {
+			continue
+		}
+		b.refs -= n
+		if b.refs > 0 {
+			continue
+		}
+		delete(s.blobs, digest)
+		if err := os.Remove(s.path(digest)); err == nil {
+			freed += b.size
+		}
+	}
+	delete(s.owners, sandboxID)
+	return freed
+}
+
+// Stats returns the current store contents.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st Stats
+	for _, b := range s.blobs {
+		st.Blobs++
+		st.Bytes += b.size
+		st.Refs += b.refs
+		st.LogicalBytes += b.size * int64(b.refs)
+	}
+	return st
+}
@@ -0,0 +1,419 @@
+// Package auth verifies bearer tokens issued by an OpenID Connect provider.
+//
+// Only what Boxed needs is implemented: discovery of the JWKS, RSA and ECDSA
+// signatures, and the iss, aud, exp and nbf checks.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// DefaultOrgClaim is the claim read as the caller's organisation
+	DefaultOrgClaim = "org"
+
+	// leeway absorbs clock skew between Boxed and the issuer
+	leeway = time.Minute
+
+	// keyRefreshInterval limits JWKS fetches caused by unknown key IDs
+	keyRefreshInterval = time.Minute
+
+	// keyMaxAge refetches the JWKS so rotated-out keys stop being trusted
+	keyMaxAge = time.Hour
+)
+
+// Config configures an OIDCVerifier.
+type Config struct {
+	// Issuer is the provider URL; tokens must carry it as "iss"
+	Issuer string
+
+	// Audience, if set, must be in the token's "aud"
+	Audience string
+
+	// JWKSURL overrides the jwks_uri found by discovery
+	JWKSURL string
+
+	// OrgClaim names the claim holding the organisation (default: "org")
+	OrgClaim string
+
+	// HTTPClient fetches discovery and keys (default: 10s timeout)
+	HTTPClient *http.Client
+}
+
+// ScopeAdmin lets a caller see and manage every sandbox, not only those
+// it created.
+const ScopeAdmin = "boxed:admin"
+
+// Claims identifies the caller of a request.
+type Claims struct {
+	Subject   string    `json:"sub"`
+	Org       string    `json:"org,omitempty"`
+	Issuer    string    `json:"iss"`
+	ExpiresAt time.Time `json:"exp"`
+
+	// Scopes are those of the token's "scope" or "scp" claim
+	Scopes []string `json:"scope,omitempty"`
+}
+
+// HasScope reports whether the caller was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// OIDCVerifier validates JWTs against the signing keys of one issuer.
+type OIDCVerifier struct {
+	cfg     Config
+	jwksURL string
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewOIDCVerifier discovers the issuer's keys. It fails if the issuer
+// cannot be reached, so a misconfiguration shows at startup.
+func NewOIDCVerifier(ctx context.Context, cfg Config) (*OIDCVerifier, error) {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc: issuer is required")
+	}
+	if cfg.OrgClaim == "" {
+		cfg.OrgClaim = DefaultOrgClaim
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	v := &OIDCVerifier{cfg: cfg, jwksURL: cfg.JWKSURL}
+	if v.jwksURL == "" {
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, cfg.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+			return nil, fmt.Errorf("oidc: discovery: %w", err)
+		}
+		if strings.TrimSuffix(doc.Issuer, "/") != cfg.Issuer {
+			return nil, fmt.Errorf("oidc: discovery returned issuer %q, want %q", doc.Issuer, cfg.Issuer)
+		}
+		if doc.JWKSURI == "" {
+			return nil, errors.New("oidc: discovery document has no jwks_uri")
+		}
+		v.jwksURL = doc.JWKSURI
+	}
+	if err := v.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Verify checks the signature and claims of a compact JWT. Every failure
+// wraps ErrInvalidToken.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var payload map[string]any
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrInvalidToken, err)
+	}
+	return v.checkClaims(payload)
+}
+
+func (v *OIDCVerifier) checkClaims(payload map[string]any) (*Claims, error) {
+	now := time.Now()
+	c := &Claims{}
+	c.Issuer, _ = payload["iss"].(string)
+	c.Subject, _ = payload["sub"].(string)
+	c.Org, _ = payload[v.cfg.OrgClaim].(string)
+	c.Scopes = scopes(payload)
+
+	if strings.TrimSuffix(c.Issuer, "/") != v.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	exp, ok := payload["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	c.ExpiresAt = time.Unix(int64(exp), 0)
+	if now.After(c.ExpiresAt.Add(leeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := payload["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if v.cfg.Audience != "" && !hasAudience(payload["aud"], v.cfg.Audience) {
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+// scopes returns the scopes of the space-separated "scope" claim of RFC
+// 8693 or of "scp", a list or string some providers use instead.
+func scopes(payload map[string]any) []string {
+	if s, ok := payload["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	switch scp := payload["scp"].(type) {
+	case string:
+		return strings.Fields(scp)
+	case []any:
+		var out []string
+		for _, v := range scp {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// hasAudience reports whether aud, a string or a list of strings, holds want.
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the signing key kid, refetching the JWKS when the key is
+// unknown (the issuer rotated keys) or the cached set is old.
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.lookup(kid)
+	stale := time.Since(v.fetchedAt) > keyMaxAge
+	canRefresh := time.Since(v.fetchedAt) > keyRefreshInterval
+	v.mu.Unlock()
+
+	if (!ok && canRefresh) || stale {
+		if err := v.refreshKeys(ctx); err != nil && !ok {
+			return nil, err
+		}
+		v.mu.Lock()
+		key, ok = v.lookup(kid)
+		v.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// lookup finds kid; tokens without one match a set with a single key.
+// v.mu must be held.
+func (v *OIDCVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, true
+		}
+	}
+	k, ok := v.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *OIDCVerifier) refreshKeys(ctx context.Context) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.jwksURL, &set); err != nil {
+		return fmt.Errorf("oidc: fetch keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped, not fatal
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("oidc: no usable signing keys")
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %s does not match an EC key", alg)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("bad key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a context carrying the caller's claims.
+func WithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// FromContext returns the claims of an authenticated caller, or nil.
+func FromContext(ctx context.Context) *Claims {
+	c, _ := ctx.Value(claimsKey{}).(*Claims)
+	return c
+}
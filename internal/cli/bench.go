@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/akshayaggarwal99/boxed/tests/bench"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchServer      string
+	benchTemplate    string
+	benchIterations  int
+	benchFileSize    int64
+	benchConcurrency []int
+	benchLanguage    string
+	benchCode        string
+	benchReport      string
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure the latency and throughput of a running server",
+	Long: `Measure a running server end to end: cold create latency, warm claim
+latency when the server has a warm pool, exec round trips, file upload and
+download throughput, and exec throughput as concurrent workers are added.
+The sandboxes it creates are deleted when it is done.
+
+--report writes the report as JSON, to compare runs before and after a
+pool or driver change.`,
+	Example: `  boxed bench --iterations 20 --concurrency 1,4,16 --report before.json`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		report, err := bench.Run(ctx, bench.Config{
+			Server:      benchServer,
+			APIKey:      apiKey,
+			Template:    benchTemplate,
+			Iterations:  benchIterations,
+			FileSize:    benchFileSize,
+			Concurrency: benchConcurrency,
+			Language:    benchLanguage,
+			Code:        benchCode,
+			Progress: func(msg string) {
+				if !structured() {
+					fmt.Fprintf(os.Stderr, "Measuring %s...\n", msg)
+				}
+			},
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+
+		if benchReport != "" {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			if err := os.WriteFile(benchReport, append(data, '\n'), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		printResult(report, func() { printBench(os.Stdout, report) })
+	},
+}
+
+func printBench(out io.Writer, r *bench.Report) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MEASUREMENT\tSAMPLES\tERRORS\tMEAN\tP50\tP90\tP99\tMAX\tRATE")
+	row := func(name string, l bench.Latency, rate string) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%.1fms\t%s\n",
+			name, l.Samples, l.Errors, l.MeanMS, l.P50MS, l.P90MS, l.P99MS, l.MaxMS, rate)
+	}
+	row("cold create", r.ColdCreate, "")
+	if r.WarmClaim != nil {
+		row("warm claim", *r.WarmClaim, "")
+	}
+	row("exec", r.Exec, "")
+	row("upload", r.Upload.Latency, fmt.Sprintf("%.1f MB/s", r.Upload.MBPerSec))
+	row("download", r.Download.Latency, fmt.Sprintf("%.1f MB/s", r.Download.MBPerSec))
+	for _, c := range r.Concurrency {
+		row(fmt.Sprintf("exec x%d", c.Workers), c.Latency, fmt.Sprintf("%.1f execs/s", c.ExecsPerSec))
+	}
+	w.Flush()
+
+	for _, name := range slices.Sorted(maps.Keys(r.Skipped)) {
+		fmt.Fprintf(out, "Skipped %s: %s\n", name, r.Skipped[name])
+	}
+	for _, m := range []struct {
+		name string
+		l    bench.Latency
+	}{{"cold create", r.ColdCreate}, {"exec", r.Exec}, {"upload", r.Upload.Latency}, {"download", r.Download.Latency}} {
+		if m.l.Error != "" {
+			fmt.Fprintf(out, "First %s error: %s\n", m.name, m.l.Error)
+		}
+	}
+}
+
+func init() {
+	benchCmd.Flags().StringVar(&benchServer, "server", envString("BOXED_URL", client.DefaultBaseURL), "URL of the server to measure")
+	benchCmd.Flags().StringVar(&benchTemplate, "template", "", "Template of the sandboxes (default: the server's default)")
+	benchCmd.Flags().IntVar(&benchIterations, "iterations", bench.DefaultIterations, "Samples per measurement, and execs per worker when measuring concurrency")
+	benchCmd.Flags().Int64Var(&benchFileSize, "file-size", bench.DefaultFileSize, "Size in bytes of the file uploaded and downloaded")
+	benchCmd.Flags().IntSliceVar(&benchConcurrency, "concurrency", bench.DefaultConcurrency, "Numbers of concurrent workers to measure exec throughput at")
+	benchCmd.Flags().StringVar(&benchLanguage, "language", bench.DefaultLanguage, "Language of the execs")
+	benchCmd.Flags().StringVar(&benchCode, "code", bench.DefaultCode, "Code the execs run")
+	benchCmd.Flags().StringVar(&benchReport, "report", "", "Also write the report as JSON to this file")
+	RootCmd.AddCommand(benchCmd)
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// completionTimeout bounds the API calls made while completing, so that a
+// server that is down does not hang the shell.
+const completionTimeout = 2 * time.Second
+
+// completionGet fetches an API path from the local server into v.
+func completionGet(apiPath string, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080"+apiPath, nil)
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("X-Boxed-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// sandboxCompletions returns the running sandboxes whose ID starts with
+// prefix, described by image and labels, with suffix appended to each ID.
+func sandboxCompletions(prefix, suffix string) []cobra.Completion {
+	var result struct {
+		Sandboxes []struct {
+			ID     string `json:"id"`
+			Config struct {
+				Image  string            `json:"image"`
+				Labels map[string]string `json:"labels"`
+			} `json:"config"`
+		} `json:"sandboxes"`
+	}
+	if err := completionGet("/v1/sandbox?state=ready", &result); err != nil {
+		cobra.CompDebugln("listing sandboxes: "+err.Error(), true)
+		return nil
+	}
+
+	var completions []cobra.Completion
+	for _, s := range result.Sandboxes {
+		if !strings.HasPrefix(s.ID, prefix) {
+			continue
+		}
+		desc := s.Config.Image
+		for k, v := range s.Config.Labels {
+			desc += " " + k + "=" + v
+		}
+		completions = append(completions, cobra.CompletionWithDesc(s.ID+suffix, desc))
+	}
+	return completions
+}
+
+// remotePathCompletions lists the entries of the sandbox directory that
+// toComplete, an absolute path, is in. Directories end in a slash so that
+// completion can go on into them; each completion starts with prefix.
+func remotePathCompletions(id, toComplete, prefix string) []cobra.Completion {
+	if toComplete == "" {
+		toComplete = "/"
+	}
+	if !strings.HasPrefix(toComplete, "/") {
+		return nil
+	}
+	dir, base := toComplete, ""
+	if !strings.HasSuffix(toComplete, "/") {
+		dir, base = path.Split(toComplete)
+	}
+
+	var result struct {
+		Files []struct {
+			Name  string `json:"name"`
+			Path  string `json:"path"`
+			IsDir bool   `json:"is_dir"`
+		} `json:"files"`
+	}
+	query := url.Values{"path": {dir}}
+	if err := completionGet(fmt.Sprintf("/v1/sandbox/%s/files?%s", url.PathEscape(id), query.Encode()), &result); err != nil {
+		cobra.CompDebugln("listing "+dir+": "+err.Error(), true)
+		return nil
+	}
+
+	var completions []cobra.Completion
+	for _, f := range result.Files {
+		// The listing is recursive, with paths starting at the directory's
+		// own name; only its direct entries are wanted
+		if strings.Count(strings.Trim(f.Path, "/"), "/") != 1 || !strings.HasPrefix(f.Name, base) {
+			continue
+		}
+		p := dir + f.Name
+		if f.IsDir {
+			p += "/"
+		}
+		completions = append(completions, prefix+p)
+	}
+	return completions
+}
+
+// completeSandboxID completes the sandbox ID a command takes first.
+func completeSandboxID(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return sandboxCompletions(toComplete, ""), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSandboxPath completes "[sandbox-id] [path]" as well as the
+// scp-style "sandbox-id:path".
+func completeSandboxPath(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	pathDirective := cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	switch {
+	case len(args) == 0:
+		if id, p, ok := strings.Cut(toComplete, ":"); ok {
+			return remotePathCompletions(id, p, id+":"), pathDirective
+		}
+		return sandboxCompletions(toComplete, ""), cobra.ShellCompDirectiveNoFileComp
+	case len(args) == 1 && splitRemote(args[0]) == nil:
+		return remotePathCompletions(args[0], toComplete, ""), pathDirective
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeLocalToRemote completes "[local-path] [sandbox-id]:[remote-path]":
+// local files first, then a sandbox ID followed by a colon and its files.
+func completeLocalToRemote(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return nil, cobra.ShellCompDirectiveDefault
+	case 1:
+		directive := cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+		if id, p, ok := strings.Cut(toComplete, ":"); ok {
+			return remotePathCompletions(id, p, id+":"), directive
+		}
+		return sandboxCompletions(toComplete, ":"), directive
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	createTemplate string
+	createTimeout  int
+	createPlatform string
+	createLabels   []string
+
+	createDNS        []string
+	createDNSSearch  []string
+	createDNSOptions []string
+	createHosts      []string
+)
+
+// createResult is what create prints with -o json|yaml.
+type createResult struct {
+	SandboxID string   `json:"sandbox_id"`
+	Status    string   `json:"status"`
+	Platform  string   `json:"platform,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// parseLabels turns key=value pairs into the metadata of a create.
+func parseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("--label %q is not key=value", pair)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// parseHosts turns host:ip pairs into the extra hosts of a create. The
+// address is everything after the first colon, so IPv6 ones need no
+// brackets.
+func parseHosts(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	hosts := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		host, addr, ok := strings.Cut(pair, ":")
+		if !ok || host == "" || addr == "" {
+			return nil, fmt.Errorf("--add-host %q is not host:ip", pair)
+		}
+		hosts[host] = addr
+	}
+	return hosts, nil
+}
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a sandbox that outlives the command",
+	Long: `Create a sandbox and print its ID, for later commands such as fs, repl or
+history to use. It runs until --timeout passes or it is stopped with
+boxed stop.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		labels, err := parseLabels(createLabels)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		hosts, err := parseHosts(createHosts)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		payload := map[string]any{
+			"template": createTemplate,
+			"timeout":  createTimeout,
+		}
+		if labels != nil {
+			payload["metadata"] = labels
+		}
+		if createPlatform != "" {
+			payload["platform"] = createPlatform
+		}
+		if len(createDNS) > 0 {
+			payload["dns"] = createDNS
+		}
+		if len(createDNSSearch) > 0 {
+			payload["dns_search"] = createDNSSearch
+		}
+		if len(createDNSOptions) > 0 {
+			payload["dns_options"] = createDNSOptions
+		}
+		if hosts != nil {
+			payload["extra_hosts"] = hosts
+		}
+		body, _ := json.Marshal(payload)
+
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/v1/sandbox", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("X-Boxed-API-Key", apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result createResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		printResult(result, func() {
+			for _, w := range result.Warnings {
+				fmt.Fprintf(os.Stderr, "⚠️  %s\n", w)
+			}
+			// Only the ID goes to stdout, for $(boxed create)
+			fmt.Println(result.SandboxID)
+		})
+	},
+}
+
+func init() {
+	createCmd.Flags().StringVarP(&createTemplate, "template", "t", "", "Sandbox template or image (default: the server's default template)")
+	createCmd.Flags().IntVar(&createTimeout, "timeout", 0, "Lifetime in seconds (default: the template's)")
+	createCmd.Flags().StringVar(&createPlatform, "platform", "", "Image platform, e.g. linux/amd64 (default: the host's)")
+	createCmd.Flags().StringArrayVarP(&createLabels, "label", "l", nil, "Label the sandbox, as key=value; repeatable")
+	createCmd.Flags().StringArrayVar(&createDNS, "dns", nil, "Name server IP address for the sandbox's resolv.conf; repeatable, up to 3")
+	createCmd.Flags().StringArrayVar(&createDNSSearch, "dns-search", nil, "DNS search domain; repeatable")
+	createCmd.Flags().StringArrayVar(&createDNSOptions, "dns-option", nil, "resolv.conf option, e.g. ndots:2; repeatable")
+	createCmd.Flags().StringArrayVar(&createHosts, "add-host", nil, "Add host:ip to the sandbox's /etc/hosts (ip may be host-gateway); repeatable")
+	RootCmd.AddCommand(createCmd)
+}
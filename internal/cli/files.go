@@ -6,8 +6,10 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -19,6 +21,14 @@ var filesCmd = &cobra.Command{
 	Short: "Manage files in a sandbox",
 }
 
+// fileEntry is a file as printed by fs ls -o json|yaml.
+type fileEntry struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	IsDir        bool      `json:"is_dir"`
+	LastModified time.Time `json:"last_modified"`
+}
+
 var lsCmd = &cobra.Command{
 	Use:   "ls [sandbox-id] [path]",
 	Short: "List files in directory",
@@ -48,25 +58,34 @@ var lsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		var files []struct {
-			Name         string    `json:"name"`
-			Size         int64     `json:"size"`
-			IsDir        bool      `json:"is_dir"`
-			LastModified time.Time `json:"last_modified"`
+		files := []fileEntry{}
+		if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
 		}
-		json.NewDecoder(resp.Body).Decode(&files)
 
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-		fmt.Fprintln(w, "MODE\tSIZE\tUPDATED\tNAME")
-		for _, f := range files {
-			mode := "-rw-r--r--"
-			if f.IsDir {
-				mode = "drwxr-xr-x"
+		printResult(files, func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "MODE\tSIZE\tUPDATED\tNAME")
+			for _, f := range files {
+				mode := "-rw-r--r--"
+				if f.IsDir {
+					mode = "drwxr-xr-x"
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", mode, f.Size, f.LastModified.Format(time.RFC822), f.Name)
 			}
-			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", mode, f.Size, f.LastModified.Format(time.RFC822), f.Name)
-		}
-		w.Flush()
+			w.Flush()
+		})
 	},
+	ValidArgsFunction: completeSandboxPath,
+}
+
+// uploaded is what fs cp and fs sync print with -o json|yaml.
+type uploaded struct {
+	SandboxID string `json:"sandbox_id"`
+	// Path is the sandbox directory the files were uploaded into
+	Path  string `json:"path"`
+	Files int    `json:"files"`
 }
 
 var putCmd = &cobra.Command{
@@ -94,63 +113,15 @@ var putCmd = &cobra.Command{
 		id := parts[0]
 		remotePath := parts[1]
 
-		// Upload
-		file, err := os.Open(localPath)
-		if err != nil {
-			fmt.Printf("Failed to open local file: %v\n", err)
-			os.Exit(1)
-		}
-		defer file.Close()
-
-		// Prepare Multipart
-		r, w := io.Pipe()
-		m := multipart.NewWriter(w)
-
-		go func() {
-			defer w.Close()
-			defer m.Close()
-
-			// We need to set "path" field?
-			// The handler expects form value "path" to be definition of destination directory?
-			// Or full path?
-			// Handler: `fullPath := fmt.Sprintf("%s/%s", strings.TrimSuffix(path, "/"), file.Filename)`
-			// So "path" param should be the DIRECTORY.
-
-			// If remotePath is "/foo/bar.txt", handler logic implies we pass "/foo" and filename "bar.txt".
-			// Let's just pass filepath.Dir(remotePath) ?
-			// Actually let's assume remotePath is the DIRECTORY where we want to put it.
-			// But cp usually allows renaming.
-			// Let's rely on standard practice: If remotePath ends in /, it's a dir.
-			// If not, it's a file rename?
-			// Handler logic currently forces appending filename. So we CANNOT rename.
-			// MVP: remotePath IS the directory.
-
-			m.WriteField("path", remotePath)
-
-			part, err := m.CreateFormFile("file", filepath.Base(localPath))
-			if err != nil {
-				return
-			}
-			io.Copy(part, file)
-		}()
-
-		req, _ := http.NewRequest("POST", fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/files", id), r)
-		req.Header.Set("Content-Type", m.FormDataContentType())
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
+		if err := uploadFile(id, localPath, remotePath); err != nil {
 			fmt.Printf("Upload failed: %v\n", err)
 			os.Exit(1)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Error: %s\n", resp.Status)
-			io.Copy(os.Stderr, resp.Body)
-			os.Exit(1)
-		}
-		fmt.Printf("Uploaded to %s:%s\n", id, remotePath)
+		printResult(uploaded{SandboxID: id, Path: remotePath, Files: 1}, func() {
+			fmt.Printf("Uploaded to %s:%s\n", id, remotePath)
+		})
 	},
+	ValidArgsFunction: completeLocalToRemote,
 }
 
 var getCmd = &cobra.Command{
@@ -189,15 +160,170 @@ var getCmd = &cobra.Command{
 
 		io.Copy(os.Stdout, resp.Body)
 	},
+	ValidArgsFunction: completeSandboxPath,
+}
+
+// filesUsage is the response of GET /sandbox/:id/files/usage.
+type filesUsage struct {
+	SandboxID string `json:"sandbox_id"`
+	UsedBytes int64  `json:"used_bytes"`
+	Paths     []struct {
+		Path           string `json:"path"`
+		UsedBytes      int64  `json:"used_bytes"`
+		Files          int64  `json:"files"`
+		LimitBytes     int64  `json:"limit_bytes,omitempty"`
+		AvailableBytes int64  `json:"available_bytes,omitempty"`
+	} `json:"paths"`
+}
+
+var duCmd = &cobra.Command{
+	Use:   "du [sandbox-id] [path...]",
+	Short: "Show the space files take, under /workspace and /output by default",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		u := fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/files/usage", args[0])
+		if len(args) > 1 {
+			u += "?" + url.Values{"path": args[1:]}.Encode()
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var usage filesUsage
+		if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+
+		printResult(usage, func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "PATH\tUSED\tFILES\tAVAILABLE")
+			for _, p := range usage.Paths {
+				avail := "-"
+				if p.LimitBytes > 0 {
+					avail = formatBytes(p.AvailableBytes) + " / " + formatBytes(p.LimitBytes)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", p.Path, formatBytes(p.UsedBytes), p.Files, avail)
+			}
+			w.Flush()
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
 }
 
 func init() {
 	filesCmd.AddCommand(lsCmd)
 	filesCmd.AddCommand(putCmd)
 	filesCmd.AddCommand(getCmd)
+	filesCmd.AddCommand(duCmd)
 	RootCmd.AddCommand(filesCmd)
 }
 
+// uploadFile streams a single local file into remoteDir inside the sandbox.
+// The upload handler treats "path" as the destination DIRECTORY and appends
+// the form filename, so renaming on upload is not supported.
+func uploadFile(id, localPath, remoteDir string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer file.Close()
+
+	// Prepare Multipart
+	r, w := io.Pipe()
+	m := multipart.NewWriter(w)
+
+	go func() {
+		defer w.Close()
+		defer m.Close()
+
+		m.WriteField("path", remoteDir)
+
+		part, err := m.CreateFormFile("file", filepath.Base(localPath))
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		io.Copy(part, file)
+	}()
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/files", id), r)
+	req.Header.Set("Content-Type", m.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// uploadBatchSize is how many files fs sync uploads per request; servers
+// accept forms of up to 1000 parts.
+const uploadBatchSize = 500
+
+// uploadFiles streams local files into the sandbox in one request, each to
+// the remote path at the same index.
+func uploadFiles(id string, localPaths, remotePaths []string) error {
+	paths, _ := json.Marshal(remotePaths)
+	r, w := io.Pipe()
+	defer r.Close()
+	m := multipart.NewWriter(w)
+
+	go func() {
+		err := func() error {
+			if err := m.WriteField("paths", string(paths)); err != nil {
+				return err
+			}
+			for _, localPath := range localPaths {
+				file, err := os.Open(localPath)
+				if err != nil {
+					return fmt.Errorf("failed to open local file: %w", err)
+				}
+				part, err := m.CreateFormFile("file", filepath.Base(localPath))
+				if err == nil {
+					_, err = io.Copy(part, file)
+				}
+				file.Close()
+				if err != nil {
+					return fmt.Errorf("%s: %w", localPath, err)
+				}
+			}
+			return m.Close()
+		}()
+		w.CloseWithError(err)
+	}()
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/files", id), r)
+	req.Header.Set("Content-Type", m.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
 func splitRemote(s string) []string {
 	// Simple split by first colon
 	for i, c := range s {
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type gcItem struct {
+	Kind   string    `json:"kind"`
+	ID     string    `json:"id"`
+	Reason string    `json:"reason"`
+	Bytes  int64     `json:"bytes"`
+	At     time.Time `json:"at"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// gcRun is a collection as printed by gc -o json|yaml.
+type gcRun struct {
+	ID             string    `json:"id"`
+	Trigger        string    `json:"trigger"`
+	DryRun         bool      `json:"dry_run"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	Items          []gcItem  `json:"items"`
+	Removed        int       `json:"removed"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+var gcDryRun bool
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove resources left behind by earlier server runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		body, _ := json.Marshal(map[string]bool{"dry_run": gcDryRun})
+		resp, err := http.Post("http://localhost:8080/v1/admin/gc", "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var run gcRun
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		if run.Items == nil {
+			run.Items = []gcItem{}
+		}
+
+		printResult(run, func() {
+			printGCItems(run.Items)
+			verb := "Removed"
+			if run.DryRun {
+				verb = "Would remove"
+			}
+			fmt.Printf("%s %d resources, %s reclaimed\n", verb, run.Removed, formatBytes(run.ReclaimedBytes))
+		})
+	},
+}
+
+var gcReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show what garbage collection removed and why",
+	Run: func(cmd *cobra.Command, args []string) {
+		resp, err := http.Get("http://localhost:8080/v1/admin/gc/report")
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var report struct {
+			Runs   []gcRun  `json:"runs"`
+			Reaped []gcItem `json:"reaped"`
+			Totals struct {
+				Runs           int   `json:"runs"`
+				Removed        int   `json:"removed"`
+				Failed         int   `json:"failed"`
+				ReclaimedBytes int64 `json:"reclaimed_bytes"`
+			} `json:"totals"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		if report.Runs == nil {
+			report.Runs = []gcRun{}
+		}
+		for i := range report.Runs {
+			if report.Runs[i].Items == nil {
+				report.Runs[i].Items = []gcItem{}
+			}
+		}
+		if report.Reaped == nil {
+			report.Reaped = []gcItem{}
+		}
+
+		printResult(report, func() {
+			items := report.Reaped
+			for _, run := range report.Runs {
+				items = append(items, run.Items...)
+			}
+			printGCItems(items)
+			fmt.Printf("%d runs, %d removed, %d failed, %s reclaimed\n",
+				report.Totals.Runs, report.Totals.Removed, report.Totals.Failed, formatBytes(report.Totals.ReclaimedBytes))
+		})
+	},
+}
+
+func printGCItems(items []gcItem) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "AT\tKIND\tID\tREASON\tSIZE\tERROR")
+	for _, it := range items {
+		id := it.ID
+		if len(id) > 24 {
+			id = id[:24]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			it.At.Format(time.RFC3339), it.Kind, id, it.Reason, formatBytes(it.Bytes), it.Error)
+	}
+	w.Flush()
+}
+
+// formatBytes renders n with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Only report what would be removed")
+	gcCmd.AddCommand(gcReportCmd)
+	RootCmd.AddCommand(gcCmd)
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// execEntry is an exec as printed by history -o json|yaml.
+type execEntry struct {
+	Seq        int       `json:"seq"`
+	Language   string    `json:"language"`
+	Code       string    `json:"code"`
+	ExitCode   *int      `json:"exit_code"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	QueuedMS   int64     `json:"queued_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [sandbox-id]",
+	Short: "Show the code executed in a sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/execs", id))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result struct {
+			Execs []execEntry `json:"execs"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		if result.Execs == nil {
+			result.Execs = []execEntry{}
+		}
+
+		printResult(result.Execs, func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "#\tSTARTED\tLANG\tEXIT\tDURATION\tCODE")
+			for _, e := range result.Execs {
+				exit := "-"
+				if e.ExitCode != nil {
+					exit = fmt.Sprintf("%d", *e.ExitCode)
+				} else if e.Error != "" {
+					exit = "err"
+				}
+				duration := (time.Duration(e.DurationMS) * time.Millisecond).String()
+				if e.QueuedMS > 0 {
+					duration += fmt.Sprintf(" (queued %s)", time.Duration(e.QueuedMS)*time.Millisecond)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+					e.Seq, e.StartedAt.Format(time.RFC3339), e.Language, exit, duration, summarizeCode(e.Code, 60))
+			}
+			w.Flush()
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+// summarizeCode returns the first line of code, shortened to max runes.
+func summarizeCode(code string, max int) string {
+	line, _, multiline := strings.Cut(strings.TrimSpace(code), "\n")
+	runes := []rune(line)
+	if len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	if multiline {
+		return line + " ..."
+	}
+	return line
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+}
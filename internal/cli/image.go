@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/rootfs"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var imageCmd = &cobra.Command{
+	Use:   "image",
+	Short: "Build and manage sandbox images",
+}
+
+var buildRootfsCmd = &cobra.Command{
+	Use:   "build-rootfs [image]",
+	Short: "Convert an OCI image into an ext4 rootfs for the Firecracker driver",
+	Long: `Flattens a Docker/OCI image into an ext4 filesystem image, injects the
+boxed-agent and an init script (/sbin/boxed-init), and writes a manifest
+next to the output (<output>.json).
+
+Requires a reachable Docker daemon and mkfs.ext4 (e2fsprogs).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		agentPath, _ := cmd.Flags().GetString("agent")
+		sizeMB, _ := cmd.Flags().GetInt64("size-mb")
+		console, _ := cmd.Flags().GetString("console")
+
+		if output == "" {
+			output = rootfsName(args[0])
+		}
+
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			fmt.Printf("Failed to create docker client: %v\n", err)
+			os.Exit(1)
+		}
+		defer cli.Close()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		manifest, err := rootfs.Build(ctx, cli, rootfs.Options{
+			Image:     args[0],
+			AgentPath: agentPath,
+			Output:    output,
+			SizeMB:    sizeMB,
+			Console:   console,
+		})
+		if err != nil {
+			fmt.Printf("Build failed: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("📦 Rootfs written to %s (%d MB)\n", output, manifest.SizeBytes/(1024*1024))
+		fmt.Printf("   Manifest: %s.json\n", output)
+		fmt.Printf("   Boot with: init=%s\n", manifest.Init)
+	},
+}
+
+var (
+	buildTemplate   string
+	buildDockerfile string
+	buildArgs       []string
+	buildInit       string
+	buildMemoryMB   int64
+	buildCPUCores   float64
+)
+
+var imageBuildCmd = &cobra.Command{
+	Use:   "build [context-dir]",
+	Short: "Build an image from a Dockerfile on the server and add it as a template",
+	Long: `Send a directory, the current one by default, to the server, which builds
+the Dockerfile at its root, or the one given with -f, and adds the image as
+the template given with -t, e.g. agent-env:v1. The tag is "latest" if the
+name has none. The build output is printed as it comes.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		name, tag, _ := strings.Cut(buildTemplate, ":")
+		fields := [][2]string{{"name", name}, {"tag", tag}, {"init", buildInit}}
+		if buildDockerfile != "" {
+			data, err := os.ReadFile(buildDockerfile)
+			if err != nil {
+				fmt.Printf("Error reading Dockerfile: %v\n", err)
+				os.Exit(1)
+			}
+			fields = append(fields, [2]string{"dockerfile", string(data)})
+		}
+		if buildMemoryMB > 0 {
+			fields = append(fields, [2]string{"memory_mb", strconv.FormatInt(buildMemoryMB, 10)})
+		}
+		if buildCPUCores > 0 {
+			fields = append(fields, [2]string{"cpu_cores", strconv.FormatFloat(buildCPUCores, 'f', -1, 64)})
+		}
+		for _, arg := range buildArgs {
+			fields = append(fields, [2]string{"build_arg", arg})
+		}
+
+		pr, pw := io.Pipe()
+		w := multipart.NewWriter(pw)
+		go func() {
+			err := func() error {
+				for _, f := range fields {
+					if f[1] == "" {
+						continue
+					}
+					if err := w.WriteField(f[0], f[1]); err != nil {
+						return err
+					}
+				}
+				part, err := w.CreateFormFile("context", "context.tar.gz")
+				if err != nil {
+					return err
+				}
+				if err := tarDir(part, dir); err != nil {
+					return err
+				}
+				return w.Close()
+			}()
+			pw.CloseWithError(err)
+		}()
+
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/v1/images/build", pr)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		req.Header.Set("Accept", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		// Structured output is kept for the template
+		logs := os.Stdout
+		if structured() {
+			logs = os.Stderr
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var ev struct {
+				Type     string         `json:"type"`
+				Line     string         `json:"line"`
+				Template *publishResult `json:"template"`
+				Error    struct {
+					Message string `json:"error"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+				fmt.Printf("Error parsing response: %v\n", err)
+				os.Exit(1)
+			}
+			switch ev.Type {
+			case "log":
+				fmt.Fprintln(logs, ev.Line)
+			case "error":
+				fmt.Printf("Build failed: %s\n", ev.Error.Message)
+				os.Exit(1)
+			case "done":
+				result := *ev.Template
+				printResult(result, func() {
+					fmt.Printf("Built template %s (image %s, %d MB, %g CPUs)\n",
+						result.Name, result.Image, result.MemoryMB, result.CPUCores)
+				})
+				return
+			}
+		}
+		fmt.Println("Error: the build ended without a result")
+		os.Exit(1)
+	},
+}
+
+// tarDir writes the files under dir to w as a gzipped tar archive, with
+// paths relative to dir.
+func tarDir(w io.Writer, dir string) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// rootfsName derives a default output file name from an image reference,
+// e.g. "python:3.10-slim" -> "python-3.10-slim.ext4".
+func rootfsName(image string) string {
+	return strings.NewReplacer(":", "-", "@", "-").Replace(filepath.Base(image)) + ".ext4"
+}
+
+func init() {
+	buildRootfsCmd.Flags().StringP("output", "o", "", "Output ext4 path (default: derived from the image name)")
+	buildRootfsCmd.Flags().String("agent", "bin/boxed-agent", "Path to a Linux boxed-agent binary")
+	buildRootfsCmd.Flags().Int64("size-mb", 0, "Filesystem size in MB (default: content size + 30%)")
+	buildRootfsCmd.Flags().String("console", rootfs.DefaultConsole, "Device the agent speaks JSON-RPC on")
+	imageCmd.AddCommand(buildRootfsCmd)
+
+	imageBuildCmd.Flags().StringVarP(&buildTemplate, "template", "t", "", "Template to add, name[:tag]")
+	imageBuildCmd.Flags().StringVarP(&buildDockerfile, "file", "f", "", "Dockerfile to build (default: the one in the context)")
+	imageBuildCmd.Flags().StringArrayVar(&buildArgs, "build-arg", nil, "Value of an ARG, KEY=VALUE; repeatable")
+	imageBuildCmd.Flags().StringVar(&buildInit, "init", "", "Script run in each sandbox of the template before it is ready")
+	imageBuildCmd.Flags().Int64Var(&buildMemoryMB, "memory", 0, "Memory of the template's sandboxes in MB (default: the server's)")
+	imageBuildCmd.Flags().Float64Var(&buildCPUCores, "cpus", 0, "CPU cores of the template's sandboxes (default: the server's)")
+	imageBuildCmd.MarkFlagRequired("template")
+	imageCmd.AddCommand(imageBuildCmd)
+	RootCmd.AddCommand(imageCmd)
+}
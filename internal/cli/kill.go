@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	killSignal  string
+	killSession string
+)
+
+// killResult is what kill prints with -o json|yaml.
+type killResult struct {
+	SandboxID string `json:"sandbox_id"`
+	Signal    string `json:"signal"`
+	Processes int    `json:"processes"`
+}
+
+var killCmd = &cobra.Command{
+	Use:   "kill [sandbox-id]",
+	Short: "Send a signal to the running execs of a sandbox",
+	Long: `Send a signal to the execs running in a sandbox, e.g. to interrupt a hung
+command without destroying the sandbox. With --session, the REPL of that
+interactive session gets it instead.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		body, _ := json.Marshal(map[string]string{"signal": killSignal, "session": killSession})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/signal", id),
+			"application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result killResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		printResult(result, func() {
+			fmt.Printf("Sent %s to %d process(es)\n", result.Signal, result.Processes)
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func init() {
+	killCmd.Flags().StringVarP(&killSignal, "signal", "s", "SIGTERM", "Signal to send: SIGINT, SIGTERM, SIGKILL, SIGHUP, SIGQUIT, SIGUSR1 or SIGUSR2")
+	killCmd.Flags().StringVar(&killSession, "session", "", "Signal the REPL of this interactive session")
+	RootCmd.AddCommand(killCmd)
+}
@@ -4,19 +4,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	listLabels []string
+	listStates []string
+)
+
+// listedSandbox is a sandbox as printed by list -o json|yaml.
+type listedSandbox struct {
+	ID        string            `json:"id"`
+	State     string            `json:"state"`
+	Driver    string            `json:"driver"`
+	Image     string            `json:"image"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	Labels    map[string]string `json:"labels"`
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List active sandboxes",
 	Run: func(cmd *cobra.Command, args []string) {
 		// TODO: Use global apiURL flag
-		resp, err := http.Get("http://localhost:8080/v1/sandbox")
+		query := url.Values{"label": listLabels, "state": listStates}
+		resp, err := http.Get("http://localhost:8080/v1/sandbox?" + query.Encode())
 		if err != nil {
 			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
 			os.Exit(1)
@@ -30,10 +50,15 @@ var listCmd = &cobra.Command{
 
 		var result struct {
 			Sandboxes []struct {
-				ID        string    `json:"id"`
-				State     string    `json:"state"`
-				CreatedAt time.Time `json:"created_at"`
-				Driver    string    `json:"driver_type"`
+				ID        string     `json:"id"`
+				State     string     `json:"state"`
+				CreatedAt time.Time  `json:"created_at"`
+				ExpiresAt *time.Time `json:"expires_at"`
+				Driver    string     `json:"driver_type"`
+				Config    struct {
+					Image  string            `json:"image"`
+					Labels map[string]string `json:"labels"`
+				} `json:"config"`
 			} `json:"sandboxes"`
 		}
 
@@ -42,15 +67,39 @@ var listCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-		fmt.Fprintln(w, "ID\tSTATE\tDRIVER\tCREATED")
-		for _, s := range result.Sandboxes {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID, s.State, s.Driver, s.CreatedAt.Format(time.RFC3339))
+		sandboxes := make([]listedSandbox, len(result.Sandboxes))
+		for i, s := range result.Sandboxes {
+			sandboxes[i] = listedSandbox{
+				ID:        s.ID,
+				State:     s.State,
+				Driver:    s.Driver,
+				Image:     s.Config.Image,
+				CreatedAt: s.CreatedAt,
+				ExpiresAt: s.ExpiresAt,
+				Labels:    s.Config.Labels,
+			}
+			if sandboxes[i].Labels == nil {
+				sandboxes[i].Labels = map[string]string{}
+			}
 		}
-		w.Flush()
+		printResult(sandboxes, func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "ID\tSTATE\tDRIVER\tCREATED\tLABELS")
+			for _, s := range sandboxes {
+				var labels []string
+				for k, v := range s.Labels {
+					labels = append(labels, k+"="+v)
+				}
+				sort.Strings(labels)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.State, s.Driver, s.CreatedAt.Format(time.RFC3339), strings.Join(labels, ","))
+			}
+			w.Flush()
+		})
 	},
 }
 
 func init() {
+	listCmd.Flags().StringArrayVarP(&listLabels, "label", "l", nil, "Only sandboxes with this label (key=value or key); repeatable")
+	listCmd.Flags().StringSliceVar(&listStates, "state", nil, "Only sandboxes in these states (e.g. ready,stopped)")
 	RootCmd.AddCommand(listCmd)
 }
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsFollow bool
+	logsSource string
+	logsExec   string
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [sandbox-id]",
+	Short: "Show the agent or process logs of a sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		query := url.Values{"source": {logsSource}}
+		if logsExec != "" {
+			query.Set("exec", logsExec)
+		}
+		if logsFollow {
+			query.Set("follow", "true")
+		}
+		resp, err := http.Get(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/logs?%s", id, query.Encode()))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var line struct {
+				Time time.Time `json:"time"`
+				Exec string    `json:"exec"`
+				Text string    `json:"text"`
+			}
+			if err := dec.Decode(&line); err == io.EOF {
+				return
+			} else if err != nil {
+				fmt.Printf("Error parsing response: %v\n", err)
+				os.Exit(1)
+			}
+			printStreamItem(line, func() {
+				if line.Exec != "" && logsExec == "" {
+					fmt.Printf("%s  [%s] %s\n", line.Time.Local().Format("15:04:05.000"), line.Exec, line.Text)
+				} else {
+					fmt.Printf("%s  %s\n", line.Time.Local().Format("15:04:05.000"), line.Text)
+				}
+			})
+		}
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func init() {
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep printing new lines until the sandbox stops")
+	logsCmd.Flags().StringVar(&logsSource, "source", "agent", "Log to show: agent or process")
+	logsCmd.Flags().StringVar(&logsExec, "exec", "", "Only show the agent lines of this exec (the ID in brackets)")
+	RootCmd.AddCommand(logsCmd)
+}
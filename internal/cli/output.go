@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the global --output flag. Tables are for people and may change;
+// JSON and YAML results keep their fields, so scripts can rely on them.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormat string
+
+// checkOutputFormat validates the global --output flag.
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q: want table, json or yaml", outputFormat)
+}
+
+// structured reports whether results are printed as JSON or YAML rather
+// than for people. Progress messages are left out then.
+func structured() bool {
+	return outputFormat != outputTable
+}
+
+// printResult prints v as JSON or YAML, or calls table to print it for
+// people.
+func printResult(v any, table func()) {
+	if !structured() {
+		table()
+		return
+	}
+	if err := encodeResult(os.Stdout, v, true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing result: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printStreamItem prints one result of a command that keeps printing, such
+// as stats --follow: a line of JSON, or a YAML document. For tables it
+// calls table.
+func printStreamItem(v any, table func()) {
+	if !structured() {
+		table()
+		return
+	}
+	if outputFormat == outputYAML {
+		fmt.Println("---")
+	}
+	if err := encodeResult(os.Stdout, v, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Error printing result: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// encodeResult writes v in the chosen structured format. YAML uses the
+// JSON field names, so both formats have the same schema.
+func encodeResult(w io.Writer, v any, indent bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if outputFormat == outputJSON {
+		if indent {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, data, "", "  "); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	// JSON is YAML: decoding it keeps the field order, and dropping the
+	// flow style it was written in makes block YAML of it
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	blockStyle(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// blockStyle clears the styles of n and its children, so that they are
+// encoded as block YAML with strings quoted only where needed. Strings
+// YAML 1.1 parsers take for booleans, such as "yes", stay quoted.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && yaml11Bools[strings.ToLower(n.Value)] {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+var yaml11Bools = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true,
+}
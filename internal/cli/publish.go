@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var publishInit string
+
+// publishResult is what publish prints with -o json|yaml.
+type publishResult struct {
+	Name     string  `json:"name"`
+	Image    string  `json:"image"`
+	MemoryMB int64   `json:"memory_mb"`
+	CPUCores float64 `json:"cpu_cores"`
+	Driver   string  `json:"driver,omitempty"`
+}
+
+var publishCmd = &cobra.Command{
+	Use:   "publish [sandbox-id] [name[:tag]]",
+	Short: "Save a running sandbox as a template",
+	Long: `Save the filesystem of a running sandbox as a template that later
+sandboxes can be created from, e.g. after preparing it with repl. The tag
+is "latest" if the name has none. Running processes are not saved: --init
+gives a script that starts them again in each new sandbox.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+		name, tag, _ := strings.Cut(args[1], ":")
+
+		body, _ := json.Marshal(map[string]string{"name": name, "tag": tag, "init": publishInit})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/publish-template", id),
+			"application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result publishResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		printResult(result, func() {
+			fmt.Printf("Published template %s (image %s, %d MB, %g CPUs)\n",
+				result.Name, result.Image, result.MemoryMB, result.CPUCores)
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func init() {
+	publishCmd.Flags().StringVar(&publishInit, "init", "", "Script run in each sandbox of the template before it is ready")
+	RootCmd.AddCommand(publishCmd)
+}
@@ -22,11 +22,25 @@ var replCmd = &cobra.Command{
 
 		// Determine language (optional)
 		lang, _ := cmd.Flags().GetString("lang")
+		attach, _ := cmd.Flags().GetString("attach")
+		name, _ := cmd.Flags().GetString("session")
 
 		u := url.URL{Scheme: "ws", Host: "localhost:8080", Path: fmt.Sprintf("/v1/sandbox/%s/interact", id)}
-		if lang != "" {
-			u.RawQuery = "lang=" + lang
+		query := url.Values{}
+		if attach != "" {
+			query.Set("session", attach)
+		} else {
+			if lang != "" {
+				query.Set("lang", lang)
+			}
+			if name != "" {
+				query.Set("session", name)
+			}
 		}
+		if apiKey != "" {
+			query.Set("api_key", apiKey)
+		}
+		u.RawQuery = query.Encode()
 
 		fmt.Printf("Connecting to %s...\n", u.String())
 
@@ -46,10 +60,14 @@ var replCmd = &cobra.Command{
 		// goroutine: WS -> Local Stdout
 		go func() {
 			defer close(done)
+			var session string
 			for {
 				_, message, err := c.ReadMessage()
 				if err != nil {
 					fmt.Printf("\nConnection closed: %v\n", err)
+					if session != "" && !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+						fmt.Printf("The session keeps running for a while. Reattach with: boxed repl %s --attach %s\n", id, session)
+					}
 					return
 				}
 
@@ -57,6 +75,7 @@ var replCmd = &cobra.Command{
 				var event struct {
 					Method string `json:"method"`
 					Params struct {
+						ID      string `json:"id"`
 						Chunk   string `json:"chunk"`
 						Message string `json:"message"`
 						Code    int    `json:"code"`
@@ -65,6 +84,9 @@ var replCmd = &cobra.Command{
 
 				if err := json.Unmarshal(message, &event); err == nil {
 					switch event.Method {
+					case "session":
+						session = event.Params.ID
+						fmt.Printf("[Session %s]\n", session)
 					case "stdout", "stderr":
 						fmt.Print(event.Params.Chunk)
 					case "error":
@@ -120,9 +142,12 @@ var replCmd = &cobra.Command{
 			return
 		}
 	},
+	ValidArgsFunction: completeSandboxID,
 }
 
 func init() {
 	replCmd.Flags().StringP("lang", "l", "bash", "Language/Shell (bash, python)")
+	replCmd.Flags().String("attach", "", "Reattach to a session whose connection dropped")
+	replCmd.Flags().String("session", "", "Join the named session, starting it if it is not running")
 	RootCmd.AddCommand(replCmd)
 }
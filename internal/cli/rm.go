@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rmAll    bool
+	rmLabels []string
+	rmStates []string
+)
+
+// rmResult is what rm and stop print with -o json|yaml.
+type rmResult struct {
+	Stopped []string    `json:"stopped"`
+	Failed  []rmFailure `json:"failed"`
+}
+
+type rmFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm [sandbox-id...]",
+	Short: "Stop and remove sandboxes",
+	Long: `Stop and remove the given sandboxes, or every sandbox matching --label
+and --state. Without IDs, --label or --all is required.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 && (rmAll || len(rmLabels) > 0 || len(rmStates) > 0) {
+			fmt.Println("Error: give sandbox IDs or --all/--label/--state, not both")
+			os.Exit(1)
+		}
+		if len(args) == 0 && !rmAll && len(rmLabels) == 0 {
+			fmt.Println("Error: give sandbox IDs, --label or --all")
+			os.Exit(1)
+		}
+
+		result := rmResult{Stopped: []string{}, Failed: []rmFailure{}}
+		for _, id := range args {
+			if err := rmSandbox(id); err != nil {
+				result.Failed = append(result.Failed, rmFailure{ID: id, Error: err.Error()})
+				continue
+			}
+			result.Stopped = append(result.Stopped, id)
+		}
+		if len(args) == 0 {
+			result = rmMatching()
+		}
+
+		printResult(result, func() {
+			for _, id := range result.Stopped {
+				fmt.Println(id)
+			}
+			for _, f := range result.Failed {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", f.ID, f.Error)
+			}
+			if len(args) == 0 {
+				fmt.Fprintf(os.Stderr, "Removed %d sandboxes\n", len(result.Stopped))
+			}
+		})
+		if len(result.Failed) > 0 {
+			os.Exit(1)
+		}
+	},
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		return sandboxCompletions(toComplete, ""), cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+func rmSandbox(id string) error {
+	req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/v1/sandbox/"+url.PathEscape(id), nil)
+	if apiKey != "" {
+		req.Header.Set("X-Boxed-API-Key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// rmMatching deletes the sandboxes the flags select.
+func rmMatching() rmResult {
+	query := url.Values{"label": rmLabels, "state": rmStates}
+	if rmAll {
+		query.Set("all", "true")
+	}
+	req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/v1/sandbox?"+query.Encode(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Error: %s\n", resp.Status)
+		io.Copy(os.Stderr, resp.Body)
+		os.Exit(1)
+	}
+
+	result := rmResult{Stopped: []string{}, Failed: []rmFailure{}}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		os.Exit(1)
+	}
+	return result
+}
+
+func init() {
+	rmCmd.Flags().BoolVar(&rmAll, "all", false, "Remove every sandbox, or every one in --state")
+	rmCmd.Flags().StringArrayVarP(&rmLabels, "label", "l", nil, "Only sandboxes with this label (key=value or key); repeatable")
+	rmCmd.Flags().StringSliceVar(&rmStates, "state", nil, "Only sandboxes in these states (e.g. stopped,error)")
+	RootCmd.AddCommand(rmCmd)
+}
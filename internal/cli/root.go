@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/rs/zerolog"
@@ -37,6 +38,11 @@ and client utilities for interacting with the API.`,
 		} else {
 			zerolog.SetGlobalLevel(zerolog.InfoLevel)
 		}
+
+		if err := checkOutputFormat(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
@@ -50,5 +56,6 @@ func Execute() {
 func init() {
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
 	RootCmd.PersistentFlags().BoolVar(&jsonLog, "json-log", false, "Output logs in JSON format")
+	RootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format: table, json or yaml")
 	RootCmd.PersistentFlags().StringVar(&apiKey, "api-key", os.Getenv("BOXED_API_KEY"), "API Key for authentication")
 }
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -9,27 +10,125 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	template string
-	timeout  int
+	template     string
+	timeout      int
+	platform     string
+	runFile      string
+	runLang      string
+	runEnv       []string
+	artifactsDir string
+	keepSandbox  bool
 )
 
+// extLanguages maps the extensions of --file to the language they run as.
+var extLanguages = map[string]string{
+	".py":   "python",
+	".js":   "javascript",
+	".mjs":  "javascript",
+	".cjs":  "javascript",
+	".sh":   "bash",
+	".bash": "bash",
+}
+
+// runInput returns the code and language run executes, from its argument
+// or --file, and --lang.
+func runInput(args []string) (code, lang string, err error) {
+	switch {
+	case len(args) == 1 && runFile != "":
+		return "", "", fmt.Errorf("give either code or --file, not both")
+	case len(args) == 1:
+		return args[0], cmp.Or(runLang, "python"), nil
+	case runFile == "":
+		return "", "", fmt.Errorf("give code to run or --file")
+	}
+	data, err := os.ReadFile(runFile)
+	if err != nil {
+		return "", "", err
+	}
+	lang = runLang
+	if lang == "" {
+		if lang = extLanguages[strings.ToLower(filepath.Ext(runFile))]; lang == "" {
+			return "", "", fmt.Errorf("cannot tell the language of %s from its extension; set --lang", runFile)
+		}
+	}
+	return string(data), lang, nil
+}
+
+// parseEnv turns KEY=VAL pairs into a map.
+func parseEnv(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("--env %q is not KEY=VAL", pair)
+		}
+		env[k] = v
+	}
+	return env, nil
+}
+
+// runResult is what run prints with -o json|yaml.
+type runResult struct {
+	SandboxID string          `json:"sandbox_id"`
+	Stdout    string          `json:"stdout"`
+	Stderr    string          `json:"stderr"`
+	ExitCode  *int            `json:"exit_code"`
+	Artifacts []savedArtifact `json:"artifacts"`
+
+	// ErrorKind and ErrorMessage say why the code could not run, e.g.
+	// "command_not_found"
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	// Kept is set when --keep left the sandbox running
+	Kept bool `json:"kept,omitempty"`
+}
+
+// savedArtifact is an artifact of run, saved under --artifacts-dir.
+type savedArtifact struct {
+	Path    string `json:"path"`
+	Mime    string `json:"mime"`
+	SavedTo string `json:"saved_to,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
 var runCmd = &cobra.Command{
 	Use:   "run [code]",
 	Short: "Run code in a ephemeral sandbox",
-	Args:  cobra.ExactArgs(1),
+	Long: `Run code in a ephemeral sandbox, given as an argument or with --file, and
+save the artifacts it produces. The language of a file is inferred from its
+extension (.py, .js, .sh); code given as an argument is Python unless --lang
+says otherwise.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		code := args[0]
+		code, lang, err := runInput(args)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		env, err := parseEnv(runEnv)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
 
 		// 1. Create Sandbox
 		createPayload := map[string]any{
 			"template": template,
 			"timeout":  timeout,
 		}
+		if platform != "" {
+			createPayload["platform"] = platform
+		}
 		body, _ := json.Marshal(createPayload)
 
 		resp, err := http.Post("http://localhost:8080/v1/sandbox", "application/json", bytes.NewReader(body))
@@ -46,19 +145,28 @@ var runCmd = &cobra.Command{
 		}
 
 		var createResp struct {
-			ID string `json:"sandbox_id"`
+			ID       string   `json:"sandbox_id"`
+			Warnings []string `json:"warnings"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&createResp); err != nil {
 			fmt.Printf("Bad response: %v\n", err)
 			os.Exit(1)
 		}
 		id := createResp.ID
-		fmt.Printf("📦 Sandbox %s created\n", id)
+		for _, w := range createResp.Warnings {
+			fmt.Fprintf(os.Stderr, "⚠️  %s\n", w)
+		}
+		if !structured() {
+			fmt.Printf("📦 Sandbox %s created\n", id)
+		}
 
 		// 2. Execute Code
-		execPayload := map[string]string{
+		execPayload := map[string]any{
 			"code":     code,
-			"language": "python", // MVP: Assume python based on default template
+			"language": lang,
+		}
+		if env != nil {
+			execPayload["env"] = env
 		}
 		body, _ = json.Marshal(execPayload)
 		resp, err = http.Post(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/exec", id), "application/json", bytes.NewReader(body))
@@ -68,12 +176,20 @@ var runCmd = &cobra.Command{
 			os.Exit(1)
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			fmt.Printf("Exec failed: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			cleanup(id)
+			os.Exit(1)
+		}
 
 		var execResp struct {
-			Stdout    string `json:"stdout"`
-			Stderr    string `json:"stderr"`
-			ExitCode  *int   `json:"exit_code"`
-			Artifacts []struct {
+			Stdout       string `json:"stdout"`
+			Stderr       string `json:"stderr"`
+			ExitCode     *int   `json:"exit_code"`
+			ErrorKind    string `json:"error_kind"`
+			ErrorMessage string `json:"error_message"`
+			Artifacts    []struct {
 				Path       string `json:"path"`
 				Mime       string `json:"mime"`
 				DataBase64 string `json:"data_base64"`
@@ -81,35 +197,72 @@ var runCmd = &cobra.Command{
 		}
 		json.NewDecoder(resp.Body).Decode(&execResp)
 
-		fmt.Print(execResp.Stdout)
-		if execResp.Stderr != "" {
-			fmt.Fprint(os.Stderr, execResp.Stderr)
+		result := runResult{
+			SandboxID: id,
+			Stdout:    execResp.Stdout,
+			Stderr:    execResp.Stderr,
+			ExitCode:  execResp.ExitCode,
+			Artifacts: []savedArtifact{},
+			Kept:      keepSandbox,
+
+			ErrorKind:    execResp.ErrorKind,
+			ErrorMessage: execResp.ErrorMessage,
 		}
 
 		// Handle artifacts
 		if len(execResp.Artifacts) > 0 {
-			os.Mkdir("artifacts", 0755)
-			fmt.Println("\n📂 Artifacts:")
-			for _, a := range execResp.Artifacts {
-				data, err := base64.StdEncoding.DecodeString(a.DataBase64)
-				if err != nil {
-					fmt.Printf("  - Failed to decode %s: %v\n", a.Path, err)
-					continue
-				}
+			os.MkdirAll(artifactsDir, 0755)
+		}
+		for _, a := range execResp.Artifacts {
+			saved := savedArtifact{Path: a.Path, Mime: a.Mime}
+			data, err := base64.StdEncoding.DecodeString(a.DataBase64)
+			if err != nil {
+				saved.Error = fmt.Sprintf("failed to decode: %v", err)
+			} else {
 				// Save locally
-				localPath := filepath.Join("artifacts", filepath.Base(a.Path))
-				err = os.WriteFile(localPath, data, 0644)
-				if err != nil {
-					fmt.Printf("  - Failed to write %s: %v\n", localPath, err)
+				localPath := filepath.Join(artifactsDir, filepath.Base(a.Path))
+				if err := os.WriteFile(localPath, data, 0644); err != nil {
+					saved.Error = fmt.Sprintf("failed to write %s: %v", localPath, err)
 				} else {
-					fmt.Printf("  - Saved: %s (%s)\n", localPath, a.Mime)
+					saved.SavedTo = localPath
 				}
 			}
+			result.Artifacts = append(result.Artifacts, saved)
 		}
 
 		// 3. Cleanup
-		cleanup(id)
-		fmt.Printf("\n♻️  Sandbox destroyed\n")
+		if !keepSandbox {
+			cleanup(id)
+		}
+
+		printResult(result, func() {
+			fmt.Print(result.Stdout)
+			if result.Stderr != "" {
+				fmt.Fprint(os.Stderr, result.Stderr)
+			}
+			if result.ErrorKind != "" {
+				fmt.Fprintf(os.Stderr, "⚠️  %s", result.ErrorKind)
+				if result.ErrorMessage != "" {
+					fmt.Fprintf(os.Stderr, ": %s", result.ErrorMessage)
+				}
+				fmt.Fprintln(os.Stderr)
+			}
+			if len(result.Artifacts) > 0 {
+				fmt.Println("\n📂 Artifacts:")
+			}
+			for _, a := range result.Artifacts {
+				if a.Error != "" {
+					fmt.Printf("  - %s: %s\n", a.Path, a.Error)
+				} else {
+					fmt.Printf("  - Saved: %s (%s)\n", a.SavedTo, a.Mime)
+				}
+			}
+			if result.Kept {
+				fmt.Printf("\n📦 Sandbox %s kept; remove it with: boxed rm %s\n", id, id)
+			} else {
+				fmt.Printf("\n♻️  Sandbox destroyed\n")
+			}
+		})
 	},
 }
 
@@ -119,7 +272,13 @@ func cleanup(id string) {
 }
 
 func init() {
-	runCmd.Flags().StringVarP(&template, "template", "t", "python:3.10-slim", "Sandbox template image")
+	runCmd.Flags().StringVarP(&template, "template", "t", "", "Sandbox template or image (default: the server's default template)")
 	runCmd.Flags().IntVar(&timeout, "timeout", 30, "Timeout in seconds")
+	runCmd.Flags().StringVar(&platform, "platform", "", "Image platform, e.g. linux/amd64 (default: the host's)")
+	runCmd.Flags().StringVarP(&runFile, "file", "f", "", "File of code to run instead of the argument; its extension gives the language")
+	runCmd.Flags().StringVarP(&runLang, "lang", "l", "", "Language of the code: python, python-session, javascript, bash or bash-session (default: python, or from --file)")
+	runCmd.Flags().StringArrayVarP(&runEnv, "env", "e", nil, "Environment variable for the code, as KEY=VAL; repeatable")
+	runCmd.Flags().StringVar(&artifactsDir, "artifacts-dir", "artifacts", "Directory artifacts are saved to")
+	runCmd.Flags().BoolVar(&keepSandbox, "keep", false, "Leave the sandbox running instead of destroying it")
 	RootCmd.AddCommand(runCmd)
 }
@@ -2,44 +2,155 @@ package cli
 
 import (
 	"context"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/config"
 	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"github.com/akshayaggarwal99/boxed/internal/driver/plugin"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
 
-	// Register docker driver
+	// Register drivers
 	_ "github.com/akshayaggarwal99/boxed/internal/driver/docker"
+	_ "github.com/akshayaggarwal99/boxed/internal/driver/wasm"
 
 	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
-	port       string
-	driverName string
+	// conf holds the values of the flags of settings a config file can
+	// hold; those given on the command line override the file
+	conf       = config.Default()
+	configFile string
+
+	grpcEnabled bool
+	grpcPort    string
+	execCache   int
+
+	sshPort    string
+	sshHostKey string
+
+	drainTimeout      time.Duration
+	stopOnExit        bool
+	reconcileInterval time.Duration
+
+	expiryWarning time.Duration
+	usageInterval time.Duration
+	orphanPolicy  string
+	instanceID    string
+	gpus          string
+	discardStderr bool
+
+	nodeURL           string
+	heartbeatInterval time.Duration
+	nodeTimeout       time.Duration
+
+	security      string
+	trustedImages []string
+	seccompDir    string
 )
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start the Boxed Control Plane server",
 	Run: func(cmd *cobra.Command, args []string) {
-		runServer()
+		runServer(cmd)
 	},
 }
 
 func init() {
-	serveCmd.Flags().StringVarP(&port, "port", "p", "8080", "HTTP server port")
-	serveCmd.Flags().StringVarP(&driverName, "driver", "d", "docker", "Backend driver: docker, firecracker")
-	serveCmd.Flags().StringVar(&apiKey, "api-key", os.Getenv("BOXED_API_KEY"), "API Key for authentication")
+	serveCmd.Flags().StringVarP(&configFile, "config", "c", os.Getenv("BOXED_CONFIG"), "YAML file of settings the flags and BOXED_* variables override (default: "+config.DefaultFile+" if it exists)")
+	serveCmd.Flags().StringVarP(&conf.Port, "port", "p", conf.Port, "HTTP server port")
+	serveCmd.Flags().BoolVar(&grpcEnabled, "grpc", envBool("BOXED_GRPC", false), "Also serve the gRPC API on the HTTP port, over HTTP/2")
+	serveCmd.Flags().StringVar(&grpcPort, "grpc-port", os.Getenv("BOXED_GRPC_PORT"), "Serve the gRPC API on this port instead of the HTTP port")
+	serveCmd.Flags().StringVar(&sshPort, "ssh-port", os.Getenv("BOXED_SSH_PORT"), "Serve an SSH/SFTP gateway to sandboxes on this port")
+	serveCmd.Flags().StringVar(&sshHostKey, "ssh-host-key", os.Getenv("BOXED_SSH_HOST_KEY"), "Private key file of the SSH gateway, generated if missing (default: a new key each start)")
+	serveCmd.Flags().StringSliceVarP(&conf.Driver.Names, "driver", "d", conf.Driver.Names, "Backend drivers: docker, wasm or a plugin; with several, the first is the default")
+	serveCmd.Flags().StringVar(&conf.Driver.PluginDir, "plugin-dir", conf.Driver.PluginDir, "Directory of driver plugins, executables named boxed-driver-<name>")
+	serveCmd.Flags().StringSliceVar(&conf.Driver.Routes, "driver-route", nil, "Image pattern=driver routes used when a create names no driver (e.g. 'python:*=docker')")
+	serveCmd.Flags().StringVar(&conf.Auth.APIKey, "api-key", "", "API Key for authentication")
+	serveCmd.Flags().StringSliceVar(&conf.Pool.Prepull, "prepull", nil, "Images to pull in the background at startup")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxOutput, "max-output", conf.Limits.MaxOutput, "Bytes of stdout/stderr captured per exec")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxContextFileSize, "max-context-file-size", conf.Limits.MaxContextFileSize, "Decoded bytes each context file of a create may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxContextSize, "max-context-size", conf.Limits.MaxContextSize, "Decoded bytes all the context files of a create may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxCodeSize, "max-code-size", conf.Limits.MaxCodeSize, "Bytes of code an exec may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxArgs, "max-args", conf.Limits.MaxArgs, "Arguments a command line, such as a sidecar's, may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxEnvVars, "max-env-vars", conf.Limits.MaxEnvVars, "Variables an exec or sidecar environment may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxEnvSize, "max-env-size", conf.Limits.MaxEnvSize, "Bytes of names and values an exec or sidecar environment may have")
+	serveCmd.Flags().IntVar(&conf.Limits.MaxBodySize, "max-body-size", conf.Limits.MaxBodySize, "Bytes a JSON request body may have")
+	serveCmd.Flags().DurationVar(&conf.Limits.MaxTTL, "max-ttl", conf.Limits.MaxTTL, "Longest remaining lifetime a sandbox can be given")
+	serveCmd.Flags().IntVar(&execCache, "exec-cache-size", envInt("BOXED_EXEC_CACHE_SIZE", api.DefaultExecCacheSize), "Exec results kept for requests that set cache (-1 disables)")
+	serveCmd.Flags().StringVar(&conf.Storage.ArtifactDir, "artifact-dir", "", "Directory for the deduplicating artifact store (disabled if empty)")
+	serveCmd.Flags().DurationVar(&drainTimeout, "drain-timeout", envDuration("BOXED_DRAIN_TIMEOUT", 30*time.Second), "How long shutdown waits for in-flight execs and sessions")
+	serveCmd.Flags().BoolVar(&stopOnExit, "stop-sandboxes-on-exit", envBool("BOXED_STOP_ON_EXIT", false), "Stop running sandboxes on shutdown instead of leaving them for the next startup")
+	serveCmd.Flags().DurationVar(&reconcileInterval, "reconcile-interval", envDuration("BOXED_RECONCILE_INTERVAL", time.Minute), "How often containers are reconciled with tracked sandboxes (negative disables)")
+	serveCmd.Flags().IntVar(&conf.Pool.MaxSandboxes, "max-sandboxes", 0, "Live sandboxes after which creates are refused (0 means no limit)")
+	serveCmd.Flags().DurationVar(&conf.Limits.MaxSandboxAge, "max-sandbox-age", 0, "Longest a sandbox may live after creation, however its TTL is extended (0 means no cap)")
+	serveCmd.Flags().DurationVar(&conf.Limits.DownloadStallTimeout, "download-stall-timeout", 0, "How long a file download may make no progress before it is given up (default 1m, negative never gives up)")
+	serveCmd.Flags().DurationVar(&expiryWarning, "expiry-warning", envDuration("BOXED_EXPIRY_WARNING", api.DefaultExpiryWarning), "How long before a sandbox expires a ttl_warning event is sent on /v1/events")
+	serveCmd.Flags().DurationVar(&usageInterval, "usage-interval", envDuration("BOXED_USAGE_INTERVAL", api.DefaultUsageInterval), "How often the CPU time and lifetime of running sandboxes are recorded for /v1/usage")
+	serveCmd.Flags().StringVar(&conf.Templates, "templates", "", "YAML file of the templates sandboxes are created from, reloaded when it changes (default: built-in python templates)")
+	serveCmd.Flags().StringVar(&orphanPolicy, "orphans", envString("BOXED_ORPHAN_POLICY", driver.OrphanDelete), "What to do at startup with sandboxes an earlier process left running: delete, adopt or keep")
+	serveCmd.Flags().StringVar(&instanceID, "instance-id", os.Getenv("BOXED_INSTANCE_ID"), "Name of this server among others sharing a Docker daemon; each only collects its own containers")
+	serveCmd.Flags().StringVar(&gpus, "gpus", os.Getenv("BOXED_GPUS"), "GPUs sandboxes can be given, as id[:type],... (e.g. '0:a100,1:a100'; default: those nvidia-smi finds)")
+	serveCmd.Flags().BoolVar(&discardStderr, "discard-agent-stderr", envBool("BOXED_DISCARD_AGENT_STDERR", false), "Drop the stderr of Docker agents instead of keeping it in the sandbox's agent logs")
+	serveCmd.Flags().StringVar(&conf.Storage.StateDir, "state-dir", "", "Directory keeping sandbox records, timelines and exec history; nodes of a cluster share it")
+	serveCmd.Flags().StringVar(&nodeURL, "node-url", os.Getenv("BOXED_NODE_URL"), "URL other nodes reach this server at; joins the cluster sharing --state-dir")
+	serveCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", envDuration("BOXED_HEARTBEAT_INTERVAL", api.DefaultHeartbeatInterval), "How often a cluster node refreshes its entry")
+	serveCmd.Flags().DurationVar(&nodeTimeout, "node-timeout", envDuration("BOXED_NODE_TIMEOUT", api.DefaultNodeTimeout), "How long after its last heartbeat a node's sandboxes are taken over")
+	serveCmd.Flags().StringVar(&conf.Auth.KeysFile, "api-keys-file", "", "YAML file of further named API keys, each owning the sandboxes it creates")
+	serveCmd.Flags().StringVar(&conf.Auth.OIDC.Issuer, "oidc-issuer", "", "OpenID Connect issuer whose bearer tokens are accepted (disabled if empty)")
+	serveCmd.Flags().StringVar(&conf.Auth.OIDC.Audience, "oidc-audience", "", "Audience bearer tokens must be issued for")
+	serveCmd.Flags().StringVar(&conf.Auth.OIDC.OrgClaim, "oidc-org-claim", conf.Auth.OIDC.OrgClaim, "Token claim recorded as the sandbox owner's organisation")
+	serveCmd.Flags().IntVar(&conf.Pool.ReadyMin, "ready-pool-min", 0, "Warm sandboxes a pooled driver needs before /readyz reports ready")
+	serveCmd.Flags().StringVar(&conf.TLS.CertFile, "tls-cert", "", "Certificate file the API is served with over HTTPS (with --tls-key)")
+	serveCmd.Flags().StringVar(&conf.TLS.KeyFile, "tls-key", "", "Private key file of --tls-cert")
+	serveCmd.Flags().StringSliceVar(&conf.CORS.AllowedOrigins, "cors-origin", nil, "Origins whose web pages may call the API and open its WebSockets (e.g. 'https://*.example.com')")
+	serveCmd.Flags().StringSliceVar(&conf.CORS.AllowedHeaders, "cors-header", nil, "Request headers cross-origin pages may send (default: those of the API)")
+	serveCmd.Flags().BoolVar(&conf.CORS.AllowCredentials, "cors-credentials", false, "Let cross-origin pages send cookies and HTTP authentication")
+	serveCmd.Flags().DurationVar(&conf.CORS.MaxAge, "cors-max-age", 0, "How long browsers may cache a CORS preflight")
+	serveCmd.Flags().BoolVar(&conf.Dashboard, "dashboard", conf.Dashboard, "Serve the web dashboard at /ui")
+	serveCmd.Flags().IntVar(&conf.Scheduling.Slots, "exec-slots", 0, "Execs run at once across sandboxes, shared fairly between callers by priority class (0 means no limit)")
+	serveCmd.Flags().IntVar(&conf.Scheduling.SandboxSlots, "exec-sandbox-slots", 0, "Execs run at once in one sandbox, later ones waiting their turn (0 means no limit)")
+	serveCmd.Flags().IntVar(&conf.Scheduling.MaxQueue, "exec-max-queue", 0, "Execs of one caller that may wait for a slot before more are refused with 429 (default 100)")
+	serveCmd.Flags().DurationVar(&conf.Scheduling.MaxWait, "exec-max-wait", 0, "How long an exec waits for a slot before failing with 429 (default 30s)")
+	serveCmd.Flags().StringVar(&conf.Scheduling.DefaultClass, "exec-default-class", "", "Priority class of callers whose key names none (default: standard)")
+	serveCmd.Flags().IntVar(&conf.Egress.ProxyPort, "egress-proxy-port", 0, "Serve the proxy sandboxes asking for internet access reach it through on this port (0: they get none)")
+	serveCmd.Flags().StringSliceVar(&conf.Egress.AllowCIDRs, "egress-allow-cidr", nil, "Private ranges the egress proxy lets sandboxes reach all the same (e.g. '10.0.5.0/24')")
+	serveCmd.Flags().StringVar(&security, "security", envString("BOXED_SECURITY", "default"), "Security of sandboxes created without their own settings: default or hardened")
+	serveCmd.Flags().StringSliceVar(&trustedImages, "trusted-images", envList("BOXED_TRUSTED_IMAGES"), "Image patterns --security hardened leaves alone (e.g. 'boxed-*:*')")
+	serveCmd.Flags().StringVar(&seccompDir, "seccomp-dir", os.Getenv("BOXED_SECCOMP_DIR"), "Directory of seccomp profiles <name>.json that creates can name, over the built-in ones")
 	RootCmd.AddCommand(serveCmd)
 }
 
-func runServer() {
-	log.Info().Str("driver", driverName).Str("port", port).Msg("🗳️  Starting Boxed Server")
+func runServer(cmd *cobra.Command) {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	cfg.Override(conf, cmd.Flags().Changed)
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	if cfg.Path != "" {
+		log.Info().Str("file", cfg.Path).Msg("Loaded configuration")
+	}
+	log.Info().Strs("drivers", cfg.Driver.Names).Str("port", cfg.Port).Msg("🗳️  Starting Boxed Server")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -53,8 +164,61 @@ func runServer() {
 		cancel()
 	}()
 
+	// Spans are exported when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(ctx, "boxed", RootCmd.Version)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+	defer func() {
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to flush traces")
+		}
+	}()
+	if tracing.Enabled() {
+		log.Info().Msg("Exporting traces over OTLP")
+	}
+
 	// Init Driver
-	d, err := driver.NewDriver(driverName, nil)
+	plugins, err := plugin.Load(cfg.Driver.PluginDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load driver plugins")
+	}
+	if len(plugins) > 0 {
+		log.Info().Strs("plugins", plugins).Msg("Loaded driver plugins")
+	}
+	if nodeURL != "" {
+		if instanceID == "" || cfg.Storage.StateDir == "" {
+			log.Fatal().Msg("--node-url requires --instance-id and --state-dir")
+		}
+		// Another node may have taken over what the last process left
+		if !cmd.Flags().Changed("orphans") && os.Getenv("BOXED_ORPHAN_POLICY") == "" {
+			orphanPolicy = driver.OrphanKeep
+		}
+	}
+	driverRoutes, err := multi.ParseRoutes(strings.Join(cfg.Driver.Routes, ","))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid driver routes")
+	}
+	// The driver options of the config file give way to these flags only
+	// when the flags or their environment variables are set
+	driverCfg := cfg.Driver.Settings()
+	for _, s := range []struct {
+		key, flag, env string
+		value          any
+	}{
+		{"reconcile_interval", "reconcile-interval", "BOXED_RECONCILE_INTERVAL", reconcileInterval},
+		{"orphan_policy", "orphans", "BOXED_ORPHAN_POLICY", orphanPolicy},
+		{"instance_id", "instance-id", "BOXED_INSTANCE_ID", instanceID},
+		{"gpus", "gpus", "BOXED_GPUS", gpus},
+		{"discard_agent_stderr", "discard-agent-stderr", "BOXED_DISCARD_AGENT_STDERR", discardStderr},
+	} {
+		if _, ok := driverCfg[s.key]; !ok || cmd.Flags().Changed(s.flag) || os.Getenv(s.env) != "" {
+			driverCfg[s.key] = s.value
+		}
+	}
+	d, err := multi.Open(cfg.Driver.Names, driverCfg, driverRoutes)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize driver")
 	}
@@ -72,20 +236,207 @@ func runServer() {
 	e.HideBanner = true
 	e.HidePort = true
 
-	h := api.NewHandler(d, apiKey)
+	opts := []api.Option{
+		api.WithMaxOutput(cfg.Limits.MaxOutput),
+		api.WithMaxTTL(cfg.Limits.MaxTTL),
+		api.WithMaxContextFileSize(cfg.Limits.MaxContextFileSize),
+		api.WithMaxContextSize(cfg.Limits.MaxContextSize),
+		api.WithInputLimits(cfg.Limits.Inputs()),
+		api.WithExecCacheSize(execCache),
+		api.WithReadyPoolMin(cfg.Pool.ReadyMin),
+		api.WithMaxSandboxes(cfg.Pool.MaxSandboxes),
+		api.WithMaxSandboxAge(cfg.Limits.MaxSandboxAge),
+		api.WithDownloadStallTimeout(cfg.Limits.DownloadStallTimeout),
+		api.WithExpiryWarning(expiryWarning),
+		api.WithUsageInterval(usageInterval),
+	}
+	if cfg.Storage.ArtifactDir != "" {
+		store, err := artifacts.Open(cfg.Storage.ArtifactDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open artifact store")
+		}
+		opts = append(opts, api.WithArtifactStore(store))
+	}
+	if cfg.Templates != "" {
+		catalog, err := templates.Load(cfg.Templates)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load templates")
+		}
+		go func() {
+			if err := catalog.Watch(ctx); err != nil {
+				log.Error().Err(err).Msg("Cannot watch templates for changes")
+			}
+		}()
+		opts = append(opts, api.WithTemplates(catalog))
+	}
+	if cfg.Storage.StateDir != "" {
+		store, err := state.OpenFileStore(cfg.Storage.StateDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open state store")
+		}
+		opts = append(opts, api.WithStore(store))
+	}
+	if nodeURL != "" {
+		opts = append(opts, api.WithCluster(api.ClusterConfig{
+			NodeID:            instanceID,
+			URL:               nodeURL,
+			HeartbeatInterval: heartbeatInterval,
+			NodeTimeout:       nodeTimeout,
+		}))
+	}
+	keys := cfg.Auth.Keys
+	if cfg.Auth.KeysFile != "" {
+		fileKeys, err := api.LoadAPIKeys(cfg.Auth.KeysFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load API keys")
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) > 0 {
+		if err := api.CheckAPIKeys(keys); err != nil {
+			log.Fatal().Err(err).Msg("Invalid API keys")
+		}
+		if err := api.CheckExecScheduling(cfg.Scheduling.Exec(), keys); err != nil {
+			log.Fatal().Err(err).Msg("Invalid exec scheduling")
+		}
+		opts = append(opts, api.WithAPIKeys(keys))
+	}
+	if hooks := cfg.Admission.Webhooks; len(hooks) > 0 {
+		opts = append(opts, api.WithAdmissionWebhooks(hooks))
+	}
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		opts = append(opts, api.WithCORS(cfg.CORS.Policy()))
+	}
+	if cfg.Dashboard {
+		opts = append(opts, api.WithDashboard())
+	}
+	if cfg.Scheduling.Slots > 0 || cfg.Scheduling.SandboxSlots > 0 {
+		opts = append(opts, api.WithExecScheduling(cfg.Scheduling.Exec()))
+	}
+	if len(cfg.Metrics.SlowDriverOps) > 0 {
+		opts = append(opts, api.WithSlowDriverOps(cfg.Metrics.SlowDriverOps))
+	}
+	switch security {
+	case "", "default":
+	case "hardened":
+		opts = append(opts, api.WithSecurity(driver.HardenedSecurity(), trustedImages...))
+	default:
+		log.Fatal().Str("security", security).Msg("Invalid --security: want default or hardened")
+	}
+	if seccompDir != "" {
+		opts = append(opts, api.WithSeccompDir(seccompDir))
+	}
+	// The ranges let through the egress proxy may be cloned from and sent
+	// job callbacks too
+	egressAllow, err := cfg.Egress.Allowed()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid egress allowlist")
+	}
+	opts = append(opts, api.WithGitAllowCIDRs(egressAllow), api.WithCallbackAllowCIDRs(egressAllow))
+	if oidc := cfg.Auth.OIDC; oidc.Issuer != "" {
+		verifier, err := auth.NewOIDCVerifier(ctx, auth.Config{Issuer: oidc.Issuer, Audience: oidc.Audience, OrgClaim: oidc.OrgClaim})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up OIDC authentication")
+		}
+		opts = append(opts, api.WithTokenVerifier(verifier))
+	}
+
+	h := api.NewHandler(d, cfg.Auth.APIKey, opts...)
 	h.RegisterRoutes(e)
+	h.Prepull(cfg.Pool.Prepull)
+
+	var gs *grpc.Server
+	if grpcEnabled || grpcPort != "" {
+		var grpcOpts []grpc.ServerOption
+		if cfg.TLS.Enabled() && grpcPort != "" {
+			creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("Failed to load TLS certificate")
+			}
+			grpcOpts = append(grpcOpts, grpc.Creds(creds))
+		}
+		gs = h.NewGRPCServer(grpcOpts...)
+	}
+	if gs != nil && grpcPort == "" {
+		api.MountGRPC(e, gs)
+	}
+
+	var ss *api.SSHServer
+	if sshPort != "" {
+		hostKey, err := api.LoadSSHHostKey(sshHostKey)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load SSH host key")
+		}
+		if sshHostKey == "" {
+			log.Warn().Msg("SSH host key is generated anew each start; set --ssh-host-key to keep it")
+		}
+		ss = h.NewSSHServer(hostKey)
+	}
+
+	var ep *api.EgressProxy
+	if cfg.Egress.ProxyPort != 0 {
+		ep = h.NewEgressProxy(cfg.Egress.ProxyPort, egressAllow)
+	}
 
 	// Start server
-	serverErr := make(chan error, 1)
+	serverErr := make(chan error, 4)
 	go func() {
-		log.Info().Str("port", port).Msg("🚀 Server listening")
-		serverErr <- e.Start(":" + port)
+		log.Info().Str("port", cfg.Port).Bool("tls", cfg.TLS.Enabled()).Msg("🚀 Server listening")
+		if cfg.TLS.Enabled() {
+			serverErr <- e.StartTLS(":"+cfg.Port, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			return
+		}
+		serverErr <- e.Start(":" + cfg.Port)
 	}()
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for gRPC")
+		}
+		go func() {
+			log.Info().Str("port", grpcPort).Msg("🚀 gRPC listening")
+			serverErr <- gs.Serve(lis)
+		}()
+	}
+	if ss != nil {
+		lis, err := net.Listen("tcp", ":"+sshPort)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for SSH")
+		}
+		go func() {
+			log.Info().Str("port", sshPort).Msg("🚀 SSH gateway listening")
+			serverErr <- ss.Serve(lis)
+		}()
+	}
+	if ep != nil {
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.Egress.ProxyPort))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to listen for the egress proxy")
+		}
+		go func() {
+			log.Info().Int("port", cfg.Egress.ProxyPort).Msg("🚀 Egress proxy listening")
+			serverErr <- ep.Serve(lis)
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
+		// Keep serving while in-flight work finishes; new creates get 503
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer drainCancel()
+		h.Drain(drainCtx, stopOnExit)
+
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer shutdownCancel()
+		if gs != nil {
+			api.StopGRPC(shutdownCtx, gs)
+		}
+		if ss != nil {
+			ss.Shutdown(shutdownCtx)
+		}
+		if ep != nil {
+			ep.Shutdown(shutdownCtx)
+		}
 		if err := e.Shutdown(shutdownCtx); err != nil {
 			log.Error().Err(err).Msg("Server forced to shutdown")
 		}
@@ -93,3 +444,48 @@ func runServer() {
 		log.Fatal().Err(err).Msg("Server startup failed")
 	}
 }
+
+// envInt reads an integer environment variable, falling back to def.
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean environment variable, falling back to def.
+func envBool(key string, def bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a duration environment variable (e.g. "12h"), falling
+// back to def.
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// envList reads a comma-separated environment variable, leaving out empty
+// entries.
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// envString reads an environment variable, falling back to def if unset.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
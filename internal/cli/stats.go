@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsFollow   bool
+	statsInterval time.Duration
+)
+
+type resourceStats struct {
+	Time             time.Time `json:"time"`
+	CPUPercent       *float64  `json:"cpu_percent"`
+	MemoryBytes      int64     `json:"memory_bytes"`
+	MemoryLimitBytes int64     `json:"memory_limit_bytes"`
+	PIDs             int64     `json:"pids"`
+	DiskBytes        int64     `json:"disk_bytes"`
+	DiskReadBytes    int64     `json:"disk_read_bytes"`
+	DiskWriteBytes   int64     `json:"disk_write_bytes"`
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [sandbox-id]",
+	Short: "Show the CPU, memory and disk usage of a sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		u := fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/stats", args[0])
+		if statsFollow {
+			u += "/stream?" + url.Values{"interval": {strconv.FormatFloat(statsInterval.Seconds(), 'f', -1, 64)}}.Encode()
+		}
+		resp, err := http.Get(u)
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		if !structured() {
+			fmt.Printf("%-12s  %6s  %-21s  %5s  %10s  %10s  %10s\n", "TIME", "CPU", "MEMORY", "PIDS", "DISK", "READ", "WRITTEN")
+		}
+		if !statsFollow {
+			var s resourceStats
+			if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+				fmt.Printf("Error parsing response: %v\n", err)
+				os.Exit(1)
+			}
+			printResult(s, func() { printStats(s) })
+			return
+		}
+
+		// Server-sent events: "event: <name>" then "data: <json>"
+		scanner := bufio.NewScanner(resp.Body)
+		event := ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			if e, ok := strings.CutPrefix(line, "event: "); ok {
+				event = e
+				continue
+			}
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			if event == "error" {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", data)
+				os.Exit(1)
+			}
+			var s resourceStats
+			if err := json.Unmarshal([]byte(data), &s); err != nil {
+				fmt.Printf("Error parsing response: %v\n", err)
+				os.Exit(1)
+			}
+			printStreamItem(s, func() { printStats(s) })
+		}
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func printStats(s resourceStats) {
+	cpu := "-"
+	if s.CPUPercent != nil {
+		cpu = fmt.Sprintf("%.1f%%", *s.CPUPercent)
+	}
+	mem := formatBytes(s.MemoryBytes)
+	if s.MemoryLimitBytes > 0 {
+		mem += " / " + formatBytes(s.MemoryLimitBytes)
+	}
+	fmt.Printf("%-12s  %6s  %-21s  %5d  %10s  %10s  %10s\n", s.Time.Local().Format("15:04:05.000"), cpu, mem,
+		s.PIDs, formatBytes(s.DiskBytes), formatBytes(s.DiskReadBytes), formatBytes(s.DiskWriteBytes))
+}
+
+func init() {
+	statsCmd.Flags().BoolVarP(&statsFollow, "follow", "f", false, "Keep printing samples until the sandbox stops")
+	statsCmd.Flags().DurationVar(&statsInterval, "interval", time.Second, "Time between samples with --follow")
+	RootCmd.AddCommand(statsCmd)
+}
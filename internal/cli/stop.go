@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var stopCmd = &cobra.Command{
+	Use:   "stop [sandbox-id...]",
+	Short: "Stop sandboxes by ID",
+	Long: `Stop and remove the given sandboxes, such as those of boxed create. To
+stop sandboxes by label or state, use boxed rm.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		result := rmResult{Stopped: []string{}, Failed: []rmFailure{}}
+		for _, id := range args {
+			if err := rmSandbox(id); err != nil {
+				result.Failed = append(result.Failed, rmFailure{ID: id, Error: err.Error()})
+				continue
+			}
+			result.Stopped = append(result.Stopped, id)
+		}
+
+		printResult(result, func() {
+			for _, id := range result.Stopped {
+				fmt.Println(id)
+			}
+			for _, f := range result.Failed {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", f.ID, f.Error)
+			}
+		})
+		if len(result.Failed) > 0 {
+			os.Exit(1)
+		}
+	},
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		return sandboxCompletions(toComplete, ""), cobra.ShellCompDirectiveNoFileComp
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(stopCmd)
+}
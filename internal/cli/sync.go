@@ -0,0 +1,286 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+// syncDebounce is how long a path must be quiet before it is re-uploaded.
+// Editors typically emit several write events per save.
+const syncDebounce = 150 * time.Millisecond
+
+var syncCmd = &cobra.Command{
+	Use:   "sync [local-dir] [sandbox-id]:[remote-dir]",
+	Short: "Upload a local directory into a sandbox, optionally watching for changes",
+	Long: `Recursively uploads local-dir into remote-dir inside the sandbox.
+
+With --watch, the command keeps running and pushes every created or modified
+file as soon as it changes, enabling a local-edit / sandbox-run loop.
+Deletions are not propagated.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		localDir := args[0]
+		parts := splitRemote(args[1])
+		if parts == nil {
+			fmt.Println("Invalid remote format. Use ID:/path/to/dest")
+			os.Exit(1)
+		}
+
+		watch, _ := cmd.Flags().GetBool("watch")
+		excludes, _ := cmd.Flags().GetStringSlice("exclude")
+
+		info, err := os.Stat(localDir)
+		if err != nil || !info.IsDir() {
+			fmt.Printf("%s is not a directory\n", localDir)
+			os.Exit(1)
+		}
+
+		s := &syncer{
+			id:        parts[0],
+			localDir:  localDir,
+			remoteDir: parts[1],
+			excludes:  excludes,
+		}
+
+		count, err := s.uploadTree(localDir)
+		if err != nil {
+			fmt.Printf("Sync failed: %v\n", err)
+			os.Exit(1)
+		}
+		result := uploaded{SandboxID: s.id, Path: s.remoteDir, Files: count}
+		if watch {
+			// Changes follow as further results
+			printStreamItem(result, func() {
+				fmt.Printf("Synced %d files to %s:%s\n", count, s.id, s.remoteDir)
+			})
+		} else {
+			printResult(result, func() {
+				fmt.Printf("Synced %d files to %s:%s\n", count, s.id, s.remoteDir)
+			})
+		}
+
+		if !watch {
+			return
+		}
+		if err := s.watch(); err != nil {
+			fmt.Printf("Watch failed: %v\n", err)
+			os.Exit(1)
+		}
+	},
+	ValidArgsFunction: completeLocalToRemote,
+}
+
+func init() {
+	syncCmd.Flags().BoolP("watch", "w", false, "Keep running and push local changes")
+	syncCmd.Flags().StringSlice("exclude", []string{".git", "node_modules", "__pycache__"}, "File or directory names to skip")
+	filesCmd.AddCommand(syncCmd)
+}
+
+// syncer mirrors a local directory tree into a sandbox directory.
+type syncer struct {
+	id        string
+	localDir  string
+	remoteDir string
+	excludes  []string
+}
+
+// excluded reports whether a file or directory name matches an exclude pattern.
+func (s *syncer) excluded(name string) bool {
+	for _, pattern := range s.excludes {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteDirFor returns the sandbox directory a local file should be uploaded into.
+func (s *syncer) remoteDirFor(localPath string) (string, error) {
+	rel, err := filepath.Rel(s.localDir, filepath.Dir(localPath))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.remoteDir, filepath.ToSlash(rel)), nil
+}
+
+// upload pushes a single local file to its mirrored location.
+func (s *syncer) upload(localPath string) error {
+	dir, err := s.remoteDirFor(localPath)
+	if err != nil {
+		return err
+	}
+	return uploadFile(s.id, localPath, dir)
+}
+
+// uploadTree recursively uploads every regular file under root, many files
+// per request.
+func (s *syncer) uploadTree(root string) (int, error) {
+	var local, remote []string
+	flush := func() error {
+		if len(local) == 0 {
+			return nil
+		}
+		if err := uploadFiles(s.id, local, remote); err != nil {
+			return err
+		}
+		local, remote = local[:0], remote[:0]
+		return nil
+	}
+	count := 0
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && s.excluded(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		dir, err := s.remoteDirFor(p)
+		if err != nil {
+			return err
+		}
+		local = append(local, p)
+		remote = append(remote, path.Join(dir, d.Name()))
+		count++
+		if len(local) == uploadBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return count, err
+}
+
+// addWatches registers root and all of its non-excluded subdirectories.
+// fsnotify is not recursive, so every directory needs its own watch.
+func (s *syncer) addWatches(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != root && s.excluded(d.Name()) {
+			return filepath.SkipDir
+		}
+		return w.Add(p)
+	})
+}
+
+// watch pushes changes until interrupted.
+func (s *syncer) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if err := s.addWatches(w, s.localDir); err != nil {
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	fmt.Fprintf(os.Stderr, "Watching %s for changes. CTRL+C to stop.\n", s.localDir)
+
+	// Debounce: remember the last event time per path and flush quiet ones.
+	pending := make(map[string]time.Time)
+	ticker := time.NewTicker(syncDebounce / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stderr, "Stopped watching")
+			return nil
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+
+		case event, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if s.excluded(filepath.Base(event.Name)) {
+				continue
+			}
+
+			switch {
+			case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+				info, err := os.Stat(event.Name)
+				if err != nil {
+					continue
+				}
+				if info.IsDir() {
+					// A new directory: watch it and push whatever it already holds
+					// (e.g. a directory moved in from elsewhere).
+					if err := s.addWatches(w, event.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to watch %s: %v\n", event.Name, err)
+					}
+					if n, err := s.uploadTree(event.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to sync %s: %v\n", event.Name, err)
+					} else if n > 0 {
+						s.synced(event.Name, n)
+					}
+					continue
+				}
+				pending[event.Name] = time.Now()
+
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				delete(pending, event.Name)
+				fmt.Fprintf(os.Stderr, "Skipped removal of %s (deletes are not synced)\n", event.Name)
+			}
+
+		case now := <-ticker.C:
+			for p, last := range pending {
+				if now.Sub(last) < syncDebounce {
+					continue
+				}
+				delete(pending, p)
+				if err := s.upload(p); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to sync %s: %v\n", p, err)
+					continue
+				}
+				s.synced(p, 1)
+			}
+		}
+	}
+}
+
+// synced reports files uploaded from localPath, a file or a directory,
+// while watching.
+func (s *syncer) synced(localPath string, n int) {
+	info, err := os.Stat(localPath)
+	isDir := err == nil && info.IsDir()
+	dir, _ := s.remoteDirFor(localPath)
+	if isDir {
+		dir, _ = s.remoteDirFor(filepath.Join(localPath, "x"))
+	}
+	printStreamItem(uploaded{SandboxID: s.id, Path: dir, Files: n}, func() {
+		if isDir {
+			fmt.Printf("Synced %d files from %s\n", n, localPath)
+		} else {
+			fmt.Printf("Synced %s\n", localPath)
+		}
+	})
+}
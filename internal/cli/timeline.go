@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// timelineWidth is the number of columns used for the Gantt bars.
+const timelineWidth = 40
+
+// timeline is a sandbox's timeline as printed by timeline -o json|yaml.
+type timeline struct {
+	TotalMS int64           `json:"total_ms"`
+	Events  []timelineEvent `json:"events"`
+}
+
+type timelineEvent struct {
+	Type       string `json:"type"`
+	OffsetMS   int64  `json:"offset_ms"`
+	DurationMS int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline [sandbox-id]",
+	Short: "Show the lifecycle timeline of a sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/timeline", id))
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result timeline
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		if result.Events == nil {
+			result.Events = []timelineEvent{}
+		}
+
+		printResult(result, func() {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+			fmt.Fprintln(w, "OFFSET\tEVENT\tDURATION\t\tDETAIL")
+			for _, e := range result.Events {
+				detail := e.Detail
+				if e.Error != "" {
+					detail = strings.TrimSpace(detail + " error: " + e.Error)
+				}
+				fmt.Fprintf(w, "+%s\t%s\t%s\t%s\t%s\n",
+					ms(e.OffsetMS), e.Type, ms(e.DurationMS), ganttBar(e.OffsetMS, e.DurationMS, result.TotalMS), detail)
+			}
+			w.Flush()
+			fmt.Printf("\nTotal: %s\n", ms(result.TotalMS))
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func ms(v int64) string {
+	return (time.Duration(v) * time.Millisecond).String()
+}
+
+// ganttBar renders an event as a bar positioned within the total span.
+func ganttBar(offset, duration, total int64) string {
+	if total <= 0 {
+		return "|" + strings.Repeat(" ", timelineWidth) + "|"
+	}
+	start := int(offset * timelineWidth / total)
+	length := int(duration * timelineWidth / total)
+	if length == 0 {
+		length = 1
+	} else if length > timelineWidth {
+		length = timelineWidth
+	}
+	if start+length > timelineWidth {
+		start = timelineWidth - length
+	}
+	return "|" + strings.Repeat(" ", start) + strings.Repeat("█", length) + strings.Repeat(" ", timelineWidth-start-length) + "|"
+}
+
+func init() {
+	RootCmd.AddCommand(timelineCmd)
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ttlSet    time.Duration
+	ttlExtend time.Duration
+)
+
+// ttlResult is what ttl prints with -o json|yaml; ExpiresAt is null if
+// the driver cannot tell.
+type ttlResult struct {
+	SandboxID string     `json:"sandbox_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+var ttlCmd = &cobra.Command{
+	Use:   "ttl [sandbox-id]",
+	Short: "Show or change when a sandbox expires",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		var resp *http.Response
+		var err error
+		if ttlSet == 0 && ttlExtend == 0 {
+			resp, err = http.Get(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s", id))
+		} else {
+			body, _ := json.Marshal(map[string]int{
+				"ttl":       int(ttlSet / time.Second),
+				"extend_by": int(ttlExtend / time.Second),
+			})
+			resp, err = http.Post(fmt.Sprintf("http://localhost:8080/v1/sandbox/%s/ttl", id),
+				"application/json", bytes.NewReader(body))
+		}
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+
+		var result struct {
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		printResult(ttlResult{SandboxID: id, ExpiresAt: result.ExpiresAt}, func() {
+			if result.ExpiresAt == nil {
+				fmt.Println("Expiry unknown")
+				return
+			}
+			fmt.Printf("Expires at %s (in %s)\n", result.ExpiresAt.Local().Format(time.RFC3339),
+				time.Until(*result.ExpiresAt).Round(time.Second))
+		})
+	},
+	ValidArgsFunction: completeSandboxID,
+}
+
+func init() {
+	ttlCmd.Flags().DurationVar(&ttlSet, "set", 0, "Set the remaining lifetime (e.g. 30m)")
+	ttlCmd.Flags().DurationVar(&ttlExtend, "extend", 0, "Extend the lifetime by this much; negative shortens it")
+	ttlCmd.MarkFlagsMutuallyExclusive("set", "extend")
+	RootCmd.AddCommand(ttlCmd)
+}
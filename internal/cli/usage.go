@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type usageTotals struct {
+	Sandboxes     int     `json:"sandboxes"`
+	CPUSeconds    float64 `json:"cpu_seconds"`
+	MemoryMBHours float64 `json:"memory_mb_hours"`
+	WallSeconds   float64 `json:"wall_seconds"`
+}
+
+type usageGroup struct {
+	Group string `json:"group"`
+	usageTotals
+}
+
+// usageReport is the report as printed by usage -o json|yaml.
+type usageReport struct {
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	GroupBy string       `json:"group_by"`
+	Groups  []usageGroup `json:"groups"`
+	Total   usageTotals  `json:"total"`
+}
+
+var (
+	usageFrom    string
+	usageTo      string
+	usageGroupBy string
+	usageCSV     bool
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the resources sandboxes consumed, for charging them back",
+	Long: `Show the CPU time, memory and lifetime sandboxes consumed within a period,
+grouped by the key that created them (the default), image, sandbox or the
+value of a label. The period defaults to the current month; --csv prints the
+report as CSV for spreadsheets and billing systems.`,
+	Example: `  boxed usage --from 2026-09-01 --to 2026-10-01 --group-by label:team --csv > september.csv`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		q := url.Values{}
+		for name, v := range map[string]string{"from": usageFrom, "to": usageTo, "group_by": usageGroupBy} {
+			if v != "" {
+				q.Set(name, v)
+			}
+		}
+		if usageCSV {
+			q.Set("format", "csv")
+		}
+
+		resp, err := http.Get("http://localhost:8080/v1/usage?" + q.Encode())
+		if err != nil {
+			fmt.Printf("Error connecting to server: %v\nIs the server running?\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error: %s\n", resp.Status)
+			io.Copy(os.Stderr, resp.Body)
+			os.Exit(1)
+		}
+		if usageCSV {
+			io.Copy(os.Stdout, resp.Body)
+			return
+		}
+
+		var report usageReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			fmt.Printf("Error parsing response: %v\n", err)
+			os.Exit(1)
+		}
+		printResult(report, func() {
+			fmt.Printf("Usage from %s to %s\n", report.From.Local().Format(time.DateTime), report.To.Local().Format(time.DateTime))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "%s\tSANDBOXES\tCPU SECONDS\tMEMORY MB-HOURS\tLIFETIME\n", groupHeader(report.GroupBy))
+			for _, g := range report.Groups {
+				printUsageRow(w, g.Group, g.usageTotals)
+			}
+			printUsageRow(w, "TOTAL", report.Total)
+			w.Flush()
+		})
+	},
+}
+
+// groupHeader names the first column of the usage table.
+func groupHeader(groupBy string) string {
+	if name, ok := strings.CutPrefix(groupBy, "label:"); ok {
+		return "LABEL " + name
+	}
+	return map[string]string{"key": "KEY", "image": "IMAGE", "sandbox": "SANDBOX"}[groupBy]
+}
+
+func printUsageRow(w io.Writer, group string, t usageTotals) {
+	if group == "" {
+		group = "-"
+	}
+	lifetime := time.Duration(t.WallSeconds * float64(time.Second)).Round(time.Second)
+	fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%s\n", group, t.Sandboxes, t.CPUSeconds, t.MemoryMBHours, lifetime)
+}
+
+func init() {
+	usageCmd.Flags().StringVar(&usageFrom, "from", "", "Start of the period, as YYYY-MM-DD or an RFC 3339 time (default: start of the month)")
+	usageCmd.Flags().StringVar(&usageTo, "to", "", "End of the period, as YYYY-MM-DD or an RFC 3339 time (default: now)")
+	usageCmd.Flags().StringVar(&usageGroupBy, "group-by", "key", "Group by key, image, sandbox or label:<name>")
+	usageCmd.Flags().BoolVar(&usageCSV, "csv", false, "Print the report as CSV")
+	RootCmd.AddCommand(usageCmd)
+}
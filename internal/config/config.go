@@ -0,0 +1,539 @@
+// Package config loads the settings of a Boxed server from a YAML file,
+// boxed.yaml by default:
+//
+//	port: 8080
+//	driver:
+//	  names: [docker, wasm]      # the first is the default
+//	  routes: ["python:*=docker"]
+//	  plugin_dir: plugins
+//	  options:                   # passed to the drivers as they are
+//	    reconcile_interval: 30s
+//	    agent_restarts: 5
+//	pool:
+//	  ready_min: 2
+//	  max_sandboxes: 100
+//	  prepull: [python:3.10-slim]
+//	limits:
+//	  max_ttl: 1h
+//	  max_output: 1048576
+//	tls:
+//	  cert_file: /etc/boxed/tls.crt
+//	  key_file: /etc/boxed/tls.key
+//	auth:
+//	  api_key: 3f9c...
+//	  keys_file: /etc/boxed/keys.yaml
+//	  keys:
+//	    - {name: ci, key: 8a1d...}
+//	  oidc:
+//	    issuer: https://login.example.com
+//	    audience: boxed
+//	admission:
+//	  webhooks:
+//	    - {name: policy, url: https://policy.example.com/review}
+//	cors:
+//	  allowed_origins: [https://console.example.com]
+//	  allow_credentials: true
+//	scheduling:
+//	  slots: 32
+//	  sandbox_slots: 1
+//	  classes: {interactive: 8, standard: 4, batch: 1}
+//	metrics:
+//	  slow_driver_ops: {create: 5s, stop: 0s}
+//	templates: /etc/boxed/templates.yaml
+//	storage:
+//	  state_dir: /var/lib/boxed/state
+//	  artifact_dir: /var/lib/boxed/artifacts
+//	egress:
+//	  proxy_port: 3129
+//	  allow_cidrs: [10.0.5.0/24]
+//	dashboard: true
+//
+// Settings the file leaves out keep their defaults. Environment variables,
+// such as BOXED_MAX_TTL, override the file, and the server's flags override
+// both; the env and flag tags of Config's fields name them.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver/instrument"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is loaded when no file is named, if it exists.
+const DefaultFile = "boxed.yaml"
+
+// Config is the configuration of a server.
+type Config struct {
+	// Path is the file the configuration was loaded from, if any
+	Path string `yaml:"-"`
+
+	Port       string           `yaml:"port" env:"PORT" flag:"port"`
+	Driver     DriverConfig     `yaml:"driver"`
+	Pool       PoolConfig       `yaml:"pool"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	TLS        TLSConfig        `yaml:"tls"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Admission  AdmissionConfig  `yaml:"admission"`
+	CORS       CORSConfig       `yaml:"cors"`
+	Scheduling SchedulingConfig `yaml:"scheduling"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Templates  string           `yaml:"templates" env:"BOXED_TEMPLATES" flag:"templates"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Egress     EgressConfig     `yaml:"egress"`
+
+	// Dashboard serves the web dashboard at /ui
+	Dashboard bool `yaml:"dashboard" env:"BOXED_DASHBOARD" flag:"dashboard"`
+}
+
+// DriverConfig chooses the drivers sandboxes run on.
+type DriverConfig struct {
+	// Names are docker, wasm or plugins; with several, Routes choose
+	// between them and the first is the default
+	Names     []string `yaml:"names" env:"BOXED_DRIVER" flag:"driver"`
+	Routes    []string `yaml:"routes" env:"BOXED_DRIVER_ROUTES" flag:"driver-route"`
+	PluginDir string   `yaml:"plugin_dir" env:"BOXED_PLUGIN_DIR" flag:"plugin-dir"`
+
+	// Options are the drivers' config, such as modules_dir of the wasm
+	// driver; see Settings
+	Options map[string]any `yaml:"options"`
+}
+
+// PoolConfig sizes the sandboxes a server keeps.
+type PoolConfig struct {
+	// ReadyMin is the warm sandboxes a pooled driver needs before the
+	// server reports ready
+	ReadyMin int `yaml:"ready_min" env:"BOXED_READY_POOL_MIN" flag:"ready-pool-min"`
+
+	// MaxSandboxes refuses creates while as many are live; 0 means no limit
+	MaxSandboxes int `yaml:"max_sandboxes" env:"BOXED_MAX_SANDBOXES" flag:"max-sandboxes"`
+
+	// Prepull are images pulled in the background at startup
+	Prepull []string `yaml:"prepull" env:"BOXED_PREPULL" flag:"prepull"`
+}
+
+// LimitsConfig bounds what requests and sandboxes may use; see the
+// matching options of package api.
+type LimitsConfig struct {
+	MaxOutput          int           `yaml:"max_output" env:"BOXED_MAX_OUTPUT" flag:"max-output"`
+	MaxTTL             time.Duration `yaml:"max_ttl" env:"BOXED_MAX_TTL" flag:"max-ttl"`
+	MaxSandboxAge      time.Duration `yaml:"max_sandbox_age" env:"BOXED_MAX_SANDBOX_AGE" flag:"max-sandbox-age"`
+	MaxContextFileSize int           `yaml:"max_context_file_size" env:"BOXED_MAX_CONTEXT_FILE_SIZE" flag:"max-context-file-size"`
+	MaxContextSize     int           `yaml:"max_context_size" env:"BOXED_MAX_CONTEXT_SIZE" flag:"max-context-size"`
+	MaxCodeSize        int           `yaml:"max_code_size" env:"BOXED_MAX_CODE_SIZE" flag:"max-code-size"`
+	MaxArgs            int           `yaml:"max_args" env:"BOXED_MAX_ARGS" flag:"max-args"`
+	MaxEnvVars         int           `yaml:"max_env_vars" env:"BOXED_MAX_ENV_VARS" flag:"max-env-vars"`
+	MaxEnvSize         int           `yaml:"max_env_size" env:"BOXED_MAX_ENV_SIZE" flag:"max-env-size"`
+	MaxBodySize        int           `yaml:"max_body_size" env:"BOXED_MAX_BODY_SIZE" flag:"max-body-size"`
+
+	// DownloadStallTimeout gives up file downloads that make no progress
+	// for as long; negative never gives up
+	DownloadStallTimeout time.Duration `yaml:"download_stall_timeout" env:"BOXED_DOWNLOAD_STALL_TIMEOUT" flag:"download-stall-timeout"`
+}
+
+// Inputs returns the limits of requests.
+func (l LimitsConfig) Inputs() api.InputLimits {
+	return api.InputLimits{
+		CodeSize: l.MaxCodeSize,
+		Args:     l.MaxArgs,
+		EnvVars:  l.MaxEnvVars,
+		EnvSize:  l.MaxEnvSize,
+		BodySize: l.MaxBodySize,
+	}
+}
+
+// TLSConfig serves the API over HTTPS when both files are set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" env:"BOXED_TLS_CERT" flag:"tls-cert"`
+	KeyFile  string `yaml:"key_file" env:"BOXED_TLS_KEY" flag:"tls-key"`
+}
+
+// Enabled reports whether the API is served over HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// AuthConfig sets who may call the API; with none of it set, anyone may.
+type AuthConfig struct {
+	APIKey   string `yaml:"api_key" env:"BOXED_API_KEY" flag:"api-key"`
+	KeysFile string `yaml:"keys_file" env:"BOXED_API_KEYS_FILE" flag:"api-keys-file"`
+
+	// Keys are named keys in addition to those of KeysFile
+	Keys []api.APIKey `yaml:"keys"`
+
+	OIDC OIDCConfig `yaml:"oidc"`
+}
+
+// OIDCConfig accepts the bearer tokens of an OpenID Connect issuer.
+type OIDCConfig struct {
+	Issuer   string `yaml:"issuer" env:"BOXED_OIDC_ISSUER" flag:"oidc-issuer"`
+	Audience string `yaml:"audience" env:"BOXED_OIDC_AUDIENCE" flag:"oidc-audience"`
+	OrgClaim string `yaml:"org_claim" env:"BOXED_OIDC_ORG_CLAIM" flag:"oidc-org-claim"`
+}
+
+// AdmissionConfig sets the webhooks that review creates and execs before
+// they run.
+type AdmissionConfig struct {
+	Webhooks []api.AdmissionWebhook `yaml:"webhooks"`
+}
+
+// CORSConfig sets the origins whose web pages may call the API; with none,
+// the API sends no CORS headers.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins" env:"BOXED_CORS_ORIGINS" flag:"cors-origin"`
+	AllowedHeaders   []string      `yaml:"allowed_headers" env:"BOXED_CORS_HEADERS" flag:"cors-header"`
+	AllowCredentials bool          `yaml:"allow_credentials" env:"BOXED_CORS_CREDENTIALS" flag:"cors-credentials"`
+	MaxAge           time.Duration `yaml:"max_age" env:"BOXED_CORS_MAX_AGE" flag:"cors-max-age"`
+}
+
+// Policy returns the CORS policy of the API.
+func (c CORSConfig) Policy() api.CORSPolicy {
+	return api.CORSPolicy{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedHeaders:   c.AllowedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+// SchedulingConfig shares the exec slots of the server between callers,
+// and bounds the execs of each sandbox; with neither, execs run as they
+// come. See api.ExecScheduling.
+type SchedulingConfig struct {
+	Slots        int            `yaml:"slots" env:"BOXED_EXEC_SLOTS" flag:"exec-slots"`
+	SandboxSlots int            `yaml:"sandbox_slots" env:"BOXED_EXEC_SANDBOX_SLOTS" flag:"exec-sandbox-slots"`
+	MaxQueue     int            `yaml:"max_queue" env:"BOXED_EXEC_MAX_QUEUE" flag:"exec-max-queue"`
+	MaxWait      time.Duration  `yaml:"max_wait" env:"BOXED_EXEC_MAX_WAIT" flag:"exec-max-wait"`
+	DefaultClass string         `yaml:"default_class" env:"BOXED_EXEC_DEFAULT_CLASS" flag:"exec-default-class"`
+	Classes      map[string]int `yaml:"classes"`
+}
+
+// Exec returns the exec scheduling of the API.
+func (s SchedulingConfig) Exec() api.ExecScheduling {
+	return api.ExecScheduling{
+		Slots:        s.Slots,
+		SandboxSlots: s.SandboxSlots,
+		MaxQueue:     s.MaxQueue,
+		MaxWait:      s.MaxWait,
+		Classes:      s.Classes,
+		DefaultClass: s.DefaultClass,
+	}
+}
+
+// MetricsConfig tunes what the server reports about itself.
+type MetricsConfig struct {
+	// SlowDriverOps are the thresholds above which driver calls are logged
+	// as slow, by operation; see api.WithSlowDriverOps
+	SlowDriverOps map[string]time.Duration `yaml:"slow_driver_ops"`
+}
+
+// StorageConfig sets where a server keeps data; empty directories disable
+// what they hold.
+type StorageConfig struct {
+	// StateDir keeps sandbox records, timelines and exec history
+	StateDir string `yaml:"state_dir" env:"BOXED_STATE_DIR" flag:"state-dir"`
+
+	// ArtifactDir keeps the deduplicating artifact store
+	ArtifactDir string `yaml:"artifact_dir" env:"BOXED_ARTIFACT_DIR" flag:"artifact-dir"`
+}
+
+// EgressConfig sets the proxy sandboxes created with
+// network_policy.enable_internet reach the internet through; without a
+// port they get no internet access. See api.EgressProxy.
+type EgressConfig struct {
+	ProxyPort int `yaml:"proxy_port" env:"BOXED_EGRESS_PROXY_PORT" flag:"egress-proxy-port"`
+
+	// AllowCIDRs are private ranges the proxy lets sandboxes reach all
+	// the same, such as that of a package mirror, e.g. "10.0.5.0/24"
+	AllowCIDRs []string `yaml:"allow_cidrs" env:"BOXED_EGRESS_ALLOW_CIDRS" flag:"egress-allow-cidr"`
+}
+
+// Allowed returns AllowCIDRs parsed.
+func (e EgressConfig) Allowed() ([]netip.Prefix, error) {
+	var allow []netip.Prefix
+	for _, c := range e.AllowCIDRs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", c)
+		}
+		allow = append(allow, p.Masked())
+	}
+	return allow, nil
+}
+
+// Default returns the configuration of a server started without a file,
+// environment variables or flags.
+func Default() *Config {
+	return &Config{
+		Port: "8080",
+		Driver: DriverConfig{
+			Names:     []string{"docker"},
+			PluginDir: "plugins",
+		},
+		Limits: LimitsConfig{
+			MaxOutput:          api.DefaultMaxOutput,
+			MaxTTL:             api.DefaultMaxTTL,
+			MaxContextFileSize: api.DefaultMaxContextFileSize,
+			MaxContextSize:     api.DefaultMaxContextSize,
+			MaxCodeSize:        api.DefaultMaxCodeSize,
+			MaxArgs:            api.DefaultMaxArgs,
+			MaxEnvVars:         api.DefaultMaxEnvVars,
+			MaxEnvSize:         api.DefaultMaxEnvSize,
+			MaxBodySize:        api.DefaultMaxBodySize,
+		},
+		Auth: AuthConfig{
+			OIDC: OIDCConfig{OrgClaim: auth.DefaultOrgClaim},
+		},
+		Dashboard: true,
+	}
+}
+
+// Load returns the defaults overridden by the file at path, or DefaultFile
+// if path is empty and it exists, and then by the environment. It does
+// not validate the result; see Validate.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			path = DefaultFile
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+		cfg.Path = path
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyEnv sets the settings whose environment variable is set.
+func (c *Config) applyEnv() error {
+	var errs []error
+	settings(c, func(f reflect.StructField, v reflect.Value) {
+		name := f.Tag.Get("env")
+		value := os.Getenv(name)
+		if name == "" || value == "" {
+			return
+		}
+		if err := set(v, value); err != nil {
+			errs = append(errs, fmt.Errorf("$%s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// set parses s into v.
+func set(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeFor[time.Duration]():
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		v.SetString(s)
+	}
+	return nil
+}
+
+// Override sets the settings of c whose flag changed reports as given on
+// the command line to their value in flags.
+func (c *Config) Override(flags *Config, changed func(flag string) bool) {
+	from := make(map[string]reflect.Value)
+	settings(flags, func(f reflect.StructField, v reflect.Value) {
+		from[f.Tag.Get("flag")] = v
+	})
+	settings(c, func(f reflect.StructField, v reflect.Value) {
+		if name := f.Tag.Get("flag"); name != "" && changed(name) {
+			v.Set(from[name])
+		}
+	})
+}
+
+// settings calls fn with each setting of c, the fields of its structs
+// that have an env or flag tag.
+func settings(c *Config, fn func(reflect.StructField, reflect.Value)) {
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := range v.NumField() {
+			f, fv := v.Type().Field(i), v.Field(i)
+			switch {
+			case f.Tag.Get("env") != "" || f.Tag.Get("flag") != "":
+				fn(f, fv)
+			case f.Type.Kind() == reflect.Struct:
+				walk(fv)
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+}
+
+// Validate reports every setting that would keep the server from starting
+// or misbehave, such as routes to drivers that are not configured or TLS
+// files that do not hold a key pair.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(setting, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		fail("port", "%q is not a port number", c.Port)
+	}
+
+	if len(c.Driver.Names) == 0 {
+		fail("driver.names", "at least one driver is required")
+	}
+	for _, name := range c.Driver.Names {
+		if name == "" {
+			fail("driver.names", "driver names cannot be empty")
+		}
+	}
+	routes, err := multi.ParseRoutes(strings.Join(c.Driver.Routes, ","))
+	if err != nil {
+		fail("driver.routes", "%v", err)
+	}
+	for _, r := range routes {
+		if !slices.Contains(c.Driver.Names, r.Driver) {
+			fail("driver.routes", "route %s=%s names a driver not in driver.names", r.Pattern, r.Driver)
+		}
+	}
+
+	for _, s := range []struct {
+		name string
+		n    int64
+	}{
+		{"pool.ready_min", int64(c.Pool.ReadyMin)},
+		{"pool.max_sandboxes", int64(c.Pool.MaxSandboxes)},
+		{"limits.max_output", int64(c.Limits.MaxOutput)},
+		{"limits.max_ttl", int64(c.Limits.MaxTTL)},
+		{"limits.max_sandbox_age", int64(c.Limits.MaxSandboxAge)},
+		{"limits.max_context_file_size", int64(c.Limits.MaxContextFileSize)},
+		{"limits.max_context_size", int64(c.Limits.MaxContextSize)},
+		{"limits.max_code_size", int64(c.Limits.MaxCodeSize)},
+		{"limits.max_args", int64(c.Limits.MaxArgs)},
+		{"limits.max_env_vars", int64(c.Limits.MaxEnvVars)},
+		{"limits.max_env_size", int64(c.Limits.MaxEnvSize)},
+		{"limits.max_body_size", int64(c.Limits.MaxBodySize)},
+	} {
+		if s.n < 0 {
+			fail(s.name, "cannot be negative")
+		}
+	}
+
+	switch t := c.TLS; {
+	case t.CertFile == "" && t.KeyFile == "":
+	case t.CertFile == "" || t.KeyFile == "":
+		fail("tls", "cert_file and key_file must be set together")
+	default:
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			fail("tls", "%v", err)
+		}
+	}
+
+	if err := api.CheckAPIKeys(c.Auth.Keys); err != nil {
+		fail("auth.keys", "%v", err)
+	}
+	if c.Auth.OIDC.Issuer == "" && c.Auth.OIDC.Audience != "" {
+		fail("auth.oidc", "audience is set without an issuer")
+	}
+
+	if err := api.CheckAdmissionWebhooks(c.Admission.Webhooks); err != nil {
+		fail("admission.webhooks", "%v", err)
+	}
+
+	if err := api.CheckCORS(c.CORS.Policy()); err != nil {
+		fail("cors", "%v", err)
+	}
+	if err := api.CheckExecScheduling(c.Scheduling.Exec(), c.Auth.Keys); err != nil {
+		fail("scheduling", "%v", err)
+	}
+
+	if c.Egress.ProxyPort < 0 || c.Egress.ProxyPort > 65535 {
+		fail("egress.proxy_port", "must be a port number")
+	}
+	if _, err := c.Egress.Allowed(); err != nil {
+		fail("egress.allow_cidrs", "%v", err)
+	}
+
+	for op, t := range c.Metrics.SlowDriverOps {
+		if !slices.Contains(instrument.Ops, op) {
+			fail("metrics.slow_driver_ops", "unknown operation %q", op)
+		} else if t < 0 {
+			fail("metrics.slow_driver_ops", "threshold of %s cannot be negative", op)
+		}
+	}
+
+	if c.Templates != "" {
+		if _, err := os.Stat(c.Templates); err != nil {
+			fail("templates", "%v", err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		if c.Path != "" {
+			return fmt.Errorf("config: %s: %w", c.Path, err)
+		}
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// Settings returns the config of the drivers: Options with durations such as
+// "30s" parsed into a time.Duration, as the drivers expect.
+func (d DriverConfig) Settings() map[string]any {
+	cfg := make(map[string]any, len(d.Options))
+	for k, v := range d.Options {
+		if s, ok := v.(string); ok {
+			if dur, err := time.ParseDuration(s); err == nil {
+				v = dur
+			}
+		}
+		cfg[k] = v
+	}
+	return cfg
+}
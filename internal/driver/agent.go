@@ -0,0 +1,336 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of AgentHealth.
+const (
+	DefaultAgentPingInterval = 15 * time.Second
+	DefaultAgentPingTimeout  = 5 * time.Second
+	DefaultAgentRestarts     = 3
+	DefaultAgentBackoff      = 100 * time.Millisecond
+)
+
+// ErrAgentUnresponsive is why an agent that did not answer a ping in time
+// was given up on.
+var ErrAgentUnresponsive = errors.New("agent stopped responding")
+
+// pingID prefixes the IDs of the pings AgentHealth sends, which tell their
+// answers apart from those the caller of Connect waits for.
+const pingID = "boxed-ping-"
+
+// AgentHealth checks the agents a driver's Connect starts, and starts them
+// again when they fail. Zero values mean the defaults.
+type AgentHealth struct {
+	// PingInterval is how long an established connection may be quiet
+	// before its agent is pinged; negative disables pinging it
+	PingInterval time.Duration
+
+	// PingTimeout is how long an agent has to answer a ping
+	PingTimeout time.Duration
+
+	// Restarts bounds how often Connect starts an agent again after the
+	// last one failed; negative allows none
+	Restarts int
+
+	// Backoff is the delay before the first restart, doubled before each
+	// further one
+	Backoff time.Duration
+}
+
+// AgentHealthConfig reads the AgentHealth of a driver from its config:
+// cfg["agent_ping_interval"], cfg["agent_ping_timeout"] and
+// cfg["agent_backoff"] (time.Duration) and cfg["agent_restarts"] (int).
+func AgentHealthConfig(cfg map[string]any) AgentHealth {
+	var h AgentHealth
+	h.PingInterval, _ = cfg["agent_ping_interval"].(time.Duration)
+	h.PingTimeout, _ = cfg["agent_ping_timeout"].(time.Duration)
+	h.Backoff, _ = cfg["agent_backoff"].(time.Duration)
+	h.Restarts, _ = cfg["agent_restarts"].(int)
+	return h
+}
+
+func (h AgentHealth) withDefaults() AgentHealth {
+	if h.PingInterval == 0 {
+		h.PingInterval = DefaultAgentPingInterval
+	}
+	if h.PingTimeout <= 0 {
+		h.PingTimeout = DefaultAgentPingTimeout
+	}
+	if h.Restarts == 0 {
+		h.Restarts = DefaultAgentRestarts
+	} else if h.Restarts < 0 {
+		h.Restarts = 0
+	}
+	if h.Backoff <= 0 {
+		h.Backoff = DefaultAgentBackoff
+	}
+	return h
+}
+
+// Connect returns a connection to an agent that start starts, once the
+// agent has answered a ping. Agents that fail to start or to answer are
+// started again, with backoff, up to Restarts times. ErrSandboxNotFound
+// and ErrSandboxNotRunning are returned at once.
+//
+// crashed is called for each agent that exited or stopped responding,
+// whether during Connect or while the connection is in use. The
+// connection pings its agent whenever it has been quiet for PingInterval,
+// and closes it if it does not answer: reads then end as if the agent had
+// exited. Callers must write whole lines.
+func (h AgentHealth) Connect(ctx context.Context, id string, start func(context.Context) (io.ReadWriteCloser, error), crashed func(error)) (io.ReadWriteCloser, error) {
+	h = h.withDefaults()
+	backoff := h.Backoff
+	for restarts := 0; ; restarts++ {
+		rwc, err := start(ctx)
+		if err == nil {
+			c := newAgentConn(rwc, h, crashed)
+			if err = c.ping(ctx); err == nil {
+				c.watch()
+				return c, nil
+			}
+			if ctx.Err() != nil {
+				c.Close()
+				return nil, ctx.Err()
+			}
+			c.fail(err)
+		} else if errors.Is(err, ErrSandboxNotFound) || errors.Is(err, ErrSandboxNotRunning) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		if restarts == h.Restarts {
+			return nil, fmt.Errorf("%w after %d attempts: %w", ErrConnectionFailed, restarts+1, err)
+		}
+		log.Warn().Err(err).Str("id", id).Dur("backoff", backoff).Msg("Agent failed to start; starting it again")
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// agentConn is a connection to an agent that answers the pings of
+// AgentHealth, which it keeps from the caller.
+type agentConn struct {
+	rwc     io.ReadWriteCloser
+	h       AgentHealth
+	crashed func(error)
+
+	// out carries the lines the agent sends, except pongs, to Read
+	out *io.PipeReader
+
+	writeMu sync.Mutex
+	// midLine is set while the caller's last write did not end a line, when
+	// a ping would corrupt it
+	midLine bool
+	pings   atomic.Uint64
+
+	// alive receives a value whenever the agent sends a line
+	alive chan struct{}
+	// delivering is set while a line waits for the caller to read it, when
+	// the agent cannot be heard
+	delivering atomic.Bool
+	// ended is closed when the agent's stream ends
+	ended chan struct{}
+
+	// closed is set by Close, failed by giving up on the agent; neither is
+	// a crash when the stream ends
+	closed    atomic.Bool
+	failed    atomic.Bool
+	done      chan struct{}
+	closeOnce sync.Once
+	crashOnce sync.Once
+}
+
+func newAgentConn(rwc io.ReadWriteCloser, h AgentHealth, crashed func(error)) *agentConn {
+	pr, pw := io.Pipe()
+	c := &agentConn{
+		rwc:     rwc,
+		h:       h,
+		crashed: crashed,
+		out:     pr,
+		alive:   make(chan struct{}, 1),
+		ended:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.pump(pw)
+	return c
+}
+
+// pump passes what the agent sends to Read, minus the answers to pings.
+func (c *agentConn) pump(pw *io.PipeWriter) {
+	defer close(c.ended)
+	r := bufio.NewReader(c.rwc)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			select {
+			case c.alive <- struct{}{}:
+			default:
+			}
+			if !isPong(line) {
+				c.delivering.Store(true)
+				_, werr := pw.Write(line)
+				c.delivering.Store(false)
+				if werr != nil {
+					// The caller closed the connection
+					return
+				}
+			}
+		}
+		if err != nil {
+			if !c.closed.Load() && !c.failed.Load() {
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+					err = errors.New("agent exited")
+				}
+				c.crash(err)
+			}
+			pw.Close()
+			return
+		}
+	}
+}
+
+// isPong reports whether line answers a ping, with a result or an error:
+// agents that predate pings reject them, which shows they are alive too.
+func isPong(line []byte) bool {
+	if !bytes.Contains(line, []byte(pingID)) {
+		return false
+	}
+	var msg struct {
+		Method string `json:"method"`
+		ID     any    `json:"id"`
+	}
+	if json.Unmarshal(line, &msg) != nil || msg.Method != "" {
+		return false
+	}
+	id, ok := msg.ID.(string)
+	return ok && strings.HasPrefix(id, pingID)
+}
+
+// ping sends a ping and waits for the agent to send anything.
+func (c *agentConn) ping(ctx context.Context) error {
+	// Earlier lines say nothing about whether the agent is alive now
+	select {
+	case <-c.alive:
+	default:
+	}
+
+	c.writeMu.Lock()
+	if c.midLine {
+		// The caller is writing to the agent, which is no sign of it
+		// being stuck
+		c.writeMu.Unlock()
+		return nil
+	}
+	msg := fmt.Sprintf(`{"jsonrpc":"2.0","method":"ping","id":"%s%d"}`+"\n", pingID, c.pings.Add(1))
+	_, err := io.WriteString(c.rwc, msg)
+	c.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to ping agent: %w", err)
+	}
+
+	t := time.NewTimer(c.h.PingTimeout)
+	defer t.Stop()
+	select {
+	case <-c.alive:
+		return nil
+	case <-c.ended:
+		return errors.New("agent exited")
+	case <-t.C:
+		return fmt.Errorf("%w: no answer to a ping within %s", ErrAgentUnresponsive, c.h.PingTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return nil
+	}
+}
+
+// watch pings the agent whenever it has been quiet for PingInterval,
+// until the connection is closed or the agent gives up.
+func (c *agentConn) watch() {
+	if c.h.PingInterval < 0 {
+		return
+	}
+	go func() {
+		t := time.NewTimer(c.h.PingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-c.ended:
+				return
+			case <-c.alive:
+			case <-t.C:
+				if !c.delivering.Load() {
+					if err := c.ping(context.Background()); err != nil {
+						c.fail(err)
+						return
+					}
+				}
+			}
+			t.Reset(c.h.PingInterval)
+		}
+	}()
+}
+
+// fail gives up on the agent, closing its stream.
+func (c *agentConn) fail(err error) {
+	if c.closed.Load() {
+		return
+	}
+	c.failed.Store(true)
+	c.crash(err)
+	c.rwc.Close()
+}
+
+func (c *agentConn) crash(err error) {
+	c.crashOnce.Do(func() {
+		if c.crashed != nil {
+			c.crashed(err)
+		}
+	})
+}
+
+func (c *agentConn) Read(p []byte) (int, error) {
+	return c.out.Read(p)
+}
+
+func (c *agentConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	n, err := c.rwc.Write(p)
+	if n > 0 {
+		c.midLine = p[n-1] != '\n'
+	}
+	return n, err
+}
+
+func (c *agentConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		close(c.done)
+		err = c.rwc.Close()
+		c.out.Close()
+	})
+	return err
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrBuildFailed is returned by ImageBuilder when a step of the
+// Dockerfile fails, as opposed to the build not being run.
+var ErrBuildFailed = errors.New("image build failed")
+
+// ImageBuild is what ImageBuilder builds an image from.
+type ImageBuild struct {
+	// Context is the build context, a tar archive, optionally gzipped
+	Context io.Reader
+
+	// Dockerfile is the path of the Dockerfile in Context; "Dockerfile"
+	// if empty
+	Dockerfile string
+
+	// BuildArgs are the values of the Dockerfile's ARGs
+	BuildArgs map[string]string
+
+	// Labels are set on the image
+	Labels map[string]string
+}
+
+// ImageBuilder is implemented by drivers that can build the images their
+// sandboxes run from a Dockerfile.
+type ImageBuilder interface {
+	// BuildImage builds b as the image ref, replacing any image of that
+	// name. log, if non-nil, is called with each line of the build's
+	// output. A failing step returns ErrBuildFailed.
+	BuildImage(ctx context.Context, ref string, b ImageBuild, log func(line string)) error
+}
@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Size checks that the file's content is well-formed base64 and returns its
+// decoded size, without decoding it.
+func (f FileInjection) Size() (int64, error) {
+	var n, pad int64
+	for i := 0; i < len(f.ContentBase64); i++ {
+		switch c := f.ContentBase64[i]; {
+		case c == '\r' || c == '\n':
+			// Ignored by the decoder, as line breaks
+		case c == '=':
+			pad++
+		case pad > 0 || !isBase64(c):
+			return 0, f.invalid()
+		default:
+			n++
+		}
+	}
+	if (n+pad)%4 != 0 || pad > 2 {
+		return 0, f.invalid()
+	}
+	return (n+pad)/4*3 - pad, nil
+}
+
+// Open returns a reader decoding the file's content as it is read.
+func (f FileInjection) Open() io.Reader {
+	return &base64Reader{file: f, r: base64.NewDecoder(base64.StdEncoding, strings.NewReader(f.ContentBase64))}
+}
+
+func (f FileInjection) invalid() error {
+	return fmt.Errorf("%w: context file %s is not valid base64", ErrInvalidConfig, f.Path)
+}
+
+func isBase64(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/'
+}
+
+// base64Reader reports corrupt input as ErrInvalidConfig, naming the file.
+type base64Reader struct {
+	file FileInjection
+	r    io.Reader
+}
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if _, ok := err.(base64.CorruptInputError); ok {
+		err = b.file.invalid()
+	}
+	return n, err
+}
@@ -0,0 +1,40 @@
+package driver
+
+import "context"
+
+// Describer is implemented by drivers that can inspect what is installed in
+// a running sandbox.
+type Describer interface {
+	// Describe probes a sandbox for its operating system, interpreters and
+	// packages.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist.
+	Describe(ctx context.Context, id string) (*Environment, error)
+}
+
+// Environment is what a driver found installed in a sandbox.
+type Environment struct {
+	// OS names the distribution (e.g. "Debian GNU/Linux 12 (bookworm)"), if known
+	OS string `json:"os,omitempty"`
+
+	Interpreters []Interpreter `json:"interpreters"`
+	Packages     []Package     `json:"packages"`
+}
+
+// Interpreter is a language runtime found in the sandbox.
+type Interpreter struct {
+	// Command is the executable (e.g. "python3")
+	Command string `json:"command"`
+
+	// Version is empty if it could not be determined
+	Version string `json:"version,omitempty"`
+}
+
+// Package is a library installed for an interpreter.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+
+	// Manager is the package manager that installed it ("pip", "npm")
+	Manager string `json:"manager"`
+}
@@ -0,0 +1,88 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/docker/docker/api/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// buildMessage is a single line of the JSON stream returned by
+// ImageBuild.
+type buildMessage struct {
+	Stream      string `json:"stream"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+// BuildImage implements driver.ImageBuilder with the daemon's builder. Base
+// images are pulled with the registry credentials of the driver.
+func (d *DockerDriver) BuildImage(ctx context.Context, ref string, b driver.ImageBuild, log func(string)) error {
+	ctx, span := tracing.Start(ctx, "docker.build", attribute.String("boxed.image", ref))
+	err := d.buildImage(ctx, ref, b, log)
+	tracing.End(span, err)
+	return err
+}
+
+func (d *DockerDriver) buildImage(ctx context.Context, ref string, b driver.ImageBuild, log func(string)) error {
+	auths, err := d.buildAuths(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to build image %s: %w", ref, err)
+	}
+	args := make(map[string]*string, len(b.BuildArgs))
+	for k, v := range b.BuildArgs {
+		args[k] = &v
+	}
+	resp, err := d.cli.ImageBuild(ctx, b.Context, types.ImageBuildOptions{
+		Tags:        []string{ref},
+		Dockerfile:  b.Dockerfile,
+		BuildArgs:   args,
+		Labels:      b.Labels,
+		AuthConfigs: auths,
+		// Intermediate containers of failed steps are not kept either
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build image %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	// Steps print partial lines; a line is logged once it is complete
+	var partial string
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg buildMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read build output: %w", err)
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			if msg.Error == "" {
+				msg.Error = msg.ErrorDetail.Message
+			}
+			return fmt.Errorf("%w: %s", driver.ErrBuildFailed, strings.TrimSpace(msg.Error))
+		}
+		if log == nil || msg.Stream == "" {
+			continue
+		}
+		lines := strings.Split(partial+msg.Stream, "\n")
+		partial = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			log(strings.TrimRight(line, "\r"))
+		}
+	}
+	if log != nil && partial != "" {
+		log(partial)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/rs/zerolog/log"
+)
+
+// injectContext writes the context files of cfg into the container as one
+// tar stream, decoding each file as it is copied so that none is held in
+// memory whole.
+func (d *DockerDriver) injectContext(ctx context.Context, id string, cfg driver.SandboxConfig) error {
+	if len(cfg.Context) == 0 {
+		return nil
+	}
+	type entry struct {
+		file driver.FileInjection
+		path string
+		size int64
+	}
+	entries := make([]entry, 0, len(cfg.Context))
+	for _, file := range cfg.Context {
+		size, err := file.Size()
+		if err != nil {
+			return err
+		}
+		target := file.Path
+		if !filepath.IsAbs(target) && cfg.WorkDir != "" {
+			target = filepath.Join(cfg.WorkDir, target)
+		}
+		target, err = d.resolvePath(ctx, id, target)
+		if err != nil {
+			return fmt.Errorf("failed to inject context file %s: %w", file.Path, err)
+		}
+		entries = append(entries, entry{file, target, size})
+	}
+
+	start := time.Now()
+	var total int64
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := func() error {
+			tw := tar.NewWriter(pw)
+			for i, e := range entries {
+				// Entries are named relative to "/" so that Docker creates
+				// any missing parent directories while extracting.
+				header := &tar.Header{
+					Name:    strings.TrimPrefix(e.path, "/"),
+					Size:    e.size,
+					Mode:    0644,
+					ModTime: start,
+				}
+				err := tw.WriteHeader(header)
+				if err == nil {
+					_, err = io.CopyN(tw, e.file.Open(), e.size)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to inject context file %s (%d of %d): %w", e.file.Path, i+1, len(entries), err)
+				}
+				total += e.size
+			}
+			return tw.Close()
+		}()
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err := d.cli.CopyToContainer(ctx, id, "/", pr, types.CopyToContainerOptions{})
+	pr.CloseWithError(errCopyDone)
+	if werr := <-done; werr != nil && !errors.Is(werr, errCopyDone) {
+		// A bad file, rather than Docker, is why the copy failed
+		return werr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inject context files: docker copy failed: %w", err)
+	}
+	log.Debug().Str("id", id).Int("files", len(entries)).Int64("bytes", total).
+		Dur("took", time.Since(start)).Msg("Injected context files")
+	return nil
+}
+
+// errCopyDone stops the tar writer of injectContext once Docker stops
+// reading.
+var errCopyDone = errors.New("copy finished")
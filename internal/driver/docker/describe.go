@@ -0,0 +1,60 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// describeScript prints one "|"-separated record per line: the OS, each
+// interpreter found with its --version output, and pip and global npm
+// packages. It only relies on POSIX sh and sed.
+const describeScript = `
+. /etc/os-release 2>/dev/null && echo "os|$PRETTY_NAME"
+for c in python3 node bash; do
+	command -v $c >/dev/null 2>&1 && echo "interpreter|$c|$($c --version 2>&1 | head -n 1)"
+done
+command -v python3 >/dev/null 2>&1 && python3 -m pip list --format=freeze 2>/dev/null | sed 's/==/|/; s/^/pip|/'
+command -v npm >/dev/null 2>&1 && npm ls -g --depth=0 2>/dev/null | sed -n 's/.* \(.*\)@\(.*\)$/npm|\1|\2/p'
+exit 0
+`
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Describe implements driver.Describer.
+func (d *DockerDriver) Describe(ctx context.Context, id string) (*driver.Environment, error) {
+	code, out, err := d.runExec(ctx, id, []string{"sh", "-c", describeScript}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("environment probe exited with %d: %s", code, out)
+	}
+	return parseDescription(out), nil
+}
+
+func parseDescription(out string) *driver.Environment {
+	env := &driver.Environment{Interpreters: []driver.Interpreter{}, Packages: []driver.Package{}}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		switch {
+		case fields[0] == "os" && len(fields) == 2:
+			env.OS = fields[1]
+		case fields[0] == "interpreter" && len(fields) == 3:
+			env.Interpreters = append(env.Interpreters, driver.Interpreter{
+				Command: fields[1],
+				Version: versionPattern.FindString(fields[2]),
+			})
+		case (fields[0] == "pip" || fields[0] == "npm") && len(fields) == 3:
+			env.Packages = append(env.Packages, driver.Package{
+				Name:    fields[1],
+				Version: fields[2],
+				Manager: fields[0],
+			})
+		}
+	}
+	return env
+}
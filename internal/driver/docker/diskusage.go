@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/client"
+)
+
+// diskUsageScript prints one "|"-separated record per directory given as
+// an argument: its path, the KiB its files take and how many there are,
+// and the filesystem holding it with its size and available KiB. It only
+// relies on POSIX sh, du, find and df.
+const diskUsageScript = `
+for d; do
+	[ -d "$d" ] || { echo "usage|$d|0|0||0|0"; continue; }
+	used=$(du -sk "$d" 2>/dev/null | tail -n 1 | cut -f 1)
+	files=$(find "$d" -type f 2>/dev/null | wc -l)
+	df -Pk "$d" 2>/dev/null | tail -n 1 | { read fs size _ avail _; echo "usage|$d|${used:-0}|$files|$fs|$size|$avail"; }
+done
+exit 0
+`
+
+// DiskUsage implements driver.DiskUsageReader with du and df run in the
+// container as root, which reads every file. Usage is counted in the 1 KiB
+// blocks du counts. Directories on a tmpfs, such as /output, are bounded
+// by its size.
+func (d *DockerDriver) DiskUsage(ctx context.Context, id string, dirs []string) ([]driver.DirUsage, error) {
+	info, err := d.cli.ContainerInspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		return nil, driver.ErrSandboxNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if info.State == nil || !info.State.Running {
+		return nil, driver.ErrSandboxNotRunning
+	}
+
+	cmd := append([]string{"sh", "-c", diskUsageScript, "sh"}, dirs...)
+	code, out, err := d.runExecAs(ctx, id, "0", cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("disk usage probe exited with %d: %s", code, out)
+	}
+	return parseDiskUsage(out, dirs), nil
+}
+
+func parseDiskUsage(out string, dirs []string) []driver.DirUsage {
+	byPath := make(map[string]driver.DirUsage, len(dirs))
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		n := len(fields)
+		if n < 7 || fields[0] != "usage" {
+			continue
+		}
+		kib := func(s string) int64 {
+			v, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			return v * 1024
+		}
+		u := driver.DirUsage{
+			Path:      strings.Join(fields[1:n-5], "|"),
+			UsedBytes: kib(fields[n-5]),
+		}
+		u.Files, _ = strconv.ParseInt(strings.TrimSpace(fields[n-4]), 10, 64)
+		if fields[n-3] == "tmpfs" {
+			u.LimitBytes = kib(fields[n-2])
+			u.AvailableBytes = kib(fields[n-1])
+		}
+		byPath[u.Path] = u
+	}
+	usage := make([]driver.DirUsage, len(dirs))
+	for i, dir := range dirs {
+		u, ok := byPath[dir]
+		if !ok {
+			u = driver.DirUsage{Path: dir}
+		}
+		usage[i] = u
+	}
+	return usage
+}
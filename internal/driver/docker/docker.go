@@ -1,28 +1,50 @@
 package docker
 
 import (
-	"bytes"
+	"cmp"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	DriverName      = "docker"
 	AgentBinaryPath = "/usr/local/bin/boxed-agent"
 	ManagedLabel    = "xyz.boxed.managed"
+
+	// ExpiresLabel holds the Unix time a container's TTL ends, as set at
+	// creation. Docker labels cannot change, so later extensions are only
+	// known to the process that made them.
+	ExpiresLabel = "xyz.boxed.expires_at"
+
+	// InstanceLabel names the server instance that created a container.
+	// Garbage collection and listing only consider the containers of their
+	// own instance, so servers sharing a daemon leave each other alone.
+	InstanceLabel = "xyz.boxed.instance"
+
+	// UserLabel holds the user code runs as by default, so the agent of a
+	// container created by an earlier process still runs code as it
+	UserLabel = "xyz.boxed.user"
+
+	// DefaultInstance is the instance ID of servers that set none. It also
+	// owns containers created before instances were labeled.
+	DefaultInstance = "default"
 )
 
 // DockerDriver implements the driver.Driver interface using the Docker engine.
@@ -30,19 +52,118 @@ type DockerDriver struct {
 	cli *client.Client
 	// hostAgentPath is the path to the compiled agent binary on the host
 	hostAgentPath string
+
+	// startedAt separates orphans of earlier runs from containers still
+	// being created by this one
+	startedAt time.Time
+
+	// instance is the InstanceLabel value of this server's containers
+	instance string
+
+	// adopted holds the instances whose containers were taken over with
+	// Adopt, as keys
+	adopted sync.Map
+
+	// ReapNotifier reports TTL expiries and orphan removals
+	driver.ReapNotifier
+
+	// faults are failures injected for tests; see faults.go
+	faults faults
+
+	// retries is how transient daemon errors are retried; see retry.go
+	retries retryPolicy
+
+	// health checks and restarts the agents Connect starts
+	health driver.AgentHealth
+
+	// gpus are the host's GPUs sandboxes can be given; see hostGPUs
+	gpus *driver.GPUPool
+
+	// groups counts the sandboxes on each group network; see joinGroup
+	groups  map[string]int
+	groupMu sync.Mutex
+
+	// egress is the network of sandboxes with an EgressProxy and the
+	// host's address on it; see egressNetwork
+	egress   egressNet
+	egressMu sync.Mutex
+
+	// registries holds the credentials of private registries; see
+	// registry.go
+	registries *registryCredentials
+
+	// discardStderr drops the stderr of agents instead of keeping it for
+	// Logs
+	discardStderr bool
+
+	// host is the daemon's platform; see hostPlatform
+	host     string
+	hostOnce sync.Once
+
+	// done stops the reconciler when the driver is closed
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// sandboxes tracks per-sandbox state that Docker itself does not keep
+	sandboxes map[string]*sandbox
+}
+
+// sandbox is the driver's in-memory bookkeeping for a container it created.
+type sandbox struct {
+	cfg driver.SandboxConfig
+	// sidecarExecs maps sidecar name to its detached exec ID
+	sidecarExecs map[string]string
+	// ttl removes the container when its lifetime ends; Stop cancels it
+	ttl *time.Timer
+	// failure is set when the container stopped on its own, and exitReason
+	// to why; see markDied
+	failure    string
+	exitReason string
+	// layers are the workspace volumes removed with the container
+	layers []string
+	// agentLog keeps the agent's stderr
+	agentLog *driver.LogBuffer
+	// platform is that of the image; see driver.SandboxConfig.Platform
+	platform string
+	// agentCrashes counts the agents that crashed while it kept running
+	agentCrashes int
+	// gpus are held for the container until Stop removes it
+	gpus []driver.GPU
+	// network is that of the sandbox's group, left when Stop removes it
+	network string
+	// hibernated is set while Hibernate keeps the container stopped
+	hibernated bool
 }
 
 // New creates a new DockerDriver.
 // cfg["agent_path"] can be used to specify the host path to the boxed-agent binary.
+// cfg["instance_id"] sets the InstanceLabel of created containers (default:
+// DefaultInstance). Servers sharing a daemon need distinct IDs.
+// cfg["orphan_policy"] says what happens at startup to the containers an
+// earlier process of this instance left behind: driver.OrphanDelete
+// (default), driver.OrphanAdopt or driver.OrphanKeep.
+// cfg["cleanup_orphans"] = false is the older spelling of OrphanKeep.
+// cfg["reconcile_interval"] (a time.Duration, default 1m) sets how often
+// Docker's containers are reconciled with the tracked sandboxes; a negative
+// value disables it.
+// cfg["gpus"] lists the GPUs sandboxes can be given (see driver.ParseGPUs;
+// default: those nvidia-smi finds).
+// cfg["registries"] lists the credentials of private registries images are
+// pulled from (see driver.RegistryCredential); more can be added with
+// SetRegistryCredential.
+// cfg["discard_agent_stderr"] = true drops the stderr of agents, which is
+// otherwise kept with each sandbox's agent logs.
+// cfg["faults"] injects failures for tests (default: $BOXED_TEST_FAULTS).
+// cfg["retries"], cfg["retry_backoff"] and cfg["retry_max_backoff"] set how
+// calls failing with transient daemon errors are retried (see retryConfig).
+// See driver.AgentHealthConfig for the settings of agent health checks.
 func New(cfg map[string]any) (driver.Driver, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
 
-	// Perform startup cleanup of orphaned containers
-	go cleanupOrphans(cli)
-
 	agentPath := "boxed-agent" // Default expectation: in PATH or current dir?
 	if p, ok := cfg["agent_path"].(string); ok {
 		agentPath = p
@@ -53,10 +174,77 @@ func New(cfg map[string]any) (driver.Driver, error) {
 		agentPath = absPath
 	}
 
-	return &DockerDriver{
+	faultSpec := os.Getenv("BOXED_TEST_FAULTS")
+	if s, ok := cfg["faults"].(string); ok {
+		faultSpec = s
+	}
+	faults, err := parseFaults(faultSpec)
+	if err != nil {
+		return nil, err
+	}
+	if len(faults) > 0 {
+		log.Warn().Str("faults", faultSpec).Msg("Test fault injection enabled: do not use in production")
+	}
+
+	instance := DefaultInstance
+	if v, ok := cfg["instance_id"].(string); ok && v != "" {
+		instance = v
+	}
+	orphans := driver.OrphanDelete
+	if cleanup, ok := cfg["cleanup_orphans"].(bool); ok && !cleanup {
+		orphans = driver.OrphanKeep
+	}
+	if v, ok := cfg["orphan_policy"].(string); ok && v != "" {
+		orphans = v
+	}
+	if err := driver.ValidateOrphanPolicy(orphans); err != nil {
+		return nil, err
+	}
+
+	gpus, err := hostGPUs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(gpus) > 0 {
+		log.Info().Str("gpus", formatGPUs(gpus)).Msg("GPUs available to sandboxes")
+	}
+
+	registries, err := parseRegistries(cfg)
+	if err != nil {
+		return nil, err
+	}
+	discardStderr, _ := cfg["discard_agent_stderr"].(bool)
+
+	d := &DockerDriver{
 		cli:           cli,
 		hostAgentPath: agentPath,
-	}, nil
+		startedAt:     time.Now(),
+		instance:      instance,
+		faults:        faults,
+		retries:       retryConfig(cfg),
+		health:        driver.AgentHealthConfig(cfg),
+		gpus:          driver.NewGPUPool(gpus),
+		groups:        make(map[string]int),
+		registries:    registries,
+		discardStderr: discardStderr,
+		done:          make(chan struct{}),
+		sandboxes:     make(map[string]*sandbox),
+	}
+
+	// Deal with the containers of an earlier process
+	if orphans != driver.OrphanKeep {
+		go d.handleOrphans(orphans)
+	}
+
+	interval := DefaultReconcileInterval
+	if v, ok := cfg["reconcile_interval"].(time.Duration); ok && v != 0 {
+		interval = v
+	}
+	if interval > 0 {
+		go d.reconcileLoop(interval)
+	}
+
+	return d, nil
 }
 
 func init() {
@@ -73,55 +261,21 @@ func (d *DockerDriver) Healthy(ctx context.Context) error {
 }
 
 func (d *DockerDriver) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
 	return d.cli.Close()
 }
 
-func cleanupOrphans(cli *client.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	log.Info().Msg("Performing startup garbage collection of orphaned containers...")
-	list, err := cli.ContainerList(ctx, types.ContainerListOptions{
-		All:     true,
-		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
-	})
-	if err != nil {
-		log.Warn().Err(err).Msg("Failed to list orphaned containers")
-		return
-	}
-
-	count := 0
-	for _, c := range list {
-		// Stop/Remove them
-		err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
-		if err != nil {
-			log.Warn().Str("id", c.ID).Err(err).Msg("Failed to remove orphan")
-		} else {
-			count++
-		}
-	}
-	if count > 0 {
-		log.Info().Int("count", count).Msg("Removed orphaned containers")
-	} else {
-		log.Info().Msg("No orphans found")
-	}
-}
-
 func (d *DockerDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
 	if err := cfg.Validate(); err != nil {
 		return "", err
 	}
 
 	// Prepare resources
-	// NanoCPUs: 1.0 = 1e9.
-	nanoCPUs := int64(cfg.CPUCores * 1e9)
-	memoryBytes := cfg.MemoryMB * 1024 * 1024
+	resources := cpuResources(cfg)
+	resources.Memory = cfg.MemoryMB * 1024 * 1024
 
 	hostConfig := &container.HostConfig{
-		Resources: container.Resources{
-			NanoCPUs: nanoCPUs,
-			Memory:   memoryBytes,
-		},
+		Resources: resources,
 		Mounts: []mount.Mount{
 			// Mount the agent binary
 			{
@@ -141,15 +295,25 @@ func (d *DockerDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (st
 				Target: "/output",
 			},
 		},
-		// Drop capabilities for security (basic set)
-		// CapDrop: []string{"ALL"},
-		// CapAdd:  []string{"NET_BIND_SERVICE"},
+	}
+	if err := d.applySecurity(hostConfig, cfg); err != nil {
+		return "", err
 	}
 
 	// Network configuration
 	if !cfg.EnableNetworking {
 		hostConfig.NetworkMode = "none"
 	}
+	applyDNS(hostConfig, cfg)
+	// Sandboxes given an egress proxy reach nothing but it
+	var egress egressNet
+	if cfg.EnableNetworking && cfg.EgressProxy != nil {
+		var err error
+		if egress, err = d.egressNetwork(ctx); err != nil {
+			return "", err
+		}
+		hostConfig.NetworkMode = container.NetworkMode(egress.name)
+	}
 
 	// Environment variables
 	env := []string{
@@ -158,6 +322,9 @@ func (d *DockerDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (st
 	for k, v := range cfg.Env {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
+	if egress.name != "" {
+		env = append(env, cfg.EgressProxy.Env(egress.gateway)...)
+	}
 
 	// For the "warm pool" or just basic execution, the container needs to stay alive.
 	// We use "tail -f /dev/null" as the entrypoint so we can exec into it later.
@@ -169,87 +336,204 @@ func (d *DockerDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (st
 	// Check if image exists, pull if not (optional, but good for UX)
 	// d.pullImage(ctx, cfg.Image) // Simplified: assume user has image or Docker will handle
 
-	// Ensure image exists locally, otherwise pull it
-	_, _, err := d.cli.ImageInspectWithRaw(ctx, cfg.Image)
-	if client.IsErrNotFound(err) {
-		log.Info().Str("image", cfg.Image).Msg("Image not found locally, pulling...")
-		reader, err := d.cli.ImagePull(ctx, cfg.Image, types.ImagePullOptions{})
-		if err != nil {
-			return "", fmt.Errorf("failed to pull image %s: %w", cfg.Image, err)
-		}
-		// Drain the output to ensure pull completes
-		io.Copy(io.Discard, reader)
-		reader.Close()
-	} else if err != nil {
-		return "", fmt.Errorf("failed to inspect image: %w", err)
+	platform, err := d.ensureImage(ctx, cfg.Image, cfg.Platform)
+	if err != nil {
+		return "", err
 	}
 
-	labels := cfg.Labels
-	if labels == nil {
-		labels = make(map[string]string)
+	// Copied so that Boxed's own labels stay out of the reported config
+	labels := make(map[string]string, len(cfg.Labels)+3)
+	for k, v := range cfg.Labels {
+		labels[k] = v
 	}
 	labels[ManagedLabel] = "true"
+	labels[InstanceLabel] = d.instance
+	labels[ExpiresLabel] = strconv.FormatInt(time.Now().Add(cfg.Timeout).Unix(), 10)
 
-	resp, err := d.cli.ContainerCreate(ctx,
-		&container.Config{
-			Image:      cfg.Image,
-			Cmd:        []string{"tail", "-f", "/dev/null"},
-			Env:        env,
-			Labels:     labels,
-			WorkingDir: cfg.WorkDir,
-		},
-		hostConfig,
-		nil,
-		nil,
-		"", // let Docker assign name or generate one
-	)
+	volumes, err := d.volumeMounts(ctx, cfg.Volumes)
 	if err != nil {
-		return "", fmt.Errorf("failed to create container: %w", err)
+		return "", err
 	}
+	hostConfig.Mounts = append(hostConfig.Mounts, volumes...)
 
-	// Context Injection
-	for _, file := range cfg.Context {
-		data, err := base64.StdEncoding.DecodeString(file.ContentBase64)
+	var layers []string
+	if cfg.Workspace != "" {
+		key := newLayerKey()
+		overlay, created, err := d.mountWorkspace(ctx, cfg.Workspace, key)
 		if err != nil {
-			log.Error().Err(err).Str("path", file.Path).Msg("Failed to decode context file")
-			continue
+			return "", err
 		}
+		layers = created
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: overlay,
+			Target: cfg.WorkDir,
+		})
+		labels[WorkspaceLabel] = cfg.Workspace
+		labels[LayerLabel] = key
+	}
+	if cfg.User != "" {
+		labels[UserLabel] = cfg.User
+	}
+	labels[SeccompLabel] = cfg.Security.SeccompName()
 
-		// Ensure absolute path
-		targetPath := file.Path
-		if !filepath.IsAbs(targetPath) {
-			targetPath = filepath.Join(cfg.WorkDir, targetPath)
+	var gpus []driver.GPU
+	if cfg.GPU != nil {
+		if gpus, err = d.gpus.Allocate(*cfg.GPU); err != nil {
+			d.removeVolumes(layers)
+			return "", err
 		}
+		hostConfig.DeviceRequests = append(hostConfig.DeviceRequests, gpuDeviceRequest(gpus))
+		labels[GPULabel] = formatGPUs(gpus)
+	}
 
-		if err := d.PutFile(ctx, resp.ID, targetPath, bytes.NewReader(data)); err != nil {
-			log.Error().Err(err).Str("path", file.Path).Msg("Failed to inject context file")
-			d.Stop(ctx, resp.ID)
-			return "", fmt.Errorf("failed to inject file %s: %w", file.Path, err)
+	var netName string
+	var netConfig *network.NetworkingConfig
+	if cfg.NetworkGroup != "" {
+		if netName, err = d.joinGroup(ctx, cfg.NetworkGroup); err != nil {
+			d.removeVolumes(layers)
+			d.gpus.Release(gpus)
+			return "", err
+		}
+		hostConfig.NetworkMode = container.NetworkMode(netName)
+		netConfig = groupEndpoint(netName, cfg)
+		labels[NetworkGroupLabel] = cfg.NetworkGroup
+		if cfg.NetworkAlias != "" {
+			labels[NetworkAliasLabel] = cfg.NetworkAlias
 		}
 	}
 
-	// Enforce TTL
-	go func(id string, timeout time.Duration) {
-		time.Sleep(timeout)
-		// Check if it still exists? Stop is idempotent.
-		// Use a fresh context for cleanup
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// A create that failed transiently may have made the container all the
+	// same: retries look for it by its key before making another
+	createKey := newLayerKey()
+	labels[CreateKeyLabel] = createKey
+	var resp container.CreateResponse
+	createCtx, span := tracing.Start(ctx, "docker.container_create")
+	attempted := false
+	err = d.retry(createCtx, "container_create", func() (err error) {
+		if attempted {
+			if resp.ID, err = d.createdByKey(createCtx, createKey); err != nil || resp.ID != "" {
+				return err
+			}
+		}
+		attempted = true
+		resp, err = d.cli.ContainerCreate(createCtx,
+			&container.Config{
+				Image:      cfg.Image,
+				Cmd:        []string{"tail", "-f", "/dev/null"},
+				Env:        env,
+				Labels:     labels,
+				WorkingDir: cfg.WorkDir,
+			},
+			hostConfig,
+			netConfig,
+			ociPlatform(platform, d.hostPlatform(ctx)),
+			"", // let Docker assign name or generate one
+		)
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		d.removeVolumes(layers)
+		d.gpus.Release(gpus)
+		if netName != "" {
+			d.leaveGroup(netName)
+		}
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+
+	sb := &sandbox{cfg: cfg, layers: layers, agentLog: driver.NewLogBuffer(driver.LogSourceAgent, 0), platform: platform, gpus: gpus, network: netName}
+	d.mu.Lock()
+	d.sandboxes[resp.ID] = sb
+	d.mu.Unlock()
+
+	// Any failure from here on must not leave the container behind.
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		// Fresh context: ctx being cancelled may be why we are failing
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		if err := d.Stop(cleanupCtx, resp.ID); err != nil {
+			log.Warn().Err(err).Str("id", resp.ID).Msg("Failed to clean up sandbox after failed create")
+		}
+	}()
+
+	// Group networks are internal; sandboxes allowed out join the default
+	// bridge too, or the egress network if they go out through the proxy
+	if netName != "" && cfg.EnableNetworking {
+		out := cmp.Or(egress.name, "bridge")
+		if err := d.cli.NetworkConnect(ctx, out, resp.ID, nil); err != nil {
+			return "", fmt.Errorf("failed to connect to network %s: %w", out, err)
+		}
+	}
 
-		d.Stop(ctx, id)
-	}(resp.ID, cfg.Timeout)
+	// Context Injection
+	if len(cfg.Context) > 0 {
+		driver.ReportPhase(ctx, driver.PhaseInjectingContext)
+	}
+	injectCtx, span := tracing.Start(ctx, "docker.inject_context", attribute.Int("boxed.files", len(cfg.Context)))
+	err = d.injectContext(injectCtx, resp.ID, cfg)
+	tracing.End(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	// Enforce TTL. The timer is armed last so that a failed create never
+	// leaves one behind, and Stop cancels it.
+	d.mu.Lock()
+	d.armTTL(resp.ID, sb, cfg.Timeout)
+	d.mu.Unlock()
 
+	committed = true
 	return resp.ID, nil
 }
 
+// armTTL (re)schedules the removal of a sandbox. d.mu must be held.
+func (d *DockerDriver) armTTL(id string, sb *sandbox, after time.Duration) {
+	if sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	sb.ttl = time.AfterFunc(after, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		log.Info().Str("id", id).Msg("Sandbox TTL expired")
+		d.expire(ctx, id)
+	})
+}
+
+// SetExpiry implements driver.ExpiryController.
+func (d *DockerDriver) SetExpiry(ctx context.Context, id string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sb := d.sandboxes[id]
+	if sb == nil || sb.ttl == nil {
+		return driver.ErrSandboxNotFound
+	}
+	d.armTTL(id, sb, time.Until(at))
+	return nil
+}
+
 func (d *DockerDriver) Start(ctx context.Context, id string) error {
-	if err := d.cli.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+	startCtx, span := tracing.Start(ctx, "docker.container_start")
+	err := d.cli.ContainerStart(startCtx, id, types.ContainerStartOptions{})
+	tracing.End(span, err)
+	if client.IsErrNotFound(err) {
+		return driver.ErrSandboxNotFound
+	}
+	if err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
 
 	// Wait a brief moment to ensure it's actually running?
 	// Usually ContainerStart returns once the process is launched.
-	return nil
+
+	if err := d.setupUser(ctx, id); err != nil {
+		return err
+	}
+	return d.startSidecars(ctx, id)
 }
 
 func (d *DockerDriver) Stop(ctx context.Context, id string) error {
@@ -258,18 +542,42 @@ func (d *DockerDriver) Stop(ctx context.Context, id string) error {
 		Force:         true,
 		RemoveVolumes: true,
 	}
-	if err := d.cli.ContainerRemove(ctx, id, opts); err != nil {
-		if client.IsErrNotFound(err) {
-			return driver.ErrSandboxNotFound
-		}
+	err := d.cli.ContainerRemove(ctx, id, opts)
+	if err != nil && !client.IsErrNotFound(err) {
 		return fmt.Errorf("failed to stop/remove container: %w", err)
 	}
+
+	// The container is gone (possibly removed behind our back): drop the
+	// bookkeeping and cancel the TTL either way.
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	if sb != nil && sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	delete(d.sandboxes, id)
+	d.mu.Unlock()
+	if sb != nil {
+		sb.agentLog.Close()
+		d.removeVolumes(sb.layers)
+		d.gpus.Release(sb.gpus)
+		if sb.network != "" {
+			d.leaveGroup(sb.network)
+		}
+	}
+
+	if err != nil {
+		return driver.ErrSandboxNotFound
+	}
 	return nil
 }
 
 func (d *DockerDriver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
 	// Check if running
-	info, err := d.cli.ContainerInspect(ctx, id)
+	var info types.ContainerJSON
+	err := d.retry(ctx, "container_inspect", func() (err error) {
+		info, err = d.cli.ContainerInspect(ctx, id)
+		return err
+	})
 	if err != nil {
 		if client.IsErrNotFound(err) {
 			return nil, driver.ErrSandboxNotFound
@@ -280,6 +588,20 @@ func (d *DockerDriver) Connect(ctx context.Context, id string) (io.ReadWriteClos
 		return nil, driver.ErrSandboxNotRunning
 	}
 
+	conn, err := d.health.Connect(ctx, id, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return d.startAgent(ctx, id, info.Config.Labels)
+	}, func(err error) {
+		d.agentCrashed(id, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.withExecFaults(id, conn), nil
+}
+
+// startAgent execs an agent in container id, whose labels are given, and
+// returns its stdio.
+func (d *DockerDriver) startAgent(ctx context.Context, id string, labels map[string]string) (io.ReadWriteCloser, error) {
 	// Exec the agent
 	execConfig := types.ExecConfig{
 		Cmd:          []string{AgentBinaryPath},
@@ -288,6 +610,12 @@ func (d *DockerDriver) Connect(ctx context.Context, id string) (io.ReadWriteClos
 		AttachStderr: true,
 		Tty:          false, // Raw stream for JSON-RPC
 	}
+	if user := labels[UserLabel]; user != "" {
+		// The agent stays root to switch to the user, or another one
+		// an exec asks for
+		execConfig.User = "0"
+		execConfig.Env = []string{"BOXED_DEFAULT_USER=" + user}
+	}
 
 	execIDResp, err := d.cli.ContainerExecCreate(ctx, id, execConfig)
 	if err != nil {
@@ -320,11 +648,51 @@ func (d *DockerDriver) Connect(ctx context.Context, id string) (io.ReadWriteClos
 	//
 	// If the agent writes JSON-RPC to stdout, we need to strip the Docker headers.
 
-	return NewDockerStream(resp), nil
+	// The agent's stderr is kept for GET /logs, its lines tagged with the
+	// exec's short ID; untracked containers have nowhere to keep it
+	var stderr io.Writer = io.Discard
+	d.mu.Lock()
+	if sb := d.sandboxes[id]; sb != nil && !d.discardStderr {
+		stderr = sb.agentLog.Exec(shortExecID(execIDResp.ID))
+	}
+	d.mu.Unlock()
+	return NewDockerStream(resp, stderr), nil
+}
+
+// shortExecID shortens a Docker exec ID like docker does container IDs.
+func shortExecID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// agentCrashed counts a crash of an agent of container id, unless the
+// container went down with it.
+func (d *DockerDriver) agentCrashed(id string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if info, ierr := d.cli.ContainerInspect(ctx, id); ierr != nil || !info.State.Running {
+		return
+	}
+	log.Warn().Err(err).Str("id", id).Msg("Agent crashed")
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	if sb != nil {
+		sb.agentCrashes++
+	}
+	d.mu.Unlock()
+	if sb != nil {
+		sb.agentLog.Printf("agent crashed: %v", err)
+	}
 }
 
 func (d *DockerDriver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
-	json, err := d.cli.ContainerInspect(ctx, id)
+	var json types.ContainerJSON
+	err := d.retry(ctx, "container_inspect", func() (err error) {
+		json, err = d.cli.ContainerInspect(ctx, id)
+		return err
+	})
 	if err != nil {
 		if client.IsErrNotFound(err) {
 			return nil, driver.ErrSandboxNotFound
@@ -342,42 +710,139 @@ func (d *DockerDriver) Info(ctx context.Context, id string) (*driver.SandboxInfo
 	// Map created time
 	created, _ := time.Parse(time.RFC3339Nano, json.Created)
 
-	return &driver.SandboxInfo{
+	info := &driver.SandboxInfo{
 		ID:         json.ID,
 		State:      state,
 		CreatedAt:  created,
 		DriverType: DriverName,
 		IPAddress:  json.NetworkSettings.IPAddress,
-		// Config: rebuild from inspect if needed, or skipped
-	}, nil
+		Seccomp:    labelSeccomp(json.Config.Labels),
+	}
+
+	d.mu.Lock()
+	sb := d.sandboxes[json.ID]
+	var failure, exitReason string
+	var hibernated bool
+	if sb != nil {
+		// Tracked containers only exit when Stop removes them, or when
+		// they hibernate
+		markDied(sb, json.ID, json.State)
+		failure, exitReason, hibernated = sb.failure, sb.exitReason, sb.hibernated
+		info.AgentCrashes = sb.agentCrashes
+		// UpdateResources changes the limits
+		info.Config = sb.cfg
+	}
+	d.mu.Unlock()
+	if sb != nil {
+		info.GPUs = sb.gpus
+		info.Platform = sb.platform
+		info.Emulated = emulated(sb.platform, d.hostPlatform(ctx))
+		if json.State.Running {
+			info.Sidecars = d.sidecarStatus(ctx, sb)
+		} else if hibernated {
+			info.State = driver.StateHibernated
+		} else if failure != "" {
+			info.State = driver.StateError
+			info.Error = failure
+			info.ExitReason = exitReason
+		}
+	}
+	return info, nil
 }
 
 func (d *DockerDriver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
-	// Not implemented efficiently for now
-	containers, err := d.cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	var containers []types.Container
+	err := d.retry(ctx, "container_list", func() (err error) {
+		containers, err = d.cli.ContainerList(ctx, types.ContainerListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
+		})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	var results []*driver.SandboxInfo
 	for _, c := range containers {
-		// Filter by label to only show boxed containers?
-		// For now, allow all or check a label if we added one.
+		if !d.owns(c.Labels) {
+			continue
+		}
+		d.mu.Lock()
+		sb := d.sandboxes[c.ID]
+		var failure, exitReason string
+		var crashes int
+		var hibernated bool
+		var cfg driver.SandboxConfig
+		if sb != nil {
+			failure, exitReason, crashes = sb.failure, sb.exitReason, sb.agentCrashes
+			hibernated = sb.hibernated
+			cfg = sb.cfg
+		}
+		d.mu.Unlock()
 
 		state := driver.StateStopped
 		if c.State == "running" {
 			state = driver.StateReady
+		} else if hibernated {
+			state = driver.StateHibernated
+		} else if failure != "" {
+			state = driver.StateError
+		}
+		if len(states) > 0 && !containsState(states, state) {
+			continue
 		}
 
-		results = append(results, &driver.SandboxInfo{
-			ID:         c.ID,
-			State:      state,
-			DriverType: DriverName,
-		})
+		info := &driver.SandboxInfo{
+			ID:           c.ID,
+			State:        state,
+			CreatedAt:    time.Unix(c.Created, 0).UTC(),
+			DriverType:   DriverName,
+			Error:        failure,
+			ExitReason:   exitReason,
+			AgentCrashes: crashes,
+			Seccomp:      labelSeccomp(c.Labels),
+		}
+		if sb != nil {
+			info.Config = cfg
+			info.GPUs = sb.gpus
+		} else {
+			// Created by an earlier process: only Docker's view is left
+			info.Config = driver.SandboxConfig{
+				Image:     c.Image,
+				Labels:    userLabels(c.Labels),
+				Workspace: c.Labels[WorkspaceLabel],
+				User:      c.Labels[UserLabel],
+			}
+			info.GPUs = labelGPUs(c.Labels)
+		}
+		results = append(results, info)
 	}
 	return results, nil
 }
 
+// userLabels drops the labels Boxed sets for itself.
+func userLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		switch k {
+		case ManagedLabel, ExpiresLabel, InstanceLabel, WorkspaceLabel, VolumeLabel, LayerLabel, UserLabel, CreateKeyLabel, SeccompLabel:
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func containsState(states []driver.SandboxState, s driver.SandboxState) bool {
+	for _, st := range states {
+		if st == s {
+			return true
+		}
+	}
+	return false
+}
+
 // DockerWrapper handles the multiplexed stream from Docker (StdCopy format)
 type DockerStream struct {
 	resp types.HijackedResponse
@@ -391,14 +856,20 @@ type DockerStream struct {
 	// Let's use stdcopy logic in a goroutine to pipe stdout to a pipe, and log stderr.
 	reader *io.PipeReader
 	writer *io.PipeWriter
+	// stderr receives the agent's stderr
+	stderr io.Writer
 }
 
-func NewDockerStream(resp types.HijackedResponse) *DockerStream {
+// NewDockerStream demultiplexes an attached exec: stdout is read from the
+// stream, stderr goes to the given writer, which is closed at the end of
+// the stream if it is an io.Closer.
+func NewDockerStream(resp types.HijackedResponse, stderr io.Writer) *DockerStream {
 	pr, pw := io.Pipe()
 	ds := &DockerStream{
 		resp:   resp,
 		reader: pr,
 		writer: pw,
+		stderr: stderr,
 	}
 
 	go ds.demux()
@@ -425,6 +896,9 @@ func (ds *DockerStream) demux() {
 	// I will just implement a simple loop.
 
 	defer ds.writer.Close()
+	if c, ok := ds.stderr.(io.Closer); ok {
+		defer c.Close()
+	}
 
 	for {
 		header := make([]byte, 8)
@@ -449,9 +923,10 @@ func (ds *DockerStream) demux() {
 				return
 			}
 		case 2: // Stderr
-			// Discard or log
-			// We discard for now to keep the JSON-RPC stream clean
-			io.CopyN(os.Stderr, ds.resp.Reader, int64(payloadSize))
+			// Kept apart to keep the JSON-RPC stream clean
+			if _, err := io.CopyN(ds.stderr, ds.resp.Reader, int64(payloadSize)); err != nil {
+				return
+			}
 		default:
 			// Stream info or other
 			io.CopyN(io.Discard, ds.resp.Reader, int64(payloadSize))
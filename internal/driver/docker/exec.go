@@ -0,0 +1,66 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// runExec runs a command inside the container outside of the agent and waits
+// for it to finish. It is used for housekeeping (health checks, setup steps)
+// that must not go through the JSON-RPC stream.
+func (d *DockerDriver) runExec(ctx context.Context, id string, cmd []string, env []string) (int, string, error) {
+	return d.runExecAs(ctx, id, "", cmd, env)
+}
+
+// runExecAs is runExec as user; "" is the image's user.
+func (d *DockerDriver) runExecAs(ctx context.Context, id, user string, cmd []string, env []string) (int, string, error) {
+	execResp, err := d.cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		User:         user,
+		Cmd:          cmd,
+		Env:          env,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, "", fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attach, err := d.cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return -1, "", fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attach.Close()
+
+	// Stdout and stderr are merged: callers only need it for diagnostics.
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attach.Reader); err != nil {
+		return -1, output.String(), fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspect, err := d.cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return -1, output.String(), fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return inspect.ExitCode, output.String(), nil
+}
+
+// startDetached launches a background process in the container and returns
+// its exec ID. The process lives until it exits or the container is removed.
+func (d *DockerDriver) startDetached(ctx context.Context, id string, cmd []string, env []string) (string, error) {
+	execResp, err := d.cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		Cmd:    cmd,
+		Env:    env,
+		Detach: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec: %w", err)
+	}
+	if err := d.cli.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		return "", fmt.Errorf("failed to start exec: %w", err)
+	}
+	return execResp.ID, nil
+}
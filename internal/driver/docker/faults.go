@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/errdefs"
+	"github.com/rs/zerolog/log"
+)
+
+// Faults that BOXED_TEST_FAULTS (or cfg["faults"]) can inject, for
+// integration tests of failure handling against a real daemon. Each takes an
+// optional delay, e.g. "stop_during_exec=2s,slow_pull".
+const (
+	// FaultStopDuringExec kills the container a delay after an exec request
+	// is sent to its agent (default 500ms).
+	FaultStopDuringExec = "stop_during_exec"
+
+	// FaultSlowPull holds every image pull for a delay before it starts
+	// (default 5s). Cancelling the pull ends the wait.
+	FaultSlowPull = "slow_pull"
+
+	// FaultFlakyDaemon fails the first attempt of every call that is
+	// retried on transient errors with a 503 after the call was made, as
+	// if the daemon's answer had been lost. It takes no delay.
+	FaultFlakyDaemon = "flaky_daemon"
+)
+
+var faultDefaults = map[string]time.Duration{
+	FaultStopDuringExec: 500 * time.Millisecond,
+	FaultSlowPull:       5 * time.Second,
+	FaultFlakyDaemon:    0,
+}
+
+// faults holds the delay of each enabled fault.
+type faults map[string]time.Duration
+
+// parseFaults parses a comma-separated list of fault[=delay] entries.
+func parseFaults(s string) (faults, error) {
+	return driver.ParseFaults(s, faultDefaults)
+}
+
+// slowPull waits out the slow_pull delay, if enabled.
+func (d *DockerDriver) slowPull(ctx context.Context, ref string) error {
+	delay, ok := d.faults[FaultSlowPull]
+	if !ok {
+		return nil
+	}
+	log.Warn().Str("image", ref).Dur("delay", delay).Msg("Fault injected: delaying image pull")
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flakyDaemon returns the error flaky_daemon injects into the first
+// attempt of op, if enabled.
+func (d *DockerDriver) flakyDaemon(op string) error {
+	if _, ok := d.faults[FaultFlakyDaemon]; !ok {
+		return nil
+	}
+	log.Warn().Str("op", op).Msg("Fault injected: losing the daemon's answer")
+	return errdefs.Unavailable(errors.New("fault injected: daemon unavailable"))
+}
+
+// withExecFaults wraps an agent stream so that stop_during_exec fires after
+// the first request written to it.
+func (d *DockerDriver) withExecFaults(id string, rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	delay, ok := d.faults[FaultStopDuringExec]
+	if !ok {
+		return rwc
+	}
+	return &faultStream{ReadWriteCloser: rwc, fire: func() {
+		time.AfterFunc(delay, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			log.Warn().Str("id", id).Msg("Fault injected: killing container during exec")
+			if err := d.cli.ContainerKill(ctx, id, "KILL"); err != nil {
+				log.Warn().Err(err).Str("id", id).Msg("Failed to inject stop_during_exec")
+			}
+		})
+	}}
+}
+
+type faultStream struct {
+	io.ReadWriteCloser
+	once sync.Once
+	fire func()
+}
+
+func (s *faultStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	if err == nil {
+		s.once.Do(s.fire)
+	}
+	return n, err
+}
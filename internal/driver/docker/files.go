@@ -4,8 +4,11 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -64,42 +67,151 @@ func (d *DockerDriver) PutFile(ctx context.Context, id, path string, content io.
 		return err
 	}
 
-	// Create a tar stream
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-
-	data, err := io.ReadAll(content)
-	if err != nil {
-		return fmt.Errorf("failed to read content: %w", err)
+	// Files with a known size are streamed; anything else is spooled to
+	// learn the size the tar header needs.
+	size, sized := regularFileSize(content)
+	if !sized {
+		spooled, n, cleanup, err := spoolContent(content)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		content, size = spooled, n
 	}
 
+	// The entry is named relative to "/" so that Docker creates any missing
+	// parent directories while extracting.
 	header := &tar.Header{
-		Name:    filepath.Base(absPath),
-		Size:    int64(len(data)),
+		Name:    strings.TrimPrefix(absPath, "/"),
+		Size:    size,
 		Mode:    0644,
 		ModTime: time.Now(),
 	}
 
-	if err := tw.WriteHeader(header); err != nil {
-		return fmt.Errorf("tar write header failed: %w", err)
-	}
-	if _, err := tw.Write(data); err != nil {
-		return fmt.Errorf("tar write body failed: %w", err)
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		if err := tw.WriteHeader(header); err != nil {
+			pw.CloseWithError(fmt.Errorf("tar write header failed: %w", err))
+			return
+		}
+		if _, err := io.CopyN(tw, content, size); err != nil {
+			pw.CloseWithError(fmt.Errorf("tar write body failed: %w", err))
+			return
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	defer pr.Close()
+
+	err = d.cli.CopyToContainer(ctx, id, "/", pr, types.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("docker copy failed: %w", err)
 	}
-	if err := tw.Close(); err != nil {
-		return fmt.Errorf("tar close failed: %w", err)
+	return nil
+}
+
+// PutFiles implements driver.FilesPutter by writing the files as one tar
+// stream, like the context files of a create.
+func (d *DockerDriver) PutFiles(ctx context.Context, id string, files []driver.FileUpload) error {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		absPath, err := d.resolvePath(ctx, id, file.Path)
+		if err != nil {
+			return err
+		}
+		paths[i] = absPath
 	}
 
-	// CopyToContainer expects the path to be the directory *containing* the file.
-	dir := filepath.Dir(absPath)
+	modTime := time.Now()
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := func() error {
+			tw := tar.NewWriter(pw)
+			for i, file := range files {
+				header := &tar.Header{
+					Name:    strings.TrimPrefix(paths[i], "/"),
+					Size:    file.Size,
+					Mode:    0644,
+					ModTime: modTime,
+				}
+				if err := tw.WriteHeader(header); err != nil {
+					return fmt.Errorf("tar write header failed: %w", err)
+				}
+				content, err := file.Open()
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file.Path, err)
+				}
+				_, err = io.CopyN(tw, content, file.Size)
+				content.Close()
+				if err != nil {
+					return fmt.Errorf("failed to write %s: %w", file.Path, err)
+				}
+			}
+			return tw.Close()
+		}()
+		pw.CloseWithError(err)
+		done <- err
+	}()
 
-	err = d.cli.CopyToContainer(ctx, id, dir, &buf, types.CopyToContainerOptions{})
+	err := d.cli.CopyToContainer(ctx, id, "/", pr, types.CopyToContainerOptions{})
+	pr.CloseWithError(errCopyDone)
+	if werr := <-done; werr != nil && !errors.Is(werr, errCopyDone) {
+		return werr
+	}
 	if err != nil {
 		return fmt.Errorf("docker copy failed: %w", err)
 	}
 	return nil
 }
 
+// regularFileSize reports the size of r if it is a regular file.
+func regularFileSize(r io.Reader) (int64, bool) {
+	f, ok := r.(interface{ Stat() (fs.FileInfo, error) })
+	if !ok {
+		return 0, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// spoolMemoryBytes is how much unsized content is held in memory before
+// spoolContent moves it to a temporary file.
+const spoolMemoryBytes = 1 << 20
+
+// spoolContent reads content to learn its size. Small content stays in
+// memory; anything larger goes to a temporary file removed by cleanup.
+func spoolContent(content io.Reader) (io.Reader, int64, func(), error) {
+	head, err := io.ReadAll(io.LimitReader(content, spoolMemoryBytes+1))
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	if len(head) <= spoolMemoryBytes {
+		return bytes.NewReader(head), int64(len(head)), func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "boxed-spool-")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(head), content))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("failed to spool content: %w", err)
+	}
+	return f, n, cleanup, nil
+}
+
 // GetFile implements driver.Driver.
 func (d *DockerDriver) GetFile(ctx context.Context, id, path string) (io.ReadCloser, error) {
 	absPath, err := d.resolvePath(ctx, id, path)
@@ -116,13 +228,13 @@ func (d *DockerDriver) GetFile(ctx context.Context, id, path string) (io.ReadClo
 	tr := tar.NewReader(reader)
 
 	// Advance to first entry
-	_, err = tr.Next()
+	header, err := tr.Next()
 	if err != nil {
 		reader.Close()
 		return nil, fmt.Errorf("file not found in tar: %w", err)
 	}
 
-	return &tarReadCloser{tr: tr, closer: reader}, nil
+	return &tarReadCloser{tr: tr, header: header, closer: reader}, nil
 }
 
 func (d *DockerDriver) resolvePath(ctx context.Context, id, path string) (string, error) {
@@ -142,9 +254,15 @@ func (d *DockerDriver) resolvePath(ctx context.Context, id, path string) (string
 
 type tarReadCloser struct {
 	tr     *tar.Reader
+	header *tar.Header
 	closer io.Closer
 }
 
+// Stat describes the file from its tar header, which carries the size.
+func (t *tarReadCloser) Stat() (fs.FileInfo, error) {
+	return t.header.FileInfo(), nil
+}
+
 func (t *tarReadCloser) Read(p []byte) (int, error) {
 	return t.tr.Read(p)
 }
@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// FSDiff implements driver.FSDiffer with the changes Docker tracks in the
+// container's writable layer. /tmp, /output, volumes and workspaces are
+// mounts, which Docker leaves out.
+func (d *DockerDriver) FSDiff(ctx context.Context, id string) ([]driver.FSChange, error) {
+	diff, err := d.cli.ContainerDiff(ctx, id)
+	if client.IsErrNotFound(err) {
+		return nil, driver.ErrSandboxNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff container: %w", err)
+	}
+	changes := make([]driver.FSChange, 0, len(diff))
+	for _, c := range diff {
+		kind := driver.FSChanged
+		switch c.Kind {
+		case container.ChangeAdd:
+			kind = driver.FSAdded
+		case container.ChangeDelete:
+			kind = driver.FSRemoved
+		}
+		changes = append(changes, driver.FSChange{Path: c.Path, Kind: kind})
+	}
+	slices.SortFunc(changes, func(a, b driver.FSChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+)
+
+// CollectGarbage implements driver.GarbageCollector. Orphans are managed
+// containers of this instance that the driver does not track and that were
+// created before it started: leftovers of a previous server process.
+// Containers created since are in flight, and those of other instances
+// belong to other servers sharing the daemon.
+func (d *DockerDriver) CollectGarbage(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Size:    true,
+		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []driver.GCItem
+	for _, c := range list {
+		d.mu.Lock()
+		_, tracked := d.sandboxes[c.ID]
+		d.mu.Unlock()
+		if tracked || !d.owns(c.Labels) || !time.Unix(c.Created, 0).Before(d.startedAt) {
+			continue
+		}
+
+		item := driver.GCItem{
+			Kind:   "container",
+			ID:     c.ID,
+			Reason: driver.GCReasonOrphaned,
+			Bytes:  c.SizeRw,
+			At:     time.Now(),
+		}
+		if !dryRun {
+			err := d.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+			if err != nil {
+				log.Warn().Str("id", c.ID).Err(err).Msg("Failed to remove orphan")
+				item.Error = err.Error()
+			}
+		}
+		items = append(items, item)
+	}
+
+	// Layers and networks go after containers so that those just removed
+	// free theirs
+	layers, err := d.collectLayers(ctx, dryRun)
+	if err != nil {
+		return items, err
+	}
+	items = append(items, layers...)
+	networks, err := d.collectNetworks(ctx, dryRun)
+	if err != nil {
+		return items, err
+	}
+	return append(items, networks...), nil
+}
+
+// handleOrphans applies the orphan policy to the containers of earlier
+// runs at startup. Removals are reported through the reap hook.
+func (d *DockerDriver) handleOrphans(policy string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if policy == driver.OrphanAdopt {
+		if err := d.adoptOrphans(ctx); err != nil {
+			log.Warn().Err(err).Msg("Failed to adopt orphaned containers")
+		}
+	}
+
+	// Adopted containers are tracked now; what is left cannot be adopted
+	log.Info().Msg("Performing startup garbage collection of orphaned containers...")
+	items, err := d.CollectGarbage(ctx, false)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list orphaned containers")
+		return
+	}
+
+	count := 0
+	for _, item := range items {
+		d.Reaped(item)
+		if item.Error == "" {
+			count++
+		}
+	}
+	if count > 0 {
+		log.Info().Int("count", count).Msg("Removed orphaned containers")
+	} else {
+		log.Info().Msg("No orphans found")
+	}
+}
+
+// adoptOrphans tracks the running, unexpired containers of earlier runs
+// again. Their expiry is the one they were created with; sidecars are not
+// supervised and later TTL changes are lost.
+func (d *DockerDriver) adoptOrphans(ctx context.Context) error {
+	count, err := d.adoptContainers(ctx, func(c types.Container) bool {
+		return d.owns(c.Labels) && time.Unix(c.Created, 0).Before(d.startedAt)
+	})
+	if count > 0 {
+		log.Info().Int("count", count).Msg("Adopted orphaned containers")
+	}
+	return err
+}
+
+// Adopt implements driver.Adopter. The containers of instance are owned by
+// this driver from then on, like its own, with the same limits as
+// adoptOrphans.
+func (d *DockerDriver) Adopt(ctx context.Context, instance string) (int, error) {
+	if instance == d.instance {
+		return 0, nil
+	}
+	d.adopted.Store(instance, true)
+	count, err := d.adoptContainers(ctx, func(c types.Container) bool {
+		return instanceOf(c.Labels) == instance
+	})
+	if count > 0 {
+		log.Info().Int("count", count).Str("instance", instance).Msg("Adopted containers of another instance")
+	}
+	return count, err
+}
+
+// adoptContainers tracks the running, unexpired managed containers that
+// match and are not tracked yet, returning how many there were.
+func (d *DockerDriver) adoptContainers(ctx context.Context, match func(types.Container) bool) (int, error) {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	count := 0
+	for _, c := range list {
+		if c.State != "running" || expired(c.Labels, now) || !match(c) {
+			continue
+		}
+		cfg := driver.SandboxConfig{
+			Image:        c.Image,
+			Labels:       userLabels(c.Labels),
+			Workspace:    c.Labels[WorkspaceLabel],
+			NetworkGroup: c.Labels[NetworkGroupLabel],
+			NetworkAlias: c.Labels[NetworkAliasLabel],
+		}
+		cfg.Validate()
+		ttl := cfg.Timeout
+		if at, err := strconv.ParseInt(c.Labels[ExpiresLabel], 10, 64); err == nil {
+			ttl = time.Until(time.Unix(at, 0))
+		}
+		sb := &sandbox{cfg: cfg, agentLog: driver.NewLogBuffer(driver.LogSourceAgent, 0), gpus: labelGPUs(c.Labels)}
+		if key := c.Labels[LayerLabel]; key != "" {
+			sb.layers = layerVolumes(key)
+		}
+		if cfg.NetworkGroup != "" {
+			sb.network = groupNetwork(instanceOf(c.Labels), cfg.NetworkGroup)
+		}
+
+		d.mu.Lock()
+		if _, tracked := d.sandboxes[c.ID]; !tracked {
+			d.sandboxes[c.ID] = sb
+			d.gpus.Hold(sb.gpus)
+			if sb.network != "" {
+				d.holdGroup(sb.network)
+			}
+			d.armTTL(c.ID, sb, ttl)
+			count++
+		}
+		d.mu.Unlock()
+	}
+	return count, nil
+}
+
+// instanceOf returns the instance a container or volume belongs to.
+func instanceOf(labels map[string]string) string {
+	if instance := labels[InstanceLabel]; instance != "" {
+		return instance
+	}
+	return DefaultInstance
+}
+
+// owns reports whether a container or volume with these labels belongs to
+// this instance, or to one it adopted.
+func (d *DockerDriver) owns(labels map[string]string) bool {
+	instance := instanceOf(labels)
+	if instance == d.instance {
+		return true
+	}
+	_, adopted := d.adopted.Load(instance)
+	return adopted
+}
+
+// expire stops a sandbox whose TTL elapsed and reports it.
+func (d *DockerDriver) expire(ctx context.Context, id string) {
+	item := driver.GCItem{Kind: "sandbox", ID: id, Reason: driver.GCReasonExpired}
+	if info, _, err := d.cli.ContainerInspectWithRaw(ctx, id, true); err == nil && info.SizeRw != nil {
+		item.Bytes = *info.SizeRw
+	}
+	if err := d.Stop(ctx, id); err != nil {
+		item.Error = err.Error()
+	}
+	item.At = time.Now()
+	d.Reaped(item)
+}
@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types/container"
+	"github.com/rs/zerolog/log"
+)
+
+// GPULabel lists the GPUs passed through to a container, "id[:type],...",
+// so that a later process taking it over knows they are held.
+const GPULabel = "xyz.boxed.gpus"
+
+// hostGPUs returns the GPUs sandboxes can be given: cfg["gpus"] (see
+// driver.ParseGPUs) or, if unset, those nvidia-smi lists. nvidia-smi runs
+// where the server does, so a remote daemon's GPUs must be configured.
+func hostGPUs(cfg map[string]any) ([]driver.GPU, error) {
+	if s, ok := cfg["gpus"].(string); ok && s != "" {
+		return driver.ParseGPUs(s)
+	}
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=index,name", "--format=csv,noheader").Output()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list GPUs with nvidia-smi; sandboxes get none")
+		return nil, nil
+	}
+	var gpus []driver.GPU
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		index, name, ok := strings.Cut(scanner.Text(), ",")
+		if ok {
+			gpus = append(gpus, driver.GPU{ID: strings.TrimSpace(index), Type: strings.TrimSpace(name)})
+		}
+	}
+	return gpus, nil
+}
+
+// gpuDeviceRequest passes gpus through to a container, like docker run
+// --gpus.
+func gpuDeviceRequest(gpus []driver.GPU) container.DeviceRequest {
+	ids := make([]string, len(gpus))
+	for i, g := range gpus {
+		ids[i] = g.ID
+	}
+	return container.DeviceRequest{
+		Driver:       "nvidia",
+		DeviceIDs:    ids,
+		Capabilities: [][]string{{"gpu"}},
+	}
+}
+
+// formatGPUs is the GPULabel value of gpus.
+func formatGPUs(gpus []driver.GPU) string {
+	entries := make([]string, len(gpus))
+	for i, g := range gpus {
+		entries[i] = g.ID
+		if g.Type != "" {
+			entries[i] += ":" + g.Type
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// labelGPUs returns the GPUs of a container, from its GPULabel.
+func labelGPUs(labels map[string]string) []driver.GPU {
+	gpus, _ := driver.ParseGPUs(labels[GPULabel])
+	return gpus
+}
@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Hibernate implements driver.Hibernator by stopping the container without
+// removing it: its writable layer, workspace volumes, network and GPUs are
+// kept for Wake. Processes get Docker's grace period to exit. Only this
+// process knows a container hibernates; a later one handles it by its
+// orphan policy, like any other.
+func (d *DockerDriver) Hibernate(ctx context.Context, id string) error {
+	inspect, err := d.cli.ContainerInspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		return driver.ErrSandboxNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	if sb == nil {
+		d.mu.Unlock()
+		return driver.ErrSandboxNotFound
+	}
+	markDied(sb, id, inspect.State)
+	if sb.hibernated || !inspect.State.Running {
+		d.mu.Unlock()
+		return driver.ErrSandboxNotRunning
+	}
+	// Set first, so that the reconciler does not take the stop for a death
+	sb.hibernated = true
+	d.mu.Unlock()
+
+	stopCtx, span := tracing.Start(ctx, "docker.container_stop")
+	err = d.cli.ContainerStop(stopCtx, id, container.StopOptions{})
+	tracing.End(span, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		sb.hibernated = false
+		if client.IsErrNotFound(err) {
+			return driver.ErrSandboxNotFound
+		}
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	if sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	sb.sidecarExecs = nil
+	return nil
+}
+
+// Wake implements driver.Hibernator. The container keeps its ID, but may
+// get another IP address.
+func (d *DockerDriver) Wake(ctx context.Context, id string, ttl time.Duration) error {
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	var hibernated bool
+	var failure string
+	if sb != nil {
+		hibernated, failure = sb.hibernated, sb.failure
+	}
+	d.mu.Unlock()
+	switch {
+	case sb == nil:
+		return driver.ErrSandboxNotFound
+	case hibernated:
+	case failure != "":
+		return driver.ErrSandboxNotRunning
+	default:
+		return driver.ErrSandboxAlreadyRunning
+	}
+
+	if err := d.Start(ctx, id); err != nil {
+		// Stop what did start, so that the sandbox stays hibernated
+		stopCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		d.cli.ContainerStop(stopCtx, id, container.StopOptions{})
+		return err
+	}
+	d.mu.Lock()
+	sb.hibernated = false
+	d.armTTL(id, sb, ttl)
+	d.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// pullMessage is a single line of the JSON stream returned by ImagePull.
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Error          string `json:"error"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+// PullImage implements driver.ImageManager. Images not built for the host
+// platform are pulled for one they are built for.
+func (d *DockerDriver) PullImage(ctx context.Context, ref string, progress func(driver.PullProgress)) error {
+	_, err := d.pullPlatform(ctx, ref, "", progress)
+	return err
+}
+
+// pullImage pulls ref for platform, the daemon's if empty, retrying
+// transient failures: pulls are idempotent.
+func (d *DockerDriver) pullImage(ctx context.Context, ref, platform string, progress func(driver.PullProgress)) error {
+	return d.retry(ctx, "pull", func() error {
+		return d.pullOnce(ctx, ref, platform, progress)
+	})
+}
+
+func (d *DockerDriver) pullOnce(ctx context.Context, ref, platform string, progress func(driver.PullProgress)) error {
+	if err := d.slowPull(ctx, ref); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	auth, err := d.registryAuth(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	reader, err := d.cli.ImagePull(ctx, ref, types.ImagePullOptions{Platform: platform, RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer reader.Close()
+
+	type layer struct {
+		current, total int64
+		done           bool
+	}
+	layers := make(map[string]*layer)
+	var order []string
+
+	dec := json.NewDecoder(reader)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if msg.Error != "" {
+			return &pullError{ref: ref, msg: msg.Error}
+		}
+		if progress == nil {
+			continue
+		}
+
+		// Layer-scoped messages carry an ID; the rest are overall status lines.
+		if msg.ID != "" && msg.ID != ref {
+			l, ok := layers[msg.ID]
+			if !ok {
+				l = &layer{}
+				layers[msg.ID] = l
+				order = append(order, msg.ID)
+			}
+			switch msg.Status {
+			case "Downloading":
+				l.current = msg.ProgressDetail.Current
+				l.total = msg.ProgressDetail.Total
+			case "Download complete", "Pull complete", "Already exists":
+				l.done = true
+				if l.total > 0 {
+					l.current = l.total
+				}
+			}
+		}
+
+		p := driver.PullProgress{Status: msg.Status, Layers: len(order)}
+		for _, id := range order {
+			l := layers[id]
+			p.CurrentBytes += l.current
+			p.TotalBytes += l.total
+			if l.done {
+				p.LayersDone++
+			}
+		}
+		progress(p)
+	}
+	return nil
+}
+
+// ListImages implements driver.ImageManager.
+func (d *DockerDriver) ListImages(ctx context.Context) ([]*driver.ImageInfo, error) {
+	images, err := d.cli.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	results := make([]*driver.ImageInfo, 0, len(images))
+	for _, img := range images {
+		results = append(results, &driver.ImageInfo{
+			ID:        img.ID,
+			Tags:      img.RepoTags,
+			SizeBytes: img.Size,
+			CreatedAt: time.Unix(img.Created, 0).UTC(),
+		})
+	}
+	return results, nil
+}
+
+// ensureImage pulls ref for platform unless it is already available
+// locally, and returns the platform of the image; see
+// driver.SandboxConfig.Platform.
+func (d *DockerDriver) ensureImage(ctx context.Context, ref, platform string) (string, error) {
+	var img types.ImageInspect
+	err := d.retry(ctx, "image_inspect", func() (err error) {
+		img, _, err = d.cli.ImageInspectWithRaw(ctx, ref)
+		return err
+	})
+	if err == nil && (platform == "" || samePlatform(platform, imagePlatform(img))) {
+		return imagePlatform(img), nil
+	} else if err != nil && !client.IsErrNotFound(err) {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	log.Info().Str("image", ref).Str("platform", platform).Msg("Image not found locally, pulling...")
+	driver.ReportPull(ctx)
+	driver.ReportPhase(ctx, driver.PhasePullingImage)
+	pullCtx, span := tracing.Start(ctx, "docker.pull", attribute.String("boxed.image", ref))
+	pulled, err := d.pullPlatform(pullCtx, ref, platform, nil)
+	tracing.End(span, err)
+	driver.ReportPhase(ctx, driver.PhaseCreating)
+	return pulled, err
+}
@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// processLogTail is how many lines of the container's own log are sent
+// before following.
+const processLogTail = "1000"
+
+// Logs implements driver.LogReader. The agent source is the stderr of the
+// agents this process attached to; the process source is Docker's log of
+// the container's main process.
+func (d *DockerDriver) Logs(ctx context.Context, id, source string, follow bool) (<-chan driver.LogLine, error) {
+	if err := driver.ValidateLogSource(source); err != nil {
+		return nil, err
+	}
+	if source == driver.LogSourceProcess {
+		return d.processLogs(ctx, id, follow)
+	}
+
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	d.mu.Unlock()
+	if sb == nil {
+		return nil, driver.ErrSandboxNotFound
+	}
+	return sb.agentLog.Stream(ctx, follow), nil
+}
+
+func (d *DockerDriver) processLogs(ctx context.Context, id string, follow bool) (<-chan driver.LogLine, error) {
+	rc, err := d.cli.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Timestamps: true,
+		Tail:       processLogTail,
+	})
+	if client.IsErrNotFound(err) {
+		return nil, driver.ErrSandboxNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read container logs: %w", err)
+	}
+
+	ch := make(chan driver.LogLine, 64)
+	pr, pw := io.Pipe()
+	go func() {
+		// The container has no TTY, so both streams come multiplexed
+		_, err := stdcopy.StdCopy(pw, pw, rc)
+		pw.CloseWithError(err)
+	}()
+	go func() {
+		defer close(ch)
+		defer rc.Close()
+		defer pr.Close()
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			line := driver.LogLine{Source: driver.LogSourceProcess, Text: scanner.Text()}
+			// Each line starts with its RFC 3339 timestamp
+			if ts, text, ok := strings.Cut(line.Text, " "); ok {
+				if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+					line.Time, line.Text = t, text
+				}
+			}
+			select {
+			case ch <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
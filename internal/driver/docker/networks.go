@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+)
+
+// NetworkGroupLabel marks the containers of a driver.SandboxConfig
+// NetworkGroup and the network they share; the value is the group name.
+// NetworkAliasLabel keeps a container's NetworkAlias.
+const (
+	NetworkGroupLabel = "xyz.boxed.network_group"
+	NetworkAliasLabel = "xyz.boxed.network_alias"
+)
+
+// groupNetwork names the Docker network of group for instance. Instances
+// sharing a daemon get their own networks for the same group name.
+func groupNetwork(instance, group string) string {
+	return "boxed-net-" + instance + "-" + group
+}
+
+// joinGroup counts one more sandbox on the network of group, creating it
+// for the first, and returns the network's name. The network is internal:
+// its sandboxes reach each other and nothing else.
+func (d *DockerDriver) joinGroup(ctx context.Context, group string) (string, error) {
+	d.groupMu.Lock()
+	defer d.groupMu.Unlock()
+
+	name := groupNetwork(d.instance, group)
+	if d.groups[name] == 0 {
+		_, err := d.cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+		if client.IsErrNotFound(err) {
+			_, err = d.cli.NetworkCreate(ctx, name, types.NetworkCreate{
+				CheckDuplicate: true,
+				Driver:         "bridge",
+				Internal:       true,
+				Labels: map[string]string{
+					ManagedLabel:      "true",
+					InstanceLabel:     d.instance,
+					NetworkGroupLabel: group,
+				},
+			})
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create network for group %s: %w", group, err)
+		}
+	}
+	d.groups[name]++
+	return name, nil
+}
+
+// leaveGroup counts one sandbox less on a group network, removing it after
+// the last.
+func (d *DockerDriver) leaveGroup(name string) {
+	d.groupMu.Lock()
+	defer d.groupMu.Unlock()
+
+	if d.groups[name]--; d.groups[name] > 0 {
+		return
+	}
+	delete(d.groups, name)
+	// Fresh context: the network outlives the request that stopped its
+	// last sandbox
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.cli.NetworkRemove(ctx, name); err != nil && !client.IsErrNotFound(err) {
+		log.Warn().Err(err).Str("network", name).Msg("Failed to remove group network")
+	}
+}
+
+// holdGroup counts an adopted sandbox on a group network, which exists.
+func (d *DockerDriver) holdGroup(name string) {
+	d.groupMu.Lock()
+	d.groups[name]++
+	d.groupMu.Unlock()
+}
+
+// groupEndpoint attaches a container to the network of its group under its
+// alias, if it has one.
+func groupEndpoint(net string, cfg driver.SandboxConfig) *network.NetworkingConfig {
+	settings := &network.EndpointSettings{}
+	if cfg.NetworkAlias != "" {
+		settings.Aliases = []string{cfg.NetworkAlias}
+	}
+	return &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{net: settings}}
+}
+
+// collectNetworks removes the group networks of this instance, or of one it
+// adopted, that no sandbox is counted in, such as those left behind by an
+// earlier process.
+func (d *DockerDriver) collectNetworks(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	list, err := d.cli.NetworkList(ctx, types.NetworkListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", NetworkGroupLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.groupMu.Lock()
+	defer d.groupMu.Unlock()
+	var items []driver.GCItem
+	for _, n := range list {
+		if d.groups[n.Name] > 0 || !d.owns(n.Labels) || !n.Created.Before(d.startedAt) {
+			continue
+		}
+		item := driver.GCItem{Kind: "network", ID: n.Name, Reason: driver.GCReasonOrphaned, At: time.Now()}
+		if !dryRun {
+			if err := d.cli.NetworkRemove(ctx, n.ID); err != nil && !client.IsErrNotFound(err) {
+				log.Warn().Str("network", n.Name).Err(err).Msg("Failed to remove orphaned network")
+				item.Error = err.Error()
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// applyDNS sets the resolv.conf and /etc/hosts entries of cfg, which
+// Docker writes into the container. HostGateway is Docker's own name for
+// the host.
+func applyDNS(hc *container.HostConfig, cfg driver.SandboxConfig) {
+	hc.DNS = cfg.DNS
+	hc.DNSSearch = cfg.DNSSearch
+	hc.DNSOptions = cfg.DNSOptions
+	for _, name := range slices.Sorted(maps.Keys(cfg.ExtraHosts)) {
+		hc.ExtraHosts = append(hc.ExtraHosts, name+":"+cfg.ExtraHosts[name])
+	}
+}
+
+// EgressLabel marks the network of an instance's sandboxes that reach the
+// internet through a driver.EgressProxy.
+const EgressLabel = "xyz.boxed.egress"
+
+// egressNet is a network sandboxes reach the host on and nothing else.
+type egressNet struct {
+	name    string
+	gateway string
+}
+
+// egressNetwork returns the network of sandboxes with an EgressProxy,
+// creating it first. It is internal, like group networks, so that the
+// only way out is the proxy at the host's address on it, the gateway. It
+// is kept for later sandboxes.
+func (d *DockerDriver) egressNetwork(ctx context.Context) (egressNet, error) {
+	d.egressMu.Lock()
+	defer d.egressMu.Unlock()
+	if d.egress.gateway != "" {
+		return d.egress, nil
+	}
+
+	name := "boxed-egress-" + d.instance
+	res, err := d.cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+	if client.IsErrNotFound(err) {
+		_, err = d.cli.NetworkCreate(ctx, name, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			Internal:       true,
+			Labels: map[string]string{
+				ManagedLabel:  "true",
+				InstanceLabel: d.instance,
+				EgressLabel:   "true",
+			},
+		})
+		if err == nil {
+			res, err = d.cli.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+		}
+	}
+	if err != nil {
+		return egressNet{}, fmt.Errorf("failed to create egress network: %w", err)
+	}
+	for _, c := range res.IPAM.Config {
+		if addr, err := netip.ParseAddr(c.Gateway); err == nil && addr.Is4() {
+			d.egress = egressNet{name: name, gateway: c.Gateway}
+			return d.egress, nil
+		}
+	}
+	return egressNet{}, fmt.Errorf("egress network %s has no IPv4 gateway", name)
+}
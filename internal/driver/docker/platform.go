@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// hostPlatform returns the daemon's "os/arch", which images run on
+// natively.
+func (d *DockerDriver) hostPlatform(ctx context.Context) string {
+	d.hostOnce.Do(func() {
+		d.host = runtime.GOOS + "/" + runtime.GOARCH
+		if v, err := d.cli.ServerVersion(ctx); err == nil && v.Os != "" && v.Arch != "" {
+			d.host = v.Os + "/" + v.Arch
+		}
+	})
+	return d.host
+}
+
+// pullPlatform pulls ref for platform and returns the platform pulled. With
+// no platform, an image not built for the host is pulled for a platform it
+// is built for, preferring amd64, the one emulators cover best.
+func (d *DockerDriver) pullPlatform(ctx context.Context, ref, platform string, progress func(driver.PullProgress)) (string, error) {
+	err := d.pullImage(ctx, ref, platform, progress)
+	if err != nil && noMatchingManifest(err) {
+		available := d.imagePlatforms(ctx, ref)
+		if platform != "" || len(available) == 0 {
+			return "", fmt.Errorf("%w: image %s is not built for %s; it is for %s",
+				driver.ErrInvalidConfig, ref, orHost(platform, d.hostPlatform(ctx)), orUnknown(available))
+		}
+		platform = pickPlatform(available, d.hostPlatform(ctx))
+		log.Warn().Str("image", ref).Str("platform", platform).Str("host", d.hostPlatform(ctx)).
+			Msg("Image is not built for the host platform; its sandboxes run under emulation")
+		err = d.pullImage(ctx, ref, platform, progress)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var img types.ImageInspect
+	err = d.retry(ctx, "image_inspect", func() (err error) {
+		img, _, err = d.cli.ImageInspectWithRaw(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return imagePlatform(img), nil
+}
+
+// noMatchingManifest reports whether a pull failed because the image is
+// not built for the platform asked for.
+func noMatchingManifest(err error) bool {
+	return strings.Contains(err.Error(), "no matching manifest")
+}
+
+// imagePlatforms returns the platforms the registry has ref for, or none
+// if it cannot tell.
+func (d *DockerDriver) imagePlatforms(ctx context.Context, ref string) []string {
+	dist, err := d.cli.DistributionInspect(ctx, ref, "")
+	if err != nil {
+		log.Debug().Err(err).Str("image", ref).Msg("Failed to inspect image platforms")
+		return nil
+	}
+	var platforms []string
+	for _, p := range dist.Platforms {
+		// Attestation manifests are listed as unknown/unknown
+		if p.OS == "" || p.OS == "unknown" {
+			continue
+		}
+		platforms = append(platforms, formatPlatform(p.OS, p.Architecture, p.Variant))
+	}
+	return platforms
+}
+
+// pickPlatform chooses which of available to run on host: amd64 if the
+// image has it for the host OS, else the first for the host OS.
+func pickPlatform(available []string, host string) string {
+	hostOS, _, _ := strings.Cut(host, "/")
+	if slices.Contains(available, hostOS+"/amd64") {
+		return hostOS + "/amd64"
+	}
+	for _, p := range available {
+		if strings.HasPrefix(p, hostOS+"/") {
+			return p
+		}
+	}
+	return available[0]
+}
+
+// imagePlatform returns the "os/arch[/variant]" of a local image.
+func imagePlatform(img types.ImageInspect) string {
+	return formatPlatform(img.Os, img.Architecture, img.Variant)
+}
+
+func formatPlatform(os, arch, variant string) string {
+	p := os + "/" + arch
+	if variant != "" {
+		p += "/" + variant
+	}
+	return p
+}
+
+// samePlatform reports whether platforms a and b are the same. A variant
+// only matters if both name one.
+func samePlatform(a, b string) bool {
+	aOS, aArch, aVariant := splitPlatform(a)
+	bOS, bArch, bVariant := splitPlatform(b)
+	return aOS == bOS && aArch == bArch && (aVariant == "" || bVariant == "" || aVariant == bVariant)
+}
+
+// emulated reports whether an image for platform runs under emulation on
+// host.
+func emulated(platform, host string) bool {
+	if platform == "" || host == "" {
+		return false
+	}
+	_, arch, _ := splitPlatform(platform)
+	_, hostArch, _ := splitPlatform(host)
+	return arch != hostArch
+}
+
+func splitPlatform(p string) (os, arch, variant string) {
+	os, rest, _ := strings.Cut(p, "/")
+	arch, variant, _ = strings.Cut(rest, "/")
+	return os, arch, variant
+}
+
+// ociPlatform is platform for ContainerCreate. Daemons older than API 1.41
+// refuse one, so none is given for images running natively.
+func ociPlatform(platform, host string) *ocispec.Platform {
+	if !emulated(platform, host) {
+		return nil
+	}
+	os, arch, variant := splitPlatform(platform)
+	return &ocispec.Platform{OS: os, Architecture: arch, Variant: variant}
+}
+
+func orHost(platform, host string) string {
+	if platform == "" {
+		return host
+	}
+	return platform
+}
+
+func orUnknown(platforms []string) string {
+	if len(platforms) == 0 {
+		return "other platforms"
+	}
+	return strings.Join(platforms, ", ")
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultReconcileInterval is how often the reconciler compares Docker's
+// containers with the sandboxes the driver tracks.
+const DefaultReconcileInterval = time.Minute
+
+var (
+	reconcileRunsTotal = metrics.Default.Counter("boxed_reconcile_runs_total",
+		"Reconciliations of Docker state with tracked sandboxes.", "result")
+	sandboxesDiedTotal = metrics.Default.Counter("boxed_sandboxes_died_total",
+		"Sandboxes marked as failed because their container stopped on its own.")
+)
+
+// reconcileLoop runs reconcile every interval until the driver is closed.
+func (d *DockerDriver) reconcileLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := d.reconcile(ctx); err != nil {
+				reconcileRunsTotal.Inc("error")
+				log.Warn().Err(err).Msg("Failed to reconcile containers")
+			} else {
+				reconcileRunsTotal.Inc("ok")
+			}
+			cancel()
+		}
+	}
+}
+
+// reconcile brings Docker in line with what the driver expects, for the
+// containers of this instance:
+//   - tracked sandboxes whose container stopped on its own are marked as
+//     failed; their TTL still removes them, so clients can see why
+//   - untracked containers past their expiry label are removed, e.g. when
+//     their TTL elapsed while the server was down
+//   - untracked containers that exited are removed
+//
+// Removals are reported through the reap hook.
+func (d *DockerDriver) reconcile(ctx context.Context) error {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Size:    true,
+		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, c := range list {
+		if !d.owns(c.Labels) {
+			continue
+		}
+		exited := c.State == "exited" || c.State == "dead"
+
+		d.mu.Lock()
+		sb := d.sandboxes[c.ID]
+		dying := sb != nil && exited && sb.failure == "" && !sb.hibernated
+		d.mu.Unlock()
+		if dying {
+			// The list does not say whether the kernel killed it
+			if inspect, err := d.cli.ContainerInspect(ctx, c.ID); err == nil {
+				d.mu.Lock()
+				markDied(sb, c.ID, inspect.State)
+				d.mu.Unlock()
+			}
+		}
+		if sb != nil {
+			continue
+		}
+
+		var item driver.GCItem
+		switch {
+		case expired(c.Labels, now):
+			item = driver.GCItem{Kind: "sandbox", ID: c.ID, Reason: driver.GCReasonExpired}
+		case exited:
+			item = driver.GCItem{Kind: "container", ID: c.ID, Reason: driver.GCReasonExited}
+		default:
+			continue
+		}
+		item.Bytes = c.SizeRw
+		err := d.cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil {
+			log.Warn().Str("id", c.ID).Err(err).Msg("Failed to remove container")
+			item.Error = err.Error()
+		}
+		item.At = time.Now()
+		d.Reaped(item)
+	}
+	return nil
+}
+
+// markDied records why a tracked sandbox's container stopped on its own,
+// if it has and that is not known already. Hibernated containers were
+// stopped on purpose. Callers hold d.mu.
+func markDied(sb *sandbox, id string, state *types.ContainerState) {
+	if sb.failure != "" || sb.hibernated || state == nil || (state.Status != "exited" && state.Status != "dead") {
+		return
+	}
+	if state.OOMKilled {
+		sb.exitReason = driver.ExitOOMKilled
+		sb.failure = fmt.Sprintf("container stopped unexpectedly: killed for exceeding its memory limit of %d MB", sb.cfg.MemoryMB)
+	} else {
+		sb.exitReason = driver.ExitSandboxDied
+		sb.failure = fmt.Sprintf("container stopped unexpectedly with exit code %d", state.ExitCode)
+	}
+	sandboxesDiedTotal.Inc()
+	log.Warn().Str("id", id).Str("reason", sb.exitReason).Int("exit_code", state.ExitCode).Msg("Sandbox container died")
+}
+
+// expired reports whether a container's expiry label lies before now.
+// Containers without the label never expire here.
+func expired(labels map[string]string, now time.Time) bool {
+	at, err := strconv.ParseInt(labels[ExpiresLabel], 10, 64)
+	return err == nil && time.Unix(at, 0).Before(now)
+}
@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubServer is the address Docker Hub's credential is kept under,
+// by helpers and in the credentials of builds.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// registryCredentials are the credentials image pulls use, by registry.
+type registryCredentials struct {
+	mu    sync.Mutex
+	creds map[string]driver.RegistryCredential
+}
+
+// parseRegistries reads cfg["registries"], a list of
+// driver.RegistryCredential, as given in the driver options of the config
+// file or by a program embedding the driver.
+func parseRegistries(cfg map[string]any) (*registryCredentials, error) {
+	r := &registryCredentials{creds: make(map[string]driver.RegistryCredential)}
+	v, ok := cfg["registries"]
+	if !ok || v == nil {
+		return r, nil
+	}
+	var creds []driver.RegistryCredential
+	if c, ok := v.([]driver.RegistryCredential); ok {
+		creds = c
+	} else {
+		// Lists of maps from YAML take the same shape through JSON
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: registries: %v", driver.ErrInvalidConfig, err)
+		}
+		if err := json.Unmarshal(data, &creds); err != nil {
+			return nil, fmt.Errorf("%w: registries must be a list of registry, username and password or helper", driver.ErrInvalidConfig)
+		}
+	}
+	for _, c := range creds {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		r.creds[c.Registry] = c
+	}
+	return r, nil
+}
+
+// SetRegistryCredential implements driver.RegistryAuthenticator.
+func (d *DockerDriver) SetRegistryCredential(cred driver.RegistryCredential) error {
+	if err := cred.Validate(); err != nil {
+		return err
+	}
+	d.registries.mu.Lock()
+	defer d.registries.mu.Unlock()
+	d.registries.creds[cred.Registry] = cred
+	return nil
+}
+
+// DeleteRegistryCredential implements driver.RegistryAuthenticator.
+func (d *DockerDriver) DeleteRegistryCredential(registry string) bool {
+	registry = driver.NormalizeRegistry(registry)
+	d.registries.mu.Lock()
+	defer d.registries.mu.Unlock()
+	_, ok := d.registries.creds[registry]
+	delete(d.registries.creds, registry)
+	return ok
+}
+
+// RegistryCredentials implements driver.RegistryAuthenticator.
+func (d *DockerDriver) RegistryCredentials() []driver.RegistryCredential {
+	d.registries.mu.Lock()
+	defer d.registries.mu.Unlock()
+	creds := make([]driver.RegistryCredential, 0, len(d.registries.creds))
+	for _, c := range d.registries.creds {
+		c.Password = ""
+		creds = append(creds, c)
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Registry < creds[j].Registry })
+	return creds
+}
+
+// registryAuth returns the encoded credentials for pulling ref, or "" if
+// its registry has none. Helpers are asked on every pull: the tokens of
+// some, such as ECR's, expire within hours.
+func (d *DockerDriver) registryAuth(ctx context.Context, ref string) (string, error) {
+	host := driver.ImageRegistry(ref)
+	d.registries.mu.Lock()
+	cred, ok := d.registries.creds[host]
+	d.registries.mu.Unlock()
+	if !ok {
+		return "", nil
+	}
+	auth, err := credentialAuth(ctx, cred)
+	if err != nil {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(auth)
+}
+
+// buildAuths returns the credentials of every registry, for the base
+// images of a build, keyed by server address as the daemon expects.
+func (d *DockerDriver) buildAuths(ctx context.Context) (map[string]registry.AuthConfig, error) {
+	d.registries.mu.Lock()
+	creds := make([]driver.RegistryCredential, 0, len(d.registries.creds))
+	for _, c := range d.registries.creds {
+		creds = append(creds, c)
+	}
+	d.registries.mu.Unlock()
+
+	auths := make(map[string]registry.AuthConfig, len(creds))
+	for _, cred := range creds {
+		auth, err := credentialAuth(ctx, cred)
+		if err != nil {
+			return nil, err
+		}
+		server := cred.Registry
+		if server == driver.DockerHub {
+			server = dockerHubServer
+		}
+		auths[server] = auth
+	}
+	return auths, nil
+}
+
+// credentialAuth turns cred into the credential the daemon is given,
+// asking its helper if it has one.
+func credentialAuth(ctx context.Context, cred driver.RegistryCredential) (registry.AuthConfig, error) {
+	if cred.Helper != "" {
+		return helperCredential(ctx, cred.Helper, cred.Registry)
+	}
+	return registry.AuthConfig{ServerAddress: cred.Registry, Username: cred.Username, Password: cred.Password}, nil
+}
+
+// helperCredential asks the credential helper docker-credential-<helper>
+// for the credential of host, as the docker CLI does.
+func helperCredential(ctx context.Context, helper, host string) (registry.AuthConfig, error) {
+	server := host
+	if host == driver.DockerHub {
+		server = dockerHubServer
+	}
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			// Helpers report some errors, such as a missing
+			// credential, on stdout
+			msg = strings.TrimSpace(string(out))
+		}
+		return registry.AuthConfig{}, fmt.Errorf("credential helper %s failed for %s: %w: %s", helper, host, err, msg)
+	}
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("credential helper %s returned invalid JSON: %w", helper, err)
+	}
+	auth := registry.AuthConfig{ServerAddress: host, Username: resp.Username, Password: resp.Secret}
+	if resp.Username == "<token>" {
+		// The secret is an identity token rather than a password
+		auth = registry.AuthConfig{ServerAddress: host, IdentityToken: resp.Secret}
+	}
+	return auth, nil
+}
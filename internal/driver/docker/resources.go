@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// UpdateResources implements driver.ResourceUpdater by changing the
+// container's cgroup limits. Lowering the memory limit below what the
+// sandbox uses fails, as the kernel cannot reclaim it.
+func (d *DockerDriver) UpdateResources(ctx context.Context, id string, u driver.ResourceUpdate) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	d.mu.Unlock()
+	if sb == nil {
+		return driver.ErrSandboxNotFound
+	}
+	info, err := d.cli.ContainerInspect(ctx, id)
+	if client.IsErrNotFound(err) {
+		return driver.ErrSandboxNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if !info.State.Running {
+		return driver.ErrSandboxNotRunning
+	}
+
+	var resources container.Resources
+	if u.MemoryMB > 0 {
+		resources.Memory = u.MemoryMB * 1024 * 1024
+		// As Docker defaults it at create, so that raising the limit
+		// does not go past the swap limit
+		resources.MemorySwap = 2 * resources.Memory
+	}
+	if u.CPUCores > 0 {
+		d.mu.Lock()
+		cfg := sb.cfg
+		d.mu.Unlock()
+		cfg.CPUCores = u.CPUCores
+		cpu := cpuResources(cfg)
+		// Docker refuses to change the period of a running container
+		resources.NanoCPUs, resources.CPUQuota = cpu.NanoCPUs, cpu.CPUQuota
+	}
+	if _, err := d.cli.ContainerUpdate(ctx, id, container.UpdateConfig{Resources: resources}); err != nil {
+		if client.IsErrNotFound(err) {
+			return driver.ErrSandboxNotFound
+		}
+		return fmt.Errorf("failed to update container resources: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if u.MemoryMB > 0 {
+		sb.cfg.MemoryMB = u.MemoryMB
+	}
+	if u.CPUCores > 0 {
+		sb.cfg.CPUCores = u.CPUCores
+	}
+	return nil
+}
+
+// cpuResources returns the CPU limits of a sandbox of cfg: NanoCPUs (1.0
+// cores = 1e9), or with a burst, a quota of CPUCores per period of the
+// burst and its shares.
+func cpuResources(cfg driver.SandboxConfig) container.Resources {
+	b := cfg.CPUBurst
+	if b == nil {
+		return container.Resources{NanoCPUs: int64(cfg.CPUCores * 1e9)}
+	}
+	period := b.Period().Microseconds()
+	return container.Resources{
+		CPUPeriod: period,
+		CPUQuota:  int64(cfg.CPUCores * float64(period)),
+		CPUShares: b.Shares,
+	}
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of the retry settings; see retryConfig.
+const (
+	DefaultRetries         = 3
+	DefaultRetryBackoff    = 200 * time.Millisecond
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// CreateKeyLabel identifies the create call that made a container, so that
+// a create retried after a transient error finds the container an earlier
+// attempt made instead of making a second one.
+const CreateKeyLabel = "xyz.boxed.create_key"
+
+// retryPolicy is how calls to the daemon that fail with a transient error,
+// such as a dropped connection or a 500 from an overloaded dockerd, are
+// retried.
+type retryPolicy struct {
+	// retries is the attempts made after the first; 0 disables retries
+	retries int
+
+	// backoff is the wait before the first retry, doubled for each one
+	// after it up to maxBackoff. Waits are jittered by up to half.
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// retryConfig returns the retry settings of cfg: cfg["retries"] (default
+// DefaultRetries; negative disables retries), cfg["retry_backoff"] and
+// cfg["retry_max_backoff"] (time.Durations).
+func retryConfig(cfg map[string]any) retryPolicy {
+	p := retryPolicy{retries: DefaultRetries, backoff: DefaultRetryBackoff, maxBackoff: DefaultRetryMaxBackoff}
+	if v, ok := cfg["retries"].(int); ok && v != 0 {
+		p.retries = max(v, 0)
+	}
+	if v, ok := cfg["retry_backoff"].(time.Duration); ok && v > 0 {
+		p.backoff = v
+	}
+	if v, ok := cfg["retry_max_backoff"].(time.Duration); ok && v > 0 {
+		p.maxBackoff = v
+	}
+	p.maxBackoff = max(p.maxBackoff, p.backoff)
+	return p
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient, or the retries run out, and returns its last error. fn must
+// be safe to call again after a transient error: idempotent, such as an
+// inspect, a list or a pull, or guarded, as create is by CreateKeyLabel.
+func (d *DockerDriver) retry(ctx context.Context, op string, fn func() error) error {
+	backoff := d.retries.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt == 0 && err == nil {
+			err = d.flakyDaemon(op)
+		}
+		if err == nil || attempt >= d.retries.retries || !transient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := backoff/2 + rand.N(backoff/2+1)
+		log.Warn().Err(err).Str("op", op).Int("attempt", attempt+1).Dur("backoff", wait).
+			Msg("Transient Docker error, retrying")
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff = min(backoff*2, d.retries.maxBackoff)
+	}
+}
+
+// transientPullErrors are the messages of pull failures, reported in the
+// progress stream rather than as errors the client can type, that are
+// worth retrying.
+var transientPullErrors = []string{
+	"unexpected EOF",
+	"connection reset by peer",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+// transient reports whether err may not happen again: the daemon could not
+// be reached or dropped the connection, answered 500 or 503, or a registry
+// failed mid-pull. Cancellations are not.
+func transient(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errdefs.IsSystem(err) || errdefs.IsUnavailable(err) || client.IsErrConnectionFailed(err):
+		return true
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE):
+		return true
+	}
+	var pe *pullError
+	if errors.As(err, &pe) {
+		for _, msg := range transientPullErrors {
+			if strings.Contains(pe.msg, msg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pullError is a failure the daemon reported in the progress stream of a
+// pull.
+type pullError struct {
+	ref, msg string
+}
+
+func (e *pullError) Error() string {
+	return fmt.Sprintf("failed to pull image %s: %s", e.ref, e.msg)
+}
+
+// createdByKey returns the ID of the container made by the create whose
+// CreateKeyLabel is key, or "" if there is none.
+func (d *DockerDriver) createdByKey(ctx context.Context, key string) (string, error) {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", CreateKeyLabel+"="+key)),
+	})
+	if err != nil || len(list) == 0 {
+		return "", err
+	}
+	return list[0].ID, nil
+}
@@ -0,0 +1,86 @@
+package docker
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// DescriptorDir holds the environment descriptor the control plane writes
+// at creation; it is a volume under a read-only root filesystem.
+const DescriptorDir = "/run/boxed"
+
+// SeccompLabel names the seccomp profile a container runs under; see
+// driver.Security.SeccompName.
+const SeccompLabel = "xyz.boxed.seccomp"
+
+// seccompStrict is driver.SeccompStrict: Docker's default profile without
+// socket, socketcall, accept, bind, connect, listen, io_uring and ptrace.
+//
+//go:embed seccomp_strict.json
+var seccompStrict string
+
+// applySecurity sets the hardening of cfg.Security on hostConfig.
+//
+// Files are copied into containers through the Docker API, which cannot
+// write to a read-only root filesystem and does not see tmpfs mounts. Under
+// ReadOnlyRootfs the working directory and DescriptorDir are therefore
+// anonymous volumes, removed with the container.
+func (d *DockerDriver) applySecurity(hostConfig *container.HostConfig, cfg driver.SandboxConfig) error {
+	sec := cfg.Security
+	if sec.ReadOnlyRootfs {
+		hostConfig.ReadonlyRootfs = true
+		if cfg.Workspace == "" {
+			hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{Type: mount.TypeVolume, Target: cfg.WorkDir})
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{Type: mount.TypeVolume, Target: DescriptorDir})
+	}
+	for _, dir := range sec.Tmpfs {
+		if dir == DescriptorDir && sec.ReadOnlyRootfs {
+			continue
+		}
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{Type: mount.TypeTmpfs, Target: dir})
+	}
+	hostConfig.CapDrop = sec.CapDrop
+	hostConfig.CapAdd = sec.CapAdd
+	if sec.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	}
+	switch sec.SeccompProfile {
+	case "", driver.SeccompDefault:
+	case driver.SeccompPermissive:
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp=unconfined")
+	case driver.SeccompStrict:
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+seccompStrict)
+	default:
+		// The API takes the profile itself rather than a path
+		data, err := os.ReadFile(sec.SeccompProfile)
+		if err != nil {
+			return fmt.Errorf("%w: seccomp profile: %v", driver.ErrInvalidConfig, err)
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("%w: seccomp profile %s is not JSON", driver.ErrInvalidConfig, sec.SeccompProfile)
+		}
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+string(data))
+	}
+	if sec.PidsLimit > 0 {
+		limit := sec.PidsLimit
+		hostConfig.Resources.PidsLimit = &limit
+	}
+	return nil
+}
+
+// labelSeccomp returns the seccomp profile of a container from its
+// SeccompLabel. Containers created before the label ran under Docker's
+// default.
+func labelSeccomp(labels map[string]string) string {
+	if name := labels[SeccompLabel]; name != "" {
+		return name
+	}
+	return driver.SeccompDefault
+}
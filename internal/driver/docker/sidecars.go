@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// startSidecars launches every sidecar declared for the sandbox as a detached
+// exec and blocks until their health checks pass. Sidecars are children of the
+// container, so they are torn down together with it by Stop.
+func (d *DockerDriver) startSidecars(ctx context.Context, id string) error {
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	d.mu.Unlock()
+	if sb == nil || len(sb.cfg.Sidecars) == 0 {
+		return nil
+	}
+	// Failures are recorded by the caller's span
+	ctx, span := tracing.Start(ctx, "docker.sidecars", attribute.Int("boxed.sidecars", len(sb.cfg.Sidecars)))
+	defer span.End()
+
+	execs := make(map[string]string, len(sb.cfg.Sidecars))
+	for _, sc := range sb.cfg.Sidecars {
+		env := make([]string, 0, len(sc.Env))
+		for k, v := range sc.Env {
+			env = append(env, fmt.Sprintf("%s=%s", k, v))
+		}
+
+		execID, err := d.startDetached(ctx, id, sc.Cmd, env)
+		if err != nil {
+			return fmt.Errorf("failed to start sidecar %s: %w", sc.Name, err)
+		}
+		execs[sc.Name] = execID
+		log.Info().Str("id", id).Str("sidecar", sc.Name).Msg("Sidecar started")
+	}
+
+	d.mu.Lock()
+	sb.sidecarExecs = execs
+	d.mu.Unlock()
+
+	for _, sc := range sb.cfg.Sidecars {
+		if sc.HealthCheck == nil {
+			continue
+		}
+		if err := d.waitHealthy(ctx, id, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitHealthy polls the sidecar's health check until it exits 0.
+func (d *DockerDriver) waitHealthy(ctx context.Context, id string, sc driver.Sidecar) error {
+	hc := sc.HealthCheck
+	interval := time.Duration(hc.IntervalMS) * time.Millisecond
+
+	var lastOutput string
+	for attempt := 1; attempt <= hc.Retries; attempt++ {
+		code, output, err := d.runExec(ctx, id, hc.Cmd, nil)
+		if err == nil && code == 0 {
+			log.Info().Str("id", id).Str("sidecar", sc.Name).Int("attempts", attempt).Msg("Sidecar healthy")
+			return nil
+		}
+		lastOutput = output
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("sidecar %s health check: %w", sc.Name, driver.ErrTimeout)
+		case <-time.After(interval):
+		}
+	}
+	return fmt.Errorf("sidecar %s did not become healthy after %d attempts: %s", sc.Name, hc.Retries, lastOutput)
+}
+
+// sidecarStatus reports whether each sidecar process is still running.
+func (d *DockerDriver) sidecarStatus(ctx context.Context, sb *sandbox) []driver.SidecarStatus {
+	d.mu.Lock()
+	execs := make(map[string]string, len(sb.sidecarExecs))
+	for name, execID := range sb.sidecarExecs {
+		execs[name] = execID
+	}
+	d.mu.Unlock()
+
+	statuses := make([]driver.SidecarStatus, 0, len(execs))
+	for name, execID := range execs {
+		status := driver.SidecarStatus{Name: name}
+		if inspect, err := d.cli.ContainerExecInspect(ctx, execID); err == nil {
+			status.Running = inspect.Running
+			if !inspect.Running {
+				code := inspect.ExitCode
+				status.ExitCode = &code
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
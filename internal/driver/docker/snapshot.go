@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Snapshot implements driver.Snapshotter by committing the container.
+func (d *DockerDriver) Snapshot(ctx context.Context, id, ref string, labels map[string]string) error {
+	ctx, span := tracing.Start(ctx, "docker.commit", attribute.String("boxed.image", ref))
+	_, err := d.cli.ContainerCommit(ctx, id, types.ContainerCommitOptions{
+		Reference: ref,
+		Pause:     true,
+		Config:    &container.Config{Labels: labels},
+	})
+	tracing.End(span, err)
+	if client.IsErrNotFound(err) {
+		return driver.ErrSandboxNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit container: %w", err)
+	}
+	return nil
+}
+
+// HasImage implements driver.Snapshotter.
+func (d *DockerDriver) HasImage(ctx context.Context, ref string) (bool, error) {
+	_, _, err := d.cli.ImageInspectWithRaw(ctx, ref)
+	if client.IsErrNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Stats implements driver.StatsReader with a one-shot docker stats call,
+// which reads the container's cgroup without waiting for a second sample.
+func (d *DockerDriver) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	resp, err := d.cli.ContainerStatsOneShot(ctx, id)
+	if client.IsErrNotFound(err) {
+		return nil, driver.ErrSandboxNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read container stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var s types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+	// Stopped containers have no cgroup to read
+	if s.Read.IsZero() {
+		return nil, driver.ErrSandboxNotRunning
+	}
+
+	cpu := s.CPUStats.CPUUsage.TotalUsage
+	stats := &driver.ResourceStats{
+		Time:             s.Read,
+		CPUNanos:         &cpu,
+		MemoryBytes:      memoryUsage(s.MemoryStats),
+		MemoryLimitBytes: s.MemoryStats.Limit,
+		PIDs:             s.PidsStats.Current,
+	}
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			stats.DiskReadBytes += e.Value
+		case "write":
+			stats.DiskWriteBytes += e.Value
+		}
+	}
+	return stats, nil
+}
+
+// memoryUsage is the usage docker stats shows: the cgroup's usage minus
+// the inactive page cache, which the kernel reclaims before an OOM kill.
+func memoryUsage(m types.MemoryStats) uint64 {
+	// cgroup v1 calls it total_inactive_file, v2 inactive_file
+	cache, ok := m.Stats["total_inactive_file"]
+	if !ok {
+		cache = m.Stats["inactive_file"]
+	}
+	if cache > m.Usage {
+		return 0
+	}
+	return m.Usage - cache
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// setupUserScript makes sure the user $1 (a name or "uid[:gid]") exists and
+// gives it the working directory $2 and /output. Missing users and groups
+// are appended to /etc/passwd and /etc/group rather than created with
+// useradd, which slim images lack; new users get the next free uid from
+// 1000. It only relies on POSIX sh and grep.
+const setupUserScript = `
+set -e
+spec=$1 dir=$2
+name= uid= gid=
+case $spec in
+*[!0-9:]*) name=$spec ;;
+*) uid=${spec%%:*}; case $spec in *:*) gid=${spec#*:} ;; esac ;;
+esac
+exists= next=1000
+while IFS=: read -r n _ u g _; do
+	if [ -z "$exists" ] && { [ "$n" = "$name" ] || [ "$u" = "$uid" ]; }; then
+		exists=1 name=$n uid=$u
+		[ -n "$gid" ] || gid=$g
+	fi
+	if [ "$u" -ge "$next" ] && [ "$u" -lt 60000 ]; then next=$((u + 1)); fi
+done < /etc/passwd
+[ -n "$uid" ] || uid=$next
+[ -n "$gid" ] || gid=$uid
+[ -n "$name" ] || name=user$uid
+grep -q "^[^:]*:[^:]*:$gid:" /etc/group || echo "$name:x:$gid:" >> /etc/group
+if [ -z "$exists" ]; then
+	echo "$name:x:$uid:$gid::/home/$name:/bin/sh" >> /etc/passwd
+	mkdir -p "/home/$name"
+	chown "$uid:$gid" "/home/$name"
+fi
+mkdir -p "$dir"
+chown -R "$uid:$gid" "$dir" /output
+`
+
+// setupUser prepares the user a sandbox runs code as, if it has one. It runs
+// as root, whatever user the image defaults to.
+func (d *DockerDriver) setupUser(ctx context.Context, id string) error {
+	d.mu.Lock()
+	sb := d.sandboxes[id]
+	d.mu.Unlock()
+	if sb == nil || sb.cfg.User == "" {
+		return nil
+	}
+	// Failures are recorded by the caller's span
+	ctx, span := tracing.Start(ctx, "docker.setup_user", attribute.String("boxed.user", sb.cfg.User))
+	defer span.End()
+
+	cmd := []string{"sh", "-c", setupUserScript, "sh", sb.cfg.User, sb.cfg.WorkDir}
+	code, out, err := d.runExecAs(ctx, id, "0", cmd, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set up user %s: %w", sb.cfg.User, err)
+	}
+	if code != 0 {
+		return fmt.Errorf("failed to set up user %s: exit code %d: %s", sb.cfg.User, code, strings.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// VolumeLabel marks the Docker volumes backing driver.VolumeManager
+// volumes; the value is the volume name
+const VolumeLabel = "xyz.boxed.volume"
+
+// dockerVolume names the Docker volume of a volume.
+func dockerVolume(name string) string {
+	return "boxed-volume-" + name
+}
+
+// CreateVolume implements driver.VolumeManager with a named Docker volume,
+// which outlives the containers mounting it.
+func (d *DockerDriver) CreateVolume(ctx context.Context, name string, labels map[string]string) (*driver.VolumeInfo, error) {
+	if err := driver.ValidateVolumeName(name); err != nil {
+		return nil, err
+	}
+	if _, err := d.cli.VolumeInspect(ctx, dockerVolume(name)); err == nil {
+		return nil, driver.ErrVolumeExists
+	} else if !client.IsErrNotFound(err) {
+		return nil, err
+	}
+	volLabels := maps.Clone(labels)
+	if volLabels == nil {
+		volLabels = make(map[string]string, 2)
+	}
+	volLabels[ManagedLabel], volLabels[VolumeLabel] = "true", name
+	_, err := d.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   dockerVolume(name),
+		Labels: volLabels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+	return d.GetVolume(ctx, name)
+}
+
+// GetVolume implements driver.VolumeManager.
+func (d *DockerDriver) GetVolume(ctx context.Context, name string) (*driver.VolumeInfo, error) {
+	vol, err := d.inspectVolume(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	users, err := d.volumeUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return volumeInfo(vol, d.volumeSizes(ctx), users), nil
+}
+
+// ListVolumes implements driver.VolumeManager.
+func (d *DockerDriver) ListVolumes(ctx context.Context) ([]*driver.VolumeInfo, error) {
+	list, err := d.cli.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", VolumeLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	users, err := d.volumeUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sizes := d.volumeSizes(ctx)
+
+	var results []*driver.VolumeInfo
+	for _, vol := range list.Volumes {
+		if vol.Name == dockerVolume(vol.Labels[VolumeLabel]) {
+			results = append(results, volumeInfo(*vol, sizes, users))
+		}
+	}
+	return results, nil
+}
+
+// DeleteVolume implements driver.VolumeManager.
+func (d *DockerDriver) DeleteVolume(ctx context.Context, name string) error {
+	info, err := d.GetVolume(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.InUse > 0 {
+		return driver.ErrVolumeInUse
+	}
+	if err := d.cli.VolumeRemove(ctx, dockerVolume(name), false); err != nil {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+	return nil
+}
+
+// inspectVolume returns the Docker volume of a volume, which must carry
+// its label: a volume of the same name created by hand is not one.
+func (d *DockerDriver) inspectVolume(ctx context.Context, name string) (volume.Volume, error) {
+	vol, err := d.cli.VolumeInspect(ctx, dockerVolume(name))
+	if client.IsErrNotFound(err) || (err == nil && vol.Labels[VolumeLabel] != name) {
+		return vol, fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, name)
+	}
+	return vol, err
+}
+
+func volumeInfo(vol volume.Volume, sizes map[string]int64, users map[string]int) *driver.VolumeInfo {
+	created, _ := time.Parse(time.RFC3339, vol.CreatedAt)
+	size, ok := sizes[vol.Name]
+	if !ok {
+		size = -1
+	}
+	return &driver.VolumeInfo{Name: vol.Labels[VolumeLabel], CreatedAt: created, SizeBytes: size, InUse: users[vol.Name], Labels: userLabels(vol.Labels)}
+}
+
+// volumeUsers counts the sandboxes mounting each Docker volume.
+func (d *DockerDriver) volumeUsers(ctx context.Context) (map[string]int, error) {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]int)
+	for _, c := range list {
+		for _, m := range c.Mounts {
+			if m.Type == mount.TypeVolume {
+				users[m.Name]++
+			}
+		}
+	}
+	return users, nil
+}
+
+// volumeMounts returns the mounts of the volumes of a sandbox, which must
+// exist.
+func (d *DockerDriver) volumeMounts(ctx context.Context, volumes []driver.VolumeMount) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		if _, err := d.inspectVolume(ctx, v.Name); err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   dockerVolume(v.Name),
+			Target:   v.MountPath,
+			ReadOnly: v.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
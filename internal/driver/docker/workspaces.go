@@ -0,0 +1,352 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// WorkspaceLabel marks the base volume of a workspace and the containers
+	// mounting it; the value is the workspace name
+	WorkspaceLabel = "xyz.boxed.workspace"
+
+	// LayerLabel ties the copy-on-write volumes of a sandbox to its
+	// container; the value is a random key shared by both
+	LayerLabel = "xyz.boxed.layer"
+)
+
+// workspaceVolume names the base volume of a workspace.
+func workspaceVolume(name string) string {
+	return "boxed-workspace-" + name
+}
+
+// CreateWorkspace implements driver.WorkspaceManager. The base is a named
+// volume, filled through a helper container that is never started.
+func (d *DockerDriver) CreateWorkspace(ctx context.Context, spec driver.WorkspaceSpec) (*driver.WorkspaceInfo, error) {
+	if err := driver.ValidateWorkspaceName(spec.Name); err != nil {
+		return nil, err
+	}
+	if spec.Image == "" && len(spec.Files) > 0 {
+		return nil, fmt.Errorf("%w: an image is needed to fill the workspace", driver.ErrInvalidConfig)
+	}
+	archive, err := tarFiles(spec.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	name := workspaceVolume(spec.Name)
+	if _, err := d.cli.VolumeInspect(ctx, name); err == nil {
+		return nil, driver.ErrWorkspaceExists
+	} else if !client.IsErrNotFound(err) {
+		return nil, err
+	}
+	labels := maps.Clone(spec.Labels)
+	if labels == nil {
+		labels = make(map[string]string, 2)
+	}
+	labels[ManagedLabel], labels[WorkspaceLabel] = "true", spec.Name
+	vol, err := d.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   name,
+		Labels: labels,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace volume: %w", err)
+	}
+	if len(spec.Files) > 0 {
+		if err := d.fillWorkspace(ctx, spec.Name, spec.Image, archive); err != nil {
+			d.removeVolumes([]string{vol.Name})
+			return nil, err
+		}
+	}
+	return d.GetWorkspace(ctx, spec.Name)
+}
+
+// fillWorkspace extracts archive into the base volume of a workspace.
+// Docker copies into the mounts of a created container even if it never
+// runs. The helper is not managed, so it is never listed as a sandbox.
+func (d *DockerDriver) fillWorkspace(ctx context.Context, name, image string, archive *bytes.Buffer) error {
+	if _, err := d.ensureImage(ctx, image, ""); err != nil {
+		return err
+	}
+	helper, err := d.cli.ContainerCreate(ctx,
+		&container.Config{
+			Image:  image,
+			Cmd:    []string{"true"},
+			Labels: map[string]string{WorkspaceLabel: name},
+		},
+		&container.HostConfig{
+			Mounts: []mount.Mount{{
+				Type:   mount.TypeVolume,
+				Source: workspaceVolume(name),
+				Target: "/workspace",
+				// Keep whatever the image has at /workspace out of the base
+				VolumeOptions: &mount.VolumeOptions{NoCopy: true},
+			}},
+			NetworkMode: "none",
+		},
+		nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create helper container: %w", err)
+	}
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		d.cli.ContainerRemove(cleanupCtx, helper.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	if err := d.cli.CopyToContainer(ctx, helper.ID, "/workspace", archive, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy workspace files: %w", err)
+	}
+	return nil
+}
+
+// GetWorkspace implements driver.WorkspaceManager.
+func (d *DockerDriver) GetWorkspace(ctx context.Context, name string) (*driver.WorkspaceInfo, error) {
+	vol, err := d.cli.VolumeInspect(ctx, workspaceVolume(name))
+	if client.IsErrNotFound(err) || (err == nil && vol.Labels[WorkspaceLabel] != name) {
+		return nil, driver.ErrWorkspaceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	users, err := d.workspaceUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return workspaceInfo(vol, d.volumeSizes(ctx), users), nil
+}
+
+// ListWorkspaces implements driver.WorkspaceManager.
+func (d *DockerDriver) ListWorkspaces(ctx context.Context) ([]*driver.WorkspaceInfo, error) {
+	list, err := d.cli.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", WorkspaceLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	users, err := d.workspaceUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sizes := d.volumeSizes(ctx)
+
+	var results []*driver.WorkspaceInfo
+	for _, vol := range list.Volumes {
+		if vol.Name == workspaceVolume(vol.Labels[WorkspaceLabel]) {
+			results = append(results, workspaceInfo(*vol, sizes, users))
+		}
+	}
+	return results, nil
+}
+
+// DeleteWorkspace implements driver.WorkspaceManager.
+func (d *DockerDriver) DeleteWorkspace(ctx context.Context, name string) error {
+	info, err := d.GetWorkspace(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.InUse > 0 {
+		return driver.ErrWorkspaceInUse
+	}
+	if err := d.cli.VolumeRemove(ctx, workspaceVolume(name), false); err != nil {
+		return fmt.Errorf("failed to remove workspace volume: %w", err)
+	}
+	return nil
+}
+
+func workspaceInfo(vol volume.Volume, sizes map[string]int64, users map[string]int) *driver.WorkspaceInfo {
+	name := vol.Labels[WorkspaceLabel]
+	created, _ := time.Parse(time.RFC3339, vol.CreatedAt)
+	size, ok := sizes[vol.Name]
+	if !ok {
+		size = -1
+	}
+	return &driver.WorkspaceInfo{Name: name, CreatedAt: created, SizeBytes: size, InUse: users[name], Labels: userLabels(vol.Labels)}
+}
+
+// workspaceUsers counts the containers mounting each workspace.
+func (d *DockerDriver) workspaceUsers(ctx context.Context) (map[string]int, error) {
+	list, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ManagedLabel+"=true"), filters.Arg("label", WorkspaceLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]int)
+	for _, c := range list {
+		users[c.Labels[WorkspaceLabel]]++
+	}
+	return users, nil
+}
+
+// volumeSizes returns the disk usage of local volumes by name. Sizes are
+// best effort: on error the map is empty.
+func (d *DockerDriver) volumeSizes(ctx context.Context) map[string]int64 {
+	sizes := make(map[string]int64)
+	usage, err := d.cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to get volume sizes")
+		return sizes
+	}
+	for _, vol := range usage.Volumes {
+		if vol.UsageData != nil && vol.UsageData.Size >= 0 {
+			sizes[vol.Name] = vol.UsageData.Size
+		}
+	}
+	return sizes
+}
+
+// mountWorkspace creates the copy-on-write view of a workspace for one
+// sandbox: an overlay volume whose lower layer is the workspace's base and
+// whose upper and work directories are two more volumes. It returns the
+// overlay volume and every volume created, to be removed with the sandbox.
+func (d *DockerDriver) mountWorkspace(ctx context.Context, name, key string) (string, []string, error) {
+	base, err := d.cli.VolumeInspect(ctx, workspaceVolume(name))
+	if client.IsErrNotFound(err) {
+		return "", nil, driver.ErrWorkspaceNotFound
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	labels := map[string]string{ManagedLabel: "true", InstanceLabel: d.instance, LayerLabel: key}
+	var created []string
+	dirs := make(map[string]string, 2)
+	for _, part := range []string{"upper", "work"} {
+		vol, err := d.cli.VolumeCreate(ctx, volume.CreateOptions{Name: "boxed-layer-" + key + "-" + part, Labels: labels})
+		if err != nil {
+			d.removeVolumes(created)
+			return "", nil, fmt.Errorf("failed to create workspace layer: %w", err)
+		}
+		created = append(created, vol.Name)
+		dirs[part] = vol.Mountpoint
+	}
+
+	overlay, err := d.cli.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   "boxed-layer-" + key,
+		Driver: "local",
+		DriverOpts: map[string]string{
+			"type":   "overlay",
+			"device": "overlay",
+			"o":      fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", base.Mountpoint, dirs["upper"], dirs["work"]),
+		},
+		Labels: labels,
+	})
+	if err != nil {
+		d.removeVolumes(created)
+		return "", nil, fmt.Errorf("failed to create workspace overlay: %w", err)
+	}
+	// The overlay goes first when removing: it refers to the others
+	return overlay.Name, append([]string{overlay.Name}, created...), nil
+}
+
+// removeVolumes removes volumes in order, logging failures. It runs on a
+// fresh context since it is part of cleaning up.
+func (d *DockerDriver) removeVolumes(names []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, name := range names {
+		if err := d.cli.VolumeRemove(ctx, name, true); err != nil && !client.IsErrNotFound(err) {
+			log.Warn().Err(err).Str("volume", name).Msg("Failed to remove volume")
+		}
+	}
+}
+
+// collectLayers removes the workspace layers of containers that are gone,
+// e.g. removed by an earlier process that did not clean up after them.
+// Like container orphans, only layers older than the driver are touched.
+func (d *DockerDriver) collectLayers(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	containers, err := d.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LayerLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		live[c.Labels[LayerLabel]] = true
+	}
+
+	list, err := d.cli.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LayerLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sizes := d.volumeSizes(ctx)
+
+	var items []driver.GCItem
+	for _, vol := range list.Volumes {
+		created, err := time.Parse(time.RFC3339, vol.CreatedAt)
+		if live[vol.Labels[LayerLabel]] || err != nil || !created.Before(d.startedAt) || !d.owns(vol.Labels) {
+			continue
+		}
+		item := driver.GCItem{Kind: "volume", ID: vol.Name, Reason: driver.GCReasonOrphaned, Bytes: sizes[vol.Name], At: time.Now()}
+		if !dryRun {
+			if err := d.cli.VolumeRemove(ctx, vol.Name, true); err != nil && !client.IsErrNotFound(err) {
+				log.Warn().Str("volume", vol.Name).Err(err).Msg("Failed to remove orphaned layer")
+				item.Error = err.Error()
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// layerVolumes returns the volumes mountWorkspace creates for key, in the
+// order they are removed.
+func layerVolumes(key string) []string {
+	return []string{"boxed-layer-" + key, "boxed-layer-" + key + "-upper", "boxed-layer-" + key + "-work"}
+}
+
+func newLayerKey() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tarFiles packs files into an archive relative to its root. Docker creates
+// missing parent directories while extracting.
+func tarFiles(files []driver.FileInjection) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		data, err := base64.StdEncoding.DecodeString(file.ContentBase64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: file %s is not valid base64", driver.ErrInvalidConfig, file.Path)
+		}
+		name := strings.TrimPrefix(path.Clean("/"+file.Path), "/")
+		if name == "" {
+			return nil, fmt.Errorf("%w: file path is empty", driver.ErrInvalidConfig)
+		}
+		header := &tar.Header{Name: name, Size: int64(len(data)), Mode: 0644, ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
@@ -6,10 +6,15 @@
 package driver
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -35,6 +40,10 @@ var (
 
 	// ErrInvalidConfig indicates the provided configuration is invalid.
 	ErrInvalidConfig = errors.New("invalid sandbox configuration")
+
+	// ErrNotImplemented indicates the backend a request was routed to does
+	// not support the operation.
+	ErrNotImplemented = errors.New("not supported by driver")
 )
 
 // SandboxState represents the current state of a sandbox.
@@ -53,8 +62,18 @@ const (
 	// StateStopped indicates the sandbox has been terminated.
 	StateStopped SandboxState = "stopped"
 
+	// StateHibernated indicates the sandbox's processes were stopped by
+	// Hibernator.Hibernate while its filesystem was kept, until it is
+	// woken.
+	StateHibernated SandboxState = "hibernated"
+
 	// StateError indicates the sandbox encountered an unrecoverable error.
 	StateError SandboxState = "error"
+
+	// StateFailed indicates the sandbox could not be created or started and
+	// its resources were released. It is terminal and reported by the control
+	// plane, never by drivers.
+	StateFailed SandboxState = "failed"
 )
 
 // SandboxConfig defines the specifications for the requested execution environment.
@@ -69,6 +88,11 @@ type SandboxConfig struct {
 	// CPUCores sets the CPU limit as fractional cores (e.g., 1.0 = 1 core, 0.5 = half core)
 	CPUCores float64 `json:"cpu_cores"`
 
+	// CPUBurst lets the sandbox run above CPUCores for short stretches
+	// while holding it to CPUCores on average; see CPUBurst. Drivers
+	// without CPU limits, such as wasm, ignore it.
+	CPUBurst *CPUBurst `json:"cpu_burst,omitempty"`
+
 	// Env contains environment variables to inject into the sandbox
 	Env map[string]string `json:"env,omitempty"`
 
@@ -78,6 +102,11 @@ type SandboxConfig struct {
 	// EnableNetworking allows outbound network access (subject to egress filtering)
 	EnableNetworking bool `json:"enable_networking"`
 
+	// EgressProxy, with EnableNetworking, sends the sandbox's traffic
+	// through a proxy of the server instead of out directly; see
+	// EgressProxy
+	EgressProxy *EgressProxy `json:"egress_proxy,omitempty"`
+
 	// AllowedHosts is the whitelist for outbound connections when networking is enabled
 	// Supports wildcards (e.g., "*.google.com", "pypi.org")
 	AllowedHosts []string `json:"allowed_hosts,omitempty"`
@@ -93,6 +122,258 @@ type SandboxConfig struct {
 
 	// Context contains files to inject at startup
 	Context []FileInjection `json:"context,omitempty"`
+
+	// Sidecars are helper processes (databases, mock servers) started with the
+	// sandbox and stopped with it
+	Sidecars []Sidecar `json:"sidecars,omitempty"`
+
+	// Workspace names a base workspace mounted copy-on-write at WorkDir;
+	// see WorkspaceManager. Context files are written on top of it.
+	Workspace string `json:"workspace,omitempty"`
+
+	// Volumes are persistent volumes mounted into the sandbox; see
+	// VolumeManager
+	Volumes []VolumeMount `json:"volumes,omitempty"`
+
+	// Driver picks the backend of a Router; other drivers ignore it
+	Driver string `json:"driver,omitempty"`
+
+	// User runs code as this user instead of root: a user name, created if
+	// the image lacks it, or "uid[:gid]". WorkDir and /output are given to
+	// the user. See ValidateUser.
+	User string `json:"user,omitempty"`
+
+	// Security hardens the sandbox beyond the driver's defaults. Drivers
+	// without processes of their own, such as wasm, ignore it.
+	Security Security `json:"security,omitzero"`
+
+	// Platform is the "os/arch[/variant]" of the image to run, such as
+	// "linux/amd64". By default it is the host's, or if the image is not
+	// built for it, one the image is built for. Platforms other than the
+	// host's run under emulation. Drivers that do not run images, such as
+	// wasm, ignore it.
+	Platform string `json:"platform,omitempty"`
+
+	// GPU passes GPUs of the host through to the sandbox. Drivers without
+	// GPUs, such as wasm, reject it with ErrInvalidConfig.
+	GPU *GPURequest `json:"gpu,omitempty"`
+
+	// NetworkGroup puts the sandbox on a network shared with the other
+	// sandboxes of the group, such as an app and its database, and with
+	// nothing else. The network is created with the group's first sandbox
+	// and removed with its last. Drivers without networking, such as wasm,
+	// reject it with ErrInvalidConfig.
+	NetworkGroup string `json:"network_group,omitempty"`
+
+	// NetworkAlias is the name the other sandboxes of NetworkGroup reach
+	// the sandbox at, e.g. "db"
+	NetworkAlias string `json:"network_alias,omitempty"`
+
+	// DNS are the IP addresses of the name servers of the sandbox's
+	// resolv.conf, at most 3, in place of the host's. DNSSearch are its
+	// search domains and DNSOptions its options, e.g. "ndots:2".
+	DNS        []string `json:"dns,omitempty"`
+	DNSSearch  []string `json:"dns_search,omitempty"`
+	DNSOptions []string `json:"dns_options,omitempty"`
+
+	// ExtraHosts are added to the sandbox's /etc/hosts, mapping host
+	// names to IP addresses or HostGateway, e.g. "api.internal" to the
+	// address of a mock server. Drivers without networking, such as wasm,
+	// reject them and DNS settings with ErrInvalidConfig.
+	ExtraHosts map[string]string `json:"extra_hosts,omitempty"`
+}
+
+// Security restricts what code in a sandbox can do to its own container and
+// the host kernel. The zero value keeps the driver's defaults.
+type Security struct {
+	// ReadOnlyRootfs mounts the image read-only. The working directory,
+	// /tmp, /output and Tmpfs stay writable.
+	ReadOnlyRootfs bool `json:"read_only_rootfs,omitempty"`
+
+	// Tmpfs are further absolute directories mounted as empty tmpfs, e.g.
+	// "/var/tmp" or "/run" under ReadOnlyRootfs
+	Tmpfs []string `json:"tmpfs,omitempty"`
+
+	// CapDrop and CapAdd are Linux capabilities removed from and added to
+	// the driver's default set, without the CAP_ prefix; "ALL" drops them
+	// all. See Capabilities for those CapAdd accepts.
+	CapDrop []string `json:"cap_drop,omitempty"`
+	CapAdd  []string `json:"cap_add,omitempty"`
+
+	// NoNewPrivileges stops processes from gaining privileges through
+	// setuid binaries or file capabilities
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+
+	// SeccompProfile is the syscall filter of the sandbox: one of the
+	// built-in profiles SeccompDefault, SeccompStrict and
+	// SeccompPermissive, or the absolute path, on the driver's host, of a
+	// JSON seccomp profile. Empty keeps the runtime's default.
+	SeccompProfile string `json:"seccomp_profile,omitempty"`
+
+	// PidsLimit caps the number of processes and threads, 0 for no limit
+	PidsLimit int64 `json:"pids_limit,omitempty"`
+}
+
+// Built-in seccomp profiles of Security.SeccompProfile.
+const (
+	// SeccompDefault is the runtime's default profile
+	SeccompDefault = "default"
+
+	// SeccompStrict is the default profile without networking: sockets
+	// cannot be created, nor can io_uring or ptrace be used. It suits
+	// templates that only compute.
+	SeccompStrict = "strict"
+
+	// SeccompPermissive filters no syscalls
+	SeccompPermissive = "permissive"
+)
+
+// IsSeccompBuiltin reports whether name is a built-in seccomp profile.
+func IsSeccompBuiltin(name string) bool {
+	return name == SeccompDefault || name == SeccompStrict || name == SeccompPermissive
+}
+
+// SeccompName returns the name of the seccomp profile of s, as reported in
+// SandboxInfo.Seccomp: the built-in profile, or the file name of a custom
+// one without its .json extension.
+func (s Security) SeccompName() string {
+	switch {
+	case s.SeccompProfile == "":
+		return SeccompDefault
+	case IsSeccompBuiltin(s.SeccompProfile):
+		return s.SeccompProfile
+	}
+	return strings.TrimSuffix(path.Base(s.SeccompProfile), ".json")
+}
+
+// Capabilities are the capabilities Docker grants containers by default,
+// the only ones Security.CapAdd may add back after dropping them.
+var Capabilities = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL",
+	"MKNOD", "NET_BIND_SERVICE", "NET_RAW", "SETFCAP", "SETGID", "SETPCAP",
+	"SETUID", "SYS_CHROOT",
+}
+
+// HardenedSecurity returns the settings recommended for running untrusted
+// code: a read-only image with tmpfs scratch space, no capabilities, no
+// privilege escalation and at most 256 processes.
+func HardenedSecurity() Security {
+	return Security{
+		ReadOnlyRootfs:  true,
+		Tmpfs:           []string{"/var/tmp", "/run"},
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		PidsLimit:       256,
+	}
+}
+
+// IsZero reports whether s keeps the driver's defaults.
+func (s Security) IsZero() bool {
+	return !s.ReadOnlyRootfs && len(s.Tmpfs) == 0 && len(s.CapDrop) == 0 && len(s.CapAdd) == 0 &&
+		!s.NoNewPrivileges && s.SeccompProfile == "" && s.PidsLimit == 0
+}
+
+// Validate checks the capabilities and paths of s.
+func (s Security) Validate() error {
+	for _, dir := range s.Tmpfs {
+		if !path.IsAbs(dir) || path.Clean(dir) != dir || dir == "/" {
+			return fmt.Errorf("%w: tmpfs path %q must be an absolute directory other than /", ErrInvalidConfig, dir)
+		}
+	}
+	for _, c := range s.CapDrop {
+		if c != "ALL" && !validCapability.MatchString(c) {
+			return fmt.Errorf("%w: invalid capability %q", ErrInvalidConfig, c)
+		}
+	}
+	for _, c := range s.CapAdd {
+		if !slices.Contains(Capabilities, c) {
+			return fmt.Errorf("%w: capability %q cannot be added; allowed: %s", ErrInvalidConfig, c, strings.Join(Capabilities, ", "))
+		}
+	}
+	if p := s.SeccompProfile; p != "" && !IsSeccompBuiltin(p) && !path.IsAbs(p) {
+		return fmt.Errorf("%w: seccomp profile %q is neither %s, %s, %s nor an absolute path",
+			ErrInvalidConfig, p, SeccompDefault, SeccompStrict, SeccompPermissive)
+	}
+	if s.PidsLimit < 0 {
+		return fmt.Errorf("%w: pids_limit cannot be negative", ErrInvalidConfig)
+	}
+	return nil
+}
+
+var validCapability = regexp.MustCompile(`^[A-Z][A-Z_]{1,31}$`)
+
+// DefaultCPUBurstPeriodMS is the period of a CPUBurst that sets none.
+const DefaultCPUBurstPeriodMS = 1000
+
+// CPUBurst trades a sandbox's steady CPU for responsiveness. The kernel
+// enforces CPUCores over periods of 100 ms by default, throttling any
+// command that needs more than CPUCores × 100 ms of CPU, however short. A
+// burst lengthens the period: in each, the sandbox runs as fast as its
+// threads go until it used CPUCores × PeriodMS of CPU time, and is paused
+// for the rest of it. A REPL's short commands then finish unthrottled,
+// while a long loop still averages CPUCores.
+type CPUBurst struct {
+	// PeriodMS is the period, 1 to 1000 ms, over which CPUCores is
+	// enforced; DefaultCPUBurstPeriodMS if zero
+	PeriodMS int64 `json:"period_ms,omitempty" yaml:"period_ms"`
+
+	// Shares weighs the sandbox against the others when they contend for
+	// the host's CPUs, 2 to 262144; sandboxes weigh 1024 by default. A low
+	// weight lets bursts of busier sandboxes go first.
+	Shares int64 `json:"shares,omitempty" yaml:"shares"`
+}
+
+// Period returns the period of b.
+func (b CPUBurst) Period() time.Duration {
+	return time.Duration(cmp.Or(b.PeriodMS, DefaultCPUBurstPeriodMS)) * time.Millisecond
+}
+
+// Validate checks b for a sandbox limited to cores.
+func (b CPUBurst) Validate(cores float64) error {
+	switch {
+	case b.PeriodMS < 0 || b.PeriodMS > 1000:
+		return fmt.Errorf("%w: cpu_burst period_ms must be between 1 and 1000", ErrInvalidConfig)
+	case b.Shares != 0 && (b.Shares < 2 || b.Shares > 262144):
+		return fmt.Errorf("%w: cpu_burst shares must be between 2 and 262144", ErrInvalidConfig)
+	case cores*float64(b.Period().Milliseconds()) < 1:
+		// The kernel's smallest quota
+		return fmt.Errorf("%w: cpu_burst period_ms is too short for %g cores: they must get at least 1 ms of it", ErrInvalidConfig, cores)
+	}
+	return nil
+}
+
+// Sidecar is a long-running process that runs next to user code inside the
+// sandbox, e.g. a local Postgres for tests against agent-generated code.
+type Sidecar struct {
+	// Name identifies the sidecar in SandboxInfo
+	Name string `json:"name"`
+
+	// Cmd is the command line to run (e.g., ["redis-server", "--port", "6379"])
+	Cmd []string `json:"cmd"`
+
+	// Env contains additional environment variables for the sidecar
+	Env map[string]string `json:"env,omitempty"`
+
+	// HealthCheck, if set, must succeed before the sandbox is reported ready
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+}
+
+// HealthCheck is a command that exits 0 once a sidecar is ready.
+type HealthCheck struct {
+	Cmd []string `json:"cmd"`
+
+	// IntervalMS is the delay between attempts (default: 500)
+	IntervalMS int `json:"interval_ms,omitempty"`
+
+	// Retries is the number of attempts before giving up (default: 20)
+	Retries int `json:"retries,omitempty"`
+}
+
+// SidecarStatus reports the runtime state of a sidecar.
+type SidecarStatus struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"`
+	ExitCode *int   `json:"exit_code,omitempty"`
 }
 
 // NetworkPolicy defines network access rules
@@ -168,6 +449,9 @@ type Driver interface {
 	// For Docker: This attaches to a container exec stream over TCP.
 	// For Firecracker: This connects via vsock.
 	//
+	// Drivers that start an agent per connection do so through
+	// AgentHealth.Connect, which restarts agents that fail.
+	//
 	// The caller is responsible for closing the connection when done.
 	//
 	// Returns ErrSandboxNotRunning if the sandbox is not in StateReady.
@@ -185,6 +469,9 @@ type Driver interface {
 
 	// GetFile downloads a file from the sandbox.
 	// Returns a reader for the raw file data. Caller must Close it.
+	// Readers that know the file's size and modification time should
+	// implement Stat() (fs.FileInfo, error) as *os.File does; the API then
+	// sends Content-Length and serves byte ranges.
 	GetFile(ctx context.Context, id, path string) (io.ReadCloser, error)
 
 	// Info returns runtime information about a sandbox.
@@ -227,32 +514,150 @@ func (c *SandboxConfig) Validate() error {
 	if c.WorkDir == "" {
 		c.WorkDir = "/workspace"
 	}
+	if c.Workspace != "" {
+		if err := ValidateWorkspaceName(c.Workspace); err != nil {
+			return err
+		}
+	}
+	if err := c.validateVolumes(); err != nil {
+		return err
+	}
+	if c.User != "" {
+		if err := ValidateUser(c.User); err != nil {
+			return err
+		}
+		// Setting up the user writes /etc/passwd
+		if c.Security.ReadOnlyRootfs {
+			return fmt.Errorf("%w: a user cannot be set up on a read-only root filesystem", ErrInvalidConfig)
+		}
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	if c.Platform != "" {
+		if err := ValidatePlatform(c.Platform); err != nil {
+			return err
+		}
+	}
+
+	if c.GPU != nil {
+		if err := c.GPU.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateNetworkGroup(); err != nil {
+		return err
+	}
+	if err := c.validateDNS(); err != nil {
+		return err
+	}
 
 	// Validate constraints
-	if c.MemoryMB > 8192 {
+	if c.MemoryMB > MaxMemoryMB {
 		return fmt.Errorf("%w: memory cannot exceed 8GB", ErrInvalidConfig)
 	}
-	if c.CPUCores > 4.0 {
+	if c.CPUCores > MaxCPUCores {
 		return fmt.Errorf("%w: CPU cannot exceed 4 cores", ErrInvalidConfig)
 	}
-	if c.Timeout > 30*time.Minute {
-		return fmt.Errorf("%w: timeout cannot exceed 30 minutes", ErrInvalidConfig)
+	if c.CPUBurst != nil {
+		if err := c.CPUBurst.Validate(c.CPUCores); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Sidecars {
+		sc := &c.Sidecars[i]
+		if sc.Name == "" || len(sc.Cmd) == 0 {
+			return fmt.Errorf("%w: sidecars need a name and a command", ErrInvalidConfig)
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("%w: duplicate sidecar name %q", ErrInvalidConfig, sc.Name)
+		}
+		names[sc.Name] = true
+
+		if hc := sc.HealthCheck; hc != nil {
+			if len(hc.Cmd) == 0 {
+				return fmt.Errorf("%w: health check for sidecar %q has no command", ErrInvalidConfig, sc.Name)
+			}
+			if hc.IntervalMS <= 0 {
+				hc.IntervalMS = 500
+			}
+			if hc.Retries <= 0 {
+				hc.Retries = 20
+			}
+		}
 	}
 
 	return nil
 }
 
+var userSpec = regexp.MustCompile(`^([a-z_][a-z0-9_-]{0,31}|[0-9]{1,10}(:[0-9]{1,10})?)$`)
+
+// ValidateUser checks a user to run code as: a user name (lowercase
+// letters, digits, '_' and '-', at most 32 characters) or a numeric uid with
+// an optional gid, such as "1000:1000".
+func ValidateUser(user string) error {
+	if !userSpec.MatchString(user) {
+		return fmt.Errorf("%w: invalid user %q", ErrInvalidConfig, user)
+	}
+	return nil
+}
+
+var platformSpec = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// ValidatePlatform checks an image platform: "os/arch" with an optional
+// variant, such as "linux/amd64" or "linux/arm/v7".
+func ValidatePlatform(platform string) error {
+	if !platformSpec.MatchString(platform) {
+		return fmt.Errorf("%w: invalid platform %q: want os/arch[/variant], e.g. linux/amd64", ErrInvalidConfig, platform)
+	}
+	return nil
+}
+
+// Reasons a sandbox, or the process of an exec, ended abnormally.
+const (
+	// ExitOOMKilled means the kernel killed it for exceeding its memory
+	// limit
+	ExitOOMKilled = "oom_killed"
+
+	// ExitSandboxDied means the sandbox stopped on its own, taking any
+	// running exec with it
+	ExitSandboxDied = "sandbox_died"
+
+	// ExitSignaled means the process was killed by a signal
+	ExitSignaled = "signaled"
+
+	// ExitCanceled means the process was killed because nobody waited for
+	// it any more, e.g. its client disconnected
+	ExitCanceled = "canceled"
+
+	// ExitAgentCrashed means the agent running the process exited or
+	// stopped responding while the sandbox lived on
+	ExitAgentCrashed = "agent_crashed"
+)
+
 // SandboxInfo contains runtime information about a sandbox.
 type SandboxInfo struct {
 	// ID is the unique identifier for this sandbox
 	ID string `json:"id"`
 
+	// BackendID is the backend's own ID for the sandbox, if the control
+	// plane assigned ID differently
+	BackendID string `json:"backend_id,omitempty"`
+
 	// State is the current lifecycle state
 	State SandboxState `json:"state"`
 
 	// CreatedAt is when the sandbox was created
 	CreatedAt time.Time `json:"created_at"`
 
+	// Phase is the step of its lifecycle the sandbox is in, and Phases
+	// when it entered each since the create began. They are filled in by
+	// the control plane, which drives the lifecycle.
+	Phase  SandboxPhase      `json:"phase,omitempty"`
+	Phases []PhaseTransition `json:"phases,omitempty"`
+
 	// Config is the original configuration used to create the sandbox
 	Config SandboxConfig `json:"config"`
 
@@ -264,6 +669,37 @@ type SandboxInfo struct {
 
 	// Error contains the last error message if State is StateError
 	Error string `json:"error,omitempty"`
+
+	// ExitReason is ExitOOMKilled or ExitSandboxDied when the sandbox
+	// stopped on its own
+	ExitReason string `json:"exit_reason,omitempty"`
+
+	// Sidecars reports the state of each sidecar process
+	Sidecars []SidecarStatus `json:"sidecars,omitempty"`
+
+	// ExpiresAt is when the sandbox TTL removes it. It is filled in by the
+	// control plane, which tracks changes made after creation.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Platform is the "os/arch[/variant]" of the sandbox's image, and
+	// Emulated is set when it differs from the host's, so that its code
+	// runs under emulation. Drivers that do not run images leave them
+	// unset.
+	Platform string `json:"platform,omitempty"`
+	Emulated bool   `json:"emulated,omitempty"`
+
+	// AgentCrashes counts the agents Connect started in the sandbox that
+	// exited or stopped responding; see AgentHealth
+	AgentCrashes int `json:"agent_crashes,omitempty"`
+
+	// GPUs are the GPUs passed through to the sandbox; see
+	// SandboxConfig.GPU
+	GPUs []GPU `json:"gpus,omitempty"`
+
+	// Seccomp names the seccomp profile the sandbox runs under; see
+	// Security.SeccompName. Drivers that do not filter syscalls leave it
+	// unset.
+	Seccomp string `json:"seccomp,omitempty"`
 }
 
 // PooledDriver extends Driver with warm pool capabilities for sub-second startup.
@@ -282,6 +718,16 @@ type PooledDriver interface {
 	PoolStatus(ctx context.Context) (*PoolStats, error)
 }
 
+// Router is implemented by drivers that dispatch each sandbox to one of
+// several backends, picked by SandboxConfig.Driver or a routing policy.
+type Router interface {
+	Driver
+
+	// Backends returns the names SandboxConfig.Driver accepts, the default
+	// first.
+	Backends() []string
+}
+
 // PoolStats contains statistics about the warm pool.
 type PoolStats struct {
 	// Available is the number of pre-warmed sandboxes ready to be claimed
@@ -297,6 +743,185 @@ type PoolStats struct {
 	Target int `json:"target"`
 }
 
+// ImageManager is implemented by drivers that keep a local image cache.
+// It lets the control plane warm images before the first create needs them.
+type ImageManager interface {
+	// PullImage fetches an image into the local cache. progress, if non-nil,
+	// is called as the pull advances.
+	PullImage(ctx context.Context, ref string, progress func(PullProgress)) error
+
+	// ListImages returns the images available locally.
+	ListImages(ctx context.Context) ([]*ImageInfo, error)
+}
+
+// Snapshotter is implemented by drivers that can save the filesystem of a
+// running sandbox as an image new sandboxes can be created from.
+type Snapshotter interface {
+	// Snapshot saves the filesystem of a sandbox as the image ref,
+	// replacing any image of that name, with labels set on the image.
+	// Mounts, such as the working directory of a workspace, /tmp and
+	// /output, are not saved.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist.
+	Snapshot(ctx context.Context, id, ref string, labels map[string]string) error
+
+	// HasImage reports whether the image ref is available locally.
+	HasImage(ctx context.Context, ref string) (bool, error)
+}
+
+// FileUpload is a file written by FilesPutter.
+type FileUpload struct {
+	// Path is where the file is written in the sandbox
+	Path string
+
+	// Size is the length of the content Open returns
+	Size int64
+
+	// Open returns the content. It is called once, when the file is
+	// written, so that files are not all open at the same time.
+	Open func() (io.ReadCloser, error)
+}
+
+// FilesPutter is implemented by drivers that write several files into a
+// sandbox in one transfer, which is faster than a PutFile for each.
+type FilesPutter interface {
+	// PutFiles writes files into a sandbox like PutFile does, in order.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist.
+	PutFiles(ctx context.Context, id string, files []FileUpload) error
+}
+
+// Adopter is implemented by drivers that can take over the sandboxes of
+// another control-plane instance sharing their backend, e.g. after that
+// instance died.
+type Adopter interface {
+	// Adopt tracks the running sandboxes of instance as if this driver had
+	// created them: they are listed, stopped on expiry and garbage
+	// collected from then on. It returns how many were adopted.
+	Adopt(ctx context.Context, instance string) (int, error)
+}
+
+// The largest memory and CPU limits a sandbox can have.
+const (
+	MaxMemoryMB = 8192
+	MaxCPUCores = 4.0
+)
+
+// ResourceUpdate changes the limits of a running sandbox. Fields left zero
+// keep their limit.
+type ResourceUpdate struct {
+	// MemoryMB is the new memory limit in megabytes
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+
+	// CPUCores is the new CPU limit in fractional cores
+	CPUCores float64 `json:"cpu_cores,omitempty"`
+}
+
+// Validate checks that u changes a limit, within those of SandboxConfig.
+func (u ResourceUpdate) Validate() error {
+	switch {
+	case u.MemoryMB == 0 && u.CPUCores == 0:
+		return fmt.Errorf("%w: memory_mb or cpu_cores is required", ErrInvalidConfig)
+	case u.MemoryMB < 0 || u.CPUCores < 0:
+		return fmt.Errorf("%w: limits must be positive", ErrInvalidConfig)
+	case u.MemoryMB > MaxMemoryMB:
+		return fmt.Errorf("%w: memory cannot exceed 8GB", ErrInvalidConfig)
+	case u.CPUCores > MaxCPUCores:
+		return fmt.Errorf("%w: CPU cannot exceed 4 cores", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// ResourceUpdater is implemented by drivers that can change the limits of
+// a sandbox while it runs.
+type ResourceUpdater interface {
+	// UpdateResources applies u to a running sandbox; its Info reports the
+	// new limits in Config.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist and
+	// ErrSandboxNotRunning if it is stopped.
+	UpdateResources(ctx context.Context, id string, u ResourceUpdate) error
+}
+
+// Kinds of FSChange.
+const (
+	FSAdded   = "added"
+	FSChanged = "changed"
+	FSRemoved = "removed"
+)
+
+// FSChange is a path of a sandbox's filesystem that was added, changed or
+// removed since the sandbox was created from its image.
+type FSChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// FSDiffer is implemented by drivers that track the changes sandboxes make
+// to their filesystem, as docker diff does.
+type FSDiffer interface {
+	// FSDiff returns the changes to the filesystem of a sandbox, sorted by
+	// path. A directory holding changed paths is changed itself; a removed
+	// directory is reported without its contents. /tmp, /output, volumes
+	// and workspaces are not tracked.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist.
+	FSDiff(ctx context.Context, id string) ([]FSChange, error)
+}
+
+// ExpiryController is implemented by drivers whose sandbox TTL can be changed
+// after creation.
+type ExpiryController interface {
+	// SetExpiry reschedules the TTL removal of a sandbox to at. A time in the
+	// past removes it right away.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist.
+	SetExpiry(ctx context.Context, id string, at time.Time) error
+}
+
+// Hibernator is implemented by drivers that can stop a sandbox without
+// removing it, so that it can be started again later with its files.
+type Hibernator interface {
+	// Hibernate stops the processes of a running sandbox and cancels its
+	// TTL, keeping its ID, filesystem and reserved devices; Info reports
+	// StateHibernated until Wake or Stop.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist and
+	// ErrSandboxNotRunning if it is not running.
+	Hibernate(ctx context.Context, id string) error
+
+	// Wake starts a hibernated sandbox again, as Start does, and arms its
+	// TTL to remove it after ttl. A sandbox that fails to wake stays
+	// hibernated.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist,
+	// ErrSandboxAlreadyRunning if it is running and ErrSandboxNotRunning if
+	// it stopped without being hibernated.
+	Wake(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// PullProgress is a snapshot of an in-flight image pull.
+type PullProgress struct {
+	// Status is the latest status line reported by the backend
+	Status string `json:"status"`
+
+	// Layers is the number of layers seen so far; LayersDone of them are complete
+	Layers     int `json:"layers"`
+	LayersDone int `json:"layers_done"`
+
+	// CurrentBytes and TotalBytes aggregate download progress across layers
+	CurrentBytes int64 `json:"current_bytes"`
+	TotalBytes   int64 `json:"total_bytes"`
+}
+
+// ImageInfo describes an image in the local cache.
+type ImageInfo struct {
+	ID        string    `json:"id"`
+	Tags      []string  `json:"tags"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // DriverFactory creates Driver instances based on configuration.
 // This enables runtime selection of the backend (e.g., based on environment).
 type DriverFactory func(cfg map[string]any) (Driver, error)
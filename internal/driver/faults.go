@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseFaults parses the failures a driver injects for tests: a
+// comma-separated list of fault[=delay] entries, such as
+// "stop_during_exec=2s,slow_pull". defaults holds the known faults and the
+// delay of those given without one.
+func ParseFaults(s string, defaults map[string]time.Duration) (map[string]time.Duration, error) {
+	f := map[string]time.Duration{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, delay, hasDelay := strings.Cut(entry, "=")
+		def, ok := defaults[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown fault %q", name)
+		}
+		if !hasDelay {
+			f[name] = def
+			continue
+		}
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid delay for fault %s: %q", name, delay)
+		}
+		f[name] = d
+	}
+	return f, nil
+}
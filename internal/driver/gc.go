@@ -0,0 +1,117 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GarbageCollector is implemented by drivers that can find and remove
+// resources no sandbox owns anymore, such as containers left behind by a
+// previous server process.
+type GarbageCollector interface {
+	// CollectGarbage removes leftover resources and reports each of them.
+	// With dryRun set nothing is removed.
+	CollectGarbage(ctx context.Context, dryRun bool) ([]GCItem, error)
+
+	// SetReapHook registers fn to be told about resources the driver removes
+	// on its own, e.g. sandboxes whose TTL expired.
+	SetReapHook(fn func(GCItem))
+}
+
+// Reasons for garbage collecting a resource.
+const (
+	// GCReasonOrphaned marks resources no sandbox of this server owns
+	GCReasonOrphaned = "orphaned"
+
+	// GCReasonExpired marks sandboxes removed when their timeout elapsed
+	GCReasonExpired = "ttl_expired"
+
+	// GCReasonExited marks containers no sandbox owns that stopped running
+	GCReasonExited = "exited"
+)
+
+// Orphan policies: what a driver does at startup with the sandboxes an
+// earlier process of the same server left running.
+const (
+	// OrphanDelete removes them
+	OrphanDelete = "delete"
+
+	// OrphanAdopt tracks them again, with the expiry they were created with
+	OrphanAdopt = "adopt"
+
+	// OrphanKeep leaves them alone until they expire
+	OrphanKeep = "keep"
+)
+
+// ValidateOrphanPolicy checks that policy is one of the Orphan constants.
+func ValidateOrphanPolicy(policy string) error {
+	switch policy {
+	case OrphanDelete, OrphanAdopt, OrphanKeep:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown orphan policy %q (want %s, %s or %s)",
+		ErrInvalidConfig, policy, OrphanDelete, OrphanAdopt, OrphanKeep)
+}
+
+// GCItem describes a resource removed by garbage collection or, on a dry
+// run, one that would be.
+type GCItem struct {
+	// Kind is the type of resource, e.g. "container" or "sandbox"
+	Kind string `json:"kind"`
+
+	// ID identifies the resource (container ID, sandbox ID, directory)
+	ID string `json:"id"`
+
+	// Reason is one of the GCReason constants
+	Reason string `json:"reason"`
+
+	// Bytes is the disk space reclaimed, when known
+	Bytes int64 `json:"bytes"`
+
+	// At is when the resource was removed (or inspected, on a dry run)
+	At time.Time `json:"at"`
+
+	// Error is set if removing the resource failed
+	Error string `json:"error,omitempty"`
+}
+
+// maxPendingReaps bounds the items a ReapNotifier keeps until a hook is set.
+const maxPendingReaps = 1000
+
+// ReapNotifier implements SetReapHook for drivers. Items reported before a
+// hook is registered (e.g. by a cleanup started in the driver constructor)
+// are kept and delivered once it is.
+type ReapNotifier struct {
+	mu      sync.Mutex
+	hook    func(GCItem)
+	pending []GCItem
+}
+
+// SetReapHook implements GarbageCollector.
+func (n *ReapNotifier) SetReapHook(fn func(GCItem)) {
+	n.mu.Lock()
+	n.hook = fn
+	pending := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+
+	for _, item := range pending {
+		fn(item)
+	}
+}
+
+// Reaped reports a resource the driver removed.
+func (n *ReapNotifier) Reaped(item GCItem) {
+	n.mu.Lock()
+	hook := n.hook
+	if hook == nil && len(n.pending) < maxPendingReaps {
+		n.pending = append(n.pending, item)
+	}
+	n.mu.Unlock()
+
+	if hook != nil {
+		hook(item)
+	}
+}
@@ -0,0 +1,126 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// GPURequest asks for GPUs of the host to be passed through to a sandbox,
+// each to that sandbox alone.
+type GPURequest struct {
+	// Count is how many GPUs the sandbox gets, at least 1
+	Count int `json:"count"`
+
+	// Type restricts them to a model, such as "a100": it must be part of
+	// their model name, ignoring case. Empty allows any.
+	Type string `json:"type,omitempty"`
+}
+
+// GPU is a GPU of the host.
+type GPU struct {
+	// ID is the device index or UUID the runtime knows it by
+	ID string `json:"id"`
+
+	// Type is its model name, e.g. "NVIDIA A100-SXM4-40GB"
+	Type string `json:"type,omitempty"`
+}
+
+func (r *GPURequest) validate() error {
+	if r.Count < 1 {
+		return fmt.Errorf("%w: gpu count must be at least 1", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// ParseGPUs parses a list of the host's GPUs, "id[:type],...", e.g.
+// "0:a100,1:a100,2:t4".
+func ParseGPUs(s string) ([]GPU, error) {
+	var gpus []GPU
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, typ, _ := strings.Cut(entry, ":")
+		if id == "" || seen[id] {
+			return nil, fmt.Errorf("%w: invalid GPU %q; want id[:type], each id once", ErrInvalidConfig, entry)
+		}
+		seen[id] = true
+		gpus = append(gpus, GPU{ID: id, Type: typ})
+	}
+	return gpus, nil
+}
+
+// GPUPool hands the GPUs of a host out to sandboxes, so that no GPU is
+// given to two at once.
+type GPUPool struct {
+	gpus []GPU
+
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+// NewGPUPool returns a pool of gpus, none of them held.
+func NewGPUPool(gpus []GPU) *GPUPool {
+	return &GPUPool{gpus: gpus, held: make(map[string]bool)}
+}
+
+// GPUs returns the GPUs of the pool.
+func (p *GPUPool) GPUs() []GPU {
+	return p.gpus
+}
+
+// Allocate holds GPUs matching req until they are released. It returns
+// ErrInvalidConfig if the host does not have that many and
+// ErrResourceExhausted if too many of them are held.
+func (p *GPUPool) Allocate(req GPURequest) ([]GPU, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var matching, free []GPU
+	for _, g := range p.gpus {
+		if req.Type != "" && !strings.Contains(strings.ToLower(g.Type), strings.ToLower(req.Type)) {
+			continue
+		}
+		matching = append(matching, g)
+		if !p.held[g.ID] {
+			free = append(free, g)
+		}
+	}
+
+	kind := "GPUs"
+	if req.Type != "" {
+		kind = req.Type + " GPUs"
+	}
+	if len(matching) < req.Count {
+		return nil, fmt.Errorf("%w: %d %s requested, the host has %d", ErrInvalidConfig, req.Count, kind, len(matching))
+	}
+	if len(free) < req.Count {
+		return nil, fmt.Errorf("%w: %d %s requested, %d of the host's %d are free", ErrResourceExhausted, req.Count, kind, len(free), len(matching))
+	}
+	free = free[:req.Count]
+	for _, g := range free {
+		p.held[g.ID] = true
+	}
+	return free, nil
+}
+
+// Hold marks GPUs as held, such as those of sandboxes a driver takes over.
+func (p *GPUPool) Hold(gpus []GPU) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range gpus {
+		p.held[g.ID] = true
+	}
+}
+
+// Release frees GPUs that Allocate or Hold held.
+func (p *GPUPool) Release(gpus []GPU) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, g := range gpus {
+		delete(p.held, g.ID)
+	}
+}
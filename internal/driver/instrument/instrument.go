@@ -0,0 +1,390 @@
+// Package instrument times the calls a driver serves, exporting them as
+// Prometheus metrics and logging those slower than a threshold per
+// operation, so that a slow backend, such as a Docker daemon under load,
+// can be told apart from time spent in the control plane.
+//
+// Operations are named after the driver methods in snake case: create,
+// start, stop, connect, list_files, put_file, get_file, info, list,
+// healthy, and for the optional interfaces describe, set_expiry,
+// update_resources, hibernate, wake, fs_diff, adopt, snapshot, has_image,
+// put_files, logs, stats and collect_garbage. Calls that return a stream,
+// such as connect, get_file and logs, are timed until the stream is open.
+package instrument
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/metrics"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	operationsTotal = metrics.Default.Counter("boxed_driver_operations_total",
+		"Driver calls, by driver, operation and result (ok or error).", "driver", "op", "result")
+	operationSeconds = metrics.Default.Histogram("boxed_driver_operation_duration_seconds",
+		"Time driver calls took, by driver and operation.", buckets, "driver", "op")
+	slowTotal = metrics.Default.Counter("boxed_driver_slow_operations_total",
+		"Driver calls slower than the threshold of their operation.", "driver", "op")
+)
+
+// buckets reach to a minute, as creates that pull an image take that long.
+var buckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Ops are the operations that are timed.
+var Ops = []string{
+	"create", "start", "stop", "connect", "list_files", "put_file", "get_file",
+	"info", "list", "healthy", "describe", "set_expiry", "update_resources",
+	"hibernate", "wake", "fs_diff", "adopt", "snapshot", "has_image",
+	"put_files", "logs", "stats", "collect_garbage",
+}
+
+// DefaultSlow is the threshold of operations DefaultThresholds leaves out.
+const DefaultSlow = time.Second
+
+// DefaultThresholds are the thresholds of the operations expected to take
+// longer than DefaultSlow.
+var DefaultThresholds = map[string]time.Duration{
+	"create":          3 * time.Second,
+	"start":           3 * time.Second,
+	"stop":            10 * time.Second,
+	"connect":         3 * time.Second,
+	"hibernate":       30 * time.Second,
+	"wake":            10 * time.Second,
+	"adopt":           10 * time.Second,
+	"snapshot":        30 * time.Second,
+	"collect_garbage": 30 * time.Second,
+}
+
+// Driver wraps a driver, timing its calls.
+type Driver struct {
+	backend driver.Driver
+	name    string
+	slow    map[string]time.Duration
+}
+
+// Wrap returns d with its calls timed. slow overrides the thresholds of
+// DefaultThresholds for the operations it names; a threshold of 0 turns
+// the warning off.
+func Wrap(d driver.Driver, slow map[string]time.Duration) *Driver {
+	thresholds := make(map[string]time.Duration, len(Ops))
+	for _, op := range Ops {
+		thresholds[op] = DefaultSlow
+	}
+	for op, t := range DefaultThresholds {
+		thresholds[op] = t
+	}
+	for op, t := range slow {
+		thresholds[op] = t
+	}
+	return &Driver{backend: d, name: d.DriverName(), slow: thresholds}
+}
+
+// call is a timed call of an operation.
+type call struct {
+	d       *Driver
+	op      string
+	started time.Time
+}
+
+func (d *Driver) begin(op string) call {
+	return call{d: d, op: op, started: time.Now()}
+}
+
+// end records the call, returning the event to log it with if it was
+// slow, or nil. Calls the backend does not implement are not recorded.
+func (c call) end(err error) *zerolog.Event {
+	if errors.Is(err, driver.ErrNotImplemented) {
+		return nil
+	}
+	took := time.Since(c.started)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	operationsTotal.Inc(c.d.name, c.op, result)
+	operationSeconds.Observe(took.Seconds(), c.d.name, c.op)
+
+	threshold := c.d.slow[c.op]
+	if threshold <= 0 || took < threshold {
+		return nil
+	}
+	slowTotal.Inc(c.d.name, c.op)
+	ev := log.Warn().Str("driver", c.d.name).Str("op", c.op).
+		Dur("duration", took).Dur("threshold", threshold)
+	if err != nil {
+		ev = ev.Err(err)
+	}
+	return ev
+}
+
+// done ends a call on sandbox id, logging it if it was slow.
+func (c call) done(id string, err error) {
+	if ev := c.end(err); ev != nil {
+		if id != "" {
+			ev = ev.Str("id", id)
+		}
+		ev.Msg("Slow driver operation")
+	}
+}
+
+func (d *Driver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	c := d.begin("create")
+	ctx, pulled := driver.WatchPulls(ctx)
+	id, err := d.backend.Create(ctx, cfg)
+	if ev := c.end(err); ev != nil {
+		ev.Str("id", id).Str("image", cfg.Image).Bool("pulled", pulled()).Msg("Slow driver operation")
+	}
+	return id, err
+}
+
+func (d *Driver) Start(ctx context.Context, id string) error {
+	c := d.begin("start")
+	err := d.backend.Start(ctx, id)
+	c.done(id, err)
+	return err
+}
+
+func (d *Driver) Stop(ctx context.Context, id string) error {
+	c := d.begin("stop")
+	err := d.backend.Stop(ctx, id)
+	c.done(id, err)
+	return err
+}
+
+func (d *Driver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	c := d.begin("connect")
+	conn, err := d.backend.Connect(ctx, id)
+	c.done(id, err)
+	return conn, err
+}
+
+func (d *Driver) ListFiles(ctx context.Context, id, dir string) ([]*driver.FileEntry, error) {
+	c := d.begin("list_files")
+	entries, err := d.backend.ListFiles(ctx, id, dir)
+	c.done(id, err)
+	return entries, err
+}
+
+func (d *Driver) PutFile(ctx context.Context, id, dest string, content io.Reader) error {
+	c := d.begin("put_file")
+	err := d.backend.PutFile(ctx, id, dest, content)
+	c.done(id, err)
+	return err
+}
+
+func (d *Driver) GetFile(ctx context.Context, id, src string) (io.ReadCloser, error) {
+	c := d.begin("get_file")
+	r, err := d.backend.GetFile(ctx, id, src)
+	c.done(id, err)
+	return r, err
+}
+
+func (d *Driver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	c := d.begin("info")
+	info, err := d.backend.Info(ctx, id)
+	c.done(id, err)
+	return info, err
+}
+
+func (d *Driver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
+	c := d.begin("list")
+	list, err := d.backend.List(ctx, states)
+	c.done("", err)
+	return list, err
+}
+
+func (d *Driver) DriverName() string {
+	return d.name
+}
+
+func (d *Driver) Healthy(ctx context.Context) error {
+	c := d.begin("healthy")
+	err := d.backend.Healthy(ctx)
+	c.done("", err)
+	return err
+}
+
+func (d *Driver) Close() error {
+	return d.backend.Close()
+}
+
+// Describe implements driver.Describer. Backends that cannot probe report
+// nothing, as if the driver had no Describer.
+func (d *Driver) Describe(ctx context.Context, id string) (*driver.Environment, error) {
+	ds, ok := d.backend.(driver.Describer)
+	if !ok {
+		return &driver.Environment{}, nil
+	}
+	c := d.begin("describe")
+	env, err := ds.Describe(ctx, id)
+	c.done(id, err)
+	return env, err
+}
+
+// SetExpiry implements driver.ExpiryController.
+func (d *Driver) SetExpiry(ctx context.Context, id string, at time.Time) error {
+	ec, ok := d.backend.(driver.ExpiryController)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("set_expiry")
+	err := ec.SetExpiry(ctx, id, at)
+	c.done(id, err)
+	return err
+}
+
+// UpdateResources implements driver.ResourceUpdater.
+func (d *Driver) UpdateResources(ctx context.Context, id string, u driver.ResourceUpdate) error {
+	ru, ok := d.backend.(driver.ResourceUpdater)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("update_resources")
+	err := ru.UpdateResources(ctx, id, u)
+	c.done(id, err)
+	return err
+}
+
+// Hibernate implements driver.Hibernator.
+func (d *Driver) Hibernate(ctx context.Context, id string) error {
+	hb, ok := d.backend.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("hibernate")
+	err := hb.Hibernate(ctx, id)
+	c.done(id, err)
+	return err
+}
+
+// Wake implements driver.Hibernator.
+func (d *Driver) Wake(ctx context.Context, id string, ttl time.Duration) error {
+	hb, ok := d.backend.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("wake")
+	err := hb.Wake(ctx, id, ttl)
+	c.done(id, err)
+	return err
+}
+
+// FSDiff implements driver.FSDiffer.
+func (d *Driver) FSDiff(ctx context.Context, id string) ([]driver.FSChange, error) {
+	fd, ok := d.backend.(driver.FSDiffer)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	c := d.begin("fs_diff")
+	changes, err := fd.FSDiff(ctx, id)
+	c.done(id, err)
+	return changes, err
+}
+
+// Adopt implements driver.Adopter.
+func (d *Driver) Adopt(ctx context.Context, instance string) (int, error) {
+	a, ok := d.backend.(driver.Adopter)
+	if !ok {
+		return 0, driver.ErrNotImplemented
+	}
+	c := d.begin("adopt")
+	n, err := a.Adopt(ctx, instance)
+	c.done("", err)
+	return n, err
+}
+
+// Snapshot implements driver.Snapshotter.
+func (d *Driver) Snapshot(ctx context.Context, id, ref string, labels map[string]string) error {
+	sn, ok := d.backend.(driver.Snapshotter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("snapshot")
+	err := sn.Snapshot(ctx, id, ref, labels)
+	c.done(id, err)
+	return err
+}
+
+// HasImage implements driver.Snapshotter.
+func (d *Driver) HasImage(ctx context.Context, ref string) (bool, error) {
+	sn, ok := d.backend.(driver.Snapshotter)
+	if !ok {
+		return false, driver.ErrNotImplemented
+	}
+	c := d.begin("has_image")
+	has, err := sn.HasImage(ctx, ref)
+	c.done("", err)
+	return has, err
+}
+
+// PutFiles implements driver.FilesPutter.
+func (d *Driver) PutFiles(ctx context.Context, id string, files []driver.FileUpload) error {
+	fp, ok := d.backend.(driver.FilesPutter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	c := d.begin("put_files")
+	err := fp.PutFiles(ctx, id, files)
+	c.done(id, err)
+	return err
+}
+
+// Logs implements driver.LogReader.
+func (d *Driver) Logs(ctx context.Context, id, source string, follow bool) (<-chan driver.LogLine, error) {
+	lr, ok := d.backend.(driver.LogReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	c := d.begin("logs")
+	lines, err := lr.Logs(ctx, id, source, follow)
+	c.done(id, err)
+	return lines, err
+}
+
+// Stats implements driver.StatsReader.
+func (d *Driver) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	sr, ok := d.backend.(driver.StatsReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	c := d.begin("stats")
+	stats, err := sr.Stats(ctx, id)
+	c.done(id, err)
+	return stats, err
+}
+
+// DiskUsage implements driver.DiskUsageReader.
+func (d *Driver) DiskUsage(ctx context.Context, id string, dirs []string) ([]driver.DirUsage, error) {
+	ur, ok := d.backend.(driver.DiskUsageReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	c := d.begin("disk_usage")
+	usage, err := ur.DiskUsage(ctx, id, dirs)
+	c.done(id, err)
+	return usage, err
+}
+
+// CollectGarbage implements driver.GarbageCollector.
+func (d *Driver) CollectGarbage(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	gc, ok := d.backend.(driver.GarbageCollector)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	c := d.begin("collect_garbage")
+	items, err := gc.CollectGarbage(ctx, dryRun)
+	c.done("", err)
+	return items, err
+}
+
+// SetReapHook implements driver.GarbageCollector.
+func (d *Driver) SetReapHook(fn func(driver.GCItem)) {
+	if gc, ok := d.backend.(driver.GarbageCollector); ok {
+		gc.SetReapHook(fn)
+	}
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"context"
+	"time"
+)
+
+// SandboxPhase is a step of a sandbox's lifecycle, finer than its
+// SandboxState: a creating sandbox may be pulling its image or waiting for
+// its agent.
+type SandboxPhase string
+
+const (
+	// PhaseCreating is provisioning the sandbox: resolving its template,
+	// cloning, creating the container.
+	PhaseCreating SandboxPhase = "creating"
+
+	// PhasePullingImage is pulling the sandbox's image, which was not
+	// available locally.
+	PhasePullingImage SandboxPhase = "pulling-image"
+
+	// PhaseInjectingContext is writing the create's context files.
+	PhaseInjectingContext SandboxPhase = "injecting-context"
+
+	// PhaseStarting is starting the sandbox's processes and sidecars.
+	PhaseStarting SandboxPhase = "starting"
+
+	// PhaseAgentWaiting is waiting for the sandbox's agent to answer.
+	PhaseAgentWaiting SandboxPhase = "agent-waiting"
+
+	// PhaseInitializing is running the init script of the sandbox's
+	// template.
+	PhaseInitializing SandboxPhase = "initializing"
+
+	// PhaseReady is running and taking execs.
+	PhaseReady SandboxPhase = "ready"
+
+	// PhaseStopping is being stopped and removed.
+	PhaseStopping SandboxPhase = "stopping"
+)
+
+// PhaseTransition is the moment a sandbox entered a phase, which lasted
+// until the next transition.
+type PhaseTransition struct {
+	Phase SandboxPhase `json:"phase"`
+	At    time.Time    `json:"at"`
+}
+
+type phaseKey struct{}
+
+// WatchPhases returns a context that drivers report the phases of a create
+// made with it to, calling fn as each begins.
+func WatchPhases(ctx context.Context, fn func(SandboxPhase)) context.Context {
+	return context.WithValue(ctx, phaseKey{}, fn)
+}
+
+// ReportPhase records that a call made with ctx entered phase, if ctx came
+// from WatchPhases.
+func ReportPhase(ctx context.Context, phase SandboxPhase) {
+	if fn, ok := ctx.Value(phaseKey{}).(func(SandboxPhase)); ok {
+		fn(phase)
+	}
+}
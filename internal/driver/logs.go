@@ -0,0 +1,218 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Log sources.
+const (
+	// LogSourceAgent is what the agent running an exec reports about it,
+	// e.g. its stderr in a container
+	LogSourceAgent = "agent"
+
+	// LogSourceProcess is the output of the sandbox's main process
+	LogSourceProcess = "process"
+)
+
+// LogReader is implemented by drivers that keep sandbox logs for debugging.
+type LogReader interface {
+	// Logs sends the recent lines of source, oldest first, on the returned
+	// channel. With follow set it then keeps sending new lines until ctx is
+	// done or the sandbox stops; either way the channel is closed at the end.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist and
+	// ErrNotImplemented for sources the driver does not have.
+	Logs(ctx context.Context, id, source string, follow bool) (<-chan LogLine, error)
+}
+
+// LogLine is one line of sandbox log output.
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// Exec identifies the agent process that wrote the line, if known
+	Exec string `json:"exec,omitempty"`
+	Text string `json:"text"`
+}
+
+// ValidateLogSource checks that source is one of the LogSource constants.
+func ValidateLogSource(source string) error {
+	switch source {
+	case LogSourceAgent, LogSourceProcess:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown log source %q (want %s or %s)", ErrInvalidConfig, source, LogSourceAgent, LogSourceProcess)
+}
+
+// DefaultLogLines is how many lines a LogBuffer keeps by default.
+const DefaultLogLines = 1000
+
+// maxLogLine caps a line; longer ones are split.
+const maxLogLine = 16 * 1024
+
+// LogBuffer keeps the last lines written to it, for drivers implementing
+// LogReader. It is an io.Writer; writes are split into lines. Writers
+// returned by Exec tag their lines and hold their partial lines apart.
+type LogBuffer struct {
+	source string
+	max    int
+
+	mu    sync.Mutex
+	lines []LogLine
+	// untagged holds the partial line of Write
+	untagged LogWriter
+	// added counts the lines ever added, including those dropped since
+	added int
+	// notify is closed and replaced whenever a line is added
+	notify chan struct{}
+	closed bool
+}
+
+// NewLogBuffer returns a buffer of source lines keeping the last n lines
+// (DefaultLogLines if n <= 0).
+func NewLogBuffer(source string, n int) *LogBuffer {
+	if n <= 0 {
+		n = DefaultLogLines
+	}
+	b := &LogBuffer{source: source, max: n, notify: make(chan struct{})}
+	b.untagged.b = b
+	return b
+}
+
+// Write implements io.Writer. An unterminated last line is held until the
+// rest arrives.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	return b.untagged.Write(p)
+}
+
+// Printf adds a line.
+func (b *LogBuffer) Printf(format string, args ...any) {
+	b.untagged.Printf(format, args...)
+}
+
+// Exec returns a writer adding lines tagged with exec, the ID of the agent
+// process writing them. Closing it keeps its partial line.
+func (b *LogBuffer) Exec(exec string) *LogWriter {
+	return &LogWriter{b: b, exec: exec}
+}
+
+// add must be called with b.mu held.
+func (b *LogBuffer) add(exec, text string) {
+	if b.closed {
+		return
+	}
+	b.lines = append(b.lines, LogLine{Time: time.Now(), Source: b.source, Exec: exec, Text: text})
+	b.added++
+	if len(b.lines) > b.max {
+		b.lines = append(b.lines[:0:0], b.lines[len(b.lines)-b.max:]...)
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Close ends following readers, e.g. when the sandbox stops. A held partial
+// line is kept as a line of its own.
+func (b *LogBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.untagged.flush()
+	if !b.closed {
+		b.closed = true
+		close(b.notify)
+	}
+}
+
+// LogWriter writes the lines of one agent process to a LogBuffer; see
+// LogBuffer.Exec.
+type LogWriter struct {
+	b    *LogBuffer
+	exec string
+	// partial is guarded by b.mu
+	partial []byte
+}
+
+// Write implements io.Writer like LogBuffer.Write.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 && len(w.partial) < maxLogLine {
+			break
+		}
+		if i < 0 || i > maxLogLine {
+			i = maxLogLine
+		}
+		w.b.add(w.exec, string(bytes.TrimSuffix(w.partial[:i], []byte("\r"))))
+		if i < len(w.partial) && w.partial[i] == '\n' {
+			i++
+		}
+		w.partial = w.partial[i:]
+	}
+	if len(w.partial) == 0 {
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+// Printf adds a line.
+func (w *LogWriter) Printf(format string, args ...any) {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	w.b.add(w.exec, fmt.Sprintf(format, args...))
+}
+
+// Close keeps a held partial line as a line of its own.
+func (w *LogWriter) Close() error {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	w.flush()
+	return nil
+}
+
+// flush must be called with b.mu held.
+func (w *LogWriter) flush() {
+	if len(w.partial) > 0 {
+		w.b.add(w.exec, string(w.partial))
+		w.partial = nil
+	}
+}
+
+// Stream implements LogReader.Logs for the buffer.
+func (b *LogBuffer) Stream(ctx context.Context, follow bool) <-chan LogLine {
+	ch := make(chan LogLine, 64)
+	go func() {
+		defer close(ch)
+		next := 0 // number of the next line to send
+		for {
+			b.mu.Lock()
+			first := b.added - len(b.lines)
+			next = max(next, first)
+			batch := append([]LogLine(nil), b.lines[next-first:]...)
+			next = b.added
+			notify, closed := b.notify, b.closed
+			b.mu.Unlock()
+
+			for _, line := range batch {
+				select {
+				case ch <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !follow || closed {
+				return
+			}
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
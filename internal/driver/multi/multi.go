@@ -0,0 +1,658 @@
+// Package multi implements a driver that serves sandboxes from several
+// backends at once, e.g. Docker for trusted templates and a VM driver for
+// untrusted ones. Each create goes to the backend named in
+// SandboxConfig.Driver, else to the backend of the first route matching the
+// image, else to the default (first) backend.
+//
+// Sandbox IDs are prefixed with the backend name ("docker:3f9c..."), so
+// every later call finds its backend without extra state, also after a
+// restart.
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+const DriverName = "multi"
+
+// Backend is a driver sandboxes can be routed to.
+type Backend struct {
+	// Name is what SandboxConfig.Driver and routes refer to; it must not
+	// contain ':'
+	Name   string
+	Driver driver.Driver
+}
+
+// Route sends sandboxes whose image matches Pattern (path.Match syntax,
+// e.g. "python:*") to the backend named Driver.
+type Route struct {
+	Pattern string `json:"pattern"`
+	Driver  string `json:"driver"`
+}
+
+// MultiDriver implements driver.Router over a set of backends.
+type MultiDriver struct {
+	backends []Backend
+	byName   map[string]driver.Driver
+	routes   []Route
+}
+
+// New creates a driver routing between backends; the first is the default.
+func New(backends []Backend, routes []Route) (*MultiDriver, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("multi: no backends")
+	}
+	d := &MultiDriver{backends: backends, byName: make(map[string]driver.Driver, len(backends)), routes: routes}
+	for _, b := range backends {
+		if b.Name == "" || strings.Contains(b.Name, ":") {
+			return nil, fmt.Errorf("multi: invalid backend name %q", b.Name)
+		}
+		if d.byName[b.Name] != nil {
+			return nil, fmt.Errorf("multi: duplicate backend %q", b.Name)
+		}
+		d.byName[b.Name] = b.Driver
+	}
+	for _, r := range routes {
+		if d.byName[r.Driver] == nil {
+			return nil, fmt.Errorf("multi: route %q uses unknown driver %q", r.Pattern, r.Driver)
+		}
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("multi: route %q: %w", r.Pattern, err)
+		}
+	}
+	return d, nil
+}
+
+// Open creates the drivers registered under names, passing each cfg. With a
+// single name that driver is returned as is; with more they are combined
+// into a MultiDriver using routes.
+func Open(names []string, cfg map[string]any, routes []Route) (driver.Driver, error) {
+	if len(names) == 1 && len(routes) == 0 {
+		return driver.NewDriver(strings.TrimSpace(names[0]), cfg)
+	}
+
+	var backends []Backend
+	closeAll := func() {
+		for _, b := range backends {
+			b.Driver.Close()
+		}
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		d, err := driver.NewDriver(name, cfg)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		backends = append(backends, Backend{Name: name, Driver: d})
+	}
+	d, err := New(backends, routes)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return d, nil
+}
+
+// ParseRoutes parses a comma-separated list of pattern=driver pairs, e.g.
+// "python:*=docker,untrusted/*=firecracker".
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.LastIndex(part, "=")
+		if i <= 0 || i == len(part)-1 {
+			return nil, fmt.Errorf("invalid route %q: want pattern=driver", part)
+		}
+		routes = append(routes, Route{Pattern: part[:i], Driver: part[i+1:]})
+	}
+	return routes, nil
+}
+
+func (d *MultiDriver) DriverName() string {
+	return DriverName
+}
+
+// Backends implements driver.Router.
+func (d *MultiDriver) Backends() []string {
+	names := make([]string, len(d.backends))
+	for i, b := range d.backends {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// pick returns the backend for an image, or the one asked for.
+func (d *MultiDriver) pick(image, requested string) (string, driver.Driver, error) {
+	if requested != "" {
+		if b := d.byName[requested]; b != nil {
+			return requested, b, nil
+		}
+		return "", nil, fmt.Errorf("%w: unknown driver %q", driver.ErrInvalidConfig, requested)
+	}
+	for _, r := range d.routes {
+		if ok, _ := path.Match(r.Pattern, image); ok {
+			return r.Driver, d.byName[r.Driver], nil
+		}
+	}
+	return d.backends[0].Name, d.backends[0].Driver, nil
+}
+
+// resolve splits a sandbox ID into its backend and the backend's own ID.
+func (d *MultiDriver) resolve(id string) (string, driver.Driver, string, error) {
+	name, inner, ok := strings.Cut(id, ":")
+	if b := d.byName[name]; ok && b != nil {
+		return name, b, inner, nil
+	}
+	return "", nil, "", driver.ErrSandboxNotFound
+}
+
+func join(name, id string) string {
+	return name + ":" + id
+}
+
+func (d *MultiDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	name, b, err := d.pick(cfg.Image, cfg.Driver)
+	if err != nil {
+		return "", err
+	}
+	cfg.Driver = ""
+	id, err := b.Create(ctx, cfg)
+	if err != nil {
+		return "", err
+	}
+	return join(name, id), nil
+}
+
+func (d *MultiDriver) Start(ctx context.Context, id string) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	return b.Start(ctx, inner)
+}
+
+func (d *MultiDriver) Stop(ctx context.Context, id string) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	return b.Stop(ctx, inner)
+}
+
+func (d *MultiDriver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	return b.Connect(ctx, inner)
+}
+
+func (d *MultiDriver) ListFiles(ctx context.Context, id, dir string) ([]*driver.FileEntry, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	return b.ListFiles(ctx, inner, dir)
+}
+
+func (d *MultiDriver) PutFile(ctx context.Context, id, dest string, content io.Reader) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	return b.PutFile(ctx, inner, dest, content)
+}
+
+func (d *MultiDriver) GetFile(ctx context.Context, id, src string) (io.ReadCloser, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	return b.GetFile(ctx, inner, src)
+}
+
+func (d *MultiDriver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	name, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.Info(ctx, inner)
+	if err != nil {
+		return nil, err
+	}
+	info.ID = join(name, info.ID)
+	return info, nil
+}
+
+func (d *MultiDriver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
+	var results []*driver.SandboxInfo
+	for _, b := range d.backends {
+		list, err := b.Driver.List(ctx, states)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Name, err)
+		}
+		for _, info := range list {
+			info.ID = join(b.Name, info.ID)
+			results = append(results, info)
+		}
+	}
+	return results, nil
+}
+
+// Healthy requires every backend to be healthy.
+func (d *MultiDriver) Healthy(ctx context.Context) error {
+	for _, b := range d.backends {
+		if err := b.Driver.Healthy(ctx); err != nil {
+			return fmt.Errorf("%s: %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *MultiDriver) Close() error {
+	var errs []error
+	for _, b := range d.backends {
+		if err := b.Driver.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Describe implements driver.Describer. Backends that cannot probe report
+// nothing, as if the driver had no Describer.
+func (d *MultiDriver) Describe(ctx context.Context, id string) (*driver.Environment, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	if ds, ok := b.(driver.Describer); ok {
+		return ds.Describe(ctx, inner)
+	}
+	return &driver.Environment{}, nil
+}
+
+// SetExpiry implements driver.ExpiryController.
+func (d *MultiDriver) SetExpiry(ctx context.Context, id string, at time.Time) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	ec, ok := b.(driver.ExpiryController)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return ec.SetExpiry(ctx, inner, at)
+}
+
+// UpdateResources implements driver.ResourceUpdater.
+func (d *MultiDriver) UpdateResources(ctx context.Context, id string, u driver.ResourceUpdate) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	ru, ok := b.(driver.ResourceUpdater)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return ru.UpdateResources(ctx, inner, u)
+}
+
+// Hibernate implements driver.Hibernator.
+func (d *MultiDriver) Hibernate(ctx context.Context, id string) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	hb, ok := b.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return hb.Hibernate(ctx, inner)
+}
+
+// Wake implements driver.Hibernator.
+func (d *MultiDriver) Wake(ctx context.Context, id string, ttl time.Duration) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	hb, ok := b.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return hb.Wake(ctx, inner, ttl)
+}
+
+// FSDiff implements driver.FSDiffer.
+func (d *MultiDriver) FSDiff(ctx context.Context, id string) ([]driver.FSChange, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	fd, ok := b.(driver.FSDiffer)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return fd.FSDiff(ctx, inner)
+}
+
+// Adopt implements driver.Adopter with every backend that can adopt.
+func (d *MultiDriver) Adopt(ctx context.Context, instance string) (int, error) {
+	total, adopters := 0, 0
+	var errs []error
+	for _, b := range d.backends {
+		a, ok := b.Driver.(driver.Adopter)
+		if !ok {
+			continue
+		}
+		adopters++
+		n, err := a.Adopt(ctx, instance)
+		total += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	if adopters == 0 {
+		return 0, driver.ErrNotImplemented
+	}
+	return total, errors.Join(errs...)
+}
+
+// Snapshot implements driver.Snapshotter.
+func (d *MultiDriver) Snapshot(ctx context.Context, id, ref string, labels map[string]string) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	sn, ok := b.(driver.Snapshotter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return sn.Snapshot(ctx, inner, ref, labels)
+}
+
+// HasImage implements driver.Snapshotter, asking the backend sandboxes of
+// ref would be routed to.
+func (d *MultiDriver) HasImage(ctx context.Context, ref string) (bool, error) {
+	_, b, _ := d.pick(ref, "")
+	sn, ok := b.(driver.Snapshotter)
+	if !ok {
+		return false, driver.ErrNotImplemented
+	}
+	return sn.HasImage(ctx, ref)
+}
+
+// PutFiles implements driver.FilesPutter.
+func (d *MultiDriver) PutFiles(ctx context.Context, id string, files []driver.FileUpload) error {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return err
+	}
+	fp, ok := b.(driver.FilesPutter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return fp.PutFiles(ctx, inner, files)
+}
+
+// Logs implements driver.LogReader.
+func (d *MultiDriver) Logs(ctx context.Context, id, source string, follow bool) (<-chan driver.LogLine, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	lr, ok := b.(driver.LogReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return lr.Logs(ctx, inner, source, follow)
+}
+
+// Stats implements driver.StatsReader.
+func (d *MultiDriver) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	sr, ok := b.(driver.StatsReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return sr.Stats(ctx, inner)
+}
+
+// DiskUsage implements driver.DiskUsageReader.
+func (d *MultiDriver) DiskUsage(ctx context.Context, id string, dirs []string) ([]driver.DirUsage, error) {
+	_, b, inner, err := d.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	ur, ok := b.(driver.DiskUsageReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return ur.DiskUsage(ctx, inner, dirs)
+}
+
+// PullImage implements driver.ImageManager. The image is pulled by the
+// backend its sandboxes would be routed to.
+func (d *MultiDriver) PullImage(ctx context.Context, ref string, progress func(driver.PullProgress)) error {
+	_, b, _ := d.pick(ref, "")
+	im, ok := b.(driver.ImageManager)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return im.PullImage(ctx, ref, progress)
+}
+
+// BuildImage implements driver.ImageBuilder. The image is built by the
+// backend its sandboxes would be routed to.
+func (d *MultiDriver) BuildImage(ctx context.Context, ref string, b driver.ImageBuild, log func(string)) error {
+	_, be, _ := d.pick(ref, "")
+	ib, ok := be.(driver.ImageBuilder)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return ib.BuildImage(ctx, ref, b, log)
+}
+
+// ListImages implements driver.ImageManager, listing the images of every
+// backend that keeps them.
+func (d *MultiDriver) ListImages(ctx context.Context) ([]*driver.ImageInfo, error) {
+	var results []*driver.ImageInfo
+	for _, b := range d.backends {
+		if im, ok := b.Driver.(driver.ImageManager); ok {
+			images, err := im.ListImages(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", b.Name, err)
+			}
+			results = append(results, images...)
+		}
+	}
+	return results, nil
+}
+
+// SetRegistryCredential implements driver.RegistryAuthenticator, giving
+// the credential to every backend that pulls images.
+func (d *MultiDriver) SetRegistryCredential(cred driver.RegistryCredential) error {
+	found := false
+	for _, b := range d.backends {
+		if ra, ok := b.Driver.(driver.RegistryAuthenticator); ok {
+			if err := ra.SetRegistryCredential(cred); err != nil {
+				return fmt.Errorf("%s: %w", b.Name, err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return driver.ErrNotImplemented
+	}
+	return nil
+}
+
+// DeleteRegistryCredential implements driver.RegistryAuthenticator.
+func (d *MultiDriver) DeleteRegistryCredential(registry string) bool {
+	deleted := false
+	for _, b := range d.backends {
+		if ra, ok := b.Driver.(driver.RegistryAuthenticator); ok && ra.DeleteRegistryCredential(registry) {
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+// RegistryCredentials implements driver.RegistryAuthenticator, listing the
+// credentials of the first backend that pulls images, which the others
+// were given too.
+func (d *MultiDriver) RegistryCredentials() []driver.RegistryCredential {
+	for _, b := range d.backends {
+		if ra, ok := b.Driver.(driver.RegistryAuthenticator); ok {
+			return ra.RegistryCredentials()
+		}
+	}
+	return nil
+}
+
+// CollectGarbage implements driver.GarbageCollector over every backend
+// that collects garbage. A failing backend does not stop the others.
+func (d *MultiDriver) CollectGarbage(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	var items []driver.GCItem
+	var errs []error
+	for _, b := range d.backends {
+		gc, ok := b.Driver.(driver.GarbageCollector)
+		if !ok {
+			continue
+		}
+		found, err := gc.CollectGarbage(ctx, dryRun)
+		for _, item := range found {
+			items = append(items, sandboxItem(b.Name, item))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	return items, errors.Join(errs...)
+}
+
+// SetReapHook implements driver.GarbageCollector.
+func (d *MultiDriver) SetReapHook(fn func(driver.GCItem)) {
+	for _, b := range d.backends {
+		if gc, ok := b.Driver.(driver.GarbageCollector); ok {
+			name := b.Name
+			gc.SetReapHook(func(item driver.GCItem) {
+				fn(sandboxItem(name, item))
+			})
+		}
+	}
+}
+
+// sandboxItem prefixes the ID of sandbox items, so that the control plane
+// can match them with its records.
+func sandboxItem(name string, item driver.GCItem) driver.GCItem {
+	if item.Kind == "sandbox" {
+		item.ID = join(name, item.ID)
+	}
+	return item
+}
+
+// Workspaces are kept by the default backend; sandboxes of other backends
+// cannot use them.
+func (d *MultiDriver) workspaces() (driver.WorkspaceManager, error) {
+	if wm, ok := d.backends[0].Driver.(driver.WorkspaceManager); ok {
+		return wm, nil
+	}
+	return nil, driver.ErrNotImplemented
+}
+
+// CreateWorkspace implements driver.WorkspaceManager.
+func (d *MultiDriver) CreateWorkspace(ctx context.Context, spec driver.WorkspaceSpec) (*driver.WorkspaceInfo, error) {
+	wm, err := d.workspaces()
+	if err != nil {
+		return nil, err
+	}
+	return wm.CreateWorkspace(ctx, spec)
+}
+
+// GetWorkspace implements driver.WorkspaceManager.
+func (d *MultiDriver) GetWorkspace(ctx context.Context, name string) (*driver.WorkspaceInfo, error) {
+	wm, err := d.workspaces()
+	if err != nil {
+		return nil, err
+	}
+	return wm.GetWorkspace(ctx, name)
+}
+
+// ListWorkspaces implements driver.WorkspaceManager.
+func (d *MultiDriver) ListWorkspaces(ctx context.Context) ([]*driver.WorkspaceInfo, error) {
+	wm, err := d.workspaces()
+	if err != nil {
+		return nil, err
+	}
+	return wm.ListWorkspaces(ctx)
+}
+
+// DeleteWorkspace implements driver.WorkspaceManager.
+func (d *MultiDriver) DeleteWorkspace(ctx context.Context, name string) error {
+	wm, err := d.workspaces()
+	if err != nil {
+		return err
+	}
+	return wm.DeleteWorkspace(ctx, name)
+}
+
+// Volumes are kept by the default backend, like workspaces.
+func (d *MultiDriver) volumes() (driver.VolumeManager, error) {
+	if vm, ok := d.backends[0].Driver.(driver.VolumeManager); ok {
+		return vm, nil
+	}
+	return nil, driver.ErrNotImplemented
+}
+
+// CreateVolume implements driver.VolumeManager.
+func (d *MultiDriver) CreateVolume(ctx context.Context, name string, labels map[string]string) (*driver.VolumeInfo, error) {
+	vm, err := d.volumes()
+	if err != nil {
+		return nil, err
+	}
+	return vm.CreateVolume(ctx, name, labels)
+}
+
+// GetVolume implements driver.VolumeManager.
+func (d *MultiDriver) GetVolume(ctx context.Context, name string) (*driver.VolumeInfo, error) {
+	vm, err := d.volumes()
+	if err != nil {
+		return nil, err
+	}
+	return vm.GetVolume(ctx, name)
+}
+
+// ListVolumes implements driver.VolumeManager.
+func (d *MultiDriver) ListVolumes(ctx context.Context) ([]*driver.VolumeInfo, error) {
+	vm, err := d.volumes()
+	if err != nil {
+		return nil, err
+	}
+	return vm.ListVolumes(ctx)
+}
+
+// DeleteVolume implements driver.VolumeManager.
+func (d *MultiDriver) DeleteVolume(ctx context.Context, name string) error {
+	vm, err := d.volumes()
+	if err != nil {
+		return err
+	}
+	return vm.DeleteVolume(ctx, name)
+}
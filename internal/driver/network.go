@@ -0,0 +1,119 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// networkAlias is a DNS label: lowercase letters, digits and '-', neither
+// first nor last.
+var networkAlias = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// validateNetworkGroup checks NetworkGroup and NetworkAlias. Group names
+// follow the rules of ValidateWorkspaceName.
+func (c *SandboxConfig) validateNetworkGroup() error {
+	if c.NetworkGroup == "" {
+		if c.NetworkAlias != "" {
+			return fmt.Errorf("%w: network_alias needs a network_group", ErrInvalidConfig)
+		}
+		return nil
+	}
+	if !workspaceName.MatchString(c.NetworkGroup) {
+		return fmt.Errorf("%w: invalid network group %q", ErrInvalidConfig, c.NetworkGroup)
+	}
+	if c.NetworkAlias != "" && !networkAlias.MatchString(c.NetworkAlias) {
+		return fmt.Errorf("%w: invalid network alias %q: use lowercase letters, digits and '-'", ErrInvalidConfig, c.NetworkAlias)
+	}
+	return nil
+}
+
+// HostGateway is an ExtraHosts address standing for the host the sandbox
+// runs on, such as to reach a mock server started next to the server.
+const HostGateway = "host-gateway"
+
+// maxDNSServers is how many name servers resolvers use; later ones in
+// resolv.conf are ignored.
+const maxDNSServers = 3
+
+var (
+	// hostName is a DNS name of labels of letters, digits and '-',
+	// neither first nor last, separated by dots
+	hostName = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+	// dnsOption is a resolv.conf option, e.g. "ndots:2" or "rotate"
+	dnsOption = regexp.MustCompile(`^[a-z][a-z0-9-]*(:[0-9]+)?$`)
+)
+
+// IsHostName reports whether s is a DNS name: labels of letters, digits
+// and '-', neither first nor last, separated by dots.
+func IsHostName(s string) bool {
+	return len(s) <= 253 && hostName.MatchString(s)
+}
+
+// CustomDNS reports whether the config sets DNS, DNSSearch, DNSOptions or
+// ExtraHosts.
+func (c *SandboxConfig) CustomDNS() bool {
+	return len(c.DNS) > 0 || len(c.DNSSearch) > 0 || len(c.DNSOptions) > 0 || len(c.ExtraHosts) > 0
+}
+
+// validateDNS checks DNS, DNSSearch, DNSOptions and ExtraHosts.
+func (c *SandboxConfig) validateDNS() error {
+	if len(c.DNS) > maxDNSServers {
+		return fmt.Errorf("%w: at most %d dns servers, as resolvers ignore the rest", ErrInvalidConfig, maxDNSServers)
+	}
+	for _, s := range c.DNS {
+		if _, err := netip.ParseAddr(s); err != nil {
+			return fmt.Errorf("%w: invalid dns server %q: want an IP address", ErrInvalidConfig, s)
+		}
+	}
+	for _, s := range c.DNSSearch {
+		if !IsHostName(s) {
+			return fmt.Errorf("%w: invalid dns search domain %q", ErrInvalidConfig, s)
+		}
+	}
+	for _, s := range c.DNSOptions {
+		if !dnsOption.MatchString(s) {
+			return fmt.Errorf("%w: invalid dns option %q, e.g. \"ndots:2\"", ErrInvalidConfig, s)
+		}
+	}
+	for name, addr := range c.ExtraHosts {
+		if !IsHostName(name) {
+			return fmt.Errorf("%w: invalid extra host name %q", ErrInvalidConfig, name)
+		}
+		if _, err := netip.ParseAddr(addr); err != nil && addr != HostGateway {
+			return fmt.Errorf("%w: invalid address %q of extra host %s: want an IP address or %q", ErrInvalidConfig, addr, name, HostGateway)
+		}
+	}
+	return nil
+}
+
+// EgressProxy is an HTTP and SOCKS5 proxy on the host that a sandbox
+// reaches the internet through. The sandbox's network reaches nothing but
+// the proxy, which it logs in to as User with Password; the proxy variables
+// of Env point its tools at it.
+type EgressProxy struct {
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Env returns HTTP_PROXY, HTTPS_PROXY and ALL_PROXY, in both cases, for the
+// proxy reached at host, and NO_PROXY for the sandbox's own loopback.
+func (p *EgressProxy) Env(host string) []string {
+	u := (&url.URL{
+		Scheme: "http",
+		User:   url.UserPassword(p.User, p.Password),
+		Host:   net.JoinHostPort(host, strconv.Itoa(p.Port)),
+	}).String()
+	const local = "localhost,127.0.0.1,::1"
+	env := []string{"NO_PROXY=" + local, "no_proxy=" + local}
+	for _, k := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"} {
+		env = append(env, k+"="+u, strings.ToLower(k)+"="+u)
+	}
+	return env
+}
@@ -0,0 +1,427 @@
+// Package plugin runs sandbox drivers as separate processes, so that
+// backends such as Nomad or LXD can be added without rebuilding Boxed.
+//
+// A plugin is an executable named boxed-driver-<name> in the plugin
+// directory; Load registers it as driver <name>. Each driver opened from it
+// starts the executable, which calls Serve. The plugin listens on a Unix
+// socket and writes one handshake line to its stdout:
+//
+//	<protocol version>|unix|<socket path>
+//
+// The server then calls the boxed.plugin.v1.Driver gRPC service on the
+// socket, with messages encoded as JSON (content type
+// application/grpc+json). Configure passes the driver configuration first;
+// durations in it arrive as nanoseconds. The other methods mirror
+// driver.Driver, with its sentinel errors carried as status codes.
+//
+// Plugins serve the Driver interface only: pools, images, logs, stats and
+// the other optional interfaces are not available to them.
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Prefix starts the file name of every plugin executable.
+const Prefix = "boxed-driver-"
+
+// The environment variable with which the server starts plugins; Serve
+// refuses to run without it.
+const (
+	cookieKey   = "BOXED_PLUGIN_COOKIE"
+	cookieValue = "9c4e1f6a3b2d4e5f8a7b6c5d4e3f2a1b"
+)
+
+// Timeouts of a plugin's lifecycle.
+const (
+	// handshakeTimeout bounds how long a plugin has to start listening
+	handshakeTimeout = 10 * time.Second
+	// closeTimeout bounds how long a plugin has to exit once closed
+	closeTimeout = 10 * time.Second
+)
+
+// Load registers a driver for each plugin executable in dir and returns
+// their names. A missing dir holds no plugins. Plugins named after a
+// driver that is already registered are skipped with a warning.
+func Load(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	registered := make(map[string]bool)
+	for _, name := range driver.AvailableDrivers() {
+		registered[name] = true
+	}
+	var names []string
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), Prefix)
+		if !ok || name == "" || e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if info, err := os.Stat(path); err != nil || info.Mode()&0111 == 0 {
+			log.Warn().Str("path", path).Msg("Skipping plugin that is not executable")
+			continue
+		}
+		if registered[name] {
+			log.Warn().Str("path", path).Str("driver", name).Msg("Skipping plugin named after a registered driver")
+			continue
+		}
+		driver.RegisterDriver(name, func(cfg map[string]any) (driver.Driver, error) {
+			return Open(path, cfg)
+		})
+		registered[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// pluginDriver is a driver.Driver served by a plugin process.
+type pluginDriver struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.Closer
+	conn   *grpc.ClientConn
+	exited chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Open starts the plugin executable at path and configures its driver
+// with cfg.
+func Open(path string, cfg map[string]any) (driver.Driver, error) {
+	name := strings.TrimPrefix(filepath.Base(path), Prefix)
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), cookieKey+"="+cookieValue)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", name, err)
+	}
+	p := &pluginDriver{name: name, cmd: cmd, stdin: stdin, exited: make(chan struct{})}
+	var stderrDone sync.WaitGroup
+	stderrDone.Add(1)
+	go func() {
+		defer stderrDone.Done()
+		logOutput(name, stderr)
+	}()
+
+	// The first line of stdout is the handshake, the rest is logged
+	handshake := make(chan string, 1)
+	go func() {
+		r := bufio.NewReader(stdout)
+		line, _ := r.ReadString('\n')
+		handshake <- line
+		logOutput(name, r)
+		// Wait must not run before the pipes are drained
+		stderrDone.Wait()
+		cmd.Wait()
+		close(p.exited)
+	}()
+
+	var line string
+	select {
+	case line = <-handshake:
+	case <-time.After(handshakeTimeout):
+	}
+	addr, err := parseHandshake(line)
+	if err == nil {
+		p.conn, err = grpc.NewClient("unix://"+addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodec{}.Name())))
+	}
+	if err != nil {
+		p.kill()
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	var resp configureResponse
+	if err := p.invoke(ctx, "Configure", &configureRequest{Config: cfg}, &resp); err != nil {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	if resp.Name != "" {
+		p.name = resp.Name
+	}
+	return p, nil
+}
+
+// parseHandshake returns the socket address of a handshake line.
+func parseHandshake(line string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid handshake %q", strings.TrimSpace(line))
+	}
+	if v, err := strconv.Atoi(parts[0]); err != nil || v != ProtocolVersion {
+		return "", fmt.Errorf("unsupported protocol version %q, want %d", parts[0], ProtocolVersion)
+	}
+	if parts[1] != "unix" {
+		return "", fmt.Errorf("unsupported network %q", parts[1])
+	}
+	return parts[2], nil
+}
+
+// logOutput logs the lines a plugin writes.
+func logOutput(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Info().Str("plugin", name).Msg(scanner.Text())
+	}
+}
+
+// kill ends the plugin process and waits for it.
+func (p *pluginDriver) kill() {
+	p.cmd.Process.Kill()
+	<-p.exited
+}
+
+// invoke calls a unary method of the plugin.
+func (p *pluginDriver) invoke(ctx context.Context, name string, req, resp any) error {
+	return fromStatus(p.conn.Invoke(ctx, method(name), req, resp))
+}
+
+func (p *pluginDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	var resp idMessage
+	if err := p.invoke(ctx, "Create", &createRequest{Config: cfg}, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (p *pluginDriver) Start(ctx context.Context, id string) error {
+	return p.invoke(ctx, "Start", &idMessage{ID: id}, &empty{})
+}
+
+func (p *pluginDriver) Stop(ctx context.Context, id string) error {
+	return p.invoke(ctx, "Stop", &idMessage{ID: id}, &empty{})
+}
+
+func (p *pluginDriver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	var info driver.SandboxInfo
+	if err := p.invoke(ctx, "Info", &idMessage{ID: id}, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (p *pluginDriver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
+	var resp listResponse
+	if err := p.invoke(ctx, "List", &listRequest{States: states}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sandboxes, nil
+}
+
+func (p *pluginDriver) ListFiles(ctx context.Context, id, path string) ([]*driver.FileEntry, error) {
+	var resp filesResponse
+	if err := p.invoke(ctx, "ListFiles", &pathRequest{ID: id, Path: path}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Files, nil
+}
+
+func (p *pluginDriver) DriverName() string {
+	return p.name
+}
+
+func (p *pluginDriver) Healthy(ctx context.Context) error {
+	select {
+	case <-p.exited:
+		return fmt.Errorf("plugin %s exited", p.name)
+	default:
+	}
+	return p.invoke(ctx, "Healthy", &empty{}, &empty{})
+}
+
+// Close closes the plugin's driver and waits for the plugin to exit,
+// killing it if it does not in time.
+func (p *pluginDriver) Close() error {
+	p.closeOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		p.closeErr = p.invoke(ctx, "Close", &empty{}, &empty{})
+		p.conn.Close()
+		p.stdin.Close()
+		select {
+		case <-p.exited:
+		case <-ctx.Done():
+			log.Warn().Str("plugin", p.name).Msg("Killing plugin that did not exit")
+			p.kill()
+		}
+	})
+	return p.closeErr
+}
+
+func (p *pluginDriver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	// The stream outlives ctx, which only bounds connecting
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := p.conn.NewStream(streamCtx, &serviceDesc.Streams[streamConnect], method("Connect"))
+	if err == nil {
+		err = stream.SendMsg(&chunk{ID: id})
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+
+	acked := make(chan error, 1)
+	go func() { acked <- stream.RecvMsg(&chunk{}) }()
+	select {
+	case err = <-acked:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+	return &streamConn{stream: stream, cancel: cancel}, nil
+}
+
+func (p *pluginDriver) PutFile(ctx context.Context, id, path string, content io.Reader) error {
+	stream, err := p.conn.NewStream(ctx, &serviceDesc.Streams[streamPutFile], method("PutFile"))
+	if err != nil {
+		return fromStatus(err)
+	}
+	if err := stream.SendMsg(&chunk{ID: id, Path: path}); err != nil {
+		return fromStatus(stream.RecvMsg(&empty{}))
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&chunk{Data: buf[:n]}); err != nil {
+				// The plugin ended the call; its status says why
+				return fromStatus(stream.RecvMsg(&empty{}))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fromStatus(err)
+	}
+	return fromStatus(stream.RecvMsg(&empty{}))
+}
+
+func (p *pluginDriver) GetFile(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := p.conn.NewStream(streamCtx, &serviceDesc.Streams[streamGetFile], method("GetFile"))
+	if err == nil {
+		err = stream.SendMsg(&pathRequest{ID: id, Path: path})
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	var first chunk
+	if err == nil {
+		err = stream.RecvMsg(&first)
+	}
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+
+	r := &streamConn{stream: stream, cancel: cancel}
+	if first.Stat != nil {
+		return &statConn{streamConn: r, info: fileInfo{first.Stat}}, nil
+	}
+	return r, nil
+}
+
+// streamConn reads and writes the data of a stream's chunks.
+type streamConn struct {
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	buf    []byte
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		var in chunk
+		if err := c.stream.RecvMsg(&in); err == io.EOF {
+			return 0, io.EOF
+		} else if err != nil {
+			return 0, fromStatus(err)
+		}
+		c.buf = in.Data
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, chunkSize)
+		if err := c.stream.SendMsg(&chunk{Data: p[written : written+n]}); err != nil {
+			return written, fromStatus(err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.wmu.Lock()
+		c.stream.CloseSend()
+		c.wmu.Unlock()
+		c.cancel()
+	})
+	return nil
+}
+
+// statConn is a file read from a plugin that knows its size, which lets
+// the API serve byte ranges.
+type statConn struct {
+	*streamConn
+	info fs.FileInfo
+}
+
+func (c *statConn) Stat() (fs.FileInfo, error) {
+	return c.info, nil
+}
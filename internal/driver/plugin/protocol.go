@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// ProtocolVersion is the version of the handshake and of the Driver
+// service. Plugins speaking another version are refused.
+const ProtocolVersion = 1
+
+// serviceName is the gRPC service plugins serve.
+const serviceName = "boxed.plugin.v1.Driver"
+
+// chunkSize bounds the data of a single stream message.
+const chunkSize = 32 * 1024
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes messages as JSON, so that the service needs no
+// generated code; calls use the "application/grpc+json" content type.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// Messages of the Driver service.
+type (
+	configureRequest struct {
+		Config map[string]any `json:"config"`
+	}
+	configureResponse struct {
+		Name string `json:"name"`
+	}
+	createRequest struct {
+		Config driver.SandboxConfig `json:"config"`
+	}
+	idMessage struct {
+		ID string `json:"id"`
+	}
+	pathRequest struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	}
+	listRequest struct {
+		States []driver.SandboxState `json:"states,omitempty"`
+	}
+	listResponse struct {
+		Sandboxes []*driver.SandboxInfo `json:"sandboxes"`
+	}
+	filesResponse struct {
+		Files []*driver.FileEntry `json:"files"`
+	}
+	empty struct{}
+
+	// chunk carries the bytes of Connect, PutFile and GetFile. The first
+	// message of PutFile names the file; the first of GetFile describes it.
+	chunk struct {
+		ID   string    `json:"id,omitempty"`
+		Path string    `json:"path,omitempty"`
+		Data []byte    `json:"data,omitempty"`
+		Stat *fileStat `json:"stat,omitempty"`
+	}
+)
+
+// fileStat is what GetFile tells of a file whose reader has a Stat method.
+type fileStat struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// fileInfo is the fs.FileInfo of a fileStat.
+type fileInfo struct{ s *fileStat }
+
+func (fi fileInfo) Name() string       { return fi.s.Name }
+func (fi fileInfo) Size() int64        { return fi.s.Size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.s.Mode }
+func (fi fileInfo) ModTime() time.Time { return fi.s.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.s.Mode.IsDir() }
+func (fi fileInfo) Sys() any           { return nil }
+
+// The streaming methods, indexed in serviceDesc.Streams.
+const (
+	streamConnect = iota
+	streamPutFile
+	streamGetFile
+)
+
+// serviceDesc describes the Driver service; server implements it.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Configure", (*server).configure),
+		unary("Create", (*server).create),
+		unary("Start", (*server).start),
+		unary("Stop", (*server).stop),
+		unary("Info", (*server).info),
+		unary("List", (*server).list),
+		unary("ListFiles", (*server).listFiles),
+		unary("Healthy", (*server).healthy),
+		unary("Close", (*server).close),
+	},
+	Streams: []grpc.StreamDesc{
+		streamConnect: {StreamName: "Connect", Handler: streamHandler((*server).connect), ServerStreams: true, ClientStreams: true},
+		streamPutFile: {StreamName: "PutFile", Handler: streamHandler((*server).putFile), ClientStreams: true},
+		streamGetFile: {StreamName: "GetFile", Handler: streamHandler((*server).getFile), ServerStreams: true},
+	},
+}
+
+// method returns the full name of a method of the service.
+func method(name string) string {
+	return "/" + serviceName + "/" + name
+}
+
+// unary describes a unary method served by call.
+func unary[Req, Resp any](name string, call func(*server, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				resp, err := call(srv.(*server), ctx, req.(*Req))
+				return resp, toStatus(err)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: method(name)}, handler)
+		},
+	}
+}
+
+// streamHandler adapts a streaming method of server.
+func streamHandler(call func(*server, grpc.ServerStream) error) grpc.StreamHandler {
+	return func(srv any, stream grpc.ServerStream) error {
+		return toStatus(call(srv.(*server), stream))
+	}
+}
+
+// statusCodes carries the driver's sentinel errors across the wire.
+var statusCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{driver.ErrSandboxNotFound, codes.NotFound},
+	{driver.ErrSandboxAlreadyRunning, codes.AlreadyExists},
+	{driver.ErrSandboxNotRunning, codes.FailedPrecondition},
+	{driver.ErrConnectionFailed, codes.Unavailable},
+	{driver.ErrResourceExhausted, codes.ResourceExhausted},
+	{driver.ErrTimeout, codes.DeadlineExceeded},
+	{driver.ErrInvalidConfig, codes.InvalidArgument},
+	{driver.ErrNotImplemented, codes.Unimplemented},
+	{context.Canceled, codes.Canceled},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+}
+
+// toStatus turns an error of a plugin's driver into a gRPC status.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, s := range statusCodes {
+		if errors.Is(err, s.err) {
+			return status.Error(s.code, err.Error())
+		}
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// fromStatus turns a gRPC status back into an error that matches the
+// driver's sentinel errors with errors.Is.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return err
+	}
+	for _, s := range statusCodes {
+		if st.Code() == s.code {
+			return &remoteError{msg: st.Message(), err: s.err}
+		}
+	}
+	return errors.New(st.Message())
+}
+
+// remoteError is an error reported by a plugin.
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.err }
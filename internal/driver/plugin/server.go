@@ -0,0 +1,310 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Serve runs a plugin: it serves the driver factory makes, with the
+// configuration the server passes, until the server closes it or exits.
+// It is called from the main function of a plugin executable, which the
+// server starts; run any other way it returns an error at once.
+func Serve(factory driver.DriverFactory) error {
+	if os.Getenv(cookieKey) != cookieValue {
+		return errors.New("this program is a Boxed driver plugin: put it in the server's plugin directory instead of running it")
+	}
+	// Ctrl-C reaches the whole process group; the server closes plugins
+	// itself once it has drained
+	signal.Ignore(os.Interrupt)
+
+	dir, err := os.MkdirTemp("", "boxed-plugin-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	lis, err := net.Listen("unix", filepath.Join(dir, "plugin.sock"))
+	if err != nil {
+		return err
+	}
+
+	s := &server{factory: factory}
+	gs := grpc.NewServer()
+	gs.RegisterService(&serviceDesc, s)
+	s.shutdown = gs.GracefulStop
+	// The server holds our stdin open while it runs
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		gs.Stop()
+	}()
+
+	fmt.Printf("%d|unix|%s\n", ProtocolVersion, lis.Addr())
+	err = gs.Serve(lis)
+	s.closeDriver()
+	return err
+}
+
+// server serves the Driver service with the driver of a plugin.
+type server struct {
+	factory driver.DriverFactory
+	// shutdown stops serving once Close has answered
+	shutdown func()
+
+	mu     sync.Mutex
+	d      driver.Driver
+	closed bool
+}
+
+// driver returns the driver made by Configure.
+func (s *server) driver() (driver.Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d == nil {
+		return nil, status.Error(codes.FailedPrecondition, "plugin is not configured")
+	}
+	return s.d, nil
+}
+
+// closeDriver closes the driver, if it was made and not closed yet.
+func (s *server) closeDriver() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d == nil || s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.d.Close()
+}
+
+func (s *server) configure(ctx context.Context, req *configureRequest) (*configureResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d != nil {
+		return nil, status.Error(codes.FailedPrecondition, "plugin is already configured")
+	}
+	d, err := s.factory(req.Config)
+	if err != nil {
+		return nil, err
+	}
+	s.d = d
+	return &configureResponse{Name: d.DriverName()}, nil
+}
+
+func (s *server) create(ctx context.Context, req *createRequest) (*idMessage, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	id, err := d.Create(ctx, req.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &idMessage{ID: id}, nil
+}
+
+func (s *server) start(ctx context.Context, req *idMessage) (*empty, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, d.Start(ctx, req.ID)
+}
+
+func (s *server) stop(ctx context.Context, req *idMessage) (*empty, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, d.Stop(ctx, req.ID)
+}
+
+func (s *server) info(ctx context.Context, req *idMessage) (*driver.SandboxInfo, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	return d.Info(ctx, req.ID)
+}
+
+func (s *server) list(ctx context.Context, req *listRequest) (*listResponse, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	sandboxes, err := d.List(ctx, req.States)
+	if err != nil {
+		return nil, err
+	}
+	return &listResponse{Sandboxes: sandboxes}, nil
+}
+
+func (s *server) listFiles(ctx context.Context, req *pathRequest) (*filesResponse, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	files, err := d.ListFiles(ctx, req.ID, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &filesResponse{Files: files}, nil
+}
+
+func (s *server) healthy(ctx context.Context, _ *empty) (*empty, error) {
+	d, err := s.driver()
+	if err != nil {
+		return nil, err
+	}
+	return &empty{}, d.Healthy(ctx)
+}
+
+func (s *server) close(ctx context.Context, _ *empty) (*empty, error) {
+	err := s.closeDriver()
+	// GracefulStop waits for this call to end
+	go s.shutdown()
+	return &empty{}, err
+}
+
+// connect relays the agent stream of the sandbox named by the first
+// message. An empty message answers once it is connected.
+func (s *server) connect(stream grpc.ServerStream) error {
+	var req chunk
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	conn, err := d.Connect(stream.Context(), req.ID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := stream.SendMsg(&chunk{}); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			var in chunk
+			if err := stream.RecvMsg(&in); err != nil {
+				// The server closed the connection
+				conn.Close()
+				return
+			}
+			if _, err := conn.Write(in.Data); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// putFile writes the data of the messages after the first, which names
+// the file, to the sandbox.
+func (s *server) putFile(stream grpc.ServerStream) error {
+	var req chunk
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := d.PutFile(stream.Context(), req.ID, req.Path, pr)
+		// Unblock writes the driver did not read
+		pr.Close()
+		done <- err
+	}()
+	for {
+		var in chunk
+		err := stream.RecvMsg(&in)
+		if err == io.EOF {
+			pw.Close()
+			break
+		}
+		if err != nil {
+			pw.CloseWithError(err)
+			<-done
+			return err
+		}
+		if _, err := pw.Write(in.Data); err != nil {
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	return stream.SendMsg(&empty{})
+}
+
+// getFile sends a file of a sandbox: first a message with its stat, if
+// the driver's reader has one, then its data.
+func (s *server) getFile(stream grpc.ServerStream) error {
+	var req pathRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	rc, err := d.GetFile(stream.Context(), req.ID, req.Path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	first := &chunk{}
+	if st, ok := rc.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if fi, err := st.Stat(); err == nil {
+			first.Stat = &fileStat{Name: fi.Name(), Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime()}
+		}
+	}
+	if err := stream.SendMsg(first); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if err := stream.SendMsg(&chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package driver
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type pullKey struct{}
+
+// WatchPulls returns a context that drivers report image pulls to, and a
+// function reporting whether any call made with it pulled an image, so a
+// slow create can be told apart from a slow pull.
+func WatchPulls(ctx context.Context) (context.Context, func() bool) {
+	var pulled atomic.Bool
+	return context.WithValue(ctx, pullKey{}, &pulled), pulled.Load
+}
+
+// ReportPull records that an image was pulled for a call made with ctx,
+// if ctx came from WatchPulls.
+func ReportPull(ctx context.Context) {
+	if pulled, ok := ctx.Value(pullKey{}).(*atomic.Bool); ok {
+		pulled.Store(true)
+	}
+}
@@ -0,0 +1,81 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DockerHub is the registry of image references that name none.
+const DockerHub = "docker.io"
+
+// RegistryCredential authenticates the image pulls from one registry,
+// either with a username and password (or token) or with a Docker
+// credential helper, the docker-credential-<Helper> program.
+type RegistryCredential struct {
+	// Registry is the host, and port if any, e.g. ghcr.io or
+	// 123456789012.dkr.ecr.us-east-1.amazonaws.com
+	Registry string `json:"registry" yaml:"registry"`
+
+	Username string `json:"username,omitempty" yaml:"username"`
+	Password string `json:"password,omitempty" yaml:"password"`
+
+	// Helper names the credential helper, e.g. ecr-login or gcloud
+	Helper string `json:"helper,omitempty" yaml:"helper"`
+}
+
+// RegistryAuthenticator is implemented by drivers that pull images, to
+// give them the credentials of private registries.
+type RegistryAuthenticator interface {
+	// SetRegistryCredential adds the credential of cred.Registry, or
+	// replaces it.
+	SetRegistryCredential(cred RegistryCredential) error
+
+	// DeleteRegistryCredential forgets the credential of registry,
+	// reporting whether there was one.
+	DeleteRegistryCredential(registry string) bool
+
+	// RegistryCredentials lists the credentials by registry, without
+	// their passwords.
+	RegistryCredentials() []RegistryCredential
+}
+
+// Validate normalizes the registry of c and checks that it has either a
+// username and password or a helper.
+func (c *RegistryCredential) Validate() error {
+	c.Registry = NormalizeRegistry(c.Registry)
+	switch {
+	case c.Registry == "":
+		return fmt.Errorf("%w: registry credential without a registry", ErrInvalidConfig)
+	case c.Helper != "" && (c.Username != "" || c.Password != ""):
+		return fmt.Errorf("%w: credential of %s has both a helper and a password", ErrInvalidConfig, c.Registry)
+	case c.Helper != "" && strings.ContainsAny(c.Helper, `/\`):
+		return fmt.Errorf("%w: invalid credential helper %q", ErrInvalidConfig, c.Helper)
+	case c.Helper == "" && (c.Username == "" || c.Password == ""):
+		return fmt.Errorf("%w: credential of %s needs a username and password, or a helper", ErrInvalidConfig, c.Registry)
+	}
+	return nil
+}
+
+// NormalizeRegistry turns the ways a registry is written, such as
+// https://index.docker.io/v1/, into its host.
+func NormalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+	registry = strings.ToLower(registry)
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io":
+		return DockerHub
+	}
+	return registry
+}
+
+// ImageRegistry returns the registry an image reference is pulled from:
+// its first component if that looks like a host, as Docker decides, or
+// Docker Hub.
+func ImageRegistry(ref string) string {
+	host, _, ok := strings.Cut(ref, "/")
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return DockerHub
+	}
+	return NormalizeRegistry(host)
+}
@@ -0,0 +1,387 @@
+// Package shortid gives sandboxes short IDs assigned by the control plane,
+// such as "sbx_9f3k2m7q", in place of the backend's own: 64 hex digits for
+// Docker, "backend:id" for the multi driver. The short ID is stored as a
+// label of the sandbox, so the mapping is found again after a restart.
+//
+// Backend IDs are still accepted wherever a short ID is, and sandboxes
+// created without the label keep their backend ID.
+package shortid
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+const (
+	// Prefix starts every short ID
+	Prefix = "sbx_"
+
+	// Label holds the short ID of a sandbox; callers cannot set it
+	Label = "boxed.id"
+
+	// idLength is the number of random characters after Prefix
+	idLength = 8
+)
+
+// alphabet is Crockford's base32 in lower case: no i, l, o or u to misread.
+const alphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+// Driver wraps a driver, translating between short and backend IDs.
+type Driver struct {
+	backend driver.Driver
+
+	mu sync.Mutex
+	// toBackend maps short IDs to backend IDs; "" marks an ID being created
+	toBackend map[string]string
+	// fromBackend maps backend IDs to short IDs
+	fromBackend map[string]string
+}
+
+// Wrap returns d with short sandbox IDs.
+func Wrap(d driver.Driver) *Driver {
+	return &Driver{
+		backend:     d,
+		toBackend:   make(map[string]string),
+		fromBackend: make(map[string]string),
+	}
+}
+
+// Backend returns the wrapped driver.
+func (d *Driver) Backend() driver.Driver {
+	return d.backend
+}
+
+func newID() string {
+	b := make([]byte, idLength)
+	rand.Read(b)
+	for i := range b {
+		// 256 is a multiple of 32: every character is equally likely
+		b[i] = alphabet[b[i]%32]
+	}
+	return Prefix + string(b)
+}
+
+// Lookup returns the short ID of the sandbox ref names, ref being its short
+// or its backend ID. It reports false for sandboxes it does not know.
+func (d *Driver) Lookup(ref string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if b := d.toBackend[ref]; b != "" {
+		return ref, true
+	}
+	id, ok := d.fromBackend[ref]
+	return id, ok
+}
+
+// BackendID returns the backend's ID for a short ID, or "" if it is unknown.
+func (d *Driver) BackendID(id string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.toBackend[id]
+}
+
+// resolve returns the backend ID for id. IDs it cannot map, such as backend
+// IDs, are passed through for the backend to judge.
+func (d *Driver) resolve(ctx context.Context, id string) string {
+	if b := d.BackendID(id); b != "" {
+		return b
+	}
+	if strings.HasPrefix(id, Prefix) {
+		// Created before a restart: the labels have the mapping
+		if _, err := d.List(ctx, nil); err == nil {
+			if b := d.BackendID(id); b != "" {
+				return b
+			}
+		}
+	}
+	return id
+}
+
+// learn records the short ID labelling a sandbox the backend reported and
+// rewrites info to show it.
+func (d *Driver) learn(info *driver.SandboxInfo) {
+	id := info.Config.Labels[Label]
+	if id == "" {
+		return
+	}
+	d.mu.Lock()
+	d.toBackend[id] = info.ID
+	d.fromBackend[info.ID] = id
+	d.mu.Unlock()
+
+	labels := make(map[string]string, len(info.Config.Labels))
+	for k, v := range info.Config.Labels {
+		if k != Label {
+			labels[k] = v
+		}
+	}
+	info.Config.Labels = labels
+	info.BackendID = info.ID
+	info.ID = id
+}
+
+// forget drops the mapping of a sandbox that is gone.
+func (d *Driver) forget(backendID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, ok := d.fromBackend[backendID]; ok {
+		delete(d.toBackend, id)
+		delete(d.fromBackend, backendID)
+	}
+}
+
+// shortItem rewrites the ID of sandbox items to the short ID, forgetting
+// the sandbox if the item was removed.
+func (d *Driver) shortItem(item driver.GCItem, removed bool) driver.GCItem {
+	if item.Kind != "sandbox" {
+		return item
+	}
+	if id, ok := d.Lookup(item.ID); ok {
+		if removed {
+			d.forget(item.ID)
+		}
+		item.ID = id
+	}
+	return item
+}
+
+func (d *Driver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	d.mu.Lock()
+	id := newID()
+	for _, taken := d.toBackend[id]; taken; _, taken = d.toBackend[id] {
+		id = newID()
+	}
+	d.toBackend[id] = ""
+	d.mu.Unlock()
+
+	labels := make(map[string]string, len(cfg.Labels)+1)
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	labels[Label] = id
+	cfg.Labels = labels
+
+	backendID, err := d.backend.Create(ctx, cfg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		delete(d.toBackend, id)
+		return "", err
+	}
+	d.toBackend[id] = backendID
+	d.fromBackend[backendID] = id
+	return id, nil
+}
+
+func (d *Driver) Start(ctx context.Context, id string) error {
+	return d.backend.Start(ctx, d.resolve(ctx, id))
+}
+
+func (d *Driver) Stop(ctx context.Context, id string) error {
+	backendID := d.resolve(ctx, id)
+	if err := d.backend.Stop(ctx, backendID); err != nil {
+		return err
+	}
+	d.forget(backendID)
+	return nil
+}
+
+func (d *Driver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	return d.backend.Connect(ctx, d.resolve(ctx, id))
+}
+
+func (d *Driver) ListFiles(ctx context.Context, id, dir string) ([]*driver.FileEntry, error) {
+	return d.backend.ListFiles(ctx, d.resolve(ctx, id), dir)
+}
+
+func (d *Driver) PutFile(ctx context.Context, id, dest string, content io.Reader) error {
+	return d.backend.PutFile(ctx, d.resolve(ctx, id), dest, content)
+}
+
+func (d *Driver) GetFile(ctx context.Context, id, src string) (io.ReadCloser, error) {
+	return d.backend.GetFile(ctx, d.resolve(ctx, id), src)
+}
+
+func (d *Driver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	info, err := d.backend.Info(ctx, d.resolve(ctx, id))
+	if err != nil {
+		return nil, err
+	}
+	d.learn(info)
+	return info, nil
+}
+
+func (d *Driver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
+	list, err := d.backend.List(ctx, states)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range list {
+		d.learn(info)
+	}
+	return list, nil
+}
+
+func (d *Driver) DriverName() string {
+	return d.backend.DriverName()
+}
+
+func (d *Driver) Healthy(ctx context.Context) error {
+	return d.backend.Healthy(ctx)
+}
+
+func (d *Driver) Close() error {
+	return d.backend.Close()
+}
+
+// Describe implements driver.Describer. Backends that cannot probe report
+// nothing, as if the driver had no Describer.
+func (d *Driver) Describe(ctx context.Context, id string) (*driver.Environment, error) {
+	ds, ok := d.backend.(driver.Describer)
+	if !ok {
+		return &driver.Environment{}, nil
+	}
+	return ds.Describe(ctx, d.resolve(ctx, id))
+}
+
+// SetExpiry implements driver.ExpiryController.
+func (d *Driver) SetExpiry(ctx context.Context, id string, at time.Time) error {
+	ec, ok := d.backend.(driver.ExpiryController)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return ec.SetExpiry(ctx, d.resolve(ctx, id), at)
+}
+
+// UpdateResources implements driver.ResourceUpdater.
+func (d *Driver) UpdateResources(ctx context.Context, id string, u driver.ResourceUpdate) error {
+	ru, ok := d.backend.(driver.ResourceUpdater)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return ru.UpdateResources(ctx, d.resolve(ctx, id), u)
+}
+
+// Hibernate implements driver.Hibernator.
+func (d *Driver) Hibernate(ctx context.Context, id string) error {
+	hb, ok := d.backend.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return hb.Hibernate(ctx, d.resolve(ctx, id))
+}
+
+// Wake implements driver.Hibernator.
+func (d *Driver) Wake(ctx context.Context, id string, ttl time.Duration) error {
+	hb, ok := d.backend.(driver.Hibernator)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return hb.Wake(ctx, d.resolve(ctx, id), ttl)
+}
+
+// FSDiff implements driver.FSDiffer.
+func (d *Driver) FSDiff(ctx context.Context, id string) ([]driver.FSChange, error) {
+	fd, ok := d.backend.(driver.FSDiffer)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return fd.FSDiff(ctx, d.resolve(ctx, id))
+}
+
+// Adopt implements driver.Adopter, learning the short IDs of the adopted
+// sandboxes.
+func (d *Driver) Adopt(ctx context.Context, instance string) (int, error) {
+	a, ok := d.backend.(driver.Adopter)
+	if !ok {
+		return 0, driver.ErrNotImplemented
+	}
+	n, err := a.Adopt(ctx, instance)
+	if n > 0 {
+		d.List(ctx, nil)
+	}
+	return n, err
+}
+
+// Snapshot implements driver.Snapshotter.
+func (d *Driver) Snapshot(ctx context.Context, id, ref string, labels map[string]string) error {
+	sn, ok := d.backend.(driver.Snapshotter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return sn.Snapshot(ctx, d.resolve(ctx, id), ref, labels)
+}
+
+// HasImage implements driver.Snapshotter.
+func (d *Driver) HasImage(ctx context.Context, ref string) (bool, error) {
+	sn, ok := d.backend.(driver.Snapshotter)
+	if !ok {
+		return false, driver.ErrNotImplemented
+	}
+	return sn.HasImage(ctx, ref)
+}
+
+// PutFiles implements driver.FilesPutter.
+func (d *Driver) PutFiles(ctx context.Context, id string, files []driver.FileUpload) error {
+	fp, ok := d.backend.(driver.FilesPutter)
+	if !ok {
+		return driver.ErrNotImplemented
+	}
+	return fp.PutFiles(ctx, d.resolve(ctx, id), files)
+}
+
+// Logs implements driver.LogReader.
+func (d *Driver) Logs(ctx context.Context, id, source string, follow bool) (<-chan driver.LogLine, error) {
+	lr, ok := d.backend.(driver.LogReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return lr.Logs(ctx, d.resolve(ctx, id), source, follow)
+}
+
+// Stats implements driver.StatsReader.
+func (d *Driver) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	sr, ok := d.backend.(driver.StatsReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return sr.Stats(ctx, d.resolve(ctx, id))
+}
+
+// DiskUsage implements driver.DiskUsageReader.
+func (d *Driver) DiskUsage(ctx context.Context, id string, dirs []string) ([]driver.DirUsage, error) {
+	ur, ok := d.backend.(driver.DiskUsageReader)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	return ur.DiskUsage(ctx, d.resolve(ctx, id), dirs)
+}
+
+// CollectGarbage implements driver.GarbageCollector, reporting sandboxes
+// by their short IDs.
+func (d *Driver) CollectGarbage(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	gc, ok := d.backend.(driver.GarbageCollector)
+	if !ok {
+		return nil, driver.ErrNotImplemented
+	}
+	items, err := gc.CollectGarbage(ctx, dryRun)
+	for i, item := range items {
+		items[i] = d.shortItem(item, !dryRun)
+	}
+	return items, err
+}
+
+// SetReapHook implements driver.GarbageCollector.
+func (d *Driver) SetReapHook(fn func(driver.GCItem)) {
+	if gc, ok := d.backend.(driver.GarbageCollector); ok {
+		gc.SetReapHook(func(item driver.GCItem) {
+			fn(d.shortItem(item, true))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"context"
+	"time"
+)
+
+// StatsReader is implemented by drivers that can sample the resources a
+// sandbox uses.
+type StatsReader interface {
+	// Stats returns the current resource usage of a sandbox.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist and
+	// ErrSandboxNotRunning if it is not running.
+	Stats(ctx context.Context, id string) (*ResourceStats, error)
+}
+
+// ResourceStats is one sample of a sandbox's resource usage. Drivers leave
+// what they cannot measure zero, or nil for the CPU fields.
+type ResourceStats struct {
+	Time time.Time `json:"time"`
+
+	// CPUNanos is the CPU time the sandbox has used since it started
+	CPUNanos *uint64 `json:"cpu_ns,omitempty"`
+
+	// CPUPercent is the CPU used since the previous sample, 100 per fully
+	// used core. It is filled in by the control plane, which keeps the
+	// previous sample.
+	CPUPercent *float64 `json:"cpu_percent,omitempty"`
+
+	// MemoryBytes is the memory in use, not counting reclaimable page cache
+	MemoryBytes uint64 `json:"memory_bytes"`
+
+	// MemoryLimitBytes is the memory the sandbox may use before it is killed
+	MemoryLimitBytes uint64 `json:"memory_limit_bytes,omitempty"`
+
+	// PIDs is the number of processes and threads
+	PIDs uint64 `json:"pids,omitempty"`
+
+	// DiskBytes is the size of the files the sandbox wrote
+	DiskBytes uint64 `json:"disk_bytes,omitempty"`
+
+	// DiskReadBytes and DiskWriteBytes count block device I/O since the
+	// sandbox started
+	DiskReadBytes  uint64 `json:"disk_read_bytes,omitempty"`
+	DiskWriteBytes uint64 `json:"disk_write_bytes,omitempty"`
+}
+
+// DiskUsageReader is implemented by drivers that can measure the files
+// under directories of a sandbox.
+type DiskUsageReader interface {
+	// DiskUsage returns the usage of each of dirs, absolute paths in the
+	// sandbox, in order. A directory that does not exist uses nothing.
+	//
+	// Returns ErrSandboxNotFound if the sandbox doesn't exist and
+	// ErrSandboxNotRunning if the driver needs it running to look.
+	DiskUsage(ctx context.Context, id string, dirs []string) ([]DirUsage, error)
+}
+
+// DirUsage is the space the files under a directory of a sandbox take.
+type DirUsage struct {
+	Path string `json:"path"`
+
+	// UsedBytes is the size of the files under Path
+	UsedBytes int64 `json:"used_bytes"`
+
+	// Files is the number of regular files under Path
+	Files int64 `json:"files"`
+
+	// LimitBytes is the size of the filesystem holding Path when it is
+	// bounded for the sandbox alone, such as a tmpfs, and AvailableBytes
+	// what is left of it; both 0 if Path is only bounded by the host's
+	// disk
+	LimitBytes     int64 `json:"limit_bytes,omitempty"`
+	AvailableBytes int64 `json:"available_bytes,omitempty"`
+}
@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Errors returned by VolumeManager implementations.
+var (
+	// ErrVolumeNotFound indicates the named volume does not exist.
+	ErrVolumeNotFound = errors.New("volume not found")
+
+	// ErrVolumeExists indicates a volume with that name already exists.
+	ErrVolumeExists = errors.New("volume already exists")
+
+	// ErrVolumeInUse indicates live sandboxes still mount the volume.
+	ErrVolumeInUse = errors.New("volume in use")
+)
+
+// VolumeManager is implemented by drivers that keep persistent volumes:
+// named directories, such as datasets or model caches, that sandboxes
+// created with SandboxConfig.Volumes mount. Unlike workspaces, sandboxes
+// share a volume and their writes to it persist after they are gone.
+type VolumeManager interface {
+	// CreateVolume creates an empty volume carrying labels.
+	//
+	// Returns ErrVolumeExists if the name is taken.
+	CreateVolume(ctx context.Context, name string, labels map[string]string) (*VolumeInfo, error)
+
+	// GetVolume describes a volume.
+	//
+	// Returns ErrVolumeNotFound if it doesn't exist.
+	GetVolume(ctx context.Context, name string) (*VolumeInfo, error)
+
+	// ListVolumes returns all volumes.
+	ListVolumes(ctx context.Context) ([]*VolumeInfo, error)
+
+	// DeleteVolume removes a volume and its contents.
+	//
+	// Returns ErrVolumeNotFound if it doesn't exist and ErrVolumeInUse
+	// while sandboxes mount it.
+	DeleteVolume(ctx context.Context, name string) error
+}
+
+// VolumeInfo describes a persistent volume.
+type VolumeInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// SizeBytes is the size of its contents, -1 if unknown
+	SizeBytes int64 `json:"size_bytes"`
+
+	// InUse is the number of live sandboxes mounting the volume
+	InUse int `json:"in_use"`
+
+	// Labels are those the volume was created with, such as its owner
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// VolumeMount mounts a volume into a sandbox.
+type VolumeMount struct {
+	// Name is the volume's; see VolumeManager
+	Name string `json:"name"`
+
+	// MountPath is the absolute directory the volume is seen at
+	MountPath string `json:"mount_path"`
+
+	// ReadOnly keeps the sandbox from changing the volume
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// ValidateVolumeName checks that name can be used for a volume on every
+// driver, with the rules of ValidateWorkspaceName.
+func ValidateVolumeName(name string) error {
+	if !workspaceName.MatchString(name) {
+		return fmt.Errorf("%w: invalid volume name %q", ErrInvalidConfig, name)
+	}
+	return nil
+}
+
+// validateVolumes checks the volumes of c: valid names, and mount paths
+// that are absolute directories other than / and /output, each used once.
+func (c *SandboxConfig) validateVolumes() error {
+	paths := make(map[string]bool, len(c.Volumes))
+	for _, v := range c.Volumes {
+		if err := ValidateVolumeName(v.Name); err != nil {
+			return err
+		}
+		p := v.MountPath
+		if !path.IsAbs(p) || path.Clean(p) != p || p == "/" || p == "/output" {
+			return fmt.Errorf("%w: mount path %q of volume %s must be an absolute directory other than / and /output", ErrInvalidConfig, p, v.Name)
+		}
+		if paths[p] {
+			return fmt.Errorf("%w: two volumes are mounted at %s", ErrInvalidConfig, p)
+		}
+		if p == c.WorkDir && c.Workspace != "" {
+			return fmt.Errorf("%w: volume %s cannot be mounted at the working directory, which the workspace is", ErrInvalidConfig, v.Name)
+		}
+		paths[p] = true
+	}
+	return nil
+}
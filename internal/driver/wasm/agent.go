@@ -0,0 +1,621 @@
+package wasm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxArtifactSize bounds files returned inline as artifacts.
+const maxArtifactSize = 10 * 1024 * 1024
+
+// outputDir is watched for artifacts unless an exec asks for other paths.
+const outputDir = "/output"
+
+func instantiateWASI(r wazero.Runtime) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(context.Background(), r); err != nil {
+		return fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	return nil
+}
+
+// agent speaks the boxed-agent JSON-RPC protocol on one end of an in-memory
+// pipe, so the control plane talks to wasm sandboxes exactly like it talks
+// to the Rust agent in a container.
+type agent struct {
+	d    *WasmDriver
+	sb   *sandbox
+	conn net.Conn
+	// log adds to the sandbox's agent log, tagged with the agent's number
+	log *driver.LogWriter
+
+	// writeMu serialises responses and events
+	writeMu sync.Mutex
+
+	// stdinMu guards stdin, the inputs of the running REPLs by session;
+	// "" is that of the REPL started without one
+	stdinMu sync.Mutex
+	stdin   map[string]*io.PipeWriter
+
+	// procMu guards kill, which ends the module last started for each
+	// session while it runs; execs are session ""
+	procMu sync.Mutex
+	kill   map[string]*context.CancelCauseFunc
+
+	// hung is set by FaultAgentHang: the agent sends nothing more
+	hung atomic.Bool
+}
+
+// signalError is why a module was ended by proc.signal. Modules cannot
+// handle signals: any signal ends them, as it does a process that does not
+// catch it.
+type signalError int
+
+func (e signalError) Error() string {
+	return fmt.Sprintf("killed by signal %d", int(e))
+}
+
+// errExecCanceled is why a module was ended by exec.cancel.
+var errExecCanceled = errors.New("exec canceled")
+
+func newAgentConn(d *WasmDriver, sb *sandbox) io.ReadWriteCloser {
+	client, server := net.Pipe()
+	n := sb.agents.Add(1)
+	a := &agent{d: d, sb: sb, conn: server, log: sb.agentLog.Exec(strconv.FormatInt(n, 10)),
+		stdin: make(map[string]*io.PipeWriter), kill: make(map[string]*context.CancelCauseFunc)}
+	go a.serve(n == 1)
+	return client
+}
+
+// serve answers requests until the connection is closed. first is set for
+// the first agent of the sandbox.
+func (a *agent) serve(first bool) {
+	defer a.conn.Close()
+	if a.injectFaults(first) {
+		return
+	}
+
+	// Closing the connection aborts whatever is running; a REPL blocked
+	// reading its input sees the end of it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer a.closeStdins()
+
+	scanner := bufio.NewScanner(a.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req proto.Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			a.send(proto.NewErrorResponse(nil, proto.ParseError, "invalid JSON"))
+			continue
+		}
+
+		switch req.Method {
+		case "ping":
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+		case "exec":
+			var params proto.ExecParams
+			raw, _ := json.Marshal(req.Params)
+			if err := json.Unmarshal(raw, &params); err != nil || params.Cmd == "" {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidParams, "invalid exec params"))
+				continue
+			}
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+			go a.exec(ctx, params)
+		case "repl.start":
+			var params proto.ReplStartParams
+			raw, _ := json.Marshal(req.Params)
+			if err := json.Unmarshal(raw, &params); err != nil || params.Cmd == "" {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidParams, "invalid repl params"))
+				continue
+			}
+			// Input sent right after the start must find the REPL
+			stdin, err := a.openStdin(params.Session)
+			if err != nil {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidRequest, err.Error()))
+				continue
+			}
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+			go a.repl(ctx, params, stdin)
+		case "repl.input":
+			var params proto.ReplInputParams
+			raw, _ := json.Marshal(req.Params)
+			if err := json.Unmarshal(raw, &params); err != nil {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidParams, "invalid repl input"))
+				continue
+			}
+			// An empty write to the pipe would wait for the module to read
+			if params.Data != "" || !params.EOF {
+				if err := a.input(params.Session, params.Data); err != nil {
+					a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidParams, err.Error()))
+					continue
+				}
+			}
+			if params.EOF {
+				a.closeStdin(params.Session)
+			}
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+		case "proc.signal":
+			var params proto.SignalParams
+			raw, _ := json.Marshal(req.Params)
+			if err := json.Unmarshal(raw, &params); err != nil || params.Signal <= 0 {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidParams, "invalid signal"))
+				continue
+			}
+			if !a.signal(params.Session, params.Signal) {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidRequest, "no process is running"))
+				continue
+			}
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+		case proto.MethodExecCancel:
+			if !a.cancelExec() {
+				a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.InvalidRequest, "no exec is running"))
+				continue
+			}
+			a.reply(req.ID, proto.NewSuccessResponse(req.ID, nil))
+		default:
+			a.reply(req.ID, proto.NewErrorResponse(req.ID, proto.MethodNotFound,
+				fmt.Sprintf("method %q is not supported by the wasm driver", req.Method)))
+		}
+	}
+}
+
+func (a *agent) reply(id any, resp *proto.Response) {
+	if id != nil {
+		a.send(resp)
+	}
+}
+
+func (a *agent) send(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil || a.hung.Load() {
+		return
+	}
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	a.conn.Write(append(data, '\n'))
+}
+
+func (a *agent) event(method string, params map[string]any) {
+	a.send(proto.NewNotification(method, params))
+}
+
+// sessionEvent sends a notification of the REPL of session, which it
+// names unless it is the REPL started without one.
+func (a *agent) sessionEvent(session, method string, params map[string]any) {
+	if session != "" {
+		params["session"] = session
+	}
+	a.event(method, params)
+}
+
+// streamWriter turns module output into stdout/stderr notifications.
+type streamWriter struct {
+	a       *agent
+	method  string
+	session string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.a.sessionEvent(w.session, w.method, map[string]any{"chunk": string(p)})
+	return len(p), nil
+}
+
+func (a *agent) exec(ctx context.Context, p proto.ExecParams) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	var opts proto.ArtifactOptions
+	if p.Artifacts != nil {
+		opts = *p.Artifacts
+	}
+	roots := opts.WatchPaths
+	if len(roots) == 0 {
+		roots = []string{outputDir}
+	}
+
+	ctx, done := a.start(ctx, "")
+	defer done()
+
+	before := make(map[string]time.Time)
+	for _, root := range roots {
+		for path, mod := range snapshot(a.sb.hostPath(root)) {
+			before[path] = mod
+		}
+	}
+	a.log.Printf("exec %s", strings.Join(append([]string{p.Cmd}, p.Args...), " "))
+	started := time.Now()
+	// The module runs in-process: its span joins the control plane's trace
+	runCtx, span := tracing.Start(tracing.WithTraceparent(ctx, p.Traceparent), "wasm.run", attribute.String("boxed.cmd", p.Cmd))
+	code, err := a.d.run(runCtx, a.sb, p, bytes.NewReader(nil), &streamWriter{a: a, method: "stdout"}, &streamWriter{a: a, method: "stderr"})
+	exit := exitParams(ctx, code)
+	span.SetAttributes(attribute.Int("boxed.exit_code", exit["code"].(int)))
+	tracing.End(span, err)
+	if exit["signal"] != nil {
+		a.log.Printf("killed by signal %d after %s", exit["signal"], time.Since(started).Round(time.Millisecond))
+	} else if err != nil {
+		a.log.Printf("exec failed after %s: %v", time.Since(started).Round(time.Millisecond), err)
+		a.event("error", map[string]any{"message": err.Error(), "kind": errorKind(err)})
+	} else {
+		a.log.Printf("exited with code %d after %s", code, time.Since(started).Round(time.Millisecond))
+	}
+	a.sendArtifacts(roots, before, opts)
+	a.event("exit", exit)
+}
+
+// repl runs an interpreter reading its input from repl.input requests,
+// like the Rust agent does with a process's stdin. Output is streamed as it
+// is written; there is no artifact capture. REPLs of different sessions
+// run side by side.
+func (a *agent) repl(ctx context.Context, p proto.ReplStartParams, stdin *io.PipeReader) {
+	defer stdin.Close()
+	defer a.closeStdin(p.Session)
+
+	ctx, done := a.start(ctx, p.Session)
+	defer done()
+	// A module blocked reading its input does not notice ctx ending
+	defer context.AfterFunc(ctx, func() { stdin.Close() })()
+
+	a.log.Printf("repl %s", strings.Join(append([]string{p.Cmd}, p.Args...), " "))
+	started := time.Now()
+	exec := proto.ExecParams{Cmd: p.Cmd, Args: p.Args, Env: p.Env}
+	code, err := a.d.run(ctx, a.sb, exec, stdin, &streamWriter{a, "stdout", p.Session}, &streamWriter{a, "stderr", p.Session})
+	exit := exitParams(ctx, code)
+	if exit["signal"] != nil {
+		a.log.Printf("repl killed by signal %d after %s", exit["signal"], time.Since(started).Round(time.Millisecond))
+	} else if err != nil {
+		a.log.Printf("repl failed after %s: %v", time.Since(started).Round(time.Millisecond), err)
+		a.sessionEvent(p.Session, "error", map[string]any{"message": err.Error(), "kind": errorKind(err)})
+	} else {
+		a.log.Printf("repl exited with code %d after %s", code, time.Since(started).Round(time.Millisecond))
+	}
+	a.sessionEvent(p.Session, "exit", exit)
+}
+
+// start makes the module about to run on ctx the one proc.signal ends for
+// session. The returned func is called when it has returned.
+func (a *agent) start(ctx context.Context, session string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	a.procMu.Lock()
+	defer a.procMu.Unlock()
+	a.kill[session] = &cancel
+	return ctx, func() {
+		a.procMu.Lock()
+		defer a.procMu.Unlock()
+		cancel(nil)
+		if a.kill[session] == &cancel {
+			delete(a.kill, session)
+		}
+	}
+}
+
+// signal ends the running module of session, reporting whether there was
+// one.
+func (a *agent) signal(session string, sig int) bool {
+	a.procMu.Lock()
+	defer a.procMu.Unlock()
+	kill := a.kill[session]
+	if kill == nil {
+		return false
+	}
+	(*kill)(signalError(sig))
+	return true
+}
+
+// cancelExec ends the running exec, reporting whether there was one.
+func (a *agent) cancelExec() bool {
+	a.procMu.Lock()
+	defer a.procMu.Unlock()
+	kill := a.kill[""]
+	if kill == nil {
+		return false
+	}
+	(*kill)(errExecCanceled)
+	return true
+}
+
+// exitParams are those of the exit event of a module run on ctx that
+// returned code: as the Rust agent reports a process killed by a signal if
+// proc.signal ended it.
+func exitParams(ctx context.Context, code int) map[string]any {
+	var sig signalError
+	if errors.As(context.Cause(ctx), &sig) {
+		return map[string]any{"code": 128 + int(sig), "signal": int(sig), "reason": driver.ExitSignaled}
+	}
+	if errors.Is(context.Cause(ctx), errExecCanceled) {
+		return map[string]any{"code": 137, "signal": 9, "reason": driver.ExitCanceled}
+	}
+	return map[string]any{"code": code}
+}
+
+// openStdin sets up the input of the REPL of session, about to start.
+func (a *agent) openStdin(session string) (*io.PipeReader, error) {
+	a.stdinMu.Lock()
+	defer a.stdinMu.Unlock()
+	if a.stdin[session] != nil {
+		if session == "" {
+			return nil, errors.New("a REPL is already running on this connection")
+		}
+		return nil, fmt.Errorf("a REPL is already running for session %s", session)
+	}
+	pr, pw := io.Pipe()
+	a.stdin[session] = pw
+	return pr, nil
+}
+
+// input writes data to the running REPL of session. Like writing to a
+// process's stdin it blocks until the interpreter reads.
+func (a *agent) input(session, data string) error {
+	a.stdinMu.Lock()
+	w := a.stdin[session]
+	a.stdinMu.Unlock()
+	if w == nil {
+		if session == "" {
+			return errors.New("no REPL is running")
+		}
+		return fmt.Errorf("no REPL is running for session %s", session)
+	}
+	_, err := io.WriteString(w, data)
+	return err
+}
+
+func (a *agent) closeStdin(session string) {
+	a.stdinMu.Lock()
+	defer a.stdinMu.Unlock()
+	if w := a.stdin[session]; w != nil {
+		w.Close()
+		delete(a.stdin, session)
+	}
+}
+
+func (a *agent) closeStdins() {
+	a.stdinMu.Lock()
+	defer a.stdinMu.Unlock()
+	for session, w := range a.stdin {
+		w.Close()
+		delete(a.stdin, session)
+	}
+}
+
+// kindError is an error of run that its error event reports as kind.
+type kindError struct {
+	kind string
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+func (e *kindError) Unwrap() error { return e.err }
+
+// errorKind is the kind of the error event for an error of run.
+func errorKind(err error) string {
+	var ke *kindError
+	switch {
+	case errors.As(err, &ke):
+		return ke.kind
+	case errors.Is(err, driver.ErrTimeout):
+		return proto.ErrorTimedOut
+	}
+	return proto.ErrorInternal
+}
+
+// run executes cmd as a WASI module in the sandbox and returns its exit code.
+func (d *WasmDriver) run(ctx context.Context, sb *sandbox, p proto.ExecParams, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if p.User != "" {
+		return -1, &kindError{proto.ErrorPermissionDenied, errors.New("the wasm driver cannot run code as another user")}
+	}
+	cwd := sb.cfg.WorkDir
+	if p.Cwd != "" {
+		// WASI has no working directory: modules find it in $PWD
+		cwd = sb.sandboxPath(p.Cwd)
+		if !isDir(sb.hostPath(cwd)) {
+			return -1, &kindError{proto.ErrorCwdNotFound, fmt.Errorf("working directory %s does not exist", cwd)}
+		}
+	}
+
+	sb.mu.Lock()
+	r := sb.runtime
+	if r == nil {
+		sb.mu.Unlock()
+		return -1, driver.ErrSandboxNotRunning
+	}
+	compiled, err := d.module(ctx, sb, p.Cmd)
+	sb.mu.Unlock()
+	if err != nil {
+		return -1, err
+	}
+
+	fsConfig := wazero.NewFSConfig().WithDirMount(sb.root, "/")
+	if lib := filepath.Join(d.modulesDir, "lib"); isDir(lib) {
+		fsConfig = fsConfig.WithReadOnlyDirMount(lib, "/usr/local/lib")
+	}
+	for mountPath, m := range sb.mounts {
+		if m.readOnly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(m.dir, mountPath)
+		} else {
+			fsConfig = fsConfig.WithDirMount(m.dir, mountPath)
+		}
+	}
+
+	config := wazero.NewModuleConfig().
+		WithName(""). // anonymous, so executions can run concurrently
+		WithArgs(append([]string{p.Cmd}, p.Args...)...).
+		WithStdin(stdin).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader).
+		WithEnv("HOME", "/tmp").
+		WithEnv("PWD", cwd)
+	for k, v := range sb.cfg.Env {
+		config = config.WithEnv(k, v)
+	}
+	for k, v := range p.Env {
+		config = config.WithEnv(k, v)
+	}
+
+	mod, err := r.InstantiateModule(sb.withMemoryAccounting(ctx), compiled, config)
+	if mod != nil {
+		mod.Close(context.Background())
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		if ctx.Err() != nil {
+			return -1, driver.ErrTimeout
+		}
+		return int(exitErr.ExitCode()), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// module returns the compiled interpreter for cmd. Callers hold sb.mu.
+func (d *WasmDriver) module(ctx context.Context, sb *sandbox, cmd string) (wazero.CompiledModule, error) {
+	name := filepath.Base(cmd)
+	if m, ok := sb.modules[name]; ok {
+		return m, nil
+	}
+
+	path := filepath.Join(d.modulesDir, name+".wasm")
+	bin, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &kindError{proto.ErrorCommandNotFound, fmt.Errorf("no WASI module for %q (expected %s)", cmd, path)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := sb.runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", path, err)
+	}
+	sb.modules[name] = m
+	return m, nil
+}
+
+// snapshot records modification times of the files under dir.
+func snapshot(dir string) map[string]time.Time {
+	files := make(map[string]time.Time)
+	filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return nil
+		}
+		if info, err := e.Info(); err == nil {
+			files[path] = info.ModTime()
+		}
+		return nil
+	})
+	return files
+}
+
+// sendArtifacts emits files under roots created or modified since before
+// that pass the size and MIME filters of opts.
+func (a *agent) sendArtifacts(roots []string, before map[string]time.Time, opts proto.ArtifactOptions) {
+	limit := opts.MaxSize
+	if opts.Delivery != proto.DeliveryURL && (limit <= 0 || limit > maxArtifactSize) {
+		limit = maxArtifactSize
+	}
+
+	sent := make(map[string]bool)
+	for _, root := range roots {
+		for path, mod := range snapshot(a.sb.hostPath(root)) {
+			if prev, ok := before[path]; (ok && !mod.After(prev)) || sent[path] {
+				continue
+			}
+			sent[path] = true
+
+			info, err := os.Stat(path)
+			if err != nil || (limit > 0 && info.Size() > limit) {
+				continue
+			}
+			mimeType := mime.TypeByExtension(filepath.Ext(path))
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			if !mimeAllowed(opts.MIMETypes, mimeType) {
+				continue
+			}
+
+			rel, _ := filepath.Rel(a.sb.root, path)
+			params := map[string]any{
+				"path": artifactPath("/" + filepath.ToSlash(rel)),
+				"mime": mimeType,
+				"size": info.Size(),
+			}
+			if opts.Delivery != proto.DeliveryURL {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					log.Warn().Err(err).Str("path", path).Msg("Failed to read artifact")
+					continue
+				}
+				params["data_base64"] = base64.StdEncoding.EncodeToString(data)
+			}
+			a.event("artifact", params)
+		}
+	}
+}
+
+// artifactPath reports paths under /output relative to it, like the Rust
+// agent; other paths stay absolute.
+func artifactPath(p string) string {
+	if rel, ok := strings.CutPrefix(p, outputDir+"/"); ok {
+		return rel
+	}
+	return p
+}
+
+// mimeAllowed matches mimeType, without parameters, against exact types and
+// "type/*" wildcards. An empty list allows everything.
+func mimeAllowed(patterns []string, mimeType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.TrimSpace(mimeType)
+	kind, _, _ := strings.Cut(mimeType, "/")
+	for _, p := range patterns {
+		if p == "*" || p == "*/*" || strings.EqualFold(p, mimeType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && strings.EqualFold(prefix, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,31 @@
+package wasm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// Describe implements driver.Describer. Interpreters are the modules in the
+// modules directory; their versions and packages are not known.
+func (d *WasmDriver) Describe(ctx context.Context, id string) (*driver.Environment, error) {
+	if _, err := d.get(id); err != nil {
+		return nil, err
+	}
+
+	env := &driver.Environment{OS: "WASI", Interpreters: []driver.Interpreter{}, Packages: []driver.Package{}}
+	entries, err := os.ReadDir(d.modulesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".wasm" {
+			continue
+		}
+		env.Interpreters = append(env.Interpreters, driver.Interpreter{Command: strings.TrimSuffix(e.Name(), ".wasm")})
+	}
+	return env, nil
+}
@@ -0,0 +1,48 @@
+package wasm
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Faults that cfg["faults"] can inject, for integration tests of how agent
+// failures are handled. Each takes an optional delay, e.g. "agent_crash=1s".
+const (
+	// FaultAgentCrash makes the first agent of each sandbox exit a delay
+	// after it starts (default 0: before it answers anything).
+	FaultAgentCrash = "agent_crash"
+
+	// FaultAgentHang makes the first agent of each sandbox stop answering
+	// a delay after it starts (default 0).
+	FaultAgentHang = "agent_hang"
+)
+
+var faultDefaults = map[string]time.Duration{
+	FaultAgentCrash: 0,
+	FaultAgentHang:  0,
+}
+
+// injectFaults sets up the faults of a, if it is the first agent of its
+// sandbox. It reports whether a is to exit at once.
+func (a *agent) injectFaults(first bool) bool {
+	if !first {
+		return false
+	}
+	if delay, ok := a.d.faults[FaultAgentCrash]; ok {
+		log.Warn().Str("id", a.sb.id).Dur("delay", delay).Msg("Fault injected: crashing agent")
+		if delay == 0 {
+			return true
+		}
+		time.AfterFunc(delay, func() { a.conn.Close() })
+	}
+	if delay, ok := a.d.faults[FaultAgentHang]; ok {
+		log.Warn().Str("id", a.sb.id).Dur("delay", delay).Msg("Fault injected: hanging agent")
+		if delay == 0 {
+			a.hung.Store(true)
+		} else {
+			time.AfterFunc(delay, func() { a.hung.Store(true) })
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// sandboxPath cleans p into an absolute path inside the sandbox. Relative
+// paths are resolved against the work dir; ".." cannot escape the root.
+func (sb *sandbox) sandboxPath(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(sb.cfg.WorkDir, p)
+	}
+	return path.Clean("/" + p)
+}
+
+// hostPath maps a sandbox path onto the host; see resolve.
+func (sb *sandbox) hostPath(p string) string {
+	host, _ := sb.resolve(p)
+	return host
+}
+
+// ListFiles implements driver.Driver. Like the docker driver it lists the
+// path recursively, with names relative to its parent directory.
+func (d *WasmDriver) ListFiles(ctx context.Context, id, p string) ([]*driver.FileEntry, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	target := sb.hostPath(p)
+	base := filepath.Dir(target)
+
+	var entries []*driver.FileEntry
+	err = filepath.WalkDir(target, func(hostPath string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(base, hostPath)
+		entries = append(entries, &driver.FileEntry{
+			Name:         e.Name(),
+			Path:         filepath.ToSlash(rel),
+			Size:         info.Size(),
+			Mode:         int64(info.Mode().Perm()),
+			IsDir:        e.IsDir(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read path: %w", err)
+	}
+	return entries, nil
+}
+
+// PutFile implements driver.Driver.
+func (d *WasmDriver) PutFile(ctx context.Context, id, p string, content io.Reader) error {
+	sb, err := d.get(id)
+	if err != nil {
+		return err
+	}
+	return sb.writeFile(p, content)
+}
+
+// GetFile implements driver.Driver.
+func (d *WasmDriver) GetFile(ctx context.Context, id, p string) (io.ReadCloser, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(sb.hostPath(p))
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %w", err)
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", p)
+	}
+	return f, nil
+}
+
+// writeFile writes content to the sandbox path p, which must not be on a
+// read-only volume.
+func (sb *sandbox) writeFile(p string, content io.Reader) error {
+	target, readOnly := sb.resolve(p)
+	if readOnly {
+		return fmt.Errorf("%s is on a read-only volume", sb.sandboxPath(p))
+	}
+	return writeHostFile(target, content)
+}
+
+// writeFile writes content to the sandbox path p under root, creating
+// parent directories.
+func writeFile(root, p string, content io.Reader) error {
+	return writeHostFile(filepath.Join(root, filepath.FromSlash(path.Clean("/"+p))), content)
+}
+
+func writeHostFile(target string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
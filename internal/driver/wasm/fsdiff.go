@@ -0,0 +1,94 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// stamp is what FSDiff compares of a path to tell whether it changed.
+type stamp struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+}
+
+// untracked are the paths that, like the mounts of the docker driver, are
+// left out of snapshots and diffs ("/tmp" for root/tmp).
+func (sb *sandbox) untracked() map[string]bool {
+	skip := map[string]bool{"/tmp": true, "/output": true}
+	if sb.cfg.Workspace != "" {
+		skip[sb.cfg.WorkDir] = true
+	}
+	return skip
+}
+
+// stamps records the paths under the sandbox's root that FSDiff tracks.
+func (sb *sandbox) stamps() (map[string]stamp, error) {
+	skip := sb.untracked()
+	stamps := make(map[string]stamp)
+	err := filepath.WalkDir(sb.root, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(sb.root, p)
+		if rel == "." {
+			return nil
+		}
+		name := "/" + filepath.ToSlash(rel)
+		if skip[name] {
+			return filepath.SkipDir
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		stamps[name] = stamp{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return stamps, err
+}
+
+// FSDiff implements driver.FSDiffer by comparing the sandbox's root with
+// the image it was created from, as recorded by Create.
+func (d *WasmDriver) FSDiff(ctx context.Context, id string) ([]driver.FSChange, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	now, err := sb.stamps()
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff sandbox: %w", err)
+	}
+	var changes []driver.FSChange
+	for name, s := range now {
+		before, ok := sb.image[name]
+		switch {
+		case !ok:
+			changes = append(changes, driver.FSChange{Path: name, Kind: driver.FSAdded})
+		case before != s:
+			changes = append(changes, driver.FSChange{Path: name, Kind: driver.FSChanged})
+		}
+	}
+	for name := range sb.image {
+		// Report removed directories but not their contents
+		if _, ok := now[name]; ok {
+			continue
+		}
+		if _, ok := sb.image[path.Dir(name)]; ok {
+			if _, kept := now[path.Dir(name)]; !kept {
+				continue
+			}
+		}
+		changes = append(changes, driver.FSChange{Path: name, Kind: driver.FSRemoved})
+	}
+	slices.SortFunc(changes, func(a, b driver.FSChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
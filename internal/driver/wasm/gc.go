@@ -0,0 +1,79 @@
+package wasm
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/rs/zerolog/log"
+)
+
+// CollectGarbage implements driver.GarbageCollector. Orphans are sandbox
+// roots under root_dir that no live sandbox owns, left behind when a
+// previous process exited without stopping its sandboxes.
+func (d *WasmDriver) CollectGarbage(ctx context.Context, dryRun bool) ([]driver.GCItem, error) {
+	entries, err := os.ReadDir(d.rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []driver.GCItem
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), "wasm-") {
+			continue
+		}
+		d.mu.Lock()
+		_, live := d.sandboxes[e.Name()]
+		d.mu.Unlock()
+		// Roots newer than the driver may belong to a create in flight
+		if info, err := e.Info(); live || err != nil || !info.ModTime().Before(d.startedAt) {
+			continue
+		}
+
+		root := filepath.Join(d.rootDir, e.Name())
+		item := driver.GCItem{
+			Kind:   "directory",
+			ID:     root,
+			Reason: driver.GCReasonOrphaned,
+			Bytes:  diskUsage(root),
+			At:     time.Now(),
+		}
+		if !dryRun {
+			if err := os.RemoveAll(root); err != nil {
+				log.Warn().Err(err).Str("dir", root).Msg("Failed to remove orphaned sandbox root")
+				item.Error = err.Error()
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// expire stops a sandbox whose TTL elapsed and reports it.
+func (d *WasmDriver) expire(sb *sandbox) {
+	item := driver.GCItem{Kind: "sandbox", ID: sb.id, Reason: driver.GCReasonExpired, Bytes: diskUsage(sb.root)}
+	if err := d.Stop(context.Background(), sb.id); err != nil {
+		item.Error = err.Error()
+	}
+	item.At = time.Now()
+	d.Reaped(item)
+}
+
+// diskUsage sums the sizes of the regular files under dir.
+func diskUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || !e.Type().IsRegular() {
+			return nil
+		}
+		if info, err := e.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
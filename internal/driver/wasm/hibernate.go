@@ -0,0 +1,64 @@
+package wasm
+
+import (
+	"context"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/tetratelabs/wazero"
+)
+
+// Hibernate implements driver.Hibernator by closing the sandbox's runtime,
+// which releases the memory of its modules, and keeping its root. Like
+// running ones, hibernated sandboxes do not outlive the driver.
+func (d *WasmDriver) Hibernate(ctx context.Context, id string) error {
+	sb, err := d.get(id)
+	if err != nil {
+		return err
+	}
+	sb.mu.Lock()
+	if sb.runtime == nil {
+		sb.mu.Unlock()
+		return driver.ErrSandboxNotRunning
+	}
+	// Also aborts running executions; compiled modules go with the runtime
+	sb.runtime.Close(context.Background())
+	sb.runtime = nil
+	sb.modules = make(map[string]wazero.CompiledModule)
+	sb.state = driver.StateHibernated
+	sb.mu.Unlock()
+
+	d.mu.Lock()
+	if sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// Wake implements driver.Hibernator.
+func (d *WasmDriver) Wake(ctx context.Context, id string, ttl time.Duration) error {
+	sb, err := d.get(id)
+	if err != nil {
+		return err
+	}
+	sb.mu.Lock()
+	state := sb.state
+	sb.mu.Unlock()
+	switch state {
+	case driver.StateHibernated:
+	case driver.StateReady:
+		return driver.ErrSandboxAlreadyRunning
+	default:
+		return driver.ErrSandboxNotRunning
+	}
+
+	// Start leaves the state alone when it fails
+	if err := d.Start(ctx, id); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.armTTL(sb, ttl)
+	d.mu.Unlock()
+	return nil
+}
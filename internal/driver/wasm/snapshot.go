@@ -0,0 +1,114 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// imagesDir holds one directory per image Snapshot saved under root_dir,
+// which garbage collection leaves alone like workspacesDir.
+const imagesDir = "images"
+
+func (d *WasmDriver) imagePath(ref string) string {
+	return filepath.Join(d.rootDir, imagesDir, url.PathEscape(ref))
+}
+
+// Snapshot implements driver.Snapshotter by copying the sandbox's root,
+// which sandboxes created with ref as their image start from. The wasm
+// driver keeps no image metadata, so labels are dropped. Like the docker
+// driver it leaves out /tmp, /output and the working directory of a
+// workspace.
+func (d *WasmDriver) Snapshot(ctx context.Context, id, ref string, labels map[string]string) error {
+	sb, err := d.get(id)
+	if err != nil {
+		return err
+	}
+	dir := d.imagePath(ref)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create images directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to snapshot sandbox: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := copyTree(tmp, sb.root, sb.untracked()); err != nil {
+		return fmt.Errorf("failed to snapshot sandbox: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to replace image %s: %w", ref, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("failed to save image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// HasImage implements driver.Snapshotter.
+func (d *WasmDriver) HasImage(ctx context.Context, ref string) (bool, error) {
+	_, err := os.Stat(d.imagePath(ref))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// copyImage fills a new sandbox root with the image ref saved by Snapshot,
+// if there is one.
+func (d *WasmDriver) copyImage(ref, root string) error {
+	src := d.imagePath(ref)
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return copyTree(root, src, nil)
+}
+
+// copyTree copies the directories and regular files under src to dst,
+// leaving out the paths in skip ("/tmp" for src/tmp).
+func copyTree(dst, src string, skip map[string]bool) error {
+	return filepath.WalkDir(src, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if skip["/"+filepath.ToSlash(rel)] {
+			return filepath.SkipDir
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case e.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(target, path, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(dst, src string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
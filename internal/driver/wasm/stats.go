@@ -0,0 +1,98 @@
+package wasm
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// Stats implements driver.StatsReader. Memory is the linear memory of the
+// modules running in the sandbox and disk the size of its root. Modules run
+// on goroutines of this process, so there is no CPU time to report.
+func (d *WasmDriver) Stats(ctx context.Context, id string) (*driver.ResourceStats, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	sb.mu.Lock()
+	running := sb.runtime != nil
+	sb.mu.Unlock()
+	if !running {
+		return nil, driver.ErrSandboxNotRunning
+	}
+
+	return &driver.ResourceStats{
+		Time:             time.Now(),
+		MemoryBytes:      uint64(sb.memory.Load()),
+		MemoryLimitBytes: uint64(sb.cfg.MemoryMB) * 1024 * 1024,
+		DiskBytes:        uint64(diskUsage(sb.root)),
+	}, nil
+}
+
+// withMemoryAccounting returns ctx making modules instantiated with it
+// count their linear memory in sb.memory.
+func (sb *sandbox) withMemoryAccounting(ctx context.Context) context.Context {
+	return experimental.WithMemoryAllocator(ctx, experimental.MemoryAllocatorFunc(func(capacity, limit uint64) experimental.LinearMemory {
+		return &countedMemory{buf: make([]byte, 0, capacity), max: limit, used: &sb.memory}
+	}))
+}
+
+// countedMemory is a linear memory that keeps used up to date with its
+// length.
+type countedMemory struct {
+	buf  []byte
+	max  uint64
+	used *atomic.Int64
+}
+
+// Reallocate implements experimental.LinearMemory.
+func (m *countedMemory) Reallocate(size uint64) []byte {
+	if size > m.max {
+		return nil
+	}
+	m.used.Add(int64(size) - int64(len(m.buf)))
+	if size > uint64(cap(m.buf)) {
+		// Grow geometrically, as append would, but never past max
+		buf := make([]byte, size, min(max(size, 2*uint64(cap(m.buf))), m.max))
+		copy(buf, m.buf)
+		m.buf = buf
+	}
+	m.buf = m.buf[:size]
+	return m.buf
+}
+
+// Free implements experimental.LinearMemory.
+func (m *countedMemory) Free() {
+	m.used.Add(-int64(len(m.buf)))
+	m.buf = nil
+}
+
+// DiskUsage implements driver.DiskUsageReader by walking the directories
+// on the host, which works whether the sandbox is running or not. Nothing
+// bounds a sandbox's files but the host's disk.
+func (d *WasmDriver) DiskUsage(ctx context.Context, id string, dirs []string) ([]driver.DirUsage, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]driver.DirUsage, len(dirs))
+	for i, dir := range dirs {
+		usage[i].Path = dir
+		filepath.WalkDir(sb.hostPath(dir), func(path string, e fs.DirEntry, err error) error {
+			if err != nil || !e.Type().IsRegular() {
+				return nil
+			}
+			if info, err := e.Info(); err == nil {
+				usage[i].UsedBytes += info.Size()
+				usage[i].Files++
+			}
+			return nil
+		})
+	}
+	return usage, nil
+}
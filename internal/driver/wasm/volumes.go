@@ -0,0 +1,198 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// volumesDir holds one directory per volume under root_dir, which garbage
+// collection leaves alone like workspacesDir.
+const volumesDir = "volumes"
+
+func (d *WasmDriver) volumePath(name string) string {
+	return filepath.Join(d.rootDir, volumesDir, name)
+}
+
+// labelsDir holds the labels of the volumes or workspaces under dir, one
+// JSON file each. Its name is no valid volume or workspace name, so it is
+// not listed as one.
+const labelsDir = ".labels"
+
+func (d *WasmDriver) labelsPath(dir, name string) string {
+	return filepath.Join(d.rootDir, dir, labelsDir, name+".json")
+}
+
+// writeLabels keeps the labels of the volume or workspace name under dir.
+func (d *WasmDriver) writeLabels(dir, name string, labels map[string]string) error {
+	path := d.labelsPath(dir, name)
+	if len(labels) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readLabels returns the labels of the volume or workspace name under dir,
+// nil if it has none.
+func (d *WasmDriver) readLabels(dir, name string) map[string]string {
+	data, err := os.ReadFile(d.labelsPath(dir, name))
+	if err != nil {
+		return nil
+	}
+	var labels map[string]string
+	json.Unmarshal(data, &labels)
+	return labels
+}
+
+// mount is a volume mounted into a sandbox.
+type mount struct {
+	// dir is the volume's directory on the host
+	dir      string
+	readOnly bool
+}
+
+// CreateVolume implements driver.VolumeManager with a directory that the
+// sandboxes mounting it share.
+func (d *WasmDriver) CreateVolume(ctx context.Context, name string, labels map[string]string) (*driver.VolumeInfo, error) {
+	if err := driver.ValidateVolumeName(name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(d.rootDir, volumesDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create volumes directory: %w", err)
+	}
+
+	d.volumeMu.Lock()
+	defer d.volumeMu.Unlock()
+	if err := os.Mkdir(d.volumePath(name), 0755); errors.Is(err, fs.ErrExist) {
+		return nil, driver.ErrVolumeExists
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+	if err := d.writeLabels(volumesDir, name, labels); err != nil {
+		os.Remove(d.volumePath(name))
+		return nil, fmt.Errorf("failed to create volume: %w", err)
+	}
+	return d.volumeInfo(name)
+}
+
+// GetVolume implements driver.VolumeManager.
+func (d *WasmDriver) GetVolume(ctx context.Context, name string) (*driver.VolumeInfo, error) {
+	d.volumeMu.RLock()
+	defer d.volumeMu.RUnlock()
+	return d.volumeInfo(name)
+}
+
+// ListVolumes implements driver.VolumeManager.
+func (d *WasmDriver) ListVolumes(ctx context.Context) ([]*driver.VolumeInfo, error) {
+	d.volumeMu.RLock()
+	defer d.volumeMu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(d.rootDir, volumesDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var results []*driver.VolumeInfo
+	for _, e := range entries {
+		if !e.IsDir() || driver.ValidateVolumeName(e.Name()) != nil {
+			continue
+		}
+		if info, err := d.volumeInfo(e.Name()); err == nil {
+			results = append(results, info)
+		}
+	}
+	return results, nil
+}
+
+// DeleteVolume implements driver.VolumeManager.
+func (d *WasmDriver) DeleteVolume(ctx context.Context, name string) error {
+	d.volumeMu.Lock()
+	defer d.volumeMu.Unlock()
+	info, err := d.volumeInfo(name)
+	if err != nil {
+		return err
+	}
+	if info.InUse > 0 {
+		return driver.ErrVolumeInUse
+	}
+	if err := os.RemoveAll(d.volumePath(name)); err != nil {
+		return err
+	}
+	return d.writeLabels(volumesDir, name, nil)
+}
+
+// volumeInfo must be called with volumeMu held.
+func (d *WasmDriver) volumeInfo(name string) (*driver.VolumeInfo, error) {
+	if driver.ValidateVolumeName(name) != nil {
+		return nil, fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, name)
+	}
+	dir := d.volumePath(name)
+	stat, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := &driver.VolumeInfo{Name: name, CreatedAt: stat.ModTime(), SizeBytes: diskUsage(dir), Labels: d.readLabels(volumesDir, name)}
+	d.mu.Lock()
+	for _, sb := range d.sandboxes {
+		if slices.ContainsFunc(sb.cfg.Volumes, func(v driver.VolumeMount) bool { return v.Name == name }) {
+			info.InUse++
+		}
+	}
+	d.mu.Unlock()
+	return info, nil
+}
+
+// volumeMounts returns the mounts of the volumes of a sandbox by mount
+// path, and creates their mount points in its root. volumeMu must be held,
+// so the volumes cannot be deleted before the sandbox is registered.
+func (d *WasmDriver) volumeMounts(sb *sandbox) (map[string]mount, error) {
+	mounts := make(map[string]mount, len(sb.cfg.Volumes))
+	for _, v := range sb.cfg.Volumes {
+		dir := d.volumePath(v.Name)
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", driver.ErrVolumeNotFound, v.Name)
+		}
+		if err := os.MkdirAll(filepath.Join(sb.root, filepath.FromSlash(v.MountPath)), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create mount point %s: %w", v.MountPath, err)
+		}
+		mounts[v.MountPath] = mount{dir: dir, readOnly: v.ReadOnly}
+	}
+	return mounts, nil
+}
+
+// resolve maps a sandbox path onto the host: into the innermost volume
+// mounted over it, if any, or else the sandbox root.
+func (sb *sandbox) resolve(p string) (host string, readOnly bool) {
+	p = sb.sandboxPath(p)
+	host = filepath.Join(sb.root, filepath.FromSlash(p))
+	longest := ""
+	for mountPath, m := range sb.mounts {
+		rel, ok := strings.CutPrefix(p, mountPath)
+		if ok && (rel == "" || rel[0] == '/') && len(mountPath) > len(longest) {
+			host, readOnly, longest = filepath.Join(m.dir, filepath.FromSlash(rel)), m.readOnly, mountPath
+		}
+	}
+	return host, readOnly
+}
@@ -0,0 +1,442 @@
+// Package wasm implements a driver that runs code as WASI modules in-process
+// using wazero. There is no container or VM: each sandbox is a directory on
+// the host mounted as the module's root filesystem, which gives millisecond
+// cold starts and no Docker dependency.
+//
+// Interpreters are WASI builds of the language runtime, looked up as
+// <modules_dir>/<cmd>.wasm (e.g. wasm/python3.wasm). If <modules_dir>/lib
+// exists it is mounted read-only at /usr/local/lib, which is where the
+// standard library of common WASI Python builds is expected.
+//
+// Only workloads that fit WASI fit this driver: no subprocesses, no
+// sockets. A REPL reads its input from a pipe, not a terminal.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+)
+
+const DriverName = "wasm"
+
+// WasmDriver implements driver.Driver with wazero.
+type WasmDriver struct {
+	// modulesDir holds the <cmd>.wasm interpreters
+	modulesDir string
+	// rootDir holds one directory per sandbox
+	rootDir string
+	// cache shares compiled modules between sandbox runtimes
+	cache wazero.CompilationCache
+	// startedAt separates roots left by earlier processes from new ones
+	startedAt time.Time
+
+	// ReapNotifier reports TTL expiries
+	driver.ReapNotifier
+
+	// health checks and restarts the agents Connect starts
+	health driver.AgentHealth
+	// faults are failures injected for tests; see faults.go
+	faults map[string]time.Duration
+
+	mu        sync.Mutex
+	sandboxes map[string]*sandbox
+
+	// workspaceMu keeps workspaces from being removed while being copied
+	workspaceMu sync.RWMutex
+	// volumeMu keeps volumes from being removed while sandboxes mounting
+	// them are created
+	volumeMu sync.RWMutex
+}
+
+// sandbox is one virtual root plus the runtime executing in it.
+type sandbox struct {
+	id        string
+	cfg       driver.SandboxConfig
+	root      string
+	createdAt time.Time
+	state     driver.SandboxState
+	ttl       *time.Timer
+
+	// runtime is created by Start and closed by Stop
+	runtime wazero.Runtime
+	// modules caches compiled interpreters by command name
+	modules map[string]wazero.CompiledModule
+	mu      sync.Mutex
+
+	// mounts are the volumes mounted into the root, by mount path
+	mounts map[string]mount
+
+	// image records the root as copied from the image, for FSDiff
+	image map[string]stamp
+
+	// agentLog records what the in-process agent did
+	agentLog *driver.LogBuffer
+
+	// memory is the linear memory of the running modules, in bytes
+	memory atomic.Int64
+
+	// agents counts the agents Connect started, agentCrashes those that
+	// crashed
+	agents       atomic.Int64
+	agentCrashes atomic.Int64
+}
+
+// New creates a WasmDriver.
+// cfg["modules_dir"] sets where interpreters are found (default: $BOXED_WASM_MODULES or ./wasm).
+// cfg["root_dir"] sets where sandbox roots are created (default: a temp directory).
+// cfg["faults"] injects agent failures for tests; see faults.go.
+// See driver.AgentHealthConfig for the settings of agent health checks.
+func New(cfg map[string]any) (driver.Driver, error) {
+	modulesDir := os.Getenv("BOXED_WASM_MODULES")
+	if p, ok := cfg["modules_dir"].(string); ok {
+		modulesDir = p
+	}
+	if modulesDir == "" {
+		modulesDir = "wasm"
+	}
+	modulesDir, err := filepath.Abs(modulesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rootDir, _ := cfg["root_dir"].(string)
+	if rootDir == "" {
+		rootDir = filepath.Join(os.TempDir(), "boxed-wasm")
+	}
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sandbox root: %w", err)
+	}
+
+	faultSpec, _ := cfg["faults"].(string)
+	faults, err := driver.ParseFaults(faultSpec, faultDefaults)
+	if err != nil {
+		return nil, err
+	}
+	if len(faults) > 0 {
+		log.Warn().Str("faults", faultSpec).Msg("Test fault injection enabled: do not use in production")
+	}
+
+	return &WasmDriver{
+		modulesDir: modulesDir,
+		rootDir:    rootDir,
+		cache:      wazero.NewCompilationCache(),
+		startedAt:  time.Now(),
+		health:     driver.AgentHealthConfig(cfg),
+		faults:     faults,
+		sandboxes:  make(map[string]*sandbox),
+	}, nil
+}
+
+func init() {
+	driver.RegisterDriver(DriverName, New)
+}
+
+func (d *WasmDriver) DriverName() string {
+	return DriverName
+}
+
+// Healthy checks that the sandbox root is writable.
+func (d *WasmDriver) Healthy(ctx context.Context) error {
+	f, err := os.CreateTemp(d.rootDir, ".health-")
+	if err != nil {
+		return fmt.Errorf("sandbox root not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (d *WasmDriver) Close() error {
+	d.mu.Lock()
+	ids := make([]string, 0, len(d.sandboxes))
+	for id := range d.sandboxes {
+		ids = append(ids, id)
+	}
+	d.mu.Unlock()
+
+	// Sandboxes live in this process: they cannot outlive the driver.
+	for _, id := range ids {
+		d.Stop(context.Background(), id)
+	}
+	return d.cache.Close(context.Background())
+}
+
+func (d *WasmDriver) get(id string) (*sandbox, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sb, ok := d.sandboxes[id]
+	if !ok {
+		return nil, driver.ErrSandboxNotFound
+	}
+	return sb, nil
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "wasm-" + hex.EncodeToString(b)
+}
+
+func (d *WasmDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	if err := cfg.Validate(); err != nil {
+		return "", err
+	}
+	if cfg.GPU != nil {
+		return "", fmt.Errorf("%w: the wasm driver has no GPUs", driver.ErrInvalidConfig)
+	}
+	if cfg.NetworkGroup != "" || cfg.CustomDNS() {
+		return "", fmt.Errorf("%w: the wasm driver has no network", driver.ErrInvalidConfig)
+	}
+
+	sb := &sandbox{
+		id:        newID(),
+		cfg:       cfg,
+		createdAt: time.Now(),
+		state:     driver.StateCreating,
+		modules:   make(map[string]wazero.CompiledModule),
+		agentLog:  driver.NewLogBuffer(driver.LogSourceAgent, 0),
+	}
+	sb.root = filepath.Join(d.rootDir, sb.id)
+
+	if err := d.copyImage(cfg.Image, sb.root); err != nil {
+		os.RemoveAll(sb.root)
+		return "", fmt.Errorf("failed to copy image %s: %w", cfg.Image, err)
+	}
+	image, err := sb.stamps()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		os.RemoveAll(sb.root)
+		return "", fmt.Errorf("failed to copy image %s: %w", cfg.Image, err)
+	}
+	sb.image = image
+	for _, dir := range []string{cfg.WorkDir, "/output", "/tmp"} {
+		if err := os.MkdirAll(filepath.Join(sb.root, dir), 0755); err != nil {
+			os.RemoveAll(sb.root)
+			return "", fmt.Errorf("failed to create sandbox root: %w", err)
+		}
+	}
+
+	d.volumeMu.RLock()
+	defer d.volumeMu.RUnlock()
+	mounts, err := d.volumeMounts(sb)
+	if err != nil {
+		os.RemoveAll(sb.root)
+		return "", err
+	}
+	sb.mounts = mounts
+
+	if cfg.Workspace != "" {
+		if err := d.copyWorkspace(cfg.Workspace, sb.hostPath(cfg.WorkDir)); err != nil {
+			os.RemoveAll(sb.root)
+			return "", fmt.Errorf("failed to copy workspace %s: %w", cfg.Workspace, err)
+		}
+	}
+
+	if len(cfg.Context) > 0 {
+		driver.ReportPhase(ctx, driver.PhaseInjectingContext)
+	}
+	for _, file := range cfg.Context {
+		if err := sb.writeFile(file.Path, file.Open()); err != nil {
+			os.RemoveAll(sb.root)
+			if errors.Is(err, driver.ErrInvalidConfig) {
+				return "", err
+			}
+			return "", fmt.Errorf("failed to inject context file %s: %w", file.Path, err)
+		}
+	}
+
+	d.mu.Lock()
+	d.sandboxes[sb.id] = sb
+	d.armTTL(sb, cfg.Timeout)
+	d.mu.Unlock()
+
+	return sb.id, nil
+}
+
+// armTTL (re)schedules the removal of a sandbox. d.mu must be held.
+func (d *WasmDriver) armTTL(sb *sandbox, after time.Duration) {
+	if sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	sb.ttl = time.AfterFunc(after, func() {
+		log.Info().Str("id", sb.id).Msg("Sandbox TTL expired")
+		d.expire(sb)
+	})
+}
+
+// SetExpiry implements driver.ExpiryController.
+func (d *WasmDriver) SetExpiry(ctx context.Context, id string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sb, ok := d.sandboxes[id]
+	if !ok {
+		return driver.ErrSandboxNotFound
+	}
+	d.armTTL(sb, time.Until(at))
+	return nil
+}
+
+func (d *WasmDriver) Start(ctx context.Context, id string) error {
+	sb, err := d.get(id)
+	if err != nil {
+		return err
+	}
+	if len(sb.cfg.Sidecars) > 0 {
+		return fmt.Errorf("%w: the wasm driver does not support sidecars", driver.ErrInvalidConfig)
+	}
+	if sb.cfg.User != "" {
+		return fmt.Errorf("%w: the wasm driver does not support users", driver.ErrInvalidConfig)
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.runtime != nil {
+		return driver.ErrSandboxAlreadyRunning
+	}
+
+	// 64 KiB pages
+	pages := uint32(sb.cfg.MemoryMB * 16)
+	sb.runtime = wazero.NewRuntimeWithConfig(context.Background(), wazero.NewRuntimeConfig().
+		WithCompilationCache(d.cache).
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+	if err := instantiateWASI(sb.runtime); err != nil {
+		sb.runtime.Close(context.Background())
+		sb.runtime = nil
+		return err
+	}
+	sb.state = driver.StateReady
+	return nil
+}
+
+func (d *WasmDriver) Stop(ctx context.Context, id string) error {
+	d.mu.Lock()
+	sb, ok := d.sandboxes[id]
+	delete(d.sandboxes, id)
+	d.mu.Unlock()
+	if !ok {
+		return driver.ErrSandboxNotFound
+	}
+
+	if sb.ttl != nil {
+		sb.ttl.Stop()
+	}
+	sb.agentLog.Close()
+	sb.mu.Lock()
+	sb.state = driver.StateStopped
+	if sb.runtime != nil {
+		// Also aborts running executions
+		sb.runtime.Close(context.Background())
+		sb.runtime = nil
+	}
+	sb.mu.Unlock()
+
+	if err := os.RemoveAll(sb.root); err != nil {
+		return fmt.Errorf("failed to remove sandbox root: %w", err)
+	}
+	return nil
+}
+
+func (d *WasmDriver) Connect(ctx context.Context, id string) (io.ReadWriteCloser, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	sb.mu.Lock()
+	running := sb.runtime != nil
+	sb.mu.Unlock()
+	if !running {
+		return nil, driver.ErrSandboxNotRunning
+	}
+	return d.health.Connect(ctx, id, func(context.Context) (io.ReadWriteCloser, error) {
+		return newAgentConn(d, sb), nil
+	}, func(err error) {
+		sb.agentCrashed(err)
+	})
+}
+
+// agentCrashed counts a crash of an agent of sb, unless sb was stopped.
+func (sb *sandbox) agentCrashed(err error) {
+	sb.mu.Lock()
+	running := sb.runtime != nil
+	sb.mu.Unlock()
+	if !running {
+		return
+	}
+	log.Warn().Err(err).Str("id", sb.id).Msg("Agent crashed")
+	sb.agentCrashes.Add(1)
+	sb.agentLog.Printf("agent crashed: %v", err)
+}
+
+// Logs implements driver.LogReader. Only the agent source exists: a wasm
+// sandbox has no main process.
+func (d *WasmDriver) Logs(ctx context.Context, id, source string, follow bool) (<-chan driver.LogLine, error) {
+	if err := driver.ValidateLogSource(source); err != nil {
+		return nil, err
+	}
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if source != driver.LogSourceAgent {
+		return nil, fmt.Errorf("%w: the wasm driver has no %s logs", driver.ErrNotImplemented, source)
+	}
+	return sb.agentLog.Stream(ctx, follow), nil
+}
+
+func (d *WasmDriver) Info(ctx context.Context, id string) (*driver.SandboxInfo, error) {
+	sb, err := d.get(id)
+	if err != nil {
+		return nil, err
+	}
+	return sb.info(), nil
+}
+
+func (d *WasmDriver) List(ctx context.Context, states []driver.SandboxState) ([]*driver.SandboxInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var results []*driver.SandboxInfo
+	for _, sb := range d.sandboxes {
+		info := sb.info()
+		if len(states) > 0 && !containsState(states, info.State) {
+			continue
+		}
+		results = append(results, info)
+	}
+	return results, nil
+}
+
+func (sb *sandbox) info() *driver.SandboxInfo {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return &driver.SandboxInfo{
+		ID:           sb.id,
+		State:        sb.state,
+		CreatedAt:    sb.createdAt,
+		Config:       sb.cfg,
+		DriverType:   DriverName,
+		AgentCrashes: int(sb.agentCrashes.Load()),
+	}
+}
+
+func containsState(states []driver.SandboxState, s driver.SandboxState) bool {
+	for _, st := range states {
+		if st == s {
+			return true
+		}
+	}
+	return false
+}
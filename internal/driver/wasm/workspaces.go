@@ -0,0 +1,147 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+)
+
+// workspacesDir holds one directory per base workspace under root_dir. Its
+// name does not start with "wasm-", so garbage collection leaves it alone.
+const workspacesDir = "workspaces"
+
+func (d *WasmDriver) workspacePath(name string) string {
+	return filepath.Join(d.rootDir, workspacesDir, name)
+}
+
+// CreateWorkspace implements driver.WorkspaceManager. The files are written
+// to a temporary directory first, so a failed create leaves nothing behind.
+func (d *WasmDriver) CreateWorkspace(ctx context.Context, spec driver.WorkspaceSpec) (*driver.WorkspaceInfo, error) {
+	if err := driver.ValidateWorkspaceName(spec.Name); err != nil {
+		return nil, err
+	}
+	parent := filepath.Join(d.rootDir, workspacesDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspaces directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(parent, ".new-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, file := range spec.Files {
+		data, err := base64.StdEncoding.DecodeString(file.ContentBase64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: file %s is not valid base64", driver.ErrInvalidConfig, file.Path)
+		}
+		if err := writeFile(tmp, file.Path, bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", file.Path, err)
+		}
+	}
+
+	d.workspaceMu.Lock()
+	defer d.workspaceMu.Unlock()
+	if _, err := os.Stat(d.workspacePath(spec.Name)); err == nil {
+		return nil, driver.ErrWorkspaceExists
+	}
+	// Labels first, so that a workspace is never seen without them
+	if err := d.writeLabels(workspacesDir, spec.Name, spec.Labels); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	if err := os.Rename(tmp, d.workspacePath(spec.Name)); err != nil {
+		d.writeLabels(workspacesDir, spec.Name, nil)
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return d.workspaceInfo(spec.Name)
+}
+
+// GetWorkspace implements driver.WorkspaceManager.
+func (d *WasmDriver) GetWorkspace(ctx context.Context, name string) (*driver.WorkspaceInfo, error) {
+	d.workspaceMu.RLock()
+	defer d.workspaceMu.RUnlock()
+	return d.workspaceInfo(name)
+}
+
+// ListWorkspaces implements driver.WorkspaceManager.
+func (d *WasmDriver) ListWorkspaces(ctx context.Context) ([]*driver.WorkspaceInfo, error) {
+	d.workspaceMu.RLock()
+	defer d.workspaceMu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(d.rootDir, workspacesDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var results []*driver.WorkspaceInfo
+	for _, e := range entries {
+		if !e.IsDir() || driver.ValidateWorkspaceName(e.Name()) != nil {
+			continue
+		}
+		if info, err := d.workspaceInfo(e.Name()); err == nil {
+			results = append(results, info)
+		}
+	}
+	return results, nil
+}
+
+// DeleteWorkspace implements driver.WorkspaceManager.
+func (d *WasmDriver) DeleteWorkspace(ctx context.Context, name string) error {
+	d.workspaceMu.Lock()
+	defer d.workspaceMu.Unlock()
+	info, err := d.workspaceInfo(name)
+	if err != nil {
+		return err
+	}
+	if info.InUse > 0 {
+		return driver.ErrWorkspaceInUse
+	}
+	if err := os.RemoveAll(d.workspacePath(name)); err != nil {
+		return err
+	}
+	return d.writeLabels(workspacesDir, name, nil)
+}
+
+// workspaceInfo must be called with workspaceMu held.
+func (d *WasmDriver) workspaceInfo(name string) (*driver.WorkspaceInfo, error) {
+	if driver.ValidateWorkspaceName(name) != nil {
+		return nil, driver.ErrWorkspaceNotFound
+	}
+	dir := d.workspacePath(name)
+	stat, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, driver.ErrWorkspaceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	info := &driver.WorkspaceInfo{Name: name, CreatedAt: stat.ModTime(), SizeBytes: diskUsage(dir), Labels: d.readLabels(workspacesDir, name)}
+	d.mu.Lock()
+	for _, sb := range d.sandboxes {
+		if sb.cfg.Workspace == name {
+			info.InUse++
+		}
+	}
+	d.mu.Unlock()
+	return info, nil
+}
+
+// copyWorkspace copies a workspace into a sandbox root at dir. There is no
+// copy-on-write here: a sandbox pays for its copy when it is created.
+func (d *WasmDriver) copyWorkspace(name, dir string) error {
+	d.workspaceMu.RLock()
+	defer d.workspaceMu.RUnlock()
+	src := d.workspacePath(name)
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return driver.ErrWorkspaceNotFound
+	}
+	return os.CopyFS(dir, os.DirFS(src))
+}
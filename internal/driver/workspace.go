@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Errors returned by WorkspaceManager implementations.
+var (
+	// ErrWorkspaceNotFound indicates the named workspace does not exist.
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+
+	// ErrWorkspaceExists indicates a workspace with that name already exists.
+	ErrWorkspaceExists = errors.New("workspace already exists")
+
+	// ErrWorkspaceInUse indicates live sandboxes still mount the workspace.
+	ErrWorkspaceInUse = errors.New("workspace in use")
+)
+
+// WorkspaceManager is implemented by drivers that keep base workspaces:
+// named file trees (e.g. a cloned repository with its dependencies
+// installed) that sandboxes created with SandboxConfig.Workspace see at
+// their WorkDir. Each sandbox gets a copy-on-write view, so its changes
+// never reach the base or other sandboxes.
+type WorkspaceManager interface {
+	// CreateWorkspace creates a workspace holding spec.Files.
+	//
+	// Returns ErrWorkspaceExists if the name is taken.
+	CreateWorkspace(ctx context.Context, spec WorkspaceSpec) (*WorkspaceInfo, error)
+
+	// GetWorkspace describes a workspace.
+	//
+	// Returns ErrWorkspaceNotFound if it doesn't exist.
+	GetWorkspace(ctx context.Context, name string) (*WorkspaceInfo, error)
+
+	// ListWorkspaces returns all workspaces.
+	ListWorkspaces(ctx context.Context) ([]*WorkspaceInfo, error)
+
+	// DeleteWorkspace removes a workspace.
+	//
+	// Returns ErrWorkspaceNotFound if it doesn't exist and ErrWorkspaceInUse
+	// while sandboxes mount it.
+	DeleteWorkspace(ctx context.Context, name string) error
+}
+
+// WorkspaceSpec describes a workspace to create.
+type WorkspaceSpec struct {
+	// Name identifies the workspace; see ValidateWorkspaceName
+	Name string `json:"name"`
+
+	// Files are written to the workspace, relative to its root
+	Files []FileInjection `json:"files,omitempty"`
+
+	// Image is used by drivers that need a helper container to fill the
+	// workspace. It should be one the workspace's sandboxes use.
+	Image string `json:"image,omitempty"`
+
+	// Labels are kept with the workspace, such as its owner
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// WorkspaceInfo describes a base workspace.
+type WorkspaceInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// SizeBytes is the size of the base files, -1 if unknown
+	SizeBytes int64 `json:"size_bytes"`
+
+	// InUse is the number of live sandboxes mounting the workspace
+	InUse int `json:"in_use"`
+
+	// Labels are those of its WorkspaceSpec
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+var workspaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// ValidateWorkspaceName checks that name can be used for a workspace on
+// every driver: lowercase letters, digits, '.', '_' and '-', starting with a
+// letter or digit, at most 63 characters.
+func ValidateWorkspaceName(name string) error {
+	if !workspaceName.MatchString(name) {
+		return fmt.Errorf("%w: invalid workspace name %q", ErrInvalidConfig, name)
+	}
+	return nil
+}
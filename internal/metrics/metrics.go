@@ -0,0 +1,256 @@
+// Package metrics is a minimal registry of counters, gauges and histograms
+// exposed in the Prometheus text format, so the server can be scraped
+// without pulling in the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry served on GET /metrics.
+var Default = NewRegistry()
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is one metric name with its label names and a value per label set.
+type family struct {
+	name   string
+	help   string
+	kind   string // "counter", "gauge" or "histogram"
+	labels []string
+
+	// buckets are the upper bounds of a histogram's buckets, ascending
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by joined label values
+	hists  map[string]*observations
+}
+
+// observations are the samples a histogram has seen for one label set.
+type observations struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Counter is a monotonically increasing value, optionally split by labels.
+type Counter struct{ f *family }
+
+// Gauge is a value that can go up and down, optionally split by labels.
+type Gauge struct{ f *family }
+
+// Histogram counts observations, such as durations, in buckets,
+// optionally split by labels.
+type Histogram struct{ f *family }
+
+// Counter returns the counter called name, registering it on first use.
+// labels are the label names; values are passed in the same order to Add.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.family(name, help, "counter", labels)}
+}
+
+// Gauge returns the gauge called name, registering it on first use.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.family(name, help, "gauge", labels)}
+}
+
+// Histogram returns the histogram called name, registering it on first
+// use. buckets are the upper bounds of its buckets, in ascending order;
+// a last +Inf bucket is implied.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	f := r.family(name, help, "histogram", labels)
+	f.mu.Lock()
+	if f.buckets == nil {
+		f.buckets = buckets
+		f.hists = make(map[string]*observations)
+	}
+	f.mu.Unlock()
+	return &Histogram{f}
+}
+
+func (r *Registry) family(name, help, kind string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different types or labels", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, values: make(map[string]float64)}
+	r.families[name] = f
+	return f
+}
+
+// Inc adds one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.update(labelValues, func(old float64) float64 { return old + v })
+}
+
+// Set replaces the value.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(float64) float64 { return v })
+}
+
+// Add adds v (which may be negative).
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(old float64) float64 { return old + v })
+}
+
+// Observe records one sample of v.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	f := h.f
+	key := f.key(labelValues)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	o := f.hists[key]
+	if o == nil {
+		o = &observations{counts: make([]uint64, len(f.buckets))}
+		f.hists[key] = o
+	}
+	for i, le := range f.buckets {
+		if v <= le {
+			o.counts[i]++
+			break
+		}
+	}
+	o.count++
+	o.sum += v
+}
+
+func (f *family) update(labelValues []string, fn func(float64) float64) {
+	key := f.key(labelValues)
+	f.mu.Lock()
+	f.values[key] = fn(f.values[key])
+	f.mu.Unlock()
+}
+
+// key joins labelValues into the key of their values.
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format,
+// sorted by name and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.kind == "histogram" {
+		f.writeHistogram(b)
+		return
+	}
+
+	// Unlabelled metrics are reported as 0 before the first update
+	if len(f.labels) == 0 && len(f.values) == 0 {
+		fmt.Fprintf(b, "%s 0\n", f.name)
+		return
+	}
+
+	keys := make([]string, 0, len(f.values))
+	for k := range f.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f.writeSample(b, f.name, k, "", f.values[k])
+	}
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of each
+// label set.
+func (f *family) writeHistogram(b *strings.Builder) {
+	keys := make([]string, 0, len(f.hists))
+	for k := range f.hists {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o := f.hists[k]
+		var n uint64
+		for i, le := range f.buckets {
+			n += o.counts[i]
+			f.writeSample(b, f.name+"_bucket", k, strconv.FormatFloat(le, 'g', -1, 64), float64(n))
+		}
+		f.writeSample(b, f.name+"_bucket", k, "+Inf", float64(o.count))
+		f.writeSample(b, f.name+"_sum", k, "", o.sum)
+		f.writeSample(b, f.name+"_count", k, "", float64(o.count))
+	}
+}
+
+// writeSample writes one line of name with the label values of key and,
+// for histogram buckets, the le label.
+func (f *family) writeSample(b *strings.Builder, name, key, le string, v float64) {
+	b.WriteString(name)
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, lv := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", f.labels[i], lv))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) > 0 {
+		b.WriteByte('{')
+		b.WriteString(strings.Join(pairs, ","))
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	b.WriteByte('\n')
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
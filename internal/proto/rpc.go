@@ -1,6 +1,8 @@
 // Package proto defines the JSON-RPC message types for Control Plane <-> Agent communication.
 package proto
 
+import "encoding/json"
+
 // Request represents a JSON-RPC 2.0 request.
 type Request struct {
 	JSONRPC string         `json:"jsonrpc"`
@@ -39,6 +41,51 @@ type ExecParams struct {
 	Args    []string          `json:"args,omitempty"`
 	Env     map[string]string `json:"env,omitempty"`
 	Timeout int64             `json:"timeout,omitempty"` // milliseconds
+
+	// Cwd is the working directory, absolute or relative to the sandbox's
+	// WorkDir; empty means WorkDir
+	Cwd string `json:"cwd,omitempty"`
+
+	// User runs the command as this user (see driver.ValidateUser);
+	// empty means the sandbox's default user
+	User string `json:"user,omitempty"`
+
+	// Artifacts overrides what the agent captures; nil keeps the defaults.
+	Artifacts *ArtifactOptions `json:"artifacts,omitempty"`
+
+	// Traceparent is the W3C trace context of the control plane's span for
+	// the exec, for the agent to continue the trace
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// Artifact delivery modes. Agents know inline and URL delivery; the
+// control plane asks for URL delivery of a manifest.
+const (
+	DeliveryInline   = "inline"   // contents base64-encoded in the event
+	DeliveryURL      = "url"      // path only, served by the control plane
+	DeliveryManifest = "manifest" // path, size and MIME type only
+)
+
+// ArtifactOptions controls which files the agent reports as artifacts
+// during an exec. Zero values mean: watch /output, deliver inline, cap at
+// 10 MB and accept any MIME type.
+type ArtifactOptions struct {
+	WatchPaths []string `json:"watch_paths,omitempty"` // absolute directories
+	MaxSize    int64    `json:"max_size,omitempty"`    // bytes
+	MIMETypes  []string `json:"mime_types,omitempty"`  // "image/png" or "image/*"
+	Delivery   string   `json:"delivery,omitempty"`
+}
+
+// UnmarshalJSON also accepts a delivery mode alone, as in
+// "artifacts": "manifest".
+func (o *ArtifactOptions) UnmarshalJSON(data []byte) error {
+	var delivery string
+	if err := json.Unmarshal(data, &delivery); err == nil {
+		*o = ArtifactOptions{Delivery: delivery}
+		return nil
+	}
+	type options ArtifactOptions
+	return json.Unmarshal(data, (*options)(o))
 }
 
 // ReplStartParams contains parameters for the "repl.start" method.
@@ -46,44 +93,124 @@ type ReplStartParams struct {
 	Cmd  string            `json:"cmd"`
 	Args []string          `json:"args,omitempty"`
 	Env  map[string]string `json:"env,omitempty"`
+
+	// Session names the REPL, so that REPLs of several sessions can run
+	// on one connection. Its events carry the name; empty is the REPL of
+	// a connection running only one, whose events carry none.
+	Session string `json:"session,omitempty"`
 }
 
 // ReplInputParams contains parameters for the "repl.input" method.
 type ReplInputParams struct {
 	Data string `json:"data"`
+
+	// Session is the REPL the input is for; see ReplStartParams.Session
+	Session string `json:"session,omitempty"`
+
+	// EOF closes the REPL's stdin after Data, for programs that read it
+	// to the end
+	EOF bool `json:"eof,omitempty"`
 }
 
+// SignalParams contains parameters for the "proc.signal" method, which
+// sends a signal to the process the connection's exec or REPL started,
+// and its children. It is sent as a notification: an agent with no running
+// process ignores it.
+type SignalParams struct {
+	// Signal is the Linux signal number, e.g. 2 for SIGINT
+	Signal int `json:"signal"`
+
+	// Session signals the REPL of that session instead; see
+	// ReplStartParams.Session
+	Session string `json:"session,omitempty"`
+}
+
+// MethodExecCancel is the notification that ends the exec running on the
+// connection, and the processes it started, with SIGKILL. Its exit event
+// has reason driver.ExitCanceled. The control plane sends it when nobody
+// waits for the exec any more; an agent also ends what its connection ran
+// when the connection closes.
+const MethodExecCancel = "exec.cancel"
+
 // StreamEvent represents an event streamed from Agent to Control Plane.
-// These are sent as JSON-RPC notifications (no ID).
+// These are sent as JSON-RPC notifications (no ID). Those of a REPL started
+// with a session carry it in Session.
 
 // StdoutEvent is sent when the process writes to stdout.
 type StdoutEvent struct {
-	Chunk string `json:"chunk"`
+	Chunk   string `json:"chunk"`
+	Session string `json:"session,omitempty"`
 }
 
 // StderrEvent is sent when the process writes to stderr.
 type StderrEvent struct {
-	Chunk string `json:"chunk"`
+	Chunk   string `json:"chunk"`
+	Session string `json:"session,omitempty"`
 }
 
 // ExitEvent is sent when the process terminates.
 type ExitEvent struct {
 	Code int `json:"code"`
+
+	// Signal is the signal that killed the process, if one did; Code is
+	// then 128 plus the signal number, as in shells
+	Signal int `json:"signal,omitempty"`
+
+	// Reason is driver.ExitOOMKilled, driver.ExitSignaled or
+	// driver.ExitCanceled when the process was killed
+	Reason string `json:"reason,omitempty"`
+
+	Session string `json:"session,omitempty"`
 }
 
-// ArtifactEvent is sent when a new file is detected in /output.
+// ArtifactEvent is sent when a new file is detected in a watched directory.
+// Paths under /output are relative to it; other paths are absolute.
 type ArtifactEvent struct {
 	Path       string `json:"path"`
 	MIME       string `json:"mime"`
+	Size       int64  `json:"size,omitempty"`
 	DataBase64 string `json:"data_base64,omitempty"`
 	URL        string `json:"url,omitempty"` // For large files uploaded to S3
+
+	// SHA256 and Deduplicated are set by the control plane when the
+	// artifact is kept in its content-addressable store
+	SHA256       string `json:"sha256,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
 }
 
 // ErrorEvent is sent when an error occurs during execution.
 type ErrorEvent struct {
 	Message string `json:"message"`
+
+	// Kind says what failed, as one of the Error kinds; agents that do
+	// not classify their errors leave it empty
+	Kind string `json:"kind,omitempty"`
+
+	Session string `json:"session,omitempty"`
 }
 
+// Kinds of ErrorEvent.
+const (
+	// ErrorCommandNotFound means the program to run does not exist
+	ErrorCommandNotFound = "command_not_found"
+
+	// ErrorPermissionDenied means the program exists but may not be run,
+	// or not as the user asked for
+	ErrorPermissionDenied = "permission_denied"
+
+	// ErrorCwdNotFound means the working directory does not exist
+	ErrorCwdNotFound = "cwd_not_found"
+
+	// ErrorUserNotFound means the user to run as does not exist
+	ErrorUserNotFound = "user_not_found"
+
+	// ErrorTimedOut means the process ran past ExecParams.Timeout
+	ErrorTimedOut = "timed_out"
+
+	// ErrorInternal means the agent failed for a reason of its own
+	ErrorInternal = "internal"
+)
+
 // NewRequest creates a new JSON-RPC 2.0 request.
 func NewRequest(method string, params map[string]any, id any) *Request {
 	return &Request{
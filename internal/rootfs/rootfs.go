@@ -0,0 +1,374 @@
+// Package rootfs converts OCI images into ext4 root filesystems bootable by
+// the Firecracker driver.
+//
+// The resulting image is the flattened container filesystem plus:
+//   - the boxed-agent binary at /usr/local/bin/boxed-agent
+//   - an init script at /sbin/boxed-init that mounts the pseudo filesystems,
+//     restores the image environment and hands the console to the agent
+//   - a manifest at /etc/boxed/image.json describing the source image
+//
+// Building requires a reachable Docker daemon (to flatten the image) and
+// mkfs.ext4 from e2fsprogs (to populate the filesystem without root mounts).
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// AgentPath is where the agent binary is placed inside the rootfs.
+	AgentPath = "/usr/local/bin/boxed-agent"
+
+	// InitPath is the init the kernel should be booted with (init=/sbin/boxed-init).
+	InitPath = "/sbin/boxed-init"
+
+	// ManifestPath is where the image manifest is written inside the rootfs.
+	ManifestPath = "/etc/boxed/image.json"
+
+	// DefaultConsole is the device the agent speaks JSON-RPC on.
+	DefaultConsole = "/dev/ttyS0"
+
+	// minSizeMB is the smallest filesystem we create; ext4 metadata alone needs room.
+	minSizeMB = 64
+)
+
+// Options configures a rootfs build.
+type Options struct {
+	// Image is the source OCI image reference (pulled if missing)
+	Image string
+
+	// AgentPath is the host path of a Linux boxed-agent binary
+	AgentPath string
+
+	// Output is the path of the ext4 file to create
+	Output string
+
+	// SizeMB fixes the filesystem size. Zero sizes it from the content plus headroom.
+	SizeMB int64
+
+	// Console is the device the agent is attached to (default: /dev/ttyS0)
+	Console string
+}
+
+// Manifest describes a built rootfs. It is written both inside the image and
+// next to it (<output>.json) so the driver can read it without mounting.
+type Manifest struct {
+	SourceImage string    `json:"source_image"`
+	ImageID     string    `json:"image_id"`
+	Env         []string  `json:"env"`
+	WorkDir     string    `json:"work_dir"`
+	Init        string    `json:"init"`
+	Agent       string    `json:"agent"`
+	AgentSHA256 string    `json:"agent_sha256"`
+	Console     string    `json:"console"`
+	SizeBytes   int64     `json:"size_bytes"`
+	BuiltAt     time.Time `json:"built_at"`
+}
+
+// Build flattens opts.Image into an ext4 filesystem at opts.Output.
+func Build(ctx context.Context, cli *client.Client, opts Options) (*Manifest, error) {
+	if opts.Image == "" || opts.Output == "" || opts.AgentPath == "" {
+		return nil, errors.New("image, output and agent path are required")
+	}
+	if opts.Console == "" {
+		opts.Console = DefaultConsole
+	}
+	mkfs, err := exec.LookPath("mkfs.ext4")
+	if err != nil {
+		return nil, errors.New("mkfs.ext4 not found: install e2fsprogs")
+	}
+
+	inspect, err := ensureImage(ctx, cli, opts.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	staging, err := os.MkdirTemp("", "boxed-rootfs-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	log.Info().Str("image", opts.Image).Str("staging", staging).Msg("Exporting image filesystem")
+	if err := exportImage(ctx, cli, opts.Image, staging); err != nil {
+		return nil, err
+	}
+
+	agentSum, err := installAgent(opts.AgentPath, filepath.Join(staging, AgentPath))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		SourceImage: opts.Image,
+		ImageID:     inspect.ID,
+		Env:         inspect.Config.Env,
+		WorkDir:     inspect.Config.WorkingDir,
+		Init:        InitPath,
+		Agent:       AgentPath,
+		AgentSHA256: agentSum,
+		Console:     opts.Console,
+		BuiltAt:     time.Now().UTC(),
+	}
+	if manifest.WorkDir == "" {
+		manifest.WorkDir = "/workspace"
+	}
+
+	if err := writeInit(staging, manifest); err != nil {
+		return nil, err
+	}
+
+	sizeMB := opts.SizeMB
+	if sizeMB <= 0 {
+		used, err := dirSize(staging)
+		if err != nil {
+			return nil, err
+		}
+		// 30% headroom for the workspace plus ext4 metadata overhead
+		sizeMB = used*13/10/(1024*1024) + minSizeMB
+	}
+	manifest.SizeBytes = sizeMB * 1024 * 1024
+
+	// The manifest goes inside the image before mkfs copies the tree.
+	if err := writeJSON(filepath.Join(staging, ManifestPath), manifest); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(opts.Output)
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Truncate(manifest.SizeBytes); err != nil {
+		out.Close()
+		return nil, err
+	}
+	out.Close()
+
+	log.Info().Str("output", opts.Output).Int64("size_mb", sizeMB).Msg("Creating ext4 filesystem")
+	cmd := exec.CommandContext(ctx, mkfs, "-F", "-q", "-L", "boxed-rootfs", "-d", staging, opts.Output)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(opts.Output)
+		return nil, fmt.Errorf("mkfs.ext4 failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	if err := writeJSON(opts.Output+".json", manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ensureImage inspects the image, pulling it first if it is not cached.
+func ensureImage(ctx context.Context, cli *client.Client, image string) (types.ImageInspect, error) {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, image)
+	if client.IsErrNotFound(err) {
+		log.Info().Str("image", image).Msg("Image not found locally, pulling...")
+		reader, err := cli.ImagePull(ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return inspect, fmt.Errorf("failed to pull image %s: %w", image, err)
+		}
+		io.Copy(io.Discard, reader)
+		reader.Close()
+		inspect, _, err = cli.ImageInspectWithRaw(ctx, image)
+	}
+	if err != nil {
+		return inspect, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return inspect, nil
+}
+
+// exportImage flattens the image layers by exporting a never-started container.
+func exportImage(ctx context.Context, cli *client.Client, image, dest string) error {
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image: image,
+		Cmd:   []string{"/bin/true"}, // never run, but required for images without a CMD
+	}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create export container: %w", err)
+	}
+	defer cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	reader, err := cli.ContainerExport(ctx, resp.ID)
+	if err != nil {
+		return fmt.Errorf("failed to export container: %w", err)
+	}
+	defer reader.Close()
+
+	return extractTar(reader, dest)
+}
+
+// extractTar unpacks a container export into dest, refusing entries that
+// would escape it. Device nodes are skipped: init mounts devtmpfs instead.
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar read error: %w", err)
+		}
+
+		target := filepath.Join(dest, filepath.Clean("/"+hdr.Name))
+		if !strings.HasPrefix(target, dest+string(os.PathSeparator)) && target != dest {
+			return fmt.Errorf("tar entry escapes destination: %s", hdr.Name)
+		}
+		mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			os.Chmod(target, mode)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+			os.Chmod(target, mode)
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source := filepath.Join(dest, filepath.Clean("/"+hdr.Linkname))
+			os.Remove(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		// Ownership only sticks when building as root; that is fine for
+		// unprivileged builds because the agent runs as root in the VM.
+		os.Lchown(target, hdr.Uid, hdr.Gid)
+	}
+}
+
+// installAgent copies the agent binary into the rootfs and returns its SHA-256.
+func installAgent(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open agent binary: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), in); err != nil {
+		return "", fmt.Errorf("failed to copy agent binary: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeInit writes the init script and the directories it relies on.
+func writeInit(root string, m *Manifest) error {
+	var env strings.Builder
+	for _, kv := range m.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&env, "export %s=%s\n", k, shellQuote(v))
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+# Generated by boxed image build-rootfs. Runs as PID 1 inside the microVM.
+mount -t proc proc /proc
+mount -t sysfs sysfs /sys
+mount -t devtmpfs devtmpfs /dev 2>/dev/null
+mkdir -p /dev/pts && mount -t devpts devpts /dev/pts
+mount -t tmpfs tmpfs /tmp
+mount -t tmpfs tmpfs /output
+mount -t tmpfs tmpfs /run
+hostname boxed
+
+%s
+export BOXED_AGENT_MODE=firecracker
+mkdir -p %s
+cd %s
+
+# The control plane speaks JSON-RPC to the agent over the console device.
+exec %s <%s >%s 2>/run/boxed-agent.log
+`, env.String(), shellQuote(m.WorkDir), shellQuote(m.WorkDir), m.Agent, m.Console, m.Console)
+
+	for _, dir := range []string{"proc", "sys", "dev", "tmp", "output", "run", "sbin", "etc/boxed"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(root, m.Init), []byte(script), 0755)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeJSON(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// dirSize sums the sizes of regular files under root.
+func dirSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,408 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore is a Store kept as files in a directory, which several
+// control-plane nodes can share over a network filesystem. It also
+// implements NodeRegistry and UsageStore.
+//
+// Each sandbox is written by the node serving it only, so records are
+// replaced atomically by renaming and histories are appended to without
+// locking across nodes.
+type FileStore struct {
+	dir string
+
+	// mu serializes this process' appends, which read the history to
+	// number and bound it
+	mu sync.Mutex
+}
+
+// Subdirectories of a FileStore.
+const (
+	sandboxesDir = "sandboxes"
+	execsDir     = "execs"
+	eventsDir    = "events"
+	nodesDir     = "nodes"
+	usageDir     = "usage"
+)
+
+// OpenFileStore opens the store in dir, creating it if needed.
+func OpenFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{sandboxesDir, execsDir, eventsDir, nodesDir, usageDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("state: %w", err)
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of a sandbox or node; names are escaped so that
+// they cannot leave the directory.
+func (f *FileStore) path(sub, name, ext string) string {
+	return filepath.Join(f.dir, sub, url.PathEscape(name)+ext)
+}
+
+// Ping implements Pinger: the directory must be reachable.
+func (f *FileStore) Ping(ctx context.Context) error {
+	_, err := os.Stat(filepath.Join(f.dir, sandboxesDir))
+	return err
+}
+
+// writeJSON replaces the file at path with v, atomically.
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// readLines decodes a file of JSON lines; a missing file has none. A torn
+// last line, from a node that died while appending, is skipped.
+func readLines[T any](path string) ([]T, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []T
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		var v T
+		if json.Unmarshal(sc.Bytes(), &v) == nil {
+			out = append(out, v)
+		}
+	}
+	return out, sc.Err()
+}
+
+// appendLine adds v to a file of JSON lines, rewriting it with the last max
+// entries once it holds more.
+func appendLine[T any](path string, v T, existing []T, max int) error {
+	if len(existing)+1 > max {
+		all := append(existing[len(existing)+1-max:], v)
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range all {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *FileStore) AppendExec(ctx context.Context, sandboxID string, rec ExecRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := f.path(execsDir, sandboxID, ".jsonl")
+	records, err := readLines[ExecRecord](path)
+	if err != nil {
+		return err
+	}
+	rec.Seq = 1
+	if len(records) > 0 {
+		rec.Seq = records[len(records)-1].Seq + 1
+	}
+	return appendLine(path, rec, records, MaxExecsPerSandbox)
+}
+
+func (f *FileStore) ListExecs(ctx context.Context, sandboxID string) ([]ExecRecord, error) {
+	records, err := readLines[ExecRecord](f.path(execsDir, sandboxID, ".jsonl"))
+	if records == nil {
+		records = []ExecRecord{}
+	}
+	return records, err
+}
+
+func (f *FileStore) PutSandbox(ctx context.Context, rec SandboxRecord) error {
+	return writeJSON(f.path(sandboxesDir, rec.ID, ".json"), rec)
+}
+
+func (f *FileStore) GetSandbox(ctx context.Context, sandboxID string) (SandboxRecord, error) {
+	var rec SandboxRecord
+	data, err := os.ReadFile(f.path(sandboxesDir, sandboxID, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, ErrNotFound
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// storedSandbox is a record with when its file was last written.
+type storedSandbox struct {
+	rec     SandboxRecord
+	modTime time.Time
+}
+
+func (f *FileStore) readSandboxes() ([]storedSandbox, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, sandboxesDir))
+	if err != nil {
+		return nil, err
+	}
+	var out []storedSandbox
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, sandboxesDir, e.Name()))
+		if err != nil {
+			// Removed since listing
+			continue
+		}
+		var rec SandboxRecord
+		if json.Unmarshal(data, &rec) != nil {
+			continue
+		}
+		s := storedSandbox{rec: rec}
+		if info, err := e.Info(); err == nil {
+			s.modTime = info.ModTime()
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (f *FileStore) ListSandboxes(ctx context.Context) ([]SandboxRecord, error) {
+	stored, err := f.readSandboxes()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]SandboxRecord, len(stored))
+	for i, s := range stored {
+		records[i] = s.rec
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (f *FileStore) AppendEvent(ctx context.Context, sandboxID string, ev Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := f.path(eventsDir, sandboxID, ".jsonl")
+	events, err := readLines[Event](path)
+	if err != nil {
+		return err
+	}
+	return appendLine(path, ev, events, MaxEventsPerSandbox)
+}
+
+func (f *FileStore) ListEvents(ctx context.Context, sandboxID string) ([]Event, error) {
+	events, err := readLines[Event](f.path(eventsDir, sandboxID, ".jsonl"))
+	if events == nil {
+		events = []Event{}
+	}
+	// See MemoryStore.ListEvents
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, err
+}
+
+// DeleteSandbox drops the exec history of a sandbox and then the records
+// and timelines of the stopped sandboxes beyond MaxStoppedTimelines, those
+// written longest ago first.
+func (f *FileStore) DeleteSandbox(ctx context.Context, sandboxID string) error {
+	if err := os.Remove(f.path(execsDir, sandboxID, ".jsonl")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	stored, err := f.readSandboxes()
+	if err != nil {
+		return err
+	}
+	var stopped []storedSandbox
+	for _, s := range stored {
+		if s.rec.State == SandboxStopped || s.rec.State == SandboxFailed {
+			stopped = append(stopped, s)
+		}
+	}
+	if len(stopped) <= MaxStoppedTimelines {
+		return nil
+	}
+	sort.Slice(stopped, func(i, j int) bool { return stopped[i].modTime.Before(stopped[j].modTime) })
+	for _, s := range stopped[:len(stopped)-MaxStoppedTimelines] {
+		os.Remove(f.path(eventsDir, s.rec.ID, ".jsonl"))
+		os.Remove(f.path(sandboxesDir, s.rec.ID, ".json"))
+	}
+	return nil
+}
+
+func (f *FileStore) Heartbeat(ctx context.Context, node Node) error {
+	return writeJSON(f.path(nodesDir, node.ID, ".json"), node)
+}
+
+func (f *FileStore) ListNodes(ctx context.Context) ([]Node, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, nodesDir))
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, nodesDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var n Node
+		if json.Unmarshal(data, &n) == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// RemoveNode renames the node's entry out of the way first: renaming is
+// atomic, so only one of several nodes removing it gets the file.
+func (f *FileStore) RemoveNode(ctx context.Context, id string, staleBefore time.Time) (bool, error) {
+	path := f.path(nodesDir, id, ".json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var n Node
+	if err := json.Unmarshal(data, &n); err != nil || !n.HeartbeatAt.Before(staleBefore) {
+		return false, err
+	}
+
+	claimed, err := os.CreateTemp(filepath.Dir(path), ".removed-*")
+	if err != nil {
+		return false, err
+	}
+	claimed.Close()
+	defer os.Remove(claimed.Name())
+	if err := os.Rename(path, claimed.Name()); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	// The node may have come back between the read and the rename
+	data, err = os.ReadFile(claimed.Name())
+	if err != nil || json.Unmarshal(data, &n) != nil || !n.HeartbeatAt.Before(staleBefore) {
+		if err == nil {
+			os.Rename(claimed.Name(), path)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// PutUsage also removes the records last written more than MaxUsageAge ago,
+// which are those of sandboxes that stopped before then.
+func (f *FileStore) PutUsage(ctx context.Context, rec UsageRecord) error {
+	if err := writeJSON(f.path(usageDir, rec.SandboxID, ".json"), rec); err != nil {
+		return err
+	}
+	if rec.StoppedAt == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(f.dir, usageDir))
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-MaxUsageAge)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(f.dir, usageDir, e.Name()))
+		}
+	}
+	return nil
+}
+
+func (f *FileStore) GetUsage(ctx context.Context, sandboxID string) (UsageRecord, error) {
+	var rec UsageRecord
+	data, err := os.ReadFile(f.path(usageDir, sandboxID, ".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return rec, ErrNotFound
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+func (f *FileStore) ListUsage(ctx context.Context, from, to time.Time) ([]UsageRecord, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, usageDir))
+	if err != nil {
+		return nil, err
+	}
+	records := []UsageRecord{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, usageDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var u UsageRecord
+		if json.Unmarshal(data, &u) == nil && u.ranBetween(from, to) {
+			records = append(records, u)
+		}
+	}
+	sortUsage(records)
+	return records, nil
+}
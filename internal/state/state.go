@@ -0,0 +1,562 @@
+// Package state holds the control plane's own records about sandboxes.
+//
+// Drivers only know about the backend resources (containers, VMs). Everything
+// the control plane needs to remember on top of that - what was executed,
+// when, and with what outcome, and the lifecycle timeline - lives in a Store.
+package state
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrNotFound is returned when the store has no record of a sandbox.
+var ErrNotFound = errors.New("no record found")
+
+const (
+	// MaxRecordedOutput is the number of bytes of stdout/stderr kept per exec record.
+	MaxRecordedOutput = 4 * 1024
+
+	// MaxExecsPerSandbox bounds the history kept for a single sandbox.
+	// Older records are evicted first.
+	MaxExecsPerSandbox = 200
+
+	// MaxEventsPerSandbox bounds the timeline kept for a single sandbox.
+	MaxEventsPerSandbox = 500
+
+	// MaxStoppedTimelines is how many timelines of stopped sandboxes are kept
+	// around for post-mortem debugging. Older ones are evicted first.
+	MaxStoppedTimelines = 100
+
+	// MaxUsageAge is how long usage records are kept after their sandbox
+	// stopped, so that they can still be billed.
+	MaxUsageAge = 400 * 24 * time.Hour
+)
+
+// Timeline event types.
+const (
+	EventCreated      = "created"
+	EventStarted      = "started"
+	EventAgentReady   = "agent_ready"
+	EventExec         = "exec"
+	EventFileUpload   = "file_upload"
+	EventFileDownload = "file_download"
+	EventStopped      = "stopped"
+	EventFailed       = "failed"
+	EventTTLChanged   = "ttl_changed"
+	EventTakenOver    = "taken_over"
+	EventSignaled     = "signaled"
+	EventInit         = "init"
+	EventPublished    = "published"
+	EventResized      = "resized"
+	EventHibernated   = "hibernated"
+	EventWoken        = "woken"
+)
+
+// Sandbox record states. They mirror driver.SandboxState values.
+const (
+	SandboxCreating   = "creating"
+	SandboxReady      = "ready"
+	SandboxFailed     = "failed"
+	SandboxStopped    = "stopped"
+	SandboxHibernated = "hibernated"
+)
+
+// SandboxRecord is the control plane's view of a sandbox lifecycle.
+type SandboxRecord struct {
+	ID        string    `json:"id"`
+	Image     string    `json:"image"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// BackendID is the driver's own ID for the sandbox
+	BackendID string `json:"backend_id,omitempty"`
+
+	// ExpiresAt is when the sandbox TTL removes it
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// HibernatedAt is when the sandbox hibernated (State ==
+	// SandboxHibernated); its TTL is stopped, with ExpiresAt less
+	// HibernatedAt left of it
+	HibernatedAt time.Time `json:"hibernated_at,omitzero"`
+
+	// Error describes why the sandbox failed (State == SandboxFailed)
+	Error string `json:"error,omitempty"`
+
+	// ContextDigest identifies the context files the sandbox was created
+	// with; with Image it scopes the exec cache
+	ContextDigest string `json:"context_digest,omitempty"`
+
+	// Volumes names the persistent volumes the sandbox mounts; execs that
+	// can read them are never cached
+	Volumes []string `json:"volumes,omitempty"`
+
+	// NetworkGroup is the network group the sandbox is on; its execs can
+	// talk to other sandboxes, so they are never cached either
+	NetworkGroup string `json:"network_group,omitempty"`
+
+	// Node is the control-plane node serving the sandbox, when several
+	// share the store
+	Node string `json:"node,omitempty"`
+
+	// Labels are the sandbox labels, kept for events sent once the driver
+	// has forgotten it
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Init is the run of the template's init script, if it has one; it is
+	// kept when the script failed the create
+	Init *ExecRecord `json:"init,omitempty"`
+
+	// Phases are the steps of the sandbox's lifecycle in the order it
+	// entered them; the last is the current one
+	Phases []PhaseChange `json:"phases,omitempty"`
+}
+
+// PhaseChange is the moment a sandbox entered a phase of its lifecycle.
+// Phases mirror driver.SandboxPhase values.
+type PhaseChange struct {
+	Phase string    `json:"phase"`
+	At    time.Time `json:"at"`
+}
+
+// ExecRecord describes a single execution performed in a sandbox.
+type ExecRecord struct {
+	// Seq is the 1-based position of this exec within the sandbox history
+	Seq int `json:"seq"`
+
+	// Language is the language/interpreter requested by the caller
+	Language string `json:"language"`
+
+	// Code is the submitted code, truncated to MaxRecordedOutput
+	Code string `json:"code"`
+
+	// File and Args are the script run instead of code, and its arguments
+	File string   `json:"file,omitempty"`
+	Args []string `json:"args,omitempty"`
+
+	// ExitCode is nil if the process never reported an exit
+	ExitCode *int `json:"exit_code"`
+
+	// StartedAt is when the exec request was received
+	StartedAt time.Time `json:"started_at"`
+
+	// DurationMS is the wall-clock duration of the exec in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+
+	// QueuedMS is the part of DurationMS the exec waited for other execs
+	// of the sandbox to finish
+	QueuedMS int64 `json:"queued_ms,omitempty"`
+
+	// Stdout and Stderr are truncated to MaxRecordedOutput
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+
+	// Truncated is true if any of Code, Stdout or Stderr was cut short
+	Truncated bool `json:"truncated"`
+
+	// ExitReason says why the process ended abnormally, e.g. "oom_killed"
+	ExitReason string `json:"exit_reason,omitempty"`
+
+	// ErrorKind classifies a failed exec, e.g. "command_not_found"
+	ErrorKind string `json:"error_kind,omitempty"`
+
+	// Error describes a control-plane failure (timeout, broken stream),
+	// or the agent's error when the exec ran
+	Error string `json:"error,omitempty"`
+
+	// Cached is true if the result was served from the exec cache and the
+	// code did not run
+	Cached bool `json:"cached,omitempty"`
+}
+
+// Event is a single entry of a sandbox lifecycle timeline.
+type Event struct {
+	// Type is one of the Event* constants
+	Type string `json:"type"`
+
+	// At is when the operation started
+	At time.Time `json:"at"`
+
+	// DurationMS is how long the operation took (0 for instantaneous events)
+	DurationMS int64 `json:"duration_ms"`
+
+	// Detail is a short human readable description (image, path, exit code, reason)
+	Detail string `json:"detail,omitempty"`
+
+	// Error is set when the operation failed
+	Error string `json:"error,omitempty"`
+}
+
+// Store persists control plane records about sandboxes.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// AppendExec adds an exec record to the sandbox history.
+	// The record's Seq is assigned by the store.
+	AppendExec(ctx context.Context, sandboxID string, rec ExecRecord) error
+
+	// ListExecs returns the exec history of a sandbox, oldest first.
+	ListExecs(ctx context.Context, sandboxID string) ([]ExecRecord, error)
+
+	// PutSandbox creates or replaces the record of a sandbox.
+	PutSandbox(ctx context.Context, rec SandboxRecord) error
+
+	// GetSandbox returns the record of a sandbox, or ErrNotFound.
+	GetSandbox(ctx context.Context, sandboxID string) (SandboxRecord, error)
+
+	// ListSandboxes returns every sandbox record, oldest first, including
+	// retained records of stopped and failed sandboxes.
+	ListSandboxes(ctx context.Context) ([]SandboxRecord, error)
+
+	// AppendEvent adds an entry to the sandbox timeline.
+	AppendEvent(ctx context.Context, sandboxID string, ev Event) error
+
+	// ListEvents returns the sandbox timeline ordered by start time.
+	ListEvents(ctx context.Context, sandboxID string) ([]Event, error)
+
+	// DeleteSandbox drops the exec history of a stopped sandbox. Its record
+	// and timeline are retained (bounded by MaxStoppedTimelines) so they can
+	// still be inspected.
+	DeleteSandbox(ctx context.Context, sandboxID string) error
+}
+
+// Pinger is implemented by stores backed by a database or another service,
+// so that readiness checks can tell whether it is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Node is a control-plane instance sharing the store with others.
+type Node struct {
+	// ID is the node's instance ID, which labels its sandboxes
+	ID string `json:"id"`
+
+	// URL is where other nodes reach its API, e.g. "http://10.0.0.5:8080"
+	URL string `json:"url"`
+
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// NodeRegistry is implemented by stores shared by several control-plane
+// nodes. Nodes heartbeat periodically; one that stops is taken over by
+// another, which removes its entry first.
+type NodeRegistry interface {
+	// Heartbeat registers a node or refreshes its entry.
+	Heartbeat(ctx context.Context, node Node) error
+
+	// ListNodes returns the registered nodes, ordered by ID.
+	ListNodes(ctx context.Context) ([]Node, error)
+
+	// RemoveNode drops the entry of a node if its last heartbeat is
+	// before staleBefore, reporting whether it did. Of several nodes
+	// removing the same one, only one succeeds.
+	RemoveNode(ctx context.Context, id string, staleBefore time.Time) (bool, error)
+}
+
+// UsageRecord is what a sandbox consumed over its lifetime, for charging it
+// back to whoever created it.
+type UsageRecord struct {
+	SandboxID string `json:"sandbox_id"`
+	Image     string `json:"image"`
+
+	// Key identifies who created the sandbox: the subject of their bearer
+	// token, or a shared key for API key and unauthenticated callers
+	Key    string            `json:"key"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// MemoryMB and CPUCores are what the sandbox was allocated
+	MemoryMB int64   `json:"memory_mb"`
+	CPUCores float64 `json:"cpu_cores"`
+
+	// Changes are the allocations it was given later, oldest first
+	Changes []UsageChange `json:"changes,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+
+	// SeenAt is when the sandbox was last sampled running. It ends the
+	// usage of a sandbox whose stop was never recorded, e.g. because its
+	// node crashed.
+	SeenAt    time.Time  `json:"seen_at"`
+	StoppedAt *time.Time `json:"stopped_at,omitempty"`
+
+	// CPUSeconds is the CPU time consumed as of SeenAt, if the driver
+	// reports it
+	CPUSeconds float64 `json:"cpu_seconds"`
+}
+
+// UsageChange is an allocation a sandbox was given while it ran.
+type UsageChange struct {
+	At       time.Time `json:"at"`
+	MemoryMB int64     `json:"memory_mb"`
+	CPUCores float64   `json:"cpu_cores"`
+}
+
+// End returns when the sandbox stopped, or was last seen running.
+func (u UsageRecord) End() time.Time {
+	if u.StoppedAt != nil {
+		return *u.StoppedAt
+	}
+	return u.SeenAt
+}
+
+// UsageStore is implemented by stores that keep usage records. Records are
+// written by the node serving the sandbox and kept for MaxUsageAge after
+// it stopped.
+type UsageStore interface {
+	// PutUsage creates or replaces the usage record of a sandbox.
+	PutUsage(ctx context.Context, rec UsageRecord) error
+
+	// GetUsage returns the usage record of a sandbox, or ErrNotFound.
+	GetUsage(ctx context.Context, sandboxID string) (UsageRecord, error)
+
+	// ListUsage returns the records of the sandboxes that ran between from
+	// and to, ordered by start time.
+	ListUsage(ctx context.Context, from, to time.Time) ([]UsageRecord, error)
+}
+
+// ranBetween reports whether u overlaps the period from from to to.
+func (u UsageRecord) ranBetween(from, to time.Time) bool {
+	return u.StartedAt.Before(to) && u.End().After(from)
+}
+
+// Truncate shortens s to at most n bytes, reporting whether it was cut.
+// The cut is moved back to the start of a rune rather than split one.
+func Truncate(s string, n int) (string, bool) {
+	if len(s) <= n {
+		return s, false
+	}
+	return s[:RuneCut(s, n)], true
+}
+
+// RuneCut returns n, or the start of the rune s[n] belongs to if it is in
+// the middle of one, so that s[:RuneCut(s, n)] is not left with half a
+// rune. n must be less than len(s).
+func RuneCut(s string, n int) int {
+	for i := n; i >= 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			return i
+		}
+	}
+	return n
+}
+
+// MemoryStore is an in-process Store. Records are lost on restart.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	execs     map[string][]ExecRecord
+	seq       map[string]int
+	events    map[string][]Event
+	sandboxes map[string]SandboxRecord
+	// stopped lists sandboxes whose timelines are retained, oldest first
+	stopped []string
+	nodes   map[string]Node
+	usage   map[string]UsageRecord
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		execs:     make(map[string][]ExecRecord),
+		seq:       make(map[string]int),
+		events:    make(map[string][]Event),
+		sandboxes: make(map[string]SandboxRecord),
+		nodes:     make(map[string]Node),
+		usage:     make(map[string]UsageRecord),
+	}
+}
+
+func (m *MemoryStore) AppendExec(ctx context.Context, sandboxID string, rec ExecRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq[sandboxID]++
+	rec.Seq = m.seq[sandboxID]
+
+	records := append(m.execs[sandboxID], rec)
+	if len(records) > MaxExecsPerSandbox {
+		records = records[len(records)-MaxExecsPerSandbox:]
+	}
+	m.execs[sandboxID] = records
+	return nil
+}
+
+func (m *MemoryStore) ListExecs(ctx context.Context, sandboxID string) ([]ExecRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := make([]ExecRecord, len(m.execs[sandboxID]))
+	copy(records, m.execs[sandboxID])
+	return records, nil
+}
+
+func (m *MemoryStore) PutSandbox(ctx context.Context, rec SandboxRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sandboxes[rec.ID] = rec
+	return nil
+}
+
+func (m *MemoryStore) GetSandbox(ctx context.Context, sandboxID string) (SandboxRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.sandboxes[sandboxID]
+	if !ok {
+		return SandboxRecord{}, ErrNotFound
+	}
+	return rec, nil
+}
+
+func (m *MemoryStore) ListSandboxes(ctx context.Context) ([]SandboxRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := make([]SandboxRecord, 0, len(m.sandboxes))
+	for _, rec := range m.sandboxes {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (m *MemoryStore) AppendEvent(ctx context.Context, sandboxID string, ev Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := append(m.events[sandboxID], ev)
+	if len(events) > MaxEventsPerSandbox {
+		events = events[len(events)-MaxEventsPerSandbox:]
+	}
+	m.events[sandboxID] = events
+	return nil
+}
+
+func (m *MemoryStore) ListEvents(ctx context.Context, sandboxID string) ([]Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := make([]Event, len(m.events[sandboxID]))
+	copy(events, m.events[sandboxID])
+	// Events are appended when an operation finishes, so a long exec is
+	// recorded after shorter ones that started later.
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+func (m *MemoryStore) DeleteSandbox(ctx context.Context, sandboxID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.execs, sandboxID)
+	delete(m.seq, sandboxID)
+
+	_, hasEvents := m.events[sandboxID]
+	_, hasRecord := m.sandboxes[sandboxID]
+	if (hasEvents || hasRecord) && !m.isStopped(sandboxID) {
+		m.stopped = append(m.stopped, sandboxID)
+		if len(m.stopped) > MaxStoppedTimelines {
+			delete(m.events, m.stopped[0])
+			delete(m.sandboxes, m.stopped[0])
+			m.stopped = m.stopped[1:]
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) isStopped(sandboxID string) bool {
+	for _, id := range m.stopped {
+		if id == sandboxID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) Heartbeat(ctx context.Context, node Node) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nodes[node.ID] = node
+	return nil
+}
+
+func (m *MemoryStore) ListNodes(ctx context.Context) ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	nodes := make([]Node, 0, len(m.nodes))
+	for _, n := range m.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+func (m *MemoryStore) RemoveNode(ctx context.Context, id string, staleBefore time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.nodes[id]
+	if !ok || !n.HeartbeatAt.Before(staleBefore) {
+		return false, nil
+	}
+	delete(m.nodes, id)
+	return true, nil
+}
+
+// PutUsage also drops the records of sandboxes that stopped more than
+// MaxUsageAge ago.
+func (m *MemoryStore) PutUsage(ctx context.Context, rec UsageRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage[rec.SandboxID] = rec
+	if rec.StoppedAt != nil {
+		cutoff := time.Now().Add(-MaxUsageAge)
+		for id, u := range m.usage {
+			if u.End().Before(cutoff) {
+				delete(m.usage, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUsage(ctx context.Context, sandboxID string) (UsageRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rec, ok := m.usage[sandboxID]
+	if !ok {
+		return rec, ErrNotFound
+	}
+	return rec, nil
+}
+
+func (m *MemoryStore) ListUsage(ctx context.Context, from, to time.Time) ([]UsageRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	records := []UsageRecord{}
+	for _, u := range m.usage {
+		if u.ranBetween(from, to) {
+			records = append(records, u)
+		}
+	}
+	sortUsage(records)
+	return records, nil
+}
+
+func sortUsage(records []UsageRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].StartedAt.Equal(records[j].StartedAt) {
+			return records[i].StartedAt.Before(records[j].StartedAt)
+		}
+		return records[i].SandboxID < records[j].SandboxID
+	})
+}
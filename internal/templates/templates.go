@@ -0,0 +1,485 @@
+// Package templates resolves the templates sandboxes are created from to an
+// image and default resources.
+//
+// A Catalog holds named templates, one of them the default for creates that
+// name none. The built-in catalog maps "python" (the default) and
+// "python-data-science"; Load reads one from a YAML file instead, which
+// Watch reloads whenever it changes:
+//
+//	default: python
+//	defaults:            # resources of templates that set none
+//	  memory_mb: 512
+//	  cpu_cores: 1
+//	templates:
+//	  python:
+//	    image: python:3.10-slim
+//	  python-data-science:
+//	    image: boxed-python:3.9
+//	    memory_mb: 2048
+//	    cpu_cores: 2
+//	    timeout: 30m
+//	  repl:
+//	    image: python:3.10-slim
+//	    cpu_cores: 0.25
+//	    cpu_burst:       # short commands run at full speed
+//	      period_ms: 1000
+//	      shares: 256
+//	    seccomp: strict  # no sockets
+//	  postgres:
+//	    image: boxed-postgres:16
+//	    init: service postgresql start
+//	    init_timeout: 1m
+//	    driver: docker
+//	images: ["python:*", "node:*"]
+//
+// A template that is not in the catalog but is an image reference, such as
+// "node:20-slim", runs that image with the default resources if it matches
+// a pattern of images (path.Match syntax; every image when the list is
+// absent, none when it is empty). Anything else is unknown and rejected.
+//
+// A template's seccomp profile filters the syscalls of its sandboxes:
+// "default", "strict" (no networking), "permissive", the name of a profile
+// of the server's seccomp directory, or the absolute path of a JSON
+// profile. Creates that set their own security profile replace it.
+//
+// A template's init script runs once in each of its sandboxes after they
+// start and before they are ready, such as to start a service the image
+// provides. A template's driver picks the backend of servers running
+// several.
+//
+// Publish adds templates while the server runs, such as sandboxes saved as
+// images. They are kept when the file is reloaded.
+package templates
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknown is returned for templates that are neither in the catalog nor
+// an image it allows.
+var ErrUnknown = errors.New("unknown template")
+
+// ErrDefined is returned by Publish for names the catalog's file or the
+// built-in catalog defines.
+var ErrDefined = errors.New("template is defined by the catalog")
+
+// Resources used when neither a template nor the catalog's defaults set
+// them.
+const (
+	DefaultMemoryMB = 512
+	DefaultCPUCores = 1.0
+
+	// DefaultInitTimeout bounds init scripts of templates that set no
+	// init_timeout
+	DefaultInitTimeout = 5 * time.Minute
+)
+
+// reloadDebounce is how long the file must be quiet before it is reloaded.
+// Editors and ConfigMap updates emit several events per change.
+const reloadDebounce = 100 * time.Millisecond
+
+// Template is what a sandbox is created from.
+type Template struct {
+	// Name is the template's key in the catalog, or the image reference
+	// it was resolved from
+	Name string `yaml:"-"`
+
+	Image    string  `yaml:"image"`
+	MemoryMB int64   `yaml:"memory_mb"`
+	CPUCores float64 `yaml:"cpu_cores"`
+
+	// CPUBurst lets sandboxes run above CPUCores for short stretches; see
+	// driver.CPUBurst
+	CPUBurst *driver.CPUBurst `yaml:"cpu_burst"`
+
+	// Timeout is the lifetime of sandboxes created without one; zero
+	// leaves it to the server
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Init is a bash script run in each sandbox once it started, before it
+	// is ready; a sandbox whose script fails is not created
+	Init string `yaml:"init"`
+
+	// InitTimeout bounds Init, DefaultInitTimeout if zero
+	InitTimeout time.Duration `yaml:"init_timeout"`
+
+	// Driver is the backend its sandboxes run on, on servers running
+	// several; routing decides if empty
+	Driver string `yaml:"driver"`
+
+	// Seccomp is the seccomp profile of its sandboxes (see the package
+	// doc); empty leaves it to the server
+	Seccomp string `yaml:"seccomp"`
+
+	// Published marks templates added with Publish rather than read from
+	// the catalog's file
+	Published bool `yaml:"-"`
+
+	// Owner is who published the template, if the server knows callers
+	Owner string `yaml:"-"`
+}
+
+// Config is the contents of a catalog file.
+type Config struct {
+	// Default names the template of creates that name none
+	Default string `yaml:"default"`
+
+	// Defaults are the resources of templates that set none, and of
+	// images used directly
+	Defaults Template `yaml:"defaults"`
+
+	Templates map[string]Template `yaml:"templates"`
+
+	// Images are the patterns images used directly must match; nil allows
+	// every image
+	Images []string `yaml:"images"`
+}
+
+// Builtin returns the catalog of a server without a catalog file.
+func Builtin() *Catalog {
+	c, err := New(Config{
+		Default: "python",
+		Templates: map[string]Template{
+			"python":              {Image: "python:3.10-slim"},
+			"python-data-science": {Image: "boxed-python:3.9"},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// validate checks cfg and fills in the resources templates leave unset.
+func (cfg *Config) validate() error {
+	if cfg.Defaults.MemoryMB < 0 || cfg.Defaults.CPUCores < 0 || cfg.Defaults.Timeout < 0 {
+		return errors.New("defaults: resources must not be negative")
+	}
+	if cfg.Defaults.Init != "" || cfg.Defaults.InitTimeout != 0 {
+		return errors.New("defaults: init scripts are set per template")
+	}
+	if !validSeccomp(cfg.Defaults.Seccomp) {
+		return fmt.Errorf("defaults: invalid seccomp profile %q", cfg.Defaults.Seccomp)
+	}
+	if cfg.Defaults.MemoryMB == 0 {
+		cfg.Defaults.MemoryMB = DefaultMemoryMB
+	}
+	if cfg.Defaults.CPUCores == 0 {
+		cfg.Defaults.CPUCores = DefaultCPUCores
+	}
+	if b := cfg.Defaults.CPUBurst; b != nil {
+		if err := b.Validate(cfg.Defaults.CPUCores); err != nil {
+			return fmt.Errorf("defaults: %w", err)
+		}
+	}
+	for name, t := range cfg.Templates {
+		if name == "" {
+			return errors.New("templates: a template has an empty name")
+		}
+		if t.Image == "" {
+			return fmt.Errorf("template %q: image is required", name)
+		}
+		if t.MemoryMB < 0 || t.CPUCores < 0 || t.Timeout < 0 {
+			return fmt.Errorf("template %q: resources must not be negative", name)
+		}
+		if t.InitTimeout < 0 {
+			return fmt.Errorf("template %q: init_timeout must not be negative", name)
+		}
+		if t.InitTimeout > 0 && t.Init == "" {
+			return fmt.Errorf("template %q: init_timeout needs an init script", name)
+		}
+		if !validSeccomp(t.Seccomp) {
+			return fmt.Errorf("template %q: invalid seccomp profile %q", name, t.Seccomp)
+		}
+		t = cfg.withDefaults(name, t)
+		if t.CPUBurst != nil {
+			if err := t.CPUBurst.Validate(t.CPUCores); err != nil {
+				return fmt.Errorf("template %q: %w", name, err)
+			}
+		}
+		cfg.Templates[name] = t
+	}
+	if _, ok := cfg.Templates[cfg.Default]; !ok {
+		return fmt.Errorf("default template %q is not defined", cfg.Default)
+	}
+	for _, p := range cfg.Images {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("images: invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) withDefaults(name string, t Template) Template {
+	t.Name = name
+	if t.MemoryMB == 0 {
+		t.MemoryMB = cfg.Defaults.MemoryMB
+	}
+	if t.CPUCores == 0 {
+		t.CPUCores = cfg.Defaults.CPUCores
+	}
+	if t.CPUBurst == nil {
+		t.CPUBurst = cfg.Defaults.CPUBurst
+	}
+	if t.Timeout == 0 {
+		t.Timeout = cfg.Defaults.Timeout
+	}
+	if t.Init != "" && t.InitTimeout == 0 {
+		t.InitTimeout = DefaultInitTimeout
+	}
+	if t.Seccomp == "" {
+		t.Seccomp = cfg.Defaults.Seccomp
+	}
+	return t
+}
+
+// seccompName is the name of a profile of the server's seccomp directory,
+// without its .json extension.
+var seccompName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// validSeccomp reports whether p is empty, a profile name or an absolute
+// path.
+func validSeccomp(p string) bool {
+	return p == "" || seccompName.MatchString(p) || filepath.IsAbs(p)
+}
+
+// Catalog resolves templates. It is safe for concurrent use, also while
+// Watch reloads it.
+type Catalog struct {
+	// path is the file the catalog was loaded from; empty for the
+	// built-in one
+	path string
+
+	mu  sync.RWMutex
+	cfg Config
+
+	// published are the templates added with Publish, kept across reloads
+	published map[string]Template
+}
+
+// New returns a catalog of cfg.
+func New(cfg Config) (*Catalog, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	return &Catalog{cfg: cfg}, nil
+}
+
+// Load reads a catalog from the YAML file at path.
+func Load(path string) (*Catalog, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Catalog{path: path, cfg: cfg}, nil
+}
+
+func readConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("templates: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("templates: %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("templates: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Resolve returns the template called name, the default one if name is
+// empty, or a template running the image name refers to.
+func (c *Catalog) Resolve(name string) (Template, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if name == "" {
+		name = c.cfg.Default
+	}
+	if t, ok := c.cfg.Templates[name]; ok {
+		return t, nil
+	}
+	if t, ok := c.published[name]; ok {
+		return t, nil
+	}
+	if !isImageRef(name) {
+		return Template{}, fmt.Errorf("%w %q: want one of %s, or an image reference such as python:3.10-slim",
+			ErrUnknown, name, strings.Join(c.names(), ", "))
+	}
+	if c.cfg.Images != nil && !slices.ContainsFunc(c.cfg.Images, func(p string) bool {
+		ok, _ := path.Match(p, name)
+		return ok
+	}) {
+		return Template{}, fmt.Errorf("%w %q: the image is not allowed; want one of %s",
+			ErrUnknown, name, strings.Join(c.names(), ", "))
+	}
+	return c.cfg.withDefaults(name, Template{Image: name}), nil
+}
+
+// isImageRef reports whether name refers to an image rather than naming a
+// template: image references have a tag, digest or repository path.
+func isImageRef(name string) bool {
+	return strings.ContainsAny(name, ":/@")
+}
+
+// names returns the template names, sorted. c.mu must be held.
+func (c *Catalog) names() []string {
+	names := make([]string, 0, len(c.cfg.Templates)+len(c.published))
+	for n := range c.cfg.Templates {
+		names = append(names, n)
+	}
+	for n := range c.published {
+		if _, ok := c.cfg.Templates[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the template of the catalog called name, one of its
+// file or a published one; unlike Resolve it does not resolve image
+// references.
+func (c *Catalog) Lookup(name string) (Template, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t := c.get(name)
+	return t, t.Name != ""
+}
+
+// get returns the template called name. c.mu must be held.
+func (c *Catalog) get(name string) Template {
+	if t, ok := c.cfg.Templates[name]; ok {
+		return t
+	}
+	return c.published[name]
+}
+
+// Publish adds t to the catalog, or replaces the template of that name an
+// earlier Publish added, with the catalog's defaults for the resources it
+// leaves unset. It returns ErrDefined if the catalog defines the name
+// itself. Published templates last as long as the catalog; a file
+// defining the name later takes precedence.
+func (c *Catalog) Publish(t Template) (Template, error) {
+	if t.Name == "" || t.Image == "" {
+		return Template{}, errors.New("templates: a published template needs a name and an image")
+	}
+	if t.MemoryMB < 0 || t.CPUCores < 0 || t.Timeout < 0 || t.InitTimeout < 0 {
+		return Template{}, fmt.Errorf("template %q: resources must not be negative", t.Name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cfg.Templates[t.Name]; ok {
+		return Template{}, fmt.Errorf("%w: %q", ErrDefined, t.Name)
+	}
+	t = c.cfg.withDefaults(t.Name, t)
+	t.Published = true
+	if c.published == nil {
+		c.published = make(map[string]Template)
+	}
+	c.published[t.Name] = t
+	return t, nil
+}
+
+// Default returns the template of creates that name none.
+func (c *Catalog) Default() Template {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cfg.Templates[c.cfg.Default]
+}
+
+// List returns the templates sorted by name, the default one's name and
+// the image patterns (nil if every image is allowed).
+func (c *Catalog) List() ([]Template, string, []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]Template, 0, len(c.cfg.Templates)+len(c.published))
+	for _, n := range c.names() {
+		list = append(list, c.get(n))
+	}
+	return list, c.cfg.Default, slices.Clone(c.cfg.Images)
+}
+
+// Reload reads the catalog's file again. An invalid file leaves the
+// catalog as it was.
+func (c *Catalog) Reload() error {
+	if c.path == "" {
+		return nil
+	}
+	cfg, err := readConfig(c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	changed := !reflect.DeepEqual(cfg, c.cfg)
+	c.cfg = cfg
+	c.mu.Unlock()
+	if changed {
+		log.Info().Str("path", c.path).Msg("Reloaded templates")
+	}
+	return nil
+}
+
+// Watch reloads the catalog whenever its file changes, until ctx is done.
+// It watches the file's directory, so that files replaced by renaming, as
+// editors and Kubernetes ConfigMaps do, are picked up too. Reload errors
+// are logged.
+func (c *Catalog) Watch(ctx context.Context) error {
+	if c.path == "" {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(c.path)); err != nil {
+		return err
+	}
+
+	// Reloads wait for the directory to be quiet
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case _, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			timer.Reset(reloadDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Str("path", c.path).Msg("Watching templates failed")
+		case <-timer.C:
+			if err := c.Reload(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload templates; keeping the previous ones")
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package tracing sets up OpenTelemetry tracing for the server. Spans are
+// exported over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set; otherwise the global no-op
+// provider stays in place and starting a span costs next to nothing.
+//
+// Code that wants a span calls Start with the context it was given; the
+// provider in use is picked up from the otel globals.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of all Boxed spans.
+const instrumentation = "github.com/akshayaggarwal99/boxed"
+
+// Propagator carries trace context in W3C traceparent headers, over HTTP
+// and in agent RPC params.
+var Propagator = propagation.TraceContext{}
+
+// Enabled reports whether the environment configures an OTLP endpoint.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs a tracer provider exporting to the OTLP endpoint the
+// standard OTEL_* variables configure, if any. The returned function
+// flushes and stops it; it is a no-op when tracing is disabled.
+func Setup(ctx context.Context, service, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(Propagator)
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(service), semconv.ServiceVersion(version)),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start starts a span called name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed with err if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Traceparent returns the W3C traceparent of the span in ctx, or "" if ctx
+// has none, to pass trace context where there are no headers.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	Propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceparent returns ctx continuing the trace of a W3C traceparent.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return Propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// SandboxID is the attribute naming the sandbox a span concerns.
+func SandboxID(id string) attribute.KeyValue {
+	return attribute.String("boxed.sandbox.id", id)
+}
@@ -0,0 +1,425 @@
+// The Boxed dashboard: a page over the public API, authenticating with the
+// key or bearer token typed into it. Browsers cannot set headers on
+// EventSource and WebSocket requests, so those carry it in the query.
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+  key: localStorage.getItem('boxed.key') || '',
+  selected: null,
+  tab: 'stats',
+  stats: null,
+  logs: null,
+  events: null,
+  term: null,
+  ws: null,
+  cpu: [],
+  memory: [],
+};
+
+// auth returns the headers and query parameter that carry the credentials.
+function auth() {
+  if (!state.key) return { headers: {}, query: '' };
+  if (state.key.startsWith('Bearer ')) {
+    const token = state.key.slice(7);
+    return { headers: { Authorization: state.key }, query: 'access_token=' + encodeURIComponent(token) };
+  }
+  return { headers: { 'X-Boxed-API-Key': state.key }, query: 'api_key=' + encodeURIComponent(state.key) };
+}
+
+function withAuth(path) {
+  const q = auth().query;
+  return q ? path + (path.includes('?') ? '&' : '?') + q : path;
+}
+
+async function api(method, path, body) {
+  const init = { method, headers: { ...auth().headers } };
+  if (body instanceof FormData) {
+    init.body = body;
+  } else if (body !== undefined) {
+    init.headers['Content-Type'] = 'application/json';
+    init.body = JSON.stringify(body);
+  }
+  const res = await fetch('/v1' + path, init);
+  if (!res.ok) {
+    let msg = res.statusText;
+    try {
+      const err = await res.json();
+      msg = err.error || msg;
+    } catch (_) { /* not JSON */ }
+    const e = new Error(msg);
+    e.status = res.status;
+    throw e;
+  }
+  return res;
+}
+
+function status(msg, bad) {
+  const el = $('status');
+  el.textContent = msg;
+  el.style.color = bad ? 'var(--bad)' : 'var(--muted)';
+}
+
+function esc(s) {
+  return String(s ?? '').replace(/[&<>"']/g, (c) => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+}
+
+function bytes(n) {
+  if (n == null) return '–';
+  const units = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i ? n.toFixed(1) : n) + ' ' + units[i];
+}
+
+function when(t) {
+  if (!t) return '–';
+  const d = new Date(t);
+  return isNaN(d) ? '–' : d.toLocaleString();
+}
+
+// Sandboxes
+
+async function loadSandboxes() {
+  const filter = $('state-filter').value;
+  let list;
+  try {
+    const res = await api('GET', '/sandbox' + (filter ? '?state=' + filter : ''));
+    list = (await res.json()).sandboxes || [];
+  } catch (e) {
+    status(e.status === 401 ? 'Enter an API key to connect' : 'Cannot list sandboxes: ' + e.message, true);
+    return;
+  }
+  status('Connected');
+  list.sort((a, b) => new Date(b.created_at) - new Date(a.created_at));
+  $('empty').hidden = list.length > 0;
+  $('sandbox-rows').innerHTML = list.map((sb) => `
+    <tr data-id="${esc(sb.id)}" class="${sb.id === state.selected ? 'selected' : ''}">
+      <td class="mono">${esc(sb.id)}</td>
+      <td>${esc(sb.config && sb.config.image)}</td>
+      <td><span class="state ${esc(sb.state)}">${esc(sb.state)}</span></td>
+      <td>${esc(sb.driver_type)}</td>
+      <td>${when(sb.created_at)}</td>
+      <td>${when(sb.expires_at)}</td>
+      <td>${sb.state === 'stopped' ? '' : '<button type="button" class="danger" data-stop>Stop</button>'}</td>
+    </tr>`).join('');
+  if (state.selected && !list.some((sb) => sb.id === state.selected)) select(null);
+}
+
+// watchEvents reloads the list whenever a sandbox changes state.
+function watchEvents() {
+  if (state.events) state.events.close();
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(withAuth(`${proto}//${location.host}/v1/events`));
+  let pending = null;
+  ws.onmessage = () => {
+    clearTimeout(pending);
+    pending = setTimeout(loadSandboxes, 200);
+  };
+  ws.onclose = () => {
+    if (state.events === ws) setTimeout(watchEvents, 5000);
+  };
+  state.events = ws;
+}
+
+async function stopSandbox(id) {
+  if (!confirm(`Stop sandbox ${id}?`)) return;
+  try {
+    await api('DELETE', '/sandbox/' + encodeURIComponent(id));
+  } catch (e) {
+    status('Cannot stop ' + id + ': ' + e.message, true);
+  }
+  loadSandboxes();
+}
+
+function select(id) {
+  closeStreams();
+  state.selected = id;
+  document.querySelectorAll('#sandbox-rows tr').forEach((tr) => tr.classList.toggle('selected', tr.dataset.id === id));
+  $('detail').hidden = !id;
+  if (!id) return;
+  $('detail-title').textContent = id;
+  $('path').value = '/';
+  showTab(state.tab);
+}
+
+function closeStreams() {
+  if (state.stats) { state.stats.close(); state.stats = null; }
+  if (state.logs) { state.logs.abort(); state.logs = null; }
+  if (state.ws) { state.ws.close(1000); state.ws = null; }
+  state.cpu = [];
+  state.memory = [];
+}
+
+function showTab(tab) {
+  closeStreams();
+  state.tab = tab;
+  document.querySelectorAll('#tabs button').forEach((b) => b.classList.toggle('active', b.dataset.tab === tab));
+  document.querySelectorAll('.tab').forEach((el) => { el.hidden = el.id !== 'tab-' + tab; });
+  ({ stats: openStats, logs: openLogs, terminal: openTerminal, files: loadFiles })[tab]();
+}
+
+// Stats
+
+async function openStats() {
+  const id = state.selected;
+  try {
+    const sb = await (await api('GET', '/sandbox/' + encodeURIComponent(id))).json();
+    $('config').textContent = JSON.stringify(sb.config, null, 2);
+  } catch (e) {
+    $('config').textContent = e.message;
+  }
+  for (const g of ['cpu', 'memory', 'pids', 'disk']) $(g).textContent = '–';
+  const es = new EventSource(withAuth(`/v1/sandbox/${encodeURIComponent(id)}/stats/stream?interval=1`));
+  es.addEventListener('stats', (e) => showStats(JSON.parse(e.data)));
+  es.addEventListener('error', (e) => {
+    if (e.data) {
+      $('cpu').textContent = JSON.parse(e.data).error || 'unavailable';
+      es.close();
+    }
+  });
+  state.stats = es;
+}
+
+function showStats(s) {
+  $('cpu').textContent = s.cpu_percent != null ? s.cpu_percent.toFixed(1) + '%' : '–';
+  $('memory').textContent = bytes(s.memory_bytes) + (s.memory_limit_bytes ? ' / ' + bytes(s.memory_limit_bytes) : '');
+  $('pids').textContent = s.pids ?? '–';
+  $('disk').textContent = bytes(s.disk_bytes ?? s.disk_write_bytes);
+  plot('cpu-chart', state.cpu, s.cpu_percent || 0, 100);
+  plot('memory-chart', state.memory, s.memory_bytes || 0, s.memory_limit_bytes);
+}
+
+// plot adds v to the last 60 samples and draws them scaled to max.
+function plot(id, samples, v, max) {
+  samples.push(v);
+  if (samples.length > 60) samples.shift();
+  const top = Math.max(max || 0, ...samples, 1);
+  const points = samples.map((s, i) => `${i + 60 - samples.length},${(20 - (s / top) * 20).toFixed(2)}`);
+  document.querySelector(`#${id} polyline`).setAttribute('points', points.join(' '));
+}
+
+// Logs
+
+async function openLogs() {
+  const id = state.selected;
+  const out = $('logs');
+  out.textContent = '';
+  const ctrl = new AbortController();
+  state.logs = ctrl;
+  let res;
+  try {
+    res = await fetch(`/v1/sandbox/${encodeURIComponent(id)}/logs?follow=true&source=${$('log-source').value}`,
+      { headers: auth().headers, signal: ctrl.signal });
+    if (!res.ok) {
+      out.textContent = (await res.json()).error || res.statusText;
+      return;
+    }
+  } catch (_) {
+    return;
+  }
+  const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buf = '';
+  try {
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += value;
+      const lines = buf.split('\n');
+      buf = lines.pop();
+      const stick = out.scrollTop + out.clientHeight >= out.scrollHeight - 4;
+      for (const l of lines.filter(Boolean)) {
+        const line = JSON.parse(l);
+        out.textContent += `${new Date(line.time).toLocaleTimeString()} ${line.exec ? '[' + line.exec + '] ' : ''}${line.text}\n`;
+      }
+      if (stick) out.scrollTop = out.scrollHeight;
+    }
+  } catch (_) { /* aborted */ }
+}
+
+// Terminal
+
+// The interact WebSocket runs the REPL on pipes, not a terminal, so the
+// dashboard echoes and edits the line being typed and sends it on Enter.
+function openTerminal() {
+  const el = $('terminal');
+  if (!state.term) {
+    if (window.Terminal) {
+      const term = new window.Terminal({ convertEol: true, fontSize: 13, cursorBlink: true });
+      const fit = window.FitAddon ? new window.FitAddon.FitAddon() : null;
+      if (fit) term.loadAddon(fit);
+      term.open(el);
+      if (fit) {
+        fit.fit();
+        window.addEventListener('resize', () => fit.fit());
+      }
+      let line = '';
+      term.onData((data) => {
+        for (const ch of data) {
+          if (ch === '\r') {
+            term.write('\r\n');
+            send('repl.input', { data: line + '\n' });
+            line = '';
+          } else if (ch === '\x7f') {
+            if (line) { line = line.slice(0, -1); term.write('\b \b'); }
+          } else if (ch === '\x03') {
+            line = '';
+            term.write('^C\r\n');
+            send('proc.signal', { signal: 2 });
+          } else if (ch === '\x04') {
+            send('repl.input', { data: line, eof: true });
+            line = '';
+          } else if (ch >= ' ') {
+            line += ch;
+            term.write(ch);
+          }
+        }
+      });
+      state.term = { write: (s) => term.write(s), clear: () => term.reset() };
+    } else {
+      // xterm.js could not be loaded, e.g. offline: a plain text console
+      const pre = document.createElement('pre');
+      const input = document.createElement('input');
+      input.className = 'fallback';
+      input.placeholder = 'Input, sent on Enter';
+      input.addEventListener('keydown', (e) => {
+        if (e.key !== 'Enter') return;
+        pre.textContent += input.value + '\n';
+        send('repl.input', { data: input.value + '\n' });
+        input.value = '';
+      });
+      el.append(pre, input);
+      state.term = {
+        write: (s) => { pre.textContent += s; pre.scrollTop = pre.scrollHeight; },
+        clear: () => { pre.textContent = ''; },
+      };
+    }
+  }
+  $('term-status').textContent = 'disconnected';
+}
+
+function connectTerminal() {
+  if (state.ws) state.ws.close(1000);
+  state.term.clear();
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const url = `${proto}//${location.host}/v1/sandbox/${encodeURIComponent(state.selected)}/interact?lang=${$('term-lang').value}`;
+  const ws = new WebSocket(withAuth(url));
+  ws.onmessage = (e) => {
+    if (typeof e.data !== 'string') return;
+    const msg = JSON.parse(e.data);
+    const p = msg.params || {};
+    switch (msg.method) {
+      case 'session': $('term-status').textContent = 'session ' + p.id; break;
+      case 'stdout': case 'stderr': state.term.write(p.chunk); break;
+      case 'exit': state.term.write(`\r\n[exited with code ${p.code}${p.reason ? ', ' + p.reason : ''}]\r\n`); break;
+      case 'flow': if (p.state === 'dropped') state.term.write('\r\n[output dropped]\r\n'); break;
+      default: if (msg.error) state.term.write(`\r\n[${msg.error.message}]\r\n`);
+    }
+  };
+  ws.onclose = () => {
+    if (state.ws === ws) $('term-status').textContent = 'disconnected';
+  };
+  state.ws = ws;
+}
+
+function send(method, params) {
+  if (state.ws && state.ws.readyState === WebSocket.OPEN) {
+    state.ws.send(JSON.stringify({ jsonrpc: '2.0', method, params }));
+  }
+}
+
+// Files
+
+async function loadFiles() {
+  const id = state.selected;
+  const dir = $('path').value || '/';
+  const rows = $('file-rows');
+  let files;
+  try {
+    const res = await api('GET', `/sandbox/${encodeURIComponent(id)}/files?path=${encodeURIComponent(dir)}`);
+    files = (await res.json()).files || [];
+  } catch (e) {
+    rows.innerHTML = `<tr><td colspan="4" class="muted">${esc(e.message)}</td></tr>`;
+    return;
+  }
+  files.sort((a, b) => (b.is_dir - a.is_dir) || a.name.localeCompare(b.name));
+  rows.innerHTML = files.map((f) => `
+    <tr data-path="${esc(f.path)}" data-dir="${f.is_dir}">
+      <td class="mono">${esc(f.name)}${f.is_dir ? '/' : ''}</td>
+      <td>${f.is_dir ? '' : bytes(f.size)}</td>
+      <td class="mono">${(f.mode & 0o7777).toString(8).padStart(4, '0')}</td>
+      <td>${when(f.last_modified)}</td>
+    </tr>`).join('') || '<tr><td colspan="4" class="muted">Empty directory.</td></tr>';
+}
+
+function openPath(p) {
+  $('path').value = p || '/';
+  loadFiles();
+}
+
+async function download(p) {
+  try {
+    const res = await api('GET', `/sandbox/${encodeURIComponent(state.selected)}/files/content?path=${encodeURIComponent(p)}`);
+    const a = document.createElement('a');
+    a.href = URL.createObjectURL(await res.blob());
+    a.download = p.split('/').pop();
+    a.click();
+    setTimeout(() => URL.revokeObjectURL(a.href), 1000);
+  } catch (e) {
+    status('Cannot download ' + p + ': ' + e.message, true);
+  }
+}
+
+async function upload(files) {
+  const form = new FormData();
+  form.append('path', $('path').value || '/');
+  for (const f of files) form.append('file', f);
+  try {
+    await api('POST', `/sandbox/${encodeURIComponent(state.selected)}/files`, form);
+  } catch (e) {
+    status('Upload failed: ' + e.message, true);
+  }
+  loadFiles();
+}
+
+// Wiring
+
+$('login').addEventListener('submit', (e) => {
+  e.preventDefault();
+  state.key = $('key').value.trim();
+  localStorage.setItem('boxed.key', state.key);
+  loadSandboxes();
+  watchEvents();
+});
+$('refresh').addEventListener('click', loadSandboxes);
+$('state-filter').addEventListener('change', loadSandboxes);
+$('sandbox-rows').addEventListener('click', (e) => {
+  const tr = e.target.closest('tr');
+  if (!tr) return;
+  if (e.target.closest('[data-stop]')) stopSandbox(tr.dataset.id);
+  else select(tr.dataset.id);
+});
+$('tabs').addEventListener('click', (e) => {
+  if (e.target.dataset.tab) showTab(e.target.dataset.tab);
+});
+$('log-source').addEventListener('change', () => showTab('logs'));
+$('term-connect').addEventListener('click', connectTerminal);
+$('term-interrupt').addEventListener('click', () => send('proc.signal', { signal: 2 }));
+$('path-form').addEventListener('submit', (e) => { e.preventDefault(); loadFiles(); });
+$('up').addEventListener('click', () => openPath($('path').value.replace(/\/[^/]*\/?$/, '')));
+$('file-rows').addEventListener('click', (e) => {
+  const tr = e.target.closest('tr[data-path]');
+  if (!tr) return;
+  if (tr.dataset.dir === 'true') openPath(tr.dataset.path);
+  else download(tr.dataset.path);
+});
+$('upload').addEventListener('change', (e) => {
+  upload(e.target.files);
+  e.target.value = '';
+});
+
+$('key').value = state.key;
+loadSandboxes();
+watchEvents();
+setInterval(loadSandboxes, 15000);
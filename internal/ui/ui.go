@@ -0,0 +1,24 @@
+// Package ui embeds the web dashboard served at /ui: a single page that
+// lists sandboxes and shows their live stats and logs, a terminal over the
+// interact WebSocket and a file browser. The page calls the public API
+// from the browser, with the key or token typed into it, so serving it
+// needs no authentication.
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard's files, at paths relative to its root.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
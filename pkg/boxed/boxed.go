@@ -0,0 +1,411 @@
+// Package boxed embeds the Boxed control plane in a Go program.
+//
+// An Engine runs the same code paths as the REST API (template mapping,
+// defaults, exec history, timelines) without an HTTP server in between:
+//
+//	eng, err := boxed.New(ctx, boxed.Options{})
+//	defer eng.Close()
+//	sb, err := eng.CreateSandbox(ctx, boxed.CreateSandboxRequest{Template: "python:3.10-slim"})
+//	res, err := eng.Exec(ctx, sb.SandboxID, boxed.ExecRequest{Language: "python", Code: "print(1)"})
+//
+// Errors returned by Engine methods are *Error values carrying the same codes
+// as the REST API; driver sentinels such as ErrSandboxNotFound can be tested
+// with errors.Is.
+package boxed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"github.com/akshayaggarwal99/boxed/internal/driver/plugin"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/labstack/echo/v4"
+
+	// Register drivers
+	_ "github.com/akshayaggarwal99/boxed/internal/driver/docker"
+	_ "github.com/akshayaggarwal99/boxed/internal/driver/wasm"
+)
+
+// Request, response and configuration types shared with the REST API.
+type (
+	CreateSandboxRequest  = api.CreateSandboxRequest
+	CreateSandboxResponse = api.CreateSandboxResponse
+	ExecRequest           = api.ExecRequest
+	ExecResponse          = api.ExecResponse
+	ExecEvent             = api.ExecEvent
+	TTLRequest            = api.TTLRequest
+	TTLResponse           = api.TTLResponse
+	SignalRequest         = api.SignalRequest
+	SignalResponse        = api.SignalResponse
+	UsageQuery            = api.UsageQuery
+	UsageReport           = api.UsageReport
+	UsageGroup            = api.UsageGroup
+	UsageTotals           = api.UsageTotals
+	TemplateInfo          = api.TemplateInfo
+	TemplateList          = api.TemplateList
+	Descriptor            = api.Descriptor
+	ListFilter            = api.ListFilter
+	StopResult            = api.StopResult
+	StopFailure           = api.StopFailure
+	GitSource             = api.GitSource
+	SecretRequest         = api.SecretRequest
+	SecretInfo            = api.SecretInfo
+	SecretRef             = api.SecretRef
+	Packages              = api.Packages
+	SetupResult           = api.SetupResult
+	SetupStep             = api.SetupStep
+	JobRequest            = api.JobRequest
+	Job                   = api.Job
+	LifecycleEvent        = api.LifecycleEvent
+	EventFilter           = api.EventFilter
+	Error                 = api.APIError
+	DriverRoute           = multi.Route
+
+	ArtifactOptions = proto.ArtifactOptions
+	Artifact        = proto.ArtifactEvent
+
+	NetworkPolicy = driver.NetworkPolicy
+	FileInjection = driver.FileInjection
+	Sidecar       = driver.Sidecar
+	HealthCheck   = driver.HealthCheck
+	Security      = driver.Security
+	SandboxInfo   = driver.SandboxInfo
+	ResourceStats = driver.ResourceStats
+)
+
+// Artifact delivery modes for ArtifactOptions.Delivery.
+const (
+	DeliveryInline   = proto.DeliveryInline
+	DeliveryURL      = proto.DeliveryURL
+	DeliveryManifest = proto.DeliveryManifest
+)
+
+// Errors that can be matched with errors.Is.
+var (
+	ErrSandboxNotFound   = driver.ErrSandboxNotFound
+	ErrSandboxNotRunning = driver.ErrSandboxNotRunning
+	ErrTimeout           = driver.ErrTimeout
+)
+
+// Options configures an Engine.
+type Options struct {
+	// Driver is the sandbox backend: "docker" (default) or "wasm". Several
+	// separated by commas are served together, the first by default; each
+	// gets DriverConfig.
+	Driver string
+
+	// PluginDir holds driver plugins, executables named
+	// boxed-driver-<name> that Driver can then name (default: none); see
+	// package github.com/akshayaggarwal99/boxed/pkg/plugin
+	PluginDir string
+
+	// Routes pick the backend by image when a create names no driver and
+	// several are configured
+	Routes []DriverRoute
+
+	// DriverConfig is passed to the driver factory, e.g. {"agent_path": "..."}.
+	// For the docker driver, "orphan_policy" defaults to "keep" unless set
+	// explicitly: an embedding process must not remove another server's
+	// sandboxes.
+	DriverConfig map[string]any
+
+	// APIKey protects the REST API returned by HTTPHandler. It does not
+	// apply to direct method calls.
+	APIKey string
+
+	// OIDCIssuer makes the REST API also accept bearer tokens from this
+	// OpenID Connect issuer, issued for OIDCAudience if set. The token's
+	// subject is recorded as the owner of the sandboxes it creates.
+	OIDCIssuer   string
+	OIDCAudience string
+
+	// MaxOutput caps the stdout and stderr captured per exec, in bytes
+	// (default: 1 MiB each)
+	MaxOutput int
+
+	// MaxTTL caps the remaining lifetime a sandbox can be created with or
+	// extended to (default: 30m)
+	MaxTTL time.Duration
+
+	// MaxSandboxes refuses creates while this many sandboxes are live
+	// (default: no limit)
+	MaxSandboxes int
+
+	// MaxSandboxAge caps how long a sandbox may live after its creation,
+	// however its TTL is extended (default: no cap)
+	MaxSandboxAge time.Duration
+
+	// DownloadStallTimeout gives up file downloads that make no progress
+	// for as long (default: 1m; negative never gives up)
+	DownloadStallTimeout time.Duration
+
+	// ExpiryWarning is how long before a sandbox expires SubscribeEvents
+	// sends its ttl_warning event (default: 30s)
+	ExpiryWarning time.Duration
+
+	// UsageInterval is how often the CPU time and lifetime of running
+	// sandboxes are recorded for Usage (default: 1m)
+	UsageInterval time.Duration
+
+	// ExecCacheSize is the number of results kept for execs that set Cache
+	// (default: 1024, negative disables the cache)
+	ExecCacheSize int
+
+	// ArtifactDir enables the artifact store: inline artifacts are kept
+	// there, deduplicated by SHA-256, until their sandbox is stopped
+	ArtifactDir string
+
+	// Security hardens the sandboxes created without their own, except
+	// those whose image matches a TrustedImages pattern (path.Match
+	// syntax); HardenedSecurity returns the recommended settings
+	Security      Security
+	TrustedImages []string
+
+	// SeccompDir holds seccomp profiles, <name>.json, that creates and
+	// templates can name besides the built-in ones
+	SeccompDir string
+
+	// TemplatesFile is a YAML file of the templates creates can name, in
+	// the format docs/api.md describes, reloaded while the engine runs
+	// when it changes (default: the built-in python templates)
+	TemplatesFile string
+
+	// StateDir keeps sandbox records, timelines and exec history on disk
+	// instead of in memory
+	StateDir string
+}
+
+// HardenedSecurity returns settings for running untrusted code: a
+// read-only image, no capabilities, no privilege escalation and a process
+// limit.
+func HardenedSecurity() Security {
+	return driver.HardenedSecurity()
+}
+
+// Engine is an in-process Boxed control plane.
+type Engine struct {
+	driver  driver.Driver
+	handler *api.Handler
+
+	// stopWatch stops reloading TemplatesFile
+	stopWatch context.CancelFunc
+}
+
+// New initializes the driver and verifies it is healthy.
+func New(ctx context.Context, opts Options) (*Engine, error) {
+	if opts.Driver == "" {
+		opts.Driver = "docker"
+	}
+	cfg := make(map[string]any, len(opts.DriverConfig)+1)
+	for k, v := range opts.DriverConfig {
+		cfg[k] = v
+	}
+	_, keepSet := cfg["cleanup_orphans"]
+	if _, ok := cfg["orphan_policy"]; !ok && !keepSet {
+		cfg["orphan_policy"] = driver.OrphanKeep
+	}
+
+	if opts.PluginDir != "" {
+		if _, err := plugin.Load(opts.PluginDir); err != nil {
+			return nil, err
+		}
+	}
+	d, err := multi.Open(strings.Split(opts.Driver, ","), cfg, opts.Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	healthCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := d.Healthy(healthCtx); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("driver %s is not healthy: %w", opts.Driver, err)
+	}
+
+	handlerOpts := []api.Option{
+		api.WithMaxOutput(opts.MaxOutput),
+		api.WithMaxTTL(opts.MaxTTL),
+		api.WithExecCacheSize(opts.ExecCacheSize),
+		api.WithMaxSandboxes(opts.MaxSandboxes),
+		api.WithMaxSandboxAge(opts.MaxSandboxAge),
+		api.WithDownloadStallTimeout(opts.DownloadStallTimeout),
+		api.WithExpiryWarning(opts.ExpiryWarning),
+		api.WithUsageInterval(opts.UsageInterval),
+		api.WithSecurity(opts.Security, opts.TrustedImages...),
+		api.WithSeccompDir(opts.SeccompDir),
+	}
+	if opts.StateDir != "" {
+		store, err := state.OpenFileStore(opts.StateDir)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		handlerOpts = append(handlerOpts, api.WithStore(store))
+	}
+	if opts.ArtifactDir != "" {
+		store, err := artifacts.Open(opts.ArtifactDir)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		handlerOpts = append(handlerOpts, api.WithArtifactStore(store))
+	}
+	if opts.OIDCIssuer != "" {
+		verifier, err := auth.NewOIDCVerifier(ctx, auth.Config{Issuer: opts.OIDCIssuer, Audience: opts.OIDCAudience})
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		handlerOpts = append(handlerOpts, api.WithTokenVerifier(verifier))
+	}
+
+	stopWatch := func() {}
+	if opts.TemplatesFile != "" {
+		catalog, err := templates.Load(opts.TemplatesFile)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		var watchCtx context.Context
+		watchCtx, stopWatch = context.WithCancel(context.Background())
+		go catalog.Watch(watchCtx)
+		handlerOpts = append(handlerOpts, api.WithTemplates(catalog))
+	}
+
+	return &Engine{driver: d, handler: api.NewHandler(d, opts.APIKey, handlerOpts...), stopWatch: stopWatch}, nil
+}
+
+// CreateSandbox creates and starts a sandbox.
+func (e *Engine) CreateSandbox(ctx context.Context, req CreateSandboxRequest) (*CreateSandboxResponse, error) {
+	return e.handler.CreateSandbox(ctx, req)
+}
+
+// Exec runs code in a sandbox and waits for it to exit.
+func (e *Engine) Exec(ctx context.Context, id string, req ExecRequest) (*ExecResponse, error) {
+	return e.handler.Exec(ctx, id, req)
+}
+
+// ExecStream runs code like Exec and also calls emit with its output and
+// artifacts as the sandbox produces them.
+func (e *Engine) ExecStream(ctx context.Context, id string, req ExecRequest, emit func(ExecEvent)) (*ExecResponse, error) {
+	return e.handler.ExecStream(ctx, id, req, emit)
+}
+
+// Signal sends a signal to the execs running in a sandbox, or to the REPL
+// of an interactive session.
+func (e *Engine) Signal(ctx context.Context, id string, req SignalRequest) (*SignalResponse, error) {
+	return e.handler.Signal(ctx, id, req)
+}
+
+// Usage reports the CPU time, memory and lifetime sandboxes consumed within
+// a period, grouped by the key that created them, image, label value or
+// sandbox.
+func (e *Engine) Usage(ctx context.Context, q UsageQuery) (*UsageReport, error) {
+	return e.handler.Usage(ctx, q)
+}
+
+// Templates returns the templates creates can name.
+func (e *Engine) Templates(ctx context.Context) *TemplateList {
+	return e.handler.Templates(ctx)
+}
+
+// GetSandbox returns runtime information about a sandbox.
+func (e *Engine) GetSandbox(ctx context.Context, id string) (*SandboxInfo, error) {
+	return e.handler.GetSandbox(ctx, id)
+}
+
+// ListSandboxes returns the sandboxes matching filter.
+func (e *Engine) ListSandboxes(ctx context.Context, filter ListFilter) ([]*SandboxInfo, error) {
+	return e.handler.ListSandboxes(ctx, filter)
+}
+
+// Stats returns a sample of the resources a sandbox uses.
+func (e *Engine) Stats(ctx context.Context, id string) (*ResourceStats, error) {
+	return e.handler.Stats(ctx, id)
+}
+
+// Describe returns what a sandbox offers: interpreters, packages, limits
+// and network policy. The same document is at /run/boxed/descriptor.json
+// inside the sandbox.
+func (e *Engine) Describe(ctx context.Context, id string) (*Descriptor, error) {
+	return e.handler.Describe(ctx, id)
+}
+
+// SetTTL changes when a sandbox expires.
+func (e *Engine) SetTTL(ctx context.Context, id string, req TTLRequest) (*TTLResponse, error) {
+	return e.handler.SetTTL(ctx, id, req)
+}
+
+// StopSandbox stops and removes a sandbox.
+func (e *Engine) StopSandbox(ctx context.Context, id string) error {
+	return e.handler.StopSandbox(ctx, id)
+}
+
+// StopSandboxes stops and removes the sandboxes matching filter. A filter
+// without labels is refused unless all is set.
+func (e *Engine) StopSandboxes(ctx context.Context, filter ListFilter, all bool) (*StopResult, error) {
+	return e.handler.StopSandboxes(ctx, filter, all)
+}
+
+// CreateJob queues an exec in a sandbox and returns without waiting for
+// it. Jobs of a sandbox run one at a time, in order.
+func (e *Engine) CreateJob(ctx context.Context, id string, req JobRequest) (*Job, error) {
+	return e.handler.CreateJob(ctx, id, req)
+}
+
+// GetJob returns the status of a job, and its result once it has run.
+func (e *Engine) GetJob(jobID string) (*Job, error) {
+	return e.handler.GetJob(jobID)
+}
+
+// SetSecret creates a secret sandboxes and execs can be given, or
+// replaces its value.
+func (e *Engine) SetSecret(ctx context.Context, req SecretRequest) (*SecretInfo, error) {
+	return e.handler.SetSecret(ctx, req)
+}
+
+// DeleteSecret removes a secret. Sandboxes it was injected into keep it.
+func (e *Engine) DeleteSecret(ctx context.Context, name string) error {
+	return e.handler.DeleteSecret(ctx, name)
+}
+
+// SubscribeEvents returns a channel of the sandbox lifecycle events
+// matching filter, including ttl_warning shortly before a sandbox expires,
+// and a function to stop receiving them, which must be called. The channel
+// is closed by Drain, or if the caller falls behind.
+func (e *Engine) SubscribeEvents(filter EventFilter) (<-chan LifecycleEvent, func(), error) {
+	return e.handler.SubscribeEvents(filter)
+}
+
+// Drain refuses new sandboxes and waits for in-flight execs and sessions
+// until ctx is done. With stopSandboxes set, running sandboxes are stopped
+// afterwards. Call it before Close when the process is about to exit.
+func (e *Engine) Drain(ctx context.Context, stopSandboxes bool) error {
+	return e.handler.Drain(ctx, stopSandboxes)
+}
+
+// HTTPHandler exposes the REST API (under /v1) backed by this engine, for
+// programs that want to serve it themselves. Sandboxes are shared with the
+// direct method calls.
+func (e *Engine) HTTPHandler() http.Handler {
+	srv := echo.New()
+	srv.HideBanner = true
+	e.handler.RegisterRoutes(srv)
+	return srv
+}
+
+// Close releases the driver. Running sandboxes are not stopped.
+func (e *Engine) Close() error {
+	e.stopWatch()
+	return e.driver.Close()
+}
@@ -0,0 +1,2374 @@
+// Package client is the Go SDK for the Boxed HTTP API.
+//
+//	c := client.New("http://localhost:8080", client.WithAPIKey(key))
+//	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+//	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "print(1)"})
+//	if errors.Is(err, client.ErrSandboxNotFound) { ... }
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the address of a locally running `boxed serve`.
+const DefaultBaseURL = "http://localhost:8080"
+
+// Client talks to a Boxed server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	token      string
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sets the key sent in the X-Boxed-API-Key header.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithBearerToken sends token in the Authorization header, for servers
+// that accept OpenID Connect tokens.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient replaces the default http.Client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// New creates a client for the server at baseURL (e.g. "http://localhost:8080").
+func New(baseURL string, opts ...Option) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/v1",
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Sidecar is a helper process started alongside the sandbox.
+type Sidecar struct {
+	Name        string            `json:"name"`
+	Cmd         []string          `json:"cmd"`
+	Env         map[string]string `json:"env,omitempty"`
+	HealthCheck *HealthCheck      `json:"health_check,omitempty"`
+}
+
+type HealthCheck struct {
+	Cmd        []string `json:"cmd"`
+	IntervalMS int      `json:"interval_ms,omitempty"`
+	Retries    int      `json:"retries,omitempty"`
+}
+
+type FileInjection struct {
+	Path          string `json:"path"`
+	ContentBase64 string `json:"content_base64"`
+}
+
+// NetworkPolicy controls internet access, which goes through the
+// server's egress proxy; servers without one give sandboxes none.
+type NetworkPolicy struct {
+	EnableInternet bool `json:"enable_internet"`
+	// AllowDomains limits the hosts the sandbox reaches; entries like
+	// *.example.com match subdomains too
+	AllowDomains []string `json:"allow_domains,omitempty"`
+}
+
+type CreateSandboxRequest struct {
+	Template string `json:"template"`
+	// Timeout is the sandbox lifetime; it is sent with second precision
+	Timeout       time.Duration     `json:"-"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	NetworkPolicy NetworkPolicy     `json:"network_policy"`
+	Context       []FileInjection   `json:"context,omitempty"`
+	Sidecars      []Sidecar         `json:"sidecars,omitempty"`
+
+	// Workspace names a base workspace (see CreateWorkspace) that the
+	// sandbox sees copy-on-write at its working directory
+	Workspace string `json:"workspace,omitempty"`
+
+	// Volumes are persistent volumes (see CreateVolume) to mount
+	Volumes []VolumeMount `json:"volumes,omitempty"`
+
+	// Driver picks the backend on servers running several (e.g. "docker");
+	// by default the server routes by template
+	Driver string `json:"driver,omitempty"`
+
+	// Git is a repository the server clones into the working directory
+	Git *GitSource `json:"git,omitempty"`
+
+	// User runs code as this user instead of root: a user name, created if
+	// the image lacks it, or "uid[:gid]"
+	User string `json:"user,omitempty"`
+
+	// Secrets are secrets registered with SetSecret to inject; their values
+	// are masked in exec output
+	Secrets []SecretRef `json:"secrets,omitempty"`
+
+	// Packages are installed before the sandbox is ready; the server saves
+	// the result as an image that later creates with the same template and
+	// packages reuse. Docker only.
+	Packages *Packages `json:"packages,omitempty"`
+
+	// Security hardens the sandbox, replacing the server's default; an
+	// empty Security keeps Docker's defaults
+	Security *Security `json:"security,omitempty"`
+
+	// Platform is the image platform to run, e.g. "linux/amd64". By
+	// default the host's, or one the image is built for. Docker only.
+	Platform string `json:"platform,omitempty"`
+
+	// GPU passes GPUs of the host through to the sandbox. Docker only.
+	GPU *GPURequest `json:"gpu,omitempty"`
+
+	// NetworkGroup puts the sandbox on a network shared with the other
+	// sandboxes of the group, and nothing else; they reach it at
+	// NetworkAlias, e.g. "db". Docker only.
+	NetworkGroup string `json:"network_group,omitempty"`
+	NetworkAlias string `json:"network_alias,omitempty"`
+
+	// DNS are up to 3 name server IP addresses replacing the host's in the
+	// sandbox's resolv.conf, with search domains DNSSearch and options
+	// DNSOptions, e.g. "ndots:2". ExtraHosts map host names to addresses
+	// in its /etc/hosts, e.g. "api.internal" to a mock server's;
+	// "host-gateway" is the host's. Docker only.
+	DNS        []string          `json:"dns,omitempty"`
+	DNSSearch  []string          `json:"dns_search,omitempty"`
+	DNSOptions []string          `json:"dns_options,omitempty"`
+	ExtraHosts map[string]string `json:"extra_hosts,omitempty"`
+
+	// Session puts the sandbox in a session group created with
+	// CreateGroup, whose lifetime it shares; Timeout must then be unset.
+	Session string `json:"session,omitempty"`
+}
+
+// GPURequest asks for Count GPUs, of a model whose name contains Type
+// (ignoring case) if it is set. GPUs are not shared: a create fails with
+// ErrQuotaExceeded while too many are in use.
+type GPURequest struct {
+	Count int    `json:"count"`
+	Type  string `json:"type,omitempty"`
+}
+
+// GPU is a GPU of the server's host.
+type GPU struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+// Security restricts what code can do to its container and the host
+// kernel. Docker only.
+type Security struct {
+	// ReadOnlyRootfs mounts the image read-only; the working directory,
+	// /tmp, /output and Tmpfs stay writable
+	ReadOnlyRootfs bool     `json:"read_only_rootfs,omitempty"`
+	Tmpfs          []string `json:"tmpfs,omitempty"`
+
+	// CapDrop and CapAdd are Linux capabilities without the CAP_ prefix;
+	// "ALL" drops them all, and only Docker's defaults can be added back
+	CapDrop []string `json:"cap_drop,omitempty"`
+	CapAdd  []string `json:"cap_add,omitempty"`
+
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+
+	// SeccompProfile names a profile of the server's seccomp directory,
+	// or the built-in "default" or "strict" (no networking). It replaces
+	// the template's.
+	SeccompProfile string `json:"seccomp_profile,omitempty"`
+
+	PidsLimit int64 `json:"pids_limit,omitempty"`
+}
+
+// Packages lists packages to install per package manager: pip requirement
+// specifiers, npm package specifiers and Debian package names.
+type Packages struct {
+	Pip []string `json:"pip,omitempty"`
+	Npm []string `json:"npm,omitempty"`
+	Apt []string `json:"apt,omitempty"`
+}
+
+// SetupResult reports the installation of CreateSandboxRequest.Packages.
+type SetupResult struct {
+	// Image has the packages installed; the sandbox runs from it
+	Image string `json:"image"`
+	// Cached is true if the image existed, so nothing was installed
+	Cached bool        `json:"cached"`
+	Steps  []SetupStep `json:"steps,omitempty"`
+}
+
+// ContextReport describes the injection of CreateSandboxRequest.Context.
+type ContextReport struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// DurationMS is how long the server took to create the sandbox,
+	// injection included
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// SetupStep is an installation command with its output.
+type SetupStep struct {
+	Manager    string `json:"manager"`
+	Command    string `json:"command"`
+	ExitCode   *int   `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// SecretRef injects a registered secret: as the environment variable Env,
+// which defaults to the secret's name unless Path is set, as the file at
+// Path, or both.
+type SecretRef struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// Secret describes a registered secret. Its value is never returned.
+type Secret struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RegistryCredential authenticates the image pulls from a private
+// registry: Username and Password, or Helper, the Docker credential helper
+// docker-credential-<Helper>. Passwords are never returned.
+type RegistryCredential struct {
+	Registry string `json:"registry"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Helper   string `json:"helper,omitempty"`
+}
+
+// Node is a control-plane node of a cluster sharing its state.
+type Node struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	StartedAt   time.Time `json:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	// Alive is false once the node missed heartbeats for the node timeout
+	Alive bool `json:"alive"`
+	// Self is true for the node that answered
+	Self bool `json:"self"`
+	// Sandboxes is the number of live sandboxes the node serves
+	Sandboxes int `json:"sandboxes"`
+}
+
+// Template is a template of the server's catalog, which
+// CreateSandboxRequest.Template can name.
+type Template struct {
+	Name     string  `json:"name"`
+	Image    string  `json:"image"`
+	MemoryMB int64   `json:"memory_mb"`
+	CPUCores float64 `json:"cpu_cores"`
+	// CPUBurst is the template's CPU burst, if it has one
+	CPUBurst *CPUBurst `json:"cpu_burst,omitempty"`
+	// Timeout is the lifetime in seconds of sandboxes created without
+	// one, if the template sets it
+	Timeout int `json:"timeout,omitempty"`
+	// Init is the script run in each sandbox before it is ready, and
+	// InitTimeout its limit in seconds, if the template has one
+	Init        string `json:"init,omitempty"`
+	InitTimeout int    `json:"init_timeout,omitempty"`
+	// Driver is the backend the template's sandboxes run on, if it names
+	// one
+	Driver string `json:"driver,omitempty"`
+	// Seccomp is the seccomp profile of the template's sandboxes, if it
+	// sets one
+	Seccomp string `json:"seccomp,omitempty"`
+	// Default marks the template of creates that name none
+	Default bool `json:"default,omitempty"`
+	// Published marks templates saved from a sandbox with PublishTemplate
+	Published bool `json:"published,omitempty"`
+}
+
+// CPUBurst lets the sandboxes of a template run above their CPU limit for
+// short stretches: the limit is enforced on average over periods of
+// PeriodMS milliseconds rather than the kernel's 100, so commands needing
+// less CPU time than the limit allows in a period run at full speed.
+// Shares weighs the sandboxes against others when the host's CPUs are
+// contended; others weigh 1024.
+type CPUBurst struct {
+	PeriodMS int64 `json:"period_ms,omitempty"`
+	Shares   int64 `json:"shares,omitempty"`
+}
+
+// PublishTemplateRequest names the template PublishTemplate saves a
+// sandbox as, "<name>:<tag>".
+type PublishTemplateRequest struct {
+	Name string `json:"name"`
+	// Tag is "latest" if empty
+	Tag string `json:"tag,omitempty"`
+	// Init is a script run in each sandbox of the template before it is
+	// ready, such as to start services again: processes are not saved.
+	// InitTimeout bounds it in seconds.
+	Init        string `json:"init,omitempty"`
+	InitTimeout int    `json:"init_timeout,omitempty"`
+}
+
+// BuildImageRequest is built by BuildImage into the template
+// "<name>:<tag>".
+type BuildImageRequest struct {
+	Name string
+	// Tag is "latest" if empty
+	Tag string
+	// Init and InitTimeout are those of PublishTemplateRequest
+	Init        string
+	InitTimeout int
+	// MemoryMB and CPUCores are the template's resources; the server's
+	// defaults if 0
+	MemoryMB int64
+	CPUCores float64
+
+	// Dockerfile is the content of the Dockerfile; if empty, the one at
+	// the root of Context is built
+	Dockerfile string
+	// Context is the build context, a tar archive, optionally gzipped;
+	// nil if the Dockerfile copies nothing in
+	Context io.Reader
+	// BuildArgs are the values of the Dockerfile's ARGs
+	BuildArgs map[string]string
+}
+
+// UsageTotals is what sandboxes consumed within a period.
+type UsageTotals struct {
+	Sandboxes int `json:"sandboxes"`
+	// CPUSeconds is the CPU time consumed, where the driver reports it
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// MemoryMBHours is the memory allocated times how long it was
+	MemoryMBHours float64 `json:"memory_mb_hours"`
+	// WallSeconds is the time the sandboxes existed
+	WallSeconds float64 `json:"wall_seconds"`
+}
+
+// UsageGroup is the usage of the sandboxes sharing a key, image, label
+// value or ID.
+type UsageGroup struct {
+	Group string `json:"group"`
+	UsageTotals
+}
+
+// UsageReport is the usage of a period, by group.
+type UsageReport struct {
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	GroupBy string       `json:"group_by"`
+	Groups  []UsageGroup `json:"groups"`
+	Total   UsageTotals  `json:"total"`
+}
+
+// UsageQuery selects the usage to report. Zero values mean the start of
+// the current month (UTC), now, and grouping by the key that created the
+// sandboxes.
+type UsageQuery struct {
+	From time.Time
+	To   time.Time
+	// GroupBy is "key", "image", "sandbox" or "label:<name>"
+	GroupBy string
+}
+
+func (q UsageQuery) values() url.Values {
+	v := url.Values{}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.Format(time.RFC3339Nano))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.Format(time.RFC3339Nano))
+	}
+	if q.GroupBy != "" {
+		v.Set("group_by", q.GroupBy)
+	}
+	return v
+}
+
+// Lifecycle event types sent by SubscribeEvents.
+const (
+	EventCreated    = "created"
+	EventReady      = "ready"
+	EventTTLWarning = "ttl_warning"
+	EventStopped    = "stopped"
+	EventErrored    = "errored"
+)
+
+// LifecycleEvent is a sandbox state transition.
+type LifecycleEvent struct {
+	Type      string            `json:"type"`
+	SandboxID string            `json:"sandbox_id"`
+	At        time.Time         `json:"at"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// ExpiresAt is when the TTL reaps the sandbox; set on ready and
+	// ttl_warning events
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Reason is why the sandbox stopped, e.g. "api" or "ttl_expired"
+	Reason string `json:"reason,omitempty"`
+	// Error describes what went wrong for errored events
+	Error string `json:"error,omitempty"`
+}
+
+// GitSource names a repository to clone into a new sandbox.
+type GitSource struct {
+	// URL is the http(s) URL of the repository
+	URL string `json:"url"`
+	// Ref is a branch, tag or commit; by default the default branch
+	Ref string `json:"ref,omitempty"`
+	// Token authenticates to private repositories
+	Token string `json:"token,omitempty"`
+}
+
+type SidecarStatus struct {
+	Name     string `json:"name"`
+	Running  bool   `json:"running"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+}
+
+// SandboxConfig is the configuration a sandbox was created with.
+type SandboxConfig struct {
+	Image    string            `json:"image"`
+	MemoryMB int64             `json:"memory_mb"`
+	CPUCores float64           `json:"cpu_cores"`
+	CPUBurst *CPUBurst         `json:"cpu_burst,omitempty"`
+	WorkDir  string            `json:"work_dir,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	Workspace string        `json:"workspace,omitempty"`
+	Volumes   []VolumeMount `json:"volumes,omitempty"`
+
+	NetworkGroup string `json:"network_group,omitempty"`
+	NetworkAlias string `json:"network_alias,omitempty"`
+
+	DNS        []string          `json:"dns,omitempty"`
+	DNSSearch  []string          `json:"dns_search,omitempty"`
+	DNSOptions []string          `json:"dns_options,omitempty"`
+	ExtraHosts map[string]string `json:"extra_hosts,omitempty"`
+
+	// Security is the sandbox's hardening; its SeccompProfile is the
+	// built-in profile or the path of the profile on the server
+	Security *Security `json:"security,omitempty"`
+}
+
+// WorkspaceRequest describes a base workspace to create.
+type WorkspaceRequest struct {
+	Name  string          `json:"name"`
+	Files []FileInjection `json:"files,omitempty"`
+
+	// Image fills the workspace on the Docker driver; it should be the
+	// image of the sandboxes that use it
+	Image string `json:"image,omitempty"`
+}
+
+// Workspace is a base workspace shared by sandboxes.
+type Workspace struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// SizeBytes is -1 if the server cannot tell
+	SizeBytes int64 `json:"size_bytes"`
+	// InUse is the number of live sandboxes using the workspace
+	InUse int `json:"in_use"`
+	// Labels record who created it
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// VolumeMount mounts a volume into a sandbox at MountPath.
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	ReadOnly  bool   `json:"read_only,omitempty"`
+}
+
+// Volume is a persistent volume that sandboxes mount and share.
+type Volume struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// SizeBytes is -1 if the server cannot tell
+	SizeBytes int64 `json:"size_bytes"`
+	// InUse is the number of live sandboxes mounting the volume
+	InUse int `json:"in_use"`
+	// Labels record who created it
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type Sandbox struct {
+	ID         string          `json:"id"`
+	State      string          `json:"state"`
+	CreatedAt  time.Time       `json:"created_at"`
+	DriverType string          `json:"driver_type"`
+	Sidecars   []SidecarStatus `json:"sidecars,omitempty"`
+	ExpiresAt  *time.Time      `json:"expires_at,omitempty"`
+	Config     SandboxConfig   `json:"config"`
+
+	// BackendID is the driver's own ID for the sandbox, e.g. its container
+	BackendID string `json:"backend_id,omitempty"`
+
+	// Error says what went wrong if State is "error" or "failed"
+	Error string `json:"error,omitempty"`
+	// ExitReason is "oom_killed" or "sandbox_died" if the sandbox stopped
+	// on its own
+	ExitReason string `json:"exit_reason,omitempty"`
+
+	// Setup reports the package installation; only CreateSandbox sets it
+	Setup *SetupResult `json:"setup,omitempty"`
+
+	// Context reports the injection of the context files; only
+	// CreateSandbox sets it
+	Context *ContextReport `json:"context,omitempty"`
+
+	// Init is the run of the template's init script; only CreateSandbox
+	// sets it, see Client.Init
+	Init *ExecRecord `json:"init,omitempty"`
+
+	// Platform is the "os/arch" of the sandbox's image; Emulated is set
+	// when the host runs it under emulation
+	Platform string `json:"platform,omitempty"`
+	Emulated bool   `json:"emulated,omitempty"`
+
+	// AgentCrashes counts the agents in the sandbox that crashed or
+	// stopped responding, and were started again
+	AgentCrashes int `json:"agent_crashes,omitempty"`
+
+	// GPUs are the GPUs passed through to the sandbox
+	GPUs []GPU `json:"gpus,omitempty"`
+
+	// Seccomp names the seccomp profile the sandbox runs under, such as
+	// "default" or "strict"; drivers that do not filter syscalls leave it
+	// empty
+	Seccomp string `json:"seccomp,omitempty"`
+
+	// Phase is the step of its lifecycle the sandbox is in, such as
+	// "pulling-image" or "ready", and Phases when it entered each, which
+	// tells where a slow create spent its time
+	Phase  string            `json:"phase,omitempty"`
+	Phases []PhaseTransition `json:"phases,omitempty"`
+
+	// Warnings are things to know about a new sandbox, such as it running
+	// under emulation; only CreateSandbox sets them
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PhaseTransition is the moment a sandbox entered a phase of its
+// lifecycle: "creating", "pulling-image", "injecting-context",
+// "starting", "agent-waiting", "initializing", "ready" or "stopping". It
+// lasted until the next transition.
+type PhaseTransition struct {
+	Phase string    `json:"phase"`
+	At    time.Time `json:"at"`
+}
+
+// ListOption narrows ListSandboxes.
+type ListOption func(url.Values)
+
+// LabelFilter only lists sandboxes whose metadata has key set to value.
+// With an empty value, having the key is enough.
+func LabelFilter(key, value string) ListOption {
+	return func(q url.Values) {
+		if value == "" {
+			q.Add("label", key)
+		} else {
+			q.Add("label", key+"="+value)
+		}
+	}
+}
+
+// StateFilter only lists sandboxes in one of states (e.g. "ready").
+func StateFilter(states ...string) ListOption {
+	return func(q url.Values) {
+		for _, s := range states {
+			q.Add("state", s)
+		}
+	}
+}
+
+// EventTypeFilter only sends SubscribeEvents events of the given types
+// (e.g. EventTTLWarning).
+func EventTypeFilter(types ...string) ListOption {
+	return func(q url.Values) {
+		for _, t := range types {
+			q.Add("type", t)
+		}
+	}
+}
+
+// AllSandboxes lets DeleteSandboxes match sandboxes without a LabelFilter.
+func AllSandboxes() ListOption {
+	return func(q url.Values) {
+		q.Set("all", "true")
+	}
+}
+
+type ExecRequest struct {
+	Code string `json:"code"`
+
+	// Language is python, python-session, javascript, bash or
+	// bash-session. With python-session the code runs in an interpreter
+	// kept per sandbox, so variables and imports carry over to the next
+	// exec; with bash-session in a shell kept per sandbox, so the working
+	// directory and environment do.
+	Language string `json:"language"`
+
+	// File runs a script already in the sandbox instead of Code, such as
+	// "/workspace/run.py", with Args; Language then defaults to the one of
+	// its extension (.py, .js, .sh)
+	File string   `json:"file,omitempty"`
+	Args []string `json:"args,omitempty"`
+
+	// Cwd is the directory to run in, absolute or relative to the sandbox's
+	// working directory
+	Cwd string `json:"cwd,omitempty"`
+
+	// User runs the code as this user instead of the sandbox's default
+	User string `json:"user,omitempty"`
+
+	// Env adds environment variables for this exec only
+	Env map[string]string `json:"env,omitempty"`
+
+	// Secrets are injected for this exec: variables for this exec only,
+	// files written before it runs. Not allowed with python-session or
+	// bash-session.
+	Secrets []SecretRef `json:"secrets,omitempty"`
+
+	// SpillOutput stores the full output in the sandbox when it exceeds the
+	// server's capture limit; the files are listed in ExecResult.Artifacts.
+	SpillOutput bool `json:"spill_output,omitempty"`
+
+	// Artifacts controls which files are returned as artifacts; nil keeps
+	// the server defaults (/output, inline, 10 MB).
+	Artifacts *ArtifactOptions `json:"artifacts,omitempty"`
+
+	// Cache lets the server answer with the result of identical code run in
+	// a sandbox created with the same image and context, without running
+	// it. Only successful execs are cached.
+	Cache bool `json:"cache,omitempty"`
+
+	// IfBusy is what the exec does when its sandbox already runs as many
+	// execs as the server allows: IfBusyQueue (the default) waits its
+	// turn, IfBusyReject fails with ErrSandboxBusy
+	IfBusy string `json:"if_busy,omitempty"`
+}
+
+// What an exec does when its sandbox is busy, ExecRequest.IfBusy.
+const (
+	IfBusyQueue  = "queue"
+	IfBusyReject = "reject"
+)
+
+// Artifact delivery modes.
+const (
+	DeliveryInline   = "inline"
+	DeliveryURL      = "url"
+	DeliveryManifest = "manifest"
+)
+
+type ArtifactOptions struct {
+	// WatchPaths are absolute directories watched instead of /output
+	WatchPaths []string `json:"watch_paths,omitempty"`
+	// MaxSize skips larger files, in bytes
+	MaxSize int64 `json:"max_size,omitempty"`
+	// MIMETypes keeps only matching files: "image/png" or "image/*"
+	MIMETypes []string `json:"mime_types,omitempty"`
+	// Delivery is DeliveryInline (contents in DataBase64), DeliveryURL
+	// (download link in URL) or DeliveryManifest (path, size and MIME type
+	// only; see Artifact.SandboxPath)
+	Delivery string `json:"delivery,omitempty"`
+}
+
+type Artifact struct {
+	Path       string `json:"path"`
+	MIME       string `json:"mime"`
+	Size       int64  `json:"size,omitempty"`
+	DataBase64 string `json:"data_base64,omitempty"`
+	URL        string `json:"url,omitempty"`
+
+	// SHA256 is set when the server keeps artifacts in its store;
+	// Deduplicated means identical content was already stored.
+	SHA256       string `json:"sha256,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+}
+
+// SandboxPath is the absolute path of the artifact in its sandbox, for
+// DownloadFile: paths under /output are reported relative to it.
+func (a Artifact) SandboxPath() string {
+	if path.IsAbs(a.Path) {
+		return a.Path
+	}
+	return path.Join("/output", a.Path)
+}
+
+type ExecResult struct {
+	Stdout    string     `json:"stdout"`
+	Stderr    string     `json:"stderr"`
+	Artifacts []Artifact `json:"artifacts"`
+	ExitCode  *int       `json:"exit_code"`
+
+	// Truncated is set when stdout or stderr exceeded the capture limit;
+	// StdoutBytes and StderrBytes hold the full sizes.
+	Truncated   bool  `json:"truncated"`
+	StdoutBytes int64 `json:"stdout_bytes"`
+	StderrBytes int64 `json:"stderr_bytes"`
+
+	// SpillTruncated is set when a spilled stream was too large to spill
+	// whole, so its artifact holds only the start of it
+	SpillTruncated bool `json:"spill_truncated,omitempty"`
+
+	// Cached is set when the result came from the server's exec cache
+	Cached bool `json:"cached,omitempty"`
+
+	// QueuePosition is the place the exec took among those waiting for
+	// other execs of the sandbox to finish, and QueuedMS how long it
+	// waited; both are zero if it ran at once
+	QueuePosition int   `json:"queue_position,omitempty"`
+	QueuedMS      int64 `json:"queued_ms,omitempty"`
+
+	// ExitReason says why the process ended abnormally: "signaled",
+	// "oom_killed", "sandbox_died" or "agent_crashed"; with the latter two
+	// ExitCode is nil. Signal is the signal that killed it.
+	ExitReason string `json:"exit_reason,omitempty"`
+	Signal     int    `json:"signal,omitempty"`
+
+	// ErrorKind says why the exec failed when it failed for a reason other
+	// than the code's own, one of the ErrorKind constants; ErrorMessage is
+	// the sandbox's description of it.
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// Kinds of exec failures, ExecResult.ErrorKind. The exit reasons
+// "oom_killed", "sandbox_died" and "agent_crashed" are kinds too.
+const (
+	ErrorKindCommandNotFound  = "command_not_found"
+	ErrorKindPermissionDenied = "permission_denied"
+	ErrorKindCwdNotFound      = "cwd_not_found"
+	ErrorKindUserNotFound     = "user_not_found"
+	ErrorKindTimedOut         = "timed_out"
+	ErrorKindOOMKilled        = "oom_killed"
+	ErrorKindSandboxDied      = "sandbox_died"
+	ErrorKindAgentCrashed     = "agent_crashed"
+	ErrorKindInternal         = "internal"
+)
+
+// Types of ExecEvent.
+const (
+	ExecEventStdout   = "stdout"
+	ExecEventStderr   = "stderr"
+	ExecEventArtifact = "artifact"
+	ExecEventQueued   = "queued"
+	ExecEventExit     = "exit"
+)
+
+// ExecEvent is a step of an exec run with ExecStream.
+type ExecEvent struct {
+	Type string `json:"type"`
+
+	// Chunk is the output of stdout and stderr events
+	Chunk string `json:"chunk,omitempty"`
+
+	// Artifact is set on artifact events
+	Artifact *Artifact `json:"artifact,omitempty"`
+
+	// QueuePosition is set on the queued event, sent when the exec waits
+	// for its sandbox, and on the exit event
+	QueuePosition int `json:"queue_position,omitempty"`
+
+	// On the exit event, the fields of ExecResult other than the output
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	ExitReason  string `json:"exit_reason,omitempty"`
+	Signal      int    `json:"signal,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	StdoutBytes int64  `json:"stdout_bytes,omitempty"`
+	StderrBytes int64  `json:"stderr_bytes,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+	QueuedMS    int64  `json:"queued_ms,omitempty"`
+
+	ErrorKind    string `json:"error_kind,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	Error *APIError `json:"error,omitempty"`
+}
+
+// Job states.
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// JobRequest is an exec to run in the background with CreateJob.
+type JobRequest struct {
+	ExecRequest
+
+	// Timeout bounds the run, not counting the time queued, in seconds;
+	// 0 means the server default of 10 minutes
+	Timeout int `json:"timeout,omitempty"`
+
+	// CallbackURL is sent a POST of the finished Job
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Job is an exec queued with CreateJob. Jobs of a sandbox run one at a
+// time, in the order they were queued.
+type Job struct {
+	ID        string `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	Status    string `json:"status"`
+	Language  string `json:"language"`
+
+	// Result is set once the exec has run, whatever its exit code
+	Result *ExecResult `json:"result,omitempty"`
+
+	// Error and ErrorCode say why a failed or canceled job has no result
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+
+	CallbackURL string       `json:"callback_url,omitempty"`
+	Callback    *JobCallback `json:"callback,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job is done, failed or canceled.
+func (j *Job) Finished() bool {
+	return j.FinishedAt != nil
+}
+
+// JobCallback reports the delivery of a job's completion callback.
+type JobCallback struct {
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+type ExecRecord struct {
+	Seq        int       `json:"seq"`
+	Language   string    `json:"language"`
+	Code       string    `json:"code"`
+	File       string    `json:"file,omitempty"`
+	Args       []string  `json:"args,omitempty"`
+	ExitCode   *int      `json:"exit_code"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	QueuedMS   int64     `json:"queued_ms,omitempty"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Truncated  bool      `json:"truncated"`
+	Error      string    `json:"error,omitempty"`
+	Cached     bool      `json:"cached,omitempty"`
+	ExitReason string    `json:"exit_reason,omitempty"`
+	ErrorKind  string    `json:"error_kind,omitempty"`
+}
+
+type TimelineEvent struct {
+	Type       string    `json:"type"`
+	At         time.Time `json:"at"`
+	OffsetMS   int64     `json:"offset_ms"`
+	DurationMS int64     `json:"duration_ms"`
+	Detail     string    `json:"detail,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// LogLine is one line of a sandbox log; see Client.Logs.
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	// Exec identifies the agent process that wrote the line, if known
+	Exec string `json:"exec,omitempty"`
+	Text string `json:"text"`
+}
+
+// Log sources for Client.Logs.
+const (
+	// LogSourceAgent is what the agent running execs reported
+	LogSourceAgent = "agent"
+	// LogSourceProcess is the output of the sandbox's main process
+	LogSourceProcess = "process"
+)
+
+// ResourceStats is a sample of a sandbox's resource usage; see
+// Client.Stats. Drivers leave what they cannot measure zero, or nil for
+// the CPU fields.
+type ResourceStats struct {
+	Time time.Time `json:"time"`
+	// CPUNanos is the CPU time used since the sandbox started
+	CPUNanos *uint64 `json:"cpu_ns,omitempty"`
+	// CPUPercent is the CPU used since the previous streamed sample, 100
+	// per fully used core
+	CPUPercent       *float64 `json:"cpu_percent,omitempty"`
+	MemoryBytes      uint64   `json:"memory_bytes"`
+	MemoryLimitBytes uint64   `json:"memory_limit_bytes,omitempty"`
+	PIDs             uint64   `json:"pids,omitempty"`
+	DiskBytes        uint64   `json:"disk_bytes,omitempty"`
+	DiskReadBytes    uint64   `json:"disk_read_bytes,omitempty"`
+	DiskWriteBytes   uint64   `json:"disk_write_bytes,omitempty"`
+}
+
+// Descriptor describes what a sandbox offers; see Client.Describe.
+type Descriptor struct {
+	SandboxID    string        `json:"sandbox_id"`
+	Driver       string        `json:"driver"`
+	Image        string        `json:"image"`
+	OS           string        `json:"os,omitempty"`
+	Interpreters []Interpreter `json:"interpreters"`
+	Packages     []Package     `json:"packages"`
+	Limits       struct {
+		CPUCores       float64    `json:"cpu_cores"`
+		MemoryMB       int64      `json:"memory_mb"`
+		MaxOutputBytes int        `json:"max_output_bytes"`
+		ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	} `json:"limits"`
+	Network       NetworkPolicy `json:"network"`
+	WorkDir       string        `json:"work_dir"`
+	WritablePaths []string      `json:"writable_paths"`
+	GeneratedAt   time.Time     `json:"generated_at"`
+}
+
+type Interpreter struct {
+	// Languages are the ExecRequest.Language values that run it
+	Languages []string `json:"languages"`
+	Command   string   `json:"command"`
+	Version   string   `json:"version,omitempty"`
+}
+
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Manager string `json:"manager"`
+}
+
+type FileEntry struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Mode         int64     `json:"mode"`
+	IsDir        bool      `json:"is_dir"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// CreateSandbox creates and starts a sandbox.
+func (c *Client) CreateSandbox(ctx context.Context, req CreateSandboxRequest) (*Sandbox, error) {
+	var resp createSandboxResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox", createSandboxBody(req), &resp); err != nil {
+		return nil, err
+	}
+	return resp.sandbox(), nil
+}
+
+// createSandboxBody is the body of a create, with its timeout in seconds.
+func createSandboxBody(req CreateSandboxRequest) any {
+	return struct {
+		CreateSandboxRequest
+		Timeout int `json:"timeout,omitempty"`
+	}{req, int(req.Timeout / time.Second)}
+}
+
+type createSandboxResponse struct {
+	SandboxID string         `json:"sandbox_id"`
+	Status    string         `json:"status"`
+	Setup     *SetupResult   `json:"setup"`
+	Context   *ContextReport `json:"context"`
+	Init      *ExecRecord    `json:"init"`
+	Platform  string         `json:"platform"`
+	Emulated  bool           `json:"emulated"`
+	GPUs      []GPU          `json:"gpus"`
+	Warnings  []string       `json:"warnings"`
+}
+
+func (r *createSandboxResponse) sandbox() *Sandbox {
+	return &Sandbox{
+		ID:       r.SandboxID,
+		State:    r.Status,
+		Setup:    r.Setup,
+		Context:  r.Context,
+		Init:     r.Init,
+		Platform: r.Platform,
+		Emulated: r.Emulated,
+		GPUs:     r.GPUs,
+		Warnings: r.Warnings,
+	}
+}
+
+// GetSandbox returns runtime information about a sandbox.
+func (c *Client) GetSandbox(ctx context.Context, id string) (*Sandbox, error) {
+	var sb Sandbox
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id), nil, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// Init returns the run of the init script of a sandbox's template, also
+// of one whose script failed its create.
+func (c *Client) Init(ctx context.Context, id string) (*ExecRecord, error) {
+	var rec ExecRecord
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/init", nil, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Describe probes a sandbox for its interpreters and packages and returns
+// them with its limits and network policy, e.g. to put in an agent prompt.
+func (c *Client) Describe(ctx context.Context, id string) (*Descriptor, error) {
+	var desc Descriptor
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/descriptor", nil, &desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+// SetTTL sets the remaining lifetime of a sandbox and returns its new expiry.
+func (c *Client) SetTTL(ctx context.Context, id string, ttl time.Duration) (time.Time, error) {
+	return c.changeTTL(ctx, id, map[string]int{"ttl": int(ttl / time.Second)})
+}
+
+// ExtendTTL moves the expiry of a sandbox by d, which may be negative, and
+// returns the new expiry.
+func (c *Client) ExtendTTL(ctx context.Context, id string, d time.Duration) (time.Time, error) {
+	return c.changeTTL(ctx, id, map[string]int{"extend_by": int(d / time.Second)})
+}
+
+func (c *Client) changeTTL(ctx context.Context, id string, body map[string]int) (time.Time, error) {
+	var resp struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/ttl", body, &resp); err != nil {
+		return time.Time{}, err
+	}
+	return resp.ExpiresAt, nil
+}
+
+// Hibernate stops the processes of a sandbox but keeps its files, until
+// Wake starts it again with the same ID. Its TTL does not run meanwhile.
+func (c *Client) Hibernate(ctx context.Context, id string) (*Sandbox, error) {
+	var sb Sandbox
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/hibernate", nil, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// Wake starts a hibernated sandbox again, giving it ttl to live, or what
+// was left of its TTL when it hibernated if ttl is zero.
+func (c *Client) Wake(ctx context.Context, id string, ttl time.Duration) (*Sandbox, error) {
+	body := map[string]int{"ttl": int(ttl / time.Second)}
+	var sb Sandbox
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/wake", body, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// Resources are the memory and CPU limits of a sandbox.
+type Resources struct {
+	MemoryMB int64   `json:"memory_mb,omitempty"`
+	CPUCores float64 `json:"cpu_cores,omitempty"`
+}
+
+// UpdateResources raises or lowers the limits of a running sandbox; zero
+// fields keep theirs. It returns the limits the sandbox has now.
+func (c *Client) UpdateResources(ctx context.Context, id string, r Resources) (*Resources, error) {
+	var resp Resources
+	if err := c.doJSON(ctx, http.MethodPatch, "/sandbox/"+url.PathEscape(id)+"/resources", r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// FSChange is a path a sandbox added, changed or removed; Kind is
+// "added", "changed" or "removed".
+type FSChange struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// FSDiff returns the changes a sandbox made to its filesystem since it was
+// created, sorted by path. A non-empty dir keeps those at or under it.
+// /tmp, /output, volumes and workspaces are not tracked.
+func (c *Client) FSDiff(ctx context.Context, id, dir string) ([]FSChange, error) {
+	var resp struct {
+		Changes []FSChange `json:"changes"`
+	}
+	p := "/sandbox/" + url.PathEscape(id) + "/fsdiff"
+	if dir != "" {
+		p += "?" + url.Values{"path": {dir}}.Encode()
+	}
+	if err := c.doJSON(ctx, http.MethodGet, p, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Changes, nil
+}
+
+// DirUsage is the space the files under a directory of a sandbox take.
+// LimitBytes and AvailableBytes are set only when the directory is on a
+// filesystem of the sandbox's own, such as the /output tmpfs.
+type DirUsage struct {
+	Path           string `json:"path"`
+	UsedBytes      int64  `json:"used_bytes"`
+	Files          int64  `json:"files"`
+	LimitBytes     int64  `json:"limit_bytes,omitempty"`
+	AvailableBytes int64  `json:"available_bytes,omitempty"`
+}
+
+// FilesUsage is the space the files of a sandbox take under the
+// directories asked for.
+type FilesUsage struct {
+	UsedBytes int64      `json:"used_bytes"`
+	Paths     []DirUsage `json:"paths"`
+}
+
+// FilesUsage measures the files under dirs, absolute paths in the sandbox,
+// or under /workspace and /output if none are given.
+func (c *Client) FilesUsage(ctx context.Context, id string, dirs ...string) (*FilesUsage, error) {
+	var usage FilesUsage
+	p := "/sandbox/" + url.PathEscape(id) + "/files/usage"
+	if len(dirs) > 0 {
+		p += "?" + url.Values{"path": dirs}.Encode()
+	}
+	if err := c.doJSON(ctx, http.MethodGet, p, nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ListSandboxes returns the sandboxes managed by the server, optionally
+// narrowed by LabelFilter and StateFilter.
+func (c *Client) ListSandboxes(ctx context.Context, opts ...ListOption) ([]Sandbox, error) {
+	var resp struct {
+		Sandboxes []Sandbox `json:"sandboxes"`
+	}
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	p := "/sandbox"
+	if len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+	if err := c.doJSON(ctx, http.MethodGet, p, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sandboxes, nil
+}
+
+// DeleteSandbox stops and removes a sandbox.
+func (c *Client) DeleteSandbox(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/sandbox/"+url.PathEscape(id), nil, nil)
+}
+
+// DeleteResult reports which sandboxes DeleteSandboxes stopped.
+type DeleteResult struct {
+	Stopped []string `json:"stopped"`
+	Failed  []struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	} `json:"failed"`
+}
+
+// DeleteSandboxes stops and removes every sandbox matching opts, which
+// must include a LabelFilter or AllSandboxes. Sandboxes that could not be
+// stopped are listed in Failed rather than returned as an error.
+func (c *Client) DeleteSandboxes(ctx context.Context, opts ...ListOption) (*DeleteResult, error) {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	var res DeleteResult
+	if err := c.doJSON(ctx, http.MethodDelete, "/sandbox?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CreateGroupRequest starts a session group.
+type CreateGroupRequest struct {
+	// ID names the group, e.g. after a conversation; by default the
+	// server picks one
+	ID string `json:"id,omitempty"`
+
+	// TTL is the lifetime of the group and its sandboxes in seconds; by
+	// default 5 minutes
+	TTL int `json:"ttl,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Group is a session group: the sandboxes an agent uses for one
+// conversation, e.g. a browser, code and database sandbox, which share a
+// lifetime and are stopped together.
+type Group struct {
+	ID        string            `json:"id"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+
+	// Status is "empty", "creating", "ready", "degraded" or "stopped"
+	Status string `json:"status"`
+
+	// States counts the sandboxes in each state
+	States map[string]int `json:"states"`
+
+	// Sandboxes are only listed by GetGroup
+	Sandboxes []Sandbox `json:"sandboxes,omitempty"`
+}
+
+// CreateGroup starts a session group. Sandboxes join it when created with
+// its ID in CreateSandboxRequest.Session.
+func (c *Client) CreateGroup(ctx context.Context, req CreateGroupRequest) (*Group, error) {
+	var g Group
+	if err := c.doJSON(ctx, http.MethodPost, "/sessions", req, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListGroups returns the caller's session groups, oldest first.
+func (c *Client) ListGroups(ctx context.Context) ([]Group, error) {
+	var resp struct {
+		Sessions []Group `json:"sessions"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sessions, nil
+}
+
+// GetGroup describes a session group with its sandboxes. It fails with
+// ErrNotFound if there is none.
+func (c *Client) GetGroup(ctx context.Context, id string) (*Group, error) {
+	var g Group
+	if err := c.doJSON(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id), nil, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GroupTTL is the new expiry of a session group. Failed lists the
+// sandboxes whose lifetime could not follow, e.g. past the server's
+// maximum age.
+type GroupTTL struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Failed    []struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	} `json:"failed"`
+}
+
+// SetGroupTTL sets the remaining lifetime of a session group and of its
+// running sandboxes.
+func (c *Client) SetGroupTTL(ctx context.Context, id string, ttl time.Duration) (*GroupTTL, error) {
+	var resp GroupTTL
+	body := map[string]int{"ttl": int(ttl / time.Second)}
+	if err := c.doJSON(ctx, http.MethodPost, "/sessions/"+url.PathEscape(id)+"/ttl", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteGroup ends a session group and stops its sandboxes. Sandboxes that
+// could not be stopped are listed in Failed rather than returned as an
+// error.
+func (c *Client) DeleteGroup(ctx context.Context, id string) (*DeleteResult, error) {
+	var res DeleteResult
+	if err := c.doJSON(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Exec runs code in a sandbox and waits for it to finish.
+func (c *Client) Exec(ctx context.Context, id string, req ExecRequest) (*ExecResult, error) {
+	var res ExecResult
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/exec", req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ExecStream runs code in a sandbox, calling fn with its output and
+// artifacts as they are produced, and returns the exit event. The output is
+// not capped by the server's limit, only the copy it records. An error from
+// fn stops reading and is returned.
+func (c *Client) ExecStream(ctx context.Context, id string, req ExecRequest, fn func(ExecEvent) error) (*ExecEvent, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := c.newRequest(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/exec", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/x-ndjson")
+	resp, err := c.do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev ExecEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil, fmt.Errorf("boxed: exec stream ended without an exit")
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("boxed: decode exec event: %w", err)
+		}
+		switch ev.Type {
+		case ExecEventExit:
+			return &ev, nil
+		case "error":
+			if ev.Error == nil {
+				ev.Error = &APIError{Message: "exec failed"}
+			}
+			ev.Error.StatusCode = resp.StatusCode
+			return nil, ev.Error
+		}
+		if err := fn(ev); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Signal sends signal, such as "SIGINT" or "SIGKILL", to the execs running
+// in a sandbox and the processes they started, and returns how many execs
+// got it. An exec the signal kills ends with ExitReason "signaled".
+func (c *Client) Signal(ctx context.Context, id, signal string) (int, error) {
+	return c.signal(ctx, id, map[string]string{"signal": signal})
+}
+
+// SignalSession sends signal to the REPL of an interactive session.
+func (c *Client) SignalSession(ctx context.Context, id, sessionID, signal string) error {
+	_, err := c.signal(ctx, id, map[string]string{"signal": signal, "session": sessionID})
+	return err
+}
+
+func (c *Client) signal(ctx context.Context, id string, body map[string]string) (int, error) {
+	var resp struct {
+		Processes int `json:"processes"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/signal", body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Processes, nil
+}
+
+// CreateJob queues an exec in a sandbox and returns without waiting for it.
+func (c *Client) CreateJob(ctx context.Context, id string, req JobRequest) (*Job, error) {
+	var job Job
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/jobs", req, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns the status of a job, and its result once it has run.
+func (c *Client) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := c.doJSON(ctx, http.MethodGet, "/jobs/"+url.PathEscape(jobID), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitJob polls a job every interval until it has finished or ctx is done.
+func (c *Client) WaitJob(ctx context.Context, jobID string, interval time.Duration) (*Job, error) {
+	for {
+		job, err := c.GetJob(ctx, jobID)
+		if err != nil || job.Finished() {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CancelJob cancels a queued or running job. It fails with ErrConflict if
+// the job has finished.
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*Job, error) {
+	var job Job
+	if err := c.doJSON(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(jobID), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns the jobs of a sandbox, oldest first.
+func (c *Client) ListJobs(ctx context.Context, id string) ([]Job, error) {
+	var resp struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/jobs", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Jobs, nil
+}
+
+// CreateWorkspace creates a base workspace. It fails with ErrConflict if
+// the name is taken.
+func (c *Client) CreateWorkspace(ctx context.Context, req WorkspaceRequest) (*Workspace, error) {
+	var ws Workspace
+	if err := c.doJSON(ctx, http.MethodPost, "/workspaces", req, &ws); err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// ReplaceWorkspace replaces the files of a workspace, creating it if
+// needed. It fails with ErrConflict while sandboxes use it.
+func (c *Client) ReplaceWorkspace(ctx context.Context, req WorkspaceRequest) (*Workspace, error) {
+	var ws Workspace
+	if err := c.doJSON(ctx, http.MethodPut, "/workspaces/"+url.PathEscape(req.Name), req, &ws); err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// GetWorkspace describes a workspace. It fails with ErrNotFound if there is
+// none by that name.
+func (c *Client) GetWorkspace(ctx context.Context, name string) (*Workspace, error) {
+	var ws Workspace
+	if err := c.doJSON(ctx, http.MethodGet, "/workspaces/"+url.PathEscape(name), nil, &ws); err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// ListWorkspaces returns the base workspaces on the server.
+func (c *Client) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	var resp struct {
+		Workspaces []Workspace `json:"workspaces"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/workspaces", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Workspaces, nil
+}
+
+// DeleteWorkspace removes a workspace. It fails with ErrConflict while
+// sandboxes use it.
+func (c *Client) DeleteWorkspace(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/workspaces/"+url.PathEscape(name), nil, nil)
+}
+
+// CreateVolume creates an empty persistent volume. It fails with
+// ErrConflict if the name is taken.
+func (c *Client) CreateVolume(ctx context.Context, name string) (*Volume, error) {
+	var v Volume
+	if err := c.doJSON(ctx, http.MethodPost, "/volumes", map[string]string{"name": name}, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// GetVolume describes a volume. It fails with ErrNotFound if there is none
+// by that name.
+func (c *Client) GetVolume(ctx context.Context, name string) (*Volume, error) {
+	var v Volume
+	if err := c.doJSON(ctx, http.MethodGet, "/volumes/"+url.PathEscape(name), nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ListVolumes returns the persistent volumes on the server.
+func (c *Client) ListVolumes(ctx context.Context) ([]Volume, error) {
+	var resp struct {
+		Volumes []Volume `json:"volumes"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/volumes", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+// DeleteVolume removes a volume and its contents. It fails with
+// ErrConflict while sandboxes mount it.
+func (c *Client) DeleteVolume(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(name), nil, nil)
+}
+
+// CreateSecret registers a secret. It fails with ErrConflict if the name
+// is taken.
+func (c *Client) CreateSecret(ctx context.Context, name, value string) (*Secret, error) {
+	var s Secret
+	body := map[string]string{"name": name, "value": value}
+	if err := c.doJSON(ctx, http.MethodPost, "/secrets", body, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SetSecret registers a secret or replaces its value. Sandboxes keep the
+// value they were given.
+func (c *Client) SetSecret(ctx context.Context, name, value string) (*Secret, error) {
+	var s Secret
+	body := map[string]string{"value": value}
+	if err := c.doJSON(ctx, http.MethodPut, "/secrets/"+url.PathEscape(name), body, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSecrets returns the secrets registered with the server, without
+// their values.
+func (c *Client) ListSecrets(ctx context.Context) ([]Secret, error) {
+	var resp struct {
+		Secrets []Secret `json:"secrets"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/secrets", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Secrets, nil
+}
+
+// DeleteSecret removes a secret. It fails with ErrNotFound if there is
+// none by that name.
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/secrets/"+url.PathEscape(name), nil, nil)
+}
+
+// SetRegistryCredential adds or replaces the credential images are pulled
+// from cred.Registry with. It needs the admin scope.
+func (c *Client) SetRegistryCredential(ctx context.Context, cred RegistryCredential) (*RegistryCredential, error) {
+	var out RegistryCredential
+	body := map[string]string{"username": cred.Username, "password": cred.Password, "helper": cred.Helper}
+	if err := c.doJSON(ctx, http.MethodPut, "/registries/"+url.PathEscape(cred.Registry), body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListRegistryCredentials returns the registries the server has
+// credentials for, sorted by registry, without their passwords.
+func (c *Client) ListRegistryCredentials(ctx context.Context) ([]RegistryCredential, error) {
+	var resp struct {
+		Registries []RegistryCredential `json:"registries"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/registries", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Registries, nil
+}
+
+// DeleteRegistryCredential forgets the credential of a registry. It fails
+// with ErrNotFound if there is none.
+func (c *Client) DeleteRegistryCredential(ctx context.Context, registry string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/registries/"+url.PathEscape(registry), nil, nil)
+}
+
+// ClusterNodes returns the control-plane nodes sharing the server's state,
+// sorted by ID. A server running alone returns none.
+func (c *Client) ClusterNodes(ctx context.Context) ([]Node, error) {
+	var resp struct {
+		Nodes []Node `json:"nodes"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/cluster/nodes", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+// ListTemplates returns the server's templates, sorted by name, and the
+// patterns images used directly as templates must match, nil if any image
+// can be.
+func (c *Client) ListTemplates(ctx context.Context) ([]Template, []string, error) {
+	var resp struct {
+		Templates []Template `json:"templates"`
+		Images    []string   `json:"images"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/templates", nil, &resp); err != nil {
+		return nil, nil, err
+	}
+	return resp.Templates, resp.Images, nil
+}
+
+// PublishTemplate saves the filesystem of a running sandbox as a template
+// with its resources, which later creates can name, e.g. "my-env:v1".
+// Publishing a name again replaces it.
+func (c *Client) PublishTemplate(ctx context.Context, id string, req PublishTemplateRequest) (*Template, error) {
+	var t Template
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/publish-template", req, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// BuildImage builds an image from a Dockerfile on the server and adds it
+// to the catalog as a template, which later creates can name. log, if
+// non-nil, is called with each line of build output as it comes. A
+// failing step fails with ErrSetupFailed; building a name again replaces
+// it.
+func (c *Client) BuildImage(ctx context.Context, req BuildImageRequest, log func(line string)) (*Template, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			fields := [][2]string{{"name", req.Name}, {"tag", req.Tag}, {"init", req.Init}, {"dockerfile", req.Dockerfile}}
+			if req.InitTimeout != 0 {
+				fields = append(fields, [2]string{"init_timeout", strconv.Itoa(req.InitTimeout)})
+			}
+			if req.MemoryMB != 0 {
+				fields = append(fields, [2]string{"memory_mb", strconv.FormatInt(req.MemoryMB, 10)})
+			}
+			if req.CPUCores != 0 {
+				fields = append(fields, [2]string{"cpu_cores", strconv.FormatFloat(req.CPUCores, 'f', -1, 64)})
+			}
+			for k, v := range req.BuildArgs {
+				fields = append(fields, [2]string{"build_arg", k + "=" + v})
+			}
+			for _, f := range fields {
+				if f[1] == "" {
+					continue
+				}
+				if err := w.WriteField(f[0], f[1]); err != nil {
+					return err
+				}
+			}
+			if req.Context != nil {
+				part, err := w.CreateFormFile("context", "context.tar")
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(part, req.Context); err != nil {
+					return fmt.Errorf("failed to read context: %w", err)
+				}
+			}
+			return w.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	hreq, err := c.newRequest(ctx, http.MethodPost, "/images/build", pr)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", w.FormDataContentType())
+	hreq.Header.Set("Accept", "application/x-ndjson")
+	resp, err := c.do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Type     string    `json:"type"`
+			Line     string    `json:"line"`
+			Template *Template `json:"template"`
+			Error    *APIError `json:"error"`
+		}
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil, fmt.Errorf("boxed: build stream ended without a template")
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("boxed: decode build event: %w", err)
+		}
+		switch ev.Type {
+		case "log":
+			if log != nil {
+				log(ev.Line)
+			}
+		case "done":
+			return ev.Template, nil
+		case "error":
+			if ev.Error == nil {
+				ev.Error = &APIError{Message: "build failed"}
+			}
+			ev.Error.StatusCode = resp.StatusCode
+			return nil, ev.Error
+		}
+	}
+}
+
+// Usage reports the CPU time, memory and lifetime sandboxes consumed within
+// a period, by group. Usage of sandboxes running across the period's bounds
+// is prorated.
+func (c *Client) Usage(ctx context.Context, q UsageQuery) (*UsageReport, error) {
+	var report UsageReport
+	if err := c.doJSON(ctx, http.MethodGet, "/usage?"+q.values().Encode(), nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// UsageCSV streams the report Usage returns as a CSV file, with a header
+// row and one row per group. The caller must close it.
+func (c *Client) UsageCSV(ctx context.Context, q UsageQuery) (io.ReadCloser, error) {
+	v := q.values()
+	v.Set("format", "csv")
+	req, err := c.newRequest(ctx, http.MethodGet, "/usage?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ListExecs returns the exec history of a sandbox, oldest first.
+func (c *Client) ListExecs(ctx context.Context, id string) ([]ExecRecord, error) {
+	var resp struct {
+		Execs []ExecRecord `json:"execs"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/execs", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Execs, nil
+}
+
+// Timeline returns the lifecycle events of a sandbox.
+func (c *Client) Timeline(ctx context.Context, id string) ([]TimelineEvent, error) {
+	var resp struct {
+		Events []TimelineEvent `json:"events"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/timeline", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Logs calls fn with the recent log lines of source, oldest first. With
+// follow set it keeps calling fn with new lines until ctx is done or the
+// sandbox stops. An error from fn stops reading and is returned.
+func (c *Client) Logs(ctx context.Context, id, source string, follow bool, fn func(LogLine) error) error {
+	return c.logs(ctx, id, url.Values{"source": {source}, "follow": {strconv.FormatBool(follow)}}, fn)
+}
+
+// ExecLogs is Logs of the agent source, keeping the lines of one agent
+// process: those whose Exec is exec.
+func (c *Client) ExecLogs(ctx context.Context, id, exec string, follow bool, fn func(LogLine) error) error {
+	q := url.Values{"source": {LogSourceAgent}, "exec": {exec}, "follow": {strconv.FormatBool(follow)}}
+	return c.logs(ctx, id, q, fn)
+}
+
+func (c *Client) logs(ctx context.Context, id string, q url.Values, fn func(LogLine) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/logs?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var line LogLine
+		if err := dec.Decode(&line); err == io.EOF {
+			return nil
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("boxed: decode log line: %w", err)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats returns the current resource usage of a sandbox.
+func (c *Client) Stats(ctx context.Context, id string) (*ResourceStats, error) {
+	var stats ResourceStats
+	if err := c.doJSON(ctx, http.MethodGet, "/sandbox/"+url.PathEscape(id)+"/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// StreamStats calls fn with a resource sample of a sandbox every interval
+// (one second if zero) until ctx is done or the sandbox stops. An error
+// from fn stops reading and is returned.
+func (c *Client) StreamStats(ctx context.Context, id string, interval time.Duration, fn func(ResourceStats) error) error {
+	p := "/sandbox/" + url.PathEscape(id) + "/stats/stream"
+	if interval > 0 {
+		p += "?" + url.Values{"interval": {strconv.FormatFloat(interval.Seconds(), 'f', -1, 64)}}.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if e, ok := strings.CutPrefix(line, "event: "); ok {
+			event = e
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "stats":
+			var stats ResourceStats
+			if err := json.Unmarshal([]byte(data), &stats); err != nil {
+				return fmt.Errorf("boxed: decode stats: %w", err)
+			}
+			if err := fn(stats); err != nil {
+				return err
+			}
+		case "error":
+			apiErr := &APIError{}
+			json.Unmarshal([]byte(data), apiErr)
+			return apiErr
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// SubscribeEvents calls fn with the lifecycle events of the server's
+// sandboxes, narrowed by LabelFilter and EventTypeFilter, until ctx is done
+// or the server ends the stream. An error from fn stops reading and is
+// returned.
+func (c *Client) SubscribeEvents(ctx context.Context, fn func(LifecycleEvent) error, opts ...ListOption) error {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	p := "/events"
+	if len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if e, ok := strings.CutPrefix(line, "event: "); ok {
+			event = e
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if event == "error" {
+			apiErr := &APIError{}
+			json.Unmarshal([]byte(data), apiErr)
+			return apiErr
+		}
+		var ev LifecycleEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("boxed: decode event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// ListFiles lists the directory at dir inside the sandbox.
+func (c *Client) ListFiles(ctx context.Context, id, dir string) ([]FileEntry, error) {
+	var resp struct {
+		Files []FileEntry `json:"files"`
+	}
+	p := "/sandbox/" + url.PathEscape(id) + "/files?path=" + url.QueryEscape(dir)
+	if err := c.doJSON(ctx, http.MethodGet, p, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Files, nil
+}
+
+// contentSHA256Header carries the hex SHA-256 digest of a downloaded file.
+const contentSHA256Header = "X-Boxed-Content-SHA256"
+
+// UploadFile writes content to remotePath inside the sandbox. The server
+// checks what it received against the SHA-256 digest of content and writes
+// nothing if they differ.
+func (c *Client) UploadFile(ctx context.Context, id, remotePath string, content io.Reader) error {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("path", path.Dir(remotePath)); err != nil {
+		return err
+	}
+	part, err := w.CreateFormFile("file", path.Base(remotePath))
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(part, sum), content); err != nil {
+		return err
+	}
+	if err := w.WriteField("sha256", hex.EncodeToString(sum.Sum(nil))); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/files", &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UploadEntry is a file written by UploadFiles.
+type UploadEntry struct {
+	// Path is where the file goes in the sandbox; relative paths are
+	// taken from /uploads
+	Path    string
+	Content io.Reader
+
+	// SHA256 is the hex digest Content must have, e.g. one published with
+	// the file; empty means the digest of what was read from Content
+	SHA256 string
+}
+
+// UploadFiles writes files into the sandbox in one streamed request, which
+// the server writes in one transfer where its driver can. It returns the
+// paths written. The server checks each file against its digest and writes
+// none of them if one differs.
+func (c *Client) UploadFiles(ctx context.Context, id string, files []UploadEntry) ([]string, error) {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	pathsJSON, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	w := multipart.NewWriter(pw)
+	go func() {
+		err := func() error {
+			if err := w.WriteField("paths", string(pathsJSON)); err != nil {
+				return err
+			}
+			sums := make([]string, len(files))
+			for i, f := range files {
+				part, err := w.CreateFormFile("file", path.Base(f.Path))
+				if err != nil {
+					return err
+				}
+				sum := sha256.New()
+				if _, err := io.Copy(io.MultiWriter(part, sum), f.Content); err != nil {
+					return fmt.Errorf("failed to read %s: %w", f.Path, err)
+				}
+				sums[i] = f.SHA256
+				if sums[i] == "" {
+					sums[i] = hex.EncodeToString(sum.Sum(nil))
+				}
+			}
+			// The server reads the whole form before it looks at the
+			// digests, so they can follow the files they were taken of
+			for _, sum := range sums {
+				if err := w.WriteField("sha256", sum); err != nil {
+					return err
+				}
+			}
+			return w.Close()
+		}()
+		pw.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/files", pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Paths, nil
+}
+
+// DownloadFile streams the file at remotePath. The caller must close it.
+// Once it is read to the end, the reader checks what it read against the
+// SHA-256 digest the server sent, and fails with ErrChecksumMismatch
+// instead of io.EOF if they differ.
+func (c *Client) DownloadFile(ctx context.Context, id, remotePath string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet,
+		"/sandbox/"+url.PathEscape(id)+"/files/content?checksum=true&path="+url.QueryEscape(remotePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &checkedBody{resp: resp, path: remotePath, sum: sha256.New()}, nil
+}
+
+// checkedBody reads a download, comparing it at the end with the digest in
+// the response's header or trailer. Servers that send neither are trusted.
+type checkedBody struct {
+	resp *http.Response
+	path string
+	sum  hash.Hash
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	b.sum.Write(p[:n])
+	if err == io.EOF {
+		want := b.resp.Header.Get(contentSHA256Header)
+		if want == "" {
+			// Trailers are only set once the body is read
+			want = b.resp.Trailer.Get(contentSHA256Header)
+		}
+		if got := hex.EncodeToString(b.sum.Sum(nil)); want != "" && !strings.EqualFold(want, got) {
+			return n, fmt.Errorf("%w: sha256 of %s is %s, the server sent %s", ErrChecksumMismatch, b.path, got, want)
+		}
+	}
+	return n, err
+}
+
+func (b *checkedBody) Close() error {
+	return b.resp.Body.Close()
+}
+
+// DownloadFileRange streams length bytes of the file at remotePath starting
+// at offset, or everything from offset on if length is negative. It is how
+// an interrupted download is resumed. The caller must close the reader.
+func (c *Client) DownloadFileRange(ctx context.Context, id, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet,
+		"/sandbox/"+url.PathEscape(id)+"/files/content?path="+url.QueryEscape(remotePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server sent the whole file
+		resp.Body.Close()
+		return nil, fmt.Errorf("boxed: server does not support ranges for %s", remotePath)
+	}
+	return resp.Body, nil
+}
+
+// Upload is a chunked upload in progress; see UploadLargeFile.
+type Upload struct {
+	ID        string `json:"id"`
+	SandboxID string `json:"sandbox_id"`
+	Path      string `json:"path"`
+	// Size is the declared total size, -1 if not given
+	Size int64 `json:"size"`
+	// Offset is the number of bytes the server has received
+	Offset int64 `json:"offset"`
+}
+
+// uploadChunkSize is the chunk size UploadLargeFile uses by default.
+const uploadChunkSize = 8 << 20
+
+// uploadRetries is how often UploadLargeFile resends a failed chunk.
+const uploadRetries = 3
+
+// CreateUpload starts a chunked upload to remotePath. Pass size -1 if the
+// total size is not known up front.
+func (c *Client) CreateUpload(ctx context.Context, id, remotePath string, size int64) (*Upload, error) {
+	req := struct {
+		Path string `json:"path"`
+		Size *int64 `json:"size,omitempty"`
+	}{Path: remotePath}
+	if size >= 0 {
+		req.Size = &size
+	}
+	var u Upload
+	if err := c.doJSON(ctx, http.MethodPost, "/sandbox/"+url.PathEscape(id)+"/uploads", req, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUpload reports how far an upload got, so it can be resumed.
+func (c *Client) GetUpload(ctx context.Context, id, uploadID string) (*Upload, error) {
+	var u Upload
+	if err := c.doJSON(ctx, http.MethodGet, c.uploadPath(id, uploadID), nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// AppendUpload sends the chunk that starts at offset. It fails with
+// ErrConflict if offset is not where the upload is.
+func (c *Client) AppendUpload(ctx context.Context, id, uploadID string, offset int64, chunk io.Reader) (*Upload, error) {
+	req, err := c.newRequest(ctx, http.MethodPatch, c.uploadPath(id, uploadID), chunk)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var u Upload
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return nil, fmt.Errorf("boxed: decode response: %w", err)
+	}
+	return &u, nil
+}
+
+// CommitUpload writes the uploaded file into the sandbox. If sha256Hex is
+// set the server checks the content against it first.
+func (c *Client) CommitUpload(ctx context.Context, id, uploadID, sha256Hex string) error {
+	req := struct {
+		SHA256 string `json:"sha256,omitempty"`
+	}{sha256Hex}
+	return c.doJSON(ctx, http.MethodPost, c.uploadPath(id, uploadID)+"/commit", req, nil)
+}
+
+// AbortUpload drops an upload and what was sent of it.
+func (c *Client) AbortUpload(ctx context.Context, id, uploadID string) error {
+	return c.doJSON(ctx, http.MethodDelete, c.uploadPath(id, uploadID), nil, nil)
+}
+
+// UploadLargeFile writes content to remotePath in chunks of chunkSize bytes
+// (8 MiB if 0), so neither side holds the whole file in memory. A chunk
+// that fails in transit is resumed from the offset the server reports.
+func (c *Client) UploadLargeFile(ctx context.Context, id, remotePath string, content io.Reader, chunkSize int) error {
+	if chunkSize <= 0 {
+		chunkSize = uploadChunkSize
+	}
+	u, err := c.CreateUpload(ctx, id, remotePath, -1)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.New()
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(content, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			if offset, err = c.sendChunk(ctx, u, offset, buf[:n]); err != nil {
+				c.AbortUpload(context.WithoutCancel(ctx), id, u.ID)
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		} else if readErr != nil {
+			c.AbortUpload(context.WithoutCancel(ctx), id, u.ID)
+			return readErr
+		}
+	}
+	return c.CommitUpload(ctx, id, u.ID, hex.EncodeToString(sum.Sum(nil)))
+}
+
+// sendChunk sends chunk, which starts at offset, and returns the offset
+// after it. Failed attempts resume from wherever the server got to.
+func (c *Client) sendChunk(ctx context.Context, u *Upload, offset int64, chunk []byte) (int64, error) {
+	end := offset + int64(len(chunk))
+	var err error
+	for attempt := 0; attempt <= uploadRetries; attempt++ {
+		if attempt > 0 {
+			var cur *Upload
+			if cur, err = c.GetUpload(ctx, u.SandboxID, u.ID); err != nil {
+				return 0, err
+			}
+			if cur.Offset < offset || cur.Offset > end {
+				return 0, fmt.Errorf("boxed: upload is at offset %d, outside the chunk at %d", cur.Offset, offset)
+			}
+			chunk, offset = chunk[cur.Offset-offset:], cur.Offset
+			if offset == end {
+				return end, nil
+			}
+		}
+		var res *Upload
+		if res, err = c.AppendUpload(ctx, u.SandboxID, u.ID, offset, bytes.NewReader(chunk)); err == nil {
+			return res.Offset, nil
+		}
+		if ctx.Err() != nil {
+			return 0, err
+		}
+	}
+	return 0, err
+}
+
+func (c *Client) uploadPath(id, uploadID string) string {
+	return "/sandbox/" + url.PathEscape(id) + "/uploads/" + url.PathEscape(uploadID)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+p, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-Boxed-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends req and converts non-2xx responses into *APIError.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		// Older servers answer with {"message": ...} or plain text
+		var legacy struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &legacy) == nil && legacy.Message != "" {
+			apiErr.Message = legacy.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = codeForStatus(resp.StatusCode)
+	}
+	return nil, apiErr
+}
+
+// codeForStatus infers an error code for servers that don't send one.
+// Every sandbox route answers 404 only for unknown sandboxes.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "sandbox_not_found"
+	case http.StatusTooManyRequests:
+		return "quota_exceeded"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "timed_out"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "unauthorized"
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusNotImplemented:
+		return "not_implemented"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return ""
+}
+
+func (c *Client) doJSON(ctx context.Context, method, p string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("boxed: encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, p, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("boxed: decode response: %w", err)
+	}
+	return nil
+}
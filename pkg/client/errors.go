@@ -0,0 +1,119 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned (wrapped in *APIError) by Client methods.
+// Use errors.Is to test for them.
+var (
+	// ErrSandboxNotFound indicates the sandbox does not exist or was already stopped.
+	ErrSandboxNotFound = errors.New("boxed: sandbox not found")
+
+	// ErrSandboxNotRunning indicates the sandbox exists but is not running.
+	ErrSandboxNotRunning = errors.New("boxed: sandbox not running")
+
+	// ErrNotFound indicates a resource other than a sandbox, such as a
+	// workspace, does not exist.
+	ErrNotFound = errors.New("boxed: not found")
+
+	// ErrConflict indicates the request conflicts with the resource's
+	// current state, e.g. deleting a workspace that sandboxes still use.
+	ErrConflict = errors.New("boxed: conflict")
+
+	// ErrSandboxBusy indicates an exec asked not to wait for the other
+	// execs of its sandbox, or waited too long.
+	ErrSandboxBusy = errors.New("boxed: sandbox busy")
+
+	// ErrQuotaExceeded indicates the server refused the request because a
+	// resource limit was reached.
+	ErrQuotaExceeded = errors.New("boxed: quota exceeded")
+
+	// ErrTimedOut indicates the operation exceeded its deadline on the server.
+	ErrTimedOut = errors.New("boxed: timed out")
+
+	// ErrCanceled indicates the operation was canceled before it finished,
+	// e.g. a job whose exec was cut short.
+	ErrCanceled = errors.New("boxed: canceled")
+
+	// ErrUnauthorized indicates a missing or invalid API key.
+	ErrUnauthorized = errors.New("boxed: unauthorized")
+
+	// ErrForbidden indicates the caller is known but the request is not
+	// allowed, e.g. an admission webhook denied it.
+	ErrForbidden = errors.New("boxed: forbidden")
+
+	// ErrInvalidRequest indicates the server rejected the request parameters.
+	ErrInvalidRequest = errors.New("boxed: invalid request")
+
+	// ErrNotImplemented indicates the server's driver does not support the operation.
+	ErrNotImplemented = errors.New("boxed: not implemented")
+
+	// ErrUnavailable indicates the server is shutting down and refuses new
+	// work. Retry against another instance or after it restarts.
+	ErrUnavailable = errors.New("boxed: unavailable")
+
+	// ErrSetupFailed indicates installing a sandbox's packages, or a step
+	// of an image build, failed; the message carries the end of the
+	// output.
+	ErrSetupFailed = errors.New("boxed: setup failed")
+)
+
+// ErrChecksumMismatch is returned, wrapped, by the reader of DownloadFile
+// when what arrived does not match the SHA-256 digest the server sent.
+var ErrChecksumMismatch = errors.New("boxed: checksum mismatch")
+
+// codeErrors maps the server's error codes to sentinels.
+var codeErrors = map[string]error{
+	"sandbox_not_found":   ErrSandboxNotFound,
+	"sandbox_not_running": ErrSandboxNotRunning,
+	"not_found":           ErrNotFound,
+	"conflict":            ErrConflict,
+	"sandbox_busy":        ErrSandboxBusy,
+	"quota_exceeded":      ErrQuotaExceeded,
+	"timed_out":           ErrTimedOut,
+	"canceled":            ErrCanceled,
+	"unauthorized":        ErrUnauthorized,
+	"forbidden":           ErrForbidden,
+	"invalid_request":     ErrInvalidRequest,
+	"not_implemented":     ErrNotImplemented,
+	"unavailable":         ErrUnavailable,
+	"setup_failed":        ErrSetupFailed,
+}
+
+// APIError is returned for every non-2xx response.
+type APIError struct {
+	// StatusCode is the HTTP status of the response; 0 for gRPC calls
+	StatusCode int
+
+	// Code is the machine readable error code (e.g. "sandbox_not_found").
+	// It is empty if the server did not send one.
+	Code string `json:"code"`
+
+	// Message is the human readable error message
+	Message string `json:"error"`
+
+	// SandboxID is the sandbox a failed create left behind, whose
+	// record and timeline can still be read
+	SandboxID string `json:"sandbox_id,omitempty"`
+
+	// QueuePosition is set when the server, or the exec's sandbox, had no
+	// slot for an exec: where it was among the execs waiting. RetryAfter
+	// is about how many seconds until a slot is free.
+	QueuePosition int `json:"queue_position,omitempty"`
+	RetryAfter    int `json:"retry_after,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("boxed: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("boxed: %s: %s", e.Code, e.Message)
+}
+
+// Unwrap exposes the sentinel matching the error code, so that
+// errors.Is(err, client.ErrSandboxNotFound) works.
+func (e *APIError) Unwrap() error {
+	return codeErrors[e.Code]
+}
@@ -0,0 +1,365 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService is the gRPC service of a Boxed server, whose messages are
+// the JSON bodies of the REST API.
+const grpcService = "/boxed.v1.Boxed/"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+// GRPCClient talks to the gRPC API of a Boxed server (`boxed serve
+// --grpc`), which multiplexes calls on one HTTP/2 connection and streams
+// exec output and REPL sessions. It is safe for concurrent use.
+type GRPCClient struct {
+	conn   *grpc.ClientConn
+	apiKey string
+	token  string
+}
+
+// DialGRPC creates a client for the gRPC API at target (e.g.
+// "localhost:8080"). The options set its API key or bearer token; dialOpts
+// replace the default plaintext transport, e.g. with TLS credentials.
+func DialGRPC(target string, opts []Option, dialOpts ...grpc.DialOption) (*GRPCClient, error) {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	dialOpts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
+	}, dialOpts...)
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("boxed: dial %s: %w", target, err)
+	}
+	return &GRPCClient{conn: conn, apiKey: c.apiKey, token: c.token}, nil
+}
+
+// Close closes the connection.
+func (g *GRPCClient) Close() error {
+	return g.conn.Close()
+}
+
+// CreateSandbox creates a sandbox and waits until it is running.
+func (g *GRPCClient) CreateSandbox(ctx context.Context, req CreateSandboxRequest) (*Sandbox, error) {
+	var resp createSandboxResponse
+	if err := g.invoke(ctx, "CreateSandbox", createSandboxBody(req), &resp); err != nil {
+		return nil, err
+	}
+	return resp.sandbox(), nil
+}
+
+// GetSandbox returns runtime information about a sandbox.
+func (g *GRPCClient) GetSandbox(ctx context.Context, id string) (*Sandbox, error) {
+	var sb Sandbox
+	if err := g.invoke(ctx, "GetSandbox", grpcSandbox{id}, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// ListSandboxes lists the sandboxes of the server, narrowed by
+// LabelFilter and StateFilter; unlike Client.ListSandboxes it does not
+// list those of the other nodes of a cluster.
+func (g *GRPCClient) ListSandboxes(ctx context.Context, opts ...ListOption) ([]Sandbox, error) {
+	q := url.Values{}
+	for _, opt := range opts {
+		opt(q)
+	}
+	req := struct {
+		States []string `json:"states,omitempty"`
+		Labels []string `json:"labels,omitempty"`
+	}{q["state"], q["label"]}
+	var resp struct {
+		Sandboxes []Sandbox `json:"sandboxes"`
+	}
+	if err := g.invoke(ctx, "ListSandboxes", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sandboxes, nil
+}
+
+// StopSandbox stops and removes a sandbox.
+func (g *GRPCClient) StopSandbox(ctx context.Context, id string) error {
+	return g.invoke(ctx, "StopSandbox", grpcSandbox{id}, &struct{}{})
+}
+
+// Exec runs code in a sandbox and waits for it to finish.
+func (g *GRPCClient) Exec(ctx context.Context, id string, req ExecRequest) (*ExecResult, error) {
+	var res ExecResult
+	if err := g.invoke(ctx, "Exec", grpcExec{grpcSandbox{id}, req}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ExecStream runs code in a sandbox, calling fn with its events as
+// Client.ExecStream does, and returns the exit event.
+func (g *GRPCClient) ExecStream(ctx context.Context, id string, req ExecRequest, fn func(ExecEvent) error) (*ExecEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := g.stream(ctx, "ExecStream", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(grpcExec{grpcSandbox{id}, req}); err != nil && err != io.EOF {
+		return nil, grpcError(stream, err)
+	}
+	stream.CloseSend()
+	for {
+		var ev ExecEvent
+		if err := stream.RecvMsg(&ev); err == io.EOF {
+			return nil, fmt.Errorf("boxed: exec stream ended without an exit")
+		} else if err != nil {
+			return nil, grpcError(stream, err)
+		}
+		switch ev.Type {
+		case ExecEventExit:
+			return &ev, nil
+		case "error":
+			if ev.Error == nil {
+				ev.Error = &APIError{Message: "exec failed"}
+			}
+			return nil, ev.Error
+		}
+		if err := fn(ev); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Signal sends signal, such as "SIGINT", to the processes of a sandbox's
+// execs and returns how many it reached.
+func (g *GRPCClient) Signal(ctx context.Context, id, signal string) (int, error) {
+	req := struct {
+		grpcSandbox
+		Signal string `json:"signal"`
+	}{grpcSandbox{id}, signal}
+	var resp struct {
+		Processes int `json:"processes"`
+	}
+	if err := g.invoke(ctx, "Signal", req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Processes, nil
+}
+
+// SetTTL sets the remaining lifetime of a sandbox and returns its new expiry.
+func (g *GRPCClient) SetTTL(ctx context.Context, id string, ttl time.Duration) (time.Time, error) {
+	req := struct {
+		grpcSandbox
+		TTL int `json:"ttl"`
+	}{grpcSandbox{id}, int(ttl / time.Second)}
+	var resp struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := g.invoke(ctx, "SetTTL", req, &resp); err != nil {
+		return time.Time{}, err
+	}
+	return resp.ExpiresAt, nil
+}
+
+// UpdateResources raises or lowers the limits of a running sandbox; zero
+// fields keep theirs. It returns the limits the sandbox has now.
+func (g *GRPCClient) UpdateResources(ctx context.Context, id string, r Resources) (*Resources, error) {
+	req := struct {
+		grpcSandbox
+		Resources
+	}{grpcSandbox{id}, r}
+	var resp Resources
+	if err := g.invoke(ctx, "UpdateResources", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Hibernate stops the processes of a sandbox but keeps its files, until
+// Wake starts it again with the same ID. Its TTL does not run meanwhile.
+func (g *GRPCClient) Hibernate(ctx context.Context, id string) (*Sandbox, error) {
+	var sb Sandbox
+	if err := g.invoke(ctx, "Hibernate", grpcSandbox{id}, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// Wake starts a hibernated sandbox again, giving it ttl to live, or what
+// was left of its TTL when it hibernated if ttl is zero.
+func (g *GRPCClient) Wake(ctx context.Context, id string, ttl time.Duration) (*Sandbox, error) {
+	req := struct {
+		grpcSandbox
+		TTL int `json:"ttl"`
+	}{grpcSandbox{id}, int(ttl / time.Second)}
+	var sb Sandbox
+	if err := g.invoke(ctx, "Wake", req, &sb); err != nil {
+		return nil, err
+	}
+	return &sb, nil
+}
+
+// ListTemplates returns the server's templates and allowed images.
+func (g *GRPCClient) ListTemplates(ctx context.Context) ([]Template, []string, error) {
+	var resp struct {
+		Templates []Template `json:"templates"`
+		Images    []string   `json:"images"`
+	}
+	if err := g.invoke(ctx, "ListTemplates", struct{}{}, &resp); err != nil {
+		return nil, nil, err
+	}
+	return resp.Templates, resp.Images, nil
+}
+
+// PublishTemplate saves the filesystem of a running sandbox as a template,
+// like Client.PublishTemplate.
+func (g *GRPCClient) PublishTemplate(ctx context.Context, id string, req PublishTemplateRequest) (*Template, error) {
+	body := struct {
+		grpcSandbox
+		PublishTemplateRequest
+	}{grpcSandbox{id}, req}
+	var t Template
+	if err := g.invoke(ctx, "PublishTemplate", body, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Interact starts a REPL for language ("python", or bash if empty) in a
+// sandbox. The REPL runs until it exits or the interaction is closed.
+func (g *GRPCClient) Interact(ctx context.Context, id, language string) (*GRPCInteraction, error) {
+	stream, err := g.stream(ctx, "Interact", true)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(interactMessage{SandboxID: id, Language: language}); err != nil && err != io.EOF {
+		return nil, grpcError(stream, err)
+	}
+	return &GRPCInteraction{stream: stream}, nil
+}
+
+// GRPCInteraction is a REPL started by GRPCClient.Interact. Send and
+// Signal may be called while another goroutine calls Recv.
+type GRPCInteraction struct {
+	stream grpc.ClientStream
+}
+
+type interactMessage struct {
+	SandboxID string `json:"sandbox_id,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+}
+
+// Send types data into the REPL; end lines with "\n".
+func (i *GRPCInteraction) Send(data string) error {
+	return grpcError(i.stream, i.stream.SendMsg(interactMessage{Data: data}))
+}
+
+// Signal sends signal, such as "SIGINT", to the REPL.
+func (i *GRPCInteraction) Signal(signal string) error {
+	return grpcError(i.stream, i.stream.SendMsg(interactMessage{Signal: signal}))
+}
+
+// Recv returns the next message of the sandbox, a JSON-RPC notification as
+// sent over the interact WebSocket (e.g. {"method":"stdout",...}). It
+// returns io.EOF after the REPL exited.
+func (i *GRPCInteraction) Recv() (json.RawMessage, error) {
+	var msg json.RawMessage
+	if err := i.stream.RecvMsg(&msg); err != nil {
+		return nil, grpcError(i.stream, err)
+	}
+	return msg, nil
+}
+
+// Close ends the REPL.
+func (i *GRPCInteraction) Close() error {
+	return i.stream.CloseSend()
+}
+
+type grpcSandbox struct {
+	SandboxID string `json:"sandbox_id"`
+}
+
+type grpcExec struct {
+	grpcSandbox
+	ExecRequest
+}
+
+func (g *GRPCClient) outgoing(ctx context.Context) context.Context {
+	if g.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+g.token)
+	}
+	if g.apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-boxed-api-key", g.apiKey)
+	}
+	return ctx
+}
+
+func (g *GRPCClient) invoke(ctx context.Context, method string, in, out any) error {
+	var trailer metadata.MD
+	err := g.conn.Invoke(g.outgoing(ctx), grpcService+method, in, out, grpc.Trailer(&trailer))
+	return statusError(err, trailer)
+}
+
+func (g *GRPCClient) stream(ctx context.Context, method string, clientStreams bool) (grpc.ClientStream, error) {
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true, ClientStreams: clientStreams}
+	stream, err := g.conn.NewStream(g.outgoing(ctx), desc, grpcService+method)
+	if err != nil {
+		return nil, statusError(err, nil)
+	}
+	return stream, nil
+}
+
+// grpcError converts an error of a stream. A send returns io.EOF when the
+// call has ended, whose status the next receive returns.
+func grpcError(stream grpc.ClientStream, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return statusError(err, stream.Trailer())
+}
+
+// statusError converts the status of a call the server failed to an
+// *APIError, with the error code, sandbox and queue position the server
+// put in the trailer. Other errors, such as those of the connection, are returned
+// as they are.
+func statusError(err error, trailer metadata.MD) error {
+	code := trailer.Get("x-boxed-error-code")
+	st, ok := status.FromError(err)
+	if err == nil || !ok || len(code) == 0 {
+		return err
+	}
+	apiErr := &APIError{Code: code[0], Message: st.Message()}
+	if v := trailer.Get("x-boxed-sandbox-id"); len(v) > 0 {
+		apiErr.SandboxID = v[0]
+	}
+	if v := trailer.Get("x-boxed-queue-position"); len(v) > 0 {
+		apiErr.QueuePosition, _ = strconv.Atoi(v[0])
+	}
+	if v := trailer.Get("x-boxed-retry-after"); len(v) > 0 {
+		apiErr.RetryAfter, _ = strconv.Atoi(v[0])
+	}
+	return apiErr
+}
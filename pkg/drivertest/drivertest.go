@@ -0,0 +1,487 @@
+// Package drivertest checks that a sandbox backend behaves as the Driver
+// interface documents, so that drivers, built in or plugins, can replace
+// one another behind the API. A driver's tests run the suite against a
+// fresh driver:
+//
+//	func TestConformance(t *testing.T) {
+//		d, err := lxd.New(map[string]any{"remote": "local"})
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		defer d.Close()
+//		drivertest.Run(t, d, drivertest.Options{Image: "python:3.10-slim"})
+//	}
+//
+// The suite covers the lifecycle of sandboxes, the errors of operations on
+// sandboxes that do not exist or are not running, concurrent sandboxes, the
+// filesystem API and agent connections. Sandboxes must run the Boxed agent
+// and have a bash it can exec. The suite stops the sandboxes it creates,
+// and only checks those, so the driver may manage others.
+package drivertest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+)
+
+// Options configures Run.
+type Options struct {
+	// Image is the image of the sandboxes, "python:3.10-slim" by default
+	Image string
+
+	// Sandboxes is how many sandboxes the concurrency test runs at once,
+	// 4 by default
+	Sandboxes int
+
+	// Timeout bounds each test, 2 minutes by default
+	Timeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Image == "" {
+		o.Image = "python:3.10-slim"
+	}
+	if o.Sandboxes <= 0 {
+		o.Sandboxes = 4
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Minute
+	}
+	return o
+}
+
+// Run runs the conformance suite against d as subtests of t.
+func Run(t *testing.T, d driver.Driver, opts Options) {
+	opts = opts.withDefaults()
+	s := &suite{d: d, opts: opts}
+	for _, test := range []struct {
+		name string
+		run  func(*testing.T, context.Context)
+	}{
+		{"Lifecycle", s.testLifecycle},
+		{"Errors", s.testErrors},
+		{"Concurrency", s.testConcurrency},
+		{"Filesystem", s.testFilesystem},
+		{"Connect", s.testConnect},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+			defer cancel()
+			test.run(t, ctx)
+		})
+	}
+}
+
+type suite struct {
+	d    driver.Driver
+	opts Options
+}
+
+// create creates a sandbox, stopped when t ends, and starts it unless
+// start is false.
+func (s *suite) create(t *testing.T, ctx context.Context, start bool) string {
+	t.Helper()
+	id, err := s.d.Create(ctx, driver.SandboxConfig{
+		Image:  s.opts.Image,
+		Labels: map[string]string{"boxed.drivertest": t.Name()},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if id == "" {
+		t.Fatal("Create returned an empty ID")
+	}
+	t.Cleanup(func() { s.d.Stop(context.Background(), id) })
+	if start {
+		if err := s.d.Start(ctx, id); err != nil {
+			t.Fatalf("Start %s: %v", id, err)
+		}
+	}
+	return id
+}
+
+// listed reports whether List returns the sandbox id.
+func (s *suite) listed(t *testing.T, ctx context.Context, id string, states ...driver.SandboxState) bool {
+	t.Helper()
+	list, err := s.d.List(ctx, states)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	return slices.ContainsFunc(list, func(info *driver.SandboxInfo) bool { return info.ID == id })
+}
+
+func (s *suite) testLifecycle(t *testing.T, ctx context.Context) {
+	id := s.create(t, ctx, false)
+	info, err := s.d.Info(ctx, id)
+	if err != nil {
+		t.Fatalf("Info of a created sandbox: %v", err)
+	}
+	if info.ID != id {
+		t.Errorf("Info returned ID %q, want %q", info.ID, id)
+	}
+	if info.State == driver.StateReady {
+		t.Errorf("a sandbox is %s before Start", info.State)
+	}
+	if !s.listed(t, ctx, id) {
+		t.Errorf("List does not return the created sandbox %s", id)
+	}
+
+	if err := s.d.Start(ctx, id); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	info, err = s.d.Info(ctx, id)
+	if err != nil {
+		t.Fatalf("Info of a started sandbox: %v", err)
+	}
+	if info.State != driver.StateReady {
+		t.Errorf("a started sandbox is %s, want %s", info.State, driver.StateReady)
+	}
+	if !s.listed(t, ctx, id, driver.StateReady) {
+		t.Errorf("List(ready) does not return the started sandbox %s", id)
+	}
+	if s.listed(t, ctx, id, driver.StateStopped) {
+		t.Errorf("List(stopped) returns the running sandbox %s", id)
+	}
+	// Starting a running sandbox may fail, but must not disturb it
+	if err := s.d.Start(ctx, id); err != nil && !errors.Is(err, driver.ErrSandboxAlreadyRunning) {
+		t.Errorf("Start of a running sandbox: got %v, want nil or ErrSandboxAlreadyRunning", err)
+	}
+	s.ping(t, ctx, id)
+
+	if err := s.d.Stop(ctx, id); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := s.d.Stop(ctx, id); err != nil && !errors.Is(err, driver.ErrSandboxNotFound) {
+		t.Errorf("Stop of a stopped sandbox: got %v, want nil or ErrSandboxNotFound", err)
+	}
+	if info, err := s.d.Info(ctx, id); err == nil && info.State != driver.StateStopped {
+		t.Errorf("a stopped sandbox is %s", info.State)
+	} else if err != nil && !errors.Is(err, driver.ErrSandboxNotFound) {
+		t.Errorf("Info of a stopped sandbox: got %v, want ErrSandboxNotFound", err)
+	}
+	if s.listed(t, ctx, id, driver.StateReady) {
+		t.Errorf("List(ready) returns the stopped sandbox %s", id)
+	}
+}
+
+func (s *suite) testErrors(t *testing.T, ctx context.Context) {
+	const missing = "drivertest-missing"
+	expect := func(op string, err error, want ...error) {
+		t.Helper()
+		for _, w := range want {
+			if errors.Is(err, w) {
+				return
+			}
+		}
+		t.Errorf("%s: got %v, want %v", op, err, want)
+	}
+
+	_, err := s.d.Create(ctx, driver.SandboxConfig{})
+	expect("Create without an image", err, driver.ErrInvalidConfig)
+
+	expect("Start of a missing sandbox", s.d.Start(ctx, missing), driver.ErrSandboxNotFound)
+	expect("Stop of a missing sandbox", s.d.Stop(ctx, missing), driver.ErrSandboxNotFound)
+	_, err = s.d.Info(ctx, missing)
+	expect("Info of a missing sandbox", err, driver.ErrSandboxNotFound)
+	conn, err := s.d.Connect(ctx, missing)
+	if err == nil {
+		conn.Close()
+	}
+	expect("Connect to a missing sandbox", err, driver.ErrSandboxNotFound, driver.ErrSandboxNotRunning)
+
+	id := s.create(t, ctx, false)
+	conn, err = s.d.Connect(ctx, id)
+	if err == nil {
+		conn.Close()
+	}
+	expect("Connect to a sandbox before Start", err, driver.ErrSandboxNotRunning)
+
+	if err := s.d.Stop(ctx, id); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	conn, err = s.d.Connect(ctx, id)
+	if err == nil {
+		conn.Close()
+	}
+	expect("Connect to a stopped sandbox", err, driver.ErrSandboxNotFound, driver.ErrSandboxNotRunning)
+}
+
+func (s *suite) testConcurrency(t *testing.T, ctx context.Context) {
+	ids := make([]string, s.opts.Sandboxes)
+	errs := make([]error, s.opts.Sandboxes)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := s.d.Create(ctx, driver.SandboxConfig{Image: s.opts.Image})
+			if err != nil {
+				errs[i] = fmt.Errorf("Create: %w", err)
+				return
+			}
+			ids[i] = id
+			if err := s.d.Start(ctx, id); err != nil {
+				errs[i] = fmt.Errorf("Start %s: %w", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	t.Cleanup(func() {
+		for _, id := range ids {
+			if id != "" {
+				s.d.Stop(context.Background(), id)
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			t.Fatalf("Create returned the ID %s twice", id)
+		}
+		seen[id] = true
+		if !s.listed(t, ctx, id, driver.StateReady) {
+			t.Errorf("List(ready) does not return the sandbox %s", id)
+		}
+	}
+
+	// Each sandbox runs its own code, at the same time as the others
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stdout, code, err := s.exec(ctx, id, fmt.Sprintf("echo sandbox-%d", i))
+			if err == nil && (code != 0 || !strings.Contains(stdout, fmt.Sprintf("sandbox-%d", i))) {
+				err = fmt.Errorf("exited with %d and printed %q", code, stdout)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("exec in %s: %w", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.d.Stop(ctx, id); err != nil {
+				errs[i] = fmt.Errorf("Stop %s: %w", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if s.listed(t, ctx, id, driver.StateReady) {
+			t.Errorf("List(ready) returns the stopped sandbox %s", id)
+		}
+	}
+}
+
+func (s *suite) testFilesystem(t *testing.T, ctx context.Context) {
+	id := s.create(t, ctx, true)
+	const p = "drivertest/nested/hello.txt"
+
+	get := func(p string) (string, error) {
+		r, err := s.d.GetFile(ctx, id, p)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+
+	// Missing parent directories are created
+	if err := s.d.PutFile(ctx, id, p, strings.NewReader("hello")); err != nil {
+		t.Fatalf("PutFile: %v", err)
+	}
+	if got, err := get(p); err != nil || got != "hello" {
+		t.Errorf("GetFile: got %q, %v, want %q", got, err, "hello")
+	}
+	// Without a known size, and replacing the file
+	if err := s.d.PutFile(ctx, id, p, io.MultiReader(strings.NewReader("hello "), strings.NewReader("again"))); err != nil {
+		t.Fatalf("PutFile of a stream: %v", err)
+	}
+	if got, err := get(p); err != nil || got != "hello again" {
+		t.Errorf("GetFile of a replaced file: got %q, %v, want %q", got, err, "hello again")
+	}
+
+	entries, err := s.d.ListFiles(ctx, id, "drivertest")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	i := slices.IndexFunc(entries, func(e *driver.FileEntry) bool { return e.Name == "hello.txt" })
+	if i < 0 {
+		t.Fatalf("ListFiles does not list the nested hello.txt: %v", entries)
+	}
+	if e := entries[i]; e.IsDir || e.Size != int64(len("hello again")) {
+		t.Errorf("ListFiles: hello.txt has size %d and is_dir %t", e.Size, e.IsDir)
+	}
+	if !slices.ContainsFunc(entries, func(e *driver.FileEntry) bool { return e.Name == "nested" && e.IsDir }) {
+		t.Errorf("ListFiles does not list the directory nested: %v", entries)
+	}
+
+	if _, err := get("drivertest/missing.txt"); err == nil {
+		t.Error("GetFile of a missing file succeeded")
+	}
+
+	// Code in the sandbox sees the uploaded file
+	stdout, code, err := s.exec(ctx, id, "cat "+p)
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if code != 0 || stdout == "" {
+		t.Errorf("cat of the uploaded file exited with %d and printed %q", code, stdout)
+	}
+}
+
+func (s *suite) testConnect(t *testing.T, ctx context.Context) {
+	id := s.create(t, ctx, true)
+	s.ping(t, ctx, id)
+
+	// Connections are independent: one may close while another works
+	first, err := s.d.Connect(ctx, id)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	second, err := s.d.Connect(ctx, id)
+	if err != nil {
+		first.Close()
+		t.Fatalf("second Connect: %v", err)
+	}
+	defer second.Close()
+	first.Close()
+	if err := call(ctx, second, "ping", nil); err != nil {
+		t.Errorf("ping after another connection closed: %v", err)
+	}
+
+	stdout, code, err := s.exec(ctx, id, "echo conformance")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if code != 0 || !strings.Contains(stdout, "conformance") {
+		t.Errorf("echo exited with %d and printed %q", code, stdout)
+	}
+	_, code, err = s.exec(ctx, id, "fail")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if code == 0 {
+		t.Error("a failing command exited with 0")
+	}
+}
+
+// ping checks that the agent of id answers a ping.
+func (s *suite) ping(t *testing.T, ctx context.Context, id string) {
+	t.Helper()
+	conn, err := s.d.Connect(ctx, id)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer conn.Close()
+	if err := call(ctx, conn, "ping", nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
+
+// call sends a request to an agent and waits for its response.
+func call(ctx context.Context, conn io.ReadWriteCloser, method string, params map[string]any) error {
+	_, err := roundTrip(ctx, conn, method, params, nil)
+	return err
+}
+
+// exec runs code with bash in id over a new connection and returns its
+// standard output and exit code.
+func (s *suite) exec(ctx context.Context, id, code string) (string, int, error) {
+	conn, err := s.d.Connect(ctx, id)
+	if err != nil {
+		return "", 0, fmt.Errorf("Connect: %w", err)
+	}
+	defer conn.Close()
+	var stdout strings.Builder
+	exit := -1
+	_, err = roundTrip(ctx, conn, "exec", map[string]any{"cmd": "bash", "args": []string{"-c", code}}, func(method string, params json.RawMessage) bool {
+		var p struct {
+			Chunk string `json:"chunk"`
+			Code  int    `json:"code"`
+		}
+		json.Unmarshal(params, &p)
+		switch method {
+		case "stdout":
+			stdout.WriteString(p.Chunk)
+		case "exit":
+			exit = p.Code
+			return true
+		}
+		return false
+	})
+	return stdout.String(), exit, err
+}
+
+// roundTrip sends a request and reads the agent's messages until its
+// response, and then, if notify is set, until notify returns true for a
+// notification. Reading stops when ctx is done by closing conn.
+func roundTrip(ctx context.Context, conn io.ReadWriteCloser, method string, params map[string]any, notify func(string, json.RawMessage) bool) (json.RawMessage, error) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	msg, err := json.Marshal(proto.NewRequest(method, params, 1))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(msg, '\n')); err != nil {
+		return nil, fmt.Errorf("write %s: %w", method, err)
+	}
+	var result json.RawMessage
+	answered := false
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var m struct {
+			ID     any             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *proto.RPCError `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("invalid message from the agent %q: %w", scanner.Text(), err)
+		}
+		switch {
+		case m.Method == "" && m.Error != nil:
+			return nil, fmt.Errorf("%s: %s", method, m.Error.Message)
+		case m.Method == "":
+			result, answered = m.Result, true
+			if notify == nil {
+				return result, nil
+			}
+		case answered && notify != nil && notify(m.Method, m.Params):
+			return result, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+	return nil, fmt.Errorf("%s: the connection ended: %v", method, scanner.Err())
+}
@@ -0,0 +1,69 @@
+// Package plugin lets third-party sandbox backends run as Boxed driver
+// plugins, without forking Boxed. A plugin is a program named
+// boxed-driver-<name> whose main function calls Serve:
+//
+//	func main() {
+//		if err := plugin.Serve(lxd.New); err != nil {
+//			fmt.Fprintln(os.Stderr, err)
+//			os.Exit(1)
+//		}
+//	}
+//
+// Put it in the server's plugin directory (--plugin-dir or
+// BOXED_PLUGIN_DIR) and select it with --driver <name>. The server starts
+// one process per driver it opens and calls the factory with the driver
+// configuration; durations in it arrive as nanoseconds. Whatever the plugin
+// writes to stdout or stderr goes to the server's log.
+//
+// Sandboxes must run the Boxed agent, and Connect must return a stream to
+// it, as with the built-in drivers. Return the errors below where the Driver
+// documentation asks for them: they reach the API as the same error codes.
+// drivertest.Run checks a driver against that documentation.
+package plugin
+
+import (
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/plugin"
+)
+
+// The Driver interface and the types of its methods.
+type (
+	Driver        = driver.Driver
+	Factory       = driver.DriverFactory
+	SandboxConfig = driver.SandboxConfig
+	SandboxInfo   = driver.SandboxInfo
+	SandboxState  = driver.SandboxState
+	SidecarStatus = driver.SidecarStatus
+	Sidecar       = driver.Sidecar
+	HealthCheck   = driver.HealthCheck
+	NetworkPolicy = driver.NetworkPolicy
+	FileInjection = driver.FileInjection
+	FileEntry     = driver.FileEntry
+)
+
+// Sandbox states for SandboxInfo.State.
+const (
+	StateCreating = driver.StateCreating
+	StateReady    = driver.StateReady
+	StateStopping = driver.StateStopping
+	StateStopped  = driver.StateStopped
+	StateError    = driver.StateError
+)
+
+// Errors the server tells apart; wrap them to add detail.
+var (
+	ErrSandboxNotFound       = driver.ErrSandboxNotFound
+	ErrSandboxAlreadyRunning = driver.ErrSandboxAlreadyRunning
+	ErrSandboxNotRunning     = driver.ErrSandboxNotRunning
+	ErrConnectionFailed      = driver.ErrConnectionFailed
+	ErrResourceExhausted     = driver.ErrResourceExhausted
+	ErrTimeout               = driver.ErrTimeout
+	ErrInvalidConfig         = driver.ErrInvalidConfig
+	ErrNotImplemented        = driver.ErrNotImplemented
+)
+
+// Serve serves the driver factory makes until the server closes it or
+// exits. Run other than by the server, it returns an error at once.
+func Serve(factory Factory) error {
+	return plugin.Serve(factory)
+}
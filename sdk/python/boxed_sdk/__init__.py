@@ -34,15 +34,25 @@ class Session:
         self.id = session_id
         self.base_url = f"{client.base_url}/v1/sandbox/{session_id}"
 
-    def run(self, code: str, language: str = "python", timeout_ms: int = 30000) -> ExecutionResult:
+    def run(self, code: str, language: str = "python", timeout_ms: int = 30000,
+            cwd: Optional[str] = None, user: Optional[str] = None,
+            env: Optional[Dict[str, str]] = None) -> ExecutionResult:
+        payload = {
+            "code": code,
+            "language": language,
+            "timeout_ms": timeout_ms
+        }
+        # Directory, user and environment of this run only
+        if cwd:
+            payload["cwd"] = cwd
+        if user:
+            payload["user"] = user
+        if env:
+            payload["env"] = env
         resp = requests.post(
             f"{self.base_url}/exec",
             headers=self.client._headers(),
-            json={
-                "code": code,
-                "language": language,
-                "timeout_ms": timeout_ms
-            }
+            json=payload
         )
         resp.raise_for_status()
         data = resp.json()
@@ -91,6 +101,19 @@ class Session:
         resp.raise_for_status()
         return resp.json()
 
+    def upload_files(self, files: Dict[str, bytes]) -> Dict[str, Any]:
+        """Uploads several files, keyed by destination path, in one request."""
+        paths = list(files)
+        parts = [('file', (os.path.basename(p) or 'file', files[p])) for p in paths]
+        resp = requests.post(
+            f"{self.base_url}/files",
+            files=parts,
+            data={'paths': json.dumps(paths)},
+            headers=self.client._headers()
+        )
+        resp.raise_for_status()
+        return resp.json()
+
     def download_file(self, path: str) -> bytes:
         resp = requests.get(
             f"{self.base_url}/files/content",
@@ -100,9 +123,12 @@ class Session:
         resp.raise_for_status()
         return resp.content
 
-    def interact(self, language: str = "bash") -> 'Interaction':
+    def interact(self, language: str = "bash", session_id: Optional[str] = None) -> 'Interaction':
+        """Starts a REPL, or reattaches to session_id after a dropped connection."""
         from websocket import create_connection
         ws_url = self.client.base_url.replace("http", "ws") + f"/v1/sandbox/{self.id}/interact?lang={language}"
+        if session_id:
+            ws_url += f"&session={session_id}"
         
         # Attach API key to query if present (websockets often use query for auth)
         if self.client.api_key:
@@ -118,6 +144,8 @@ class Session:
 class Interaction:
     def __init__(self, ws):
         self.ws = ws
+        # Pass to Session.interact(session_id=...) to reattach
+        self.session_id = (ws.getheaders() or {}).get("x-boxed-session")
 
     def write(self, data: str):
         self.ws.send(data)
@@ -1,24 +1,204 @@
-import axios, { AxiosInstance } from 'axios';
+import { BoxedError, ErrorCode } from './errors';
+import { Transport } from './http';
+import type { SocketConstructor, SocketLike } from './http';
+import type {
+    ArtifactOptions,
+    BuildImageOptions,
+    BuildImageResult,
+    ContextReport,
+    DeleteResult,
+    GroupInfo,
+    GroupTTL,
+    Descriptor,
+    ExecErrorKind,
+    ExecRecord,
+    FileEntry,
+    FSChange,
+    FileInjection,
+    FilesUsage,
+    GPURequest,
+    GitSource,
+    LifecycleEvent,
+    LifecycleEventType,
+    LogLine,
+    NetworkPolicy,
+    NodeInfo,
+    Packages,
+    PublishTemplateOptions,
+    RegistryCredential,
+    ResourceStats,
+    Resources,
+    SandboxInfo,
+    SecretInfo,
+    SecretRef,
+    Security,
+    SetupResult,
+    Sidecar,
+    TemplateInfo,
+    TimelineEvent,
+    UploadInfo,
+    UsageReport,
+    VolumeInfo,
+    VolumeMount,
+    WorkspaceInfo,
+} from './types';
 
 export interface BoxedOptions {
     baseUrl: string;
+    /** Sent with every request when the server was started with --api-key */
+    apiKey?: string;
+    /** OpenID Connect token, for servers started with --oidc-issuer */
+    token?: string;
+    /** fetch implementation (default: the global fetch, Node 18+) */
+    fetch?: typeof fetch;
+    /** WebSocket constructor (default: the global WebSocket, else the "ws" package) */
+    WebSocket?: SocketConstructor;
 }
 
 export interface CreateSessionOptions {
-    template: string;
+    /** A template of the server's catalog or an image; by default the
+     * catalog's default template */
+    template?: string;
+    /** Lifetime; by default the template's, or 5 minutes */
     timeoutMs?: number;
     metadata?: Record<string, string>;
+    networkPolicy?: NetworkPolicy;
+    /** Files written into the sandbox before it starts */
+    context?: FileInjection[];
+    sidecars?: Sidecar[];
+    /** Base workspace the sandbox sees copy-on-write at its working directory */
+    workspace?: string;
+    /** Persistent volumes to mount; see createVolume */
+    volumes?: VolumeMount[];
+    /** Backend on servers running several, e.g. "docker"; by default the server routes by template */
+    driver?: string;
+    /** Repository cloned into the working directory, below the context files */
+    git?: GitSource;
+    /** User code runs as instead of root: a name or "uid[:gid]" */
+    user?: string;
+    /** Registered secrets to inject; their values are masked in output */
+    secrets?: SecretRef[];
+    /**
+     * Packages installed before the session is ready, cached by the server
+     * as an image per template and package set. Docker only.
+     */
+    packages?: Packages;
+    /** Hardening replacing the server's default; {} keeps Docker's defaults */
+    security?: Security;
+    /** Image platform, e.g. 'linux/amd64' (default: the host's) */
+    platform?: string;
+    /**
+     * GPUs passed through to the sandbox (Docker only). Creating fails with
+     * ErrorCode.QuotaExceeded while too many are in use.
+     */
+    gpu?: GPURequest;
+    /**
+     * Network shared with the other sessions of the group, and nothing else
+     * (Docker only). The network is removed with the group's last session.
+     */
+    networkGroup?: string;
+    /** Name the other sessions of networkGroup reach this one at, e.g. 'db' */
+    networkAlias?: string;
+    /**
+     * Name server IP addresses replacing the host's in the sandbox's
+     * resolv.conf, at most 3 (Docker only); dnsSearch and dnsOptions are its
+     * search domains and options, e.g. 'ndots:2'.
+     */
+    dns?: string[];
+    dnsSearch?: string[];
+    dnsOptions?: string[];
+    /**
+     * Entries of the sandbox's /etc/hosts, e.g. { 'api.internal': '10.0.0.5' };
+     * the address 'host-gateway' is the host's (Docker only).
+     */
+    extraHosts?: Record<string, string>;
+    /**
+     * Session group the session joins (see Boxed.createGroup), whose
+     * lifetime it shares; timeoutMs must then be unset.
+     */
+    group?: string;
+}
+
+export interface CreateGroupOptions {
+    /** Names the group, e.g. after a conversation (default: picked by the server) */
+    id?: string;
+    /** Lifetime of the group and its sessions (default: 5 minutes), sent with second precision */
+    ttlMs?: number;
+    metadata?: Record<string, string>;
+}
+
+export interface CreateWorkspaceOptions {
+    name: string;
+    files?: FileInjection[];
+    /** Image used to fill the workspace on the Docker driver */
+    image?: string;
+}
+
+export interface ListSessionsOptions {
+    /** Metadata the sandboxes must have; an empty value matches any value */
+    labels?: Record<string, string>;
+    /** States the sandboxes may be in, e.g. ["ready"] */
+    states?: string[];
+}
+
+export interface DeleteSessionsOptions extends ListSessionsOptions {
+    /** Required to delete without labels, e.g. every stopped sandbox */
+    all?: boolean;
+}
+
+export interface EventOptions {
+    /** Metadata the sandboxes must have; an empty value matches any value */
+    labels?: Record<string, string>;
+    /** Event types to receive, e.g. ["ttl_warning", "stopped"] */
+    types?: LifecycleEventType[];
+}
+
+export interface UsageOptions {
+    /** Start of the period (default the start of the current month, UTC) */
+    from?: Date | string;
+    /** End of the period (default now) */
+    to?: Date | string;
+    /** "key" (default), "image", "sandbox" or "label:<name>" */
+    groupBy?: string;
 }
 
 export interface RunOptions {
-    code: string;
+    code?: string;
+    /** python (default), python-session, javascript, bash or bash-session;
+     * python-session keeps variables and imports between runs, bash-session
+     * the working directory and environment */
     language?: string;
+    /** A script in the sandbox to run instead of code, e.g.
+     * '/workspace/run.py'; the language defaults to its extension's */
+    file?: string;
+    /** Arguments of the script run with file */
+    args?: string[];
+    /** Write full stdout/stderr into the sandbox when they exceed the capture limit */
+    spillOutput?: boolean;
+    artifacts?: ArtifactOptions;
+    /** Let the server answer from its exec cache; the code then does not run */
+    cache?: boolean;
+    /** Directory to run in, absolute or relative to the working directory */
+    cwd?: string;
+    /** User to run as instead of the session's default */
+    user?: string;
+    /** Environment variables for this run only */
+    env?: Record<string, string>;
+    /** Registered secrets to inject for this run; not supported by stream */
+    secrets?: SecretRef[];
+    /** When the sandbox already runs as many execs as the server allows:
+     * 'queue' (default) waits its turn, 'reject' fails with sandbox_busy */
+    ifBusy?: 'queue' | 'reject';
 }
 
 export interface Artifact {
     type: string;
     path: string;
-    url: string; // Data URI for MVP
+    /** Data URI for inline artifacts, download URL otherwise */
+    url: string;
+    size?: number;
+    /** Content digest, set when the server keeps an artifact store */
+    sha256?: string;
 }
 
 export interface ExecutionResult {
@@ -26,64 +206,467 @@ export interface ExecutionResult {
     stderr: string;
     artifacts: Artifact[];
     exitCode: number;
+    /** stdout or stderr exceeded the capture limit; the byte counts are the full sizes */
+    truncated: boolean;
+    stdoutBytes: number;
+    stderrBytes: number;
+    /** The result came from the server's exec cache */
+    cached: boolean;
+    /** Where the exec waited for other execs of the sandbox, from 1, and
+     * for how long; unset if it ran at once */
+    queuePosition?: number;
+    queuedMs?: number;
+    /** Why the process ended abnormally: 'signaled', 'oom_killed',
+     * 'sandbox_died' or 'agent_crashed' (which leave exitCode -1) */
+    exitReason?: string;
+    /** The signal that killed the process */
+    signal?: number;
+    /** Why the exec failed, when it was not the code's doing, e.g.
+     * 'command_not_found'; errorMessage is the sandbox's description */
+    errorKind?: ExecErrorKind;
+    errorMessage?: string;
+}
+
+export interface JobOptions extends RunOptions {
+    /** How long the run may take, not counting the time queued; rounded up
+     * to seconds, 10 minutes by default */
+    timeoutMs?: number;
+    /** Sent a POST of the finished job */
+    callbackUrl?: string;
+}
+
+/** An exec queued with Session.submit. */
+export interface Job {
+    id: string;
+    sessionId: string;
+    status: 'queued' | 'running' | 'done' | 'failed' | 'canceled';
+    /** Set once the code has run, whatever its exit code */
+    result?: ExecutionResult;
+    /** Why a failed or canceled job has no result */
+    error?: string;
+    errorCode?: string;
+    createdAt: Date;
+    startedAt?: Date;
+    finishedAt?: Date;
+}
+
+/** An event of a streamed exec, in the order the sandbox produced them. */
+export type ExecEvent =
+    | { type: 'stdout'; chunk: string }
+    | { type: 'stderr'; chunk: string }
+    | { type: 'artifact'; artifact: Artifact }
+    | { type: 'error'; message: string; kind?: ExecErrorKind }
+    | { type: 'exit'; code: number; signal?: number; reason?: string };
+
+export interface InteractionEvent {
+    type: 'stdout' | 'stderr' | 'exit' | 'error';
+    chunk?: string;
+    code?: number;
+    /** On exit, the signal that killed the process */
+    signal?: number;
+    message?: string;
+    /** The named REPL session the event comes from, if any */
+    session?: string;
+}
+
+interface WireArtifact {
+    path: string;
+    mime: string;
+    size?: number;
+    data_base64?: string;
+    url?: string;
+    sha256?: string;
+}
+
+interface WireExecResult {
+    stdout: string;
+    stderr: string;
+    exit_code: number | null;
+    artifacts: WireArtifact[] | null;
+    truncated: boolean;
+    stdout_bytes: number;
+    stderr_bytes: number;
+    cached?: boolean;
+    queue_position?: number;
+    queued_ms?: number;
+    exit_reason?: string;
+    signal?: number;
+    error_kind?: ExecErrorKind;
+    error_message?: string;
+}
+
+interface WireJob {
+    id: string;
+    sandbox_id: string;
+    status: Job['status'];
+    result?: WireExecResult;
+    error?: string;
+    error_code?: string;
+    created_at: string;
+    started_at?: string;
+    finished_at?: string;
+}
+
+// streamRequestID tells the response to a streamed exec from the one to the
+// REPL the interact endpoint starts.
+const streamRequestID = 2;
+
+// Interpreters per language, as the server runs them for exec.
+const interpreters: Record<string, [string, string]> = {
+    python: ['python3', '-c'],
+    javascript: ['node', '-e'],
+    node: ['node', '-e'],
+    bash: ['bash', '-c'],
+    sh: ['bash', '-c'],
+};
+
+/** The languages of script extensions, for files run without a language. */
+const fileLanguages: Record<string, string> = {
+    '.py': 'python',
+    '.js': 'javascript',
+    '.mjs': 'javascript',
+    '.cjs': 'javascript',
+    '.sh': 'bash',
+};
+
+function fileLanguage(file: string): string | undefined {
+    const dot = file.lastIndexOf('.');
+    return dot > file.lastIndexOf('/') ? fileLanguages[file.slice(dot)] : undefined;
 }
 
 export class Session {
-    constructor(private readonly client: AxiosInstance, public readonly id: string) { }
+    private readonly transport: Transport;
+    readonly id: string;
+    /** The package installation, for sessions created with packages */
+    readonly setup?: SetupResult;
+    /** What the server warned of on creation, e.g. that the image runs emulated */
+    readonly warnings: string[];
+    /** The injection of the context files, for sessions created with them */
+    readonly context?: ContextReport;
+
+    constructor(transport: Transport, id: string, setup?: SetupResult, warnings: string[] = [], context?: ContextReport) {
+        this.transport = transport;
+        this.id = id;
+        this.setup = setup;
+        this.warnings = warnings;
+        this.context = context;
+    }
+
+    private get path(): string {
+        return `/sandbox/${encodeURIComponent(this.id)}`;
+    }
 
     /**
      * Executes code within the sandbox session.
      * @param codeOrOptions The code string or options object.
      */
     async run(codeOrOptions: string | RunOptions): Promise<ExecutionResult> {
-        const options = typeof codeOrOptions === 'string' ? { code: codeOrOptions } : codeOrOptions;
-        const language = options.language || 'python';
+        const options = runOptions(codeOrOptions);
+        const data = await this.transport.json<WireExecResult>('POST', `${this.path}/exec`, {
+            json: wireRunOptions(options),
+        });
+
+        return toExecutionResult(data, this.id, this.transport);
+    }
+
+    /**
+     * Queues code to run in the background and returns the job at once.
+     * Jobs of a session run one at a time, in order; poll them with
+     * Boxed.getJob or give a callbackUrl.
+     */
+    async submit(codeOrOptions: string | JobOptions): Promise<Job> {
+        const options: JobOptions = runOptions(codeOrOptions);
+        const data = await this.transport.json<WireJob>('POST', `${this.path}/jobs`, {
+            json: {
+                ...wireRunOptions(options),
+                timeout: options.timeoutMs ? Math.ceil(options.timeoutMs / 1000) : undefined,
+                callback_url: options.callbackUrl,
+            },
+        });
+        return toJob(data, this.transport);
+    }
+
+    /** Returns the jobs of the session, oldest first. */
+    async jobs(): Promise<Job[]> {
+        const data = await this.transport.json<{ jobs: WireJob[] }>('GET', `${this.path}/jobs`);
+        return (data.jobs || []).map(j => toJob(j, this.transport));
+    }
+
+    /**
+     * Executes code and yields its output while it runs. The exec goes over
+     * the interact WebSocket: it is not recorded in the exec history, cached
+     * or capped to the server's output limit.
+     * @param codeOrOptions The code string or options object.
+     */
+    async *stream(codeOrOptions: string | RunOptions): AsyncGenerator<ExecEvent> {
+        const options = runOptions(codeOrOptions);
+        const language = options.language || (options.file ? fileLanguage(options.file) : 'python') || '';
+        const interpreter = interpreters[language];
+        if (!interpreter) {
+            throw new BoxedError(`unsupported language: ${language}`, 0, ErrorCode.InvalidRequest);
+        }
+        if (options.secrets?.length) {
+            throw new BoxedError('secrets can only be given to run', 0, ErrorCode.InvalidRequest);
+        }
+
+        // Large artifacts come as binary frames, after their notification
+        const ws = await this.transport.socket(`${this.path}/interact`, { artifacts: 'binary' });
+        ws.binaryType = 'arraybuffer';
+        const events = new EventQueue<ExecEvent>();
+        const streams = new ArtifactStreams();
+        let exit: ExecEvent | undefined;
+        ws.onmessage = ev => {
+            if (typeof ev.data !== 'string') {
+                let artifact: WireArtifact | undefined;
+                try {
+                    artifact = streams.frame(ev.data);
+                } catch (err) {
+                    events.fail(err as Error);
+                    return;
+                }
+                if (artifact) {
+                    events.push({ type: 'artifact', artifact: toArtifact(artifact, this.id, this.transport) });
+                }
+                if (exit && streams.pending() === 0) {
+                    events.push(exit);
+                    events.end();
+                }
+                return;
+            }
+            const msg = parseMessage(ev.data);
+            if (msg?.id === streamRequestID && msg.error) {
+                events.fail(new BoxedError(msg.error.message, 0, ErrorCode.Internal));
+                return;
+            }
+            const params = msg?.params || {};
+            switch (msg?.method) {
+                case 'stdout':
+                case 'stderr':
+                    events.push({ type: msg.method, chunk: params.chunk });
+                    break;
+                case 'artifact':
+                    if (params.stream) {
+                        streams.begin(params);
+                    } else {
+                        events.push({ type: 'artifact', artifact: toArtifact(params, this.id, this.transport) });
+                    }
+                    break;
+                case 'error':
+                    events.push({ type: 'error', message: params.message, kind: params.kind });
+                    break;
+                case 'exit':
+                    exit = { type: 'exit', code: params.code, signal: params.signal, reason: params.reason };
+                    // Frames of artifacts may still be on their way
+                    if (streams.pending() === 0) {
+                        events.push(exit);
+                        events.end();
+                    }
+                    break;
+            }
+        };
+        ws.onerror = () => events.fail(new BoxedError('websocket error', 0, ErrorCode.Unavailable));
+        ws.onclose = () => events.end();
 
+        const [cmd, flag] = interpreter;
+        ws.send(JSON.stringify({
+            jsonrpc: '2.0',
+            method: 'exec',
+            params: {
+                cmd,
+                args: options.file ? [options.file, ...(options.args || [])] : [flag, options.code],
+                cwd: options.cwd,
+                user: options.user,
+                env: options.env,
+                artifacts: wireArtifactOptions(options.artifacts),
+            },
+            id: streamRequestID,
+        }));
         try {
-            const response = await this.client.post<{
-                stdout: string;
-                stderr: string;
-                exit_code?: number;
-                artifacts?: Array<{ mime: string; path: string; data_base64: string }>;
-            }>(`/v1/sandbox/${this.id}/exec`, {
-                code: options.code,
-                language: language,
-            });
-
-            const data = response.data;
-            const artifacts: Artifact[] = (data.artifacts || []).map(a => ({
-                type: a.mime,
-                path: a.path,
-                url: `data:${a.mime};base64,${a.data_base64}`
-            }));
-
-            // Exit code might be missing if process failed to report it? 
-            // Our API returns *int.
-            const exitCode = data.exit_code !== undefined ? data.exit_code : -1;
-
-            return {
-                stdout: data.stdout || '',
-                stderr: data.stderr || '',
-                artifacts: artifacts,
-                exitCode: exitCode,
-            };
-        } catch (error: any) {
-            // Enhance error if possible
-            if (axios.isAxiosError(error) && error.response) {
-                throw new Error(`Execution failed: ${error.response.status} ${JSON.stringify(error.response.data)}`);
+            yield* events;
+        } finally {
+            ws.close();
+        }
+    }
+
+    /** Returns the sandbox state, configuration and expiry. */
+    async info(): Promise<SandboxInfo> {
+        return this.transport.json<SandboxInfo>('GET', this.path);
+    }
+
+    /** Returns the executions performed in the sandbox, oldest first. */
+    async execs(): Promise<ExecRecord[]> {
+        const data = await this.transport.json<{ execs: ExecRecord[] }>('GET', `${this.path}/execs`);
+        return data.execs || [];
+    }
+
+    /** Returns the run of the init script of the sandbox's template. */
+    async init(): Promise<ExecRecord> {
+        return this.transport.json<ExecRecord>('GET', `${this.path}/init`);
+    }
+
+    /** Returns the lifecycle events of the sandbox, oldest first. */
+    async timeline(): Promise<TimelineEvent[]> {
+        const data = await this.transport.json<{ events: TimelineEvent[] }>('GET', `${this.path}/timeline`);
+        return data.events || [];
+    }
+
+    /**
+     * Yields the recent log lines of the sandbox, oldest first. With follow
+     * set it keeps yielding new lines until the sandbox stops.
+     * @param options.source "agent" (default) or "process"
+     * @param options.exec only yield the agent lines of this agent process
+     */
+    async *logs(options: { source?: 'agent' | 'process'; exec?: string; follow?: boolean } = {}): AsyncGenerator<LogLine> {
+        const res = await this.transport.request('GET', `${this.path}/logs`, {
+            query: { source: options.source || 'agent', exec: options.exec, follow: options.follow ? 'true' : undefined },
+        });
+        if (!res.body) {
+            return;
+        }
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffered = '';
+        try {
+            while (true) {
+                const { done, value } = await reader.read();
+                buffered += decoder.decode(value, { stream: !done });
+                let newline: number;
+                while ((newline = buffered.indexOf('\n')) >= 0) {
+                    const line = buffered.slice(0, newline).trim();
+                    buffered = buffered.slice(newline + 1);
+                    if (line) {
+                        yield JSON.parse(line) as LogLine;
+                    }
+                }
+                if (done) {
+                    return;
+                }
             }
-            throw error;
+        } finally {
+            reader.cancel().catch(() => undefined);
         }
     }
 
+    /** Samples the resources the sandbox uses. */
+    async stats(): Promise<ResourceStats> {
+        return this.transport.json<ResourceStats>('GET', `${this.path}/stats`);
+    }
+
+    /**
+     * Yields a resource sample every `intervalMs` (default 1000) until the
+     * sandbox stops, for live gauges. Break out of the loop to stop.
+     */
+    async *streamStats(intervalMs?: number): AsyncGenerator<ResourceStats> {
+        const res = await this.transport.request('GET', `${this.path}/stats/stream`, {
+            query: { interval: intervalMs ? String(intervalMs / 1000) : undefined },
+        });
+        yield* serverSentEvents<ResourceStats>(res);
+    }
+
+    /** Describes the interpreters, packages and limits of the sandbox. */
+    async describe(): Promise<Descriptor> {
+        return this.transport.json<Descriptor>('GET', `${this.path}/descriptor`);
+    }
+
+    /**
+     * Sets the remaining lifetime of the sandbox and returns its new expiry.
+     * @param ttlMs Lifetime from now, sent with second precision
+     */
+    async setTTL(ttlMs: number): Promise<Date> {
+        return this.changeTTL({ ttl: Math.ceil(ttlMs / 1000) });
+    }
+
+    /**
+     * Extends (or, if negative, shortens) the lifetime of the sandbox and
+     * returns its new expiry.
+     */
+    async extendTTL(byMs: number): Promise<Date> {
+        return this.changeTTL({ extend_by: Math.trunc(byMs / 1000) });
+    }
+
+    private async changeTTL(body: { ttl?: number; extend_by?: number }): Promise<Date> {
+        const data = await this.transport.json<{ expires_at: string }>('POST', `${this.path}/ttl`, { json: body });
+        return new Date(data.expires_at);
+    }
+
+    /**
+     * Raises or lowers the memory and CPU limits of the running sandbox and
+     * returns those it has now. Omitted fields keep their limit.
+     */
+    async updateResources(resources: Resources): Promise<Resources> {
+        return this.transport.json<Resources>('PATCH', `${this.path}/resources`, { json: resources });
+    }
+
+    /**
+     * Stops the processes of the sandbox but keeps its files, until wake()
+     * starts it again with the same ID. Its TTL does not run meanwhile.
+     */
+    async hibernate(): Promise<SandboxInfo> {
+        return this.transport.json<SandboxInfo>('POST', `${this.path}/hibernate`);
+    }
+
+    /**
+     * Starts the hibernated sandbox again and returns it.
+     * @param ttlMs Lifetime from now, sent with second precision; by default
+     * what was left of its TTL when it hibernated
+     */
+    async wake(ttlMs?: number): Promise<SandboxInfo> {
+        const body = ttlMs === undefined ? {} : { ttl: Math.ceil(ttlMs / 1000) };
+        return this.transport.json<SandboxInfo>('POST', `${this.path}/wake`, { json: body });
+    }
+
+    /**
+     * Returns the files and directories the session added, changed or
+     * removed since it was created, sorted by path: what its code wrote,
+     * wherever it wrote it. /tmp, /output, volumes and workspaces are not
+     * tracked.
+     * @param path Only the changes at or under this directory
+     */
+    async fsDiff(path?: string): Promise<FSChange[]> {
+        const data = await this.transport.json<{ changes: FSChange[] }>('GET', `${this.path}/fsdiff`, { query: { path } });
+        return data.changes;
+    }
+
+    /**
+     * Measures the files under the given directories, /workspace and
+     * /output by default, so that agents can clean up before writes fail.
+     * @param paths Absolute paths in the session
+     */
+    async filesUsage(...paths: string[]): Promise<FilesUsage> {
+        return this.transport.json<FilesUsage>('GET', `${this.path}/files/usage`, { query: { path: paths } });
+    }
+
+    /**
+     * Saves the session's filesystem as a template with its resources,
+     * which later sessions can be created from, e.g. 'my-env:v1'.
+     * Publishing a name again replaces it. Running processes are not saved.
+     */
+    async publishTemplate(options: PublishTemplateOptions): Promise<TemplateInfo> {
+        return this.transport.json<TemplateInfo>('POST', `${this.path}/publish-template`, { json: options });
+    }
+
+    /**
+     * Sends a signal to the running execs and the processes they started,
+     * e.g. to interrupt a hung command, and returns how many execs got it.
+     * An exec the signal kills ends with exitReason 'signaled'.
+     * @param signal 'SIGINT', 'SIGTERM', 'SIGKILL', 'SIGHUP', 'SIGQUIT', 'SIGUSR1' or 'SIGUSR2'
+     * @param sessionId Signal the REPL of this interactive session instead
+     */
+    async signal(signal: string, sessionId?: string): Promise<number> {
+        const data = await this.transport.json<{ processes: number }>('POST', `${this.path}/signal`, {
+            json: { signal, session: sessionId },
+        });
+        return data.processes;
+    }
+
     /**
      * Closes the session and releases resources (stops the sandbox).
      */
     async close(): Promise<void> {
         try {
-            await this.client.delete(`/v1/sandbox/${this.id}`);
+            await this.transport.request('DELETE', this.path);
         } catch (error: any) {
-            if (axios.isAxiosError(error) && error.response && error.response.status === 404) {
+            if (error instanceof BoxedError && error.status === 404) {
                 // Already closed
                 return;
             }
@@ -96,22 +679,17 @@ export class Session {
      * @param path Directory path (default: "/")
      */
     async listFiles(path: string = '/'): Promise<FileEntry[]> {
-        const response = await this.client.get<{ files: FileEntry[] }>(`/v1/sandbox/${this.id}/files`, {
-            params: { path }
-        });
-        return response.data.files || [];
+        const data = await this.transport.json<{ files: FileEntry[] }>('GET', `${this.path}/files`, { query: { path } });
+        return data.files || [];
     }
 
     /**
      * Uploads a file to the sandbox.
-     * @param options Upload options
+     * @param file File contents
+     * @param path Destination file, or directory if it ends with "/" (default: /workspace/file)
      */
-    async uploadFile(file: Buffer | Blob, path?: string): Promise<{ status: string; path: string }> {
-        const formData = new FormData();
-
-        // If path looks like a file path (starts after /), extract it.
-        // The server appends file.Filename to path.
-        // So we should pass the directory in 'path' and the filename in the blob field.
+    async uploadFile(file: Buffer | Blob, path?: string): Promise<{ status: string; path: string; checksums: Record<string, string> }> {
+        // The server appends the file name to the directory in "path"
         let destDir = '/workspace';
         let filename = 'file';
 
@@ -125,138 +703,759 @@ export class Session {
             }
         }
 
-        formData.append('file', new Blob([file as any]), filename);
+        const blob = file instanceof Blob ? file : new Blob([file as any]);
+        const formData = new FormData();
+        formData.append('file', blob, filename);
         formData.append('path', destDir);
+        formData.append('sha256', await sha256Hex(blob));
+
+        return this.transport.json('POST', `${this.path}/files`, { body: formData });
+    }
+
+    /**
+     * Uploads several files in one request, which the server writes in one
+     * transfer where its driver can, e.g. the files of a project.
+     * @param files Contents by destination path; relative paths are taken from /uploads
+     */
+    async uploadFiles(
+        files: { path: string; content: Buffer | Blob; sha256?: string }[],
+    ): Promise<{ status: string; path: string; paths: string[]; checksums: Record<string, string> }> {
+        const formData = new FormData();
+        formData.append('paths', JSON.stringify(files.map((f) => f.path)));
+        for (const f of files) {
+            const name = f.path.split('/').pop() || 'file';
+            const blob = f.content instanceof Blob ? f.content : new Blob([f.content as any]);
+            formData.append('file', blob, name);
+            formData.append('sha256', f.sha256 ?? (await sha256Hex(blob)));
+        }
+        return this.transport.json('POST', `${this.path}/files`, { body: formData });
+    }
 
-        const response = await this.client.post(`/v1/sandbox/${this.id}/files`, formData, {
-            headers: { 'Content-Type': 'multipart/form-data' }
+    /**
+     * Uploads a large file in chunks, so it never has to fit in one request.
+     * A chunk that fails in transit is resumed from the offset the server
+     * reports.
+     * @param file File contents
+     * @param path Destination file
+     * @param chunkSize Bytes per request (default: 8 MiB)
+     */
+    async uploadLargeFile(file: Blob | Uint8Array, path: string, chunkSize: number = 8 << 20): Promise<{ status: string; path: string; size: number }> {
+        const blob = file instanceof Blob ? file : new Blob([file as any]);
+        const upload = await this.transport.json<UploadInfo>('POST', `${this.path}/uploads`, {
+            json: { path, size: blob.size },
         });
-        return response.data;
+        const uploadPath = `${this.path}/uploads/${encodeURIComponent(upload.id)}`;
+
+        try {
+            let offset = 0;
+            let failures = 0;
+            while (offset < blob.size) {
+                const end = Math.min(offset + chunkSize, blob.size);
+                try {
+                    const res = await this.transport.json<UploadInfo>('PATCH', uploadPath, {
+                        body: blob.slice(offset, end),
+                        headers: { 'Content-Type': 'application/octet-stream', 'Upload-Offset': String(offset) },
+                    });
+                    offset = res.offset;
+                    failures = 0;
+                } catch (error) {
+                    if (++failures > 3) {
+                        throw error;
+                    }
+                    offset = (await this.transport.json<UploadInfo>('GET', uploadPath)).offset;
+                }
+            }
+            return await this.transport.json('POST', `${uploadPath}/commit`, { json: {} });
+        } catch (error) {
+            await this.transport.request('DELETE', uploadPath).catch(() => undefined);
+            throw error;
+        }
     }
 
     /**
      * Downloads a file from the sandbox.
      * @param path Path to file
+     * @param range Byte range to fetch, e.g. to resume a download; end is inclusive and defaults to the end of the file
      */
-    async downloadFile(path: string): Promise<ArrayBuffer> {
-        const response = await this.client.get(`/v1/sandbox/${this.id}/files/content`, {
-            params: { path },
-            responseType: 'arraybuffer'
-        });
-        return response.data;
+    async downloadFile(path: string, range?: { start: number; end?: number }): Promise<ArrayBuffer> {
+        const headers: Record<string, string> = {};
+        const query: Record<string, string> = { path };
+        if (range) {
+            headers['Range'] = `bytes=${range.start}-${range.end ?? ''}`;
+        } else {
+            // fetch cannot read trailers, so only digests sent as a header
+            // are checked
+            query.checksum = 'true';
+        }
+        const res = await this.transport.request('GET', `${this.path}/files/content`, { query, headers });
+        if (range && res.status !== 206) {
+            throw new BoxedError(`server does not support ranges for ${path}`, res.status, ErrorCode.NotImplemented);
+        }
+        const data = await res.arrayBuffer();
+        const want = res.headers.get('X-Boxed-Content-SHA256');
+        if (!range && want) {
+            const got = await sha256Hex(new Blob([data]));
+            if (got && got !== want.toLowerCase()) {
+                throw new BoxedError(`sha256 of ${path} is ${got}, the server sent ${want}`, res.status, ErrorCode.Internal);
+            }
+        }
+        return data;
     }
 
     /**
      * Starts an interactive session (REPL) using WebSockets.
      * @param language The language shell to start (default: "bash")
+     * @param name Names the session so that several can run in the sandbox;
+     * joins it if it is already running
      */
-    async interact(language: string = 'bash'): Promise<Interaction> {
-        const WebSocket = require('ws');
-        const baseUrl = this.client.defaults.baseURL || 'http://localhost:8080';
-        const wsUrl = baseUrl.replace(/^http/, 'ws') + `/v1/sandbox/${this.id}/interact?lang=${language}`;
+    async interact(language: string = 'bash', name?: string): Promise<Interaction> {
+        const ws = await this.transport.socket(`${this.path}/interact`, { lang: language, session: name });
+        return Interaction.open(ws);
+    }
 
-        const ws = new WebSocket(wsUrl);
-        return new Interaction(ws);
+    /**
+     * Reattaches to an interactive session whose connection dropped. The
+     * recent output is replayed to the first onOutput handler registered.
+     * @param sessionId Interaction.sessionId of the dropped session
+     */
+    async attach(sessionId: string): Promise<Interaction> {
+        const ws = await this.transport.socket(`${this.path}/interact`, { session: sessionId });
+        return Interaction.open(ws);
     }
 }
 
 export class Interaction {
-    private onOutputHandlers: Array<(data: { type: 'stdout' | 'stderr' | 'exit' | 'error', chunk?: string, code?: number, message?: string }) => void> = [];
-
-    constructor(private readonly ws: any) {
-        ws.on('message', (data: Buffer) => {
-            try {
-                const event = JSON.parse(data.toString());
-                if (event.method) {
-                    const type = event.method;
-                    const params = event.params || {};
-                    this.onOutputHandlers.forEach(h => h({
-                        type: type as any,
-                        chunk: params.chunk,
-                        code: params.code,
-                        message: params.message
-                    }));
-                }
-            } catch (e) {
-                // Not a JSON-RPC event? ignore or pass as raw stdout chunk?
-                // Our API implementation wraps most things.
-            }
+    private readonly ws: SocketLike;
+    private onOutputHandlers: Array<(data: InteractionEvent) => void> = [];
+    // Output received before a handler is registered
+    private pending: InteractionEvent[] = [];
+    private started?: (id: string) => void;
+
+    /** Server-side session; pass it to Session.attach after a dropped connection */
+    sessionId = '';
+
+    /** Wraps ws and resolves once the server has announced the session. */
+    static open(ws: SocketLike): Promise<Interaction> {
+        const interaction = new Interaction(ws);
+        return new Promise((resolve, reject) => {
+            interaction.started = () => resolve(interaction);
+            ws.onclose = () => reject(new BoxedError('websocket closed before the session started', 0, ErrorCode.Unavailable));
         });
     }
 
-    onOutput(handler: (data: { type: 'stdout' | 'stderr' | 'exit' | 'error', chunk?: string, code?: number, message?: string }) => void) {
+    constructor(ws: SocketLike) {
+        this.ws = ws;
+        ws.onmessage = ev => {
+            const msg = parseMessage(ev.data);
+            if (!msg?.method) {
+                // Responses to requests carry no output
+                return;
+            }
+            const params = msg.params || {};
+            if (msg.method === 'session') {
+                this.sessionId = params.id;
+                this.started?.(params.id);
+                return;
+            }
+            const event: InteractionEvent = {
+                type: msg.method,
+                chunk: params.chunk,
+                code: params.code,
+                signal: params.signal,
+                message: params.message,
+                session: params.session,
+            };
+            if (this.onOutputHandlers.length === 0) {
+                this.pending.push(event);
+                return;
+            }
+            this.onOutputHandlers.forEach(h => h(event));
+        };
+    }
+
+    onOutput(handler: (data: InteractionEvent) => void) {
         this.onOutputHandlers.push(handler);
+        const pending = this.pending;
+        this.pending = [];
+        pending.forEach(handler);
     }
 
     async write(data: string): Promise<void> {
-        return new Promise((resolve, reject) => {
-            this.ws.send(data, (err: any) => {
-                if (err) reject(err);
-                else resolve();
-            });
-        });
+        this.ws.send(data);
     }
 
+    /** Ends the session; the REPL stops. */
     async close(): Promise<void> {
         this.ws.close();
     }
 }
 
-export interface FileEntry {
-    name: string;
-    path: string;
-    size: number;
-    mode: number;
-    is_dir: boolean;
-    last_modified: string;
-}
-
 export class Boxed {
-    private readonly client: AxiosInstance;
+    private readonly transport: Transport;
 
     constructor(options: BoxedOptions) {
-        this.client = axios.create({
-            baseURL: options.baseUrl,
-            headers: {
-                'Content-Type': 'application/json',
-            }
-        });
+        this.transport = new Transport(options.baseUrl, options.apiKey, options.token, options.fetch, options.WebSocket);
     }
 
     /**
      * Creates a new sandbox session.
      */
-    async createSession(options: CreateSessionOptions): Promise<Session> {
-        const timeoutSec = options.timeoutMs ? Math.ceil(options.timeoutMs / 1000) : 300;
+    async createSession(options: CreateSessionOptions = {}): Promise<Session> {
+        const timeoutSec = options.timeoutMs ? Math.ceil(options.timeoutMs / 1000) : undefined;
 
-        try {
-            const response = await this.client.post<{ sandbox_id: string; status: string }>('/v1/sandbox', {
+        const data = await this.transport.json<{ sandbox_id: string; status: string; setup?: SetupResult; warnings?: string[]; context?: ContextReport }>('POST', '/sandbox', {
+            json: {
                 template: options.template,
                 timeout: timeoutSec,
                 metadata: options.metadata,
-            });
+                network_policy: options.networkPolicy,
+                context: options.context,
+                sidecars: options.sidecars,
+                workspace: options.workspace,
+                volumes: options.volumes,
+                driver: options.driver,
+                git: options.git,
+                user: options.user,
+                secrets: options.secrets,
+                packages: options.packages,
+                security: options.security,
+                platform: options.platform,
+                gpu: options.gpu,
+                network_group: options.networkGroup,
+                network_alias: options.networkAlias,
+                dns: options.dns,
+                dns_search: options.dnsSearch,
+                dns_options: options.dnsOptions,
+                extra_hosts: options.extraHosts,
+                session: options.group,
+            },
+        });
+        return new Session(this.transport, data.sandbox_id, data.setup, data.warnings, data.context);
+    }
 
-            return new Session(this.client, response.data.sandbox_id);
-        } catch (error: any) {
-            if (axios.isAxiosError(error) && error.response) {
-                throw new Error(`Failed to create session: ${error.response.status} ${JSON.stringify(error.response.data)}`);
+    /**
+     * Returns a session for an existing sandbox, e.g. one created by another
+     * process. The sandbox is not checked; use Session.info for that.
+     */
+    session(id: string): Session {
+        return new Session(this.transport, id);
+    }
+
+    /**
+     * Lists the sandboxes managed by the server.
+     */
+    async listSessions(options: ListSessionsOptions = {}): Promise<SandboxInfo[]> {
+        const labels = Object.entries(options.labels || {}).map(([k, v]) => (v ? `${k}=${v}` : k));
+        const data = await this.transport.json<{ sandboxes: SandboxInfo[] }>('GET', '/sandbox', {
+            query: { label: labels, state: options.states },
+        });
+        return data.sandboxes || [];
+    }
+
+    /**
+     * Stops and removes the sandboxes matching labels and states. Without
+     * labels, `all` must be set. Sandboxes that could not be stopped are
+     * listed in `failed`.
+     */
+    async deleteSessions(options: DeleteSessionsOptions): Promise<DeleteResult> {
+        const labels = Object.entries(options.labels || {}).map(([k, v]) => (v ? `${k}=${v}` : k));
+        return this.transport.json<DeleteResult>('DELETE', '/sandbox', {
+            query: { label: labels, state: options.states, all: options.all ? 'true' : undefined },
+        });
+    }
+
+    /**
+     * Creates a session group: the sessions an agent uses for one
+     * conversation, e.g. a browser, code and database sandbox, which share
+     * a lifetime and are stopped together. Sessions join it when created
+     * with its ID as `group`.
+     */
+    async createGroup(options: CreateGroupOptions = {}): Promise<GroupInfo> {
+        return this.transport.json<GroupInfo>('POST', '/sessions', {
+            json: {
+                id: options.id,
+                ttl: options.ttlMs ? Math.ceil(options.ttlMs / 1000) : undefined,
+                metadata: options.metadata,
+            },
+        });
+    }
+
+    /** Describes a session group with its sandboxes. */
+    async getGroup(id: string): Promise<GroupInfo> {
+        return this.transport.json<GroupInfo>('GET', `/sessions/${encodeURIComponent(id)}`);
+    }
+
+    /** Lists the caller's session groups, oldest first, without their sandboxes. */
+    async listGroups(): Promise<GroupInfo[]> {
+        const data = await this.transport.json<{ sessions: GroupInfo[] }>('GET', '/sessions');
+        return data.sessions || [];
+    }
+
+    /**
+     * Sets the remaining lifetime of a session group and of its running
+     * sessions. Sessions that could not follow are listed in `failed`.
+     */
+    async setGroupTTL(id: string, ttlMs: number): Promise<GroupTTL> {
+        return this.transport.json<GroupTTL>('POST', `/sessions/${encodeURIComponent(id)}/ttl`, {
+            json: { ttl: Math.ceil(ttlMs / 1000) },
+        });
+    }
+
+    /**
+     * Ends a session group and stops its sessions. Sessions that could not
+     * be stopped are listed in `failed`.
+     */
+    async deleteGroup(id: string): Promise<DeleteResult> {
+        return this.transport.json<DeleteResult>('DELETE', `/sessions/${encodeURIComponent(id)}`);
+    }
+
+    /**
+     * Creates a base workspace that sandboxes can be created from. Fails
+     * with ErrorCode.Conflict if the name is taken.
+     */
+    async createWorkspace(options: CreateWorkspaceOptions): Promise<WorkspaceInfo> {
+        return this.transport.json<WorkspaceInfo>('POST', '/workspaces', { json: options });
+    }
+
+    /**
+     * Replaces the files of a workspace, creating it if needed. Fails with
+     * ErrorCode.Conflict while sandboxes use it.
+     */
+    async replaceWorkspace(options: CreateWorkspaceOptions): Promise<WorkspaceInfo> {
+        return this.transport.json<WorkspaceInfo>('PUT', `/workspaces/${encodeURIComponent(options.name)}`, { json: options });
+    }
+
+    async getWorkspace(name: string): Promise<WorkspaceInfo> {
+        return this.transport.json<WorkspaceInfo>('GET', `/workspaces/${encodeURIComponent(name)}`);
+    }
+
+    async listWorkspaces(): Promise<WorkspaceInfo[]> {
+        const data = await this.transport.json<{ workspaces: WorkspaceInfo[] }>('GET', '/workspaces');
+        return data.workspaces || [];
+    }
+
+    /**
+     * Deletes a workspace. Fails with ErrorCode.Conflict while sandboxes use it.
+     */
+    async deleteWorkspace(name: string): Promise<void> {
+        await this.transport.request('DELETE', `/workspaces/${encodeURIComponent(name)}`);
+    }
+
+    /**
+     * Creates an empty persistent volume that sessions can mount. Fails
+     * with ErrorCode.Conflict if the name is taken.
+     */
+    async createVolume(name: string): Promise<VolumeInfo> {
+        return this.transport.json<VolumeInfo>('POST', '/volumes', { json: { name } });
+    }
+
+    async getVolume(name: string): Promise<VolumeInfo> {
+        return this.transport.json<VolumeInfo>('GET', `/volumes/${encodeURIComponent(name)}`);
+    }
+
+    async listVolumes(): Promise<VolumeInfo[]> {
+        const data = await this.transport.json<{ volumes: VolumeInfo[] }>('GET', '/volumes');
+        return data.volumes || [];
+    }
+
+    /**
+     * Deletes a volume and its contents. Fails with ErrorCode.Conflict while
+     * sandboxes mount it.
+     */
+    async deleteVolume(name: string): Promise<void> {
+        await this.transport.request('DELETE', `/volumes/${encodeURIComponent(name)}`);
+    }
+
+    /** Returns a job of any session, with its result once it has run. */
+    async getJob(id: string): Promise<Job> {
+        return toJob(await this.transport.json<WireJob>('GET', `/jobs/${encodeURIComponent(id)}`), this.transport);
+    }
+
+    /** Polls a job until it has finished. */
+    async waitJob(id: string, intervalMs: number = 1000): Promise<Job> {
+        for (;;) {
+            const job = await this.getJob(id);
+            if (job.finishedAt) {
+                return job;
             }
-            throw error;
+            await new Promise(resolve => setTimeout(resolve, intervalMs));
         }
     }
 
     /**
-     * Lists all active sandbox sessions.
+     * Cancels a queued or running job. A running job's process may keep
+     * running in the sandbox.
      */
-    async listSessions(): Promise<Array<{ id: string; state: string; created_at: string; driver_type: string }>> {
-        try {
-            const response = await this.client.get<{ sandboxes: Array<any> }>('/v1/sandbox');
-            return response.data.sandboxes || [];
-        } catch (error: any) {
-            if (axios.isAxiosError(error) && error.response) {
-                throw new Error(`Failed to list sessions: ${error.response.status} ${JSON.stringify(error.response.data)}`);
+    async cancelJob(id: string): Promise<Job> {
+        return toJob(await this.transport.json<WireJob>('DELETE', `/jobs/${encodeURIComponent(id)}`), this.transport);
+    }
+
+    /**
+     * Registers a secret sessions and runs can be given, or replaces its
+     * value. Sessions keep the value they were given.
+     */
+    async setSecret(name: string, value: string): Promise<SecretInfo> {
+        return this.transport.json<SecretInfo>('PUT', `/secrets/${encodeURIComponent(name)}`, { json: { value } });
+    }
+
+    /** Lists the registered secrets, without their values. */
+    async listSecrets(): Promise<SecretInfo[]> {
+        const data = await this.transport.json<{ secrets: SecretInfo[] }>('GET', '/secrets');
+        return data.secrets || [];
+    }
+
+    async deleteSecret(name: string): Promise<void> {
+        await this.transport.request('DELETE', `/secrets/${encodeURIComponent(name)}`);
+    }
+
+    /**
+     * Adds or replaces the credential images are pulled from a private
+     * registry with. Needs the admin scope.
+     */
+    async setRegistryCredential(cred: RegistryCredential): Promise<RegistryCredential> {
+        const { registry, ...body } = cred;
+        return this.transport.json<RegistryCredential>('PUT', `/registries/${encodeURIComponent(registry)}`, { json: body });
+    }
+
+    /** Lists the registries the server has credentials for, without their passwords. */
+    async listRegistryCredentials(): Promise<RegistryCredential[]> {
+        const data = await this.transport.json<{ registries: RegistryCredential[] }>('GET', '/registries');
+        return data.registries || [];
+    }
+
+    async deleteRegistryCredential(registry: string): Promise<void> {
+        await this.transport.request('DELETE', `/registries/${encodeURIComponent(registry)}`);
+    }
+
+    /** Lists the templates sessions can be created from, sorted by name. */
+    async listTemplates(): Promise<TemplateInfo[]> {
+        const data = await this.transport.json<{ templates: TemplateInfo[] }>('GET', '/templates');
+        return data.templates || [];
+    }
+
+    /**
+     * Builds an image from a Dockerfile on the server and adds it as a
+     * template, e.g. 'agent-env:v1', which later sessions can be created
+     * from. A failing step throws a BoxedError with code setup_failed.
+     * Building a name again replaces it.
+     */
+    async buildImage(options: BuildImageOptions): Promise<BuildImageResult> {
+        const formData = new FormData();
+        const { context, build_args, ...fields } = options;
+        for (const [key, value] of Object.entries(fields)) {
+            if (value !== undefined) {
+                formData.append(key, String(value));
             }
-            throw error;
+        }
+        for (const [key, value] of Object.entries(build_args || {})) {
+            formData.append('build_arg', `${key}=${value}`);
+        }
+        if (context) {
+            formData.append('context', context instanceof Blob ? context : new Blob([context as any]), 'context.tar');
+        }
+        return this.transport.json<BuildImageResult>('POST', '/images/build', { body: formData });
+    }
+
+    /**
+     * Lists the control-plane nodes sharing the server's state, sorted by
+     * ID. A server running alone returns none.
+     */
+    async clusterNodes(): Promise<NodeInfo[]> {
+        const data = await this.transport.json<{ nodes: NodeInfo[] }>('GET', '/cluster/nodes');
+        return data.nodes || [];
+    }
+
+    /**
+     * Reports the CPU time, memory and lifetime sandboxes consumed within a
+     * period, by group, for charging them back. Usage of sandboxes running
+     * across the period's bounds is prorated.
+     */
+    async usage(options: UsageOptions = {}): Promise<UsageReport> {
+        return this.transport.json<UsageReport>('GET', '/usage', { query: usageQuery(options) });
+    }
+
+    /** Returns the report usage() returns as a CSV file. */
+    async usageCSV(options: UsageOptions = {}): Promise<string> {
+        const res = await this.transport.request('GET', '/usage', { query: { ...usageQuery(options), format: 'csv' } });
+        return res.text();
+    }
+
+    /**
+     * Yields sandbox lifecycle events as they happen, including
+     * `ttl_warning` shortly before a sandbox expires, so that work can be
+     * saved before it is reaped. Break out of the loop to stop.
+     */
+    async *events(options: EventOptions = {}): AsyncGenerator<LifecycleEvent> {
+        const labels = Object.entries(options.labels || {}).map(([k, v]) => (v ? `${k}=${v}` : k));
+        const res = await this.transport.request('GET', '/events', {
+            query: { label: labels, type: options.types },
+        });
+        yield* serverSentEvents<LifecycleEvent>(res);
+    }
+}
+
+function usageQuery(options: UsageOptions): Record<string, string | undefined> {
+    const time = (t?: Date | string) => (t instanceof Date ? t.toISOString() : t);
+    return { from: time(options.from), to: time(options.to), group_by: options.groupBy };
+}
+
+/**
+ * Yields the data of the server-sent events of res. An "error" event is
+ * thrown as a BoxedError.
+ */
+async function* serverSentEvents<T>(res: Response): AsyncGenerator<T> {
+    if (!res.body) {
+        return;
+    }
+    const reader = res.body.getReader();
+    const decoder = new TextDecoder();
+    let buffered = '';
+    try {
+        while (true) {
+            const { done, value } = await reader.read();
+            buffered += decoder.decode(value, { stream: !done });
+            let end: number;
+            // Events are separated by a blank line
+            while ((end = buffered.indexOf('\n\n')) >= 0) {
+                const lines = buffered.slice(0, end).split('\n');
+                buffered = buffered.slice(end + 2);
+                const event = lines.find((l) => l.startsWith('event: '))?.slice(7);
+                const data = lines.find((l) => l.startsWith('data: '))?.slice(6);
+                if (!data) {
+                    continue;
+                }
+                if (event === 'error') {
+                    const body = JSON.parse(data);
+                    throw new BoxedError(body.error, res.status, body.code);
+                }
+                yield JSON.parse(data) as T;
+            }
+            if (done) {
+                return;
+            }
+        }
+    } finally {
+        reader.cancel().catch(() => undefined);
+    }
+}
+
+function runOptions(codeOrOptions: string | RunOptions): RunOptions {
+    return typeof codeOrOptions === 'string' ? { code: codeOrOptions } : codeOrOptions;
+}
+
+function wireRunOptions(options: RunOptions) {
+    return {
+        code: options.code,
+        language: options.language || (options.file ? undefined : 'python'),
+        file: options.file,
+        args: options.args,
+        spill_output: options.spillOutput || undefined,
+        artifacts: wireArtifactOptions(options.artifacts),
+        cache: options.cache || undefined,
+        cwd: options.cwd,
+        user: options.user,
+        env: options.env,
+        secrets: options.secrets,
+        if_busy: options.ifBusy,
+    };
+}
+
+function toExecutionResult(data: WireExecResult, sandboxId: string, transport: Transport): ExecutionResult {
+    return {
+        stdout: data.stdout || '',
+        stderr: data.stderr || '',
+        artifacts: (data.artifacts || []).map(a => toArtifact(a, sandboxId, transport)),
+        exitCode: data.exit_code ?? -1,
+        truncated: data.truncated,
+        stdoutBytes: data.stdout_bytes,
+        stderrBytes: data.stderr_bytes,
+        cached: data.cached || false,
+        queuePosition: data.queue_position,
+        queuedMs: data.queued_ms,
+        exitReason: data.exit_reason,
+        signal: data.signal,
+        errorKind: data.error_kind,
+        errorMessage: data.error_message,
+    };
+}
+
+function toJob(data: WireJob, transport: Transport): Job {
+    return {
+        id: data.id,
+        sessionId: data.sandbox_id,
+        status: data.status,
+        result: data.result ? toExecutionResult(data.result, data.sandbox_id, transport) : undefined,
+        error: data.error,
+        errorCode: data.error_code,
+        createdAt: new Date(data.created_at),
+        startedAt: data.started_at ? new Date(data.started_at) : undefined,
+        finishedAt: data.finished_at ? new Date(data.finished_at) : undefined,
+    };
+}
+
+function wireArtifactOptions(o?: ArtifactOptions) {
+    if (!o) {
+        return undefined;
+    }
+    return { watch_paths: o.watchPaths, max_size: o.maxSize, mime_types: o.mimeTypes, delivery: o.delivery };
+}
+
+// toArtifact maps an artifact from an exec result or agent event. Events
+// of URL-delivered artifacts carry neither data nor URL: they link to the file.
+function toArtifact(a: WireArtifact, sandboxId: string, transport: Transport): Artifact {
+    let url: string;
+    if (a.data_base64) {
+        url = `data:${a.mime};base64,${a.data_base64}`;
+    } else if (a.url) {
+        url = transport.resolve(a.url);
+    } else {
+        const path = a.path.startsWith('/') ? a.path : `/output/${a.path}`;
+        url = transport.url(`/sandbox/${encodeURIComponent(sandboxId)}/files/content`, { path });
+    }
+    return { type: a.mime, path: a.path, url, size: a.size, sha256: a.sha256 };
+}
+
+// ArtifactStreams reassembles the artifacts the interact endpoint streams
+// as binary frames: each starts with the stream ID, the frame's index and
+// the CRC-32 of its payload, as big-endian uint32s.
+class ArtifactStreams {
+    private streams = new Map<number, { artifact: WireArtifact; frames: Uint8Array[]; left: number }>();
+
+    /** Starts the stream announced by an artifact notification. */
+    begin(params: any) {
+        const { stream, frames, ...artifact } = params;
+        this.streams.set(stream, { artifact, frames: new Array(frames), left: frames });
+    }
+
+    /** Adds a frame, returning the artifact it completes, if any. */
+    frame(data: ArrayBuffer | Uint8Array): WireArtifact | undefined {
+        const bytes = data instanceof Uint8Array ? data : new Uint8Array(data);
+        const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+        const s = this.streams.get(view.getUint32(0));
+        if (!s) {
+            return undefined;
+        }
+        const payload = bytes.subarray(12);
+        if (crc32(payload) !== view.getUint32(8)) {
+            throw new BoxedError(`corrupt frame of artifact ${s.artifact.path}`, 0, ErrorCode.Internal);
+        }
+        s.frames[view.getUint32(4)] = payload;
+        if (--s.left > 0) {
+            return undefined;
+        }
+        this.streams.delete(view.getUint32(0));
+        const whole = new Uint8Array(s.frames.reduce((n, f) => n + f.length, 0));
+        let offset = 0;
+        for (const f of s.frames) {
+            whole.set(f, offset);
+            offset += f.length;
+        }
+        return { ...s.artifact, data_base64: toBase64(whole) };
+    }
+
+    /** The streams still waiting for frames. */
+    pending(): number {
+        return this.streams.size;
+    }
+}
+
+let crcTable: Uint32Array | undefined;
+
+// crc32 is the CRC-32 (IEEE) of data.
+function crc32(data: Uint8Array): number {
+    if (!crcTable) {
+        crcTable = new Uint32Array(256);
+        for (let i = 0; i < 256; i++) {
+            let c = i;
+            for (let k = 0; k < 8; k++) {
+                c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+            }
+            crcTable[i] = c >>> 0;
+        }
+    }
+    let crc = 0xffffffff;
+    for (const b of data) {
+        crc = crcTable[(crc ^ b) & 0xff] ^ (crc >>> 8);
+    }
+    return (crc ^ 0xffffffff) >>> 0;
+}
+
+// sha256Hex is the hex SHA-256 digest of blob, or '' where Web Crypto is
+// missing (Node 18 without the global), in which case nothing is checked.
+async function sha256Hex(blob: Blob): Promise<string> {
+    const subtle = globalThis.crypto?.subtle;
+    if (!subtle) {
+        return '';
+    }
+    const digest = new Uint8Array(await subtle.digest('SHA-256', await blob.arrayBuffer()));
+    return Array.from(digest, (b) => b.toString(16).padStart(2, '0')).join('');
+}
+
+function toBase64(data: Uint8Array): string {
+    if (typeof Buffer !== 'undefined') {
+        return Buffer.from(data).toString('base64');
+    }
+    let binary = '';
+    for (let i = 0; i < data.length; i += 0x8000) {
+        binary += String.fromCharCode(...data.subarray(i, i + 0x8000));
+    }
+    return btoa(binary);
+}
+
+function parseMessage(data: any): any {
+    try {
+        return JSON.parse(data.toString());
+    } catch {
+        return undefined;
+    }
+}
+
+// EventQueue hands events pushed by socket callbacks to an async iterator.
+class EventQueue<T> implements AsyncIterable<T> {
+    private items: T[] = [];
+    private done = false;
+    private error?: Error;
+    private wake?: () => void;
+
+    push(item: T) {
+        if (!this.done) {
+            this.items.push(item);
+            this.notify();
+        }
+    }
+
+    end() {
+        this.done = true;
+        this.notify();
+    }
+
+    fail(err: Error) {
+        if (!this.done) {
+            this.error = err;
+            this.end();
+        }
+    }
+
+    private notify() {
+        const wake = this.wake;
+        this.wake = undefined;
+        wake?.();
+    }
+
+    async *[Symbol.asyncIterator](): AsyncGenerator<T> {
+        for (;;) {
+            const item = this.items.shift();
+            if (item !== undefined) {
+                yield item;
+                continue;
+            }
+            if (this.error) {
+                throw this.error;
+            }
+            if (this.done) {
+                return;
+            }
+            await new Promise<void>(resolve => (this.wake = resolve));
         }
     }
 }
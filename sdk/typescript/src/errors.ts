@@ -0,0 +1,84 @@
+/** Stable error codes the server returns in the "code" field. */
+export const ErrorCode = {
+    InvalidRequest: 'invalid_request',
+    Unauthorized: 'unauthorized',
+    Forbidden: 'forbidden',
+    NotFound: 'not_found',
+    SandboxNotFound: 'sandbox_not_found',
+    SandboxNotRunning: 'sandbox_not_running',
+    Conflict: 'conflict',
+    SandboxBusy: 'sandbox_busy',
+    QuotaExceeded: 'quota_exceeded',
+    TimedOut: 'timed_out',
+    Canceled: 'canceled',
+    NotImplemented: 'not_implemented',
+    Unavailable: 'unavailable',
+    SetupFailed: 'setup_failed',
+    Internal: 'internal',
+} as const;
+
+/** Error returned by the server, or raised while talking to it. */
+export class BoxedError extends Error {
+    /** HTTP status, 0 if no response was received */
+    readonly status: number;
+    /** One of ErrorCode; derived from the status for older servers */
+    readonly code: string;
+    /** Set when the error concerns a sandbox that was created and torn down */
+    readonly sandboxId?: string;
+    /** Where an exec refused for want of a slot, of the server or of its
+     * sandbox, was in the queue, from 1 */
+    queuePosition?: number;
+    /** Seconds the server suggests waiting before retrying */
+    retryAfter?: number;
+
+    constructor(message: string, status: number, code: string, sandboxId?: string) {
+        super(message);
+        this.name = 'BoxedError';
+        this.status = status;
+        this.code = code;
+        this.sandboxId = sandboxId;
+    }
+}
+
+// codeForStatus infers a code for servers that don't send one. Every sandbox
+// route answers 404 only for unknown sandboxes.
+function codeForStatus(status: number): string {
+    switch (status) {
+        case 400: return ErrorCode.InvalidRequest;
+        case 401:
+        case 403: return ErrorCode.Unauthorized;
+        case 404: return ErrorCode.SandboxNotFound;
+        case 408:
+        case 504: return ErrorCode.TimedOut;
+        case 429: return ErrorCode.QuotaExceeded;
+        case 501: return ErrorCode.NotImplemented;
+        case 503: return ErrorCode.Unavailable;
+        default: return ErrorCode.Internal;
+    }
+}
+
+/** Builds the error for a failed response from its body. */
+export async function errorFromResponse(res: Response): Promise<BoxedError> {
+    const text = await res.text();
+    let body: {
+        code?: string;
+        error?: string;
+        message?: string;
+        sandbox_id?: string;
+        queue_position?: number;
+        retry_after?: number;
+    } = {};
+    try {
+        body = JSON.parse(text);
+    } catch {
+        // Not JSON: keep the raw text as the message
+    }
+    const message = body.error || body.message || text || res.statusText;
+    const err = new BoxedError(message, res.status, body.code || codeForStatus(res.status), body.sandbox_id);
+    err.queuePosition = body.queue_position;
+    const retryAfter = body.retry_after ?? Number(res.headers.get('Retry-After'));
+    if (retryAfter > 0) {
+        err.retryAfter = retryAfter;
+    }
+    return err;
+}
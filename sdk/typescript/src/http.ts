@@ -0,0 +1,126 @@
+import { BoxedError, ErrorCode, errorFromResponse } from './errors';
+
+/**
+ * The WebSocket surface the SDK uses, shared by the standard WebSocket
+ * (browsers, Node 22+) and the "ws" package.
+ */
+export interface SocketLike {
+    onopen: ((ev: any) => void) | null;
+    onmessage: ((ev: { data: any }) => void) | null;
+    onerror: ((ev: any) => void) | null;
+    onclose: ((ev: any) => void) | null;
+    /** Set to 'arraybuffer' to receive binary messages as ArrayBuffers */
+    binaryType?: string;
+    send(data: string): void;
+    close(): void;
+}
+
+export type SocketConstructor = new (url: string) => SocketLike;
+
+export type Query = Record<string, string | string[] | undefined>;
+
+export interface RequestOptions {
+    query?: Query;
+    /** Sent as a JSON body */
+    json?: unknown;
+    /** Sent as is (e.g. FormData) */
+    body?: BodyInit;
+    headers?: Record<string, string>;
+}
+
+/** Transport sends requests to the v1 API of one server. */
+export class Transport {
+    readonly baseUrl: string;
+    private readonly apiKey?: string;
+    private readonly token?: string;
+    private readonly fetchImpl: typeof fetch;
+    private readonly socketImpl?: SocketConstructor;
+
+    constructor(baseUrl: string, apiKey?: string, token?: string, fetchImpl?: typeof fetch, socketImpl?: SocketConstructor) {
+        this.baseUrl = baseUrl.replace(/\/+$/, '');
+        this.apiKey = apiKey;
+        this.token = token;
+        this.fetchImpl = fetchImpl || ((input, init) => fetch(input, init));
+        this.socketImpl = socketImpl;
+    }
+
+    /** Absolute URL of an API path, e.g. "/sandbox/abc". */
+    url(path: string, query?: Query): string {
+        const params = new URLSearchParams();
+        for (const [key, value] of Object.entries(query || {})) {
+            for (const v of Array.isArray(value) ? value : [value]) {
+                if (v !== undefined) {
+                    params.append(key, v);
+                }
+            }
+        }
+        const qs = params.toString();
+        return `${this.baseUrl}/v1${path}${qs ? '?' + qs : ''}`;
+    }
+
+    /** Absolute URL for a path the server returned, e.g. "/v1/artifacts/...". */
+    resolve(serverPath: string): string {
+        return /^[a-z]+:/i.test(serverPath) ? serverPath : this.baseUrl + serverPath;
+    }
+
+    /** Sends a request and returns the response; non-2xx statuses throw BoxedError. */
+    async request(method: string, path: string, options: RequestOptions = {}): Promise<Response> {
+        const headers: Record<string, string> = { ...options.headers };
+        if (this.apiKey) {
+            headers['X-Boxed-API-Key'] = this.apiKey;
+        }
+        if (this.token) {
+            headers['Authorization'] = `Bearer ${this.token}`;
+        }
+        let body = options.body;
+        if (options.json !== undefined) {
+            headers['Content-Type'] = 'application/json';
+            body = JSON.stringify(options.json);
+        }
+
+        let res: Response;
+        try {
+            res = await this.fetchImpl(this.url(path, options.query), { method, headers, body });
+        } catch (err: any) {
+            throw new BoxedError(`${method} ${path}: ${err?.message || err}`, 0, ErrorCode.Unavailable);
+        }
+        if (!res.ok) {
+            throw await errorFromResponse(res);
+        }
+        return res;
+    }
+
+    async json<T>(method: string, path: string, options: RequestOptions = {}): Promise<T> {
+        const res = await this.request(method, path, options);
+        if (res.status === 204) {
+            return undefined as T;
+        }
+        return (await res.json()) as T;
+    }
+
+    /** Opens a WebSocket to an API path and resolves once it is open. */
+    socket(path: string, query: Query = {}): Promise<SocketLike> {
+        // Browsers cannot set headers on a WebSocket: credentials go in the URL
+        const url = this.url(path, { ...query, api_key: this.apiKey, access_token: this.token }).replace(/^http/, 'ws');
+        const ws = new (this.socketImpl || defaultSocket())(url);
+        return new Promise((resolve, reject) => {
+            ws.onopen = () => {
+                ws.onopen = null;
+                ws.onerror = null;
+                resolve(ws);
+            };
+            ws.onerror = (ev: any) => {
+                reject(new BoxedError(`websocket ${path}: ${ev?.message || 'connection failed'}`, 0, ErrorCode.Unavailable));
+            };
+        });
+    }
+}
+
+function defaultSocket(): SocketConstructor {
+    const global = (globalThis as any).WebSocket;
+    if (global) {
+        return global;
+    }
+    // Node before 22 has no WebSocket global
+    return require('ws');
+}
@@ -1 +1,4 @@
 export * from './boxed';
+export * from './errors';
+export * from './types';
+export type { SocketConstructor, SocketLike } from './http';
@@ -0,0 +1,517 @@
+// Records returned by the server keep its snake_case field names; see
+// docs/api.md for their meaning.
+
+export interface Sidecar {
+    name: string;
+    cmd: string[];
+    env?: Record<string, string>;
+    health_check?: { cmd: string[]; interval_ms?: number; retries?: number };
+}
+
+export interface FileInjection {
+    path: string;
+    content_base64: string;
+}
+
+/** A repository the server clones into a new sandbox's working directory */
+export interface GitSource {
+    /** http(s) URL of the repository */
+    url: string;
+    /** Branch, tag or commit (default: the default branch) */
+    ref?: string;
+    /** Token for private repositories */
+    token?: string;
+}
+
+/** Internet access, through the server's egress proxy; servers without one give sandboxes none */
+export interface NetworkPolicy {
+    enable_internet: boolean;
+    /** Hosts the sandbox may reach; `*.example.com` matches subdomains too */
+    allow_domains?: string[];
+}
+
+export interface SidecarStatus {
+    name: string;
+    running: boolean;
+    exit_code?: number;
+}
+
+export interface SandboxConfig {
+    image: string;
+    memory_mb: number;
+    cpu_cores: number;
+    work_dir?: string;
+    labels?: Record<string, string>;
+    workspace?: string;
+    volumes?: VolumeMount[];
+    gpu?: GPURequest;
+    network_group?: string;
+    network_alias?: string;
+    dns?: string[];
+    dns_search?: string[];
+    dns_options?: string[];
+    extra_hosts?: Record<string, string>;
+    security?: Security;
+}
+
+/** Docker hardening of a sandbox; see CreateSessionOptions.security. */
+export interface Security {
+    /** Mount the image read-only; the working directory, /tmp, /output and tmpfs stay writable */
+    read_only_rootfs?: boolean;
+    tmpfs?: string[];
+    /** Capabilities without the CAP_ prefix; 'ALL' drops them all */
+    cap_drop?: string[];
+    /** Only Docker's default capabilities can be added back */
+    cap_add?: string[];
+    no_new_privileges?: boolean;
+    /** Name of a profile in the server's seccomp directory, or the built-in 'default' or 'strict' (no networking); replaces the template's */
+    seccomp_profile?: string;
+    pids_limit?: number;
+}
+
+export interface SandboxInfo {
+    id: string;
+    /** The backend's own ID, e.g. the Docker container ID */
+    backend_id?: string;
+    state: string;
+    created_at: string;
+    driver_type: string;
+    sidecars?: SidecarStatus[];
+    expires_at?: string;
+    config: SandboxConfig;
+    error?: string;
+    /** 'oom_killed' or 'sandbox_died' if the sandbox stopped on its own */
+    exit_reason?: string;
+    /** Platform of the image, e.g. 'linux/amd64' */
+    platform?: string;
+    /** Whether the image runs under emulation, not being built for the host */
+    emulated?: boolean;
+    /** How many agents in the sandbox crashed or stopped responding, and
+     * were started again */
+    agent_crashes?: number;
+    /** GPUs passed through to the sandbox */
+    gpus?: GPU[];
+    /** Seccomp profile the sandbox runs under, e.g. 'default' or 'strict'; unset on drivers that do not filter syscalls */
+    seccomp?: string;
+    /** Step of its lifecycle the sandbox is in */
+    phase?: SandboxPhase;
+    /** When the sandbox entered each phase since its create began, which tells where a slow create spent its time */
+    phases?: PhaseTransition[];
+}
+
+export type SandboxPhase =
+    | 'creating'
+    | 'pulling-image'
+    | 'injecting-context'
+    | 'starting'
+    | 'agent-waiting'
+    | 'initializing'
+    | 'ready'
+    | 'stopping';
+
+/** The moment a sandbox entered a phase, which lasted until the next transition. */
+export interface PhaseTransition {
+    phase: SandboxPhase;
+    at: string;
+}
+
+/** Asks for GPUs of the server's host; see CreateSessionOptions.gpu. */
+export interface GPURequest {
+    count: number;
+    /** Model the GPUs must be, matched case-insensitively against part of their name, e.g. 'a100' */
+    type?: string;
+}
+
+export interface GPU {
+    /** Device index or UUID */
+    id: string;
+    /** Model name, e.g. 'NVIDIA A100-SXM4-40GB' */
+    type?: string;
+}
+
+/** A sample of a sandbox's resource usage; see Session.stats. Drivers
+ * leave what they cannot measure zero, or unset for the CPU fields. */
+export interface ResourceStats {
+    time: string;
+    /** CPU time used since the sandbox started */
+    cpu_ns?: number;
+    /** CPU used since the previous streamed sample, 100 per fully used core */
+    cpu_percent?: number;
+    memory_bytes: number;
+    memory_limit_bytes?: number;
+    pids?: number;
+    disk_bytes?: number;
+    disk_read_bytes?: number;
+    disk_write_bytes?: number;
+}
+
+/** The outcome of Boxed.deleteSessions. */
+export interface DeleteResult {
+    stopped: string[];
+    failed: { id: string; error: string }[];
+}
+
+/** A session group: sandboxes sharing a lifetime, stopped together. */
+export interface GroupInfo {
+    id: string;
+    metadata?: Record<string, string>;
+    created_at: string;
+    expires_at: string;
+    /** Sums up the states of the sandboxes */
+    status: 'empty' | 'creating' | 'ready' | 'degraded' | 'stopped';
+    /** Number of sandboxes in each state */
+    states: Record<string, number>;
+    /** Only set by getGroup */
+    sandboxes?: SandboxInfo[];
+}
+
+/** The new expiry of a session group, and the sandboxes that could not follow it. */
+export interface GroupTTL {
+    id: string;
+    expires_at: string;
+    failed: { id: string; error: string }[];
+}
+
+/** Injects a registered secret: as the environment variable env, which
+ * defaults to the secret's name unless path is set, as the file at path,
+ * or both. */
+export interface SecretRef {
+    name: string;
+    env?: string;
+    path?: string;
+}
+
+/** Packages installed before a sandbox is ready, per package manager. */
+export interface Packages {
+    pip?: string[];
+    npm?: string[];
+    apt?: string[];
+}
+
+/** An installation command run while creating a sandbox. */
+export interface SetupStep {
+    manager: 'apt' | 'pip' | 'npm';
+    command: string;
+    exit_code: number | null;
+    stdout: string;
+    stderr: string;
+    duration_ms: number;
+}
+
+/** Reports the installation of CreateSessionOptions.packages. */
+export interface SetupResult {
+    /** Image with the packages installed, which the sandbox runs from */
+    image: string;
+    /** True if the image existed, so nothing was installed */
+    cached: boolean;
+    steps?: SetupStep[];
+}
+
+/** The injection of the context files of a create. */
+export interface ContextReport {
+    files: number;
+    /** Decoded size of the files */
+    bytes: number;
+    /** How long the server took to create the sandbox, injection included */
+    duration_ms: number;
+}
+
+/** A registered secret; its value is never returned. */
+export interface SecretInfo {
+    name: string;
+    created_at: string;
+    updated_at: string;
+}
+
+/**
+ * The credential images are pulled from a private registry with: a
+ * username and password, or the Docker credential helper
+ * docker-credential-<helper>. Passwords are never returned.
+ */
+export interface RegistryCredential {
+    registry: string;
+    username?: string;
+    password?: string;
+    helper?: string;
+}
+
+/** A control-plane node sharing the server's state. */
+export interface NodeInfo {
+    id: string;
+    url: string;
+    started_at: string;
+    heartbeat_at: string;
+    /** False once the node missed heartbeats for the node timeout */
+    alive: boolean;
+    /** True for the node that answered */
+    self: boolean;
+    /** Live sandboxes the node serves */
+    sandboxes: number;
+}
+
+/** A template of the server's catalog. */
+export interface TemplateInfo {
+    name: string;
+    image: string;
+    memory_mb: number;
+    cpu_cores: number;
+    /**
+     * Lets sessions run above cpu_cores for short stretches: the limit is
+     * enforced over periods of period_ms rather than the kernel's 100 ms,
+     * and shares weighs them against others (1024) when CPUs are contended
+     */
+    cpu_burst?: { period_ms?: number; shares?: number };
+    /** Lifetime in seconds of sessions created without one, if set */
+    timeout?: number;
+    /** Script run in each session before it is ready, if the template has one */
+    init?: string;
+    /** Limit of the init script in seconds */
+    init_timeout?: number;
+    /** Backend the template's sessions run on, if it names one */
+    driver?: string;
+    /** Seccomp profile of the template's sessions, if it sets one */
+    seccomp?: string;
+    /** True for the template of sessions created without one */
+    default?: boolean;
+    /** True for templates saved from a session with publishTemplate */
+    published?: boolean;
+}
+
+/** Names the template a session is saved as, `<name>:<tag>`. */
+export interface PublishTemplateOptions {
+    /** Lowercase letters and digits, separated by '.', '_' or '-' */
+    name: string;
+    /** Default: 'latest' */
+    tag?: string;
+    /** Script run in each session of the template before it is ready, such as to start services again */
+    init?: string;
+    /** Limit of the init script in seconds */
+    init_timeout?: number;
+}
+
+export interface BuildImageOptions {
+    /** Lowercase letters and digits, separated by '.', '_' or '-' */
+    name: string;
+    /** Default: 'latest' */
+    tag?: string;
+    /** Script run in each session of the template before it is ready */
+    init?: string;
+    /** Limit of the init script in seconds */
+    init_timeout?: number;
+    /** The template's resources; the server's defaults if unset */
+    memory_mb?: number;
+    cpu_cores?: number;
+    /** The Dockerfile; if unset, the one at the root of context is built */
+    dockerfile?: string;
+    /** The build context, a tar archive, optionally gzipped */
+    context?: Buffer | Blob;
+    /** Values of the Dockerfile's ARGs */
+    build_args?: Record<string, string>;
+}
+
+export interface BuildImageResult {
+    template: TemplateInfo;
+    /** The build output, its last 64 KiB if longer */
+    log: string;
+}
+
+/** Memory and CPU limits of a sandbox. */
+export interface Resources {
+    /** Memory limit in MB */
+    memory_mb?: number;
+    /** CPU limit in fractional cores */
+    cpu_cores?: number;
+}
+
+/** A path a sandbox added, changed or removed since it was created. */
+export interface FSChange {
+    path: string;
+    kind: 'added' | 'changed' | 'removed';
+}
+
+/** The space the files under a directory of a sandbox take. */
+export interface DirUsage {
+    path: string;
+    used_bytes: number;
+    /** Number of regular files */
+    files: number;
+    /** Size of the directory's filesystem, when it is the sandbox's own (e.g. the /output tmpfs) */
+    limit_bytes?: number;
+    /** What is left of limit_bytes */
+    available_bytes?: number;
+}
+
+/** The space the files of a sandbox take under the directories asked for. */
+export interface FilesUsage {
+    sandbox_id: string;
+    /** Sum of the directories' usage */
+    used_bytes: number;
+    paths: DirUsage[];
+}
+
+/** What sandboxes consumed within a period. */
+export interface UsageTotals {
+    sandboxes: number;
+    /** CPU time consumed, where the driver reports it (0 on Wasm) */
+    cpu_seconds: number;
+    /** Memory allocated times how long it was */
+    memory_mb_hours: number;
+    /** How long the sandboxes existed */
+    wall_seconds: number;
+}
+
+/** The usage of the sandboxes sharing a key, image, label value or ID. */
+export interface UsageGroup extends UsageTotals {
+    group: string;
+}
+
+/** Resource usage of a period by group, returned by Boxed.usage(). */
+export interface UsageReport {
+    from: string;
+    to: string;
+    group_by: string;
+    groups: UsageGroup[];
+    total: UsageTotals;
+}
+
+export type LifecycleEventType = 'created' | 'ready' | 'ttl_warning' | 'stopped' | 'errored' | 'hibernated';
+
+/** A sandbox state transition streamed by Boxed.events(). */
+export interface LifecycleEvent {
+    type: LifecycleEventType;
+    sandbox_id: string;
+    at: string;
+    labels?: Record<string, string>;
+    /** When the TTL reaps the sandbox; on ready and ttl_warning events */
+    expires_at?: string;
+    /** Why the sandbox stopped, e.g. "api" or "ttl_expired" */
+    reason?: string;
+    /** What went wrong, on errored events */
+    error?: string;
+}
+
+export interface WorkspaceInfo {
+    name: string;
+    created_at: string;
+    /** -1 if the server cannot tell */
+    size_bytes: number;
+    /** Live sandboxes using the workspace */
+    in_use: number;
+}
+
+/** Mounts a persistent volume into a sandbox. */
+export interface VolumeMount {
+    name: string;
+    /** Absolute directory the sandbox sees the volume at */
+    mount_path: string;
+    read_only?: boolean;
+}
+
+export interface VolumeInfo {
+    name: string;
+    created_at: string;
+    /** -1 if the server cannot tell */
+    size_bytes: number;
+    /** Live sandboxes mounting the volume */
+    in_use: number;
+}
+
+/** A chunked upload in progress; see Session.uploadLargeFile. */
+export interface UploadInfo {
+    id: string;
+    sandbox_id: string;
+    path: string;
+    /** Declared total size, -1 if not given */
+    size: number;
+    /** Bytes the server has received */
+    offset: number;
+}
+
+export interface ExecRecord {
+    seq: number;
+    language: string;
+    code: string;
+    file?: string;
+    args?: string[];
+    exit_code: number | null;
+    started_at: string;
+    duration_ms: number;
+    /** The part of duration_ms the exec waited for other execs of the sandbox */
+    queued_ms?: number;
+    stdout: string;
+    stderr: string;
+    truncated: boolean;
+    error?: string;
+    cached?: boolean;
+    exit_reason?: string;
+    error_kind?: ExecErrorKind;
+}
+
+/** Why an exec failed, when it failed for a reason other than the code's
+ * own. Shell execs exiting 127 or 126 report 'command_not_found' or
+ * 'permission_denied'. */
+export type ExecErrorKind =
+    | 'command_not_found'
+    | 'permission_denied'
+    | 'cwd_not_found'
+    | 'user_not_found'
+    | 'timed_out'
+    | 'oom_killed'
+    | 'sandbox_died'
+    | 'agent_crashed'
+    | 'internal';
+
+export interface TimelineEvent {
+    type: string;
+    at: string;
+    offset_ms: number;
+    duration_ms: number;
+    detail?: string;
+    error?: string;
+}
+
+/** One line of a sandbox log; see Session.logs. */
+export interface LogLine {
+    time: string;
+    /** "agent" or "process" */
+    source: string;
+    /** ID of the agent process that wrote the line, if known */
+    exec?: string;
+    text: string;
+}
+
+export interface Descriptor {
+    sandbox_id: string;
+    driver: string;
+    image: string;
+    os?: string;
+    interpreters: Array<{ languages: string[]; command: string; version?: string }>;
+    packages: Array<{ name: string; version: string; manager: string }>;
+    limits: { cpu_cores: number; memory_mb: number; max_output_bytes: number; expires_at?: string };
+    network: NetworkPolicy;
+    work_dir: string;
+    writable_paths: string[];
+    generated_at: string;
+}
+
+export interface FileEntry {
+    name: string;
+    path: string;
+    size: number;
+    mode: number;
+    is_dir: boolean;
+    last_modified: string;
+}
+
+/** Controls which files an exec returns as artifacts. */
+export interface ArtifactOptions {
+    /** Absolute directories watched instead of /output */
+    watchPaths?: string[];
+    /** Skip larger files, in bytes */
+    maxSize?: number;
+    /** Keep only matching files: "image/png" or "image/*" */
+    mimeTypes?: string[];
+    /** "inline" (default) returns the data, "url" a download link;
+     * "manifest" only lists the files, which the result's urls download */
+    delivery?: 'inline' | 'url' | 'manifest';
+}
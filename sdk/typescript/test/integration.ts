@@ -1,4 +1,4 @@
-import { Boxed } from '../src';
+import { Boxed, BoxedError, ErrorCode, ExecEvent } from '../src';
 import assert from 'assert';
 
 async function main() {
@@ -32,6 +32,28 @@ print("Stderr Output", file=sys.stderr)
     assert.strictEqual(result.exitCode, 0, "Exit code should be 0");
     console.log("✅ Execution verified");
 
+    // Streaming
+    console.log("Streaming code...");
+    const events: ExecEvent[] = [];
+    for await (const ev of session.stream('print("one")\nprint("two")')) {
+        events.push(ev);
+    }
+    const streamed = events.map(e => (e.type === 'stdout' ? e.chunk : '')).join('');
+    assert.strictEqual(streamed, "one\ntwo\n", "Streamed stdout matches");
+    assert.deepStrictEqual(events[events.length - 1], { type: 'exit', code: 0 }, "Stream ends with exit");
+    console.log("✅ Streaming verified");
+
+    // Sandbox info and errors
+    const info = await session.info();
+    assert.strictEqual(info.state, "ready", "Sandbox is ready");
+    try {
+        await client.session("does-not-exist").info();
+        assert.fail("Unknown sandbox should fail");
+    } catch (err) {
+        assert.ok(err instanceof BoxedError && err.code === ErrorCode.SandboxNotFound, "Unknown sandbox is sandbox_not_found");
+    }
+    console.log("✅ Info and errors verified");
+
     // 4. File System
     console.log("Testing File System...");
 
@@ -0,0 +1,425 @@
+// Package bench measures a running Boxed server end to end: how long
+// sandboxes take to create, execs to round-trip and files to move, and how
+// exec throughput scales with concurrency. "boxed bench" runs it; the
+// report is meant to be kept as JSON and compared across pool and driver
+// changes.
+package bench
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+)
+
+// Defaults of Config.
+const (
+	DefaultIterations = 10
+	DefaultFileSize   = 8 << 20
+	DefaultLanguage   = "bash"
+	DefaultCode       = "echo boxed"
+)
+
+// DefaultConcurrency are the worker counts exec throughput is measured at.
+var DefaultConcurrency = []int{1, 2, 4, 8}
+
+// Config says what to measure and how often. Zero values mean the
+// defaults.
+type Config struct {
+	// Server is the base URL of the server, e.g. "http://localhost:8080"
+	Server string
+	APIKey string
+
+	// Template creates the sandboxes; empty means the server's default
+	Template string
+
+	// Iterations is the number of samples of each measurement, and of
+	// execs per worker when measuring concurrency
+	Iterations int
+
+	// FileSize is the size in bytes of the file uploaded and downloaded
+	FileSize int64
+
+	// Concurrency are the worker counts to measure exec throughput at
+	Concurrency []int
+
+	// Language and Code are what execs run
+	Language string
+	Code     string
+
+	// Progress, if set, is told what is being measured
+	Progress func(msg string)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Iterations <= 0 {
+		c.Iterations = DefaultIterations
+	}
+	if c.FileSize <= 0 {
+		c.FileSize = DefaultFileSize
+	}
+	if len(c.Concurrency) == 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Language == "" {
+		c.Language = DefaultLanguage
+	}
+	if c.Code == "" {
+		c.Code = DefaultCode
+	}
+	if c.Progress == nil {
+		c.Progress = func(string) {}
+	}
+	return c
+}
+
+// Report is the result of Run.
+type Report struct {
+	Server     string    `json:"server"`
+	Template   string    `json:"template,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS float64   `json:"duration_ms"`
+	Iterations int       `json:"iterations"`
+
+	// ColdCreate is how long creating a sandbox takes, each created from
+	// scratch
+	ColdCreate Latency `json:"cold_create"`
+
+	// WarmClaim is how long creating a sandbox takes while the server's
+	// warm pool has sandboxes to hand out; nil without a pool
+	WarmClaim *Latency `json:"warm_claim,omitempty"`
+
+	// Exec is the round trip of an exec in a sandbox that already ran one
+	Exec Latency `json:"exec"`
+
+	Upload   Throughput `json:"upload"`
+	Download Throughput `json:"download"`
+
+	// Concurrency is exec throughput with that many workers, each running
+	// Iterations execs in a sandbox of its own
+	Concurrency []ConcurrencyResult `json:"concurrency"`
+
+	// Skipped says why measurements were left out
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// Latency summarizes the durations of the samples that succeeded.
+type Latency struct {
+	Samples int     `json:"samples"`
+	Errors  int     `json:"errors"`
+	MinMS   float64 `json:"min_ms"`
+	MeanMS  float64 `json:"mean_ms"`
+	P50MS   float64 `json:"p50_ms"`
+	P90MS   float64 `json:"p90_ms"`
+	P99MS   float64 `json:"p99_ms"`
+	MaxMS   float64 `json:"max_ms"`
+
+	// Error is the first error, if there were any
+	Error string `json:"error,omitempty"`
+}
+
+// Throughput summarizes file transfers of Bytes each.
+type Throughput struct {
+	Latency
+	Bytes int64 `json:"bytes"`
+	// MBPerSec is the mean rate, in MB (10^6 bytes) per second
+	MBPerSec float64 `json:"mb_per_sec"`
+}
+
+// ConcurrencyResult is exec throughput with Workers workers.
+type ConcurrencyResult struct {
+	Workers     int     `json:"workers"`
+	Execs       int     `json:"execs"`
+	ExecsPerSec float64 `json:"execs_per_sec"`
+	Latency     Latency `json:"latency"`
+}
+
+// samples collects durations and errors, safe for concurrent use.
+type samples struct {
+	mu     sync.Mutex
+	times  []time.Duration
+	errors int
+	first  error
+}
+
+func (s *samples) add(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errors++
+		if s.first == nil {
+			s.first = err
+		}
+		return
+	}
+	s.times = append(s.times, d)
+}
+
+func (s *samples) latency() Latency {
+	l := Latency{Samples: len(s.times), Errors: s.errors}
+	if s.first != nil {
+		l.Error = s.first.Error()
+	}
+	if len(s.times) == 0 {
+		return l
+	}
+	sorted := slices.Clone(s.times)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	l.MinMS = ms(sorted[0])
+	l.MaxMS = ms(sorted[len(sorted)-1])
+	l.MeanMS = ms(total / time.Duration(len(sorted)))
+	l.P50MS = ms(percentile(sorted, 50))
+	l.P90MS = ms(percentile(sorted, 90))
+	l.P99MS = ms(percentile(sorted, 99))
+	return l
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// bench runs one Run.
+type bench struct {
+	cfg Config
+	c   *client.Client
+}
+
+// Run measures the server of cfg. Failed samples are counted in the
+// report; Run only fails if it cannot create the sandboxes it measures
+// in. The sandboxes it creates are deleted before it returns.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	cfg = cfg.withDefaults()
+	var opts []client.Option
+	if cfg.APIKey != "" {
+		opts = append(opts, client.WithAPIKey(cfg.APIKey))
+	}
+	b := &bench{cfg: cfg, c: client.New(cfg.Server, opts...)}
+
+	r := &Report{
+		Server:     cfg.Server,
+		Template:   cfg.Template,
+		StartedAt:  time.Now().UTC(),
+		Iterations: cfg.Iterations,
+		Skipped:    make(map[string]string),
+	}
+
+	cfg.Progress("cold create")
+	r.ColdCreate = b.creates(ctx)
+
+	if pool, ok := b.pool(ctx); ok {
+		cfg.Progress("warm claim")
+		claims := b.creates(ctx)
+		r.WarmClaim = &claims
+	} else {
+		r.Skipped["warm_claim"] = pool
+	}
+
+	id, err := b.create(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a sandbox to measure in: %w", err)
+	}
+	defer b.delete(id)
+
+	cfg.Progress("exec round trip")
+	r.Exec = b.execs(ctx, id)
+
+	cfg.Progress(fmt.Sprintf("upload and download of %d bytes", cfg.FileSize))
+	r.Upload, r.Download = b.transfers(ctx, id)
+
+	for _, workers := range cfg.Concurrency {
+		cfg.Progress(fmt.Sprintf("%d concurrent workers", workers))
+		res, err := b.concurrency(ctx, workers)
+		if err != nil {
+			r.Skipped[fmt.Sprintf("concurrency_%d", workers)] = err.Error()
+			continue
+		}
+		r.Concurrency = append(r.Concurrency, res)
+	}
+
+	r.DurationMS = ms(time.Since(r.StartedAt))
+	if len(r.Skipped) == 0 {
+		r.Skipped = nil
+	}
+	return r, nil
+}
+
+func (b *bench) create(ctx context.Context) (string, error) {
+	sb, err := b.c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: b.cfg.Template})
+	if err != nil {
+		return "", err
+	}
+	return sb.ID, nil
+}
+
+// delete deletes a sandbox even if ctx was cancelled.
+func (b *bench) delete(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	b.c.DeleteSandbox(ctx, id)
+}
+
+// creates times Iterations creates, deleting each sandbox untimed.
+func (b *bench) creates(ctx context.Context) Latency {
+	var s samples
+	for range b.cfg.Iterations {
+		start := time.Now()
+		id, err := b.create(ctx)
+		s.add(time.Since(start), err)
+		if err == nil {
+			b.delete(id)
+		}
+	}
+	return s.latency()
+}
+
+// pool reports whether the server has a warm pool, or why it is taken to
+// have none.
+func (b *bench) pool(ctx context.Context) (string, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cmp.Or(b.cfg.Server, client.DefaultBaseURL), "/")+"/readyz", nil)
+	if err != nil {
+		return err.Error(), false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err.Error(), false
+	}
+	defer resp.Body.Close()
+	var ready struct {
+		Components map[string]json.RawMessage `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+		return fmt.Sprintf("cannot read /readyz: %v", err), false
+	}
+	if _, ok := ready.Components["pool"]; !ok {
+		return "the server has no warm pool", false
+	}
+	return "", true
+}
+
+// exec runs the configured code once, failing unless it exits with 0.
+func (b *bench) exec(ctx context.Context, id string) error {
+	res, err := b.c.Exec(ctx, id, client.ExecRequest{Language: b.cfg.Language, Code: b.cfg.Code})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode == nil || *res.ExitCode != 0 {
+		return fmt.Errorf("exec did not succeed: %s", strings.TrimSpace(res.Stderr+" "+res.ExitReason))
+	}
+	return nil
+}
+
+// execs times Iterations execs in a sandbox, after one to warm it up.
+func (b *bench) execs(ctx context.Context, id string) Latency {
+	var s samples
+	b.exec(ctx, id)
+	for range b.cfg.Iterations {
+		start := time.Now()
+		err := b.exec(ctx, id)
+		s.add(time.Since(start), err)
+	}
+	return s.latency()
+}
+
+// transfers times Iterations uploads and downloads of FileSize random
+// bytes.
+func (b *bench) transfers(ctx context.Context, id string) (up, down Throughput) {
+	data := make([]byte, b.cfg.FileSize)
+	rand.Read(data)
+	const path = "/tmp/boxed-bench.bin"
+
+	var ups, downs samples
+	for range b.cfg.Iterations {
+		start := time.Now()
+		err := b.c.UploadFile(ctx, id, path, bytes.NewReader(data))
+		ups.add(time.Since(start), err)
+	}
+	for range b.cfg.Iterations {
+		start := time.Now()
+		err := b.download(ctx, id, path)
+		downs.add(time.Since(start), err)
+	}
+	return b.throughput(&ups), b.throughput(&downs)
+}
+
+func (b *bench) download(ctx context.Context, id, path string) error {
+	r, err := b.c.DownloadFile(ctx, id, path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	n, err := io.Copy(io.Discard, r)
+	if err == nil && n != b.cfg.FileSize {
+		err = fmt.Errorf("downloaded %d of %d bytes", n, b.cfg.FileSize)
+	}
+	return err
+}
+
+func (b *bench) throughput(s *samples) Throughput {
+	t := Throughput{Latency: s.latency(), Bytes: b.cfg.FileSize}
+	if t.MeanMS > 0 {
+		t.MBPerSec = float64(b.cfg.FileSize) / 1e6 / (t.MeanMS / 1000)
+	}
+	return t
+}
+
+// concurrency times workers running Iterations execs each at once, each
+// in a sandbox created beforehand.
+func (b *bench) concurrency(ctx context.Context, workers int) (ConcurrencyResult, error) {
+	ids := make([]string, 0, workers)
+	defer func() {
+		for _, id := range ids {
+			b.delete(id)
+		}
+	}()
+	for range workers {
+		id, err := b.create(ctx)
+		if err != nil {
+			return ConcurrencyResult{}, fmt.Errorf("failed to create sandbox %d of %d: %w", len(ids)+1, workers, err)
+		}
+		ids = append(ids, id)
+		b.exec(ctx, id)
+	}
+
+	var s samples
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range b.cfg.Iterations {
+				start := time.Now()
+				err := b.exec(ctx, id)
+				s.add(time.Since(start), err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := ConcurrencyResult{Workers: workers, Latency: s.latency()}
+	res.Execs = res.Latency.Samples
+	res.ExecsPerSec = float64(res.Execs) / elapsed.Seconds()
+	return res, nil
+}
@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWasmAdmissionWebhooks(t *testing.T) {
+	// The policy denies creates without a team, tags the rest with the
+	// caller and turns the echo of secrets into that of a placeholder
+	var mu sync.Mutex
+	var reviews []api.AdmissionReview
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review struct {
+			api.AdmissionReview
+			Request map[string]any `json:"request"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&review))
+		mu.Lock()
+		reviews = append(reviews, review.AdmissionReview)
+		mu.Unlock()
+
+		resp := map[string]any{"allowed": true}
+		switch review.Operation {
+		case api.AdmissionCreate:
+			metadata, _ := review.Request["metadata"].(map[string]any)
+			if metadata["team"] == nil {
+				resp = map[string]any{"allowed": false, "reason": "a team is required"}
+				break
+			}
+			metadata["checked-for"] = review.Caller.Subject
+			resp["request"] = review.Request
+		case api.AdmissionExec:
+			code, _ := review.Request["code"].(string)
+			if strings.Contains(code, "SECRET") {
+				review.Request["code"] = "echo redacted"
+				resp["request"] = review.Request
+			}
+			if strings.Contains(code, "forbidden") {
+				resp = map[string]any{"allowed": false, "reason": "not that"}
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(policy.Close)
+
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	h := api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{{Name: "ci", Key: "ci-key"}}),
+		api.WithAdmissionWebhooks([]api.AdmissionWebhook{
+			{Name: "policy", URL: policy.URL},
+			{Name: "audit", URL: down.URL, Operations: []string{api.AdmissionExec}, Timeout: time.Second, FailOpen: true},
+		}))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, client.WithAPIKey("ci-key"))
+	ctx := context.Background()
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	assert.True(t, errors.Is(err, client.ErrForbidden), "got %v", err)
+	assert.ErrorContains(t, err, "denied by admission webhook policy: a team is required")
+	var apiErr *client.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "ml"}})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+	got, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "key:ci", got.Config.Labels["checked-for"])
+
+	// Changed requests run as changed; the audit webhook is down but
+	// fails open
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo SECRET"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "redacted")
+	assert.NotContains(t, res.Stdout, "SECRET")
+
+	mu.Lock()
+	require.Len(t, reviews, 3)
+	assert.Equal(t, sb.ID, reviews[2].SandboxID)
+	assert.Equal(t, "key:ci", reviews[2].Caller.Subject)
+	mu.Unlock()
+
+	// Session languages are execs like the others
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash-session", Code: "echo forbidden"})
+	assert.True(t, errors.Is(err, client.ErrForbidden), "got %v", err)
+
+	// Interactive sessions cannot be reviewed, so they are refused
+	interact := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/sandbox/" + sb.ID + "/interact?api_key=ci-key"
+	_, resp, err := websocket.DefaultDialer.Dial(interact, nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// SSH commands are reviewed as bash execs, and shells refused
+	hostKey, err := api.LoadSSHHostKey(filepath.Join(t.TempDir(), "host_key"))
+	require.NoError(t, err)
+	ss := h.NewSSHServer(hostKey)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go ss.Serve(lis)
+	t.Cleanup(func() { ss.Shutdown(ctx) })
+	conn, err := ssh.Dial("tcp", lis.Addr().String(), &ssh.ClientConfig{
+		User:            sb.ID,
+		Auth:            []ssh.AuthMethod{ssh.Password("ci-key")},
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         10 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	out, err := session.Output("echo SECRET")
+	require.NoError(t, err)
+	assert.Equal(t, "echo redacted\n", string(out))
+	session, err = conn.NewSession()
+	require.NoError(t, err)
+	assert.Error(t, session.Run("echo forbidden"))
+	session, err = conn.NewSession()
+	require.NoError(t, err)
+	assert.Error(t, session.Shell())
+
+	// Webhooks that fail close by default
+	policy.Close()
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	assert.True(t, errors.Is(err, client.ErrUnavailable), "got %v", err)
+	assert.ErrorContains(t, err, "admission webhook policy failed")
+}
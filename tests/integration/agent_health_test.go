@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAgentFaultServer starts a server on a wasm driver injecting the given
+// agent faults, which pings agents after 200ms of quiet.
+func newAgentFaultServer(t *testing.T, faults string) *client.Client {
+	t.Helper()
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir":         buildWasmModules(t),
+		"root_dir":            t.TempDir(),
+		"faults":              faults,
+		"agent_ping_interval": 200 * time.Millisecond,
+		"agent_ping_timeout":  200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL)
+}
+
+func TestWasmAgentRestart(t *testing.T) {
+	c := newAgentFaultServer(t, "agent_crash")
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+
+	// The first agent dies before answering; the exec runs on its successor
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo restarted"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 0, *res.ExitCode)
+	assert.Contains(t, res.Stdout, "echo restarted")
+
+	got, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.AgentCrashes)
+}
+
+func TestWasmAgentFailsDuringExec(t *testing.T) {
+	for _, fault := range []string{"agent_crash=300ms", "agent_hang=300ms"} {
+		t.Run(fault, func(t *testing.T) {
+			c := newAgentFaultServer(t, fault)
+			ctx := context.Background()
+
+			sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+			require.NoError(t, err)
+			defer c.DeleteSandbox(ctx, sb.ID)
+
+			// The exec ends with its agent, long before the sleep does
+			start := time.Now()
+			res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 10s"})
+			require.NoError(t, err)
+			assert.Nil(t, res.ExitCode)
+			assert.Equal(t, driver.ExitAgentCrashed, res.ExitReason)
+			assert.Less(t, time.Since(start), 5*time.Second)
+
+			got, err := c.GetSandbox(ctx, sb.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "ready", got.State)
+			assert.Equal(t, 1, got.AgentCrashes)
+
+			// The next exec gets a healthy agent
+			res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo again"})
+			require.NoError(t, err)
+			require.NotNil(t, res.ExitCode)
+			assert.Equal(t, 0, *res.ExitCode)
+		})
+	}
+}
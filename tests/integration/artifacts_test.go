@@ -0,0 +1,209 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/artifacts"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates a PNG and a CSV in /workspace/plots, then gives the
+// agent's watcher time to report them before the process exits.
+const writeFiles = `
+import os, time
+os.makedirs('/workspace/plots', exist_ok=True)
+open('/workspace/plots/chart.png', 'wb').write(b'not really a png')
+open('/workspace/plots/data.csv', 'w').write('a,b\n1,2\n')
+time.sleep(1)
+`
+
+func TestArtifactOptions(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "timeout": 120})
+
+	resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), map[string]any{
+		"language": "python",
+		"code":     writeFiles,
+		"artifacts": map[string]any{
+			"watch_paths": []string{"/workspace/plots"},
+			"mime_types":  []string{"image/*"},
+			"delivery":    "url",
+		},
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result struct {
+		Artifacts []struct {
+			Path       string `json:"path"`
+			MIME       string `json:"mime"`
+			Size       int64  `json:"size"`
+			DataBase64 string `json:"data_base64"`
+			URL        string `json:"url"`
+		} `json:"artifacts"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NotEmpty(t, result.Artifacts)
+
+	// Only the PNG matches; duplicates come from repeated modify events
+	for _, a := range result.Artifacts {
+		assert.Equal(t, "/workspace/plots/chart.png", a.Path)
+		assert.Equal(t, "image/png", a.MIME)
+		assert.Empty(t, a.DataBase64)
+	}
+
+	// The URL serves the file from the sandbox
+	a := result.Artifacts[len(result.Artifacts)-1]
+	require.True(t, strings.HasPrefix(a.URL, "/v1/"))
+	file, err := http.Get(strings.TrimSuffix(BaseURL, "/v1") + a.URL)
+	require.NoError(t, err)
+	defer file.Body.Close()
+	body, _ := io.ReadAll(file.Body)
+	assert.Equal(t, "not really a png", string(body))
+	assert.Equal(t, int64(len(body)), a.Size)
+}
+
+func TestArtifactOptionsValidation(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim"})
+
+	resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), map[string]any{
+		"language":  "python",
+		"code":      "print(1)",
+		"artifacts": map[string]any{"delivery": "email"},
+	})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestArtifactStoreDedup(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	store, err := artifacts.Open(t.TempDir())
+	require.NoError(t, err)
+
+	e := echo.New()
+	api.NewHandler(d, "", api.WithArtifactStore(store)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+
+	// Two sandboxes generate the same file: it is stored once
+	var digests []string
+	var ids []string
+	for i := 0; i < 2; i++ {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+		require.NoError(t, err)
+		ids = append(ids, sb.ID)
+
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "same output"})
+		require.NoError(t, err)
+		require.Len(t, res.Artifacts, 1)
+		a := res.Artifacts[0]
+		assert.Equal(t, i == 1, a.Deduplicated)
+		assert.Equal(t, "/v1/artifacts/"+a.SHA256, a.URL)
+		digests = append(digests, a.SHA256)
+	}
+	assert.Equal(t, digests[0], digests[1])
+	assert.Equal(t, artifacts.Stats{Blobs: 1, Bytes: 11, Refs: 2, LogicalBytes: 22}, store.Stats())
+
+	resp, err := http.Get(srv.URL + "/v1/artifacts/" + digests[0])
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "same output", string(body))
+
+	// The blob lives until the last sandbox referencing it is stopped
+	require.NoError(t, c.DeleteSandbox(ctx, ids[0]))
+	assert.Equal(t, 1, store.Stats().Blobs)
+	require.NoError(t, c.DeleteSandbox(ctx, ids[1]))
+	assert.Equal(t, artifacts.Stats{}, store.Stats())
+
+	resp, err = http.Get(srv.URL + "/v1/artifacts/" + digests[0])
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWasmArtifactManifest(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	store, err := artifacts.Open(t.TempDir())
+	require.NoError(t, err)
+	e := echo.New()
+	api.NewHandler(d, "", api.WithArtifactStore(store)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+
+	// The manifest lists files without their contents or links, and
+	// nothing is stored
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "listed only",
+		Artifacts: &client.ArtifactOptions{Delivery: client.DeliveryManifest}, Cache: true})
+	require.NoError(t, err)
+	require.Len(t, res.Artifacts, 1)
+	a := res.Artifacts[0]
+	assert.Equal(t, client.Artifact{Path: "last.txt", MIME: "text/plain; charset=utf-8", Size: int64(len("listed only"))}, a)
+	assert.Equal(t, artifacts.Stats{}, store.Stats())
+
+	// The client fetches the files it wants with the files API
+	rc, err := c.DownloadFile(ctx, sb.ID, a.SandboxPath())
+	require.NoError(t, err)
+	body, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "listed only", string(body))
+
+	// Manifest results are not cached: the files they list may be gone
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "listed only",
+		Artifacts: &client.ArtifactOptions{Delivery: client.DeliveryManifest}, Cache: true})
+	require.NoError(t, err)
+	assert.False(t, res.Cached)
+
+	// "artifacts": "manifest" is short for the delivery alone
+	resp := postJSON(t, fmt.Sprintf("%s/v1/sandbox/%s/exec", srv.URL, sb.ID), map[string]any{
+		"language":  "bash",
+		"code":      "short form",
+		"artifacts": "manifest",
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var short client.ExecResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&short))
+	require.Len(t, short.Artifacts, 1)
+	assert.Equal(t, int64(len("short form")), short.Artifacts[0].Size)
+	assert.Empty(t, short.Artifacts[0].DataBase64)
+	assert.Empty(t, short.Artifacts[0].URL)
+
+	resp = postJSON(t, fmt.Sprintf("%s/v1/sandbox/%s/exec", srv.URL, sb.ID), map[string]any{
+		"language":  "bash",
+		"code":      "x",
+		"artifacts": "email",
+	})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/akshayaggarwal99/boxed/tests/bench"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmBench(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	report, err := bench.Run(ctx, bench.Config{
+		Server:      srv.URL,
+		Template:    "python:3.10-slim",
+		Iterations:  3,
+		FileSize:    64 << 10,
+		Concurrency: []int{1, 2},
+	})
+	require.NoError(t, err)
+
+	for name, l := range map[string]bench.Latency{
+		"cold_create": report.ColdCreate,
+		"exec":        report.Exec,
+		"upload":      report.Upload.Latency,
+		"download":    report.Download.Latency,
+	} {
+		assert.Equal(t, 3, l.Samples, name)
+		assert.Zero(t, l.Errors, "%s: %s", name, l.Error)
+		assert.Positive(t, l.MeanMS, name)
+		assert.LessOrEqual(t, l.MinMS, l.P50MS, name)
+		assert.LessOrEqual(t, l.P50MS, l.MaxMS, name)
+	}
+	assert.Positive(t, report.Download.MBPerSec)
+
+	// The wasm driver keeps no warm pool
+	assert.Nil(t, report.WarmClaim)
+	assert.Contains(t, report.Skipped, "warm_claim")
+
+	require.Len(t, report.Concurrency, 2)
+	assert.Equal(t, 2, report.Concurrency[1].Workers)
+	assert.Equal(t, 6, report.Concurrency[1].Execs)
+	assert.Positive(t, report.Concurrency[1].ExecsPerSec)
+
+	// Every sandbox it created is gone
+	list, err := client.New(srv.URL).ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,232 @@
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// builderDriver builds images by reading their context, which it keeps,
+// and printing the Dockerfile's instructions; RUN false fails.
+type builderDriver struct {
+	driver.Driver
+
+	mu      sync.Mutex
+	ref     string
+	files   map[string]string
+	build   driver.ImageBuild
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *builderDriver) BuildImage(ctx context.Context, ref string, b driver.ImageBuild, log func(string)) error {
+	files := make(map[string]string)
+	tr := tar.NewReader(b.Context)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+	d.mu.Lock()
+	d.ref, d.files, d.build = ref, files, b
+	started, release := d.started, d.release
+	d.mu.Unlock()
+	if started != nil {
+		close(started)
+		<-release
+	}
+
+	dockerfile := b.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	steps := strings.Split(strings.TrimSpace(files[dockerfile]), "\n")
+	for i, step := range steps {
+		log(fmt.Sprintf("Step %d/%d : %s", i+1, len(steps), step))
+		if step == "RUN false" {
+			return fmt.Errorf("%w: The command '/bin/sh -c false' returned a non-zero code: 1", driver.ErrBuildFailed)
+		}
+	}
+	log("Successfully tagged " + ref)
+	return nil
+}
+
+// contextTar returns a tar archive of files.
+func contextTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestWasmBuildImage(t *testing.T) {
+	wd, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { wd.Close() })
+	ctx := context.Background()
+
+	// Drivers that cannot build images say so
+	e := echo.New()
+	api.NewHandler(wd, "").RegisterRoutes(e)
+	plain := httptest.NewServer(e)
+	t.Cleanup(plain.Close)
+	_, err = client.New(plain.URL).BuildImage(ctx, client.BuildImageRequest{Name: "env", Dockerfile: "FROM python:3.12-slim"}, nil)
+	assert.ErrorIs(t, err, client.ErrNotImplemented)
+
+	d := &builderDriver{Driver: wd}
+	e = echo.New()
+	h := api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+	}))
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, client.WithAPIKey("alice-key"))
+
+	// The Dockerfile is added to the context, the output streamed
+	var lines []string
+	tmpl, err := c.BuildImage(ctx, client.BuildImageRequest{
+		Name:       "agent-env",
+		Tag:        "v1",
+		MemoryMB:   512,
+		Dockerfile: "FROM python:3.12-slim\nCOPY requirements.txt /app/",
+		Context:    bytes.NewReader(contextTar(t, map[string]string{"requirements.txt": "requests\n", "Dockerfile": "FROM scratch"})),
+		BuildArgs:  map[string]string{"PY_VERSION": "3.12"},
+	}, func(line string) { lines = append(lines, line) })
+	require.NoError(t, err)
+	assert.Equal(t, &client.Template{Name: "agent-env:v1", Image: "boxed-templates/agent-env:v1", MemoryMB: 512, CPUCores: 1, Published: true}, tmpl)
+	assert.Equal(t, []string{
+		"Step 1/2 : FROM python:3.12-slim",
+		"Step 2/2 : COPY requirements.txt /app/",
+		"Successfully tagged boxed-templates/agent-env:v1",
+	}, lines)
+	assert.Equal(t, "boxed-templates/agent-env:v1", d.ref)
+	assert.Equal(t, "requests\n", d.files["requirements.txt"])
+	assert.Equal(t, "FROM scratch", d.files["Dockerfile"])
+	assert.Equal(t, map[string]string{"PY_VERSION": "3.12"}, d.build.BuildArgs)
+	assert.Equal(t, map[string]string{api.TemplateLabel: "agent-env:v1"}, d.build.Labels)
+	list, _, err := c.ListTemplates(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, list, *tmpl)
+
+	// Without a Dockerfile the context's own is built; without streaming
+	// the output comes with the template
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", "agent-env")
+	part, _ := w.CreateFormFile("context", "context.tar")
+	part.Write(contextTar(t, map[string]string{"Dockerfile": "FROM python:3.11-slim"}))
+	w.Close()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/images/build", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("X-Boxed-API-Key", "alice-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var built struct {
+		Template client.Template `json:"template"`
+		Log      string          `json:"log"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&built))
+	assert.Equal(t, "agent-env:latest", built.Template.Name)
+	assert.Equal(t, "Step 1/1 : FROM python:3.11-slim\nSuccessfully tagged boxed-templates/agent-env:latest\n", built.Log)
+	assert.Empty(t, d.build.Dockerfile)
+
+	// A failing step fails with its output, leaving the template as it was
+	_, err = c.BuildImage(ctx, client.BuildImageRequest{Name: "agent-env", Tag: "v1", Dockerfile: "FROM python:3.12-slim\nRUN false"}, nil)
+	require.ErrorIs(t, err, client.ErrSetupFailed)
+	assert.Contains(t, err.Error(), "Step 2/2 : RUN false")
+	assert.Contains(t, err.Error(), "returned a non-zero code: 1")
+	list, _, err = c.ListTemplates(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, list, *tmpl)
+
+	for _, req := range []client.BuildImageRequest{
+		{Name: "Agent-Env", Dockerfile: "FROM python:3.12-slim"},
+		{Name: "agent-env"},
+		{Name: "agent-env", Dockerfile: "FROM python:3.12-slim", MemoryMB: -1},
+		{Name: "agent-env", Dockerfile: "FROM python:3.12-slim", Context: strings.NewReader("not a tar archive, not at all")},
+	} {
+		_, err = c.BuildImage(ctx, req, nil)
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, "%+v", req)
+	}
+
+	// Names others built, or being built, are taken
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	_, err = bob.BuildImage(ctx, client.BuildImageRequest{Name: "agent-env", Tag: "v1", Dockerfile: "FROM python:3.12-slim"}, nil)
+	assert.ErrorIs(t, err, client.ErrConflict)
+
+	d.mu.Lock()
+	d.started, d.release = make(chan struct{}), make(chan struct{})
+	started, release := d.started, d.release
+	d.mu.Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := bob.BuildImage(ctx, client.BuildImageRequest{Name: "bobs-env", Dockerfile: "FROM python:3.12-slim"}, nil)
+		done <- err
+	}()
+	<-started
+	_, err = bob.BuildImage(ctx, client.BuildImageRequest{Name: "bobs-env", Dockerfile: "FROM python:3.12-slim"}, nil)
+	assert.ErrorIs(t, err, client.ErrConflict)
+	d.mu.Lock()
+	d.started, d.release = nil, nil
+	d.mu.Unlock()
+	close(release)
+	require.NoError(t, <-done)
+}
+
+func TestBuildImage(t *testing.T) {
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	var lines []string
+	tmpl, err := c.BuildImage(ctx, client.BuildImageRequest{
+		Name:       "boxed-test-build",
+		Dockerfile: "FROM python:3.10-slim\nARG GREETING\nCOPY greeting.txt /opt/\nRUN echo \"$GREETING\" >> /opt/greeting.txt",
+		Context:    bytes.NewReader(contextTar(t, map[string]string{"greeting.txt": "hello\n"})),
+		BuildArgs:  map[string]string{"GREETING": "from the build"},
+	}, func(line string) { lines = append(lines, line) })
+	require.NoError(t, err)
+	assert.NotEmpty(t, lines)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: tmpl.Name})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: `print(open("/opt/greeting.txt").read(), end="")`})
+	require.NoError(t, err)
+	assert.Equal(t, "hello\nfrom the build\n", res.Stdout, res.Stderr)
+
+	_, err = c.BuildImage(ctx, client.BuildImageRequest{Name: "boxed-test-build", Tag: "broken", Dockerfile: "FROM python:3.10-slim\nRUN exit 3"}, nil)
+	assert.True(t, errors.Is(err, client.ErrSetupFailed), "got %v", err)
+}
@@ -0,0 +1,171 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamingDriver hides the size of downloaded files, as drivers that
+// stream them out of the sandbox do.
+type streamingDriver struct {
+	driver.Driver
+}
+
+func (d streamingDriver) GetFile(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	r, err := d.Driver.GetFile(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{r}, nil
+}
+
+func TestWasmTransferChecksums(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	serve := func(d driver.Driver) string {
+		e := echo.New()
+		api.NewHandler(d, "").RegisterRoutes(e)
+		srv := httptest.NewServer(e)
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	srvURL := serve(d)
+	c := client.New(srvURL)
+	ctx := context.Background()
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	digest := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	read := func(c *client.Client, p string) (string, error) {
+		r, err := c.DownloadFile(ctx, sb.ID, p)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+	post := func(header string, sums []string, contents ...string) (*http.Response, map[string]any) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		w.WriteField("path", "/workspace")
+		for i, content := range contents {
+			fw, _ := w.CreateFormFile("file", string(rune('a'+i))+".bin")
+			fw.Write([]byte(content))
+		}
+		for _, sum := range sums {
+			w.WriteField("sha256", sum)
+		}
+		w.Close()
+		req, err := http.NewRequest(http.MethodPost, srvURL+"/v1/sandbox/"+sb.ID+"/files", &b)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		if header != "" {
+			req.Header.Set(api.ContentSHA256Header, header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp, body
+	}
+
+	// Uploads are checked before anything is written, and answered with
+	// the digests of what arrived
+	resp, body := post("", []string{digest("one"), strings.ToUpper(digest("two"))}, "one", "two")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, map[string]any{"/workspace/a.bin": digest("one"), "/workspace/b.bin": digest("two")}, body["checksums"])
+
+	resp, body = post("", []string{"", digest("something else")}, "new", "corrupted")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "sha256 of /workspace/b.bin is "+digest("corrupted"))
+	got, err := read(c, "/workspace/a.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "one", got, "nothing of a refused upload is written")
+
+	resp, _ = post(digest("headed"), nil, "headed")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = post(digest("x"), nil, "y")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, body = post("", []string{"abc"}, "y")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "not a hex SHA-256 digest")
+	resp, body = post("", []string{digest("y"), digest("z")}, "y")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body["error"], "2 sha256 digests for 1 files")
+
+	// The client sends the digests of what it read, or those it is given
+	_, err = c.UploadFiles(ctx, sb.ID, []client.UploadEntry{
+		{Path: "/workspace/ok.txt", Content: strings.NewReader("ok")},
+		{Path: "/workspace/bad.txt", Content: strings.NewReader("truncated"), SHA256: digest("truncated and more")},
+	})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = read(c, "/workspace/ok.txt")
+	assert.Error(t, err)
+	data := strings.Repeat("weights ", 4096)
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/model.bin", strings.NewReader(data)))
+
+	// Seekable files are hashed up front and keep their ranges
+	content := srvURL + "/v1/sandbox/" + sb.ID + "/files/content?checksum=true&path=" + url.QueryEscape("/workspace/model.bin")
+	req, err := http.NewRequest(http.MethodGet, content, nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=0-6")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	part, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "weights", string(part))
+	assert.Equal(t, digest(data), resp.Header.Get(api.ContentSHA256Header))
+	got, err = read(c, "/workspace/model.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Streamed files carry the digest in a trailer
+	streamURL := serve(streamingDriver{d})
+	streamed := client.New(streamURL)
+	resp, err = http.Get(strings.Replace(content, srvURL, streamURL, 1))
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Empty(t, resp.Header.Get(api.ContentSHA256Header))
+	assert.Equal(t, digest(data), resp.Trailer.Get(api.ContentSHA256Header))
+	got, err = read(streamed, "/workspace/model.bin")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// A download that does not match its digest fails at the end
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(api.ContentSHA256Header, digest("the original"))
+		w.Write([]byte("a corrupted copy"))
+	}))
+	t.Cleanup(liar.Close)
+	_, err = read(client.New(liar.URL), "/workspace/model.bin")
+	assert.True(t, errors.Is(err, client.ErrChecksumMismatch), "got %v", err)
+}
@@ -0,0 +1,61 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSentinelErrors(t *testing.T) {
+	ctx := context.Background()
+	c := client.New("http://localhost:" + ServerPort)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Timeout:  2 * time.Minute,
+	})
+	require.NoError(t, err)
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "print('ok')"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "ok")
+
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+
+	// Operations on a removed sandbox map back to the sentinel
+	err = c.DeleteSandbox(ctx, sb.ID)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+
+	_, err = c.Exec(ctx, "does-not-exist", client.ExecRequest{Language: "python", Code: "1"})
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "sandbox_not_found", apiErr.Code)
+
+	_, err = c.Exec(ctx, "does-not-exist", client.ExecRequest{Language: "cobol", Code: "1"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+}
+
+func TestErrorBodiesCarryCodes(t *testing.T) {
+	resp, err := http.Get(BaseURL + "/sandbox/does-not-exist")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "sandbox_not_found", body.Code)
+	assert.NotEmpty(t, body.Error)
+}
@@ -0,0 +1,108 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNode serves a wasm-backed node of the cluster sharing store.
+func startNode(t *testing.T, id string, store state.Store) (*api.Handler, *client.Client) {
+	t.Helper()
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	h := api.NewHandler(d, "",
+		api.WithStore(store),
+		api.WithCluster(api.ClusterConfig{
+			NodeID:            id,
+			URL:               srv.URL,
+			HeartbeatInterval: 50 * time.Millisecond,
+			NodeTimeout:       500 * time.Millisecond,
+		}),
+	)
+	h.RegisterRoutes(e)
+	t.Cleanup(func() { h.Drain(context.Background(), false) })
+	return h, client.New(srv.URL)
+}
+
+func TestWasmCluster(t *testing.T) {
+	t.Run("memory", func(t *testing.T) { testCluster(t, state.NewMemoryStore()) })
+	t.Run("files", func(t *testing.T) {
+		store, err := state.OpenFileStore(t.TempDir())
+		require.NoError(t, err)
+		testCluster(t, store)
+	})
+}
+
+func testCluster(t *testing.T, store state.Store) {
+	nodeA, a := startNode(t, "a", store)
+	_, b := startNode(t, "b", store)
+	ctx := context.Background()
+
+	nodes, err := b.ClusterNodes(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "a", nodes[0].ID)
+	assert.False(t, nodes[0].Self)
+	assert.True(t, nodes[1].Self)
+	assert.True(t, nodes[0].Alive && nodes[1].Alive)
+
+	onA, err := a.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	onB, err := b.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	// Requests for A's sandbox made to B are served by A (the fake shell
+	// echoes its input)
+	res, err := b.Exec(ctx, onA.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo hi\n", res.Stdout)
+	info, err := b.GetSandbox(ctx, onA.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", info.State)
+
+	// Listing on either node covers both
+	for _, c := range []*client.Client{a, b} {
+		list, err := c.ListSandboxes(ctx)
+		require.NoError(t, err)
+		ids := make([]string, len(list))
+		for i, sb := range list {
+			ids[i] = sb.ID
+		}
+		assert.ElementsMatch(t, []string{onA.ID, onB.ID}, ids)
+	}
+	nodes, err = a.ClusterNodes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, nodes[0].Sandboxes)
+	assert.Equal(t, 1, nodes[1].Sandboxes)
+
+	// Once A stops heartbeating, B takes over; its wasm driver cannot
+	// reach A's sandboxes, so they are recorded as failed
+	require.NoError(t, nodeA.Drain(ctx, false))
+	require.Eventually(t, func() bool {
+		nodes, err := b.ClusterNodes(ctx)
+		return err == nil && len(nodes) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	info, err = b.GetSandbox(ctx, onA.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", info.State)
+	assert.Contains(t, info.Error, "node a stopped")
+
+	// B's own sandbox is untouched
+	res, err = b.Exec(ctx, onB.ID, client.ExecRequest{Language: "bash", Code: "echo still"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo still\n", res.Stdout)
+}
@@ -0,0 +1,166 @@
+package integration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/config"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "boxed.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`port: 8081
+driver:
+  names: [wasm]
+  options:
+    modules_dir: `+buildWasmModules(t)+`
+    root_dir: `+t.TempDir()+`
+    agent_ping_interval: 2s
+pool:
+  max_sandboxes: 1
+limits:
+  max_ttl: 10m
+  max_code_size: 64
+auth:
+  keys:
+    - {name: ci, key: ci-key}
+`), 0600))
+
+	// The environment overrides the file, and given flags both
+	t.Setenv("BOXED_MAX_SANDBOXES", "2")
+	t.Setenv("BOXED_PREPULL", "a:1, b:2")
+	t.Setenv("BOXED_CORS_ORIGINS", "https://console.test")
+	t.Setenv("BOXED_CORS_CREDENTIALS", "true")
+	cfg, err := config.Load(file)
+	require.NoError(t, err)
+	flags := config.Default()
+	flags.Port = "9090"
+	flags.Limits.MaxTTL = time.Hour
+	cfg.Override(flags, func(flag string) bool { return flag == "port" })
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, file, cfg.Path)
+	assert.Equal(t, "9090", cfg.Port)
+	assert.Equal(t, []string{"wasm"}, cfg.Driver.Names)
+	assert.Equal(t, 2, cfg.Pool.MaxSandboxes)
+	assert.Equal(t, []string{"a:1", "b:2"}, cfg.Pool.Prepull)
+	assert.Equal(t, 10*time.Minute, cfg.Limits.MaxTTL)
+	assert.Equal(t, api.CORSPolicy{AllowedOrigins: []string{"https://console.test"}, AllowCredentials: true}, cfg.CORS.Policy())
+	assert.Equal(t, api.DefaultMaxArgs, cfg.Limits.MaxArgs)
+	assert.Equal(t, 2*time.Second, cfg.Driver.Settings()["agent_ping_interval"])
+
+	// A server set up from it applies its settings
+	d, err := multi.Open(cfg.Driver.Names, cfg.Driver.Settings(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, cfg.Auth.APIKey,
+		api.WithMaxTTL(cfg.Limits.MaxTTL),
+		api.WithInputLimits(cfg.Limits.Inputs()),
+		api.WithMaxSandboxes(cfg.Pool.MaxSandboxes),
+		api.WithAPIKeys(cfg.Auth.Keys))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	_, err = client.New(srv.URL).ListSandboxes(ctx)
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), "got %v", err)
+	c := client.New(srv.URL, client.WithAPIKey("ci-key"))
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: strings.Repeat("x", 65)})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.SetTTL(ctx, sb.ID, time.Hour)
+	assert.Error(t, err)
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+
+	// Unknown settings and unparsable variables fail the load
+	require.NoError(t, os.WriteFile(file, []byte("prot: 8080\n"), 0600))
+	_, err = config.Load(file)
+	assert.ErrorContains(t, err, "field prot not found")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	t.Setenv("BOXED_MAX_TTL", "soon")
+	_, err = config.Load(file)
+	assert.ErrorContains(t, err, `$BOXED_MAX_TTL: invalid duration "soon"`)
+	t.Setenv("BOXED_MAX_TTL", "")
+
+	// Validate reports every invalid setting at once
+	cert, key := writeKeyPair(t, dir, "a")
+	otherCert, _ := writeKeyPair(t, dir, "b")
+	cfg, err = config.Load(file)
+	require.NoError(t, err)
+	cfg.Port = "http"
+	cfg.Driver.Names = []string{"docker"}
+	cfg.Driver.Routes = []string{"python:*=wasm"}
+	cfg.Limits.MaxOutput = -1
+	cfg.TLS.CertFile = cert
+	cfg.Auth.Keys = []api.APIKey{{Name: "a", Key: "k"}, {Name: "a", Key: "l"}}
+	cfg.Admission.Webhooks = []api.AdmissionWebhook{{Name: "policy", URL: "policy.internal"}}
+	cfg.CORS.AllowedOrigins = []string{"*"}
+	cfg.Scheduling.DefaultClass = "vip"
+	cfg.Metrics.SlowDriverOps = map[string]time.Duration{"pull": time.Second}
+	err = cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{
+		`port: "http" is not a port number`,
+		"driver.routes: route python:*=wasm names a driver not in driver.names",
+		"limits.max_output: cannot be negative",
+		"tls: cert_file and key_file must be set together",
+		"auth.keys: key name a is used twice",
+		"admission.webhooks: webhook policy needs an http or https URL",
+		"cors: credentials cannot be allowed to every origin",
+		"scheduling: default class vip is not defined",
+		`metrics.slow_driver_ops: unknown operation "pull"`,
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+
+	cfg, err = config.Load(file)
+	require.NoError(t, err)
+	cfg.TLS = config.TLSConfig{CertFile: cert, KeyFile: key}
+	assert.NoError(t, cfg.Validate())
+	cfg.TLS.CertFile = otherCert
+	assert.ErrorContains(t, cfg.Validate(), "tls: ")
+}
+
+// writeKeyPair writes a self-signed certificate and its key to dir.
+func writeKeyPair(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
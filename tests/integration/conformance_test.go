@@ -0,0 +1,23 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/drivertest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerConformance(t *testing.T) {
+	drivertest.Run(t, testDriver, drivertest.Options{})
+}
+
+func TestWasmConformance(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	drivertest.Run(t, d, drivertest.Options{})
+}
@@ -0,0 +1,77 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmContextLimits(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "", api.WithMaxContextFileSize(16), api.WithMaxContextSize(24))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	file := func(path, content string) client.FileInjection {
+		return client.FileInjection{Path: path, ContentBase64: base64.StdEncoding.EncodeToString([]byte(content))}
+	}
+	create := func(files ...client.FileInjection) (*client.Sandbox, error) {
+		return c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Context: files})
+	}
+
+	// Limits count decoded bytes, and are checked before anything is created
+	var apiErr *client.APIError
+	_, err = create(file("big.txt", strings.Repeat("x", 17)))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.StatusCode)
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+	assert.Contains(t, apiErr.Message, "big.txt")
+
+	_, err = create(file("a.txt", strings.Repeat("a", 12)), file("b.txt", strings.Repeat("b", 13)))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.StatusCode)
+
+	// Bad base64 is no longer skipped silently
+	_, err = create(file("ok.txt", "fine"), client.FileInjection{Path: "bad.txt", ContentBase64: "not base64!"})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "bad.txt")
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	sb, err := create(file("data/a.txt", "hello context"), file("b.txt", "0123456789"))
+	require.NoError(t, err)
+	require.NotNil(t, sb.Context)
+	assert.Equal(t, 2, sb.Context.Files)
+	assert.Equal(t, int64(23), sb.Context.Bytes)
+
+	rc, err := c.DownloadFile(ctx, sb.ID, "data/a.txt")
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello context", string(data))
+}
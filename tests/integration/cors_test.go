@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmCORS(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	serve := func(opts ...api.Option) string {
+		e := echo.New()
+		api.NewHandler(d, "secret", opts...).RegisterRoutes(e)
+		srv := httptest.NewServer(e)
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	request := func(method, url, origin string, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	dial := func(url, origin string) (int, error) {
+		ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/v1/events?api_key=secret",
+			http.Header{"Origin": {origin}})
+		if err == nil {
+			ws.Close()
+		}
+		if resp == nil {
+			return 0, err
+		}
+		return resp.StatusCode, err
+	}
+
+	url := serve(api.WithCORS(api.CORSPolicy{
+		AllowedOrigins:   []string{"https://console.test", "https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+
+	// Preflights are answered without credentials
+	resp := request(http.MethodOptions, url+"/v1/sandbox", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"X-Boxed-API-Key, Content-Type"},
+	})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), "X-Boxed-API-Key")
+	assert.Contains(t, resp.Header.Get("Access-Control-Allow-Methods"), "DELETE")
+	assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+
+	resp = request(http.MethodOptions, url+"/v1/sandbox", "https://example.com.evil.test", http.Header{
+		"Access-Control-Request-Method": {"POST"},
+	})
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// Requests are still authenticated
+	resp = request(http.MethodGet, url+"/v1/sandbox", "https://console.test", http.Header{"X-Boxed-Api-Key": {"secret"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "https://console.test", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, resp.Header.Get("Access-Control-Expose-Headers"), api.SessionHeader)
+	resp = request(http.MethodGet, url+"/v1/sandbox", "https://console.test", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// WebSockets accept the same origins
+	status, err := dial(url, "https://ops.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, status)
+	status, _ = dial(url, "https://evil.test")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = dial(url, "http://localhost:3000")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Without a policy there are no CORS headers, and only localhost
+	// pages may open WebSockets
+	url = serve()
+	resp = request(http.MethodGet, url+"/v1/sandbox", "https://console.test", http.Header{"X-Boxed-Api-Key": {"secret"}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	status, _ = dial(url, "https://console.test")
+	assert.Equal(t, http.StatusForbidden, status)
+	status, err = dial(url, "http://localhost:3000")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, status)
+
+	assert.ErrorContains(t, api.CheckCORS(api.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}), "every origin")
+	assert.ErrorContains(t, api.CheckCORS(api.CORSPolicy{AllowedOrigins: []string{"console.test"}}), "not an http or https")
+	assert.ErrorContains(t, api.CheckCORS(api.CORSPolicy{AllowedOrigins: []string{"https://app.*.test"}}), "wildcard")
+	assert.NoError(t, api.CheckCORS(api.CORSPolicy{AllowedOrigins: []string{"*", "https://*.example.com:8443"}}))
+}
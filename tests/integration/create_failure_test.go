@@ -0,0 +1,91 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countSandboxes returns how many containers the driver currently reports.
+func countSandboxes(t *testing.T) int {
+	t.Helper()
+	list, err := testDriver.List(context.Background(), nil)
+	require.NoError(t, err)
+	return len(list)
+}
+
+func TestFailedStartIsCleanedUp(t *testing.T) {
+	before := countSandboxes(t)
+
+	resp := postJSON(t, BaseURL+"/sandbox", map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  60,
+		"sidecars": []map[string]any{
+			{
+				"name":         "broken",
+				"cmd":          []string{"sleep", "300"},
+				"health_check": map[string]any{"cmd": []string{"false"}, "retries": 2, "interval_ms": 100},
+			},
+		},
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	var body struct {
+		Code      string `json:"code"`
+		SandboxID string `json:"sandbox_id"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotEmpty(t, body.SandboxID, "failed creates report the torn down sandbox")
+
+	// The container is gone...
+	assert.Equal(t, before, countSandboxes(t))
+
+	// ...but the failure is recorded
+	info, err := http.Get(fmt.Sprintf("%s/sandbox/%s", BaseURL, body.SandboxID))
+	require.NoError(t, err)
+	defer info.Body.Close()
+	require.Equal(t, http.StatusOK, info.StatusCode)
+
+	var sandbox struct {
+		State     string `json:"state"`
+		Error     string `json:"error"`
+		BackendID string `json:"backend_id"`
+	}
+	require.NoError(t, json.NewDecoder(info.Body).Decode(&sandbox))
+	assert.Equal(t, "failed", sandbox.State)
+	assert.Contains(t, sandbox.Error, "broken")
+	require.NotEmpty(t, sandbox.BackendID)
+	_, err = testDriver.Info(context.Background(), sandbox.BackendID)
+	assert.True(t, errors.Is(err, driver.ErrSandboxNotFound), "got %v", err)
+
+	events := getTimeline(t, body.SandboxID)
+	require.NotEmpty(t, events)
+	assert.Equal(t, "failed", events[len(events)-1].Type)
+
+	// Deleting it again is a plain not found
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/sandbox/%s", BaseURL, body.SandboxID), nil)
+	del, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	del.Body.Close()
+	assert.Equal(t, http.StatusNotFound, del.StatusCode)
+}
+
+func TestFailedCreateLeavesNoContainer(t *testing.T) {
+	before := countSandboxes(t)
+
+	resp := postJSON(t, BaseURL+"/sandbox", map[string]any{
+		"template": "boxed-does-not-exist:latest",
+		"timeout":  60,
+	})
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, before, countSandboxes(t))
+}
@@ -0,0 +1,65 @@
+package integration
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmDashboard(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	serve := func(opts ...api.Option) string {
+		e := echo.New()
+		api.NewHandler(d, "secret", opts...).RegisterRoutes(e)
+		srv := httptest.NewServer(e)
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(url string) (*http.Response, string) {
+		resp, err := noRedirect.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	// The page is served without credentials; the API still needs them
+	url := serve(api.WithDashboard())
+	resp, _ := get(url + "/ui")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/ui/", resp.Header.Get("Location"))
+	resp, body := get(url + "/ui/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Contains(t, body, `<script defer src="app.js"></script>`)
+	for _, file := range []string{"app.js", "app.css"} {
+		resp, body = get(url + "/ui/" + file)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, file)
+		assert.NotEmpty(t, body, file)
+	}
+	resp, _ = get(url + "/ui/missing.js")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = get(url + "/v1/sandbox")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Servers without the option have no dashboard
+	url = serve()
+	resp, _ = get(url + "/ui/")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,71 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescriptor(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim"})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	desc, err := c.Describe(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.10-slim", desc.Image)
+	assert.Contains(t, desc.OS, "Debian")
+	assert.Equal(t, int64(512), desc.Limits.MemoryMB)
+	assert.Contains(t, desc.WritablePaths, "/output")
+
+	var python *client.Interpreter
+	for i, in := range desc.Interpreters {
+		if in.Command == "python3" {
+			python = &desc.Interpreters[i]
+		}
+	}
+	require.NotNil(t, python)
+	assert.Equal(t, []string{"python"}, python.Languages)
+	assert.True(t, strings.HasPrefix(python.Version, "3.10."), python.Version)
+
+	var pip bool
+	for _, p := range desc.Packages {
+		pip = pip || (p.Manager == "pip" && p.Name == "pip")
+	}
+	assert.True(t, pip)
+
+	// The sandbox holds the same document
+	res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "cat /run/boxed/descriptor.json"})
+	require.NoError(t, err)
+	var inside client.Descriptor
+	require.NoError(t, json.Unmarshal([]byte(res.Stdout), &inside))
+	assert.Equal(t, id, inside.SandboxID)
+	assert.Equal(t, desc.Interpreters, inside.Interpreters)
+}
+
+func TestWasmDescriptor(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	desc, err := c.Describe(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "wasm", desc.Driver)
+	assert.Equal(t, []client.Interpreter{{Languages: []string{"bash", "sh", "bash-session"}, Command: "bash"}}, desc.Interpreters)
+	assert.Empty(t, desc.Packages)
+	assert.NotNil(t, desc.Limits.ExpiresAt)
+
+	file, err := c.DownloadFile(ctx, sb.ID, "/run/boxed/descriptor.json")
+	require.NoError(t, err)
+	defer file.Close()
+	var inside client.Descriptor
+	require.NoError(t, json.NewDecoder(file).Decode(&inside))
+	assert.Equal(t, sb.ID, inside.SandboxID)
+}
@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesUsage(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim"})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "mkdir -p /workspace && head -c 1048576 /dev/zero > /workspace/data.bin && echo hi > /output/report.txt"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	require.Zero(t, *res.ExitCode, res.Stderr)
+
+	usage, err := c.FilesUsage(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, usage.Paths, 2)
+	ws, out := usage.Paths[0], usage.Paths[1]
+	assert.Equal(t, "/workspace", ws.Path)
+	assert.GreaterOrEqual(t, ws.UsedBytes, int64(1048576))
+	assert.EqualValues(t, 1, ws.Files)
+	assert.Zero(t, ws.LimitBytes)
+	assert.Equal(t, "/output", out.Path)
+	assert.EqualValues(t, 1, out.Files)
+	assert.Positive(t, out.LimitBytes)
+	assert.Positive(t, out.AvailableBytes)
+	assert.Equal(t, ws.UsedBytes+out.UsedBytes, usage.UsedBytes)
+}
+
+func TestWasmFilesUsage(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/src/app.py", strings.NewReader("print(42)\n")))
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/data.csv", strings.NewReader(strings.Repeat("1,2\n", 256))))
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/output/result.json", strings.NewReader("{}")))
+
+	usage, err := c.FilesUsage(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &client.FilesUsage{
+		UsedBytes: 10 + 1024 + 2,
+		Paths: []client.DirUsage{
+			{Path: "/workspace", UsedBytes: 10 + 1024, Files: 2},
+			{Path: "/output", UsedBytes: 2, Files: 1},
+		},
+	}, usage)
+
+	// Paths are cleaned; missing directories use nothing
+	usage, err = c.FilesUsage(ctx, sb.ID, "/workspace/src/", "/nowhere")
+	require.NoError(t, err)
+	assert.Equal(t, []client.DirUsage{{Path: "/workspace/src", UsedBytes: 10, Files: 1}, {Path: "/nowhere"}}, usage.Paths)
+
+	_, err = c.FilesUsage(ctx, sb.ID, "workspace")
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+	_, err = c.FilesUsage(ctx, "missing")
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+}
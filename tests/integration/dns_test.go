@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmDNSRejected(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	// Bad settings are invalid anywhere; wasm has no network to resolve on
+	for _, req := range []client.CreateSandboxRequest{
+		{DNS: []string{"dns.google"}},
+		{DNS: []string{"1.1.1.1", "8.8.8.8", "9.9.9.9", "1.0.0.1"}},
+		{DNSSearch: []string{"bad domain"}},
+		{DNSOptions: []string{"ndots 2"}},
+		{ExtraHosts: map[string]string{"api.internal": "mock"}},
+		{ExtraHosts: map[string]string{"-api": "127.0.0.1"}},
+		{ExtraHosts: map[string]string{"api.internal": "127.0.0.1"}},
+	} {
+		req.Template = "python:3.10-slim"
+		_, err := c.CreateSandbox(ctx, req)
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest), "%+v: got %v", req, err)
+	}
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestSandboxDNS(t *testing.T) {
+	c := client.New(BaseURL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template:   "python:3.10-slim",
+		DNS:        []string{"10.0.0.53"},
+		DNSSearch:  []string{"corp.internal"},
+		DNSOptions: []string{"ndots:2"},
+		ExtraHosts: map[string]string{"api.internal": "127.0.0.1", "db.internal": "10.1.2.3"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "getent hosts api.internal db.internal; cat /etc/resolv.conf"})
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^127\.0\.0\.1\s+api\.internal$`, res.Stdout, res.Stderr)
+	assert.Regexp(t, `(?m)^10\.1\.2\.3\s+db\.internal$`, res.Stdout)
+	assert.Contains(t, res.Stdout, "nameserver 10.0.0.53")
+	assert.Contains(t, res.Stdout, "search corp.internal")
+	assert.Contains(t, res.Stdout, "options ndots:2")
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.53"}, info.Config.DNS)
+	assert.Equal(t, "10.1.2.3", info.Config.ExtraHosts["db.internal"])
+}
@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmDownloadRanges(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	data := strings.Repeat("0123456789", 100)
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/report.json", strings.NewReader(data)))
+
+	r, err := c.DownloadFileRange(ctx, sb.ID, "/workspace/report.json", 995, -1)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "56789", string(got))
+
+	r, err = c.DownloadFileRange(ctx, sb.ID, "/workspace/report.json", 10, 3)
+	require.NoError(t, err)
+	got, err = io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "012", string(got))
+
+	// Headers a browser needs
+	resp, err := http.Head(srv.URL + "/v1/sandbox/" + sb.ID + "/files/content?path=" + url.QueryEscape("/workspace/report.json"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "1000", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, `attachment; filename=report.json`, resp.Header.Get("Content-Disposition"))
+}
+
+func TestWasmDownloadStall(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "", api.WithDownloadStallTimeout(200*time.Millisecond)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	data := strings.Repeat("0123456789", 4<<20)
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/big.bin", strings.NewReader(data)))
+
+	stalled := downloadCount(t, srv.URL, "stalled")
+	complete := downloadCount(t, srv.URL, "complete")
+
+	r, err := c.DownloadFile(ctx, sb.ID, "/workspace/big.bin")
+	require.NoError(t, err)
+	n, err := io.Copy(io.Discard, r)
+	r.Close()
+	require.NoError(t, err)
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, complete+1, downloadCount(t, srv.URL, "complete"))
+
+	// A client that stops reading is given up on, not waited on forever
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /v1/sandbox/"+sb.ID+"/files/content?path=%2Fworkspace%2Fbig.bin HTTP/1.1\r\nHost: boxed\r\n\r\n")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return downloadCount(t, srv.URL, "stalled") == stalled+1
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+// downloadCount scrapes the server's boxed_file_downloads_total for
+// result.
+func downloadCount(t *testing.T, url, result string) float64 {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	prefix := `boxed_file_downloads_total{result="` + result + `"} `
+	for _, line := range strings.Split(string(body), "\n") {
+		if v, ok := strings.CutPrefix(line, prefix); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			require.NoError(t, err)
+			return f
+		}
+	}
+	return 0
+}
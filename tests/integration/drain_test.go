@@ -0,0 +1,92 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWasmServer(t *testing.T) (*api.Handler, *client.Client) {
+	t.Helper()
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	h := api.NewHandler(d, "")
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return h, client.New(srv.URL)
+}
+
+func TestDrain(t *testing.T) {
+	h, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	execDone := make(chan *client.ExecResult, 1)
+	go func() {
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 500ms"})
+		assert.NoError(t, err)
+		execDone <- res
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	drainCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- h.Drain(drainCtx, true) }()
+
+	// New sandboxes are refused while the exec finishes
+	require.Eventually(t, func() bool {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+		return errors.Is(err, client.ErrUnavailable)
+	}, 2*time.Second, 20*time.Millisecond)
+
+	res := <-execDone
+	require.NotNil(t, res)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 0, *res.ExitCode)
+	require.NoError(t, <-drained)
+
+	// The sandbox was stopped on the way out
+	_, err = c.GetSandbox(ctx, sb.ID)
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "reason: server shutdown", events[len(events)-1].Detail)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	h, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	go c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 2s"})
+	time.Sleep(100 * time.Millisecond)
+
+	// Without stopping sandboxes, the long exec is simply abandoned
+	drainCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, h.Drain(drainCtx, false), context.DeadlineExceeded)
+
+	_, err = c.GetSandbox(ctx, sb.ID)
+	assert.NoError(t, err)
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+}
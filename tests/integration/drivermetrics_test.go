@@ -0,0 +1,54 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmDriverMetrics(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	// Every create is slow against a threshold of a nanosecond
+	api.NewHandler(d, "", api.WithSlowDriverOps(map[string]time.Duration{"create": time.Nanosecond})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	require.NoError(t, err)
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`boxed_driver_operations_total{driver="wasm",op="create",result="ok"}`,
+		`boxed_driver_operations_total{driver="wasm",op="connect",result="ok"}`,
+		`boxed_driver_operations_total{driver="wasm",op="stop",result="ok"}`,
+		`boxed_driver_operation_duration_seconds_bucket{driver="wasm",op="create",le="+Inf"}`,
+		`boxed_driver_operation_duration_seconds_count{driver="wasm",op="create"}`,
+		`boxed_driver_slow_operations_total{driver="wasm",op="create"}`,
+	} {
+		assert.Contains(t, string(body), want)
+	}
+}
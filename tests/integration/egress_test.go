@@ -0,0 +1,249 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// egressDriver keeps the egress proxy login of the last sandbox it
+// created, which the API never shows.
+type egressDriver struct {
+	driver.Driver
+
+	mu   sync.Mutex
+	last *driver.EgressProxy
+}
+
+func (d *egressDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	d.mu.Lock()
+	d.last = cfg.EgressProxy
+	d.mu.Unlock()
+	return d.Driver.Create(ctx, cfg)
+}
+
+func (d *egressDriver) login() *driver.EgressProxy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+func TestWasmEgressProxy(t *testing.T) {
+	wd, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { wd.Close() })
+	d := &egressDriver{Driver: wd}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	h := api.NewHandler(d, "")
+	// The test's destination is on loopback, which sandboxes normally
+	// cannot reach
+	ep := h.NewEgressProxy(port, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	go ep.Serve(lis)
+	t.Cleanup(func() { ep.Shutdown(context.Background()) })
+
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from "+r.URL.Path)
+	}))
+	t.Cleanup(dest.Close)
+	destAddr := dest.Listener.Addr().String()
+
+	// Sandboxes without internet access get no login
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	assert.Nil(t, d.login())
+
+	sb, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template:      "python:3.10-slim",
+		NetworkPolicy: client.NetworkPolicy{EnableInternet: true},
+	})
+	require.NoError(t, err)
+	login := d.login()
+	require.NotNil(t, login)
+	assert.Equal(t, port, login.Port)
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, fmt.Sprint(info), login.Password)
+
+	proxyURL := &url.URL{Scheme: "http", User: url.UserPassword(login.User, login.Password), Host: lis.Addr().String()}
+	get := func(proxy *url.URL, target string) (int, string) {
+		t.Helper()
+		hc := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+		resp, err := hc.Get(target)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Plain HTTP is forwarded
+	code, body := get(proxyURL, "http://"+destAddr+"/plain")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello from /plain", body)
+
+	// Without the sandbox's password there is no way out
+	code, _ = get(&url.URL{Scheme: "http", User: url.UserPassword(login.User, "wrong"), Host: lis.Addr().String()}, "http://"+destAddr+"/")
+	assert.Equal(t, http.StatusProxyAuthRequired, code)
+
+	// CONNECT tunnels, but not to the metadata service or private networks
+	assert.Equal(t, "200", connectVia(t, lis.Addr().String(), login, destAddr))
+	assert.Equal(t, "403", connectVia(t, lis.Addr().String(), login, "169.254.169.254:80"))
+	assert.Equal(t, "403", connectVia(t, lis.Addr().String(), login, "10.1.2.3:443"))
+	assert.Equal(t, "403", connectVia(t, lis.Addr().String(), login, "[fd00:ec2::254]:80"))
+
+	// SOCKS5 likewise
+	assert.Equal(t, byte(0), socksConnect(t, lis.Addr().String(), login, destAddr))
+	assert.Equal(t, byte(2), socksConnect(t, lis.Addr().String(), login, "169.254.169.254:80"))
+
+	// allow_domains limits the hosts a sandbox reaches
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template:      "python:3.10-slim",
+		NetworkPolicy: client.NetworkPolicy{EnableInternet: true, AllowDomains: []string{"*.example.com"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "403", connectVia(t, lis.Addr().String(), d.login(), destAddr))
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template:      "python:3.10-slim",
+		NetworkPolicy: client.NetworkPolicy{EnableInternet: true, AllowDomains: []string{"bad domain"}},
+	})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+
+	// The login ends with the sandbox
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+	code, _ = get(proxyURL, "http://"+destAddr+"/")
+	assert.Equal(t, http.StatusProxyAuthRequired, code)
+
+	metrics, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer metrics.Body.Close()
+	text, _ := io.ReadAll(metrics.Body)
+	assert.Contains(t, string(text), `boxed_egress_connections_total{result="blocked"}`)
+	assert.Contains(t, string(text), `boxed_egress_connections_total{result="unauthorized"}`)
+}
+
+// connectVia sends a CONNECT request for dest to the HTTP proxy at proxy
+// and returns the status code of the answer.
+func connectVia(t *testing.T, proxy string, login *driver.EgressProxy, dest string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy)
+	require.NoError(t, err)
+	defer conn.Close()
+	req, _ := http.NewRequest(http.MethodConnect, "", nil)
+	req.Host = dest
+	req.URL = &url.URL{Host: dest}
+	req.SetBasicAuth(login.User, login.Password)
+	req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+	req.Header.Del("Authorization")
+	require.NoError(t, req.Write(conn))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// socksConnect asks the SOCKS5 proxy at proxy to connect to dest and
+// returns the reply code.
+func socksConnect(t *testing.T, proxy string, login *driver.EgressProxy, dest string) byte {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{5, 1, 2})
+	require.NoError(t, err)
+	var b [2]byte
+	_, err = io.ReadFull(conn, b[:])
+	require.NoError(t, err)
+	require.Equal(t, [2]byte{5, 2}, b)
+
+	auth := append([]byte{1, byte(len(login.User))}, login.User...)
+	auth = append(append(auth, byte(len(login.Password))), login.Password...)
+	_, err = conn.Write(auth)
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, b[:])
+	require.NoError(t, err)
+	require.Equal(t, [2]byte{1, 0}, b)
+
+	addr := netip.MustParseAddrPort(dest)
+	ip := addr.Addr().As4()
+	req := append([]byte{5, 1, 0, 1}, ip[:]...)
+	req = binary.BigEndian.AppendUint16(req, addr.Port())
+	_, err = conn.Write(req)
+	require.NoError(t, err)
+	var reply [10]byte
+	_, err = io.ReadFull(conn, reply[:])
+	require.NoError(t, err)
+	return reply[1]
+}
+
+func TestEgressProxy(t *testing.T) {
+	lis, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	h := api.NewHandler(testDriver, "")
+	ep := h.NewEgressProxy(lis.Addr().(*net.TCPAddr).Port, nil)
+	go ep.Serve(lis)
+	t.Cleanup(func() { ep.Shutdown(context.Background()) })
+
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template:      "python:3.10-slim",
+		NetworkPolicy: client.NetworkPolicy{EnableInternet: true},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+
+	// Tools find the proxy, which refuses the metadata service; there is
+	// no way around it
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: `
+import os, socket, urllib.error, urllib.request
+print("HTTPS_PROXY" in os.environ)
+try:
+    urllib.request.urlopen("http://169.254.169.254/latest/meta-data/", timeout=10)
+except urllib.error.HTTPError as e:
+    print(e.code)
+s = socket.socket()
+s.settimeout(3)
+try:
+    s.connect(("1.1.1.1", 80))
+    print("direct")
+except OSError:
+    print("no route")`})
+	require.NoError(t, err)
+	assert.Equal(t, "True\n403\nno route\n", res.Stdout, res.Stderr)
+}
@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/pkg/boxed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedEngine(t *testing.T) {
+	ctx := context.Background()
+	eng, err := boxed.New(ctx, boxed.Options{})
+	require.NoError(t, err)
+	defer eng.Close()
+
+	sb, err := eng.CreateSandbox(ctx, boxed.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Timeout:  120,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ready", sb.Status)
+
+	res, err := eng.Exec(ctx, sb.SandboxID, boxed.ExecRequest{Language: "python", Code: "print(6*7)"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "42")
+
+	// The same engine can also serve the REST API
+	srv := httptest.NewServer(eng.HTTPHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/v1/sandbox/" + sb.SandboxID + "/execs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, eng.StopSandbox(ctx, sb.SandboxID))
+
+	_, err = eng.Exec(ctx, sb.SandboxID, boxed.ExecRequest{Language: "python", Code: "1"})
+	assert.True(t, errors.Is(err, boxed.ErrSandboxNotFound), "got %v", err)
+
+	var apiErr *boxed.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "sandbox_not_found", apiErr.Code)
+
+	// Exec honours the caller's deadline
+	sb, err = eng.CreateSandbox(ctx, boxed.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: 120})
+	require.NoError(t, err)
+	defer eng.StopSandbox(ctx, sb.SandboxID)
+
+	shortCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	_, err = eng.Exec(shortCtx, sb.SandboxID, boxed.ExecRequest{Language: "python", Code: "import time; time.sleep(5)"})
+	assert.True(t, errors.Is(err, boxed.ErrTimeout), "got %v", err)
+}
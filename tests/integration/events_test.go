@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmLifecycleEvents(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	h := api.NewHandler(d, "")
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, unsubscribe, err := h.SubscribeEvents(api.EventFilter{Labels: []string{"team=ml"}})
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	// Unknown types are refused
+	err = c.SubscribeEvents(ctx, func(client.LifecycleEvent) error { return nil }, client.EventTypeFilter("bogus"))
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+
+	stopped := make(chan client.LifecycleEvent, 1)
+	go c.SubscribeEvents(ctx, func(ev client.LifecycleEvent) error {
+		stopped <- ev
+		return errors.New("done")
+	}, client.LabelFilter("team", "ml"), client.EventTypeFilter(client.EventStopped))
+
+	other, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute})
+	require.NoError(t, err)
+	// A TTL shorter than the warning lead gets the warning once ready
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Timeout:  2 * time.Second,
+		Metadata: map[string]string{"team": "ml"},
+	})
+	require.NoError(t, err)
+
+	var types []string
+	for len(types) < 4 {
+		select {
+		case ev := <-events:
+			require.Equal(t, sb.ID, ev.SandboxID, "events of unlabeled sandboxes are filtered out")
+			assert.Equal(t, "ml", ev.Labels["team"])
+			types = append(types, ev.Type)
+			switch ev.Type {
+			case api.LifecycleTTLWarning:
+				require.NotNil(t, ev.ExpiresAt)
+			case api.LifecycleStopped:
+				assert.Equal(t, driver.GCReasonExpired, ev.Reason)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out after events %v", types)
+		}
+	}
+	assert.Equal(t, []string{api.LifecycleCreated, api.LifecycleReady, api.LifecycleTTLWarning, api.LifecycleStopped}, types)
+
+	select {
+	case ev := <-stopped:
+		assert.Equal(t, sb.ID, ev.SandboxID)
+		assert.Equal(t, client.EventStopped, ev.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client received no stopped event")
+	}
+
+	// Over a WebSocket, each event is a JSON message
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/events?type=stopped", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	require.NoError(t, c.DeleteSandbox(ctx, other.ID))
+	var ev client.LifecycleEvent
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, ws.ReadJSON(&ev))
+	assert.Equal(t, other.ID, ev.SandboxID)
+	assert.Equal(t, "api", ev.Reason)
+
+	// Draining ends the streams
+	require.NoError(t, h.Drain(context.Background(), false))
+	_, open := <-events
+	assert.False(t, open)
+}
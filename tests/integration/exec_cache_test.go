@@ -0,0 +1,66 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmExecCache(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	newSandbox := func(files []client.FileInjection) string {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Context: files})
+		require.NoError(t, err)
+		return sb.ID
+	}
+	a, b := newSandbox(nil), newSandbox(nil)
+	req := client.ExecRequest{Language: "bash", Code: "setup", Cache: true}
+
+	first, err := c.Exec(ctx, a, req)
+	require.NoError(t, err)
+	assert.False(t, first.Cached)
+	require.Len(t, first.Artifacts, 1)
+
+	second, err := c.Exec(ctx, b, req)
+	require.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Equal(t, first.Stdout, second.Stdout)
+	assert.Equal(t, first.Artifacts, second.Artifacts)
+
+	// The code did not run in b
+	_, err = c.DownloadFile(ctx, b, "/output/last.txt")
+	assert.Error(t, err)
+	execs, err := c.ListExecs(ctx, b)
+	require.NoError(t, err)
+	require.Len(t, execs, 1)
+	assert.True(t, execs[0].Cached)
+
+	// Without the flag the code runs
+	res, err := c.Exec(ctx, b, client.ExecRequest{Language: "bash", Code: "setup"})
+	require.NoError(t, err)
+	assert.False(t, res.Cached)
+
+	// Other context files, other cache entry
+	other := newSandbox([]client.FileInjection{
+		{Path: "/workspace/data.txt", ContentBase64: base64.StdEncoding.EncodeToString([]byte("x"))},
+	})
+	res, err = c.Exec(ctx, other, req)
+	require.NoError(t, err)
+	assert.False(t, res.Cached)
+
+	// Failures are not cached
+	req.Code = "fail"
+	_, err = c.Exec(ctx, a, req)
+	require.NoError(t, err)
+	res, err = c.Exec(ctx, b, req)
+	require.NoError(t, err)
+	assert.False(t, res.Cached)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 3, *res.ExitCode)
+}
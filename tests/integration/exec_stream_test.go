@@ -0,0 +1,201 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/proto"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmExecStream(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	// The fake shell echoes its input
+	var stdout strings.Builder
+	exit, err := c.ExecStream(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo hi"}, func(ev client.ExecEvent) error {
+		if ev.Type == client.ExecEventStdout {
+			stdout.WriteString(ev.Chunk)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "echo hi\n", stdout.String())
+	require.NotNil(t, exit.ExitCode)
+	assert.Equal(t, 0, *exit.ExitCode)
+	assert.Equal(t, int64(len("echo hi\n")), exit.StdoutBytes)
+
+	// The streamed exec is recorded like any other
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.Len(t, execs, 1)
+	assert.Equal(t, "echo hi\n", execs[0].Stdout)
+
+	// Each event is its own line
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/sandbox/"+sb.ID+"/exec", bytes.NewBufferString(`{"language":"bash","code":"echo again"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	lines := strings.Split(strings.TrimSpace(body.String()), "\n")
+	assert.Contains(t, lines, `{"type":"stdout","chunk":"echo again\n"}`)
+	assert.Contains(t, lines[len(lines)-1], `"type":"exit"`)
+
+	// Failures before any output keep their status
+	_, err = c.ExecStream(ctx, "sb-missing", client.ExecRequest{Language: "bash", Code: "true"}, func(client.ExecEvent) error { return nil })
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	_, err = c.ExecStream(ctx, sb.ID, client.ExecRequest{Language: "cobol", Code: "x"}, func(client.ExecEvent) error { return nil })
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+}
+
+func TestWasmExecCancel(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	// The agent kills the exec and reports why
+	infos, err := d.List(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	conn, err := d.Connect(ctx, infos[0].ID)
+	require.NoError(t, err)
+	defer conn.Close()
+	exec, _ := json.Marshal(proto.NewRequest("exec", map[string]any{"cmd": "bash", "args": []string{"-c", "sleep 5s"}}, 1))
+	conn.Write(append(exec, '\n'))
+	time.Sleep(200 * time.Millisecond)
+	cancel, _ := json.Marshal(proto.NewNotification(proto.MethodExecCancel, nil))
+	conn.Write(append(cancel, '\n'))
+	scanner := bufio.NewScanner(conn)
+	var exit map[string]any
+	for exit == nil && scanner.Scan() {
+		var msg struct {
+			Method string         `json:"method"`
+			Params map[string]any `json:"params"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+		if msg.Method == "exit" {
+			exit = msg.Params
+		}
+	}
+	require.NotNil(t, exit)
+	assert.Equal(t, driver.ExitCanceled, exit["reason"])
+	assert.Equal(t, float64(137), exit["code"])
+
+	// A client that goes away takes its exec with it
+	reqCtx, stop := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		stop()
+	}()
+	_, err = c.Exec(reqCtx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 1500ms"})
+	require.Error(t, err)
+	time.Sleep(2 * time.Second)
+
+	// The sleep never got to write its output file
+	_, err = c.DownloadFile(ctx, sb.ID, "/output/last.txt")
+	assert.ErrorContains(t, err, "not found")
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, execs)
+	assert.Equal(t, "canceled", execs[len(execs)-1].Error)
+}
+
+// chattyShell prints until it is killed, unless its code is "true".
+const chattyShell = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if os.Args[len(os.Args)-1] == "true" {
+		return
+	}
+	for {
+		fmt.Println("chunk")
+	}
+}
+`
+
+func TestWasmExecCancelOutput(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", chattyShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	// Loads the module, which takes long with -race
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "true"})
+	require.NoError(t, err)
+
+	// Output still arriving when the client goes away is dropped with the
+	// exec rather than written as the request ends; run with -race
+	for i := 0; i < 3; i++ {
+		reqCtx, stop := context.WithTimeout(ctx, 300*time.Millisecond)
+		_, err = c.Exec(reqCtx, sb.ID, client.ExecRequest{Language: "bash", Code: "spin"})
+		stop()
+		require.Error(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		reqCtx, stop := context.WithTimeout(ctx, 300*time.Millisecond)
+		_, err = c.ExecStream(reqCtx, sb.ID, client.ExecRequest{Language: "bash", Code: "spin"}, func(client.ExecEvent) error { return nil })
+		stop()
+		require.Error(t, err)
+	}
+	require.Eventually(t, func() bool {
+		execs, err := c.ListExecs(ctx, sb.ID)
+		return err == nil && len(execs) == 7
+	}, 5*time.Second, 50*time.Millisecond)
+}
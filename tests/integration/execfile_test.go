@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmExecFile(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/run.sh", strings.NewReader("echo hi\n")))
+
+	// The fake shell echoes its last argument; the language comes from the
+	// extension
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{File: "/workspace/run.sh", Args: []string{"first", "it's \"quoted\""}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, *res.ExitCode)
+	assert.Equal(t, "it's \"quoted\"\n", res.Stdout)
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", File: "run.sh", Args: []string{"relative"}})
+	require.NoError(t, err)
+	assert.Equal(t, "relative\n", res.Stdout)
+
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.Len(t, execs, 2)
+	assert.Equal(t, "bash", execs[0].Language)
+	assert.Equal(t, "/workspace/run.sh", execs[0].File)
+	assert.Equal(t, []string{"first", "it's \"quoted\""}, execs[0].Args)
+
+	for _, req := range []client.ExecRequest{
+		{Code: "echo", File: "/workspace/run.sh"},
+		{Language: "bash", Code: "echo", Args: []string{"x"}},
+		{File: "/workspace/data.txt"},
+		{Language: api.LanguagePythonSession, File: "/workspace/run.py"},
+		{Language: "bash", File: "-c"},
+	} {
+		_, err := c.Exec(ctx, sb.ID, req)
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest), "%+v: got %v", req, err)
+	}
+}
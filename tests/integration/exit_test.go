@@ -0,0 +1,48 @@
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecExitReasons(t *testing.T) {
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "exit 3"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 3, *res.ExitCode)
+	assert.Empty(t, res.ExitReason)
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "kill -TERM $$"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 143, *res.ExitCode)
+	assert.Equal(t, "signaled", res.ExitReason)
+	assert.Equal(t, 15, res.Signal)
+
+	// The sandbox has the default 512 MB limit; the agent survives the kill
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "x = b'x' * (2 << 30)"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 137, *res.ExitCode)
+	assert.Equal(t, "oom_killed", res.ExitReason)
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "print('still here')"})
+	require.NoError(t, err)
+	assert.Equal(t, "still here\n", res.Stdout)
+
+	history, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	assert.Equal(t, "oom_killed", history[2].ExitReason)
+}
@@ -0,0 +1,102 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/shortid"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFaultServer starts a server on its own Docker driver with the given
+// BOXED_TEST_FAULTS spec.
+func newFaultServer(t *testing.T, faults string) (driver.Driver, *client.Client) {
+	t.Helper()
+	d, err := driver.NewDriver("docker", map[string]any{
+		"faults":          faults,
+		"cleanup_orphans": false,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return d, client.New(srv.URL)
+}
+
+func TestFaultStopDuringExec(t *testing.T) {
+	d, c := newFaultServer(t, "stop_during_exec=500ms")
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+
+	// The exec ends when the container dies, well before the sleep does
+	start := time.Now()
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "import time; time.sleep(30)"})
+	require.NoError(t, err)
+	assert.Nil(t, res.ExitCode)
+	assert.Equal(t, "sandbox_died", res.ExitReason)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	got, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	info, err := d.Info(ctx, got.BackendID)
+	require.NoError(t, err)
+	assert.NotEqual(t, driver.StateReady, info.State)
+}
+
+func TestFaultSlowPull(t *testing.T) {
+	d, _ := newFaultServer(t, "slow_pull=1m")
+	images, ok := d.(driver.ImageManager)
+	require.True(t, ok)
+
+	// Cancelling a pull ends the injected wait
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := images.PullImage(ctx, "python:3.10-slim", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestFaultFlakyDaemon(t *testing.T) {
+	d, c := newFaultServer(t, "flaky_daemon")
+	ctx := context.Background()
+
+	// Every inspect, list, pull and create loses its first answer, and is
+	// retried
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "print(1)"})
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", res.Stdout)
+
+	// The retried create found the container of its first attempt rather
+	// than making another
+	list, err := d.List(ctx, nil)
+	require.NoError(t, err)
+	n := 0
+	for _, info := range list {
+		if info.Config.Labels[shortid.Label] == sb.ID {
+			n++
+		}
+	}
+	assert.Equal(t, 1, n)
+}
+
+func TestFaultUnknown(t *testing.T) {
+	_, err := driver.NewDriver("docker", map[string]any{"faults": "flaky_disk", "cleanup_orphans": false})
+	assert.ErrorContains(t, err, "unknown fault")
+}
@@ -2,6 +2,7 @@ package integration
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -98,6 +100,20 @@ func TestFilesystem(t *testing.T) {
 	// Normalize stdout
 	assert.Equal(t, uploadContent, strings.TrimSpace(execResp.Stdout))
 
+	// Several files in one request, written as one tar stream
+	paths, err := client.New("http://localhost:"+ServerPort).UploadFiles(context.Background(), id, []client.UploadEntry{
+		{Path: "/workspace/src/main.py", Content: strings.NewReader("print('main')")},
+		{Path: "/workspace/lib/main.py", Content: strings.NewReader("print('lib')")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/workspace/src/main.py", "/workspace/lib/main.py"}, paths)
+	execBody, _ = json.Marshal(map[string]string{"language": "python", "code": "print(open('src/main.py').read() + open('lib/main.py').read(), end='')"})
+	resp, err = http.Post(fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), "application/json", bytes.NewReader(execBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	json.NewDecoder(resp.Body).Decode(&execResp)
+	assert.Equal(t, "print('main')print('lib')", execResp.Stdout)
+
 	// 3. List Files
 	t.Log("Testing List Files...")
 	resp, err = http.Get(fmt.Sprintf("%s/sandbox/%s/files?path=/", BaseURL, id))
@@ -0,0 +1,143 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fsShell runs "write <path> <text>" and "rm <path>", which removes path
+// and what it holds.
+const fsShell = `package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	args := strings.SplitN(os.Args[len(os.Args)-1], " ", 3)
+	var err error
+	switch {
+	case args[0] == "write" && len(args) == 3:
+		err = os.WriteFile(args[1], []byte(args[2]), 0644)
+	case args[0] == "rm" && len(args) == 2:
+		err = os.RemoveAll(args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`
+
+func TestFSDiff(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim"})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "mkdir -p /srv/out && echo hi > /srv/out/report.txt && echo x > /output/x && rm -rf /etc/apt"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	require.Zero(t, *res.ExitCode, res.Stderr)
+
+	changes, err := c.FSDiff(ctx, id, "")
+	require.NoError(t, err)
+	assert.Contains(t, changes, client.FSChange{Path: "/srv/out/report.txt", Kind: "added"})
+	assert.Contains(t, changes, client.FSChange{Path: "/etc/apt", Kind: "removed"})
+	for _, ch := range changes {
+		assert.False(t, strings.HasPrefix(ch.Path, "/output"), "got %v", ch)
+		assert.False(t, strings.HasPrefix(ch.Path, "/etc/apt/"), "got %v", ch)
+	}
+
+	changes, err = c.FSDiff(ctx, id, "/srv")
+	require.NoError(t, err)
+	assert.Equal(t, []client.FSChange{
+		{Path: "/srv", Kind: "changed"},
+		{Path: "/srv/out", Kind: "added"},
+		{Path: "/srv/out/report.txt", Kind: "added"},
+	}, changes)
+}
+
+func TestWasmFSDiff(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", fsShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	path := filepath.Join(t.TempDir(), "templates.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+default: small
+templates:
+  small:
+    image: python:3.10-slim
+`), 0o644))
+	catalog, err := templates.Load(path)
+	require.NoError(t, err)
+	e := echo.New()
+	api.NewHandler(d, "", api.WithTemplates(catalog)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	run := func(id, code string) {
+		t.Helper()
+		res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: code})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExitCode)
+		require.Zero(t, *res.ExitCode, res.Stderr)
+	}
+
+	// The image the sandbox starts from has /opt/app and /opt/cache
+	base, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "small"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(ctx, base.ID) })
+	for _, f := range []string{"/opt/app/main.py", "/opt/app/util.py", "/opt/cache/blob"} {
+		require.NoError(t, c.UploadFile(ctx, base.ID, f, strings.NewReader("v1")))
+	}
+	_, err = c.PublishTemplate(ctx, base.ID, client.PublishTemplateRequest{Name: "app"})
+	require.NoError(t, err)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "app:latest"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+	changes, err := c.FSDiff(ctx, sb.ID, "/opt")
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	run(sb.ID, "write /opt/app/main.py v2")
+	run(sb.ID, "write /opt/app/report.txt done")
+	run(sb.ID, "rm /opt/cache")
+	run(sb.ID, "write /output/result.txt untracked")
+	changes, err = c.FSDiff(ctx, sb.ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, []client.FSChange{
+		{Path: "/opt", Kind: "changed"},
+		{Path: "/opt/app", Kind: "changed"},
+		{Path: "/opt/app/main.py", Kind: "changed"},
+		{Path: "/opt/app/report.txt", Kind: "added"},
+		{Path: "/opt/cache", Kind: "removed"},
+	}, changes)
+
+	changes, err = c.FSDiff(ctx, sb.ID, "/opt/app/")
+	require.NoError(t, err)
+	assert.Len(t, changes, 3)
+	_, err = c.FSDiff(ctx, sb.ID, "opt")
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.FSDiff(ctx, "missing", "")
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+}
@@ -0,0 +1,137 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCDryRunAndReport(t *testing.T) {
+	resp := postJSON(t, BaseURL+"/admin/gc", map[string]any{"dry_run": true})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var run api.GCRun
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&run))
+	assert.True(t, run.DryRun)
+	assert.NotNil(t, run.Items)
+
+	report, err := http.Get(BaseURL + "/admin/gc/report")
+	require.NoError(t, err)
+	defer report.Body.Close()
+	var r api.GCReport
+	require.NoError(t, json.NewDecoder(report.Body).Decode(&r))
+	require.NotEmpty(t, r.Runs)
+	assert.Equal(t, run.ID, r.Runs[0].ID)
+
+	metrics, err := http.Get("http://localhost:" + ServerPort + "/metrics")
+	require.NoError(t, err)
+	defer metrics.Body.Close()
+	body, _ := io.ReadAll(metrics.Body)
+	assert.Contains(t, string(body), `boxed_gc_runs_total{trigger="api",dry_run="true"}`)
+}
+
+// TestWasmGC covers orphan removal and TTL reaping without Docker: the wasm
+// driver keeps sandboxes as directories under its root.
+func TestWasmGC(t *testing.T) {
+	root := t.TempDir()
+	orphan := filepath.Join(root, "wasm-0123456789abcdef")
+	require.NoError(t, os.MkdirAll(filepath.Join(orphan, "output"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(orphan, "output", "left.bin"), make([]byte, 4096), 0644))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(orphan, old, old))
+
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": t.TempDir(),
+		"root_dir":    root,
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	h := api.NewHandler(d, "")
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	ctx := context.Background()
+
+	// A dry run reports the orphan and leaves it in place
+	run, err := h.RunGC(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, run.Items, 1)
+	assert.Equal(t, orphan, run.Items[0].ID)
+	assert.Equal(t, driver.GCReasonOrphaned, run.Items[0].Reason)
+	assert.Equal(t, int64(4096), run.ReclaimedBytes)
+	assert.DirExists(t, orphan)
+
+	run, err = h.RunGC(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, run.Removed)
+	assert.NoDirExists(t, orphan)
+
+	// An expiring sandbox shows up in the report and its timeline
+	c := client.New(srv.URL)
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Second})
+	require.NoError(t, err)
+	var report api.GCReport
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(srv.URL + "/v1/admin/gc/report")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		return json.NewDecoder(resp.Body).Decode(&report) == nil && len(report.Reaped) > 0
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Len(t, report.Reaped, 1)
+	assert.Equal(t, sb.ID, report.Reaped[0].ID)
+	assert.Equal(t, driver.GCReasonExpired, report.Reaped[0].Reason)
+	assert.Equal(t, 1, report.Totals.Runs)
+	assert.Equal(t, 2, report.Totals.Removed)
+
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	last := events[len(events)-1]
+	assert.Equal(t, "stopped", last.Type)
+	assert.Equal(t, "reason: ttl_expired", last.Detail)
+}
+
+func TestWasmGCAdmin(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": t.TempDir(), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+	e := echo.New()
+	api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// Collection acts on every owner's resources: admins only
+	for key, want := range map[string]int{"alice-key": http.StatusForbidden, "ops-key": http.StatusOK} {
+		for _, route := range [][2]string{{http.MethodPost, "/v1/admin/gc"}, {http.MethodGet, "/v1/admin/gc/report"}} {
+			req, err := http.NewRequest(route[0], srv.URL+route[1], strings.NewReader(`{"dry_run":true}`))
+			require.NoError(t, err)
+			req.Header.Set("X-Boxed-API-Key", key)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, want, resp.StatusCode, "%s %s", key, req.URL.Path)
+		}
+	}
+}
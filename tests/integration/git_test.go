@@ -0,0 +1,179 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitServer serves a repository over git's smart HTTP protocol, asking
+// for token as the password. The repository has a README on main, a tag v1
+// before it changed, and a branch dev. It returns the server URL and the
+// commit of v1.
+func newGitServer(t *testing.T, token string) (string, string) {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(work, "src"), 0755))
+	git(work, "init", "-q", "-b", "main")
+	write := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(content), 0644))
+	}
+	write("README.md", "first\n")
+	write("src/main.py", "print('hi')\n")
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "first")
+	git(work, "tag", "v1")
+	v1 := git(work, "rev-parse", "HEAD")
+	write("README.md", "second\n")
+	git(work, "commit", "-q", "-am", "second")
+	git(work, "branch", "dev", v1)
+
+	bare := filepath.Join(root, "repo.git")
+	git(root, "clone", "-q", "--bare", work, bare)
+	git(bare, "config", "uploadpack.allowReachableSHA1InWant", "true")
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/repo.git", v1
+}
+
+func TestWasmGitContext(t *testing.T) {
+	repo, v1 := newGitServer(t, "s3cret")
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithGitAllowCIDRs([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	read := func(id, path string) string {
+		t.Helper()
+		r, err := c.DownloadFile(ctx, id, path)
+		require.NoError(t, err, path)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Git:      &client.GitSource{URL: repo, Token: "s3cret"},
+		Context:  []client.FileInjection{{Path: "README.md", ContentBase64: "b3ZlcnJpZGRlbgo="}},
+	})
+	require.NoError(t, err)
+	// Context files go on top of the repository
+	assert.Equal(t, "overridden\n", read(sb.ID, "/workspace/README.md"))
+	assert.Equal(t, "print('hi')\n", read(sb.ID, "/workspace/src/main.py"))
+	assert.Equal(t, "ref: refs/heads/main\n", read(sb.ID, "/workspace/.git/HEAD"))
+	// The token is not kept
+	assert.NotContains(t, read(sb.ID, "/workspace/.git/config"), "s3cret")
+
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Contains(t, events[0].Detail, "git "+repo)
+
+	for _, ref := range []string{"v1", "dev", v1} {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+			Template: "python:3.10-slim",
+			Git:      &client.GitSource{URL: repo, Ref: ref, Token: "s3cret"},
+		})
+		require.NoError(t, err, ref)
+		assert.Equal(t, "first\n", read(sb.ID, "/workspace/README.md"), ref)
+		if ref != "dev" {
+			// A detached HEAD still leaves a ref for git to find the repository by
+			assert.Equal(t, v1+"\n", read(sb.ID, "/workspace/.git/refs/remotes/origin/HEAD"), ref)
+		}
+	}
+
+	// Names are cloned from the addresses they resolve to that are allowed
+	sb, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Git:      &client.GitSource{URL: strings.Replace(repo, "127.0.0.1", "localhost", 1), Token: "s3cret"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", read(sb.ID, "/workspace/README.md"))
+
+	// Redirects are not followed, as they could lead anywhere
+	public, _ := newGitServer(t, "")
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.TrimSuffix(public, "/repo.git")+r.URL.RequestURI(), http.StatusFound)
+	}))
+	t.Cleanup(redirect.Close)
+
+	for _, src := range []client.GitSource{
+		{URL: repo},
+		{URL: repo, Ref: "nope", Token: "s3cret"},
+		{URL: "file:///etc"},
+		{URL: repo, Ref: "--upload-pack=touch", Token: "s3cret"},
+		{URL: redirect.URL + "/repo.git"},
+	} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Git: &src})
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest), "%+v: got %v", src, err)
+	}
+}
+
+func TestWasmGitContextPrivateHosts(t *testing.T) {
+	repo, _ := newGitServer(t, "s3cret")
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	// Hosts off the internet are refused before git connects to them
+	for _, url := range []string{
+		repo,
+		"http://localhost/repo.git",
+		"http://169.254.169.254/latest/meta-data",
+		"https://10.0.0.1/repo.git",
+		"http://[::1]/repo.git",
+	} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Git: &client.GitSource{URL: url, Token: "s3cret"}})
+		require.ErrorIs(t, err, client.ErrInvalidRequest, url)
+		assert.ErrorContains(t, err, "is not on the internet", url)
+	}
+}
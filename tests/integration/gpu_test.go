@@ -0,0 +1,26 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmGPURejected(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	// A count below 1 is invalid anywhere; wasm has no GPUs to give
+	for _, gpu := range []client.GPURequest{{Count: 0}, {Count: 1}, {Count: 1, Type: "a100"}} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", GPU: &gpu})
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest), "%+v: got %v", gpu, err)
+	}
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
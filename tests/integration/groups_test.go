@@ -0,0 +1,123 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmSessionGroups(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+	})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	ctx := context.Background()
+
+	g, err := alice.CreateGroup(ctx, client.CreateGroupRequest{ID: "conv-42", TTL: 600, Metadata: map[string]string{"agent": "planner"}})
+	require.NoError(t, err)
+	assert.Equal(t, "empty", g.Status)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), g.ExpiresAt, 5*time.Second)
+	_, err = alice.CreateGroup(ctx, client.CreateGroupRequest{ID: "conv-42"})
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+	_, err = alice.CreateGroup(ctx, client.CreateGroupRequest{ID: "no spaces"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	// Sandboxes join with the group's lifetime, which they cannot set
+	var ids []string
+	for range 2 {
+		sb, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Session: "conv-42"})
+		require.NoError(t, err)
+		ids = append(ids, sb.ID)
+	}
+	_, err = alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Session: "conv-42", Timeout: time.Minute})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{api.GroupLabel: "conv-42"}})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = bob.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Session: "conv-42"})
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	other, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	t.Cleanup(func() { alice.DeleteSandbox(ctx, other.ID) })
+
+	g, err = alice.GetGroup(ctx, "conv-42")
+	require.NoError(t, err)
+	assert.Equal(t, "ready", g.Status)
+	assert.Equal(t, map[string]string{"agent": "planner"}, g.Metadata)
+	assert.Equal(t, map[string]int{"ready": 2}, g.States)
+	require.Len(t, g.Sandboxes, 2)
+	for _, sb := range g.Sandboxes {
+		assert.Contains(t, ids, sb.ID)
+		require.NotNil(t, sb.ExpiresAt)
+		assert.WithinDuration(t, g.ExpiresAt, *sb.ExpiresAt, 2*time.Second)
+	}
+	_, err = bob.GetGroup(ctx, "conv-42")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+
+	// The TTL moves for the group and its sandboxes together
+	ttl, err := alice.SetGroupTTL(ctx, "conv-42", 20*time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, ttl.Failed)
+	assert.WithinDuration(t, time.Now().Add(20*time.Minute), ttl.ExpiresAt, 5*time.Second)
+	for _, id := range ids {
+		sb, err := alice.GetSandbox(ctx, id)
+		require.NoError(t, err)
+		assert.WithinDuration(t, ttl.ExpiresAt, *sb.ExpiresAt, 2*time.Second)
+	}
+
+	list, err := alice.ListGroups(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "conv-42", list[0].ID)
+	assert.Equal(t, map[string]int{"ready": 2}, list[0].States)
+	assert.Empty(t, list[0].Sandboxes)
+	list, err = bob.ListGroups(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	// Deleting the group stops its sandboxes, and only those
+	res, err := alice.DeleteGroup(ctx, "conv-42")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, ids, res.Stopped)
+	_, err = alice.GetGroup(ctx, "conv-42")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	_, err = alice.GetSandbox(ctx, ids[0])
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	info, err := alice.GetSandbox(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", info.State)
+
+	// Sandboxes of an expired group are stopped with it
+	g, err = alice.CreateGroup(ctx, client.CreateGroupRequest{TTL: 2})
+	require.NoError(t, err)
+	assert.Regexp(t, `^grp_[0-9a-f]{16}$`, g.ID)
+	sb, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Session: g.ID})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := alice.GetGroup(ctx, g.ID)
+		return errors.Is(err, client.ErrNotFound)
+	}, 5*time.Second, 100*time.Millisecond)
+	require.Eventually(t, func() bool {
+		_, err := alice.GetSandbox(ctx, sb.ID)
+		return errors.Is(err, client.ErrSandboxNotFound)
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,119 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmGRPC(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "secret")
+	e := echo.New()
+	h.RegisterRoutes(e)
+	gs := h.NewGRPCServer()
+	t.Cleanup(gs.Stop)
+	api.MountGRPC(e, gs)
+	srv := httptest.NewUnstartedServer(e)
+	srv.Config.Protocols = e.Server.Protocols
+	srv.Start()
+	t.Cleanup(srv.Close)
+	target := strings.TrimPrefix(srv.URL, "http://")
+	ctx := context.Background()
+
+	// Calls without the API key are refused
+	anon, err := client.DialGRPC(target, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { anon.Close() })
+	_, err = anon.ListSandboxes(ctx)
+	assert.True(t, errors.Is(err, client.ErrUnauthorized))
+
+	g, err := client.DialGRPC(target, []client.Option{client.WithAPIKey("secret")})
+	require.NoError(t, err)
+	t.Cleanup(func() { g.Close() })
+
+	sb, err := g.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	assert.Equal(t, "ready", sb.State)
+
+	// The REST API is still served on the same port
+	rest := client.New(srv.URL, client.WithAPIKey("secret"))
+	got, err := rest.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sb.ID, got.ID)
+
+	got, err = g.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", got.State)
+	list, err := g.ListSandboxes(ctx, client.StateFilter("ready"))
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	res, err := g.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo grpc"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo grpc\n", res.Stdout)
+
+	var stderr strings.Builder
+	exit, err := g.ExecStream(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "fail"}, func(ev client.ExecEvent) error {
+		if ev.Type == client.ExecEventStderr {
+			stderr.WriteString(ev.Chunk)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, exit.ExitCode)
+	assert.Equal(t, 3, *exit.ExitCode)
+	assert.Equal(t, "failing\n", stderr.String())
+
+	// The REPL echoes the lines it is sent
+	repl, err := g.Interact(ctx, sb.ID, "bash")
+	require.NoError(t, err)
+	require.NoError(t, repl.Send("hello repl\n"))
+	deadline := time.Now().Add(10 * time.Second)
+	for found := false; !found; {
+		require.True(t, time.Now().Before(deadline), "no REPL output")
+		msg, err := repl.Recv()
+		require.NoError(t, err)
+		var n struct {
+			Method string `json:"method"`
+			Params struct {
+				Chunk string `json:"chunk"`
+			} `json:"params"`
+		}
+		require.NoError(t, json.Unmarshal(msg, &n))
+		found = n.Method == "stdout" && strings.Contains(n.Params.Chunk, "hello repl")
+	}
+	require.NoError(t, repl.Close())
+
+	expires, err := g.SetTTL(ctx, sb.ID, 10*time.Minute)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), expires, time.Minute)
+
+	// Errors carry the code of the REST API
+	_, err = g.Exec(ctx, "missing", client.ExecRequest{Language: "bash", Code: "true"})
+	var apiErr *client.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "sandbox_not_found", apiErr.Code)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound))
+
+	require.NoError(t, g.StopSandbox(ctx, sb.ID))
+	live, err := d.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, live)
+}
@@ -0,0 +1,58 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	// Probes stay open when the API requires a key
+	h := api.NewHandler(d, "secret")
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	get := func(path string, out any) int {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		return resp.StatusCode
+	}
+
+	var health map[string]any
+	assert.Equal(t, http.StatusOK, get("/healthz", &health))
+	assert.Equal(t, "ok", health["status"])
+
+	var ready api.Readiness
+	assert.Equal(t, http.StatusOK, get("/readyz", &ready))
+	assert.Equal(t, api.StatusOK, ready.Status)
+	assert.Equal(t, api.StatusOK, ready.Components["driver"].Status)
+	assert.Equal(t, "wasm", ready.Components["driver"].Detail)
+	assert.Equal(t, api.StatusOK, ready.Components["store"].Status)
+
+	// A draining server is alive but takes no new work
+	require.NoError(t, h.Drain(context.Background(), false))
+	ready = api.Readiness{}
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz", &ready))
+	assert.Equal(t, api.StatusUnavailable, ready.Status)
+	assert.Equal(t, api.StatusUnavailable, ready.Components["drain"].Status)
+	assert.Equal(t, http.StatusOK, get("/healthz", &health))
+}
@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// execResult mirrors the JSON returned by POST /sandbox/:id/exec.
+type execResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode *int   `json:"exit_code"`
+}
+
+// createSandbox creates a sandbox via the API and registers its cleanup.
+func createSandbox(t *testing.T, payload map[string]any) string {
+	t.Helper()
+
+	body, _ := json.Marshal(payload)
+	resp, err := http.Post(BaseURL+"/sandbox", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Create failed: %s %s", resp.Status, string(b))
+	}
+
+	var createResp struct {
+		SandboxID string `json:"sandbox_id"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&createResp))
+	require.NotEmpty(t, createResp.SandboxID)
+
+	t.Cleanup(func() {
+		req, _ := http.NewRequest("DELETE", BaseURL+"/sandbox/"+createResp.SandboxID, nil)
+		http.DefaultClient.Do(req)
+	})
+	return createResp.SandboxID
+}
+
+// execCode runs code in a sandbox and fails the test on a non-200 response.
+func execCode(t *testing.T, id, language, code string) execResult {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"language": language, "code": code})
+	resp, err := http.Post(fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Exec failed: %s %s", resp.Status, string(b))
+	}
+
+	var result execResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	return result
+}
+
+// postJSON sends payload as JSON and returns the raw response.
+func postJSON(t *testing.T, url string, payload any) *http.Response {
+	t.Helper()
+
+	body, _ := json.Marshal(payload)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	return resp
+}
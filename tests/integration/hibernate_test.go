@@ -0,0 +1,122 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxHibernation(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "timeout": 120})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	_, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "echo kept > /workspace/state.txt"})
+	require.NoError(t, err)
+	sb, err := c.Hibernate(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "hibernated", sb.State)
+
+	// The container is stopped, not removed
+	_, err = c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "true"})
+	assert.True(t, errors.Is(err, client.ErrSandboxNotRunning), "got %v", err)
+
+	sb, err = c.Wake(ctx, id, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", sb.State)
+	res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "cat /workspace/state.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, "kept\n", res.Stdout)
+}
+
+func TestWasmHibernation(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithMaxTTL(time.Hour)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: 10 * time.Minute})
+	require.NoError(t, err)
+	id := created.ID
+	require.NoError(t, c.UploadFile(ctx, id, "/workspace/state.txt", strings.NewReader("kept")))
+
+	// A hibernated sandbox keeps its files but runs nothing, and its TTL
+	// stands still
+	sb, err := c.Hibernate(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "hibernated", sb.State)
+	assert.Nil(t, sb.ExpiresAt)
+	list, err := c.ListSandboxes(ctx, client.StateFilter("hibernated"))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, id, list[0].ID)
+
+	_, err = c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "echo asleep"})
+	assert.True(t, errors.Is(err, client.ErrSandboxNotRunning), "got %v", err)
+	_, err = c.Hibernate(ctx, id)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotRunning), "got %v", err)
+	_, err = c.SetTTL(ctx, id, time.Minute)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotRunning), "got %v", err)
+
+	// Waking gives it back what was left of its TTL, or what it is given
+	_, err = c.Wake(ctx, id, 2*time.Hour)
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	sb, err = c.Wake(ctx, id, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", sb.State)
+	require.NotNil(t, sb.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), *sb.ExpiresAt, 5*time.Second)
+	res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "echo awake"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo awake\n", res.Stdout)
+	r, err := c.DownloadFile(ctx, id, "/workspace/state.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "kept", string(data))
+	_, err = c.Wake(ctx, id, 0)
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+	_, err = c.Hibernate(ctx, id)
+	require.NoError(t, err)
+	sb, err = c.Wake(ctx, id, time.Minute)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *sb.ExpiresAt, 5*time.Second)
+
+	events, err := c.Timeline(ctx, id)
+	require.NoError(t, err)
+	var types []string
+	for _, ev := range events {
+		if ev.Type == "hibernated" || ev.Type == "woken" {
+			types = append(types, ev.Type)
+		}
+	}
+	assert.Equal(t, []string{"hibernated", "woken", "hibernated", "woken"}, types)
+
+	// Hibernated sandboxes can be stopped like others
+	_, err = c.Hibernate(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, c.DeleteSandbox(ctx, id))
+	_, err = c.GetSandbox(ctx, id)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+}
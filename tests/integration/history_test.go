@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/akshayaggarwal99/boxed/internal/state"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecHistory(t *testing.T) {
+	id := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  120,
+	})
+
+	execCode(t, id, "python", "print('first')")
+	execCode(t, id, "bash", "echo second")
+
+	resp, err := http.Get(fmt.Sprintf("%s/sandbox/%s/execs", BaseURL, id))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var history struct {
+		Execs []struct {
+			Seq      int    `json:"seq"`
+			Language string `json:"language"`
+			Code     string `json:"code"`
+			Stdout   string `json:"stdout"`
+			ExitCode *int   `json:"exit_code"`
+		} `json:"execs"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	require.Len(t, history.Execs, 2)
+
+	assert.Equal(t, 1, history.Execs[0].Seq)
+	assert.Equal(t, "python", history.Execs[0].Language)
+	assert.Equal(t, "print('first')", history.Execs[0].Code)
+	assert.Contains(t, history.Execs[0].Stdout, "first")
+
+	assert.Equal(t, 2, history.Execs[1].Seq)
+	assert.Equal(t, "bash", history.Execs[1].Language)
+	require.NotNil(t, history.Execs[1].ExitCode)
+
+	// Unknown sandboxes have no history
+	resp, err = http.Get(BaseURL + "/sandbox/does-not-exist/execs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWasmExecHistoryRunes(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+
+	// The limit falls in the middle of a three-byte rune
+	code := "xy" + strings.Repeat("€", state.MaxRecordedOutput)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: code})
+	require.NoError(t, err)
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.Len(t, execs, 1)
+	for _, s := range []string{execs[0].Code, execs[0].Stdout} {
+		assert.True(t, utf8.ValidString(s))
+		assert.NotContains(t, s, string(utf8.RuneError))
+		assert.Equal(t, "xy"+strings.Repeat("€", (state.MaxRecordedOutput-2)/3), s)
+	}
+	assert.True(t, execs[0].Truncated)
+}
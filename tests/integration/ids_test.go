@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmShortIDs(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	newClient := func() *client.Client {
+		e := echo.New()
+		api.NewHandler(d, "").RegisterRoutes(e)
+		srv := httptest.NewServer(e)
+		t.Cleanup(srv.Close)
+		return client.New(srv.URL)
+	}
+	c := newClient()
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Metadata: map[string]string{"team": "ml"},
+	})
+	require.NoError(t, err)
+	assert.Regexp(t, `^sbx_[0-9a-z]{8}$`, sb.ID)
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sb.ID, info.ID)
+	require.NotEmpty(t, info.BackendID)
+	assert.NotEqual(t, sb.ID, info.BackendID)
+	assert.Equal(t, map[string]string{"team": "ml"}, info.Config.Labels)
+
+	// Prefixes, with or without "sbx_", and the backend ID name it too
+	for _, ref := range []string{sb.ID[:7], strings.TrimPrefix(sb.ID, "sbx_")[:4], info.BackendID} {
+		got, err := c.GetSandbox(ctx, ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, sb.ID, got.ID, ref)
+	}
+	res, err := c.Exec(ctx, sb.ID[:8], client.ExecRequest{Language: "bash", Code: "echo hi"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "echo hi")
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Len(t, execs, 1)
+
+	other, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = c.GetSandbox(ctx, "sbx_")
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.GetSandbox(ctx, "sbx_zzzzzzzz")
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Metadata: map[string]string{"boxed.id": "sbx_mine"},
+	})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	// A new control plane finds the IDs again
+	c = newClient()
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	var ids []string
+	for _, s := range list {
+		ids = append(ids, s.ID)
+	}
+	assert.ElementsMatch(t, []string{sb.ID, other.ID}, ids)
+	require.NoError(t, c.DeleteSandbox(ctx, other.ID))
+	_, err = c.GetSandbox(ctx, other.ID)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	require.NoError(t, c.DeleteSandbox(ctx, strings.TrimPrefix(sb.ID, "sbx_")))
+}
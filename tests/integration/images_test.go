@@ -0,0 +1,62 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImagePull(t *testing.T) {
+	const image = "alpine:3.19"
+
+	resp := postJSON(t, BaseURL+"/images/pull", map[string]string{"image": image})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var job struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	resp.Body.Close()
+	require.NotEmpty(t, job.ID)
+
+	// Poll until the pull finishes
+	deadline := time.Now().Add(2 * time.Minute)
+	for job.Status != "done" && job.Status != "failed" {
+		require.True(t, time.Now().Before(deadline), "pull did not finish in time")
+		time.Sleep(500 * time.Millisecond)
+
+		resp, err := http.Get(BaseURL + "/images/pull/" + job.ID)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		resp.Body.Close()
+	}
+	require.Equal(t, "done", job.Status, job.Error)
+
+	// The image is now in the local cache
+	resp, err := http.Get(BaseURL + "/images")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var list struct {
+		Images []struct {
+			Tags []string `json:"tags"`
+		} `json:"images"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+
+	found := false
+	for _, img := range list.Images {
+		for _, tag := range img.Tags {
+			if tag == image {
+				found = true
+			}
+		}
+	}
+	assert.True(t, found, "pulled image should be listed")
+}
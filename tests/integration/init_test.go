@@ -0,0 +1,106 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmTemplateInit(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	catalog, err := templates.New(templates.Config{
+		Default: "plain",
+		Templates: map[string]templates.Template{
+			"plain":  {Image: "python:3.10-slim"},
+			"db":     {Image: "python:3.10-slim", Init: "start db"},
+			"broken": {Image: "python:3.10-slim", Init: "fail"},
+			"slow":   {Image: "python:3.10-slim", Init: "sleep 10s", InitTimeout: 200 * time.Millisecond},
+		},
+	})
+	require.NoError(t, err)
+	h := api.NewHandler(d, "", api.WithTemplates(catalog))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	list, _, err := c.ListTemplates(ctx)
+	require.NoError(t, err)
+	for _, tmpl := range list {
+		if tmpl.Name == "db" {
+			assert.Equal(t, "start db", tmpl.Init)
+			assert.Equal(t, 300, tmpl.InitTimeout)
+		}
+	}
+
+	// The script runs before the create returns, and its run is kept
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "db"})
+	require.NoError(t, err)
+	require.NotNil(t, sb.Init)
+	require.NotNil(t, sb.Init.ExitCode)
+	assert.Equal(t, 0, *sb.Init.ExitCode)
+	assert.Equal(t, "start db\n", sb.Init.Stdout)
+	rec, err := c.Init(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "start db", rec.Code)
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	var types []string
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	assert.Contains(t, types, "init")
+
+	plain, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	assert.Nil(t, plain.Init)
+	_, err = c.Init(ctx, plain.ID)
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+
+	// A failing script fails the create and leaves a failed sandbox whose
+	// init run can still be read
+	var apiErr *client.APIError
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "broken"})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+	assert.Equal(t, "setup_failed", apiErr.Code)
+	assert.Contains(t, apiErr.Message, "exit code 3")
+	assert.Contains(t, apiErr.Message, "failing")
+	require.NotEmpty(t, apiErr.SandboxID)
+
+	failed, err := c.GetSandbox(ctx, apiErr.SandboxID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", failed.State)
+	rec, err = c.Init(ctx, apiErr.SandboxID)
+	require.NoError(t, err)
+	require.NotNil(t, rec.ExitCode)
+	assert.Equal(t, 3, *rec.ExitCode)
+	assert.Equal(t, "failing\n", rec.Stderr)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "slow"})
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Message, "did not finish within 200ms")
+
+	live, err := d.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, live, 2)
+}
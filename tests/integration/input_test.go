@@ -0,0 +1,86 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmInputLimits(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "", api.WithInputLimits(api.InputLimits{CodeSize: 16, Args: 2, EnvVars: 2, EnvSize: 20, BodySize: 1024}))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	tooLarge := func(err error, what string) {
+		t.Helper()
+		var apiErr *client.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.StatusCode)
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+		assert.Contains(t, apiErr.Message, what)
+	}
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo ok", Env: map[string]string{"A": "1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "echo ok\n", res.Stdout)
+
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: strings.Repeat("x", 17)})
+	tooLarge(err, "code is 17 bytes")
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "true", Env: map[string]string{"A": "1", "B": "2", "C": "3"}})
+	tooLarge(err, "3 variables")
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "true", Env: map[string]string{"LONG": strings.Repeat("v", 20)}})
+	tooLarge(err, "env is 24 bytes")
+
+	// Jobs are checked when queued
+	_, err = c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: strings.Repeat("x", 17)}})
+	tooLarge(err, "code")
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Sidecars: []client.Sidecar{{Name: "db", Cmd: []string{"db", "--port", "5432"}}},
+	})
+	tooLarge(err, "sidecar db has 3 arguments")
+
+	// Bodies over the limit, whether or not they declare their length
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"pad": strings.Repeat("p", 2000)}})
+	tooLarge(err, "request body")
+
+	body, _ := json.Marshal(map[string]any{"template": "python:3.10-slim", "metadata": map[string]string{"pad": strings.Repeat("p", 2000)}})
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/sandbox", io.MultiReader(strings.NewReader(string(body))))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	var apiErr api.APIError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&apiErr))
+	assert.Equal(t, api.CodeInvalidRequest, apiErr.Code)
+
+	// Uploads are not JSON and not capped
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "big.txt", strings.NewReader(strings.Repeat("u", 4096))))
+}
@@ -0,0 +1,179 @@
+package integration
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmJobs(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	const key = "job-key"
+	e := echo.New()
+	// The callback receiver listens on loopback
+	api.NewHandler(d, key, api.WithCallbackAllowCIDRs([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, client.WithAPIKey(key))
+	ctx := context.Background()
+
+	// The callback receiver checks the signature of what it is sent
+	callbacks := make(chan client.Job, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write(body)
+		if r.Header.Get(api.JobSignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var job client.Job
+		json.Unmarshal(body, &job)
+		assert.Equal(t, job.ID, r.Header.Get(api.JobHeader))
+		callbacks <- job
+	}))
+	t.Cleanup(hook.Close)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	_, err = c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "cobol", Code: "x"}})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+	_, err = c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "x"}, CallbackURL: "ftp://example.com"})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+	_, err = c.CreateJob(ctx, "sbx_missing", client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "x"}})
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+	_, err = c.GetJob(ctx, "job_missing")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	// Jobs of a sandbox run one after the other
+	slow, err := c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "sleep 1s"}})
+	require.NoError(t, err)
+	assert.Equal(t, client.JobQueued, slow.Status)
+	next, err := c.CreateJob(ctx, sb.ID, client.JobRequest{
+		ExecRequest: client.ExecRequest{Language: "bash", Code: "fail"},
+		CallbackURL: hook.URL,
+	})
+	require.NoError(t, err)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	done, err := c.WaitJob(waitCtx, next.ID, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, client.JobDone, done.Status)
+	require.NotNil(t, done.Result)
+	assert.Equal(t, 3, *done.Result.ExitCode)
+	assert.Equal(t, "failing\n", done.Result.Stderr)
+	first, err := c.GetJob(ctx, slow.ID)
+	require.NoError(t, err)
+	assert.Equal(t, client.JobDone, first.Status)
+	assert.Equal(t, "sleep 1s\n", first.Result.Stdout)
+	assert.False(t, done.StartedAt.Before(*first.FinishedAt))
+
+	select {
+	case job := <-callbacks:
+		assert.Equal(t, next.ID, job.ID)
+		assert.Equal(t, client.JobDone, job.Status)
+		assert.Equal(t, 3, *job.Result.ExitCode)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no callback")
+	}
+	require.Eventually(t, func() bool {
+		job, err := c.GetJob(ctx, next.ID)
+		return err == nil && job.Callback != nil && job.Callback.DeliveredAt != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_, err = c.CancelJob(ctx, next.ID)
+	assert.ErrorIs(t, err, client.ErrConflict)
+
+	// A job that runs out of time fails
+	timedOut, err := c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "sleep 5s"}, Timeout: 1})
+	require.NoError(t, err)
+	job, err := c.WaitJob(waitCtx, timedOut.ID, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, client.JobFailed, job.Status)
+	assert.Equal(t, "timed_out", job.ErrorCode)
+
+	// Canceling a queued job finishes it at once; stopping the sandbox
+	// cancels the rest
+	running, err := c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "sleep 10s"}})
+	require.NoError(t, err)
+	queued, err := c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "never"}})
+	require.NoError(t, err)
+	last, err := c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "never"}})
+	require.NoError(t, err)
+	job, err = c.CancelJob(ctx, queued.ID)
+	require.NoError(t, err)
+	assert.Equal(t, client.JobCanceled, job.Status)
+	assert.True(t, job.Finished())
+
+	jobs, err := c.ListJobs(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 6)
+
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+	for _, id := range []string{running.ID, last.ID} {
+		job, err := c.WaitJob(waitCtx, id, 100*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, client.JobCanceled, job.Status, id)
+		assert.Equal(t, "sandbox stopped", job.Error)
+		assert.Nil(t, job.Result)
+	}
+}
+
+func TestWasmJobCallbackPrivateHosts(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+
+	hit := make(chan struct{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit <- struct{}{}
+	}))
+	t.Cleanup(hook.Close)
+
+	// Addresses off the internet are refused up front
+	for _, u := range []string{hook.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:8080/"} {
+		_, err = c.CreateJob(ctx, sb.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "x"}, CallbackURL: u})
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, u)
+	}
+
+	// and names resolving to them when the callback is sent
+	_, port, _ := net.SplitHostPort(hook.Listener.Addr().String())
+	job, err := c.CreateJob(ctx, sb.ID, client.JobRequest{
+		ExecRequest: client.ExecRequest{Language: "bash", Code: "x"},
+		CallbackURL: "http://localhost:" + port + "/hook",
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err = c.GetJob(ctx, job.ID)
+		return err == nil && job.Callback != nil
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Nil(t, job.Callback.DeliveredAt)
+	assert.Equal(t, 1, job.Callback.Attempts)
+	assert.Contains(t, job.Callback.Error, "not on the internet")
+	select {
+	case <-hit:
+		t.Fatal("callback reached a loopback receiver")
+	default:
+	}
+}
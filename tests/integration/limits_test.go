@@ -0,0 +1,54 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxLimits(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "", api.WithMaxSandboxes(2), api.WithMaxSandboxAge(10*time.Minute)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	a, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	assert.True(t, errors.Is(err, client.ErrQuotaExceeded), "got %v", err)
+
+	// A stopped sandbox frees its slot
+	require.NoError(t, c.DeleteSandbox(ctx, a.ID))
+	b, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	// The age cap bounds timeouts and extensions alike
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: 20 * time.Minute})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	_, err = c.SetTTL(ctx, b.ID, 9*time.Minute)
+	require.NoError(t, err)
+	_, err = c.ExtendTTL(ctx, b.ID, 5*time.Minute)
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+}
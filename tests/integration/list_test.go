@@ -0,0 +1,103 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFilters(t *testing.T) {
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	id := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"metadata": map[string]string{"session_id": "list-test", "user": "ada"},
+	})
+	other := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"metadata": map[string]string{"session_id": "someone-else"},
+	})
+
+	list, err := c.ListSandboxes(ctx, client.LabelFilter("session_id", "list-test"), client.StateFilter("ready"))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, id, list[0].ID)
+	assert.Equal(t, map[string]string{"session_id": "list-test", "user": "ada"}, list[0].Config.Labels)
+	assert.False(t, list[0].CreatedAt.IsZero())
+
+	// A key alone matches any value
+	list, err = c.ListSandboxes(ctx, client.LabelFilter("session_id", ""))
+	require.NoError(t, err)
+	var ids []string
+	for _, sb := range list {
+		ids = append(ids, sb.ID)
+	}
+	assert.Contains(t, ids, id)
+	assert.Contains(t, ids, other)
+
+	list, err = c.ListSandboxes(ctx, client.LabelFilter("session_id", "list-test"), client.StateFilter("stopped"))
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestWasmListFilters(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	a, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "red"}})
+	require.NoError(t, err)
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "blue"}})
+	require.NoError(t, err)
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	list, err = c.ListSandboxes(ctx, client.LabelFilter("team", "red"))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, a.ID, list[0].ID)
+	assert.NotNil(t, list[0].ExpiresAt)
+
+	_, err = c.ListSandboxes(ctx, client.StateFilter("sleeping"))
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+}
+
+func TestWasmDeleteSandboxes(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	var red []string
+	for range 3 {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "red"}})
+		require.NoError(t, err)
+		red = append(red, sb.ID)
+	}
+	blue, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "blue"}})
+	require.NoError(t, err)
+
+	// Without labels the caller has to ask for everything
+	_, err = c.DeleteSandboxes(ctx, client.StateFilter("ready"))
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	res, err := c.DeleteSandboxes(ctx, client.LabelFilter("team", "red"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, red, res.Stopped)
+	assert.Empty(t, res.Failed)
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, blue.ID, list[0].ID)
+
+	res, err = c.DeleteSandboxes(ctx, client.AllSandboxes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{blue.ID}, res.Stopped)
+	list, err = c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmAgentLogs(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	require.NoError(t, err)
+
+	var lines []string
+	var execs []string
+	require.NoError(t, c.Logs(ctx, sb.ID, client.LogSourceAgent, false, func(l client.LogLine) error {
+		assert.Equal(t, client.LogSourceAgent, l.Source)
+		lines = append(lines, l.Text)
+		execs = append(execs, l.Exec)
+		return nil
+	}))
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "echo hi")
+	assert.True(t, strings.HasPrefix(lines[1], "exited with code 0"), lines[1])
+	// Both lines come from the agent that ran the exec
+	require.NotEmpty(t, execs[0])
+	assert.Equal(t, execs[0], execs[1])
+
+	// The lines of another agent are left out when asking for one
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo other"})
+	require.NoError(t, err)
+	lines = nil
+	require.NoError(t, c.ExecLogs(ctx, sb.ID, execs[0], false, func(l client.LogLine) error {
+		lines = append(lines, l.Text)
+		return nil
+	}))
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.NotContains(t, line, "echo other")
+	}
+
+	err = c.Logs(ctx, sb.ID, client.LogSourceProcess, false, func(client.LogLine) error { return nil })
+	assert.True(t, errors.Is(err, client.ErrNotImplemented), "got %v", err)
+
+	t.Run("follow", func(t *testing.T) {
+		followed := make(chan string, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Logs(ctx, sb.ID, client.LogSourceAgent, true, func(l client.LogLine) error {
+				followed <- l.Text
+				return nil
+			})
+		}()
+		// The backlog comes first
+		for range 4 {
+			<-followed
+		}
+
+		_, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo again"})
+		require.NoError(t, err)
+		select {
+		case line := <-followed:
+			assert.Contains(t, line, "echo again")
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line followed")
+		}
+
+		// Stopping the sandbox ends the stream
+		require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream did not end with the sandbox")
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/multi"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiDriverRouting(t *testing.T) {
+	modules := buildWasmModules(t)
+	newBackend := func(name string) multi.Backend {
+		d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+		require.NoError(t, err)
+		return multi.Backend{Name: name, Driver: d}
+	}
+	d, err := multi.New(
+		[]multi.Backend{newBackend("trusted"), newBackend("untrusted")},
+		[]multi.Route{{Pattern: "untrusted/*", Driver: "untrusted"}},
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	// The backend shows in the backend ID, behind the short ID
+	backendID := func(id string) string {
+		t.Helper()
+		sb, err := c.GetSandbox(ctx, id)
+		require.NoError(t, err)
+		return sb.BackendID
+	}
+
+	byDefault, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(backendID(byDefault.ID), "trusted:"), byDefault.ID)
+
+	routed, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "untrusted/agent:latest"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(backendID(routed.ID), "untrusted:"), routed.ID)
+
+	named, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Driver: "untrusted"})
+	require.NoError(t, err)
+	namedBackendID := backendID(named.ID)
+	assert.True(t, strings.HasPrefix(namedBackendID, "untrusted:"), named.ID)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Driver: "firecracker"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	// Every call finds the sandbox's backend from its ID
+	res, err := c.Exec(ctx, routed.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "echo hi")
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+
+	require.NoError(t, c.DeleteSandbox(ctx, routed.ID))
+	_, err = c.GetSandbox(ctx, routed.ID)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	_, err = c.GetSandbox(ctx, "nowhere:"+strings.TrimPrefix(namedBackendID, "untrusted:"))
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+}
@@ -0,0 +1,32 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmNetworkGroupRejected(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	// Bad names are invalid anywhere; wasm has no network to share
+	for _, req := range []client.CreateSandboxRequest{
+		{NetworkAlias: "db"},
+		{NetworkGroup: "Bad Group"},
+		{NetworkGroup: "session-1", NetworkAlias: "-db"},
+		{NetworkGroup: "session-1", NetworkAlias: "db"},
+	} {
+		req.Template = "python:3.10-slim"
+		_, err := c.CreateSandbox(ctx, req)
+		assert.True(t, errors.Is(err, client.ErrInvalidRequest), "%+v: got %v", req, err)
+	}
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
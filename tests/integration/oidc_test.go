@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer is a minimal OpenID Connect provider signing RS256 tokens.
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	iss := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// token signs claims; "iss" and "exp" default to valid values.
+func (iss *testIssuer) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = iss.URL
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestWasmOIDC(t *testing.T) {
+	iss := newTestIssuer(t)
+	verifier, err := auth.NewOIDCVerifier(context.Background(), auth.Config{Issuer: iss.URL, Audience: "boxed"})
+	require.NoError(t, err)
+
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "static-key", api.WithTokenVerifier(verifier)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	valid := iss.token(t, map[string]any{"sub": "ada", "org": "acme", "aud": []string{"boxed", "other"}})
+	c := client.New(srv.URL, client.WithBearerToken(valid))
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{"team": "red"}})
+	require.NoError(t, err)
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "red", api.OwnerLabel: "ada", api.OrgLabel: "acme"}, info.Config.Labels)
+
+	// Owner metadata only comes from the token
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Metadata: map[string]string{api.OwnerLabel: "grace"}})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+
+	for name, token := range map[string]string{
+		"expired":        iss.token(t, map[string]any{"sub": "ada", "aud": "boxed", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"wrong audience": iss.token(t, map[string]any{"sub": "ada", "aud": "someone-else"}),
+		"wrong issuer":   iss.token(t, map[string]any{"sub": "ada", "aud": "boxed", "iss": "https://evil.example"}),
+		"tampered":       valid[:len(valid)-4] + "AAAA",
+	} {
+		_, err := client.New(srv.URL, client.WithBearerToken(token)).ListSandboxes(ctx)
+		assert.True(t, errors.Is(err, client.ErrUnauthorized), name)
+	}
+
+	// Only WebSockets may pass the token in the query
+	resp, err := http.Get(srv.URL + "/v1/sandbox?access_token=" + valid)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/events?access_token="+valid, nil)
+	require.NoError(t, err)
+	ws.Close()
+
+	// The API key keeps working, and nothing else does
+	_, err = client.New(srv.URL, client.WithAPIKey("static-key")).ListSandboxes(ctx)
+	assert.NoError(t, err)
+	_, err = client.New(srv.URL).ListSandboxes(ctx)
+	assert.True(t, errors.Is(err, client.ErrUnauthorized))
+}
@@ -0,0 +1,121 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bigOutput prints 30000 lines of 100 bytes (plus newline): ~3 MB.
+const bigOutput = "for _ in range(30000): print('x' * 100)"
+
+type truncatedExec struct {
+	Stdout      string `json:"stdout"`
+	Truncated   bool   `json:"truncated"`
+	StdoutBytes int64  `json:"stdout_bytes"`
+	Artifacts   []struct {
+		Path string `json:"path"`
+		URL  string `json:"url"`
+	} `json:"artifacts"`
+}
+
+func TestOutputTruncation(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "timeout": 120})
+
+	resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), map[string]any{
+		"language": "python",
+		"code":     bigOutput,
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result truncatedExec
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.True(t, result.Truncated)
+	assert.Len(t, result.Stdout, api.DefaultMaxOutput)
+	assert.Equal(t, int64(30000*101), result.StdoutBytes)
+	assert.Empty(t, result.Artifacts, "nothing is spilled unless requested")
+}
+
+func TestOutputSpill(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "timeout": 120})
+
+	resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), map[string]any{
+		"language":     "python",
+		"code":         bigOutput,
+		"spill_output": true,
+	})
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result truncatedExec
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.True(t, result.Truncated)
+	require.Len(t, result.Artifacts, 1)
+
+	// The spilled file holds the complete output
+	file, err := http.Get(fmt.Sprintf("%s/sandbox/%s/files/content?path=%s", BaseURL, id, url.QueryEscape(result.Artifacts[0].Path)))
+	require.NoError(t, err)
+	defer file.Body.Close()
+	require.Equal(t, http.StatusOK, file.StatusCode)
+	n, err := io.Copy(io.Discard, file.Body)
+	require.NoError(t, err)
+	assert.Equal(t, result.StdoutBytes, n)
+}
+
+func TestWasmOutputRunes(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithMaxOutput(1000)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+
+	// The cap falls in the middle of a three-byte rune
+	code := "xy" + strings.Repeat("€", 1000)
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: code, SpillOutput: true})
+	require.NoError(t, err)
+	assert.True(t, res.Truncated)
+	assert.False(t, res.SpillTruncated)
+	assert.True(t, utf8.ValidString(res.Stdout))
+	assert.Equal(t, "xy"+strings.Repeat("€", 332), res.Stdout)
+	assert.Equal(t, int64(len(code)+1), res.StdoutBytes)
+
+	// The spilled file still holds all of it
+	var spilled string
+	for _, a := range res.Artifacts {
+		if strings.HasSuffix(a.Path, ".stdout") {
+			spilled = a.Path
+		}
+	}
+	require.NotEmpty(t, spilled)
+	file, err := c.DownloadFile(ctx, sb.ID, spilled)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, code+"\n", string(data))
+}
@@ -0,0 +1,134 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/auth"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmOwnership(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.yaml")
+	require.NoError(t, os.WriteFile(keysFile, []byte(`keys:
+  - name: alice
+    key: alice-key
+  - name: bob
+    key: bob-key
+  - name: ops
+    key: ops-key
+    admin: true
+`), 0600))
+	keys, err := api.LoadAPIKeys(keysFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keysFile, []byte("keys:\n  - {name: a, key: k}\n  - {name: a, key: l}\n"), 0600))
+	_, err = api.LoadAPIKeys(keysFile)
+	assert.ErrorContains(t, err, "used twice")
+
+	iss := newTestIssuer(t)
+	verifier, err := auth.NewOIDCVerifier(context.Background(), auth.Config{Issuer: iss.URL})
+	require.NoError(t, err)
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "static-key", api.WithAPIKeys(keys), api.WithTokenVerifier(verifier))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	mine, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	theirs, err := bob.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	info, err := alice.GetSandbox(ctx, mine.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "key:alice", info.Config.Labels[api.OwnerLabel])
+
+	ids := func(c *client.Client) []string {
+		t.Helper()
+		list, err := c.ListSandboxes(ctx)
+		require.NoError(t, err)
+		var ids []string
+		for _, sb := range list {
+			ids = append(ids, sb.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{mine.ID}, ids(alice))
+	assert.Equal(t, []string{theirs.ID}, ids(bob))
+
+	// Another owner's sandbox does not exist for the caller, by full or
+	// abbreviated ID
+	notFound := func(err error) {
+		t.Helper()
+		assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	}
+	_, err = bob.GetSandbox(ctx, mine.ID)
+	notFound(err)
+	_, err = bob.GetSandbox(ctx, strings.TrimPrefix(mine.ID, "sbx_")[:6])
+	notFound(err)
+	_, err = bob.Exec(ctx, mine.ID, client.ExecRequest{Language: "bash", Code: "echo hi"})
+	notFound(err)
+	notFound(bob.UploadFile(ctx, mine.ID, "x.txt", strings.NewReader("x")))
+	notFound(bob.DeleteSandbox(ctx, mine.ID))
+
+	// Nor do its jobs
+	job, err := alice.CreateJob(ctx, mine.ID, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "echo job"}})
+	require.NoError(t, err)
+	_, err = bob.GetJob(ctx, job.ID)
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+	_, err = alice.GetJob(ctx, job.ID)
+	assert.NoError(t, err)
+
+	// Over gRPC too
+	gs := h.NewGRPCServer()
+	t.Cleanup(gs.Stop)
+	api.MountGRPC(e, gs)
+	grpcSrv := httptest.NewUnstartedServer(e)
+	grpcSrv.Config.Protocols = e.Server.Protocols
+	grpcSrv.Start()
+	t.Cleanup(grpcSrv.Close)
+	g, err := client.DialGRPC(strings.TrimPrefix(grpcSrv.URL, "http://"), []client.Option{client.WithAPIKey("bob-key")})
+	require.NoError(t, err)
+	t.Cleanup(func() { g.Close() })
+	_, err = g.GetSandbox(ctx, mine.ID)
+	notFound(err)
+	list, err := g.ListSandboxes(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, theirs.ID, list[0].ID)
+
+	// Admins see everything: admin keys, the server's key and tokens with
+	// the admin scope; other tokens only their own
+	both := []string{mine.ID, theirs.ID}
+	assert.ElementsMatch(t, both, ids(client.New(srv.URL, client.WithAPIKey("ops-key"))))
+	assert.ElementsMatch(t, both, ids(client.New(srv.URL, client.WithAPIKey("static-key"))))
+	admin := iss.token(t, map[string]any{"sub": "root", "scope": "openid boxed:admin"})
+	assert.ElementsMatch(t, both, ids(client.New(srv.URL, client.WithBearerToken(admin))))
+	user := iss.token(t, map[string]any{"sub": "ada"})
+	assert.Empty(t, ids(client.New(srv.URL, client.WithBearerToken(user))))
+
+	// Deleting everything deletes only what the caller owns
+	res, err := bob.DeleteSandboxes(ctx, client.AllSandboxes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{theirs.ID}, res.Stopped)
+	assert.Equal(t, []string{mine.ID}, ids(alice))
+}
@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackages(t *testing.T) {
+	c := client.New(BaseURL)
+	ctx := context.Background()
+	pkgs := &client.Packages{Pip: []string{"six==1.16.0"}}
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Packages: pkgs})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+	require.NotNil(t, sb.Setup)
+	assert.True(t, strings.HasPrefix(sb.Setup.Image, "python:3.10-slim-pkgs-"), sb.Setup.Image)
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "import six; print(six.__version__)"})
+	require.NoError(t, err)
+	assert.Equal(t, "1.16.0\n", res.Stdout, res.Stderr)
+
+	// The same packages come from the image the first create saved
+	again, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Packages: pkgs})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), again.ID) })
+	assert.True(t, again.Setup.Cached)
+	assert.Empty(t, again.Setup.Steps)
+	assert.Equal(t, sb.Setup.Image, again.Setup.Image)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Packages: &client.Packages{Pip: []string{"boxed-no-such-package-3f9a"}},
+	})
+	assert.ErrorIs(t, err, client.ErrSetupFailed)
+}
+
+func TestWasmPackages(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	for _, spec := range []string{"--index-url=http://evil", "six; rm -rf /", "a b", "$(id)"} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+			Template: "python:3.10-slim",
+			Packages: &client.Packages{Pip: []string{spec}},
+		})
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, spec)
+	}
+
+	// The wasm driver saves images but cannot install as root
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Packages: &client.Packages{Pip: []string{"requests>=2"}},
+	})
+	assert.ErrorIs(t, err, client.ErrSetupFailed)
+
+	// An empty package set is no setup at all
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Packages: &client.Packages{}})
+	require.NoError(t, err)
+	assert.Nil(t, sb.Setup)
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+}
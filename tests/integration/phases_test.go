@@ -0,0 +1,141 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pullingDriver reports pulling an image on each create, and holds stops
+// until released.
+type pullingDriver struct {
+	driver.Driver
+	stopping chan struct{}
+	release  chan struct{}
+}
+
+func (d *pullingDriver) Create(ctx context.Context, cfg driver.SandboxConfig) (string, error) {
+	driver.ReportPhase(ctx, driver.PhasePullingImage)
+	time.Sleep(10 * time.Millisecond)
+	driver.ReportPhase(ctx, driver.PhaseCreating)
+	return d.Driver.Create(ctx, cfg)
+}
+
+func (d *pullingDriver) Stop(ctx context.Context, id string) error {
+	if d.stopping != nil {
+		close(d.stopping)
+		<-d.release
+	}
+	return d.Driver.Stop(ctx, id)
+}
+
+// phaseNames returns the phases of sb in order, checking their times
+// never go back.
+func phaseNames(t *testing.T, sb *client.Sandbox) []string {
+	t.Helper()
+	var names []string
+	for i, p := range sb.Phases {
+		if i > 0 {
+			assert.False(t, p.At.Before(sb.Phases[i-1].At), "%s before %s", p.Phase, sb.Phases[i-1].Phase)
+		}
+		names = append(names, p.Phase)
+	}
+	return names
+}
+
+func TestWasmSandboxPhases(t *testing.T) {
+	wd, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { wd.Close() })
+	catalog, err := templates.New(templates.Config{
+		Default: "plain",
+		Templates: map[string]templates.Template{
+			"plain":  {Image: "python:3.10-slim"},
+			"db":     {Image: "python:3.10-slim", Init: "start db"},
+			"broken": {Image: "python:3.10-slim", Init: "fail"},
+		},
+	})
+	require.NoError(t, err)
+	d := &pullingDriver{Driver: wd}
+	e := echo.New()
+	api.NewHandler(d, "", api.WithTemplates(catalog)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	// Phases the driver reports come between those of the server
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Context: []client.FileInjection{{Path: "/workspace/app.py", ContentBase64: base64.StdEncoding.EncodeToString([]byte("print(1)"))}},
+	})
+	require.NoError(t, err)
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ready", info.Phase)
+	assert.Equal(t, []string{"creating", "pulling-image", "creating", "injecting-context", "starting", "ready"}, phaseNames(t, info))
+	assert.GreaterOrEqual(t, info.Phases[2].At.Sub(info.Phases[1].At), 10*time.Millisecond)
+
+	list, err := c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, info.Phases, list[0].Phases)
+
+	// The agent is waited for before the init script runs
+	db, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "db"})
+	require.NoError(t, err)
+	info, err = c.GetSandbox(ctx, db.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"creating", "pulling-image", "creating", "starting", "agent-waiting", "initializing", "ready"}, phaseNames(t, info))
+
+	// Failed creates keep the phases they went through
+	var apiErr *client.APIError
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "broken"})
+	require.ErrorAs(t, err, &apiErr)
+	require.NotEmpty(t, apiErr.SandboxID)
+	info, err = c.GetSandbox(ctx, apiErr.SandboxID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", info.State)
+	assert.Equal(t, "initializing", info.Phase)
+
+	// They are listed when asked for, with the labels of their create
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "broken", Metadata: map[string]string{"team": "red"}})
+	require.Error(t, err)
+	list, err = c.ListSandboxes(ctx, client.StateFilter("failed"))
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, apiErr.SandboxID, list[0].ID)
+	assert.Equal(t, "failed", list[1].State)
+	assert.NotEmpty(t, list[1].Error)
+	list, err = c.ListSandboxes(ctx, client.StateFilter("failed"), client.LabelFilter("team", "red"))
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "red", list[0].Config.Labels["team"])
+	list, err = c.ListSandboxes(ctx, client.StateFilter("ready"), client.StateFilter("failed"))
+	require.NoError(t, err)
+	assert.Len(t, list, 4)
+	list, err = c.ListSandboxes(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	// Stopping shows while the driver stops the sandbox
+	d.stopping, d.release = make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() { done <- c.DeleteSandbox(ctx, db.ID) }()
+	<-d.stopping
+	info, err = c.GetSandbox(ctx, db.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "stopping", info.Phase)
+	close(d.release)
+	require.NoError(t, <-done)
+	d.stopping = nil
+}
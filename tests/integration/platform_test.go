@@ -0,0 +1,47 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmPlatform(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	for _, platform := range []string{"amd64", "linux/", "Linux/AMD64", "linux/amd64/v8/extra"} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Platform: platform})
+		var apiErr *client.APIError
+		require.True(t, errors.As(err, &apiErr), platform)
+		assert.Equal(t, "invalid_request", apiErr.Code, platform)
+	}
+
+	// Wasm modules run on any host, so a platform is accepted and ignored
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Platform: "linux/arm64/v8", Timeout: time.Minute})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	assert.False(t, sb.Emulated)
+	assert.Empty(t, sb.Warnings)
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.False(t, info.Emulated)
+}
@@ -0,0 +1,95 @@
+package integration
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/driver/plugin"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/akshayaggarwal99/boxed/pkg/drivertest"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPluginDriver runs the WebAssembly driver as a plugin process and
+// drives it through the API.
+func TestPluginDriver(t *testing.T) {
+	dir := t.TempDir()
+	out, err := exec.Command("go", "build", "-o", filepath.Join(dir, plugin.Prefix+"wasmplugin"), "./tests/integration/testdata/wasmplugin").CombinedOutput()
+	require.NoError(t, err, string(out))
+	// Neither a file without the prefix nor one named after a built-in
+	// driver is loaded
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), nil, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, plugin.Prefix+"wasm"), nil, 0755))
+
+	_, err = plugin.Load(dir)
+	require.NoError(t, err)
+	assert.Contains(t, driver.AvailableDrivers(), "wasmplugin")
+	assert.NotContains(t, driver.AvailableDrivers(), "README")
+
+	d, err := driver.NewDriver("wasmplugin", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer d.Close()
+	require.NoError(t, d.Healthy(context.Background()))
+	assert.Equal(t, "wasm", d.DriverName())
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "hello plugin"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello plugin\n", res.Stdout)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 0, *res.ExitCode)
+	require.Len(t, res.Artifacts, 1)
+
+	// Files larger than a stream message
+	data := strings.Repeat("0123456789", 10000)
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/data.txt", strings.NewReader(data)))
+	files, err := c.ListFiles(ctx, sb.ID, "/workspace")
+	require.NoError(t, err)
+	sizes := map[string]int64{}
+	for _, f := range files {
+		sizes[f.Path] = f.Size
+	}
+	assert.Equal(t, int64(len(data)), sizes["workspace/data.txt"])
+	body, err := c.DownloadFile(ctx, sb.ID, "/workspace/data.txt")
+	require.NoError(t, err)
+	got, err := io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, data, string(got))
+	_, err = c.DownloadFile(ctx, sb.ID, "/workspace/missing.txt")
+	assert.Error(t, err)
+
+	// The driver's sentinel errors survive the trip
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+	_, err = c.GetSandbox(ctx, sb.ID)
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+	_, err = d.Info(ctx, "nope")
+	assert.ErrorIs(t, err, driver.ErrSandboxNotFound)
+
+	// And it behaves as the driver it wraps
+	drivertest.Run(t, d, drivertest.Options{})
+
+	require.NoError(t, d.Close())
+	assert.Error(t, d.Healthy(ctx))
+}
@@ -0,0 +1,115 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKernel is installed as python3.wasm. It speaks the python-session
+// protocol without running Python: each request is answered with its
+// number in this interpreter and the code, so state surviving between
+// execs shows. "fail" exits 1 and "crash" kills the interpreter.
+const fakeKernel = `package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func send(frame map[string]any) {
+	data, _ := json.Marshal(frame)
+	fmt.Printf("\x1eboxed %s\n", data)
+}
+
+func main() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for n := 1; scanner.Scan(); n++ {
+		var req struct{ Code string }
+		json.Unmarshal(scanner.Bytes(), &req)
+		switch req.Code {
+		case "fail":
+			send(map[string]any{"stderr": "Traceback\n", "exit": 1})
+		case "crash":
+			os.Exit(9)
+		default:
+			fmt.Println("raw output")
+			send(map[string]any{"stdout": fmt.Sprintf("%d: %s\n", n, req.Code)})
+			send(map[string]any{"exit": 0})
+		}
+	}
+}
+`
+
+func TestWasmPythonSession(t *testing.T) {
+	modules := buildWasmModules(t)
+	buildWasmModule(t, modules, "python3", fakeKernel)
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": modules,
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	run := func(code string) *client.ExecResult {
+		t.Helper()
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: api.LanguagePythonSession, Code: code})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExitCode)
+		return res
+	}
+
+	// Successive execs reach the same interpreter
+	res := run("x = 1")
+	assert.Equal(t, "raw output\n1: x = 1\n", res.Stdout)
+	assert.Equal(t, 0, *res.ExitCode)
+	res = run("print(x)")
+	assert.Equal(t, "raw output\n2: print(x)\n", res.Stdout)
+
+	res = run("fail")
+	assert.Equal(t, 1, *res.ExitCode)
+	assert.Equal(t, "Traceback\n", res.Stderr)
+	assert.Equal(t, "raw output\n4: after\n", run("after").Stdout)
+
+	// A dead interpreter is replaced on the next exec
+	res = run("crash")
+	assert.Equal(t, -1, *res.ExitCode)
+	assert.Contains(t, res.Stderr, "python session ended")
+	assert.Equal(t, "raw output\n1: fresh\n", run("fresh").Stdout)
+
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{
+		Language:  api.LanguagePythonSession,
+		Code:      "x",
+		Artifacts: &client.ArtifactOptions{Delivery: client.DeliveryURL},
+	})
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, execs)
+	assert.Equal(t, api.LanguagePythonSession, execs[0].Language)
+
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+}
@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconcileMarksDeadSandbox kills a sandbox's container behind the
+// driver's back and waits for the reconciler to report it as failed.
+func TestReconcileMarksDeadSandbox(t *testing.T) {
+	d, err := driver.NewDriver("docker", map[string]any{
+		"faults":             "stop_during_exec=200ms",
+		"cleanup_orphans":    false,
+		"reconcile_interval": 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "import time; time.sleep(30)"})
+	require.NoError(t, err)
+
+	got, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	backendID := got.BackendID
+	require.Eventually(t, func() bool {
+		info, err := d.Info(ctx, backendID)
+		return err == nil && info.State == driver.StateError
+	}, 5*time.Second, 100*time.Millisecond)
+	info, err := d.Info(ctx, backendID)
+	require.NoError(t, err)
+	assert.Contains(t, info.Error, "stopped unexpectedly")
+	assert.Equal(t, driver.ExitSandboxDied, info.ExitReason)
+
+	failed, err := d.List(ctx, []driver.SandboxState{driver.StateError})
+	require.NoError(t, err)
+	var ids []string
+	for _, s := range failed {
+		ids = append(ids, s.ID)
+	}
+	assert.Contains(t, ids, backendID)
+}
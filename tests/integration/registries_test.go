@@ -0,0 +1,107 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryCredentials(t *testing.T) {
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	// Credentials are validated, and returned without their password
+	_, err := c.SetRegistryCredential(ctx, client.RegistryCredential{Registry: "localhost:5999", Username: "ci"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	cred, err := c.SetRegistryCredential(ctx, client.RegistryCredential{Registry: "localhost:5999", Username: "ci", Password: "hunter2"})
+	require.NoError(t, err)
+	assert.Equal(t, client.RegistryCredential{Registry: "localhost:5999", Username: "ci"}, *cred)
+	_, err = c.SetRegistryCredential(ctx, client.RegistryCredential{Registry: "https://Localhost:5998/v2/", Helper: "boxed-missing"})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c.DeleteRegistryCredential(ctx, "localhost:5999")
+		c.DeleteRegistryCredential(ctx, "localhost:5998")
+	})
+
+	creds, err := c.ListRegistryCredentials(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, creds, client.RegistryCredential{Registry: "localhost:5998", Helper: "boxed-missing"})
+	assert.Contains(t, creds, client.RegistryCredential{Registry: "localhost:5999", Username: "ci"})
+
+	// Pulls from the registry ask its helper
+	job := pullImage(t, "localhost:5998/private/app:1")
+	assert.Equal(t, "failed", job.Status)
+	assert.Contains(t, job.Error, "credential helper boxed-missing failed for localhost:5998")
+
+	require.NoError(t, c.DeleteRegistryCredential(ctx, "localhost:5998"))
+	err = c.DeleteRegistryCredential(ctx, "localhost:5998")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	job = pullImage(t, "localhost:5998/private/app:1")
+	assert.Equal(t, "failed", job.Status)
+	assert.NotContains(t, job.Error, "credential helper")
+}
+
+func TestWasmRegistryCredentials(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "ops", Key: "ops-key", Admin: true},
+		{Name: "alice", Key: "alice-key"},
+	}))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	// Only admins see the server's credentials; the wasm driver pulls no
+	// images
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	_, err = alice.ListRegistryCredentials(ctx)
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), "got %v", err)
+	ops := client.New(srv.URL, client.WithAPIKey("ops-key"))
+	_, err = ops.ListRegistryCredentials(ctx)
+	assert.True(t, errors.Is(err, client.ErrNotImplemented), "got %v", err)
+}
+
+type pullJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// pullImage pulls image through the API and returns the finished job.
+func pullImage(t *testing.T, image string) pullJob {
+	t.Helper()
+	resp := postJSON(t, BaseURL+"/images/pull", map[string]string{"image": image})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var job pullJob
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for job.Status != "done" && job.Status != "failed" {
+		require.True(t, time.Now().Before(deadline), "pull did not finish in time")
+		time.Sleep(200 * time.Millisecond)
+		resp, err := http.Get(BaseURL + "/images/pull/" + job.ID)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		resp.Body.Close()
+	}
+	return job
+}
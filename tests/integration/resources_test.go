@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateResources(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "memory_mb": 512, "cpu_cores": 1})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	res, err := c.UpdateResources(ctx, id, client.Resources{MemoryMB: 1024})
+	require.NoError(t, err)
+	assert.Equal(t, client.Resources{MemoryMB: 1024, CPUCores: 1}, *res)
+	res, err = c.UpdateResources(ctx, id, client.Resources{CPUCores: 0.5})
+	require.NoError(t, err)
+	assert.Equal(t, client.Resources{MemoryMB: 1024, CPUCores: 0.5}, *res)
+
+	// The container runs with the new limits
+	sb, err := c.GetSandbox(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), sb.Config.MemoryMB)
+	assert.Equal(t, 0.5, sb.Config.CPUCores)
+	stats, err := c.Stats(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024<<20), stats.MemoryLimitBytes)
+
+	events, err := c.Timeline(ctx, id)
+	require.NoError(t, err)
+	var resized []string
+	for _, ev := range events {
+		if ev.Type == "resized" {
+			resized = append(resized, ev.Detail)
+		}
+	}
+	assert.Equal(t, []string{"memory 1024 MB, 1 CPUs", "memory 1024 MB, 0.5 CPUs"}, resized)
+
+	_, err = c.UpdateResources(ctx, id, client.Resources{MemoryMB: 100000})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.UpdateResources(ctx, id, client.Resources{})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+}
+
+// TestWasmUpdateResources covers the API without Docker: the wasm driver
+// cannot change the limits of running modules.
+func TestWasmUpdateResources(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+
+	_, err = c.UpdateResources(ctx, sb.ID, client.Resources{CPUCores: -1})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.UpdateResources(ctx, sb.ID, client.Resources{MemoryMB: 1024})
+	assert.True(t, errors.Is(err, client.ErrNotImplemented), "got %v", err)
+}
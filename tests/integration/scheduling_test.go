@@ -0,0 +1,211 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmExecScheduling(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	keys := []api.APIKey{
+		{Name: "chat", Key: "chat-key", Priority: api.PriorityInteractive},
+		{Name: "nightly", Key: "nightly-key", Priority: api.PriorityBatch},
+	}
+	serve := func(s api.ExecScheduling) string {
+		e := echo.New()
+		api.NewHandler(d, "", api.WithAPIKeys(keys), api.WithExecScheduling(s)).RegisterRoutes(e)
+		srv := httptest.NewServer(e)
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	ctx := context.Background()
+	sandbox := func(c *client.Client) string {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+		return sb.ID
+	}
+
+	url := serve(api.ExecScheduling{Slots: 1, MaxQueue: 2, MaxWait: 10 * time.Second})
+	chat := client.New(url, client.WithAPIKey("chat-key"))
+	nightly := client.New(url, client.WithAPIKey("nightly-key"))
+	chatSB, nightlySB := sandbox(chat), sandbox(nightly)
+
+	// The batch tenant fills the slot and its queue; the interactive exec
+	// that comes last runs next
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	run := func(c *client.Client, id, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "sleep 300ms"})
+			assert.NoError(t, err, name)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	run(nightly, nightlySB, "batch-1")
+	run(nightly, nightlySB, "batch-2")
+	run(nightly, nightlySB, "batch-3")
+
+	// A full queue is refused at once, with where the exec would have
+	// been and when to retry
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/sandbox/"+nightlySB+"/exec",
+		strings.NewReader(`{"language": "bash", "code": "echo late"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Boxed-API-Key", "nightly-key")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var body api.APIError
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, api.CodeQuotaExceeded, body.Code)
+	assert.Equal(t, 3, body.QueuePosition)
+	assert.Equal(t, "3", resp.Header.Get("Retry-After"))
+	assert.Equal(t, 3, body.RetryAfter)
+
+	run(chat, chatSB, "chat")
+	wg.Wait()
+	assert.Equal(t, []string{"batch-1", "chat", "batch-2", "batch-3"}, order)
+
+	// Execs that wait too long give up with their place in the queue
+	url = serve(api.ExecScheduling{Slots: 1, MaxWait: 200 * time.Millisecond})
+	nightly = client.New(url, client.WithAPIKey("nightly-key"))
+	done := make(chan error)
+	go func() {
+		_, err := nightly.Exec(ctx, nightlySB, client.ExecRequest{Language: "bash", Code: "sleep 1s"})
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_, err = nightly.Exec(ctx, nightlySB, client.ExecRequest{Language: "bash", Code: "echo waited"})
+	assert.True(t, errors.Is(err, client.ErrQuotaExceeded), "got %v", err)
+	var apiErr *client.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, 1, apiErr.QueuePosition)
+	assert.GreaterOrEqual(t, apiErr.RetryAfter, 1)
+	assert.Contains(t, apiErr.Message, "no exec slot within 200ms")
+
+	// Jobs wait for a slot as long as it takes
+	job, err := nightly.CreateJob(ctx, nightlySB, client.JobRequest{ExecRequest: client.ExecRequest{Language: "bash", Code: "echo queued"}})
+	require.NoError(t, err)
+	job, err = nightly.WaitJob(ctx, job.ID, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "done", job.Status)
+	require.NoError(t, <-done)
+
+	assert.ErrorContains(t, api.CheckExecScheduling(api.ExecScheduling{Slots: 4},
+		[]api.APIKey{{Name: "x", Key: "x", Priority: "urgent"}}), "priority class urgent is not defined")
+	assert.ErrorContains(t, api.CheckExecScheduling(api.ExecScheduling{Classes: map[string]int{"standard": 0}}, nil), "positive weight")
+}
+
+func TestWasmSandboxExecSlots(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithExecScheduling(api.ExecScheduling{SandboxSlots: 1, MaxWait: 5 * time.Second})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+	sandbox := func() string {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.DeleteSandbox(ctx, sb.ID) })
+		return sb.ID
+	}
+	id, other := sandbox(), sandbox()
+
+	busy := func() chan error {
+		done := make(chan error)
+		go func() {
+			_, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "sleep 500ms"})
+			done <- err
+		}()
+		time.Sleep(100 * time.Millisecond)
+		return done
+	}
+
+	// An exec asking not to wait is refused while the sandbox is busy;
+	// other sandboxes are not
+	done := busy()
+	_, err = c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "echo now", IfBusy: client.IfBusyReject})
+	assert.ErrorIs(t, err, client.ErrSandboxBusy)
+	var apiErr *client.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+	assert.Equal(t, 1, apiErr.QueuePosition)
+	assert.GreaterOrEqual(t, apiErr.RetryAfter, 1)
+
+	res, err := c.Exec(ctx, other, client.ExecRequest{Language: "bash", Code: "echo free"})
+	require.NoError(t, err)
+	assert.Zero(t, res.QueuePosition)
+
+	// Others wait their turn and say so
+	res, err = c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "echo after"})
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Equal(t, "echo after\n", res.Stdout)
+	assert.Equal(t, 1, res.QueuePosition)
+	assert.Positive(t, res.QueuedMS)
+
+	// Streamed execs are told before they run
+	done = busy()
+	var types []string
+	exit, err := c.ExecStream(ctx, id, client.ExecRequest{Language: "bash", Code: "echo streamed"}, func(ev client.ExecEvent) error {
+		types = append(types, ev.Type)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	require.NotEmpty(t, types)
+	assert.Equal(t, client.ExecEventQueued, types[0])
+	assert.NotContains(t, types[1:], client.ExecEventQueued)
+	assert.Equal(t, 1, exit.QueuePosition)
+	assert.Positive(t, exit.QueuedMS)
+
+	execs, err := c.ListExecs(ctx, id)
+	require.NoError(t, err)
+	require.NotEmpty(t, execs)
+	assert.Positive(t, execs[len(execs)-1].QueuedMS)
+
+	_, err = c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: "true", IfBusy: "later"})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var metrics strings.Builder
+	_, err = io.Copy(&metrics, resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, metrics.String(), `boxed_exec_sandbox_busy_total{reason="rejected"} 1`)
+}
@@ -0,0 +1,216 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secretShell prints the variable named by "env NAME", the file named by
+// "cat PATH", and any other code as is.
+const secretShell = `package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	code := os.Args[len(os.Args)-1]
+	if name, ok := strings.CutPrefix(code, "env "); ok {
+		fmt.Println(os.Getenv(name))
+		return
+	}
+	if path, ok := strings.CutPrefix(code, "cat "); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println(code)
+}
+`
+
+func TestWasmSecrets(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", secretShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	// get returns the raw body of GET path
+	get := func(path string) string {
+		resp, err := http.Get(srv.URL + "/v1" + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		return string(body)
+	}
+
+	const token, key, once = "tok-3f9c2a7e", `key "with" quotes`, "exec-only-51d0"
+	_, err = c.CreateSecret(ctx, "API_TOKEN", token)
+	require.NoError(t, err)
+	_, err = c.CreateSecret(ctx, "API_TOKEN", "other")
+	assert.ErrorIs(t, err, client.ErrConflict)
+	_, err = c.CreateSecret(ctx, "not-a-name", "x")
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+	_, err = c.SetSecret(ctx, "SIGNING_KEY", key)
+	require.NoError(t, err)
+	_, err = c.SetSecret(ctx, "ONCE", once)
+	require.NoError(t, err)
+
+	secrets, err := c.ListSecrets(ctx)
+	require.NoError(t, err)
+	require.Len(t, secrets, 3)
+	assert.Equal(t, "API_TOKEN", secrets[0].Name)
+	assert.NotContains(t, get("/secrets"), token)
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Secrets: []client.SecretRef{
+			{Name: "API_TOKEN"},
+			{Name: "SIGNING_KEY", Env: "KEY", Path: "/run/secrets/key"},
+		},
+	})
+	require.NoError(t, err)
+
+	// The values reach the code but not its output
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env API_TOKEN"})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env KEY"})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "cat /run/secrets/key"})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "token is " + token})
+	require.NoError(t, err)
+	assert.Equal(t, "token is ***\n", res.Stdout)
+
+	// Exec secrets are variables for that exec only, masked from then on
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env ONCE",
+		Secrets: []client.SecretRef{{Name: "ONCE"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env ONCE"})
+	require.NoError(t, err)
+	assert.Equal(t, "\n", res.Stdout)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env X",
+		Secrets: []client.SecretRef{{Name: "MISSING"}}})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+
+	// Neither the history nor the sandbox's info gives them away
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, execs)
+	for _, rec := range execs {
+		assert.NotContains(t, rec.Code+rec.Stdout, token)
+	}
+	info := get("/sandbox/" + sb.ID)
+	assert.NotContains(t, info, token)
+	assert.NotContains(t, info, base64.StdEncoding.EncodeToString([]byte(key)))
+	assert.NotContains(t, get("/sandbox"), token)
+
+	// Masking is per sandbox
+	other, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	res, err = c.Exec(ctx, other.ID, client.ExecRequest{Language: "bash", Code: token})
+	require.NoError(t, err)
+	assert.Equal(t, token+"\n", res.Stdout)
+
+	// A deleted secret stays in, and masked in, the sandboxes that have it
+	require.NoError(t, c.DeleteSecret(ctx, "API_TOKEN"))
+	assert.ErrorIs(t, c.DeleteSecret(ctx, "API_TOKEN"), client.ErrNotFound)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env API_TOKEN"})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim",
+		Secrets: []client.SecretRef{{Name: "API_TOKEN"}}})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+}
+
+func TestWasmSecretsOwners(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", secretShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	ops := client.New(srv.URL, client.WithAPIKey("ops-key"))
+	ctx := context.Background()
+
+	const aliceToken = "alice-tok-8c1e"
+	_, err = alice.CreateSecret(ctx, "API_TOKEN", aliceToken)
+	require.NoError(t, err)
+
+	// Others neither see nor use it, admins included
+	for _, c := range []*client.Client{bob, ops} {
+		secrets, err := c.ListSecrets(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, secrets)
+		_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim",
+			Secrets: []client.SecretRef{{Name: "API_TOKEN"}}})
+		assert.ErrorIs(t, err, client.ErrInvalidRequest)
+		assert.ErrorIs(t, c.DeleteSecret(ctx, "API_TOKEN"), client.ErrNotFound)
+	}
+	sb, err := bob.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	_, err = bob.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env API_TOKEN",
+		Secrets: []client.SecretRef{{Name: "API_TOKEN"}}})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+
+	// The same name is theirs to register, without touching alice's
+	_, err = bob.CreateSecret(ctx, "API_TOKEN", "bob-tok-27fa")
+	require.NoError(t, err)
+	_, err = bob.SetSecret(ctx, "API_TOKEN", "bob-tok-9d03")
+	require.NoError(t, err)
+	res, err := bob.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "env API_TOKEN",
+		Secrets: []client.SecretRef{{Name: "API_TOKEN"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "***\n", res.Stdout)
+	// Alice's value is not among those masked, as bob's sandbox never had it
+	res, err = bob.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: aliceToken})
+	require.NoError(t, err)
+	assert.Equal(t, aliceToken+"\n", res.Stdout)
+
+	secrets, err := alice.ListSecrets(ctx)
+	require.NoError(t, err)
+	require.Len(t, secrets, 1)
+	assert.Equal(t, secrets[0].CreatedAt, secrets[0].UpdatedAt)
+	require.NoError(t, bob.DeleteSecret(ctx, "API_TOKEN"))
+	_, err = alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim",
+		Secrets: []client.SecretRef{{Name: "API_TOKEN"}}})
+	require.NoError(t, err)
+}
@@ -0,0 +1,202 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHardening(t *testing.T) {
+	c := client.New(BaseURL)
+	ctx := context.Background()
+
+	sec := driver.HardenedSecurity()
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Context:  []client.FileInjection{{Path: "in.txt", ContentBase64: "aGk="}},
+		Security: &client.Security{
+			ReadOnlyRootfs:  sec.ReadOnlyRootfs,
+			Tmpfs:           sec.Tmpfs,
+			CapDrop:         sec.CapDrop,
+			NoNewPrivileges: sec.NoNewPrivileges,
+			PidsLimit:       sec.PidsLimit,
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+
+	// The image is read-only, the working directory and scratch space are not
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "touch /etc/x 2>/dev/null && echo rootfs; cat in.txt; touch out /tmp/t /var/tmp/t && echo ok"})
+	require.NoError(t, err)
+	assert.Equal(t, "hiok\n", res.Stdout, res.Stderr)
+
+	// Root has no capabilities left
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "grep CapEff /proc/self/status"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Stdout, "0000000000000000")
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotNil(t, info.Config.Security)
+	assert.True(t, info.Config.Security.ReadOnlyRootfs)
+	assert.Equal(t, int64(256), info.Config.Security.PidsLimit)
+	assert.Equal(t, driver.SeccompDefault, info.Seccomp)
+}
+
+func TestSeccompStrict(t *testing.T) {
+	c := client.New(BaseURL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Security: &client.Security{SeccompProfile: driver.SeccompStrict},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+
+	// Sockets cannot be created; computing still works
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: `
+import socket
+try:
+    socket.socket()
+    print("socket")
+except OSError as e:
+    print("denied")
+print(sum(range(10)))`})
+	require.NoError(t, err)
+	assert.Equal(t, "denied\n45\n", res.Stdout, res.Stderr)
+
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, driver.SeccompStrict, info.Seccomp)
+}
+
+func TestWasmSecurity(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	seccomp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(seccomp, "strict.json"), []byte(`{"defaultAction":"SCMP_ACT_ERRNO"}`), 0o644))
+	e := echo.New()
+	api.NewHandler(d, "",
+		api.WithSecurity(driver.HardenedSecurity(), "trusted/*:*"),
+		api.WithSeccompDir(seccomp),
+	).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	for _, sec := range []client.Security{
+		{CapAdd: []string{"SYS_ADMIN"}},
+		{CapDrop: []string{"cap_chown"}},
+		{Tmpfs: []string{"relative"}},
+		{SeccompProfile: "../etc/passwd"},
+		{PidsLimit: -1},
+	} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Security: &sec})
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, "%+v", sec)
+	}
+
+	// Without settings of its own a sandbox gets the server's default,
+	// unless its image is trusted
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotNil(t, info.Config.Security)
+	assert.True(t, info.Config.Security.ReadOnlyRootfs)
+	assert.Equal(t, []string{"ALL"}, info.Config.Security.CapDrop)
+
+	trusted, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "trusted/python:3.10"})
+	require.NoError(t, err)
+	info, err = c.GetSandbox(ctx, trusted.ID)
+	require.NoError(t, err)
+	assert.Nil(t, info.Config.Security)
+
+	// Explicit settings replace the default; profiles are found by name
+	custom, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Security: &client.Security{SeccompProfile: "strict", CapAdd: []string{"CHOWN"}},
+	})
+	require.NoError(t, err)
+	info, err = c.GetSandbox(ctx, custom.ID)
+	require.NoError(t, err)
+	require.NotNil(t, info.Config.Security)
+	assert.False(t, info.Config.Security.ReadOnlyRootfs)
+	assert.Equal(t, filepath.Join(seccomp, "strict.json"), info.Config.Security.SeccompProfile)
+}
+
+func TestWasmTemplateSeccomp(t *testing.T) {
+	_, err := templates.New(templates.Config{
+		Default:   "bad",
+		Templates: map[string]templates.Template{"bad": {Image: "python:3.10-slim", Seccomp: "../x"}},
+	})
+	assert.Error(t, err)
+
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	catalog, err := templates.New(templates.Config{
+		Default:  "plain",
+		Defaults: templates.Template{Seccomp: driver.SeccompStrict},
+		Templates: map[string]templates.Template{
+			"plain": {Image: "python:3.10-slim"},
+			"net":   {Image: "python:3.10-slim", Seccomp: driver.SeccompPermissive},
+			"file":  {Image: "python:3.10-slim", Seccomp: "/etc/boxed/calc.json"},
+		},
+	})
+	require.NoError(t, err)
+	e := echo.New()
+	api.NewHandler(d, "", api.WithTemplates(catalog)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	list, _, err := c.ListTemplates(ctx)
+	require.NoError(t, err)
+	profiles := map[string]string{}
+	for _, tmpl := range list {
+		profiles[tmpl.Name] = tmpl.Seccomp
+	}
+	assert.Equal(t, map[string]string{"plain": "strict", "net": "permissive", "file": "/etc/boxed/calc.json"}, profiles)
+
+	// Templates apply their profile unless the create names one
+	for template, want := range map[string]string{"plain": "strict", "net": "permissive", "file": "/etc/boxed/calc.json"} {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: template, Security: &client.Security{}})
+		require.NoError(t, err, template)
+		info, err := c.GetSandbox(ctx, sb.ID)
+		require.NoError(t, err)
+		require.NotNil(t, info.Config.Security, template)
+		assert.Equal(t, want, info.Config.Security.SeccompProfile, template)
+	}
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "net",
+		Security: &client.Security{SeccompProfile: driver.SeccompDefault},
+	})
+	require.NoError(t, err)
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, driver.SeccompDefault, info.Config.Security.SeccompProfile)
+
+	// Clients cannot turn filtering off, name paths, or profiles the
+	// server does not have
+	for _, profile := range []string{driver.SeccompPermissive, "/etc/boxed/calc.json", "calc"} {
+		_, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+			Template: "plain",
+			Security: &client.Security{SeccompProfile: profile},
+		})
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, profile)
+	}
+}
@@ -0,0 +1,365 @@
+package integration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sessionMessage struct {
+	Method string         `json:"method"`
+	Params map[string]any `json:"params"`
+}
+
+// readUntil reads messages until one has the given method.
+func readUntil(t *testing.T, ws *websocket.Conn, method string) []sessionMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var msgs []sessionMessage
+	for {
+		var msg sessionMessage
+		require.NoError(t, ws.ReadJSON(&msg))
+		msgs = append(msgs, msg)
+		if msg.Method == method {
+			return msgs
+		}
+	}
+}
+
+func TestWasmInteractReattach(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	sb, err := client.New(srv.URL).CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	interact := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/sandbox/" + sb.ID + "/interact"
+
+	ws, resp, err := websocket.DefaultDialer.Dial(interact, nil)
+	require.NoError(t, err)
+	hello := readUntil(t, ws, "session")
+	session := hello[0].Params["id"].(string)
+	assert.Equal(t, session, resp.Header.Get(api.SessionHeader))
+	assert.Equal(t, false, hello[0].Params["resumed"])
+
+	// Drop the connection while an exec runs: its output is kept
+	exec, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "exec",
+		"params":  map[string]any{"cmd": "bash", "args": []string{"-c", "sleep 300ms"}},
+		"id":      2,
+	})
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, exec))
+	ws.UnderlyingConn().Close()
+	time.Sleep(time.Second)
+
+	ws, _, err = websocket.DefaultDialer.Dial(interact+"?session="+session, nil)
+	require.NoError(t, err)
+	msgs := readUntil(t, ws, "exit")
+	assert.Equal(t, "session", msgs[0].Method)
+	assert.Equal(t, true, msgs[0].Params["resumed"])
+	var stdout string
+	for _, msg := range msgs {
+		if msg.Method == "stdout" {
+			stdout += msg.Params["chunk"].(string)
+		}
+	}
+	assert.Equal(t, "sleep 300ms\n", stdout)
+
+	// A normal close ends the session
+	require.NoError(t, ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	_, _, err = ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	ws.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, id := range []string{session, "sess_unknown"} {
+		_, resp, err = websocket.DefaultDialer.Dial(interact+"?session="+id, nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+// floodShell writes as many bytes of output as its code says. Without -c
+// it waits for its input to end, like a quiet REPL.
+const floodShell = `package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		io.Copy(io.Discard, os.Stdin)
+		return
+	}
+	n, _ := strconv.Atoi(os.Args[len(os.Args)-1])
+	line := append(bytes.Repeat([]byte("x"), 4095), '\n')
+	for ; n > 0; n -= len(line) {
+		os.Stdout.Write(line[:min(n, len(line))])
+	}
+}
+`
+
+func TestWasmInteractOverflow(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", floodShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	sb, err := client.New(srv.URL).CreateSandbox(context.Background(), client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	interact := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/sandbox/" + sb.ID + "/interact"
+
+	_, resp, err := websocket.DefaultDialer.Dial(interact+"?overflow=sometimes", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// flood runs a command writing size bytes without reading them for a
+	// while, then returns what the client got
+	const size = 32 << 20
+	flood := func(overflow string) (stdout int, flows []map[string]any) {
+		ws, _, err := websocket.DefaultDialer.Dial(interact+"?overflow="+overflow, nil)
+		require.NoError(t, err)
+		defer ws.Close()
+		// End the session, and its REPL, when done
+		defer ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		readUntil(t, ws, "session")
+		exec, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "exec",
+			"params":  map[string]any{"cmd": "bash", "args": []string{"-c", strconv.Itoa(size)}},
+			"id":      2,
+		})
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, exec))
+		time.Sleep(2 * time.Second)
+
+		ws.SetReadDeadline(time.Now().Add(30 * time.Second))
+		for {
+			_, data, err := ws.ReadMessage()
+			require.NoError(t, err)
+			assert.LessOrEqual(t, len(data), 32*1024)
+			var msg sessionMessage
+			require.NoError(t, json.Unmarshal(data, &msg))
+			switch msg.Method {
+			case "stdout":
+				stdout += len(msg.Params["chunk"].(string))
+			case "flow":
+				flows = append(flows, msg.Params)
+			case "exit":
+				return stdout, flows
+			}
+		}
+	}
+
+	// Blocking holds the process up until the client reads: nothing is lost
+	stdout, flows := flood(api.OverflowBlock)
+	assert.Equal(t, size, stdout)
+	require.NotEmpty(t, flows)
+	assert.Equal(t, "paused", flows[0]["state"])
+	assert.Equal(t, "resumed", flows[len(flows)-1]["state"])
+
+	// Dropping lets the process run on and says what was lost
+	stdout, flows = flood(api.OverflowDrop)
+	assert.Less(t, stdout, size)
+	var dropped float64
+	for _, f := range flows {
+		assert.Equal(t, "dropped", f["state"])
+		dropped += f["bytes"].(float64)
+	}
+	assert.Positive(t, dropped)
+}
+
+func TestWasmInteractNamedSessions(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	interact := func(id string) string {
+		return "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/sandbox/" + id + "/interact"
+	}
+	// output reads stdout until session prints line, skipping what the
+	// replay buffer repeats
+	output := func(ws *websocket.Conn, session, line string) {
+		t.Helper()
+		for {
+			msgs := readUntil(t, ws, "stdout")
+			msg := msgs[len(msgs)-1]
+			if msg.Params["session"] == session && msg.Params["chunk"] == line {
+				return
+			}
+		}
+	}
+	send := func(ws *websocket.Conn, method string, params map[string]any) {
+		t.Helper()
+		msg, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+		require.NoError(t, ws.WriteMessage(websocket.TextMessage, msg))
+	}
+
+	// A named session starts on first use; its events carry the name
+	ws, resp, err := websocket.DefaultDialer.Dial(interact(sb.ID)+"?session=sh", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sh", resp.Header.Get(api.SessionHeader))
+	hello := readUntil(t, ws, "session")
+	assert.Equal(t, false, hello[0].Params["resumed"])
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("one\n")))
+	output(ws, "sh", "one\n")
+
+	// More REPLs run beside it on the same connection
+	send(ws, "repl.start", map[string]any{"cmd": "bash", "session": "kernel"})
+	send(ws, "repl.input", map[string]any{"data": "two\n", "session": "kernel"})
+	output(ws, "kernel", "two\n")
+	send(ws, "repl.input", map[string]any{"data": "three\n", "session": "sh"})
+	output(ws, "sh", "three\n")
+
+	// Clients find the session by name
+	ws.UnderlyingConn().Close()
+	time.Sleep(100 * time.Millisecond)
+	ws, _, err = websocket.DefaultDialer.Dial(interact(sb.ID)+"?session=sh", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	hello = readUntil(t, ws, "session")
+	assert.Equal(t, true, hello[0].Params["resumed"])
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("four\n")))
+	output(ws, "sh", "four\n")
+
+	// Signals reach the REPL of the session only
+	require.NoError(t, c.SignalSession(ctx, sb.ID, "sh", "SIGTERM"))
+	msgs := readUntil(t, ws, "exit")
+	assert.Equal(t, "sh", msgs[len(msgs)-1].Params["session"])
+
+	// Names are per sandbox
+	other, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	ws2, _, err := websocket.DefaultDialer.Dial(interact(other.ID)+"?session=sh", nil)
+	require.NoError(t, err)
+	defer ws2.Close()
+	hello = readUntil(t, ws2, "session")
+	assert.Equal(t, false, hello[0].Params["resumed"])
+
+	_, resp, err = websocket.DefaultDialer.Dial(interact(sb.ID)+"?session=bad%20name", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, resp, err = websocket.DefaultDialer.Dial(interact(sb.ID)+"?session=sess_unknown", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWasmInteractBinaryArtifacts(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	sb, err := client.New(srv.URL).CreateSandbox(context.Background(), client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	interact := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/sandbox/" + sb.ID + "/interact"
+
+	_, resp, err := websocket.DefaultDialer.Dial(interact+"?artifacts=base64", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	ws, _, err := websocket.DefaultDialer.Dial(interact+"?artifacts=binary", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { ws.Close() })
+	hello := readUntil(t, ws, "session")
+	assert.Equal(t, "binary", hello[0].Params["artifacts"])
+
+	// fakeShell writes its code to /output/last.txt: far more than one
+	// message holds
+	code := strings.Repeat("0123456789abcdef", 10000)
+	exec, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "exec",
+		"params":  map[string]any{"cmd": "bash", "args": []string{"-c", code}},
+		"id":      2,
+	})
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, exec))
+
+	// The exit may come before the last frames
+	var header map[string]any
+	frames := map[uint32][]byte{}
+	exited := false
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for !exited || header == nil || len(frames) < int(header["frames"].(float64)) {
+		kind, data, err := ws.ReadMessage()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(data), 32*1024)
+		if kind == websocket.BinaryMessage {
+			require.GreaterOrEqual(t, len(data), 12)
+			assert.Equal(t, uint32(1), binary.BigEndian.Uint32(data[0:]))
+			assert.Equal(t, binary.BigEndian.Uint32(data[8:]), crc32.ChecksumIEEE(data[12:]))
+			frames[binary.BigEndian.Uint32(data[4:])] = data[12:]
+			continue
+		}
+		var msg sessionMessage
+		require.NoError(t, json.Unmarshal(data, &msg))
+		switch msg.Method {
+		case "artifact":
+			header = msg.Params
+			assert.Nil(t, header["data_base64"])
+		case "exit":
+			exited = true
+		}
+	}
+	assert.Equal(t, "last.txt", header["path"])
+	assert.Equal(t, float64(len(code)), header["size"])
+	assert.Equal(t, float64(1), header["stream"])
+	var data []byte
+	for i := range uint32(len(frames)) {
+		data = append(data, frames[i]...)
+	}
+	assert.Equal(t, code, string(data))
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), header["sha256"])
+}
@@ -0,0 +1,162 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBashKernel is installed as bash.wasm. It speaks the bash-session
+// protocol without running bash: "cd <dir>" sets the directory "pwd"
+// prints, "fail" writes an error without a newline and exits 2, "exit <n>"
+// ends the shell, and other code is echoed.
+const fakeBashKernel = `package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	in := bufio.NewReader(os.Stdin)
+	dir := "/workspace"
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line))
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(in, buf); err != nil {
+			return
+		}
+		code, status := string(buf), 0
+		switch {
+		case strings.HasPrefix(code, "cd "):
+			dir = strings.TrimPrefix(code, "cd ")
+		case code == "pwd":
+			fmt.Println(dir)
+		case code == "fail":
+			fmt.Fprint(os.Stderr, "no such file")
+			status = 2
+		case strings.HasPrefix(code, "exit "):
+			n, _ := strconv.Atoi(strings.TrimPrefix(code, "exit "))
+			os.Exit(n)
+		default:
+			fmt.Print(code)
+		}
+		fmt.Printf("\x1eboxed {\"exit\":%d}\n", status)
+		fmt.Fprintf(os.Stderr, "\x1eboxed {\"exit\":%d}\n", status)
+	}
+}
+`
+
+func TestWasmBashSession(t *testing.T) {
+	modules := buildWasmModules(t)
+	buildWasmModule(t, modules, "bash", fakeBashKernel)
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": modules,
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	run := func(code string) *client.ExecResult {
+		t.Helper()
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: api.LanguageBashSession, Code: code})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExitCode)
+		return res
+	}
+
+	// The directory changed by one exec is the next one's
+	res := run("cd /workspace/proj")
+	assert.Equal(t, 0, *res.ExitCode)
+	assert.Empty(t, res.Stdout)
+	assert.Equal(t, "/workspace/proj\n", run("pwd").Stdout)
+
+	// Output without a trailing newline is kept whole
+	assert.Equal(t, "echo -n hi", run("echo -n hi").Stdout)
+	res = run("fail")
+	assert.Equal(t, 2, *res.ExitCode)
+	assert.Equal(t, "no such file", res.Stderr)
+	assert.Equal(t, "/workspace/proj\n", run("pwd").Stdout)
+
+	// exit ends the shell with its code; the next exec gets a new one
+	res = run("exit 3")
+	assert.Equal(t, 3, *res.ExitCode)
+	assert.Contains(t, res.Stderr, "bash session ended")
+	assert.Equal(t, "/workspace\n", run("pwd").Stdout)
+
+	for _, req := range []client.ExecRequest{
+		{Language: api.LanguageBashSession, Code: "pwd", Cwd: "/tmp"},
+		{Language: api.LanguageBashSession, Code: "pwd", Env: map[string]string{"FOO": "1"}},
+		{Language: api.LanguageBashSession, File: "/workspace/run.sh"},
+	} {
+		_, err := c.Exec(ctx, sb.ID, req)
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, "%+v", req)
+	}
+
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, execs)
+	assert.Equal(t, api.LanguageBashSession, execs[0].Language)
+
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+}
+
+func TestBashSession(t *testing.T) {
+	c := client.New(BaseURL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+	run := func(code string) *client.ExecResult {
+		t.Helper()
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: api.LanguageBashSession, Code: code})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExitCode)
+		return res
+	}
+
+	res := run("mkdir -p proj && cd proj && export FOO=1 && greet() { echo \"hi $1\"; }")
+	assert.Equal(t, 0, *res.ExitCode, res.Stderr)
+	res = run("basename \"$PWD\"; echo $FOO; greet you; printf 'no newline'")
+	assert.Equal(t, "proj\n1\nhi you\nno newline", res.Stdout)
+
+	// Errors arrive in full before the exec returns
+	res = run("ls /nope; echo after >&2; false")
+	assert.Equal(t, 1, *res.ExitCode)
+	assert.Contains(t, res.Stderr, "/nope")
+	assert.Contains(t, res.Stderr, "after\n")
+
+	// Reading stdin gets end-of-file rather than the next exec
+	res = run("read line; echo \"read $?\"")
+	assert.Equal(t, "read 1\n", res.Stdout)
+
+	res = run("exit 7")
+	assert.Equal(t, 7, *res.ExitCode)
+	res = run("echo ${FOO:-unset}")
+	assert.Equal(t, "unset\n", res.Stdout)
+}
@@ -0,0 +1,64 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSidecars(t *testing.T) {
+	id := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  120,
+		"sidecars": []map[string]any{
+			{
+				"name": "web",
+				"cmd":  []string{"python3", "-m", "http.server", "8000", "--directory", "/tmp"},
+				"health_check": map[string]any{
+					"cmd": []string{"python3", "-c", "import urllib.request; urllib.request.urlopen('http://127.0.0.1:8000')"},
+				},
+			},
+		},
+	})
+
+	// The sidecar is reachable from user code
+	result := execCode(t, id, "python", "import urllib.request; print(urllib.request.urlopen('http://127.0.0.1:8000').status)")
+	assert.Contains(t, result.Stdout, "200")
+
+	// And reported in the sandbox info
+	resp, err := http.Get(BaseURL + "/sandbox/" + id)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var info struct {
+		Sidecars []struct {
+			Name    string `json:"name"`
+			Running bool   `json:"running"`
+		} `json:"sidecars"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	require.Len(t, info.Sidecars, 1)
+	assert.Equal(t, "web", info.Sidecars[0].Name)
+	assert.True(t, info.Sidecars[0].Running)
+}
+
+func TestSidecarUnhealthyFailsCreate(t *testing.T) {
+	payload := map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  60,
+		"sidecars": []map[string]any{
+			{
+				"name":         "broken",
+				"cmd":          []string{"sleep", "300"},
+				"health_check": map[string]any{"cmd": []string{"false"}, "retries": 2, "interval_ms": 100},
+			},
+		},
+	}
+	resp := postJSON(t, BaseURL+"/sandbox", payload)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
@@ -0,0 +1,98 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmSignal(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+
+	var apiErr *client.APIError
+	_, err = c.Signal(ctx, sb.ID, "SIGSEGV")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+
+	_, err = c.Signal(ctx, sb.ID, "SIGINT")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode, "nothing is running")
+
+	// A hung exec is interrupted without destroying the sandbox
+	results := make(chan *client.ExecResult, 1)
+	go func() {
+		res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 30s"})
+		assert.NoError(t, err)
+		results <- res
+	}()
+	require.Eventually(t, func() bool {
+		n, err := c.Signal(ctx, sb.ID, "int")
+		return err == nil && n == 1
+	}, 10*time.Second, 20*time.Millisecond)
+
+	select {
+	case res := <-results:
+		assert.Equal(t, driver.ExitSignaled, res.ExitReason)
+		assert.Equal(t, 2, res.Signal)
+		require.NotNil(t, res.ExitCode)
+		assert.Equal(t, 130, *res.ExitCode)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the exec was not interrupted")
+	}
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "echo still here"})
+	require.NoError(t, err)
+	assert.Equal(t, "echo still here\n", res.Stdout)
+
+	timeline, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	var signaled []string
+	for _, ev := range timeline {
+		if ev.Type == "signaled" {
+			signaled = append(signaled, ev.Detail)
+		}
+	}
+	assert.Equal(t, []string{"SIGINT to exec"}, signaled)
+
+	// A session's REPL is signaled by its ID
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/sandbox/"+sb.ID+"/interact", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	session := resp.Header.Get(api.SessionHeader)
+	readUntil(t, ws, "session")
+
+	err = c.SignalSession(ctx, sb.ID, "sess_missing", "SIGTERM")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "not_found", apiErr.Code)
+
+	require.NoError(t, c.SignalSession(ctx, sb.ID, session, "SIGTERM"))
+	msgs := readUntil(t, ws, "exit")
+	exit := msgs[len(msgs)-1].Params
+	assert.EqualValues(t, 15, exit["signal"])
+	assert.EqualValues(t, 143, exit["code"])
+}
@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWasmSSH(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+	}))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	// The host key is saved, and the same one loaded again
+	keyFile := filepath.Join(t.TempDir(), "ssh", "host_key")
+	hostKey, err := api.LoadSSHHostKey(keyFile)
+	require.NoError(t, err)
+	again, err := api.LoadSSHHostKey(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, hostKey.PublicKey().Marshal(), again.PublicKey().Marshal())
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	ss := h.NewSSHServer(hostKey)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- ss.Serve(lis) }()
+
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	sb, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	dial := func(user, password string) (*ssh.Client, error) {
+		return ssh.Dial("tcp", lis.Addr().String(), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.Password(password)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+			Timeout:         10 * time.Second,
+		})
+	}
+
+	// Logins need a valid key and a sandbox the key's owner can use
+	_, err = dial(sb.ID, "wrong-key")
+	assert.Error(t, err)
+	_, err = dial(sb.ID, "bob-key")
+	assert.Error(t, err)
+	_, err = dial("sbx_missing", "alice-key")
+	assert.Error(t, err)
+	conn, err := dial(strings.TrimPrefix(sb.ID, "sbx_")[:8], "alice-key")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// A command runs in the sandbox with its output and exit status
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	out, err := session.Output("hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+
+	session, err = conn.NewSession()
+	require.NoError(t, err)
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	err = session.Run("fail")
+	var exitErr *ssh.ExitError
+	require.True(t, errors.As(err, &exitErr), "got %v", err)
+	assert.Equal(t, 3, exitErr.ExitStatus())
+	assert.Contains(t, stderr.String(), "failing")
+
+	// A terminal is refused; a shell reads its input until EOF
+	session, err = conn.NewSession()
+	require.NoError(t, err)
+	assert.Error(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	require.NoError(t, session.Shell())
+	_, err = io.WriteString(stdin, "one\ntwo\n")
+	require.NoError(t, err)
+	require.NoError(t, stdin.Close())
+	require.NoError(t, session.Wait())
+	assert.Equal(t, "one\ntwo\n", stdout.String())
+
+	// Port forwarding is not offered
+	_, err = conn.Dial("tcp", "127.0.0.1:80")
+	assert.Error(t, err)
+
+	// SFTP moves files, starting in the working directory
+	fc, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	t.Cleanup(func() { fc.Close() })
+	wd, err := fc.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, "/workspace", wd)
+
+	f, err := fc.Create("notes.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("written over sftp"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	rc, err := alice.DownloadFile(ctx, sb.ID, "/workspace/notes.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "written over sftp", string(data))
+
+	require.NoError(t, alice.UploadFile(ctx, sb.ID, "/workspace/sub/data.csv", strings.NewReader("a,b\n")))
+	f, err = fc.Open("/workspace/sub/data.csv")
+	require.NoError(t, err)
+	data, err = io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "a,b\n", string(data))
+
+	entries, err := fc.ReadDir("/workspace")
+	require.NoError(t, err)
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.Name()] = e.IsDir()
+	}
+	assert.Equal(t, map[string]bool{"notes.txt": false, "sub": true}, names)
+	st, err := fc.Stat("notes.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("written over sftp")), st.Size())
+	_, err = fc.Stat("missing.txt")
+	assert.True(t, errors.Is(err, os.ErrNotExist), "got %v", err)
+
+	// Operations the files API has no call for run as commands, which the
+	// wasm sandbox does not have
+	assert.Error(t, fc.Mkdir("new"))
+
+	// Shutdown ends the open connection
+	shutdownCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, ss.Shutdown(shutdownCtx), context.DeadlineExceeded)
+	assert.ErrorIs(t, <-served, api.ErrSSHServerClosed)
+	_, err = conn.NewSession()
+	assert.Error(t, err)
+}
+
+func TestWasmSSHExecSlots(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	h := api.NewHandler(d, "",
+		api.WithExecScheduling(api.ExecScheduling{SandboxSlots: 1, MaxWait: 500 * time.Millisecond}),
+		api.WithInputLimits(api.InputLimits{CodeSize: 16, Args: 8, EnvVars: 8, EnvSize: 1024, BodySize: 1 << 20}))
+	e := echo.New()
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	hostKey, err := api.LoadSSHHostKey(filepath.Join(t.TempDir(), "host_key"))
+	require.NoError(t, err)
+	ss := h.NewSSHServer(hostKey)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go ss.Serve(lis)
+	t.Cleanup(func() { ss.Shutdown(ctx) })
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	conn, err := ssh.Dial("tcp", lis.Addr().String(), &ssh.ClientConfig{
+		User:            sb.ID,
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         10 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	run := func(cmd string) (string, error) {
+		session, err := conn.NewSession()
+		require.NoError(t, err)
+		var stderr bytes.Buffer
+		session.Stderr = &stderr
+		err = session.Run(cmd)
+		return stderr.String(), err
+	}
+	_, err = run("warm up")
+	require.NoError(t, err)
+
+	// A command waits for the sandbox's slot, and gives up like an exec
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "sleep 2s"})
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	stderr, err := run("echo now")
+	var exitErr *ssh.ExitError
+	require.True(t, errors.As(err, &exitErr), "got %v", err)
+	assert.Equal(t, 255, exitErr.ExitStatus())
+	assert.Contains(t, stderr, "sandbox_busy")
+	require.NoError(t, <-done)
+
+	// While a shell is open execs find the sandbox busy
+	session, err := conn.NewSession()
+	require.NoError(t, err)
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	require.Eventually(t, func() bool {
+		_, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "x", IfBusy: client.IfBusyReject})
+		return errors.Is(err, client.ErrSandboxBusy)
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NoError(t, stdin.Close())
+	require.NoError(t, session.Wait())
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "x", IfBusy: client.IfBusyReject})
+	assert.NoError(t, err)
+
+	// Commands are held to the input limits of execs
+	_, err = run(strings.Repeat("x", 17))
+	assert.Error(t, err)
+}
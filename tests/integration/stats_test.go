@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hogShell holds 64 MiB of memory for as many seconds as its code says and
+// writes a 1 KiB file.
+const hogShell = `package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+var hog []byte
+
+func main() {
+	os.WriteFile("/workspace/out.bin", make([]byte, 1024), 0644)
+	hog = make([]byte, 64<<20)
+	for i := range hog {
+		hog[i] = 1
+	}
+	secs, _ := strconv.Atoi(os.Args[len(os.Args)-1])
+	time.Sleep(time.Duration(secs) * time.Second)
+}
+`
+
+func TestWasmStats(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", hogShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	stats, err := c.Stats(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Zero(t, stats.MemoryBytes)
+	assert.Equal(t, uint64(512<<20), stats.MemoryLimitBytes)
+	assert.Nil(t, stats.CPUPercent)
+
+	// The memory of a running module is counted, and released when it exits
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "2"})
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		stats, err := c.Stats(ctx, sb.ID)
+		return err == nil && stats.MemoryBytes >= 64<<20
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NoError(t, <-done)
+	stats, err = c.Stats(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Zero(t, stats.MemoryBytes)
+	assert.GreaterOrEqual(t, stats.DiskBytes, uint64(1024))
+
+	var samples []client.ResourceStats
+	enough := errors.New("enough")
+	err = c.StreamStats(ctx, sb.ID, 250*time.Millisecond, func(s client.ResourceStats) error {
+		samples = append(samples, s)
+		if len(samples) == 3 {
+			return enough
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, enough)
+	require.Len(t, samples, 3)
+	assert.True(t, samples[2].Time.After(samples[0].Time))
+
+	// Stopping the sandbox ends the stream
+	streamed := make(chan error, 1)
+	go func() {
+		streamed <- c.StreamStats(ctx, sb.ID, 250*time.Millisecond, func(client.ResourceStats) error { return nil })
+	}()
+	time.Sleep(300 * time.Millisecond)
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+	select {
+	case err := <-streamed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end after the sandbox stopped")
+	}
+
+	_, err = c.Stats(ctx, sb.ID)
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	err = c.StreamStats(ctx, "nope", 0, func(client.ResourceStats) error { return nil })
+	assert.True(t, errors.Is(err, client.ErrSandboxNotFound), "got %v", err)
+	err = c.StreamStats(ctx, sb.ID, time.Hour, func(client.ResourceStats) error { return nil })
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+}
@@ -0,0 +1,264 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/internal/templates"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmTemplates(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	path := filepath.Join(t.TempDir(), "templates.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+default: small
+defaults:
+  memory_mb: 256
+templates:
+  small:
+    image: python:3.10-slim
+    memory_mb: 128
+    cpu_cores: 0.5
+    timeout: 2m
+  repl:
+    image: python:3.10-slim
+    cpu_cores: 0.25
+    cpu_burst:
+      period_ms: 800
+      shares: 256
+images: ["python:*"]
+`), 0o644))
+	catalog, err := templates.Load(path)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go catalog.Watch(ctx)
+
+	e := echo.New()
+	api.NewHandler(d, "", api.WithTemplates(catalog)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+
+	list, images, err := c.ListTemplates(ctx)
+	require.NoError(t, err)
+	burst := &client.CPUBurst{PeriodMS: 800, Shares: 256}
+	assert.Equal(t, []client.Template{
+		{Name: "repl", Image: "python:3.10-slim", MemoryMB: 256, CPUCores: 0.25, CPUBurst: burst},
+		{Name: "small", Image: "python:3.10-slim", MemoryMB: 128, CPUCores: 0.5, Timeout: 120, Default: true},
+	}, list)
+	assert.Equal(t, []string{"python:*"}, images)
+
+	// A burst goes with the template's sandboxes
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "repl"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	info, err := c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, burst, info.Config.CPUBurst)
+
+	// The default template brings its resources and lifetime
+	sb, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	info, err = c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "python:3.10-slim", info.Config.Image)
+	assert.Equal(t, int64(128), info.Config.MemoryMB)
+	assert.Equal(t, 0.5, info.Config.CPUCores)
+	assert.Nil(t, info.Config.CPUBurst)
+	require.NotNil(t, info.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), *info.ExpiresAt, 10*time.Second)
+
+	// Allowed images get the catalog's default resources
+	sb, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.11-slim"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	info, err = c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(256), info.Config.MemoryMB)
+	assert.Equal(t, 1.0, info.Config.CPUCores)
+
+	// Unknown templates are rejected rather than replaced by the default
+	var apiErr *client.APIError
+	for _, name := range []string{"node", "node:20-slim"} {
+		_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: name})
+		require.True(t, errors.As(err, &apiErr), name)
+		assert.Equal(t, "invalid_request", apiErr.Code, name)
+		assert.Contains(t, apiErr.Message, "unknown template", name)
+	}
+
+	// Changes to the file apply without a restart
+	require.NoError(t, os.WriteFile(path, []byte(`
+default: small
+templates:
+  small:
+    image: python:3.10-slim
+  node:
+    image: node:20-slim
+    memory_mb: 1024
+`), 0o644))
+	require.Eventually(t, func() bool {
+		list, images, err = c.ListTemplates(ctx)
+		return err == nil && len(list) == 2 && list[0].Name == "node"
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Nil(t, images, "every image is allowed")
+	sb, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "node"})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, sb.ID)
+	info, err = c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "node:20-slim", info.Config.Image)
+	assert.Equal(t, int64(1024), info.Config.MemoryMB)
+
+	// Bursts the kernel cannot apply are refused
+	for burst, want := range map[string]string{
+		"{period_ms: 2000}":                "between 1 and 1000",
+		"{shares: 1}":                      "between 2 and 262144",
+		"{period_ms: 10}, cpu_cores: 0.05": "too short for 0.05 cores",
+	} {
+		file := filepath.Join(t.TempDir(), "bad.yaml")
+		require.NoError(t, os.WriteFile(file, []byte("default: t\ntemplates:\n  t: {image: python:3.10-slim, cpu_burst: "+burst+"}\n"), 0o644))
+		_, err = templates.Load(file)
+		assert.ErrorContains(t, err, want, burst)
+	}
+
+	// An invalid file keeps the templates in use
+	require.NoError(t, os.WriteFile(path, []byte("default: missing\n"), 0o644))
+	time.Sleep(500 * time.Millisecond)
+	list, _, err = c.ListTemplates(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestWasmPublishTemplate(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+
+	path := filepath.Join(t.TempDir(), "templates.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+default: small
+templates:
+  small:
+    image: python:3.10-slim
+    memory_mb: 256
+  base:v1:
+    image: python:3.11-slim
+`), 0o644))
+	catalog, err := templates.Load(path)
+	require.NoError(t, err)
+	e := echo.New()
+	h := api.NewHandler(d, "", api.WithTemplates(catalog), api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+	}))
+	h.RegisterRoutes(e)
+	gs := h.NewGRPCServer()
+	t.Cleanup(gs.Stop)
+	api.MountGRPC(e, gs)
+	srv := httptest.NewUnstartedServer(e)
+	srv.Config.Protocols = e.Server.Protocols
+	srv.Start()
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	c := client.New(srv.URL, client.WithAPIKey("alice-key"))
+
+	// Prepare an environment and publish it
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "small"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), sb.ID) })
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/opt/env/config.txt", strings.NewReader("prepared")))
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/tmp/scratch.txt", strings.NewReader("scratch")))
+	tmpl, err := c.PublishTemplate(ctx, sb.ID, client.PublishTemplateRequest{Name: "my-env", Tag: "v1"})
+	require.NoError(t, err)
+	assert.Equal(t, &client.Template{Name: "my-env:v1", Image: "boxed-templates/my-env:v1", MemoryMB: 256, CPUCores: 1, Published: true}, tmpl)
+	list, _, err := c.ListTemplates(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, list, *tmpl)
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "published", events[len(events)-1].Type)
+	assert.Equal(t, "template my-env:v1", events[len(events)-1].Detail)
+
+	// Sandboxes of the template start from the saved filesystem, without
+	// what /tmp held
+	again, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "my-env:v1"})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.DeleteSandbox(context.Background(), again.ID) })
+	info, err := c.GetSandbox(ctx, again.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "boxed-templates/my-env:v1", info.Config.Image)
+	assert.Equal(t, int64(256), info.Config.MemoryMB)
+	rc, err := c.DownloadFile(ctx, again.ID, "/opt/env/config.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "prepared", string(content))
+	_, err = c.DownloadFile(ctx, again.ID, "/tmp/scratch.txt")
+	assert.Error(t, err)
+
+	// The tag defaults to latest, and the catalog reloading keeps
+	// published templates
+	tmpl, err = c.PublishTemplate(ctx, again.ID, client.PublishTemplateRequest{Name: "my-env"})
+	require.NoError(t, err)
+	assert.Equal(t, "my-env:latest", tmpl.Name)
+	require.NoError(t, catalog.Reload())
+	_, ok := catalog.Lookup("my-env:latest")
+	assert.True(t, ok)
+
+	conflict := func(err error) {
+		t.Helper()
+		var apiErr *client.APIError
+		require.True(t, errors.As(err, &apiErr), "got %v", err)
+		assert.Equal(t, "conflict", apiErr.Code)
+	}
+	for _, req := range []client.PublishTemplateRequest{
+		{Name: "My-Env"},
+		{Name: "my-env", Tag: "v 1"},
+		{Name: "-env"},
+		{Name: "my-env", InitTimeout: 10},
+	} {
+		_, err = c.PublishTemplate(ctx, sb.ID, req)
+		assert.ErrorIs(t, err, client.ErrInvalidRequest, "%+v", req)
+	}
+	// Names the catalog's file defines, or another caller published, are
+	// taken
+	_, err = c.PublishTemplate(ctx, sb.ID, client.PublishTemplateRequest{Name: "base", Tag: "v1"})
+	conflict(err)
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	theirs, err := bob.CreateSandbox(ctx, client.CreateSandboxRequest{})
+	require.NoError(t, err)
+	t.Cleanup(func() { bob.DeleteSandbox(context.Background(), theirs.ID) })
+	_, err = bob.PublishTemplate(ctx, theirs.ID, client.PublishTemplateRequest{Name: "my-env", Tag: "v1"})
+	conflict(err)
+	_, err = bob.PublishTemplate(ctx, sb.ID, client.PublishTemplateRequest{Name: "bobs-env"})
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+
+	// Over gRPC too
+	g, err := client.DialGRPC(strings.TrimPrefix(srv.URL, "http://"), []client.Option{client.WithAPIKey("bob-key")})
+	require.NoError(t, err)
+	t.Cleanup(func() { g.Close() })
+	tmpl, err = g.PublishTemplate(ctx, theirs.ID, client.PublishTemplateRequest{Name: "bobs-env", Tag: "v2"})
+	require.NoError(t, err)
+	assert.Equal(t, "boxed-templates/bobs-env:v2", tmpl.Image)
+	_, err = g.PublishTemplate(ctx, theirs.ID, client.PublishTemplateRequest{Name: "my-env", Tag: "v1"})
+	conflict(err)
+}
@@ -0,0 +1,18 @@
+// Command wasmplugin serves the WebAssembly driver as a driver plugin, for
+// the plugin tests.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/akshayaggarwal99/boxed/internal/driver/wasm"
+	"github.com/akshayaggarwal99/boxed/pkg/plugin"
+)
+
+func main() {
+	if err := plugin.Serve(wasm.New); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
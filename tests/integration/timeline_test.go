@@ -0,0 +1,67 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timelineEvent struct {
+	Type       string `json:"type"`
+	OffsetMS   int64  `json:"offset_ms"`
+	DurationMS int64  `json:"duration_ms"`
+	Detail     string `json:"detail"`
+	Error      string `json:"error"`
+}
+
+func getTimeline(t *testing.T, id string) []timelineEvent {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("%s/sandbox/%s/timeline", BaseURL, id))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var timeline struct {
+		SandboxID string          `json:"sandbox_id"`
+		Events    []timelineEvent `json:"events"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&timeline))
+	assert.Equal(t, id, timeline.SandboxID)
+	return timeline.Events
+}
+
+func TestSandboxTimeline(t *testing.T) {
+	id := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  120,
+	})
+
+	execCode(t, id, "python", "print('hi')")
+
+	var types []string
+	for _, ev := range getTimeline(t, id) {
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, []string{"created", "started", "agent_ready", "exec"}, types)
+
+	// The timeline survives the sandbox being stopped
+	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/sandbox/%s", BaseURL, id), nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	events := getTimeline(t, id)
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.Equal(t, "stopped", last.Type)
+	assert.Equal(t, "reason: api", last.Detail)
+
+	resp, err = http.Get(BaseURL + "/sandbox/does-not-exist/timeline")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWasmTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+	// spans returns the ended spans by name once the server span called
+	// last has ended, which may be after the client got its response
+	spans := func(last string) map[string]sdktrace.ReadOnlySpan {
+		t.Helper()
+		byName := make(map[string]sdktrace.ReadOnlySpan)
+		require.Eventually(t, func() bool {
+			for _, s := range recorder.Ended() {
+				byName[s.Name()] = s
+			}
+			return byName[last] != nil
+		}, 5*time.Second, 10*time.Millisecond, last)
+		return byName
+	}
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	byName := spans("POST /v1/sandbox")
+	root := byName["POST /v1/sandbox"]
+	for _, name := range []string{"driver.create", "driver.start"} {
+		require.Contains(t, byName, name)
+		assert.Equal(t, root.SpanContext().SpanID(), byName[name].Parent().SpanID(), name)
+	}
+
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "hi"})
+	require.NoError(t, err)
+	byName = spans("POST /v1/sandbox/:id/exec")
+	rpc, run := byName["agent.exec"], byName["wasm.run"]
+	require.NotNil(t, rpc)
+	require.NotNil(t, run)
+	// The agent continues the trace it gets in the exec params
+	assert.Equal(t, rpc.SpanContext().TraceID(), run.SpanContext().TraceID())
+	assert.Equal(t, rpc.SpanContext().SpanID(), run.Parent().SpanID())
+	assert.Equal(t, byName["POST /v1/sandbox/:id/exec"].SpanContext().SpanID(), rpc.Parent().SpanID())
+	assert.Contains(t, byName, "agent.connect")
+
+	// Callers' traces are continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/sandbox/"+sb.ID, nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	get := spans("GET /v1/sandbox/:id")["GET /v1/sandbox/:id"]
+	assert.Equal(t, traceID, get.SpanContext().TraceID().String())
+	assert.True(t, get.Parent().IsRemote())
+}
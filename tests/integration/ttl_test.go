@@ -0,0 +1,104 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxTTL(t *testing.T) {
+	id := createSandbox(t, map[string]any{"template": "python:3.10-slim", "timeout": 60})
+	c := client.New("http://localhost:" + ServerPort)
+	ctx := context.Background()
+
+	sb, err := c.GetSandbox(ctx, id)
+	require.NoError(t, err)
+	require.NotNil(t, sb.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *sb.ExpiresAt, 5*time.Second)
+
+	expiresAt, err := c.ExtendTTL(ctx, id, 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, expiresAt.Sub(*sb.ExpiresAt).Round(time.Second))
+
+	resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/ttl", BaseURL, id), map[string]any{"ttl": 1000000})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// TestWasmSandboxTTL covers the TTL API without Docker.
+func TestWasmSandboxTTL(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": t.TempDir(),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	api.NewHandler(d, "", api.WithMaxTTL(time.Hour)).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	// Creating beyond the maximum is rejected
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: 2 * time.Hour})
+	var apiErr *client.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+
+	created, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute})
+	require.NoError(t, err)
+	sb, err := c.GetSandbox(ctx, created.ID)
+	require.NoError(t, err)
+	require.NotNil(t, sb.ExpiresAt)
+
+	// Extensions add up and stay within the maximum
+	expiresAt, err := c.ExtendTTL(ctx, sb.ID, 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, expiresAt.Sub(*sb.ExpiresAt).Round(time.Second))
+
+	_, err = c.ExtendTTL(ctx, sb.ID, time.Hour)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+	_, err = c.ExtendTTL(ctx, sb.ID, -time.Hour)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+
+	sb, err = c.GetSandbox(ctx, sb.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expiresAt.Unix(), sb.ExpiresAt.Unix())
+
+	// Shortening takes effect
+	_, err = c.SetTTL(ctx, sb.ID, time.Second)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := c.GetSandbox(ctx, sb.ID)
+		return err != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	_, err = c.SetTTL(ctx, sb.ID, time.Minute)
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "sandbox_not_found", apiErr.Code)
+
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	var changes int
+	for _, ev := range events {
+		if ev.Type == "ttl_changed" {
+			changes++
+		}
+	}
+	assert.Equal(t, 2, changes)
+}
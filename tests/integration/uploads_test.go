@@ -0,0 +1,173 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmChunkedUpload(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	read := func(p string) []byte {
+		r, err := c.DownloadFile(ctx, sb.ID, p)
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return data
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024+3)
+	require.NoError(t, c.UploadLargeFile(ctx, sb.ID, "/workspace/big.bin", bytes.NewReader(data), 100_000))
+	assert.Equal(t, data, read("/workspace/big.bin"))
+
+	t.Run("offsets", func(t *testing.T) {
+		u, err := c.CreateUpload(ctx, sb.ID, "/workspace/parts.txt", 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), u.Size)
+
+		u, err = c.AppendUpload(ctx, sb.ID, u.ID, 0, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), u.Offset)
+
+		// A chunk sent twice is refused
+		_, err = c.AppendUpload(ctx, sb.ID, u.ID, 0, bytes.NewReader([]byte("hello")))
+		assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+		// Not all bytes are there yet
+		err = c.CommitUpload(ctx, sb.ID, u.ID, "")
+		assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+		got, err := c.GetUpload(ctx, sb.ID, u.ID)
+		require.NoError(t, err)
+		_, err = c.AppendUpload(ctx, sb.ID, u.ID, got.Offset, bytes.NewReader([]byte("world")))
+		require.NoError(t, err)
+		require.NoError(t, c.CommitUpload(ctx, sb.ID, u.ID, ""))
+		assert.Equal(t, "helloworld", string(read("/workspace/parts.txt")))
+
+		_, err = c.GetUpload(ctx, sb.ID, u.ID)
+		assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		u, err := c.CreateUpload(ctx, sb.ID, "/workspace/sum.txt", -1)
+		require.NoError(t, err)
+		_, err = c.AppendUpload(ctx, sb.ID, u.ID, 0, bytes.NewReader([]byte("data")))
+		require.NoError(t, err)
+
+		var apiErr *client.APIError
+		err = c.CommitUpload(ctx, sb.ID, u.ID, "00")
+		require.True(t, errors.As(err, &apiErr), "got %v", err)
+		assert.Equal(t, "invalid_request", apiErr.Code)
+
+		require.NoError(t, c.AbortUpload(ctx, sb.ID, u.ID))
+		_, err = c.GetUpload(ctx, sb.ID, u.ID)
+		assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	})
+}
+
+func TestWasmMultiFileUpload(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+	read := func(p string) string {
+		r, err := c.DownloadFile(ctx, sb.ID, p)
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	// Files of the same name go to the paths given in part order
+	var files []client.UploadEntry
+	for i := range 20 {
+		files = append(files, client.UploadEntry{
+			Path:    fmt.Sprintf("/workspace/pkg%d/mod.py", i),
+			Content: strings.NewReader(fmt.Sprintf("x = %d\n", i)),
+		})
+	}
+	files = append(files, client.UploadEntry{Path: "notes.txt", Content: strings.NewReader("relative")})
+	paths, err := c.UploadFiles(ctx, sb.ID, files)
+	require.NoError(t, err)
+	require.Len(t, paths, 21)
+	assert.Equal(t, "/uploads/notes.txt", paths[20])
+	assert.Equal(t, "x = 7\n", read("/workspace/pkg7/mod.py"))
+	assert.Equal(t, "relative", read("/uploads/notes.txt"))
+
+	events, err := c.Timeline(ctx, sb.ID)
+	require.NoError(t, err)
+	uploads := 0
+	for _, ev := range events {
+		if ev.Type == "file_upload" {
+			uploads++
+		}
+	}
+	assert.Equal(t, 21, uploads)
+
+	// paths can map file names instead; a bad mapping is refused
+	post := func(paths string, names ...string) (*http.Response, string) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		w.WriteField("path", "/workspace")
+		if paths != "" {
+			w.WriteField("paths", paths)
+		}
+		for _, name := range names {
+			fw, _ := w.CreateFormFile("file", name)
+			fw.Write([]byte("content of " + name))
+		}
+		w.Close()
+		resp, err := http.Post(srv.URL+"/v1/sandbox/"+sb.ID+"/files", w.FormDataContentType(), &b)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	resp, body := post(`{"b.txt": "docs/b.txt"}`, "a.txt", "b.txt")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Contains(t, body, `"paths":["/workspace/a.txt","/workspace/docs/b.txt"]`)
+	assert.Equal(t, "content of b.txt", read("/workspace/docs/b.txt"))
+
+	for paths, want := range map[string]string{
+		`["one.txt"]`:        "2 files",
+		`{"c.txt": "x"}`:     "no file has",
+		`["same", "./same"]`: "two files are written to /workspace/same",
+		`"not a list"`:       "JSON array or object",
+	} {
+		resp, body := post(paths, "a.txt", "b.txt")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, paths)
+		assert.Contains(t, body, want, paths)
+	}
+}
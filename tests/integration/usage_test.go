@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmUsage(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	h := api.NewHandler(d, "", api.WithUsageInterval(50*time.Millisecond))
+	defer h.Drain(context.Background(), true)
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	start := time.Now()
+	ml, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute, Metadata: map[string]string{"team": "ml"}})
+	require.NoError(t, err)
+	web, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute, Metadata: map[string]string{"team": "web"}})
+	require.NoError(t, err)
+	defer c.DeleteSandbox(ctx, web.ID)
+
+	time.Sleep(150 * time.Millisecond)
+	mid := time.Now()
+	time.Sleep(150 * time.Millisecond)
+	require.NoError(t, c.DeleteSandbox(ctx, ml.ID))
+
+	// The running sandbox is counted up to its last sample
+	var report *client.UsageReport
+	require.Eventually(t, func() bool {
+		report, err = c.Usage(ctx, client.UsageQuery{GroupBy: "label:team"})
+		require.NoError(t, err)
+		return len(report.Groups) == 2 && report.Groups[1].WallSeconds >= 0.3
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "label:team", report.GroupBy)
+	assert.Equal(t, "ml", report.Groups[0].Group)
+	assert.Equal(t, "web", report.Groups[1].Group)
+	assert.Equal(t, 2, report.Total.Sandboxes)
+	for _, g := range report.Groups {
+		assert.Equal(t, 1, g.Sandboxes)
+		assert.InDelta(t, 512*g.WallSeconds/3600, g.MemoryMBHours, 1e-6, "memory is 512 MB for as long as the sandbox ran")
+	}
+	assert.GreaterOrEqual(t, report.Groups[0].WallSeconds, 0.3)
+	assert.Less(t, report.Groups[0].WallSeconds, time.Since(start).Seconds())
+
+	// Sandboxes created with the API key share its key
+	byKey, err := c.Usage(ctx, client.UsageQuery{})
+	require.NoError(t, err)
+	require.Len(t, byKey.Groups, 1)
+	assert.Equal(t, api.DefaultUsageKey, byKey.Groups[0].Group)
+	assert.Equal(t, 2, byKey.Groups[0].Sandboxes)
+
+	// Usage is prorated to the period
+	since, err := c.Usage(ctx, client.UsageQuery{From: mid, GroupBy: "sandbox"})
+	require.NoError(t, err)
+	require.Len(t, since.Groups, 2)
+	for _, g := range since.Groups {
+		if g.Group == ml.ID {
+			assert.Greater(t, g.WallSeconds, 0.1)
+			assert.Less(t, g.WallSeconds, report.Groups[0].WallSeconds)
+		}
+	}
+
+	none, err := c.Usage(ctx, client.UsageQuery{From: start.Add(-2 * time.Hour), To: start.Add(-time.Hour)})
+	require.NoError(t, err)
+	assert.Empty(t, none.Groups)
+	assert.Zero(t, none.Total.Sandboxes)
+
+	body, err := c.UsageCSV(ctx, client.UsageQuery{GroupBy: "label:team"})
+	require.NoError(t, err)
+	rows, err := csv.NewReader(body).ReadAll()
+	body.Close()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"label:team", "sandboxes", "cpu_seconds", "memory_mb_hours", "wall_seconds"}, rows[0])
+	assert.Equal(t, []string{"ml", "1"}, rows[1][:2])
+	assert.Equal(t, []string{"web", "1"}, rows[2][:2])
+
+	var apiErr *client.APIError
+	_, err = c.Usage(ctx, client.UsageQuery{GroupBy: "owner"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+
+	_, err = c.Usage(ctx, client.UsageQuery{From: start, To: start.Add(-time.Hour)})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request", apiErr.Code)
+}
+
+func TestWasmUsageOwners(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	defer d.Close()
+
+	e := echo.New()
+	h := api.NewHandler(d, "", api.WithUsageInterval(50*time.Millisecond), api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	}))
+	defer h.Drain(context.Background(), true)
+	h.RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	ops := client.New(srv.URL, client.WithAPIKey("ops-key"))
+	ctx := context.Background()
+
+	for _, c := range []*client.Client{alice, alice, bob} {
+		sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Timeout: time.Minute})
+		require.NoError(t, err)
+		defer c.DeleteSandbox(ctx, sb.ID)
+	}
+
+	// Callers see the usage of their own sandboxes, admins everyone's
+	for c, want := range map[*client.Client][]client.UsageGroup{
+		alice: {{Group: "key:alice", UsageTotals: client.UsageTotals{Sandboxes: 2}}},
+		bob:   {{Group: "key:bob", UsageTotals: client.UsageTotals{Sandboxes: 1}}},
+		ops: {
+			{Group: "key:alice", UsageTotals: client.UsageTotals{Sandboxes: 2}},
+			{Group: "key:bob", UsageTotals: client.UsageTotals{Sandboxes: 1}},
+		},
+	} {
+		report, err := c.Usage(ctx, client.UsageQuery{})
+		require.NoError(t, err)
+		require.Len(t, report.Groups, len(want))
+		for i, g := range report.Groups {
+			assert.Equal(t, want[i].Group, g.Group)
+			assert.Equal(t, want[i].Sandboxes, g.Sandboxes)
+		}
+	}
+	report, err := bob.Usage(ctx, client.UsageQuery{GroupBy: "sandbox"})
+	require.NoError(t, err)
+	assert.Len(t, report.Groups, 1)
+}
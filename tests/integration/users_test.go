@@ -0,0 +1,126 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// whereShell prints its working directory and $GREETING, and writes a file
+// by a relative path.
+const whereShell = `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	wd, _ := os.Getwd()
+	fmt.Println(wd)
+	fmt.Println(os.Getenv("GREETING"))
+	os.WriteFile("here.txt", []byte(wd), 0644)
+}
+`
+
+func TestWasmExecCwdAndEnv(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", whereShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Context:  []client.FileInjection{{Path: "src/.keep"}},
+	})
+	require.NoError(t, err)
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash"})
+	require.NoError(t, err)
+	assert.Equal(t, "/workspace\n\n", res.Stdout)
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{
+		Language: "bash",
+		Cwd:      "src",
+		Env:      map[string]string{"GREETING": "hi"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/workspace/src\nhi\n", res.Stdout)
+	r, err := c.DownloadFile(ctx, sb.ID, "/workspace/src/here.txt")
+	require.NoError(t, err)
+	r.Close()
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Cwd: "/nope"})
+	require.NoError(t, err)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, -1, *res.ExitCode)
+	assert.Equal(t, client.ErrorKindCwdNotFound, res.ErrorKind)
+	assert.Contains(t, res.ErrorMessage, "/nope does not exist")
+	assert.Empty(t, res.Stderr)
+
+	// The wasm driver has no users to switch to
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", User: "1000"})
+	require.NoError(t, err)
+	assert.Equal(t, client.ErrorKindPermissionDenied, res.ErrorKind)
+	assert.Contains(t, res.ErrorMessage, "another user")
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", User: "dev"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", User: "Not A User"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python-session", Code: "1", Cwd: "src"})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+}
+
+func TestSandboxUser(t *testing.T) {
+	id := createSandbox(t, map[string]any{
+		"template": "python:3.10-slim",
+		"timeout":  120,
+		"user":     "dev",
+	})
+
+	exec := func(payload map[string]any) execResult {
+		t.Helper()
+		resp := postJSON(t, fmt.Sprintf("%s/sandbox/%s/exec", BaseURL, id), payload)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var result execResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	// The user is created and owns the working directory and /output
+	result := execCode(t, id, "bash", "id -un; echo $HOME; touch /workspace/a /output/b && echo ok")
+	assert.Equal(t, []string{"dev", "/home/dev", "ok"}, strings.Fields(result.Stdout))
+
+	result = exec(map[string]any{"language": "bash", "code": "id -u; pwd; echo $X", "user": "root", "cwd": "/tmp", "env": map[string]string{"X": "y"}})
+	assert.Equal(t, []string{"0", "/tmp", "y"}, strings.Fields(result.Stdout))
+
+	// A uid needs no passwd entry
+	result = exec(map[string]any{"language": "bash", "code": "id -u; id -g", "user": "4242:4343"})
+	assert.Equal(t, []string{"4242", "4343"}, strings.Fields(result.Stdout))
+
+	result = exec(map[string]any{"language": "bash", "code": "true", "user": "nobody-here"})
+	require.NotNil(t, result.ExitCode)
+	assert.Equal(t, -1, *result.ExitCode)
+	assert.Contains(t, result.Stderr, "unknown user")
+}
@@ -0,0 +1,185 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// volumeShell runs "write <path> <text>" and "read <path>".
+const volumeShell = `package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	args := strings.SplitN(os.Args[len(os.Args)-1], " ", 3)
+	switch {
+	case args[0] == "write" && len(args) == 3:
+		if err := os.WriteFile(args[1], []byte(args[2]), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case args[0] == "read" && len(args) == 2:
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(string(data))
+	}
+}
+`
+
+func TestWasmVolumes(t *testing.T) {
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", volumeShell)
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": modules, "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	vol, err := c.CreateVolume(ctx, "cache")
+	require.NoError(t, err)
+	assert.Equal(t, "cache", vol.Name)
+	assert.Zero(t, vol.InUse)
+
+	_, err = c.CreateVolume(ctx, "cache")
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+	run := func(id, code string) *client.ExecResult {
+		res, err := c.Exec(ctx, id, client.ExecRequest{Language: "bash", Code: code})
+		require.NoError(t, err)
+		require.NotNil(t, res.ExitCode)
+		return res
+	}
+
+	a, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Volumes:  []client.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+	})
+	require.NoError(t, err)
+	res := run(a.ID, "write /cache/model.bin weights")
+	assert.Equal(t, 0, *res.ExitCode, res.Stderr)
+
+	// Another sandbox sees the write, through code and the files API, but
+	// cannot change a volume it mounts read-only
+	b, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Volumes:  []client.VolumeMount{{Name: "cache", MountPath: "/data", ReadOnly: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "weights", run(b.ID, "read /data/model.bin").Stdout)
+	r, err := c.DownloadFile(ctx, b.ID, "/data/model.bin")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "weights", string(data))
+	assert.NotEqual(t, 0, *run(b.ID, "write /data/model.bin other").ExitCode)
+	assert.Error(t, c.UploadFile(ctx, b.ID, "/data/model.bin", strings.NewReader("other")))
+
+	vol, err = c.GetVolume(ctx, "cache")
+	require.NoError(t, err)
+	assert.Equal(t, 2, vol.InUse)
+	assert.Positive(t, vol.SizeBytes)
+	err = c.DeleteVolume(ctx, "cache")
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+	// The contents outlive the sandbox that wrote them
+	require.NoError(t, c.DeleteSandbox(ctx, a.ID))
+	require.NoError(t, c.DeleteSandbox(ctx, b.ID))
+	a, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Volumes:  []client.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "weights", run(a.ID, "read /cache/model.bin").Stdout)
+	require.NoError(t, c.DeleteSandbox(ctx, a.ID))
+
+	require.NoError(t, c.DeleteVolume(ctx, "cache"))
+	_, err = c.GetVolume(ctx, "cache")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Volumes:  []client.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+	})
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Template: "python:3.10-slim",
+		Volumes:  []client.VolumeMount{{Name: "cache", MountPath: "/output"}},
+	})
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest), "got %v", err)
+
+	list, err := c.ListVolumes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestWasmVolumesOwners(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	ops := client.New(srv.URL, client.WithAPIKey("ops-key"))
+	ctx := context.Background()
+
+	vol, err := alice.CreateVolume(ctx, "data")
+	require.NoError(t, err)
+	assert.Equal(t, "key:alice", vol.Labels[api.OwnerLabel])
+
+	// Others neither see nor use it
+	vols, err := bob.ListVolumes(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, vols)
+	_, err = bob.GetVolume(ctx, "data")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	assert.ErrorIs(t, bob.DeleteVolume(ctx, "data"), client.ErrNotFound)
+	_, err = bob.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Volumes: []client.VolumeMount{{Name: "data", MountPath: "/data"}},
+	})
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	// The name is taken all the same
+	_, err = bob.CreateVolume(ctx, "data")
+	assert.ErrorIs(t, err, client.ErrConflict)
+
+	// Its owner and admins do
+	sb, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{
+		Volumes: []client.VolumeMount{{Name: "data", MountPath: "/data"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, alice.DeleteSandbox(ctx, sb.ID))
+	vols, err = ops.ListVolumes(ctx)
+	require.NoError(t, err)
+	require.Len(t, vols, 1)
+	assert.Equal(t, "data", vols[0].Name)
+	require.NoError(t, ops.DeleteVolume(ctx, "data"))
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/akshayaggarwal99/boxed/internal/driver/wasm"
+)
+
+// fakeShell is compiled to WASI and installed as bash.wasm: it echoes the
+// code passed with -c and copies it into /output/last.txt. "sleep <duration>"
+// waits first. Without -c it echoes its input lines, like a REPL.
+const fakeShell = `package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fmt.Println(scanner.Text())
+		}
+		return
+	}
+	code := os.Args[len(os.Args)-1]
+	if code == "fail" {
+		fmt.Fprintln(os.Stderr, "failing")
+		os.Exit(3)
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(code, "sleep ")); err == nil {
+		time.Sleep(d)
+	}
+	fmt.Println(code)
+	os.WriteFile("/output/last.txt", []byte(code), 0644)
+}
+`
+
+// buildWasmModules compiles fakeShell for wasip1 into a fresh modules dir.
+func buildWasmModules(t *testing.T) string {
+	t.Helper()
+	modules := t.TempDir()
+	buildWasmModule(t, modules, "bash", fakeShell)
+	return modules
+}
+
+// buildWasmModule compiles the Go program src for wasip1 as
+// <modules>/<name>.wasm.
+func buildWasmModule(t *testing.T, modules, name, src string) {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+name+"\n"), 0644))
+
+	cmd := exec.Command("go", "build", "-o", filepath.Join(modules, name+".wasm"), ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("cannot build WASI module: %v\n%s", err, out)
+	}
+}
+
+func TestWasmDriver(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{
+		"modules_dir": buildWasmModules(t),
+		"root_dir":    t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer d.Close()
+	require.NoError(t, d.Healthy(context.Background()))
+
+	e := echo.New()
+	api.NewHandler(d, "").RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := client.New(srv.URL)
+	sb, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim"})
+	require.NoError(t, err)
+
+	res, err := c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "hello wasm"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello wasm\n", res.Stdout)
+	require.NotNil(t, res.ExitCode)
+	assert.Equal(t, 0, *res.ExitCode)
+	require.Len(t, res.Artifacts, 1)
+	assert.Equal(t, "last.txt", res.Artifacts[0].Path)
+	assert.NotEmpty(t, res.Artifacts[0].DataBase64)
+
+	// URL delivery returns a download link instead of the contents
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "by url",
+		Artifacts: &client.ArtifactOptions{Delivery: client.DeliveryURL}})
+	require.NoError(t, err)
+	require.Len(t, res.Artifacts, 1)
+	assert.Empty(t, res.Artifacts[0].DataBase64)
+	assert.Equal(t, int64(len("by url")), res.Artifacts[0].Size)
+	assert.Contains(t, res.Artifacts[0].URL, "/files/content?path=%2Foutput%2Flast.txt")
+
+	// Filters: other watch paths and MIME types exclude the file
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "elsewhere",
+		Artifacts: &client.ArtifactOptions{WatchPaths: []string{"/tmp"}}})
+	require.NoError(t, err)
+	assert.Empty(t, res.Artifacts)
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "images only",
+		Artifacts: &client.ArtifactOptions{MIMETypes: []string{"image/*"}}})
+	require.NoError(t, err)
+	assert.Empty(t, res.Artifacts)
+
+	_, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "x",
+		Artifacts: &client.ArtifactOptions{WatchPaths: []string{"relative"}}})
+	assert.ErrorIs(t, err, client.ErrInvalidRequest)
+
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "bash", Code: "fail"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, *res.ExitCode)
+	assert.Contains(t, res.Stderr, "failing")
+	assert.Empty(t, res.ErrorKind)
+
+	// No interpreter for python3 in the modules dir
+	res, err = c.Exec(ctx, sb.ID, client.ExecRequest{Language: "python", Code: "print(1)"})
+	require.NoError(t, err)
+	assert.Equal(t, -1, *res.ExitCode)
+	assert.Equal(t, client.ErrorKindCommandNotFound, res.ErrorKind)
+	assert.Contains(t, res.ErrorMessage, "no WASI module")
+	assert.Empty(t, res.Stderr)
+	execs, err := c.ListExecs(ctx, sb.ID)
+	require.NoError(t, err)
+	last := execs[len(execs)-1]
+	assert.Equal(t, client.ErrorKindCommandNotFound, last.ErrorKind)
+	assert.Contains(t, last.Error, "no WASI module")
+
+	// Filesystem API against the virtual root
+	require.NoError(t, c.UploadFile(ctx, sb.ID, "/workspace/data.txt", strings.NewReader("payload")))
+	files, err := c.ListFiles(ctx, sb.ID, "/workspace")
+	require.NoError(t, err)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Path)
+	}
+	assert.Contains(t, names, "workspace/data.txt")
+
+	body, err := c.DownloadFile(ctx, sb.ID, "/output/last.txt")
+	require.NoError(t, err)
+	defer body.Close()
+
+	require.NoError(t, c.DeleteSandbox(ctx, sb.ID))
+	_, err = c.GetSandbox(ctx, sb.ID)
+	assert.ErrorIs(t, err, client.ErrSandboxNotFound)
+}
@@ -0,0 +1,122 @@
+package integration
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/akshayaggarwal99/boxed/internal/api"
+	"github.com/akshayaggarwal99/boxed/internal/driver"
+	"github.com/akshayaggarwal99/boxed/pkg/client"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmWorkspaces(t *testing.T) {
+	_, c := newWasmServer(t)
+	ctx := context.Background()
+
+	ws, err := c.CreateWorkspace(ctx, client.WorkspaceRequest{
+		Name: "repo",
+		Files: []client.FileInjection{{
+			Path:          "src/main.py",
+			ContentBase64: base64.StdEncoding.EncodeToString([]byte("print('base')")),
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "repo", ws.Name)
+	assert.Zero(t, ws.InUse)
+
+	_, err = c.CreateWorkspace(ctx, client.WorkspaceRequest{Name: "repo"})
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+	read := func(id string) string {
+		r, err := c.DownloadFile(ctx, id, "/workspace/src/main.py")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	a, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Workspace: "repo"})
+	require.NoError(t, err)
+	b, err := c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Workspace: "repo"})
+	require.NoError(t, err)
+	assert.Equal(t, "print('base')", read(a.ID))
+
+	// Changes stay in the sandbox that made them
+	require.NoError(t, c.UploadFile(ctx, a.ID, "/workspace/src/main.py", strings.NewReader("print('changed')")))
+	assert.Equal(t, "print('changed')", read(a.ID))
+	assert.Equal(t, "print('base')", read(b.ID))
+
+	ws, err = c.GetWorkspace(ctx, "repo")
+	require.NoError(t, err)
+	assert.Equal(t, 2, ws.InUse)
+
+	err = c.DeleteWorkspace(ctx, "repo")
+	assert.True(t, errors.Is(err, client.ErrConflict), "got %v", err)
+
+	require.NoError(t, c.DeleteSandbox(ctx, a.ID))
+	require.NoError(t, c.DeleteSandbox(ctx, b.ID))
+	require.NoError(t, c.DeleteWorkspace(ctx, "repo"))
+
+	_, err = c.GetWorkspace(ctx, "repo")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+	_, err = c.CreateSandbox(ctx, client.CreateSandboxRequest{Template: "python:3.10-slim", Workspace: "repo"})
+	assert.True(t, errors.Is(err, client.ErrNotFound), "got %v", err)
+
+	list, err := c.ListWorkspaces(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestWasmWorkspacesOwners(t *testing.T) {
+	d, err := driver.NewDriver("wasm", map[string]any{"modules_dir": buildWasmModules(t), "root_dir": t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	e := echo.New()
+	api.NewHandler(d, "", api.WithAPIKeys([]api.APIKey{
+		{Name: "alice", Key: "alice-key"},
+		{Name: "bob", Key: "bob-key"},
+		{Name: "ops", Key: "ops-key", Admin: true},
+	})).RegisterRoutes(e)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	alice := client.New(srv.URL, client.WithAPIKey("alice-key"))
+	bob := client.New(srv.URL, client.WithAPIKey("bob-key"))
+	ops := client.New(srv.URL, client.WithAPIKey("ops-key"))
+	ctx := context.Background()
+
+	ws, err := alice.CreateWorkspace(ctx, client.WorkspaceRequest{Name: "repo"})
+	require.NoError(t, err)
+	assert.Equal(t, "key:alice", ws.Labels[api.OwnerLabel])
+
+	// Others neither see nor use it
+	list, err := bob.ListWorkspaces(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	_, err = bob.GetWorkspace(ctx, "repo")
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	_, err = bob.ReplaceWorkspace(ctx, client.WorkspaceRequest{Name: "repo"})
+	assert.ErrorIs(t, err, client.ErrNotFound)
+	assert.ErrorIs(t, bob.DeleteWorkspace(ctx, "repo"), client.ErrNotFound)
+	_, err = bob.CreateSandbox(ctx, client.CreateSandboxRequest{Workspace: "repo"})
+	assert.ErrorIs(t, err, client.ErrNotFound)
+
+	// Its owner and admins do; replacing it keeps the owner
+	sb, err := alice.CreateSandbox(ctx, client.CreateSandboxRequest{Workspace: "repo"})
+	require.NoError(t, err)
+	require.NoError(t, alice.DeleteSandbox(ctx, sb.ID))
+	ws, err = ops.ReplaceWorkspace(ctx, client.WorkspaceRequest{Name: "repo"})
+	require.NoError(t, err)
+	assert.Equal(t, "key:alice", ws.Labels[api.OwnerLabel])
+	_, err = alice.GetWorkspace(ctx, "repo")
+	require.NoError(t, err)
+	require.NoError(t, ops.DeleteWorkspace(ctx, "repo"))
+}